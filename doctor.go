@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/doodlescheduling/flux-build/internal/cachemgr"
+	flag "github.com/spf13/pflag"
+)
+
+type doctorConfig struct {
+	CacheDir       string
+	Verify         bool
+	Repair         bool
+	PruneOlderThan time.Duration
+	PruneLockfile  string
+	DryRun         bool
+}
+
+// doctor inspects and maintains the filesystem chart cache, it is invoked as flux-build doctor.
+func doctor(args []string) error {
+	cfg := doctorConfig{}
+	flags := flag.NewFlagSet("doctor", flag.ExitOnError)
+	flags.StringVar(&cfg.CacheDir, "cache-dir", getDefaultCacheDir(), "Path to helm chart cache")
+	flags.BoolVar(&cfg.Verify, "verify", false, "Verify the digest of all entries and exit > 0 if any entry is corrupted or incomplete")
+	flags.BoolVar(&cfg.Repair, "repair", false, "Remove corrupted and incomplete entries and index entries without metadata")
+	flags.DurationVar(&cfg.PruneOlderThan, "prune-older-than", 0, "Remove entries cached longer ago than this duration")
+	flags.StringVar(&cfg.PruneLockfile, "prune-lockfile", "", "Remove entries which are not referenced by the charts of this lockfile")
+	flags.BoolVar(&cfg.DryRun, "dry-run", false, "Only list the entries which would be pruned")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if os.Getenv("CACHE_DIR") != "" && !flags.Changed("cache-dir") {
+		cfg.CacheDir = os.Getenv("CACHE_DIR")
+	}
+
+	if _, err := os.Stat(cfg.CacheDir); err != nil {
+		return fmt.Errorf("cache dir %s not found: %w", cfg.CacheDir, err)
+	}
+
+	switch {
+	case cfg.PruneOlderThan > 0 || cfg.PruneLockfile != "":
+		opts := cachemgr.PruneOptions{
+			OlderThan: cfg.PruneOlderThan,
+			DryRun:    cfg.DryRun,
+		}
+
+		if cfg.PruneLockfile != "" {
+			lockfile, err := cachemgr.LoadLockfile(cfg.PruneLockfile)
+			if err != nil {
+				return err
+			}
+
+			// A lockfile without charts must prune everything rather than disable the filter
+			opts.Lockfile = append([]cachemgr.LockEntry{}, lockfile...)
+		}
+
+		entries, err := cachemgr.Prune(cfg.CacheDir, opts)
+		if err != nil {
+			return err
+		}
+
+		return printEntries(os.Stdout, entries)
+	case cfg.Repair:
+		entries, err := cachemgr.Repair(cfg.CacheDir)
+		if err != nil {
+			return err
+		}
+
+		return printEntries(os.Stdout, entries)
+	case cfg.Verify:
+		entries, err := cachemgr.Verify(cfg.CacheDir)
+		if err != nil {
+			return err
+		}
+
+		if err := printEntries(os.Stdout, entries); err != nil {
+			return err
+		}
+
+		var failed int
+		for _, entry := range entries {
+			if entry.Status == cachemgr.EntryCorrupted || entry.Status == cachemgr.EntryIncomplete {
+				failed++
+			}
+		}
+
+		if failed > 0 {
+			return fmt.Errorf("%d cache entries are corrupted or incomplete, run with --repair to remove them", failed)
+		}
+
+		return nil
+	}
+
+	entries, err := cachemgr.ListEntries(cfg.CacheDir)
+	if err != nil {
+		return err
+	}
+
+	return printEntries(os.Stdout, entries)
+}
+
+func printEntries(w io.Writer, entries []cachemgr.Entry) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "REPOSITORY\tCHART\tVERSION\tDIGEST\tSIZE\tAGE\tLAST USED\tSTATUS")
+
+	now := time.Now()
+	for _, entry := range entries {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%s\t%s\t%s\n",
+			orNone(entry.Repository),
+			orNone(entry.Chart),
+			orNone(entry.Version),
+			orNone(entry.Digest),
+			entry.Size,
+			now.Sub(entry.Created).Round(time.Second),
+			now.Sub(entry.LastUsed).Round(time.Second),
+			orNone(string(entry.Status)),
+		)
+	}
+
+	return tw.Flush()
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
@@ -2,49 +2,230 @@ package action
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
 	"io"
+	"net/http"
+	"net/url"
 	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/alitto/pond"
 	"github.com/doodlescheduling/flux-build/internal/build"
 	"github.com/doodlescheduling/flux-build/internal/cachemgr"
+	"github.com/doodlescheduling/flux-build/internal/helm/postrenderer"
+	"github.com/doodlescheduling/flux-build/internal/logbuffer"
+	"github.com/doodlescheduling/flux-build/internal/oci"
+	"github.com/doodlescheduling/flux-build/internal/transport"
 	helmv1 "github.com/fluxcd/helm-controller/api/v2beta1"
 	"github.com/go-logr/logr"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/release"
+	"k8s.io/apimachinery/pkg/labels"
 	"sigs.k8s.io/kustomize/api/resmap"
+	"sigs.k8s.io/kustomize/api/resource"
 )
 
 type Action struct {
-	Output           io.Writer
-	AllowFailure     bool
-	FailFast         bool
-	Workers          int
-	Cache            *cachemgr.Cache
-	Paths            []string
-	APIVersions      []string
-	IncludeHelmHooks bool
-	KubeVersion      *chartutil.KubeVersion
-	Logger           logr.Logger
+	Output             Sink
+	AllowFailure       bool
+	FailFast           bool
+	Workers            int
+	Cache              *cachemgr.Cache
+	Paths              []string
+	APIVersions        []string
+	IncludeHelmHooks   bool
+	HelmHookTypes      []release.HookEvent
+	HelmAction         build.ReleaseAction
+	ChartVersions      map[string]string
+	TargetPathTypes    bool
+	Extensions         bool
+	KubeVersion        *chartutil.KubeVersion
+	Logger             logr.Logger
+	InsecureRegistries []string
+	SkipTLSVerify      []string
+	ClusterScopedKinds []string
+	APIResources       []postrenderer.APIResource
+	ControllerCompat   *build.ControllerCompat
+	KeepLists          bool
+	NoDefaultKeychain  bool
+	CommonLabels       map[string]string
+	CommonAnnotations  map[string]string
+	LogsOnFailureOnly  bool
+	RekorURL           string
+	FixNameReferences  bool
+	DocumentLimits     build.DocumentLimits
+	Proxy              *url.URL
+	IdleConnsPerHost   int
+	DisableKeepAlives  bool
+	RepositoryTimeout  time.Duration
+	RepositoryTimeouts map[string]time.Duration
+	RepositoryMirrors  map[string][]string
+	RetryMax           int
+	RetryBackoff       time.Duration
+	// Clusters is a glob pattern of cluster directories (for instance clusters/*), each one is built
+	// with the Flux Kustomizations reachable from it into its own file within OutputDir
+	Clusters string
+	// OutputDir is the directory of the cluster outputs
+	OutputDir string
+	// RepositoryRoot is the directory the spec.path of Flux Kustomizations and packaged charts of the
+	// flux-system GitRepository are relative to
+	RepositoryRoot string
+	// ConflictRules enables the server-side apply conflict analysis of the output if set
+	ConflictRules []build.ConflictRule
+	// ObjectSizeLimits are checked for all resources of the output, offenders are logged
+	ObjectSizeLimits build.ObjectSizeLimits
+	// StrictObjectSize fails the build if any resource exceeds the ObjectSizeLimits
+	StrictObjectSize bool
+	// Report is the path the build report is written to, no report is written if empty
+	Report string
+	// AuditSubstitutions records every substituted variable into the report
+	AuditSubstitutions bool
+	// SkipSuspended skips suspended HelmReleases and builds charts of suspended HelmRepositories from the cache only
+	SkipSuspended bool
+	// ReleaseSelector restricts the build to the HelmReleases whose labels match, all HelmReleases are built if nil
+	ReleaseSelector labels.Selector
+	// ExecPostRenderers are local commands the manifests of every helm release are piped through
+	ExecPostRenderers []postrenderer.Exec
+	// KeepTempDirs keeps the directories the manifests of helm releases are rendered into
+	KeepTempDirs bool
+	// SchemaWarnings logs values which violate the schema of the chart instead of failing the release
+	SchemaWarnings bool
+	// UnusedValuesAllow are the paths of values which are never reported as not consumed by the chart
+	UnusedValuesAllow []string
+	// DumpValuesDir is the directory the merged values of every helm release are written to, no values are written if empty.
+	// In cluster mode the values of each cluster are written to a subdirectory named after the cluster
+	DumpValuesDir string
+	// NotesDir is the directory the rendered notes of every helm release are written to, no notes are written if empty.
+	// In cluster mode the notes of each cluster are written to a subdirectory named after the cluster
+	NotesDir string
+	// ShowSecrets writes values from secrets to DumpValuesDir instead of redacting them
+	ShowSecrets bool
+	// ValueConflicts lists the values keys of every helm release which a later source overwrites in the report
+	ValueConflicts bool
+	// CheckDeterminism renders every helm release twice and fails releases whose renders differ
+	CheckDeterminism bool
+	// FailOnEmpty fails helm releases whose chart renders no resources
+	FailOnEmpty bool
+	// WarningsAsErrors fails helm releases for which helm warns while rendering
+	WarningsAsErrors bool
+	// Timings records the durations of the operations building every helm release and writes them as a table sorted
+	// by the slowest release to stderr once the build finished
+	Timings bool
+	// StrictEnv fails helm releases referencing unset environment variables without a default
+	StrictEnv bool
+	// NoEnvsubst disables the environment substitution of helm releases
+	NoEnvsubst bool
+	// EnvDefaults are substituted for environment variables which are not set, before inline defaults apply
+	EnvDefaults map[string]string
+	// EnvAllowlist restricts the environment substitution to the variables it allows
+	EnvAllowlist build.EnvAllowlist
+	// SourcePolicy restricts the chart sources of helm releases, the decision of every release is reported
+	SourcePolicy *build.SourcePolicy
+	// OnDuplicate decides how resources declared with different content by more than one path are handled
+	OnDuplicate build.DuplicatePolicy
+	// UnsupportedVerify decides how charts whose spec.verify can't be checked are handled
+	UnsupportedVerify build.UnsupportedVerifyPolicy
+	// CreateNamespaces adds a Namespace to the output for every HelmRelease with spec.install.createNamespace
+	// unless the namespace is declared by any resource of the build
+	CreateNamespaces bool
+	// PartOfLabel is set to the name of the owning Flux Kustomization on every resource of the output which doesn't
+	// define it, no label is set if empty. The resources rendered by a HelmRelease are owned by its owner
+	PartOfLabel string
+	// ValuesOverlays are merged on top of the values of the HelmReleases matched by their selector
+	ValuesOverlays []build.ValuesOverlay
+	// ValuesOverrides set values of single HelmReleases, every targeted HelmRelease must be part of the build
+	ValuesOverrides []build.ValuesOverride
+	// TLSPolicy restricts the TLS connections to repositories, registries and OCI artifacts
+	TLSPolicy *build.TLSPolicy
+	// Interrupt is closed to stop the build cooperatively, no further helm releases or clusters are scheduled and
+	// the in-flight helm releases are canceled after the DrainTimeout. The output of completed helm releases is
+	// flushed and the result is Partial
+	Interrupt <-chan struct{}
+	// DrainTimeout is how long in-flight helm releases may finish once the build was interrupted
+	DrainTimeout time.Duration
+
+	// Sources are built in addition to the Paths, for instance objects which are already parsed by the caller of a
+	// library. Their resources are indexed and their HelmReleases built like the ones of a path
+	Sources []build.Source
+
+	// stdin holds the resources read from stdin if any of the Paths is StdinPath, stdin is read once for all clusters
+	stdin []byte
+}
+
+// StdinPath is the path which reads the resources from stdin as a multi-document YAML stream.
+const StdinPath = "-"
+
+func isStdin(path string) bool {
+	return path == StdinPath || path == "/dev/stdin"
 }
 
+// Run builds the Paths or Clusters and exits with the ExitCode of the result.
 func (a *Action) Run(ctx context.Context) error {
+	result := a.Build(ctx)
+	if a.Timings {
+		if err := writeTimings(os.Stderr, result.Releases); err != nil {
+			a.Logger.Error(err, "failed to write timings")
+		}
+	}
+
+	if code := result.ExitCode(a.AllowFailure); code != 0 {
+		os.Exit(code)
+	}
+
+	return nil
+}
+
+// Build builds the Paths or Clusters into the output and returns the statuses of the HelmReleases.
+func (a *Action) Build(ctx context.Context) Result {
+	if slices.ContainsFunc(a.Paths, isStdin) {
+		b, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return Result{Err: fmt.Errorf("failed to read stdin: %w", err)}
+		}
+
+		a.stdin = b
+	}
+
+	ctx = build.WithDocumentLimits(ctx, a.DocumentLimits)
+
+	if a.Clusters != "" {
+		return a.buildClusters(ctx)
+	}
+
+	return a.build(ctx)
+}
+
+// build builds the paths into the output, the result holds the last error which occurred.
+// The output is flushed once the build finished or was interrupted, it is aborted if ctx was canceled otherwise.
+func (a *Action) build(ctx context.Context) Result {
+	parent := ctx
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
+	a.drain(ctx, cancel)
+
+	var substitutions *build.SubstitutionRecorder
+	if a.AuditSubstitutions {
+		substitutions = build.NewSubstitutionRecorder()
+		ctx = build.WithSubstitutionRecorder(ctx, substitutions)
+	}
 
 	errs := make(chan error)
+	errsDone := make(chan struct{})
 	var lastErr error
 	helmResultPool := pond.New(1, 1, pond.Context(ctx))
-	kustomizePool := pond.New(len(a.Paths), len(a.Paths), pond.Context(ctx))
+	kustomizePool := pond.New(max(len(a.Paths)+len(a.Sources), 1), max(len(a.Paths)+len(a.Sources), 1), pond.Context(ctx))
 	helmPool := pond.New(a.Workers, a.Workers, pond.Context(ctx))
 	resourcePool := pond.New(1, 1, pond.Context(ctx))
 
-	defer func() {
-		if lastErr != nil && !a.AllowFailure {
-			os.Exit(1)
-		}
-	}()
-
 	go func() {
+		defer close(errsDone)
 		for err := range errs {
 			if err == nil {
 				continue
@@ -58,17 +239,126 @@ func (a *Action) Run(ctx context.Context) error {
 		}
 	}()
 
-	resources := make(chan resmap.ResMap, len(a.Paths))
-	manifests := make(chan resmap.ResMap, a.Workers)
+	paths, cleanup := a.pullArtifacts(ctx, errs)
+	defer cleanup()
+
+	sources := a.sources(paths)
+	resources := make(chan pathResources, len(sources))
+	manifests := make(chan pathResources, a.Workers)
 	helmBuilder := build.NewHelmBuilder(a.Logger, build.HelmOpts{
-		APIVersions:      a.APIVersions,
-		KubeVersion:      a.KubeVersion,
-		IncludeHelmHooks: a.IncludeHelmHooks,
-		Cache:            a.Cache,
+		APIVersions:           a.APIVersions,
+		KubeVersion:           a.KubeVersion,
+		IncludeHelmHooks:      a.IncludeHelmHooks,
+		HelmHookTypes:         a.HelmHookTypes,
+		Action:                a.HelmAction,
+		ChartVersionOverrides: a.ChartVersions,
+		TargetPathTypes:       a.TargetPathTypes,
+		Extensions:            a.Extensions,
+		UnsupportedVerify:     a.UnsupportedVerify,
+		Cache:                 a.Cache,
+		InsecureRegistries:    a.InsecureRegistries,
+		SkipTLSVerify:         a.SkipTLSVerify,
+		ClusterScopedKinds:    a.ClusterScopedKinds,
+		APIResources:          a.APIResources,
+		ControllerCompat:      a.ControllerCompat,
+		KeepLists:             a.KeepLists,
+		NoDefaultKeychain:     a.NoDefaultKeychain,
+		CommonLabels:          a.CommonLabels,
+		CommonAnnotations:     a.CommonAnnotations,
+		RekorURL:              a.RekorURL,
+		DocumentLimits:        &a.DocumentLimits,
+		Proxy:                 a.Proxy,
+		MaxIdleConnsPerHost:   &a.IdleConnsPerHost,
+		DisableKeepAlives:     a.DisableKeepAlives,
+		RepositoryTimeout:     &a.RepositoryTimeout,
+		RepositoryTimeouts:    a.RepositoryTimeouts,
+		RepositoryMirrors:     a.RepositoryMirrors,
+		RetryMax:              &a.RetryMax,
+		RetryBackoff:          &a.RetryBackoff,
+		SkipSuspended:         a.SkipSuspended,
+		ExecPostRenderers:     a.ExecPostRenderers,
+		KeepTempDirs:          a.KeepTempDirs,
+		SchemaWarnings:        a.SchemaWarnings,
+		UnusedValuesAllow:     a.UnusedValuesAllow,
+		DumpValuesDir:         a.DumpValuesDir,
+		NotesDir:              a.NotesDir,
+		ShowSecrets:           a.ShowSecrets,
+		ReportValueConflicts:  a.ValueConflicts,
+		CheckDeterminism:      a.CheckDeterminism,
+		FailOnEmpty:           a.FailOnEmpty,
+		WarningsAsErrors:      a.WarningsAsErrors,
+		StrictEnv:             a.StrictEnv,
+		NoEnvsubst:            a.NoEnvsubst,
+		EnvDefaults:           a.EnvDefaults,
+		EnvAllowlist:          a.EnvAllowlist,
+		RepositoryRoot:        a.RepositoryRoot,
+		SourcePolicy:          a.SourcePolicy,
+		ValuesOverlays:        a.ValuesOverlays,
+		ValuesOverrides:       a.ValuesOverrides,
+		TLSPolicy:             a.TLSPolicy,
 	})
+	defer func() {
+		if err := helmBuilder.Close(); err != nil {
+			a.Logger.Error(err, "failed to remove gitrepository checkouts")
+		}
+	}()
+
+	// Generated resources are only known once all kustomize paths are built,
+	// the kustomize results are held back until then if name references are resolved.
+	var nameRefs *build.NameReferences
+	var kustomizeResults []pathResources
+	var kustomizeResultsMu sync.Mutex
+
+	var conflicts *build.ConflictAnalyzer
+	if len(a.ConflictRules) > 0 {
+		conflicts = build.NewConflictAnalyzer(a.ConflictRules)
+	}
+
+	var namespaces *build.Namespaces
+	if a.CreateNamespaces {
+		namespaces = build.NewNamespaces()
+	}
+
+	// The owners of the paths are only known once all kustomize paths are built, the kustomize results are held
+	// back until then if resources are labeled with their owner
+	var ownership *build.Ownership
+	var partOf *build.PartOfLabeler
+	if a.PartOfLabel != "" {
+		partOf = build.NewPartOfLabeler(a.PartOfLabel)
+	}
 
 	helmResultPool.Submit(func() {
-		for index := range manifests {
+		for m := range manifests {
+			index := m.resources
+			if nameRefs != nil {
+				resolved, fixups, err := nameRefs.Resolve(index)
+				if err != nil {
+					a.Logger.Error(err, "failed to resolve name references")
+					errs <- err
+					continue
+				}
+
+				a.logFixups(a.Logger, fixups)
+				index = resolved
+			}
+
+			if partOf != nil {
+				owner, _ := ownership.Owner(m.path)
+				if err := partOf.Label(owner, index); err != nil {
+					a.Logger.Error(err, "failed to label resources with their owner")
+					errs <- err
+					continue
+				}
+			}
+
+			if conflicts != nil {
+				conflicts.Add(index)
+			}
+
+			if namespaces != nil {
+				namespaces.Observe(index)
+			}
+
 			y, err := index.AsYaml()
 			if err != nil {
 				a.Logger.Error(err, "failed to encode as yaml")
@@ -85,25 +375,43 @@ func (a *Action) Run(ctx context.Context) error {
 		}
 	})
 
-	for _, path := range a.Paths {
-		p := path
+	for i, source := range sources {
+		if a.interrupted() {
+			break
+		}
+
+		i, source, p := i, source, source.Name()
 		a.Logger.Info("build kustomize path", "path", p)
 
 		kustomizePool.Submit(func() {
-			if index, err := build.Kustomize(ctx, p); err != nil {
+			if index, err := source.Load(logr.NewContext(ctx, a.Logger.WithValues("path", p))); err != nil {
 				a.Logger.Error(err, "failed build kustomization", "path", p)
 				errs <- err
 			} else {
-				manifests <- index
-				resources <- index
+				if err := a.checkObjectSizes(a.Logger.WithValues("path", p), index); err != nil {
+					a.Logger.Error(err, "failed build kustomization", "path", p)
+					errs <- err
+				}
+
+				built := pathResources{order: i, path: p, resources: index}
+				if a.FixNameReferences || partOf != nil {
+					kustomizeResultsMu.Lock()
+					kustomizeResults = append(kustomizeResults, built)
+					kustomizeResultsMu.Unlock()
+				} else {
+					manifests <- built
+				}
+
+				resources <- built
 			}
 		})
 	}
 
 	index := make(build.ResourceIndex)
+	loader := build.NewIndexLoader(index)
 	resourcePool.Submit(func() {
 		for build := range resources {
-			if err := index.Push(build.Resources()); err != nil {
+			if err := loader.Push(build.order, build.path, build.resources.Resources()); err != nil {
 				errs <- err
 				continue
 			}
@@ -113,34 +421,565 @@ func (a *Action) Run(ctx context.Context) error {
 	kustomizePool.StopAndWait()
 	close(resources)
 	resourcePool.StopAndWait()
+	if err := a.checkDuplicates(loader.Duplicates()); err != nil {
+		a.Logger.Error(err, "failed to index resources")
+		errs <- err
+	}
+
+	helmBuilder.AddCRDs(index)
+
+	if a.FixNameReferences {
+		var err error
+		if nameRefs, err = a.resolveNameReferences(index); err != nil {
+			a.Logger.Error(err, "failed to resolve name references")
+			errs <- err
+		}
+	}
+
+	if partOf != nil {
+		ownership = build.NewOwnership(a.RepositoryRoot, index)
+	}
 
+	for _, result := range kustomizeResults {
+		manifests <- result
+	}
+
+	var skipped []Skipped
+	var pending []*resource.Resource
+	releases := newReleaseTracker()
 	for _, r := range index {
 		res := r
 		if r.GetKind() != helmv1.HelmReleaseKind {
 			continue
 		}
 
-		if ctx.Err() != nil {
+		name := build.ResourceName(res.GetKind(), res.GetNamespace(), res.GetName())
+		if a.ReleaseSelector != nil && !a.ReleaseSelector.Matches(labels.Set(res.GetLabels())) {
+			a.Logger.V(1).Info("skip helm release not matching the release selector", "namespace", res.GetNamespace(), "name", res.GetName())
+			skipped = append(skipped, Skipped{Resource: name, Reason: "selector"})
+			releases.done(name, ReleaseSkippedSelector, nil)
+			continue
+		}
+
+		if a.SkipSuspended && build.Suspended(res) {
+			a.Logger.Info("skip suspended helm release", "namespace", res.GetNamespace(), "name", res.GetName())
+			skipped = append(skipped, Skipped{Resource: name, Reason: "suspended"})
+			releases.done(name, ReleaseSkippedSuspended, nil)
+			continue
+		}
+
+		releases.pending(name, a.overlays(res), a.overrides(res))
+		pending = append(pending, res)
+	}
+
+	// HelmReleases are built in passes as their valuesFrom may reference values rendered by other HelmReleases
+	passes := build.NewReleasePasses(index, pending)
+	for pass := 1; ; pass++ {
+		batch, blocked, err := passes.Next()
+		if err != nil {
+			a.Logger.Error(err, "failed to order helm releases")
+			errs <- err
 			break
 		}
 
-		helmPool.Submit(func() {
-			a.Logger.Info("build helm release", "namespace", res.GetNamespace(), "name", res.GetName())
-			index, err := helmBuilder.Build(ctx, res, index)
-			if err != nil {
-				a.Logger.Error(err, "failed build helmrelease", "namespace", res.GetNamespace(), "name", res.GetName())
-				errs <- err
-				return
+		if len(batch) == 0 {
+			break
+		}
+
+		if blocked {
+			var names []string
+			for _, res := range batch {
+				names = append(names, build.ResourceName(res.GetKind(), res.GetNamespace(), res.GetName()))
 			}
 
-			manifests <- index
-		})
+			a.Logger.Info("build helm releases referencing values which no helm release rendered, the values are either missing or cyclic", "releases", names)
+		} else if pass > 1 {
+			a.Logger.Info("build helm releases referencing rendered values", "pass", pass, "count", len(batch))
+		}
+
+		var wg sync.WaitGroup
+		for _, r := range batch {
+			res := r
+			name := build.ResourceName(res.GetKind(), res.GetNamespace(), res.GetName())
+			if ctx.Err() != nil || a.interrupted() {
+				continue
+			}
+
+			wg.Add(1)
+			helmPool.Submit(func() {
+				defer wg.Done()
+				a.Logger.Info("build helm release", "namespace", res.GetNamespace(), "name", res.GetName())
+
+				// Logs of a release are buffered and written as one block to not interleave with other workers
+				logs := logbuffer.New(a.Logger.WithValues("namespace", res.GetNamespace(), "name", res.GetName()))
+				packaged := &build.PackagedChart{}
+				decision := &build.SourcePolicyDecision{}
+				resolved := &build.ResolvedChart{}
+				var skippedPostRenderers []string
+				var valueConflicts []build.ValueConflict
+				var warnings []string
+				var timings *build.ReleaseTimings
+				releaseCtx := build.WithSourcePolicyDecision(build.WithPackagedChart(logr.NewContext(ctx, logs.Logger()), packaged), decision)
+				releaseCtx = build.WithSkippedPostRenderers(build.WithResolvedChart(releaseCtx, resolved), &skippedPostRenderers)
+				releaseCtx = build.WithRenderWarnings(build.WithValueConflicts(releaseCtx, &valueConflicts), &warnings)
+				if a.Timings {
+					timings = &build.ReleaseTimings{}
+					releaseCtx = build.WithReleaseTimings(releaseCtx, timings)
+				}
+				index, err := helmBuilder.Build(releaseCtx, res, index)
+				if packaged.Digest != "" {
+					releases.packagedChart(name, packaged)
+				}
+				if resolved.Version != "" {
+					releases.resolvedChart(name, resolved)
+				}
+				if len(skippedPostRenderers) > 0 {
+					releases.skippedPostRenderers(name, skippedPostRenderers)
+				}
+				if decision.Decision != "" {
+					releases.sourcePolicy(name, decision)
+				}
+				if len(valueConflicts) > 0 {
+					releases.valueConflicts(name, valueConflicts)
+				}
+				if len(warnings) > 0 {
+					releases.warnings(name, warnings)
+				}
+				if timings != nil {
+					releases.timings(name, timings)
+				}
+
+				if err != nil {
+					logs.Flush()
+					a.Logger.Error(err, "failed build helmrelease", "namespace", res.GetNamespace(), "name", res.GetName())
+					status := ReleaseFailed
+					var policyErr *build.SourcePolicyError
+					if errors.As(err, &policyErr) {
+						status = ReleaseSkippedPolicy
+					}
+					releases.done(name, status, err)
+					errs <- err
+					return
+				}
+
+				passes.Add(index.Resources())
+
+				if a.LogsOnFailureOnly {
+					logs.Discard()
+				} else {
+					logs.Flush()
+				}
+
+				if err := a.checkObjectSizes(a.Logger.WithValues("namespace", res.GetNamespace(), "name", res.GetName()), index); err != nil {
+					a.Logger.Error(err, "failed build helmrelease", "namespace", res.GetNamespace(), "name", res.GetName())
+					releases.done(name, ReleaseFailed, err)
+					errs <- err
+				} else {
+					releases.done(name, ReleaseRendered, nil)
+				}
+
+				if namespace, ok := build.CreateNamespace(res); ok && namespaces != nil {
+					namespaces.Request(namespace)
+				}
+
+				path, _ := loader.Path(res)
+				manifests <- pathResources{path: path, resources: index}
+			})
+		}
+
+		if !waitPass(ctx, &wg) {
+			break
+		}
 	}
 
 	helmPool.StopAndWait()
 	close(manifests)
 	helmResultPool.StopAndWait()
+
+	if namespaces != nil {
+		if err := a.writeNamespaces(namespaces, index); err != nil {
+			a.Logger.Error(err, "failed to write namespaces to output")
+			errs <- err
+		}
+	}
+
+	if conflicts != nil {
+		a.logConflicts(conflicts.Findings())
+	}
+
 	close(errs)
+	<-errsDone
+
+	a.logSkipped(skipped)
+
+	result := Result{Releases: releases.results(), Partial: a.interrupted()}
+	a.logSummary(result)
+
+	// The HelmReleases of all clusters are checked once all clusters are built
+	if a.Clusters == "" {
+		if err := a.checkValuesOverrides(result.Releases); err != nil {
+			a.Logger.Error(err, "invalid values overrides")
+			lastErr = err
+		}
+	}
+
+	var partOfCounts *build.PartOfCounts
+	if partOf != nil {
+		counts := partOf.Counts()
+		partOfCounts = &counts
+		a.Logger.Info("labeled resources with their owning flux kustomization", "label", counts.Label, "labeled", counts.Labeled,
+			"alreadyLabeled", counts.AlreadyLabeled, "unowned", counts.Unowned)
+	}
+
+	if a.Report != "" {
+		if err := a.writeReport(Report{Partial: result.Partial, Substitutions: substitutions.Substitutions(), Skipped: skipped, Releases: result.Releases, PartOf: partOfCounts}); err != nil {
+			a.Logger.Error(err, "failed to write report", "path", a.Report)
+			lastErr = err
+		}
+	}
+
+	// The completed helm releases of an interrupted build are flushed even once a second interrupt canceled the
+	// build, the output is only discarded if the build was canceled without being interrupted
+	if parent.Err() != nil && !result.Partial {
+		if err := a.Output.Abort(); err != nil {
+			a.Logger.Error(err, "failed to discard output")
+		}
+	} else if err := a.Output.Flush(); err != nil {
+		a.Logger.Error(err, "failed to flush output")
+		lastErr = err
+	}
+
+	result.Err = lastErr
+	return result
+}
+
+// waitPass waits until the helm releases of a pass are built. It returns false once ctx is canceled as the pool
+// discards the queued helm releases then.
+func waitPass(ctx context.Context, wg *sync.WaitGroup) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// interrupted reports whether the build was interrupted.
+func (a *Action) interrupted() bool {
+	select {
+	case <-a.Interrupt:
+		return true
+	default:
+		return false
+	}
+}
+
+// drain cancels the build once the DrainTimeout elapsed after it was interrupted, it stops once ctx is done.
+func (a *Action) drain(ctx context.Context, cancel context.CancelFunc) {
+	if a.Interrupt == nil {
+		return
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			return
+		case <-a.Interrupt:
+		}
+
+		a.Logger.Info("build interrupted, waiting for in-flight helm releases", "drainTimeout", a.DrainTimeout)
+		timer := time.NewTimer(a.DrainTimeout)
+		defer timer.Stop()
+
+		select {
+		case <-ctx.Done():
+		case <-timer.C:
+			a.Logger.Info("drain timeout exceeded, cancel in-flight helm releases")
+			cancel()
+		}
+	}()
+}
+
+// pathResources are the resources built from a kustomize path, order is the position of the path.
+type pathResources struct {
+	order     int
+	path      string
+	resources resmap.ResMap
+}
+
+// sources returns the sources of the paths followed by the Sources, the resources read from stdin are taken as they
+// are while all other paths are built by kustomize.
+func (a *Action) sources(paths []string) []build.Source {
+	sources := make([]build.Source, 0, len(paths)+len(a.Sources))
+	for _, path := range paths {
+		if isStdin(path) {
+			sources = append(sources, build.NewStreamSource(path, a.stdin))
+			continue
+		}
+
+		sources = append(sources, build.PathSource(path))
+	}
+
+	return append(sources, a.Sources...)
+}
+
+// pullArtifacts extracts the paths referencing an OCI artifact into temporary directories.
+// The returned paths are built like local paths, cleanup removes the temporary directories.
+// Artifacts which can't be pulled are skipped.
+func (a *Action) pullArtifacts(ctx context.Context, errs chan<- error) ([]string, func()) {
+	var dirs []string
+	cleanup := func() {
+		for _, dir := range dirs {
+			_ = os.RemoveAll(dir)
+		}
+	}
+
+	paths := make([]string, 0, len(a.Paths))
+	for _, path := range a.Paths {
+		if !strings.HasPrefix(path, oci.ArtifactPrefix) {
+			paths = append(paths, path)
+			continue
+		}
+
+		dir, err := os.MkdirTemp("", "artifact")
+		if err != nil {
+			errs <- err
+			continue
+		}
+		dirs = append(dirs, dir)
+
+		host, _, _ := strings.Cut(strings.TrimPrefix(path, oci.ArtifactPrefix), "/")
+		insecure, skipTLSVerify := slices.Contains(a.InsecureRegistries, host), slices.Contains(a.SkipTLSVerify, host)
+		if insecure || skipTLSVerify {
+			a.Logger.Info("WARNING: accessing oci registry insecurely, this is meant for development and tests only", "registry", host, "plainHTTP", insecure, "skipTLSVerify", skipTLSVerify)
+		}
+
+		var opts []remote.Option
+		if !a.NoDefaultKeychain {
+			opts = append(opts, remote.WithAuthFromKeychain(authn.DefaultKeychain))
+		}
+		if a.Proxy != nil || a.TLSPolicy != nil || skipTLSVerify {
+			t := remote.DefaultTransport.(*http.Transport).Clone()
+			if a.Proxy != nil {
+				t.Proxy = transport.NewProxyFunc(a.Proxy)
+			}
+			if a.TLSPolicy != nil {
+				t.TLSClientConfig = a.TLSPolicy.Config()
+			}
+			if skipTLSVerify {
+				if t.TLSClientConfig == nil {
+					t.TLSClientConfig = &tls.Config{}
+				}
+				t.TLSClientConfig.InsecureSkipVerify = true
+			}
+			opts = append(opts, remote.WithTransport(t))
+		}
+
+		digest, err := oci.PullArtifact(ctx, path, dir, insecure, opts...)
+		if err != nil {
+			a.Logger.Error(err, "failed to pull artifact", "url", path)
+			errs <- err
+			continue
+		}
+
+		a.Logger.Info("pulled artifact", "url", path, "digest", digest.String())
+		paths = append(paths, dir)
+	}
+
+	return paths, cleanup
+}
+
+// resolveNameReferences detects the generated resources and rewrites the references to them in the index,
+// HelmReleases valuesFrom are then looked up by the hash-suffixed name.
+func (a *Action) resolveNameReferences(index build.ResourceIndex) (*build.NameReferences, error) {
+	m := resmap.New()
+	for _, res := range index {
+		if err := m.Append(res); err != nil {
+			return nil, err
+		}
+	}
+
+	refs, err := build.NewNameReferences(m.Resources())
+	if err != nil {
+		return nil, err
+	}
+
+	a.Logger.Info("detected generated resources", "count", refs.Len())
+
+	resolved, fixups, err := refs.Resolve(m)
+	if err != nil {
+		return nil, err
+	}
+
+	a.logFixups(a.Logger.V(1), fixups)
+	for k := range index {
+		delete(index, k)
+	}
+
+	return refs, index.Push(resolved.Resources())
+}
+
+func (a *Action) logFixups(logger logr.Logger, fixups []build.NameReferenceFixup) {
+	for _, fixup := range fixups {
+		logger.Info("fixed name reference", "kind", fixup.Referrer.Kind, "namespace", fixup.Referrer.Namespace, "name", fixup.Referrer.Name, "reference", fixup.Name, "generatedName", fixup.GeneratedName)
+	}
+}
+
+// checkDuplicates logs the resources declared with different content by more than one path, they fail the build
+// if OnDuplicate is DuplicateError.
+func (a *Action) checkDuplicates(duplicates []build.Duplicate) error {
+	if len(duplicates) == 0 {
+		return nil
+	}
+
+	switch a.OnDuplicate {
+	case build.DuplicateError:
+		return &build.DuplicateResourceError{Duplicates: duplicates}
+	case build.DuplicateLastWins:
+		for _, d := range duplicates {
+			a.Logger.V(1).Info("resource declared more than once, the last declaration wins", "resource", d.Resource, "paths", d.Paths)
+		}
+	default:
+		for _, d := range duplicates {
+			a.Logger.Info("resource declared more than once with different content, the last declaration wins", "resource", d.Resource, "paths", d.Paths)
+		}
+	}
+
+	return nil
+}
+
+// logConflicts reports the resources which set fields commonly managed by other controllers.
+func (a *Action) logConflicts(findings []build.ConflictFinding) {
+	for _, finding := range findings {
+		a.Logger.Info("resource is prone to server-side apply conflicts", "resource", finding.Resource, "manager", finding.Manager, "fields", finding.Fields)
+	}
+}
+
+// logSkipped logs a summary of the resources which were not built.
+func (a *Action) logSkipped(skipped []Skipped) {
+	for _, s := range skipped {
+		a.Logger.Info("skipped resource, it is not part of the output", "resource", s.Resource, "reason", s.Reason)
+	}
+}
+
+// writeNamespaces writes the namespaces created by HelmReleases which are not declared by any resource to the output.
+func (a *Action) writeNamespaces(namespaces *build.Namespaces, index build.ResourceIndex) error {
+	m, err := namespaces.Missing(index)
+	if err != nil || m.Size() == 0 {
+		return err
+	}
+
+	for _, r := range m.Resources() {
+		a.Logger.Info("add namespace created by helm releases", "name", r.GetName())
+	}
+
+	y, err := m.AsYaml()
+	if err != nil {
+		return err
+	}
+
+	_, err = a.Output.Write(append([]byte("---\n"), y...))
+	return err
+}
+
+// overlays returns the paths of the ValuesOverlays matching the HelmRelease.
+func (a *Action) overlays(res *resource.Resource) []string {
+	var paths []string
+	for _, overlay := range a.ValuesOverlays {
+		if overlay.Matches(res.GetNamespace(), res.GetName(), res.GetLabels()) {
+			paths = append(paths, overlay.Path)
+		}
+	}
+
+	return paths
+}
+
+// overrides returns the ValuesOverrides targeting the HelmRelease.
+func (a *Action) overrides(res *resource.Resource) []string {
+	var overrides []string
+	for _, override := range a.ValuesOverrides {
+		if override.Matches(res.GetNamespace(), res.GetName()) {
+			overrides = append(overrides, override.String())
+		}
+	}
+
+	return overrides
+}
+
+// checkValuesOverrides returns an error if any ValuesOverride targets a HelmRelease which is not part of the build.
+func (a *Action) checkValuesOverrides(releases []ReleaseResult) error {
+	found := make(map[string]bool, len(releases))
+	for _, release := range releases {
+		found[release.Resource] = true
+	}
+
+	var missing []string
+	for _, override := range a.ValuesOverrides {
+		if !found[build.ResourceName(helmv1.HelmReleaseKind, override.Namespace, override.Name)] {
+			missing = append(missing, override.String())
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("values overrides target helm releases which are not part of the build: %s", strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+// logSummary logs the number of HelmReleases by status, every HelmRelease which was not rendered,
+// every HelmRelease which received values overlays and the number of warnings of Helm per HelmRelease.
+func (a *Action) logSummary(result Result) {
+	overlaid, warned := 0, 0
+	for _, release := range result.Releases {
+		switch release.Status {
+		case ReleaseRendered, ReleaseSkippedSuspended, ReleaseSkippedSelector:
+		default:
+			a.Logger.Info("helm release is not part of the output", "resource", release.Resource, "status", release.Status, "error", release.Error)
+		}
+
+		if len(release.Warnings) > 0 {
+			warned++
+			a.Logger.Info("helm warned while rendering helm release", "resource", release.Resource, "status", release.Status, "warnings", len(release.Warnings))
+		}
+
+		if len(release.Overlays) > 0 || len(release.Overrides) > 0 {
+			overlaid++
+			a.Logger.Info("helm release values overlaid", "resource", release.Resource, "status", release.Status, "overlays", release.Overlays, "overrides", release.Overrides)
+		}
+	}
+
+	if result.Partial {
+		a.Logger.Info("PARTIAL BUILD: the build was interrupted, the output only contains the completed helm releases")
+	}
+
+	counts := result.Counts()
+	a.Logger.Info("built helm releases", "partial", result.Partial, "total", len(result.Releases), "rendered", counts[ReleaseRendered], "failed", counts[ReleaseFailed],
+		"skippedSuspended", counts[ReleaseSkippedSuspended], "skippedSelector", counts[ReleaseSkippedSelector], "skippedPolicy", counts[ReleaseSkippedPolicy],
+		"canceled", counts[ReleaseCanceled], "overlaid", overlaid, "warned", warned)
+}
+
+// checkObjectSizes logs the resources exceeding the ObjectSizeLimits, they fail the build if StrictObjectSize is set.
+// The logger is expected to carry the path or HelmRelease the resources originate from.
+func (a *Action) checkObjectSizes(logger logr.Logger, index resmap.ResMap) error {
+	findings, err := a.ObjectSizeLimits.Check(index)
+	if err != nil {
+		return err
+	}
+
+	for _, finding := range findings {
+		logger.Info("resource exceeds the kubernetes object size limit", "resource", finding.Resource, "limit", finding.Limit, "size", finding.Size, "maxSize", finding.MaxSize)
+	}
+
+	if a.StrictObjectSize && len(findings) > 0 {
+		return fmt.Errorf("%d resources exceed the kubernetes object size limits", len(findings))
+	}
 
 	return nil
 }
@@ -0,0 +1,323 @@
+package action
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/doodlescheduling/flux-build/internal/build"
+	"github.com/doodlescheduling/flux-build/internal/cachemgr"
+	"github.com/go-logr/logr"
+	. "github.com/onsi/gomega"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	helmrepo "helm.sh/helm/v3/pkg/repo"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/kustomize/api/resmap"
+	"sigs.k8s.io/yaml"
+)
+
+// blockingSource is in-flight until the build is canceled, started is closed once it is loaded.
+type blockingSource struct {
+	started chan struct{}
+}
+
+func (s *blockingSource) Name() string {
+	return "blocking"
+}
+
+func (s *blockingSource) Load(ctx context.Context) (resmap.ResMap, error) {
+	close(s.started)
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestDrainCancelsInFlight(t *testing.T) {
+	g := NewWithT(t)
+
+	interrupt := make(chan struct{})
+	a := &Action{Logger: logr.Discard(), Interrupt: interrupt, DrainTimeout: 50 * time.Millisecond}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a.drain(ctx, cancel)
+
+	g.Consistently(ctx.Done(), 100*time.Millisecond).ShouldNot(BeClosed())
+
+	close(interrupt)
+	g.Consistently(ctx.Done(), 25*time.Millisecond).ShouldNot(BeClosed())
+	g.Eventually(ctx.Done(), time.Second).Should(BeClosed())
+}
+
+func TestBuildInterrupted(t *testing.T) {
+	tests := []struct {
+		name         string
+		interrupt    bool
+		cancel       bool
+		expectOutput bool
+		expectCode   int
+	}{
+		{
+			name:         "drain timeout",
+			interrupt:    true,
+			expectOutput: true,
+			expectCode:   ExitCodePartial,
+		},
+		{
+			name:         "second interrupt",
+			interrupt:    true,
+			cancel:       true,
+			expectOutput: true,
+			expectCode:   ExitCodePartial,
+		},
+		{
+			name:       "canceled without interrupt",
+			cancel:     true,
+			expectCode: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			dir := t.TempDir()
+			path := filepath.Join(dir, "output.yaml")
+			out, err := newFileSink(path)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			completed := build.NewStreamSource("completed", []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: completed\n"))
+			inFlight := &blockingSource{started: make(chan struct{})}
+
+			interrupt := make(chan struct{})
+			a := &Action{
+				Output:       out,
+				Workers:      1,
+				Logger:       logr.Discard(),
+				Interrupt:    interrupt,
+				DrainTimeout: 50 * time.Millisecond,
+				Sources:      []build.Source{completed, inFlight},
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			go func() {
+				<-inFlight.started
+				if tt.interrupt {
+					close(interrupt)
+				}
+				if tt.cancel {
+					cancel()
+				}
+			}()
+
+			result := a.Build(ctx)
+			g.Expect(result.Partial).To(Equal(tt.interrupt))
+			g.Expect(result.ExitCode(false)).To(Equal(tt.expectCode))
+
+			entries, err := os.ReadDir(dir)
+			g.Expect(err).ToNot(HaveOccurred())
+			if !tt.expectOutput {
+				g.Expect(entries).To(BeEmpty())
+				return
+			}
+
+			g.Expect(entries).To(HaveLen(1))
+			g.Expect(entries[0].Name()).To(Equal("output.yaml"))
+
+			// The output holds the completed source only
+			b, err := os.ReadFile(path)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(string(b)).To(Equal("---\napiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: completed\n"))
+		})
+	}
+}
+
+func TestBuildStdinDocumentLimits(t *testing.T) {
+	g := NewWithT(t)
+
+	stdin, err := os.CreateTemp(t.TempDir(), "stdin")
+	g.Expect(err).ToNot(HaveOccurred())
+	_, err = stdin.WriteString("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: podinfo\ndata:\n  key: " + strings.Repeat("x", 64) + "\n")
+	g.Expect(err).ToNot(HaveOccurred())
+	_, err = stdin.Seek(0, io.SeekStart)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	defaultStdin := os.Stdin
+	os.Stdin = stdin
+	t.Cleanup(func() {
+		os.Stdin = defaultStdin
+	})
+
+	var out bytes.Buffer
+	a := &Action{
+		Output:         WriterSink(&out),
+		Workers:        1,
+		Logger:         logr.Discard(),
+		Paths:          []string{StdinPath},
+		DocumentLimits: build.DocumentLimits{MaxSize: 64},
+	}
+
+	result := a.Build(context.Background())
+	g.Expect(result.Err).To(MatchError(build.ErrDocumentLimit))
+	g.Expect(result.ExitCode(false)).To(Equal(1))
+	g.Expect(out.String()).To(BeEmpty())
+}
+
+func TestBuildSkippedReleases(t *testing.T) {
+	g := NewWithT(t)
+
+	manifests := `apiVersion: source.toolkit.fluxcd.io/v1
+kind: HelmRepository
+metadata:
+  name: podinfo
+  namespace: apps
+spec:
+  url: https://stefanprodan.github.io/podinfo
+---
+apiVersion: helm.toolkit.fluxcd.io/v2
+kind: HelmRelease
+metadata:
+  name: payments
+  namespace: apps
+  labels:
+    team: payments
+spec:
+  chart:
+    spec:
+      chart: podinfo
+      sourceRef:
+        kind: HelmRepository
+        name: podinfo
+---
+apiVersion: helm.toolkit.fluxcd.io/v2
+kind: HelmRelease
+metadata:
+  name: search
+  namespace: apps
+  labels:
+    team: search
+spec:
+  chart:
+    spec:
+      chart: podinfo
+      sourceRef:
+        kind: HelmRepository
+        name: podinfo
+`
+
+	policy, err := build.NewSourcePolicy([]string{"OCIRepository"}, nil, nil, "", "")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	selector, err := labels.Parse("team=payments")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	var out bytes.Buffer
+	a := &Action{
+		Output:          WriterSink(&out),
+		Workers:         1,
+		Logger:          logr.Discard(),
+		Sources:         []build.Source{build.NewStreamSource("manifests", []byte(manifests))},
+		SourcePolicy:    policy,
+		ReleaseSelector: selector,
+	}
+
+	result := a.Build(context.Background())
+	g.Expect(result.Counts()).To(Equal(map[ReleaseStatus]int{ReleaseSkippedPolicy: 1, ReleaseSkippedSelector: 1}))
+	g.Expect(result.Releases[0].Resource).To(Equal("HelmRelease/apps/payments"))
+	g.Expect(result.Releases[0].Status).To(Equal(ReleaseSkippedPolicy))
+	g.Expect(result.Releases[0].Error).To(ContainSubstring("violates the source policy"))
+	g.Expect(result.Releases[1].Resource).To(Equal("HelmRelease/apps/search"))
+	g.Expect(result.Releases[1].Status).To(Equal(ReleaseSkippedSelector))
+
+	// The policy violation fails the build while skipped releases do not
+	g.Expect(result.Err).To(HaveOccurred())
+	g.Expect(result.ExitCode(false)).To(Equal(1))
+}
+
+func TestBuildDefaultControllerCompat(t *testing.T) {
+	g := NewWithT(t)
+
+	const testChart = "../helm/testdata/charts/helmchart-0.1.0.tgz"
+	c, err := loader.Load(testChart)
+	g.Expect(err).ToNot(HaveOccurred())
+	archive, err := os.ReadFile(testChart)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	index := helmrepo.NewIndexFile()
+	g.Expect(index.MustAdd(c.Metadata, "helmchart-0.1.0.tgz", server.URL, "")).To(Succeed())
+	indexYAML, err := yaml.Marshal(index)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	mux.HandleFunc("/index.yaml", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(indexYAML)
+	})
+	mux.HandleFunc("/helmchart-0.1.0.tgz", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(archive)
+	})
+
+	manifests := `apiVersion: source.toolkit.fluxcd.io/v1
+kind: HelmRepository
+metadata:
+  name: charts
+  namespace: apps
+spec:
+  url: ` + server.URL + `
+---
+apiVersion: helm.toolkit.fluxcd.io/v2
+kind: HelmRelease
+metadata:
+  name: app
+  namespace: apps
+spec:
+  chart:
+    spec:
+      chart: helmchart
+      version: 0.1.0
+      sourceRef:
+        kind: HelmRepository
+        name: charts
+`
+
+	cache, err := cachemgr.New("none", "")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// An Action without a ControllerCompat renders like the latest helm-controller version
+	var out bytes.Buffer
+	a := &Action{
+		Output:  WriterSink(&out),
+		Workers: 1,
+		Logger:  logr.Discard(),
+		Cache:   cache,
+		Sources: []build.Source{build.NewStreamSource("manifests", []byte(manifests))},
+	}
+
+	result := a.Build(context.Background())
+	g.Expect(result.Err).ToNot(HaveOccurred())
+
+	resources, err := build.ReadResources(logr.Discard(), &out, build.DocumentLimits{})
+	g.Expect(err).ToNot(HaveOccurred())
+
+	var deployments int
+	for _, r := range resources.Resources() {
+		if r.GetKind() != "Deployment" {
+			continue
+		}
+
+		deployments++
+		g.Expect(r.GetNamespace()).To(Equal("apps"))
+		g.Expect(r.GetLabels()).To(HaveKeyWithValue("helm.toolkit.fluxcd.io/name", "app"))
+		g.Expect(r.GetLabels()).To(HaveKeyWithValue("helm.toolkit.fluxcd.io/namespace", "apps"))
+	}
+	g.Expect(deployments).To(Equal(1))
+}
@@ -0,0 +1,140 @@
+package action
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/doodlescheduling/flux-build/internal/build"
+)
+
+// buildClusters builds every directory matching the Clusters pattern as a distinct target.
+// A cluster consists of its directory, the Flux Kustomizations reachable from it and the Paths shared by all clusters.
+// Resources reachable from several clusters are written to the output of each of them.
+// The HelmReleases of the result are those of all clusters.
+func (a *Action) buildClusters(ctx context.Context) Result {
+	dirs, err := a.clusterDirs()
+	if err != nil {
+		a.Logger.Error(err, "failed to find clusters", "pattern", a.Clusters)
+		return Result{Err: err}
+	}
+
+	if err := os.MkdirAll(a.OutputDir, 0755); err != nil {
+		a.Logger.Error(err, "failed to create output directory", "path", a.OutputDir)
+		return Result{Err: err}
+	}
+
+	root := a.RepositoryRoot
+	if root == "" {
+		root = "."
+	}
+
+	var result Result
+	for _, dir := range dirs {
+		if ctx.Err() != nil || a.interrupted() {
+			break
+		}
+
+		name := filepath.Base(dir)
+		logger := a.Logger.WithValues("cluster", name)
+
+		clusterResult := a.buildCluster(ctx, root, dir, name)
+		for _, release := range clusterResult.Releases {
+			release.Cluster = name
+			result.Releases = append(result.Releases, release)
+		}
+
+		if clusterResult.Failed() {
+			logger.Error(clusterResult.Err, "failed to build cluster")
+			if clusterResult.Err != nil {
+				result.Err = clusterResult.Err
+			}
+
+			if a.FailFast {
+				break
+			}
+		}
+	}
+
+	result.Partial = a.interrupted()
+	if result.Partial {
+		a.Logger.Info("PARTIAL BUILD: the build was interrupted, the outputs only contain the completed clusters and helm releases")
+	}
+
+	// Overrides of HelmReleases of clusters which were never built are not reported for partial builds
+	if err := a.checkValuesOverrides(result.Releases); err != nil && !result.Partial {
+		a.Logger.Error(err, "invalid values overrides")
+		result.Err = err
+	}
+
+	return result
+}
+
+func (a *Action) buildCluster(ctx context.Context, root, dir, name string) Result {
+	logger := a.Logger.WithValues("cluster", name)
+
+	paths, err := build.KustomizationPaths(ctx, root, dir)
+	if err != nil {
+		return Result{Err: err}
+	}
+
+	outputPath := filepath.Join(a.OutputDir, name+".yaml")
+	out, err := newFileSink(outputPath)
+	if err != nil {
+		return Result{Err: err}
+	}
+
+	cluster := *a
+	cluster.Logger = logger
+	cluster.Output = out
+	cluster.Paths = append(paths, a.Paths...)
+	cluster.Report = clusterReportPath(a.Report, name)
+	if a.DumpValuesDir != "" {
+		cluster.DumpValuesDir = filepath.Join(a.DumpValuesDir, name)
+	}
+	if a.NotesDir != "" {
+		cluster.NotesDir = filepath.Join(a.NotesDir, name)
+	}
+
+	result := cluster.build(ctx)
+	logger.Info("built cluster", "output", outputPath, "paths", paths, "failed", result.Failed())
+	return result
+}
+
+// clusterDirs returns the directories matching the Clusters pattern sorted by name.
+// The names of the directories must be unique as they name the outputs.
+func (a *Action) clusterDirs() ([]string, error) {
+	matches, err := filepath.Glob(a.Clusters)
+	if err != nil {
+		return nil, err
+	}
+
+	var dirs []string
+	names := make(map[string]string)
+	for _, match := range matches {
+		stat, err := os.Stat(match)
+		if err != nil || !stat.IsDir() {
+			continue
+		}
+
+		name := filepath.Base(match)
+		if other, ok := names[name]; ok {
+			return nil, fmt.Errorf("clusters %s and %s have the same name `%s`", other, match, name)
+		}
+
+		names[name] = match
+		dirs = append(dirs, match)
+	}
+
+	if len(dirs) == 0 {
+		return nil, fmt.Errorf("no cluster directories match `%s`", a.Clusters)
+	}
+
+	sort.Slice(dirs, func(i, j int) bool {
+		return filepath.Base(dirs[i]) < filepath.Base(dirs[j])
+	})
+
+	return dirs, nil
+}
@@ -0,0 +1,52 @@
+package action
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/doodlescheduling/flux-build/internal/build"
+)
+
+// Report is written to the Report path once the build finished.
+type Report struct {
+	// Partial is set if the build was interrupted, only completed HelmReleases are part of the output.
+	Partial bool `json:"partial,omitempty"`
+	// Substitutions is the audit trail of substituted variables, it is only recorded if AuditSubstitutions is set.
+	Substitutions []build.Substitution `json:"substitutions,omitempty"`
+	// Skipped are the resources which were not built.
+	Skipped []Skipped `json:"skipped,omitempty"`
+	// Releases are the terminal statuses of all HelmReleases.
+	Releases []ReleaseResult `json:"releases,omitempty"`
+	// PartOf counts the resources labeled with their owning Flux Kustomization, it is only set if PartOfLabel is set.
+	PartOf *build.PartOfCounts `json:"partOf,omitempty"`
+}
+
+// Skipped is a resource which was not built.
+type Skipped struct {
+	// Resource is the kind, namespace and name of the resource.
+	Resource string `json:"resource"`
+	// Reason is why the resource was skipped, for instance suspended.
+	Reason string `json:"reason"`
+}
+
+// writeReport writes the report as JSON.
+func (a *Action) writeReport(report Report) error {
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(a.Report, append(b, '\n'), 0644)
+}
+
+// clusterReportPath returns the report path of a cluster, the cluster name is inserted before the extension.
+func clusterReportPath(path, cluster string) string {
+	if path == "" {
+		return ""
+	}
+
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "." + cluster + ext
+}
@@ -0,0 +1,239 @@
+package action
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/doodlescheduling/flux-build/internal/build"
+)
+
+// ReleaseStatus is the terminal status of a HelmRelease once the build finished.
+type ReleaseStatus string
+
+const (
+	// ReleaseRendered is a HelmRelease whose resources are part of the output.
+	ReleaseRendered ReleaseStatus = "Rendered"
+	// ReleaseFailed is a HelmRelease which failed to build.
+	ReleaseFailed ReleaseStatus = "Failed"
+	// ReleaseSkippedSuspended is a suspended HelmRelease skipped by SkipSuspended.
+	ReleaseSkippedSuspended ReleaseStatus = "SkippedSuspended"
+	// ReleaseSkippedSelector is a HelmRelease which does not match the ReleaseSelector.
+	ReleaseSkippedSelector ReleaseStatus = "SkippedSelector"
+	// ReleaseSkippedPolicy is a HelmRelease whose chart source violates the SourcePolicy, it is not built.
+	// The violation is the error of the release and fails the build.
+	ReleaseSkippedPolicy ReleaseStatus = "SkippedPolicy"
+	// ReleaseCanceled is a HelmRelease which was not built as the build was canceled, for instance by FailFast.
+	ReleaseCanceled ReleaseStatus = "Canceled"
+)
+
+// ReleaseResult is the outcome of a single HelmRelease.
+type ReleaseResult struct {
+	// Cluster is the name of the cluster the HelmRelease was built for, empty unless Clusters is set.
+	Cluster string `json:"cluster,omitempty"`
+	// Resource is the kind, namespace and name of the HelmRelease.
+	Resource string `json:"resource"`
+	// Status is the terminal status of the HelmRelease.
+	Status ReleaseStatus `json:"status"`
+	// Error is the error of a failed HelmRelease.
+	Error string `json:"error,omitempty"`
+	// Overlays are the paths of the values overlays merged into the values of the HelmRelease.
+	Overlays []string `json:"overlays,omitempty"`
+	// Overrides are the values overrides applied to the HelmRelease.
+	Overrides []string `json:"overrides,omitempty"`
+	// Chart is the chart version the HelmRelease was resolved to, empty if the chart was not resolved.
+	Chart *build.ResolvedChart `json:"chart,omitempty"`
+	// PackagedChart is the packaged chart of the built repository the HelmRelease was rendered from.
+	PackagedChart *build.PackagedChart `json:"packagedChart,omitempty"`
+	// SkippedPostRenderers are the built-in post renderers skipped by the annotation of the HelmRelease.
+	SkippedPostRenderers []string `json:"skippedPostRenderers,omitempty"`
+	// SourcePolicy is the decision of the source policy for the HelmRelease, empty if no policy is enforced.
+	SourcePolicy *build.SourcePolicyDecision `json:"sourcePolicy,omitempty"`
+	// ValueConflicts are the values keys a later source overwrote, only recorded if ReportValueConflicts is set.
+	ValueConflicts []build.ValueConflict `json:"valueConflicts,omitempty"`
+	// Warnings are the warnings of Helm while rendering the HelmRelease, for instance a table of the chart values
+	// overwritten by a scalar.
+	Warnings []string `json:"warnings,omitempty"`
+	// Timings are the durations of the operations building the HelmRelease, only recorded if Timings is set.
+	Timings *build.ReleaseTimings `json:"timings,omitempty"`
+}
+
+// Result is the outcome of a build.
+type Result struct {
+	// Releases are the results of all HelmReleases sorted by cluster and resource.
+	Releases []ReleaseResult
+	// Err is the last error which occurred, including errors not caused by a HelmRelease
+	// such as kustomize paths, artifacts or writing the output and the report.
+	Err error
+	// Partial is set if the build was interrupted, the output only contains the completed HelmReleases.
+	Partial bool
+}
+
+// ExitCodePartial is the exit code of an interrupted build.
+const ExitCodePartial = 3
+
+// Failed reports whether any error occurred or any HelmRelease failed.
+func (r Result) Failed() bool {
+	if r.Err != nil {
+		return true
+	}
+
+	for _, release := range r.Releases {
+		if release.Status == ReleaseFailed {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ExitCode is the exit code of the CLI for the result. It is ExitCodePartial if the build was interrupted,
+// 1 if the build Failed unless allowFailure is set, 0 otherwise. Skipped and canceled HelmReleases never fail
+// a build by themselves, a build is only canceled after another failure or an interrupt. The violation of a
+// ReleaseSkippedPolicy is the Err of the result though.
+func (r Result) ExitCode(allowFailure bool) int {
+	if r.Partial {
+		return ExitCodePartial
+	}
+
+	if r.Failed() && !allowFailure {
+		return 1
+	}
+
+	return 0
+}
+
+// Counts returns the number of HelmReleases by status.
+func (r Result) Counts() map[ReleaseStatus]int {
+	counts := make(map[ReleaseStatus]int)
+	for _, release := range r.Releases {
+		counts[release.Status]++
+	}
+
+	return counts
+}
+
+// releaseTracker collects the statuses of the HelmReleases of a build, it is safe for concurrent use.
+// HelmReleases which are pending once the build finished are reported as canceled.
+type releaseTracker struct {
+	mu       sync.Mutex
+	releases map[string]ReleaseResult
+}
+
+func newReleaseTracker() *releaseTracker {
+	return &releaseTracker{
+		releases: make(map[string]ReleaseResult),
+	}
+}
+
+// pending registers a HelmRelease which is about to be built with the values overlays and overrides applied to it.
+func (t *releaseTracker) pending(resource string, overlays, overrides []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.releases[resource] = ReleaseResult{Resource: resource, Overlays: overlays, Overrides: overrides}
+}
+
+// done records the terminal status of a HelmRelease and the error it failed with.
+func (t *releaseTracker) done(resource string, status ReleaseStatus, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	result := t.releases[resource]
+	result.Resource = resource
+	result.Status = status
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	t.releases[resource] = result
+}
+
+// packagedChart records the packaged chart of the built repository a HelmRelease is rendered from.
+func (t *releaseTracker) packagedChart(resource string, chart *build.PackagedChart) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	result := t.releases[resource]
+	result.PackagedChart = chart
+	t.releases[resource] = result
+}
+
+// resolvedChart records the chart version a HelmRelease was resolved to.
+func (t *releaseTracker) resolvedChart(resource string, chart *build.ResolvedChart) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	result := t.releases[resource]
+	result.Chart = chart
+	t.releases[resource] = result
+}
+
+// skippedPostRenderers records the built-in post renderers a HelmRelease skips.
+func (t *releaseTracker) skippedPostRenderers(resource string, skipped []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	result := t.releases[resource]
+	result.SkippedPostRenderers = skipped
+	t.releases[resource] = result
+}
+
+// sourcePolicy records the source policy decision for a HelmRelease.
+func (t *releaseTracker) sourcePolicy(resource string, decision *build.SourcePolicyDecision) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	result := t.releases[resource]
+	result.SourcePolicy = decision
+	t.releases[resource] = result
+}
+
+// valueConflicts records the values keys of a HelmRelease which a later source overwrote.
+func (t *releaseTracker) valueConflicts(resource string, conflicts []build.ValueConflict) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	result := t.releases[resource]
+	result.ValueConflicts = conflicts
+	t.releases[resource] = result
+}
+
+// warnings records the warnings of Helm while rendering a HelmRelease.
+func (t *releaseTracker) warnings(resource string, warnings []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	result := t.releases[resource]
+	result.Warnings = warnings
+	t.releases[resource] = result
+}
+
+// timings records the durations of the operations building a HelmRelease.
+func (t *releaseTracker) timings(resource string, timings *build.ReleaseTimings) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	result := t.releases[resource]
+	result.Timings = timings
+	t.releases[resource] = result
+}
+
+// results returns the statuses sorted by resource.
+func (t *releaseTracker) results() []ReleaseResult {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	results := make([]ReleaseResult, 0, len(t.releases))
+	for _, result := range t.releases {
+		if result.Status == "" {
+			result.Status = ReleaseCanceled
+		}
+
+		results = append(results, result)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Resource < results[j].Resource
+	})
+
+	return results
+}
@@ -0,0 +1,105 @@
+package action
+
+import (
+	"errors"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestResultExitCode(t *testing.T) {
+	tests := []struct {
+		name         string
+		result       Result
+		allowFailure bool
+		expectCode   int
+		expectCounts map[ReleaseStatus]int
+	}{
+		{
+			name: "all rendered",
+			result: Result{Releases: []ReleaseResult{
+				{Resource: "HelmRelease/apps/a", Status: ReleaseRendered},
+				{Resource: "HelmRelease/apps/b", Status: ReleaseRendered},
+			}},
+			expectCode:   0,
+			expectCounts: map[ReleaseStatus]int{ReleaseRendered: 2},
+		},
+		{
+			name: "skipped releases",
+			result: Result{Releases: []ReleaseResult{
+				{Resource: "HelmRelease/apps/a", Status: ReleaseRendered},
+				{Resource: "HelmRelease/apps/b", Status: ReleaseSkippedSuspended},
+				{Resource: "HelmRelease/apps/c", Status: ReleaseSkippedSelector},
+			}},
+			expectCode:   0,
+			expectCounts: map[ReleaseStatus]int{ReleaseRendered: 1, ReleaseSkippedSuspended: 1, ReleaseSkippedSelector: 1},
+		},
+		{
+			name: "failed",
+			result: Result{Releases: []ReleaseResult{
+				{Resource: "HelmRelease/apps/a", Status: ReleaseRendered},
+				{Resource: "HelmRelease/apps/b", Status: ReleaseFailed, Error: "failed"},
+				{Resource: "HelmRelease/apps/c", Status: ReleaseCanceled},
+			}},
+			expectCode:   1,
+			expectCounts: map[ReleaseStatus]int{ReleaseRendered: 1, ReleaseFailed: 1, ReleaseCanceled: 1},
+		},
+		{
+			name: "failed with allow failure",
+			result: Result{Releases: []ReleaseResult{
+				{Resource: "HelmRelease/apps/a", Status: ReleaseFailed, Error: "failed"},
+			}},
+			allowFailure: true,
+			expectCode:   0,
+			expectCounts: map[ReleaseStatus]int{ReleaseFailed: 1},
+		},
+		{
+			name: "source policy violation",
+			result: Result{
+				Releases: []ReleaseResult{
+					{Resource: "HelmRelease/apps/a", Status: ReleaseSkippedPolicy, Error: "violates the source policy"},
+				},
+				Err: errors.New("violates the source policy"),
+			},
+			expectCode:   1,
+			expectCounts: map[ReleaseStatus]int{ReleaseSkippedPolicy: 1},
+		},
+		{
+			name:         "error without releases",
+			result:       Result{Err: errors.New("failed to build kustomize path")},
+			expectCode:   1,
+			expectCounts: map[ReleaseStatus]int{},
+		},
+		{
+			name: "partial",
+			result: Result{
+				Releases: []ReleaseResult{
+					{Resource: "HelmRelease/apps/a", Status: ReleaseRendered},
+					{Resource: "HelmRelease/apps/b", Status: ReleaseCanceled},
+				},
+				Partial: true,
+			},
+			expectCode:   ExitCodePartial,
+			expectCounts: map[ReleaseStatus]int{ReleaseRendered: 1, ReleaseCanceled: 1},
+		},
+		{
+			name: "partial with failures and allow failure",
+			result: Result{
+				Releases: []ReleaseResult{
+					{Resource: "HelmRelease/apps/a", Status: ReleaseFailed, Error: "failed"},
+				},
+				Partial: true,
+			},
+			allowFailure: true,
+			expectCode:   ExitCodePartial,
+			expectCounts: map[ReleaseStatus]int{ReleaseFailed: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			g.Expect(tt.result.ExitCode(tt.allowFailure)).To(Equal(tt.expectCode))
+			g.Expect(tt.result.Counts()).To(Equal(tt.expectCounts))
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package action
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Sink receives the output of a build. Every HelmRelease and kustomize path is written as a whole, once the build
+// finished the sink is either flushed or aborted.
+type Sink interface {
+	io.Writer
+	// Flush commits everything written so far, it is called once the build finished including partial builds.
+	Flush() error
+	// Abort discards everything written so far if the sink supports it, it is called if the build was aborted
+	// before it could finish.
+	Abort() error
+}
+
+// WriterSink returns a sink which writes directly to w, nothing can be discarded once written.
+func WriterSink(w io.Writer) Sink {
+	return writerSink{Writer: w}
+}
+
+type writerSink struct {
+	io.Writer
+}
+
+func (s writerSink) Flush() error {
+	return nil
+}
+
+func (s writerSink) Abort() error {
+	return nil
+}
+
+// fileSink writes to a temporary file next to path which replaces path once flushed.
+// Readers of path never observe the output of an aborted build.
+type fileSink struct {
+	*os.File
+	path string
+}
+
+func newFileSink(path string) (*fileSink, error) {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := f.Chmod(0644); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return nil, err
+	}
+
+	return &fileSink{File: f, path: path}, nil
+}
+
+func (s *fileSink) Flush() error {
+	if err := s.File.Close(); err != nil {
+		_ = os.Remove(s.File.Name())
+		return err
+	}
+
+	return os.Rename(s.File.Name(), s.path)
+}
+
+func (s *fileSink) Abort() error {
+	_ = s.File.Close()
+	return os.Remove(s.File.Name())
+}
@@ -0,0 +1,66 @@
+package action
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestFileSinkFlush(t *testing.T) {
+	g := NewWithT(t)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "output.yaml")
+	g.Expect(os.WriteFile(path, []byte("previous"), 0644)).To(Succeed())
+
+	sink, err := newFileSink(path)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	_, err = sink.Write([]byte("---\nkind: ConfigMap\n"))
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// Readers of path observe the previous output until the sink is flushed
+	b, err := os.ReadFile(path)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(string(b)).To(Equal("previous"))
+
+	g.Expect(sink.Flush()).To(Succeed())
+
+	b, err = os.ReadFile(path)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(string(b)).To(Equal("---\nkind: ConfigMap\n"))
+
+	info, err := os.Stat(path)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(info.Mode().Perm()).To(Equal(os.FileMode(0644)))
+
+	// The temporary file was renamed into place
+	entries, err := os.ReadDir(dir)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(entries).To(HaveLen(1))
+	g.Expect(entries[0].Name()).To(Equal("output.yaml"))
+}
+
+func TestFileSinkAbort(t *testing.T) {
+	g := NewWithT(t)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "output.yaml")
+
+	sink, err := newFileSink(path)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	_, err = sink.Write([]byte("---\nkind: ConfigMap\n"))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(sink.Abort()).To(Succeed())
+
+	// Neither the output nor the temporary file exist
+	_, err = os.Stat(path)
+	g.Expect(os.IsNotExist(err)).To(BeTrue())
+
+	entries, err := os.ReadDir(dir)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(entries).To(BeEmpty())
+}
@@ -0,0 +1,43 @@
+package action
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// writeTimings writes the timings of the releases as a table sorted by the slowest release, releases without timings
+// (for instance canceled ones) are left out.
+func writeTimings(w io.Writer, releases []ReleaseResult) error {
+	var timed []ReleaseResult
+	for _, release := range releases {
+		if release.Timings != nil {
+			timed = append(timed, release)
+		}
+	}
+
+	sort.SliceStable(timed, func(i, j int) bool {
+		return timed[i].Timings.Total > timed[j].Timings.Total
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "RELEASE\tSTATUS\tCHART\tVALUES\tRENDER\tKUSTOMIZE\tTOTAL")
+	for _, release := range timed {
+		name := release.Resource
+		if release.Cluster != "" {
+			name = release.Cluster + ":" + name
+		}
+
+		t := release.Timings
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", name, release.Status, roundTiming(t.Chart), roundTiming(t.Values),
+			roundTiming(t.Render), roundTiming(t.Kustomize), roundTiming(t.Total))
+	}
+
+	return tw.Flush()
+}
+
+func roundTiming(d time.Duration) time.Duration {
+	return d.Round(time.Millisecond)
+}
@@ -0,0 +1,230 @@
+package bucket
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+)
+
+// azureScope is the OAuth2 scope of Azure Storage.
+const azureScope = "https://storage.azure.com/.default"
+
+// azureVersion is the Blob service API version, bearer tokens require at least 2017-11-09.
+const azureVersion = "2021-08-06"
+
+// azureCredential returns the ambient Azure credentials (environment, workload identity, managed identity or the az cli).
+var azureCredential = func() (azcore.TokenCredential, error) {
+	return azidentity.NewDefaultAzureCredential(nil)
+}
+
+// azureClient accesses an Azure Blob Storage container using the REST API.
+type azureClient struct {
+	http      *http.Client
+	endpoint  *url.URL
+	account   string
+	container string
+	// Exactly one of the following authenticates the requests
+	credential azcore.TokenCredential
+	accountKey []byte
+	sas        url.Values
+}
+
+func newAzureClient(ctx context.Context, opts Options) (*azureClient, error) {
+	endpoint, err := endpointURL(opts.Endpoint, "", opts.Insecure)
+	if err != nil {
+		return nil, err
+	}
+
+	if endpoint.Host == "" {
+		return nil, fmt.Errorf("azure buckets require an endpoint")
+	}
+
+	c := &azureClient{
+		http:      &http.Client{Transport: opts.Transport},
+		endpoint:  endpoint,
+		account:   strings.SplitN(endpoint.Hostname(), ".", 2)[0],
+		container: opts.BucketName,
+	}
+
+	secret := opts.Secret
+	switch {
+	case len(secret["accountKey"]) > 0:
+		c.accountKey, err = base64.StdEncoding.DecodeString(string(secret["accountKey"]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid azure accountKey: %w", err)
+		}
+	case len(secret["sasKey"]) > 0:
+		sas := string(secret["sasKey"])
+		if i := strings.Index(sas, "?"); i >= 0 {
+			sas = sas[i+1:]
+		}
+
+		c.sas, err = url.ParseQuery(sas)
+		if err != nil {
+			return nil, fmt.Errorf("invalid azure sasKey: %w", err)
+		}
+	case len(secret["clientSecret"]) > 0:
+		c.credential, err = azidentity.NewClientSecretCredential(string(secret["tenantId"]), string(secret["clientId"]), string(secret["clientSecret"]), nil)
+		if err != nil {
+			return nil, fmt.Errorf("invalid azure service principal: %w", err)
+		}
+	case len(secret["clientId"]) > 0:
+		c.credential, err = azidentity.NewManagedIdentityCredential(&azidentity.ManagedIdentityCredentialOptions{
+			ID: azidentity.ClientID(secret["clientId"]),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("invalid azure managed identity: %w", err)
+		}
+	case secret != nil:
+		return nil, fmt.Errorf("bucket secret requires one of the keys accountKey, sasKey, clientSecret or clientId")
+	default:
+		c.credential, err = azureCredential()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load azure credentials: %w", err)
+		}
+	}
+
+	return c, nil
+}
+
+type azureEnumerationResults struct {
+	Blobs struct {
+		Blob []struct {
+			Name       string `xml:"Name"`
+			Properties struct {
+				ETag string `xml:"Etag"`
+			} `xml:"Properties"`
+		} `xml:"Blob"`
+	} `xml:"Blobs"`
+	NextMarker string `xml:"NextMarker"`
+}
+
+func (c *azureClient) ListObjects(ctx context.Context, prefix string) ([]Object, error) {
+	var objects []Object
+	var marker string
+
+	for {
+		query := url.Values{
+			"restype": {"container"},
+			"comp":    {"list"},
+		}
+		if prefix != "" {
+			query.Set("prefix", prefix)
+		}
+		if marker != "" {
+			query.Set("marker", marker)
+		}
+
+		body, err := c.get(ctx, "", query)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list blobs of container `%s`: %w", c.container, err)
+		}
+
+		var result azureEnumerationResults
+		err = xml.NewDecoder(body).Decode(&result)
+		_ = body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode blobs of container `%s`: %w", c.container, err)
+		}
+
+		for _, blob := range result.Blobs.Blob {
+			objects = append(objects, Object{Key: blob.Name, ETag: strings.Trim(blob.Properties.ETag, `"`)})
+		}
+
+		if result.NextMarker == "" {
+			return objects, nil
+		}
+
+		marker = result.NextMarker
+	}
+}
+
+func (c *azureClient) GetObject(ctx context.Context, key string) (io.ReadCloser, error) {
+	return c.get(ctx, key, url.Values{})
+}
+
+func (c *azureClient) get(ctx context.Context, key string, query url.Values) (io.ReadCloser, error) {
+	u := *c.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + c.container
+	if key != "" {
+		u.Path += "/" + key
+	}
+	u.RawPath = ""
+
+	for name, values := range c.sas {
+		query[name] = values
+	}
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("x-ms-version", azureVersion)
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+
+	switch {
+	case c.accountKey != nil:
+		req.Header.Set("Authorization", "SharedKey "+c.account+":"+c.sharedKeySignature(req))
+	case c.credential != nil:
+		token, err := c.credential.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{azureScope}})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get azure token: %w", err)
+		}
+
+		req.Header.Set("Authorization", "Bearer "+token.Token)
+	}
+
+	return do(c.http, req)
+}
+
+// sharedKeySignature signs a request without body using the shared key of the storage account.
+// See https://learn.microsoft.com/en-us/rest/api/storageservices/authorize-with-shared-key.
+func (c *azureClient) sharedKeySignature(req *http.Request) string {
+	var headers []string
+	for name := range req.Header {
+		if name := strings.ToLower(name); strings.HasPrefix(name, "x-ms-") {
+			headers = append(headers, name)
+		}
+	}
+	sort.Strings(headers)
+
+	var b strings.Builder
+	// Verb followed by the empty standard headers from Content-Encoding to Range
+	b.WriteString(req.Method + "\n" + strings.Repeat("\n", 11))
+	for _, name := range headers {
+		b.WriteString(name + ":" + req.Header.Get(name) + "\n")
+	}
+
+	b.WriteString("/" + c.account + req.URL.EscapedPath())
+
+	query := req.URL.Query()
+	var params []string
+	for name := range query {
+		params = append(params, name)
+	}
+	sort.Strings(params)
+	for _, name := range params {
+		values := query[name]
+		sort.Strings(values)
+		b.WriteString("\n" + strings.ToLower(name) + ":" + strings.Join(values, ","))
+	}
+
+	mac := hmac.New(sha256.New, c.accountKey)
+	mac.Write([]byte(b.String()))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
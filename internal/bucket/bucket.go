@@ -0,0 +1,171 @@
+package bucket
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// The providers of the Bucket API.
+const (
+	ProviderGeneric = "generic"
+	ProviderAWS     = "aws"
+	ProviderGCP     = "gcp"
+	ProviderAzure   = "azure"
+)
+
+// Object is an object of a bucket.
+type Object struct {
+	Key  string
+	ETag string
+}
+
+// Client lists and downloads the objects of a bucket.
+type Client interface {
+	// ListObjects returns all objects whose key starts with prefix.
+	ListObjects(ctx context.Context, prefix string) ([]Object, error)
+	// GetObject returns the content of the object with the given key.
+	GetObject(ctx context.Context, key string) (io.ReadCloser, error)
+}
+
+// Options configure the access to a bucket, the fields correspond to the spec of a Bucket.
+type Options struct {
+	Provider   string
+	BucketName string
+	Endpoint   string
+	Region     string
+	// Insecure uses plain HTTP for endpoints without a scheme.
+	Insecure bool
+	// Secret holds the data of the secretRef.
+	// The ambient credentials of the provider (the environment, IRSA, workload identity or managed identity) are used if it is nil.
+	Secret map[string][]byte
+	// Transport is used for all requests, http.DefaultTransport is used if nil.
+	Transport http.RoundTripper
+}
+
+// NewClient returns the client of the configured provider.
+func NewClient(ctx context.Context, opts Options) (Client, error) {
+	if opts.Transport == nil {
+		opts.Transport = http.DefaultTransport
+	}
+
+	switch opts.Provider {
+	case "", ProviderGeneric, ProviderAWS:
+		return newS3Client(ctx, opts)
+	case ProviderGCP:
+		return newGCSClient(ctx, opts)
+	case ProviderAzure:
+		return newAzureClient(ctx, opts)
+	default:
+		return nil, fmt.Errorf("unsupported bucket provider `%s`", opts.Provider)
+	}
+}
+
+// Download downloads all objects whose key starts with prefix into dir and returns the revision of the downloaded objects.
+// The revision is the sha256 digest of the keys and etags, it changes with every modified object.
+func Download(ctx context.Context, client Client, prefix, dir string) (string, error) {
+	objects, err := client.ListObjects(ctx, prefix)
+	if err != nil {
+		return "", err
+	}
+
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Key < objects[j].Key
+	})
+
+	digest := sha256.New()
+	for _, object := range objects {
+		// Directory placeholders have no content
+		if strings.HasSuffix(object.Key, "/") {
+			continue
+		}
+
+		path := filepath.Join(dir, filepath.FromSlash(object.Key))
+		if !strings.HasPrefix(path, filepath.Clean(dir)+string(filepath.Separator)) {
+			return "", fmt.Errorf("object key `%s` points outside of the bucket", object.Key)
+		}
+
+		if err := download(ctx, client, object.Key, path); err != nil {
+			return "", fmt.Errorf("failed to download object `%s`: %w", object.Key, err)
+		}
+
+		fmt.Fprintf(digest, "%s %s\n", object.Key, object.ETag)
+	}
+
+	return hex.EncodeToString(digest.Sum(nil)), nil
+}
+
+func download(ctx context.Context, client Client, key, path string) error {
+	body, err := client.GetObject(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(f, body); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// endpointURL returns the base URL of the endpoint. Endpoints without a scheme use https unless insecure is set.
+func endpointURL(endpoint, fallback string, insecure bool) (*url.URL, error) {
+	if endpoint == "" {
+		endpoint = fallback
+	}
+
+	if !strings.Contains(endpoint, "://") {
+		scheme := "https"
+		if insecure {
+			scheme = "http"
+		}
+
+		endpoint = scheme + "://" + endpoint
+	}
+
+	u, err := url.Parse(strings.TrimSuffix(endpoint, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid bucket endpoint `%s`: %w", endpoint, err)
+	}
+
+	return u, nil
+}
+
+// do sends the request and returns the body of a successful response.
+func do(client *http.Client, req *http.Request) (io.ReadCloser, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+
+		// The query is omitted as it may hold a SAS token
+		u := *req.URL
+		u.RawQuery = ""
+		return nil, fmt.Errorf("%s %s: %s: %s", req.Method, u.Redacted(), resp.Status, strings.TrimSpace(string(b)))
+	}
+
+	return resp.Body, nil
+}
@@ -0,0 +1,409 @@
+package bucket
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	. "github.com/onsi/gomega"
+	"golang.org/x/oauth2"
+)
+
+var testObjects = map[string]string{
+	"charts/podinfo/Chart.yaml":  "name: podinfo\n",
+	"charts/podinfo/values.yaml": "replicaCount: 1\n",
+	"charts/podinfo/":            "",
+	"other/readme.md":            "other\n",
+}
+
+// objectKeys returns the keys of the test objects starting with prefix.
+func objectKeys(prefix string) []string {
+	var keys []string
+	for key := range testObjects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// newS3Server serves the test objects as an S3 bucket `charts`. Every list response holds a single object to test the pagination.
+// If creds is set the SigV4 signature of each request is verified.
+func newS3Server(t *testing.T, creds *aws.Credentials, region string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if creds != nil {
+			signed := r.Clone(context.Background())
+			signed.URL.Scheme, signed.URL.Host = "http", r.Host
+			// Headers added by the transport after signing (like Accept-Encoding) are not signed
+			_, signedHeaders, _ := strings.Cut(r.Header.Get("Authorization"), "SignedHeaders=")
+			signedHeaders, _, _ = strings.Cut(signedHeaders, ",")
+			signed.Header = http.Header{}
+			for _, name := range strings.Split(signedHeaders, ";") {
+				if value := r.Header.Get(name); value != "" {
+					signed.Header.Set(name, value)
+				}
+			}
+
+			signingTime, err := time.Parse("20060102T150405Z", r.Header.Get("X-Amz-Date"))
+			if err != nil {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+
+			signer := v4.NewSigner(func(o *v4.SignerOptions) { o.DisableURIPathEscaping = true })
+			if err := signer.SignHTTP(context.Background(), *creds, signed, r.Header.Get("X-Amz-Content-Sha256"), "s3", region, signingTime); err != nil ||
+				signed.Header.Get("Authorization") != r.Header.Get("Authorization") {
+				w.WriteHeader(http.StatusForbidden)
+				_, _ = w.Write([]byte("<Error><Code>SignatureDoesNotMatch</Code></Error>"))
+				return
+			}
+		}
+
+		key, ok := strings.CutPrefix(r.URL.Path, "/charts")
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if key == "" || key == "/" {
+			keys := objectKeys(r.URL.Query().Get("prefix"))
+			start := 0
+			if token := r.URL.Query().Get("continuation-token"); token != "" {
+				start = sort.SearchStrings(keys, token)
+			}
+
+			var result listBucketResult
+			result.Contents = append(result.Contents, struct {
+				Key  string `xml:"Key"`
+				ETag string `xml:"ETag"`
+			}{Key: keys[start], ETag: `"etag"`})
+			if start+1 < len(keys) {
+				result.IsTruncated = true
+				result.NextContinuationToken = keys[start+1]
+			}
+
+			_ = xml.NewEncoder(w).Encode(result)
+			return
+		}
+
+		content, ok := testObjects[strings.TrimPrefix(key, "/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		_, _ = w.Write([]byte(content))
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+// newGCSServer serves the test objects as a Google Cloud Storage bucket `charts` which requires the given bearer token.
+func newGCSServer(t *testing.T, token string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		path := r.URL.EscapedPath()
+		if path == "/storage/v1/b/charts/o" {
+			var result gcsObjects
+			for _, key := range objectKeys(r.URL.Query().Get("prefix")) {
+				result.Items = append(result.Items, struct {
+					Name string `json:"name"`
+					ETag string `json:"etag"`
+				}{Name: key, ETag: "etag"})
+			}
+
+			_ = json.NewEncoder(w).Encode(result)
+			return
+		}
+
+		// Slashes within the object name are escaped
+		name, ok := strings.CutPrefix(path, "/storage/v1/b/charts/o/")
+		if !ok || strings.Contains(name, "/") || r.URL.Query().Get("alt") != "media" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		name, _ = url.PathUnescape(name)
+		content, ok := testObjects[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		_, _ = w.Write([]byte(content))
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+// newAzureServer serves the test objects as the Azure Blob Storage container `charts`.
+// authorize validates the authentication of each request.
+func newAzureServer(t *testing.T, authorize func(r *http.Request) bool) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("x-ms-version") == "" || !authorize(r) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		key, ok := strings.CutPrefix(r.URL.Path, "/charts")
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if key == "" && r.URL.Query().Get("comp") == "list" {
+			var result azureEnumerationResults
+			for _, key := range objectKeys(r.URL.Query().Get("prefix")) {
+				blob := struct {
+					Name       string `xml:"Name"`
+					Properties struct {
+						ETag string `xml:"Etag"`
+					} `xml:"Properties"`
+				}{Name: key}
+				blob.Properties.ETag = "0x8D"
+				result.Blobs.Blob = append(result.Blobs.Blob, blob)
+			}
+
+			_ = xml.NewEncoder(w).Encode(result)
+			return
+		}
+
+		content, ok := testObjects[strings.TrimPrefix(key, "/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		_, _ = w.Write([]byte(content))
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+type fakeTokenCredential struct {
+	token string
+	err   error
+}
+
+func (f fakeTokenCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	if len(opts.Scopes) != 1 || opts.Scopes[0] != azureScope {
+		return azcore.AccessToken{}, errors.New("unexpected scopes")
+	}
+
+	return azcore.AccessToken{Token: f.token, ExpiresOn: time.Now().Add(time.Hour)}, f.err
+}
+
+func TestDownload(t *testing.T) {
+	staticCreds := aws.Credentials{AccessKeyID: "minio", SecretAccessKey: "minio123", Source: credentials.StaticCredentialsName}
+	ambientCreds := aws.Credentials{AccessKeyID: "AKIAAMBIENT", SecretAccessKey: "ambient", SessionToken: "session"}
+	accountKey := base64.StdEncoding.EncodeToString([]byte("account-key"))
+
+	// Fake the ambient credentials of the providers
+	defaultAWSCredentials, defaultGCPTokenSource, defaultAzureCredential := awsCredentials, gcpTokenSource, azureCredential
+	t.Cleanup(func() {
+		awsCredentials, gcpTokenSource, azureCredential = defaultAWSCredentials, defaultGCPTokenSource, defaultAzureCredential
+	})
+
+	awsCredentials = func(ctx context.Context, region string) (aws.CredentialsProvider, error) {
+		return credentials.NewStaticCredentialsProvider(ambientCreds.AccessKeyID, ambientCreds.SecretAccessKey, ambientCreds.SessionToken), nil
+	}
+	gcpTokenSource = func(ctx context.Context) (oauth2.TokenSource, error) {
+		return oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "gcp-token"}), nil
+	}
+	azureCredential = func() (azcore.TokenCredential, error) {
+		return fakeTokenCredential{token: "azure-token"}, nil
+	}
+
+	tests := []struct {
+		name      string
+		server    *httptest.Server
+		opts      Options
+		prefix    string
+		expectErr string
+	}{
+		{
+			name:   "generic with static credentials",
+			server: newS3Server(t, &staticCreds, "eu-central-1"),
+			opts: Options{
+				Provider: ProviderGeneric,
+				Region:   "eu-central-1",
+				Secret: map[string][]byte{
+					"accesskey": []byte("minio"),
+					"secretkey": []byte("minio123"),
+				},
+			},
+			prefix: "charts/",
+		},
+		{
+			name:   "generic with wrong credentials",
+			server: newS3Server(t, &staticCreds, defaultRegion),
+			opts: Options{
+				Provider: ProviderGeneric,
+				Secret: map[string][]byte{
+					"accesskey": []byte("minio"),
+					"secretkey": []byte("wrong"),
+				},
+			},
+			expectErr: "403 Forbidden",
+		},
+		{
+			name:      "generic with incomplete secret",
+			server:    newS3Server(t, nil, ""),
+			opts:      Options{Secret: map[string][]byte{"accesskey": []byte("minio")}},
+			expectErr: "bucket secret requires the keys accesskey and secretkey",
+		},
+		{
+			name:   "generic anonymous",
+			server: newS3Server(t, nil, ""),
+			prefix: "charts/",
+		},
+		{
+			name:   "aws ambient credentials",
+			server: newS3Server(t, &ambientCreds, "us-west-2"),
+			opts: Options{
+				Provider: ProviderAWS,
+				Region:   "us-west-2",
+			},
+			prefix: "charts/",
+		},
+		{
+			name:   "gcp ambient credentials",
+			server: newGCSServer(t, "gcp-token"),
+			opts: Options{
+				Provider: ProviderGCP,
+			},
+			prefix: "charts/",
+		},
+		{
+			name:   "azure ambient credentials",
+			server: newAzureServer(t, func(r *http.Request) bool { return r.Header.Get("Authorization") == "Bearer azure-token" }),
+			opts: Options{
+				Provider: ProviderAzure,
+			},
+			prefix: "charts/",
+		},
+		{
+			name:   "azure sas key",
+			server: newAzureServer(t, func(r *http.Request) bool { return r.URL.Query().Get("sig") == "signature" }),
+			opts: Options{
+				Provider: ProviderAzure,
+				Secret:   map[string][]byte{"sasKey": []byte("?sv=2021-08-06&sig=signature")},
+			},
+			prefix: "charts/",
+		},
+		{
+			name: "azure account key",
+			server: newAzureServer(t, func(r *http.Request) bool {
+				return strings.HasPrefix(r.Header.Get("Authorization"), "SharedKey 127:")
+			}),
+			opts: Options{
+				Provider: ProviderAzure,
+				Secret:   map[string][]byte{"accountKey": []byte(accountKey)},
+			},
+			prefix: "charts/",
+		},
+		{
+			name:      "azure secret without credentials",
+			server:    newAzureServer(t, func(r *http.Request) bool { return true }),
+			opts:      Options{Provider: ProviderAzure, Secret: map[string][]byte{}},
+			expectErr: "bucket secret requires one of the keys accountKey, sasKey, clientSecret or clientId",
+		},
+		{
+			name:      "unsupported provider",
+			server:    newS3Server(t, nil, ""),
+			opts:      Options{Provider: "ibm"},
+			expectErr: "unsupported bucket provider `ibm`",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			// The endpoint has no scheme, insecure selects plain http like for on-prem stores
+			opts := tt.opts
+			opts.BucketName = "charts"
+			opts.Endpoint = strings.TrimPrefix(tt.server.URL, "http://")
+			opts.Insecure = true
+
+			dir := t.TempDir()
+			client, err := NewClient(context.Background(), opts)
+			if err == nil {
+				_, err = Download(context.Background(), client, tt.prefix, dir)
+			}
+
+			if tt.expectErr != "" {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tt.expectErr))
+				return
+			}
+
+			g.Expect(err).ToNot(HaveOccurred())
+			for _, key := range objectKeys("") {
+				path := filepath.Join(dir, key)
+				if !strings.HasPrefix(key, tt.prefix) {
+					g.Expect(path).ToNot(BeAnExistingFile())
+					continue
+				}
+
+				if strings.HasSuffix(key, "/") {
+					continue
+				}
+
+				g.Expect(os.ReadFile(path)).To(Equal([]byte(testObjects[key])))
+			}
+		})
+	}
+}
+
+type fakeClient []Object
+
+func (f fakeClient) ListObjects(ctx context.Context, prefix string) ([]Object, error) {
+	return f, nil
+}
+
+func (f fakeClient) GetObject(ctx context.Context, key string) (io.ReadCloser, error) {
+	return io.NopCloser(strings.NewReader(key)), nil
+}
+
+func TestDownloadRevision(t *testing.T) {
+	g := NewWithT(t)
+
+	revision, err := Download(context.Background(), fakeClient{{Key: "b", ETag: "1"}, {Key: "a", ETag: "1"}}, "", t.TempDir())
+	g.Expect(err).ToNot(HaveOccurred())
+
+	sameRevision, err := Download(context.Background(), fakeClient{{Key: "a", ETag: "1"}, {Key: "b", ETag: "1"}}, "", t.TempDir())
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(sameRevision).To(Equal(revision))
+
+	modifiedRevision, err := Download(context.Background(), fakeClient{{Key: "a", ETag: "1"}, {Key: "b", ETag: "2"}}, "", t.TempDir())
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(modifiedRevision).ToNot(Equal(revision))
+
+	_, err = Download(context.Background(), fakeClient{{Key: "../escape"}}, "", t.TempDir())
+	g.Expect(err).To(MatchError("object key `../escape` points outside of the bucket"))
+}
@@ -0,0 +1,129 @@
+package bucket
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// gcsScope is the OAuth2 scope required to read objects.
+const gcsScope = "https://www.googleapis.com/auth/devstorage.read_only"
+
+// gcpTokenSource returns the ambient GCP credentials (GOOGLE_APPLICATION_CREDENTIALS, gcloud or workload identity).
+var gcpTokenSource = func(ctx context.Context) (oauth2.TokenSource, error) {
+	return google.DefaultTokenSource(ctx, gcsScope)
+}
+
+// gcsClient accesses Google Cloud Storage using the JSON API.
+type gcsClient struct {
+	http     *http.Client
+	endpoint *url.URL
+	bucket   string
+}
+
+func newGCSClient(ctx context.Context, opts Options) (*gcsClient, error) {
+	endpoint, err := endpointURL(opts.Endpoint, "storage.googleapis.com", opts.Insecure)
+	if err != nil {
+		return nil, err
+	}
+
+	var tokenSource oauth2.TokenSource
+	if opts.Secret != nil {
+		serviceAccount := opts.Secret["serviceaccount"]
+		if len(serviceAccount) == 0 {
+			return nil, fmt.Errorf("bucket secret requires the key serviceaccount")
+		}
+
+		creds, err := google.CredentialsFromJSON(ctx, serviceAccount, gcsScope)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gcp service account: %w", err)
+		}
+
+		tokenSource = creds.TokenSource
+	} else {
+		tokenSource, err = gcpTokenSource(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load gcp credentials: %w", err)
+		}
+	}
+
+	return &gcsClient{
+		http: &http.Client{Transport: &oauth2.Transport{
+			Source: tokenSource,
+			Base:   opts.Transport,
+		}},
+		endpoint: endpoint,
+		bucket:   opts.BucketName,
+	}, nil
+}
+
+type gcsObjects struct {
+	Items []struct {
+		Name string `json:"name"`
+		ETag string `json:"etag"`
+	} `json:"items"`
+	NextPageToken string `json:"nextPageToken"`
+}
+
+func (c *gcsClient) ListObjects(ctx context.Context, prefix string) ([]Object, error) {
+	var objects []Object
+	var token string
+
+	for {
+		query := url.Values{
+			"prefix": {prefix},
+			"fields": {"items(name,etag),nextPageToken"},
+		}
+		if token != "" {
+			query.Set("pageToken", token)
+		}
+
+		body, err := c.get(ctx, "/storage/v1/b/"+url.PathEscape(c.bucket)+"/o", query)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects of bucket `%s`: %w", c.bucket, err)
+		}
+
+		var result gcsObjects
+		err = json.NewDecoder(body).Decode(&result)
+		_ = body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode objects of bucket `%s`: %w", c.bucket, err)
+		}
+
+		for _, item := range result.Items {
+			objects = append(objects, Object{Key: item.Name, ETag: item.ETag})
+		}
+
+		if result.NextPageToken == "" {
+			return objects, nil
+		}
+
+		token = result.NextPageToken
+	}
+}
+
+func (c *gcsClient) GetObject(ctx context.Context, key string) (io.ReadCloser, error) {
+	// Object names are a single path segment of the JSON API, slashes must be escaped
+	return c.get(ctx, "/storage/v1/b/"+url.PathEscape(c.bucket)+"/o/"+url.PathEscape(key), url.Values{"alt": {"media"}})
+}
+
+func (c *gcsClient) get(ctx context.Context, escapedPath string, query url.Values) (io.ReadCloser, error) {
+	u, err := url.Parse(c.endpoint.String() + escapedPath)
+	if err != nil {
+		return nil, err
+	}
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return do(c.http, req)
+}
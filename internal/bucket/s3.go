@@ -0,0 +1,163 @@
+package bucket
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+)
+
+// defaultRegion is used for signing if the Bucket has no region, S3-compatible stores usually accept it.
+const defaultRegion = "us-east-1"
+
+// emptyPayloadHash is the sha256 of an empty request body.
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// awsCredentials returns the ambient AWS credentials (environment, shared config, IRSA or the instance metadata).
+var awsCredentials = func(ctx context.Context, region string) (aws.CredentialsProvider, error) {
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, awsconfig.WithRegion(region))
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg.Credentials, nil
+}
+
+// s3Client accesses S3 and S3-compatible stores using path-style requests signed with SigV4.
+type s3Client struct {
+	http     *http.Client
+	endpoint *url.URL
+	bucket   string
+	region   string
+	// credentials is nil for anonymous access
+	credentials aws.CredentialsProvider
+	signer      *v4.Signer
+}
+
+func newS3Client(ctx context.Context, opts Options) (*s3Client, error) {
+	endpoint, err := endpointURL(opts.Endpoint, "s3.amazonaws.com", opts.Insecure)
+	if err != nil {
+		return nil, err
+	}
+
+	region := opts.Region
+	if region == "" {
+		region = defaultRegion
+	}
+
+	c := &s3Client{
+		http:     &http.Client{Transport: opts.Transport},
+		endpoint: endpoint,
+		bucket:   opts.BucketName,
+		region:   region,
+		signer: v4.NewSigner(func(o *v4.SignerOptions) {
+			// Object keys are escaped once, S3 does not double escape the path
+			o.DisableURIPathEscaping = true
+		}),
+	}
+
+	switch {
+	case opts.Secret != nil:
+		accessKey, secretKey := string(opts.Secret["accesskey"]), string(opts.Secret["secretkey"])
+		if accessKey == "" || secretKey == "" {
+			return nil, fmt.Errorf("bucket secret requires the keys accesskey and secretkey")
+		}
+
+		c.credentials = credentials.NewStaticCredentialsProvider(accessKey, secretKey, "")
+	case opts.Provider == ProviderAWS:
+		c.credentials, err = awsCredentials(ctx, region)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load aws credentials: %w", err)
+		}
+	}
+
+	return c, nil
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key  string `xml:"Key"`
+		ETag string `xml:"ETag"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (c *s3Client) ListObjects(ctx context.Context, prefix string) ([]Object, error) {
+	var objects []Object
+	var token string
+
+	for {
+		query := url.Values{
+			"list-type": {"2"},
+			"prefix":    {prefix},
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		body, err := c.get(ctx, "", query)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects of bucket `%s`: %w", c.bucket, err)
+		}
+
+		var result listBucketResult
+		err = xml.NewDecoder(body).Decode(&result)
+		_ = body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode objects of bucket `%s`: %w", c.bucket, err)
+		}
+
+		for _, object := range result.Contents {
+			objects = append(objects, Object{Key: object.Key, ETag: strings.Trim(object.ETag, `"`)})
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+
+		token = result.NextContinuationToken
+	}
+}
+
+func (c *s3Client) GetObject(ctx context.Context, key string) (io.ReadCloser, error) {
+	return c.get(ctx, key, nil)
+}
+
+func (c *s3Client) get(ctx context.Context, key string, query url.Values) (io.ReadCloser, error) {
+	u := *c.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + c.bucket
+	if key != "" {
+		u.Path += "/" + key
+	}
+	u.RawPath = ""
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.credentials != nil {
+		creds, err := c.credentials.Retrieve(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve credentials: %w", err)
+		}
+
+		req.Header.Set("X-Amz-Content-Sha256", emptyPayloadHash)
+		if err := c.signer.SignHTTP(ctx, creds, req, emptyPayloadHash, "s3", c.region, time.Now()); err != nil {
+			return nil, fmt.Errorf("failed to sign request: %w", err)
+		}
+	}
+
+	return do(c.http, req)
+}
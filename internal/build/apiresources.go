@@ -0,0 +1,94 @@
+package build
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/doodlescheduling/flux-build/internal/helm/postrenderer"
+	sigsyaml "sigs.k8s.io/yaml"
+)
+
+// LoadAPIResources reads the scopes of kinds from either the output of kubectl api-resources (optionally -o wide)
+// or a YAML list of apiVersion, kind and namespaced.
+func LoadAPIResources(path string) ([]postrenderer.APIResource, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var resources []postrenderer.APIResource
+	if header := firstLine(b); strings.HasPrefix(header, "NAME ") {
+		resources, err = parseAPIResourcesTable(b)
+	} else {
+		err = sigsyaml.UnmarshalStrict(b, &resources)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid api resources in %s: %w", path, err)
+	}
+
+	for i, r := range resources {
+		if r.APIVersion == "" || r.Kind == "" {
+			return nil, fmt.Errorf("invalid api resource %d in %s: apiVersion and kind are required", i, path)
+		}
+	}
+
+	return resources, nil
+}
+
+// parseAPIResourcesTable parses the table printed by kubectl api-resources. The columns are located by the header
+// as the SHORTNAMES column is empty for most resources.
+func parseAPIResourcesTable(b []byte) ([]postrenderer.APIResource, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(bytes.TrimSpace(b)))
+	scanner.Scan()
+	header := scanner.Text()
+
+	apiVersionColumn := strings.Index(header, "APIVERSION")
+	namespacedColumn := strings.Index(header, "NAMESPACED")
+	kindColumn := strings.Index(header, "KIND")
+	if apiVersionColumn == -1 || namespacedColumn == -1 || kindColumn == -1 {
+		return nil, fmt.Errorf("the APIVERSION, NAMESPACED and KIND columns are required")
+	}
+
+	column := func(line string, i int) string {
+		if i >= len(line) {
+			return ""
+		}
+
+		if fields := strings.Fields(line[i:]); len(fields) > 0 {
+			return fields[0]
+		}
+
+		return ""
+	}
+
+	var resources []postrenderer.APIResource
+	for n := 2; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		namespaced, err := strconv.ParseBool(column(line, namespacedColumn))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid NAMESPACED column: %w", n, err)
+		}
+
+		resources = append(resources, postrenderer.APIResource{
+			APIVersion: column(line, apiVersionColumn),
+			Kind:       column(line, kindColumn),
+			Namespaced: namespaced,
+		})
+	}
+
+	return resources, scanner.Err()
+}
+
+func firstLine(b []byte) string {
+	line, _, _ := bytes.Cut(bytes.TrimSpace(b), []byte("\n"))
+	return string(line)
+}
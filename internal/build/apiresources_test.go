@@ -0,0 +1,93 @@
+package build
+
+import (
+	"testing"
+
+	"github.com/doodlescheduling/flux-build/internal/helm/postrenderer"
+	. "github.com/onsi/gomega"
+)
+
+func TestLoadAPIResources(t *testing.T) {
+	tests := []struct {
+		name      string
+		file      string
+		expect    []postrenderer.APIResource
+		expectErr string
+	}{
+		{
+			name: "kubectl api-resources -o wide",
+			file: `NAME                              SHORTNAMES   APIVERSION                        NAMESPACED   KIND                             VERBS                                                        CATEGORIES
+configmaps                        cm           v1                                true         ConfigMap                        create,delete,deletecollection,get,list,patch,update,watch
+namespaces                        ns           v1                                false        Namespace                        create,delete,get,list,patch,update,watch
+clusterissuers                                 cert-manager.io/v1                false        ClusterIssuer                    delete,deletecollection,get,list,patch,create,update,watch   cert-manager
+issuers                                        cert-manager.io/v1                true         Issuer                           delete,deletecollection,get,list,patch,create,update,watch   cert-manager
+`,
+			expect: []postrenderer.APIResource{
+				{APIVersion: "v1", Kind: "ConfigMap", Namespaced: true},
+				{APIVersion: "v1", Kind: "Namespace"},
+				{APIVersion: "cert-manager.io/v1", Kind: "ClusterIssuer"},
+				{APIVersion: "cert-manager.io/v1", Kind: "Issuer", Namespaced: true},
+			},
+		},
+		{
+			name: "kubectl api-resources",
+			file: `NAME             SHORTNAMES   APIVERSION           NAMESPACED   KIND
+clusterissuers                cert-manager.io/v1   false        ClusterIssuer
+`,
+			expect: []postrenderer.APIResource{
+				{APIVersion: "cert-manager.io/v1", Kind: "ClusterIssuer"},
+			},
+		},
+		{
+			name: "yaml list",
+			file: `- apiVersion: cert-manager.io/v1
+  kind: ClusterIssuer
+  namespaced: false
+- apiVersion: cert-manager.io/v1
+  kind: Issuer
+  namespaced: true
+`,
+			expect: []postrenderer.APIResource{
+				{APIVersion: "cert-manager.io/v1", Kind: "ClusterIssuer"},
+				{APIVersion: "cert-manager.io/v1", Kind: "Issuer", Namespaced: true},
+			},
+		},
+		{
+			name: "kubectl api-resources without apiVersion",
+			file: `NAME             SHORTNAMES   APIGROUP          NAMESPACED   KIND
+clusterissuers                cert-manager.io   false        ClusterIssuer
+`,
+			expectErr: "the APIVERSION, NAMESPACED and KIND columns are required",
+		},
+		{
+			name: "yaml list without kind",
+			file: `- apiVersion: cert-manager.io/v1
+  namespaced: false
+`,
+			expectErr: "apiVersion and kind are required",
+		},
+		{
+			name: "unknown field",
+			file: `- apiVersion: cert-manager.io/v1
+  kind: ClusterIssuer
+  scope: Cluster
+`,
+			expectErr: "unknown field \"scope\"",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			resources, err := LoadAPIResources(writeValuesFile(t, "api-resources", tt.file))
+			if tt.expectErr != "" {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tt.expectErr))
+				return
+			}
+
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(resources).To(Equal(tt.expect))
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/doodlescheduling/flux-build/internal/bucket"
+	"github.com/doodlescheduling/flux-build/internal/helm/chart"
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	sourcev1beta2 "github.com/fluxcd/source-controller/api/v1beta2"
+	"sigs.k8s.io/kustomize/api/resource"
+	"sigs.k8s.io/yaml"
+)
+
+// buildFromBucket packages the chart directory of a HelmRelease referencing a Bucket.
+// Values files are resolved relative to the chart directory or the bucket root and merged into the packaged chart.
+func (h *Helm) buildFromBucket(ctx context.Context, hr *helmv2.HelmRelease, source *resource.Resource, db map[ref]*resource.Resource) (*chart.Build, error) {
+	b, err := source.AsYAML()
+	if err != nil {
+		return nil, fmt.Errorf("failed marshal bucket as yaml: %w", err)
+	}
+
+	obj := &sourcev1beta2.Bucket{}
+	if err := yaml.Unmarshal(b, obj); err != nil {
+		return nil, fmt.Errorf("failed to decode into bucket: %w", err)
+	}
+
+	checkout, err := h.checkoutBucket(ctx, obj, db)
+	if err != nil {
+		return nil, err
+	}
+
+	return h.buildFromCheckout(ctx, hr, "bucket", obj, checkout, db)
+}
+
+// checkoutBucket downloads the objects of the Bucket once per run.
+func (h *Helm) checkoutBucket(ctx context.Context, obj *sourcev1beta2.Bucket, db map[ref]*resource.Resource) (*sourceCheckout, error) {
+	var secretName string
+	if obj.Spec.SecretRef != nil {
+		secretName = obj.Spec.SecretRef.Name
+	}
+
+	var ignore string
+	if obj.Spec.Ignore != nil {
+		ignore = *obj.Spec.Ignore
+	}
+
+	key := strings.Join([]string{"bucket", obj.Spec.Provider, obj.Spec.Endpoint, obj.Spec.BucketName, obj.Spec.Region, obj.Spec.Prefix, obj.Namespace, secretName, ignore}, "\x00")
+	v, _ := h.checkouts.LoadOrStore(key, &sourceCheckout{})
+	checkout := v.(*sourceCheckout)
+
+	checkout.once.Do(func() {
+		checkout.dir, checkout.revision, checkout.err = h.downloadBucket(ctx, obj, db)
+	})
+
+	return checkout, checkout.err
+}
+
+func (h *Helm) downloadBucket(ctx context.Context, obj *sourcev1beta2.Bucket, db map[ref]*resource.Resource) (string, string, error) {
+	ctx, cancel := withTimeout(ctx, h.repositoryTimeout(obj, obj.Spec.Endpoint))
+	defer cancel()
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if h.proxy != nil {
+		transport.Proxy = h.proxy
+	}
+
+	opts := bucket.Options{
+		Provider:   obj.Spec.Provider,
+		BucketName: obj.Spec.BucketName,
+		Endpoint:   obj.Spec.Endpoint,
+		Region:     obj.Spec.Region,
+		Insecure:   obj.Spec.Insecure,
+		Transport:  transport,
+	}
+
+	// Without a secret the ambient credentials of the provider are used
+	if obj.Spec.SecretRef != nil {
+		secret, lookupRef, err := h.getSecret(obj.Spec.SecretRef.Name, obj.Namespace, db)
+		if err != nil {
+			return "", "", err
+		}
+
+		if secret == nil {
+			return "", "", fmt.Errorf("no secret `%v` found for bucket %s/%s", lookupRef, obj.Namespace, obj.Name)
+		}
+
+		opts.Secret = secret.Data
+	}
+
+	client, err := bucket.NewClient(ctx, opts)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to configure bucket %s/%s: %w", obj.Namespace, obj.Name, err)
+	}
+
+	dir, err := os.MkdirTemp("", "bucket")
+	if err != nil {
+		return "", "", err
+	}
+
+	root := filepath.Join(dir, "source")
+	if err := os.Mkdir(root, 0700); err != nil {
+		return dir, "", err
+	}
+
+	var revision string
+	err = h.retry(ctx, "download bucket", func() error {
+		revision, err = bucket.Download(ctx, client, obj.Spec.Prefix, root)
+		return err
+	})
+	if err != nil {
+		return dir, "", fmt.Errorf("failed to download bucket %s/%s: %w", obj.Namespace, obj.Name, err)
+	}
+
+	h.logger(ctx).Info("downloaded bucket", "bucket", obj.Namespace+"/"+obj.Name, "revision", revision)
+
+	// Unlike a GitRepository a Bucket has no default ignore patterns
+	ignore := obj.Spec.Ignore
+	if ignore == nil {
+		ignore = new(string)
+	}
+
+	if err := applyIgnore(root, ignore); err != nil {
+		return dir, "", fmt.Errorf("failed to apply ignore patterns of bucket %s/%s: %w", obj.Namespace, obj.Name, err)
+	}
+
+	return dir, revision, nil
+}
@@ -0,0 +1,199 @@
+package build
+
+import (
+	"context"
+	"encoding/xml"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"github.com/go-logr/logr"
+	. "github.com/onsi/gomega"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// newBucketServer serves the test chart in charts/helmchart and a values file as the S3 bucket `charts`.
+// Requests without the access key minio are rejected.
+func newBucketServer(t *testing.T) *httptest.Server {
+	objects := map[string][]byte{
+		"values/prod.yaml":                        []byte("replicaCount: 3\n"),
+		"charts/helmchart/templates/ignored.yaml": []byte("{{ fail \"ignored\" }}"),
+	}
+
+	root := "../helm/testdata/charts/helmchart"
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		objects["charts/helmchart/"+filepath.ToSlash(rel)], err = os.ReadFile(path)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Authorization"), "Credential=minio/") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		if r.URL.Path == "/charts" {
+			type object struct {
+				Key string `xml:"Key"`
+			}
+			var result struct {
+				XMLName  xml.Name `xml:"ListBucketResult"`
+				Contents []object `xml:"Contents"`
+			}
+			for key := range objects {
+				if strings.HasPrefix(key, r.URL.Query().Get("prefix")) {
+					result.Contents = append(result.Contents, object{Key: key})
+				}
+			}
+
+			_ = xml.NewEncoder(w).Encode(result)
+			return
+		}
+
+		content, ok := objects[strings.TrimPrefix(r.URL.Path, "/charts/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		_, _ = w.Write(content)
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestBuildFromBucket(t *testing.T) {
+	server := newBucketServer(t)
+
+	newBucket := func(spec string) string {
+		return `apiVersion: source.toolkit.fluxcd.io/v1beta2
+kind: Bucket
+metadata:
+  name: charts
+  namespace: default
+spec:
+  provider: generic
+  bucketName: charts
+  endpoint: ` + strings.TrimPrefix(server.URL, "http://") + `
+  insecure: true
+  secretRef:
+    name: minio
+` + spec + `
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: minio
+  namespace: default
+data:
+  accesskey: bWluaW8=
+  secretkey: bWluaW8xMjM=`
+	}
+
+	tests := []struct {
+		name          string
+		bucket        string
+		valuesFiles   []string
+		expectValues  map[string]interface{}
+		expectIgnored bool
+		expectErr     string
+	}{
+		{
+			name:   "static credentials",
+			bucket: newBucket(""),
+		},
+		{
+			name:          "values files relative to the bucket",
+			bucket:        newBucket("  ignore: |\n    /charts/helmchart/templates/ignored.yaml\n"),
+			valuesFiles:   []string{"charts/helmchart/values.yaml", "values/prod.yaml"},
+			expectIgnored: true,
+			expectValues: map[string]interface{}{
+				"replicaCount": float64(3),
+			},
+		},
+		{
+			name:      "prefix excludes the chart",
+			bucket:    newBucket("  prefix: values/\n"),
+			expectErr: "failed to build chart `charts/helmchart` from bucket default/charts",
+		},
+		{
+			name:      "missing secret",
+			bucket:    strings.Replace(newBucket(""), "secretRef:\n    name: minio", "secretRef:\n    name: does-not-exist", 1),
+			expectErr: "no secret",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			h := NewHelmBuilder(logr.Discard(), HelmOpts{})
+			defer h.Close()
+			db := newResourceIndex(t, tt.bucket)
+
+			hr := &helmv2.HelmRelease{
+				ObjectMeta: metav1.ObjectMeta{Name: "release", Namespace: "default"},
+				Spec: helmv2.HelmReleaseSpec{
+					Chart: &helmv2.HelmChartTemplate{
+						Spec: helmv2.HelmChartTemplateSpec{
+							Chart:       "charts/helmchart",
+							ValuesFiles: tt.valuesFiles,
+							SourceRef: helmv2.CrossNamespaceObjectReference{
+								Kind: "Bucket",
+								Name: "charts",
+							},
+						},
+					},
+				},
+			}
+
+			build, spec, err := h.resolveChart(context.Background(), hr, &chartVerification{}, db)
+			if tt.expectErr != "" {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tt.expectErr))
+				return
+			}
+
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(spec.ValuesFiles).To(BeEmpty())
+			g.Expect(build.Name).To(Equal("helmchart"))
+
+			c, err := loader.Load(build.Path)
+			g.Expect(err).ToNot(HaveOccurred())
+			var templates []string
+			for _, template := range c.Templates {
+				templates = append(templates, template.Name)
+			}
+			if tt.expectIgnored {
+				g.Expect(templates).ToNot(ContainElement("templates/ignored.yaml"))
+			} else {
+				g.Expect(templates).To(ContainElement("templates/ignored.yaml"))
+			}
+
+			for key, value := range tt.expectValues {
+				g.Expect(c.Values).To(HaveKeyWithValue(key, value))
+			}
+
+			g.Expect(h.Close()).To(Succeed())
+			g.Expect(build.Path).ToNot(BeAnExistingFile())
+		})
+	}
+}
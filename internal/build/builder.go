@@ -0,0 +1,82 @@
+package build
+
+import (
+	"context"
+	"fmt"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/kustomize/api/resmap"
+	"sigs.k8s.io/kustomize/api/resource"
+)
+
+// Builder builds a resource into the resources it renders, for instance a HelmRelease into the manifests of its
+// chart. db is the index of all resources the build may look up, for instance sources and values.
+type Builder interface {
+	Build(ctx context.Context, r *resource.Resource, db map[ref]*resource.Resource) (resmap.ResMap, error)
+}
+
+var (
+	_ Builder = &Helm{}
+	_ Builder = &Kustomization{}
+	_ Builder = &Dispatcher{}
+)
+
+var (
+	// HelmReleaseGroupKind is the group and kind of HelmReleases.
+	HelmReleaseGroupKind = schema.GroupKind{Group: helmv2.GroupVersion.Group, Kind: helmv2.HelmReleaseKind}
+	// KustomizationGroupKind is the group and kind of Flux Kustomizations.
+	KustomizationGroupKind = schema.GroupKind{Group: fluxKustomizationGroup, Kind: "Kustomization"}
+)
+
+// NoBuilderError is returned by a Dispatcher for resources of a kind no builder is registered for.
+type NoBuilderError struct {
+	// GroupKind of the resource.
+	GroupKind schema.GroupKind
+	// Resource is the namespace and name of the resource.
+	Resource string
+}
+
+func (e *NoBuilderError) Error() string {
+	return fmt.Sprintf("no builder registered for %s `%s`", e.GroupKind, e.Resource)
+}
+
+// Dispatcher builds every resource by the builder registered for its group and kind, the API version is ignored.
+type Dispatcher struct {
+	builders map[schema.GroupKind]Builder
+}
+
+// NewDispatcher returns a dispatcher without builders, see Register.
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{builders: make(map[schema.GroupKind]Builder)}
+}
+
+// Register sets the builder of resources of the group and kind, it replaces a builder registered before.
+// The dispatcher must not be used concurrently while builders are registered.
+func (d *Dispatcher) Register(gk schema.GroupKind, b Builder) *Dispatcher {
+	d.builders[gk] = b
+	return d
+}
+
+// Builds reports whether a builder is registered for the resource.
+func (d *Dispatcher) Builds(r *resource.Resource) bool {
+	_, ok := d.builders[groupKindOf(r)]
+	return ok
+}
+
+// Build builds the resource by the builder registered for its group and kind, it fails with a NoBuilderError if
+// there is none.
+func (d *Dispatcher) Build(ctx context.Context, r *resource.Resource, db map[ref]*resource.Resource) (resmap.ResMap, error) {
+	gk := groupKindOf(r)
+	b, ok := d.builders[gk]
+	if !ok {
+		return nil, &NoBuilderError{GroupKind: gk, Resource: r.GetNamespace() + "/" + r.GetName()}
+	}
+
+	return b.Build(ctx, r, db)
+}
+
+func groupKindOf(r *resource.Resource) schema.GroupKind {
+	gvk := r.GetGvk()
+	return schema.GroupKind{Group: gvk.Group, Kind: gvk.Kind}
+}
@@ -0,0 +1,70 @@
+package build
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"sigs.k8s.io/kustomize/api/resmap"
+	"sigs.k8s.io/kustomize/api/resource"
+)
+
+// kindBuilder records the names of the resources it builds.
+type kindBuilder struct {
+	built []string
+}
+
+func (b *kindBuilder) Build(ctx context.Context, r *resource.Resource, db map[ref]*resource.Resource) (resmap.ResMap, error) {
+	b.built = append(b.built, r.GetName())
+	return resmap.New(), nil
+}
+
+func TestDispatcher(t *testing.T) {
+	g := NewWithT(t)
+
+	resources := newResources(t, `apiVersion: helm.toolkit.fluxcd.io/v2beta1
+kind: HelmRelease
+metadata:
+  name: podinfo
+  namespace: apps
+---
+apiVersion: helm.toolkit.fluxcd.io/v2
+kind: HelmRelease
+metadata:
+  name: redis
+  namespace: apps
+---
+apiVersion: kustomize.toolkit.fluxcd.io/v1
+kind: Kustomization
+metadata:
+  name: apps
+  namespace: flux-system
+---
+apiVersion: kustomize.config.k8s.io/v1beta1
+kind: Kustomization
+metadata:
+  name: overlay
+  namespace: apps
+`)
+
+	helm, kustomization := &kindBuilder{}, &kindBuilder{}
+	d := NewDispatcher().
+		Register(HelmReleaseGroupKind, helm).
+		Register(KustomizationGroupKind, kustomization)
+
+	for _, r := range resources[:3] {
+		g.Expect(d.Builds(r)).To(BeTrue())
+		_, err := d.Build(context.Background(), r, nil)
+		g.Expect(err).ToNot(HaveOccurred())
+	}
+
+	g.Expect(helm.built).To(Equal([]string{"podinfo", "redis"}))
+	g.Expect(kustomization.built).To(Equal([]string{"apps"}))
+
+	g.Expect(d.Builds(resources[3])).To(BeFalse())
+	_, err := d.Build(context.Background(), resources[3], nil)
+	g.Expect(err).To(MatchError("no builder registered for Kustomization.kustomize.config.k8s.io `apps/overlay`"))
+	var noBuilder *NoBuilderError
+	g.Expect(errors.As(err, &noBuilder)).To(BeTrue())
+}
@@ -0,0 +1,62 @@
+package build
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// ControllerCompat toggles behaviour which differs between helm-controller versions.
+type ControllerCompat struct {
+	// CRDsPolicyOverridesSkipCRDs gives spec.install.crds precedence over the deprecated
+	// spec.install.skipCRDs field. If unset skipCRDs always skips CRDs.
+	CRDsPolicyOverridesSkipCRDs bool
+}
+
+// latestControllerVersion is the newest helm-controller minor version of controllerCompatMatrix.
+const latestControllerVersion = "1.0"
+
+// DefaultControllerCompat is used if no helm-controller version was selected, it matches the latest
+// helm-controller version.
+var DefaultControllerCompat = controllerCompatMatrix[latestControllerVersion]
+
+// controllerCompatMatrix holds the known behaviour of helm-controller minor versions.
+// helm-controller installs namespace-less resources into the release namespace and labels them with their origin
+// in every supported version, the versions only differ in how they treat the deprecated spec.install.skipCRDs.
+var controllerCompatMatrix = map[string]ControllerCompat{
+	// 0.37 still honours spec.install.skipCRDs regardless of spec.install.crds.
+	"0.37": {},
+	// 1.0 ignores spec.install.skipCRDs as soon as spec.install.crds is set.
+	"1.0": {
+		CRDsPolicyOverridesSkipCRDs: true,
+	},
+}
+
+// ControllerCompatFor returns the ControllerCompat for the given helm-controller version.
+// Patch versions and a leading v are accepted, only the minor version is taken into account.
+// An empty version returns DefaultControllerCompat.
+func ControllerCompatFor(version string) (ControllerCompat, error) {
+	if version == "" {
+		return DefaultControllerCompat, nil
+	}
+
+	var supported []string
+	for v := range controllerCompatMatrix {
+		supported = append(supported, v)
+	}
+	slices.Sort(supported)
+
+	v, err := semver.NewVersion(version)
+	if err != nil {
+		return ControllerCompat{}, fmt.Errorf("invalid helm-controller version `%s`, supported versions are %s", version, strings.Join(supported, ", "))
+	}
+
+	compat, ok := controllerCompatMatrix[fmt.Sprintf("%d.%d", v.Major(), v.Minor())]
+	if !ok {
+		return ControllerCompat{}, fmt.Errorf("unsupported helm-controller version `%s`, supported versions are %s", version, strings.Join(supported, ", "))
+	}
+
+	return compat, nil
+}
@@ -0,0 +1,190 @@
+package build
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/Masterminds/semver/v3"
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"github.com/go-logr/logr"
+	. "github.com/onsi/gomega"
+	helmchart "helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/kustomize/api/provider"
+	"sigs.k8s.io/kustomize/api/resmap"
+)
+
+func TestControllerCompatFor(t *testing.T) {
+	tests := []struct {
+		name      string
+		version   string
+		expect    ControllerCompat
+		expectErr string
+	}{
+		{
+			name:    "default is the latest version",
+			version: "",
+			expect:  controllerCompatMatrix["1.0"],
+		},
+		{
+			name:    "minor version",
+			version: "0.37",
+			expect:  controllerCompatMatrix["0.37"],
+		},
+		{
+			name:    "patch version with prefix",
+			version: "v1.0.1",
+			expect:  controllerCompatMatrix["1.0"],
+		},
+		{
+			name:      "unknown version",
+			version:   "0.12",
+			expectErr: "unsupported helm-controller version `0.12`, supported versions are 0.37, 1.0",
+		},
+		{
+			name:      "invalid version",
+			version:   "latest",
+			expectErr: "invalid helm-controller version `latest`, supported versions are 0.37, 1.0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			compat, err := ControllerCompatFor(tt.version)
+			if tt.expectErr != "" {
+				g.Expect(err).To(MatchError(tt.expectErr))
+				return
+			}
+
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(compat).To(Equal(tt.expect))
+		})
+	}
+}
+
+func TestLatestControllerVersion(t *testing.T) {
+	g := NewWithT(t)
+
+	var versions []*semver.Version
+	for v := range controllerCompatMatrix {
+		versions = append(versions, semver.MustParse(v))
+	}
+
+	latest := slices.MaxFunc(versions, func(a, b *semver.Version) int {
+		return a.Compare(b)
+	})
+	g.Expect(latestControllerVersion).To(Equal(latest.Original()))
+	g.Expect(DefaultControllerCompat).To(Equal(controllerCompatMatrix[latestControllerVersion]))
+}
+
+func TestControllerCompatRender(t *testing.T) {
+	compatChart := &helmchart.Chart{
+		Metadata: &helmchart.Metadata{
+			APIVersion: helmchart.APIVersionV2,
+			Name:       "compat",
+			Version:    "1.0.0",
+		},
+		Templates: []*helmchart.File{
+			{
+				Name: "templates/configmap.yaml",
+				Data: []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: compat
+`),
+			},
+		},
+		Files: []*helmchart.File{
+			{
+				Name: "crds/crd.yaml",
+				Data: []byte(`apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: examples.example.com
+`),
+			},
+		},
+	}
+
+	installs := []struct {
+		name    string
+		install *helmv2.Install
+	}{
+		{name: "no install spec"},
+		{name: "skipCRDs", install: &helmv2.Install{SkipCRDs: true}},
+		{name: "crds Skip", install: &helmv2.Install{CRDs: helmv2.Skip}},
+		{name: "skipCRDs with crds Create", install: &helmv2.Install{SkipCRDs: true, CRDs: helmv2.Create}},
+	}
+
+	tests := []struct {
+		version    string
+		expectCRDs map[string]bool
+	}{
+		{
+			version: "0.37",
+			expectCRDs: map[string]bool{
+				"no install spec":           true,
+				"skipCRDs":                  false,
+				"crds Skip":                 false,
+				"skipCRDs with crds Create": false,
+			},
+		},
+		{
+			version: "1.0",
+			expectCRDs: map[string]bool{
+				"no install spec":           true,
+				"skipCRDs":                  false,
+				"crds Skip":                 false,
+				"skipCRDs with crds Create": true,
+			},
+		},
+	}
+	for _, tt := range tests {
+		for _, install := range installs {
+			t.Run(tt.version+"/"+install.name, func(t *testing.T) {
+				g := NewWithT(t)
+
+				compat, err := ControllerCompatFor(tt.version)
+				g.Expect(err).ToNot(HaveOccurred())
+
+				h := NewHelmBuilder(logr.Discard(), HelmOpts{
+					ControllerCompat: &compat,
+				})
+
+				hr := helmv2.HelmRelease{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "compat",
+						Namespace: "apps",
+					},
+					Spec: helmv2.HelmReleaseSpec{
+						Install: install.install,
+					},
+				}
+
+				rel, err := h.renderRelease(context.Background(), hr, nil, chartutil.Values{}, compatChart)
+				g.Expect(err).ToNot(HaveOccurred())
+
+				m, err := resmap.NewFactory(provider.NewDefaultDepProvider().GetResourceFactory()).NewResMapFromBytes([]byte(rel.Manifest))
+				g.Expect(err).ToNot(HaveOccurred())
+
+				var crds bool
+				for _, r := range m.Resources() {
+					switch r.GetKind() {
+					case "ConfigMap":
+						g.Expect(r.GetNamespace()).To(Equal("apps"))
+						g.Expect(r.GetLabels()).To(Equal(map[string]string{
+							"helm.toolkit.fluxcd.io/name":      "compat",
+							"helm.toolkit.fluxcd.io/namespace": "apps",
+						}))
+					case "CustomResourceDefinition":
+						crds = true
+					}
+				}
+				g.Expect(crds).To(Equal(tt.expectCRDs[install.name]))
+			})
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package build
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/kustomize/api/resmap"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+	sigsyaml "sigs.k8s.io/yaml"
+)
+
+// ConflictRule describes fields which are commonly managed by another controller in the cluster.
+// Applying such a field with server-side apply results in a field manager conflict.
+type ConflictRule struct {
+	// Manager is the controller which typically manages the fields.
+	Manager string `json:"manager"`
+	// Group of the resources, empty for the core group.
+	Group string `json:"group,omitempty"`
+	// Kinds of the resources.
+	Kinds []string `json:"kinds"`
+	// Fields are the paths of the managed fields. A `*` segment matches any list item or map key,
+	// a segment with a trailing `*` matches map keys by prefix.
+	Fields [][]string `json:"fields"`
+	// Annotation limits the rule to resources carrying the annotation, a trailing `*` matches by prefix.
+	Annotation string `json:"annotation,omitempty"`
+	// ScaleTarget limits the rule to resources targeted by a HorizontalPodAutoscaler of the output.
+	ScaleTarget bool `json:"scaleTarget,omitempty"`
+}
+
+// ConflictRules is the format of the file passed to LoadConflictRules.
+type ConflictRules struct {
+	Rules []ConflictRule `json:"rules"`
+}
+
+// DefaultConflictRules cover fields of well-known controllers.
+var DefaultConflictRules = []ConflictRule{
+	{
+		Manager:     "horizontal-pod-autoscaler",
+		Group:       "apps",
+		Kinds:       []string{"Deployment", "StatefulSet", "ReplicaSet"},
+		Fields:      [][]string{{"spec", "replicas"}},
+		ScaleTarget: true,
+	},
+	{
+		Manager: "cloud-controller-manager",
+		Kinds:   []string{"Service"},
+		Fields: [][]string{
+			{"metadata", "annotations", "cloud.google.com/neg-status"},
+			{"metadata", "annotations", "metallb.universe.tf/ip-allocated-from-pool"},
+			{"spec", "healthCheckNodePort"},
+		},
+	},
+	{
+		Manager:    "cert-manager-cainjector",
+		Group:      "admissionregistration.k8s.io",
+		Kinds:      []string{"ValidatingWebhookConfiguration", "MutatingWebhookConfiguration"},
+		Fields:     [][]string{{"webhooks", "*", "clientConfig", "caBundle"}},
+		Annotation: "cert-manager.io/inject-*",
+	},
+	{
+		Manager:    "cert-manager-cainjector",
+		Group:      "apiextensions.k8s.io",
+		Kinds:      []string{"CustomResourceDefinition"},
+		Fields:     [][]string{{"spec", "conversion", "webhook", "clientConfig", "caBundle"}},
+		Annotation: "cert-manager.io/inject-*",
+	},
+	{
+		Manager:    "cert-manager-cainjector",
+		Group:      "apiregistration.k8s.io",
+		Kinds:      []string{"APIService"},
+		Fields:     [][]string{{"spec", "caBundle"}},
+		Annotation: "cert-manager.io/inject-*",
+	},
+}
+
+// LoadConflictRules reads additional conflict rules from a YAML file.
+func LoadConflictRules(path string) ([]ConflictRule, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules ConflictRules
+	if err := sigsyaml.UnmarshalStrict(b, &rules); err != nil {
+		return nil, fmt.Errorf("invalid conflict rules in %s: %w", path, err)
+	}
+
+	for i, rule := range rules.Rules {
+		if rule.Manager == "" || len(rule.Kinds) == 0 || len(rule.Fields) == 0 {
+			return nil, fmt.Errorf("invalid conflict rule %d in %s: manager, kinds and fields are required", i, path)
+		}
+	}
+
+	return rules.Rules, nil
+}
+
+// ConflictFinding lists the fields of a resource which are prone to server-side apply conflicts.
+type ConflictFinding struct {
+	// Resource is the kind, namespace and name of the resource.
+	Resource string
+	// Manager is the controller which typically manages the fields.
+	Manager string
+	// Fields are the paths of the fields set by the resource.
+	Fields []string
+}
+
+type conflictCandidate struct {
+	ConflictFinding
+	// scaleTarget is set if the finding only applies if the resource is targeted by a HorizontalPodAutoscaler
+	scaleTarget string
+}
+
+// ConflictAnalyzer detects resources which set fields that are commonly managed by other controllers.
+// It is not safe for concurrent use.
+type ConflictAnalyzer struct {
+	rules        []ConflictRule
+	candidates   []conflictCandidate
+	scaleTargets map[string]bool
+}
+
+// NewConflictAnalyzer returns an analyzer for the given rules.
+func NewConflictAnalyzer(rules []ConflictRule) *ConflictAnalyzer {
+	return &ConflictAnalyzer{
+		rules:        rules,
+		scaleTargets: make(map[string]bool),
+	}
+}
+
+// Add analyzes the resources of the output.
+func (c *ConflictAnalyzer) Add(m resmap.ResMap) {
+	for _, r := range m.Resources() {
+		gvk := schema.FromAPIVersionAndKind(r.GetApiVersion(), r.GetKind())
+
+		if gvk.Group == "autoscaling" && gvk.Kind == "HorizontalPodAutoscaler" {
+			c.addScaleTarget(r.GetNamespace(), &r.RNode)
+		}
+
+		for _, rule := range c.rules {
+			if rule.Group != gvk.Group || !containsString(rule.Kinds, gvk.Kind) {
+				continue
+			}
+
+			if rule.Annotation != "" && !hasKey(r.GetAnnotations(), rule.Annotation) {
+				continue
+			}
+
+			var fields []string
+			for _, path := range rule.Fields {
+				fields = append(fields, matchFields(r.YNode(), path, "")...)
+			}
+
+			if len(fields) == 0 {
+				continue
+			}
+
+			candidate := conflictCandidate{
+				ConflictFinding: ConflictFinding{
+					Resource: ResourceName(gvk.Kind, r.GetNamespace(), r.GetName()),
+					Manager:  rule.Manager,
+					Fields:   fields,
+				},
+			}
+
+			if rule.ScaleTarget {
+				candidate.scaleTarget = scaleTargetKey(gvk.Group, gvk.Kind, r.GetNamespace(), r.GetName())
+			}
+
+			c.candidates = append(c.candidates, candidate)
+		}
+	}
+}
+
+// Findings returns the conflict-prone resources of all added resources sorted by resource.
+func (c *ConflictAnalyzer) Findings() []ConflictFinding {
+	var findings []ConflictFinding
+	for _, candidate := range c.candidates {
+		if candidate.scaleTarget != "" && !c.scaleTargets[candidate.scaleTarget] {
+			continue
+		}
+
+		findings = append(findings, candidate.ConflictFinding)
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		return findings[i].Resource < findings[j].Resource
+	})
+
+	return findings
+}
+
+func (c *ConflictAnalyzer) addScaleTarget(namespace string, hpa *yaml.RNode) {
+	target, err := hpa.Pipe(yaml.Lookup("spec", "scaleTargetRef"))
+	if err != nil || target == nil {
+		return
+	}
+
+	var ref struct {
+		APIVersion string `yaml:"apiVersion"`
+		Kind       string `yaml:"kind"`
+		Name       string `yaml:"name"`
+	}
+
+	if err := target.YNode().Decode(&ref); err != nil {
+		return
+	}
+
+	gvk := schema.FromAPIVersionAndKind(ref.APIVersion, ref.Kind)
+	c.scaleTargets[scaleTargetKey(gvk.Group, gvk.Kind, namespace, ref.Name)] = true
+}
+
+func scaleTargetKey(group, kind, namespace, name string) string {
+	return strings.Join([]string{group, kind, namespace, name}, "/")
+}
+
+// ResourceName returns kind/namespace/name of a resource, the namespace is omitted for cluster-scoped resources.
+func ResourceName(kind, namespace, name string) string {
+	if namespace == "" {
+		return kind + "/" + name
+	}
+
+	return kind + "/" + namespace + "/" + name
+}
+
+// matchFields returns the paths of all fields of node matching path.
+func matchFields(node *yaml.Node, path []string, prefix string) []string {
+	if node == nil {
+		return nil
+	}
+
+	if len(path) == 0 {
+		return []string{prefix}
+	}
+
+	segment := path[0]
+	var matches []string
+
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			if !matchKey(segment, key) {
+				continue
+			}
+
+			matches = append(matches, matchFields(node.Content[i+1], path[1:], joinField(prefix, key))...)
+		}
+	case yaml.SequenceNode:
+		if segment != "*" {
+			return nil
+		}
+
+		for i, item := range node.Content {
+			matches = append(matches, matchFields(item, path[1:], fmt.Sprintf("%s[%d]", prefix, i))...)
+		}
+	}
+
+	return matches
+}
+
+func joinField(prefix, key string) string {
+	if strings.ContainsAny(key, "./") {
+		return prefix + "[" + key + "]"
+	}
+
+	if prefix == "" {
+		return key
+	}
+
+	return prefix + "." + key
+}
+
+func matchKey(pattern, key string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		return strings.HasPrefix(key, prefix)
+	}
+
+	return pattern == key
+}
+
+func hasKey(m map[string]string, pattern string) bool {
+	for key := range m {
+		if matchKey(pattern, key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
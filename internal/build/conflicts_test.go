@@ -0,0 +1,215 @@
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"sigs.k8s.io/kustomize/api/provider"
+	"sigs.k8s.io/kustomize/api/resmap"
+)
+
+func TestConflictAnalyzer(t *testing.T) {
+	tests := []struct {
+		name           string
+		rules          []ConflictRule
+		manifests      []string
+		expectFindings []ConflictFinding
+	}{
+		{
+			name: "replicas of a HorizontalPodAutoscaler target",
+			manifests: []string{`apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: podinfo
+  namespace: apps
+spec:
+  replicas: 2
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: other
+  namespace: apps
+spec:
+  replicas: 2`, `apiVersion: autoscaling/v2
+kind: HorizontalPodAutoscaler
+metadata:
+  name: podinfo
+  namespace: apps
+spec:
+  scaleTargetRef:
+    apiVersion: apps/v1
+    kind: Deployment
+    name: podinfo`},
+			expectFindings: []ConflictFinding{
+				{Resource: "Deployment/apps/podinfo", Manager: "horizontal-pod-autoscaler", Fields: []string{"spec.replicas"}},
+			},
+		},
+		{
+			name: "replicas without HorizontalPodAutoscaler",
+			manifests: []string{`apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: podinfo
+  namespace: apps
+spec:
+  replicas: 2`},
+		},
+		{
+			name: "cloud load balancer annotations",
+			manifests: []string{`apiVersion: v1
+kind: Service
+metadata:
+  name: podinfo
+  namespace: apps
+  annotations:
+    cloud.google.com/neg-status: '{}'
+    example.com/other: "true"`},
+			expectFindings: []ConflictFinding{
+				{Resource: "Service/apps/podinfo", Manager: "cloud-controller-manager", Fields: []string{"metadata.annotations[cloud.google.com/neg-status]"}},
+			},
+		},
+		{
+			name: "caBundle injected by cert-manager",
+			manifests: []string{`apiVersion: admissionregistration.k8s.io/v1
+kind: ValidatingWebhookConfiguration
+metadata:
+  name: webhook
+  annotations:
+    cert-manager.io/inject-ca-from: apps/webhook
+webhooks:
+- name: a.example.com
+  clientConfig:
+    caBundle: Cg==
+- name: b.example.com
+  clientConfig:
+    service:
+      name: webhook
+- name: c.example.com
+  clientConfig:
+    caBundle: Cg==
+---
+apiVersion: admissionregistration.k8s.io/v1
+kind: MutatingWebhookConfiguration
+metadata:
+  name: webhook
+webhooks:
+- name: a.example.com
+  clientConfig:
+    caBundle: Cg==`},
+			expectFindings: []ConflictFinding{
+				{Resource: "ValidatingWebhookConfiguration/webhook", Manager: "cert-manager-cainjector", Fields: []string{"webhooks[0].clientConfig.caBundle", "webhooks[2].clientConfig.caBundle"}},
+			},
+		},
+		{
+			name: "custom rule",
+			rules: []ConflictRule{
+				{
+					Manager: "my-operator",
+					Group:   "apps",
+					Kinds:   []string{"Deployment"},
+					Fields:  [][]string{{"spec", "template", "metadata", "annotations", "my-operator.example.com/*"}},
+				},
+			},
+			manifests: []string{`apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: podinfo
+  namespace: apps
+spec:
+  template:
+    metadata:
+      annotations:
+        my-operator.example.com/restartedAt: now
+        my-operator.example.com/hash: abc`},
+			expectFindings: []ConflictFinding{
+				{Resource: "Deployment/apps/podinfo", Manager: "my-operator", Fields: []string{
+					"spec.template.metadata.annotations[my-operator.example.com/restartedAt]",
+					"spec.template.metadata.annotations[my-operator.example.com/hash]",
+				}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			factory := resmap.NewFactory(provider.NewDefaultDepProvider().GetResourceFactory())
+
+			rules := tt.rules
+			if rules == nil {
+				rules = DefaultConflictRules
+			}
+
+			analyzer := NewConflictAnalyzer(rules)
+			for _, manifest := range tt.manifests {
+				m, err := factory.NewResMapFromBytes([]byte(manifest))
+				g.Expect(err).ToNot(HaveOccurred())
+				analyzer.Add(m)
+			}
+
+			g.Expect(analyzer.Findings()).To(Equal(tt.expectFindings))
+		})
+	}
+}
+
+func TestLoadConflictRules(t *testing.T) {
+	tests := []struct {
+		name        string
+		rules       string
+		expectRules []ConflictRule
+		expectErr   string
+	}{
+		{
+			name: "valid rules",
+			rules: `rules:
+- manager: my-operator
+  group: apps
+  kinds: [Deployment]
+  annotation: my-operator.example.com/managed
+  fields:
+  - [spec, replicas]`,
+			expectRules: []ConflictRule{
+				{
+					Manager:    "my-operator",
+					Group:      "apps",
+					Kinds:      []string{"Deployment"},
+					Annotation: "my-operator.example.com/managed",
+					Fields:     [][]string{{"spec", "replicas"}},
+				},
+			},
+		},
+		{
+			name: "unknown field",
+			rules: `rules:
+- manager: my-operator
+  kind: Deployment`,
+			expectErr: "invalid conflict rules",
+		},
+		{
+			name: "missing fields",
+			rules: `rules:
+- manager: my-operator
+  kinds: [Deployment]`,
+			expectErr: "manager, kinds and fields are required",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			path := filepath.Join(t.TempDir(), "rules.yaml")
+			g.Expect(os.WriteFile(path, []byte(tt.rules), 0600)).To(Succeed())
+
+			rules, err := LoadConflictRules(path)
+			if tt.expectErr != "" {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tt.expectErr))
+				return
+			}
+
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(rules).To(Equal(tt.expectRules))
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package build
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"sort"
+
+	"github.com/doodlescheduling/flux-build/internal/cachemgr"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+	sourcev1beta2 "github.com/fluxcd/source-controller/api/v1beta2"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/kustomize/api/resource"
+)
+
+// repositoryKey returns the key the repository and its charts are cached by. HelmRepositories with the same URL
+// share the cache only if they are accessed with the same credentials, for instance robot accounts of different
+// tenants of a registry never share a repository or a chart.
+func (h *Helm) repositoryKey(ctx context.Context, repo *sourcev1.HelmRepository, normalizedURL string, db map[ref]*resource.Resource) (string, error) {
+	secret, err := h.getHelmRepositorySecret(ctx, repo, db)
+	if err != nil {
+		return "", err
+	}
+
+	certSecret, err := h.getHelmRepositoryCertSecret(ctx, repo, db)
+	if err != nil {
+		return "", err
+	}
+
+	provider := repo.Spec.Provider
+	if provider == sourcev1beta2.GenericOCIProvider {
+		provider = ""
+	}
+
+	if secret == nil && certSecret == nil && provider == "" {
+		return normalizedURL, nil
+	}
+
+	digest := sha256.New()
+	fmt.Fprintf(digest, "provider=%s\n", provider)
+	writeSecret(digest, "secret", secret)
+	writeSecret(digest, "certSecret", certSecret)
+
+	return cachemgr.RepositoryKey(normalizedURL, hex.EncodeToString(digest.Sum(nil))[:16]), nil
+}
+
+// writeSecret writes the namespace, name and data of a secret sorted by key to the digest.
+func writeSecret(digest hash.Hash, field string, secret *corev1.Secret) {
+	if secret == nil {
+		return
+	}
+
+	fmt.Fprintf(digest, "%s=%s/%s\n", field, secret.Namespace, secret.Name)
+
+	data := make(map[string][]byte, len(secret.Data)+len(secret.StringData))
+	for key, value := range secret.Data {
+		data[key] = value
+	}
+	for key, value := range secret.StringData {
+		data[key] = []byte(value)
+	}
+
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		fmt.Fprintf(digest, "%s.%s=%x\n", field, key, data[key])
+	}
+}
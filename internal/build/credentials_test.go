@@ -0,0 +1,210 @@
+package build
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/doodlescheduling/flux-build/internal/cachemgr"
+	"github.com/doodlescheduling/flux-build/internal/helm/chart"
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"github.com/fluxcd/pkg/apis/meta"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+	sourcev1beta2 "github.com/fluxcd/source-controller/api/v1beta2"
+	"github.com/go-logr/logr"
+	. "github.com/onsi/gomega"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	helmrepo "helm.sh/helm/v3/pkg/repo"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+func newCredentialsIndex(t *testing.T) ResourceIndex {
+	secret := func(name, username, password string) string {
+		return fmt.Sprintf(`apiVersion: v1
+kind: Secret
+metadata:
+  name: %s
+  namespace: default
+data:
+  username: %s
+  password: %s
+`, name, base64.StdEncoding.EncodeToString([]byte(username)), base64.StdEncoding.EncodeToString([]byte(password)))
+	}
+
+	return newResourceIndex(t, secret("tenant-a", "tenant-a", "a")+"---\n"+secret("tenant-b", "tenant-b", "b")+"---\n"+secret("tenant-a-rotated", "tenant-a", "rotated"))
+}
+
+func TestRepositoryKey(t *testing.T) {
+	db := newCredentialsIndex(t)
+
+	repo := func(secret string, provider string) *sourcev1.HelmRepository {
+		repo := &sourcev1.HelmRepository{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "repo",
+				Namespace: "default",
+			},
+			Spec: sourcev1.HelmRepositorySpec{
+				URL:      "https://charts.example.com/",
+				Provider: provider,
+			},
+		}
+
+		if secret != "" {
+			repo.Spec.SecretRef = &meta.LocalObjectReference{Name: secret}
+		}
+
+		return repo
+	}
+
+	tests := []struct {
+		name        string
+		a, b        *sourcev1.HelmRepository
+		expectEqual bool
+	}{
+		{
+			name:        "anonymous",
+			a:           repo("", ""),
+			b:           repo("", sourcev1beta2.GenericOCIProvider),
+			expectEqual: true,
+		},
+		{
+			name:        "same secret",
+			a:           repo("tenant-a", ""),
+			b:           repo("tenant-a", ""),
+			expectEqual: true,
+		},
+		{
+			name: "anonymous and secret",
+			a:    repo("", ""),
+			b:    repo("tenant-a", ""),
+		},
+		{
+			name: "different secrets",
+			a:    repo("tenant-a", ""),
+			b:    repo("tenant-b", ""),
+		},
+		{
+			name: "different secret data",
+			a:    repo("tenant-a", ""),
+			b:    repo("tenant-a-rotated", ""),
+		},
+		{
+			name: "different provider",
+			a:    repo("", ""),
+			b:    repo("", sourcev1beta2.AzureOCIProvider),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			h := NewHelmBuilder(logr.Discard(), HelmOpts{})
+
+			a, err := h.repositoryKey(context.Background(), tt.a, tt.a.Spec.URL, db)
+			g.Expect(err).ToNot(HaveOccurred())
+			b, err := h.repositoryKey(context.Background(), tt.b, tt.b.Spec.URL, db)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			if tt.expectEqual {
+				g.Expect(a).To(Equal(b))
+			} else {
+				g.Expect(a).ToNot(Equal(b))
+			}
+		})
+	}
+}
+
+func TestBuildChartSameURLDifferentCredentials(t *testing.T) {
+	g := NewWithT(t)
+
+	c, err := loader.Load(testChart)
+	g.Expect(err).ToNot(HaveOccurred())
+	archive, err := os.ReadFile(testChart)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	passwords := map[string]string{"tenant-a": "a", "tenant-b": "b"}
+	var mu sync.Mutex
+	var downloads []string
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if !ok || passwords[username] != password {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if r.URL.Path == "/helmchart-0.1.0.tgz" {
+			mu.Lock()
+			downloads = append(downloads, username)
+			mu.Unlock()
+		}
+
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	index := helmrepo.NewIndexFile()
+	g.Expect(index.MustAdd(c.Metadata, "helmchart-0.1.0.tgz", server.URL, "")).To(Succeed())
+	indexYAML, err := yaml.Marshal(index)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	mux.HandleFunc("/index.yaml", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(indexYAML)
+	})
+	mux.HandleFunc("/helmchart-0.1.0.tgz", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(archive)
+	})
+
+	cache, err := cachemgr.New("fs", t.TempDir())
+	g.Expect(err).ToNot(HaveOccurred())
+
+	h := NewHelmBuilder(logr.Discard(), HelmOpts{
+		Cache: cache,
+	})
+
+	db := newCredentialsIndex(t)
+	hr := helmv2.HelmRelease{
+		Spec: helmv2.HelmReleaseSpec{
+			Chart: &helmv2.HelmChartTemplate{
+				Spec: helmv2.HelmChartTemplateSpec{
+					Chart:   "helmchart",
+					Version: "0.1.0",
+					SourceRef: helmv2.CrossNamespaceObjectReference{
+						Kind: sourcev1.HelmRepositoryKind,
+						Name: "repo",
+					},
+				},
+			},
+		},
+	}
+
+	var paths []string
+	for _, tenant := range []string{"tenant-a", "tenant-b", "tenant-a"} {
+		repo := &sourcev1.HelmRepository{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      tenant,
+				Namespace: "default",
+			},
+			Spec: sourcev1.HelmRepositorySpec{
+				URL:       server.URL,
+				SecretRef: &meta.LocalObjectReference{Name: tenant},
+			},
+		}
+
+		build := &chart.Build{}
+		g.Expect(h.buildChart(context.Background(), repo, hr, nil, build, db)).To(Succeed())
+		g.Expect(build.Name).To(Equal("helmchart"))
+		paths = append(paths, build.Path)
+	}
+
+	// Each tenant pulls the chart with its own credentials, the cached chart is reused by the same tenant only
+	g.Expect(downloads).To(Equal([]string{"tenant-a", "tenant-b"}))
+	g.Expect(paths[0]).ToNot(Equal(paths[1]))
+	g.Expect(paths[2]).To(Equal(paths[0]))
+}
@@ -0,0 +1,202 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	helmv2beta2 "github.com/fluxcd/helm-controller/api/v2beta2"
+	helmchart "helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/release"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// diffExcerptLines is the maximum number of differing lines of both renders reported by a DeterminismError.
+const diffExcerptLines = 5
+
+// nondeterministicFunctions match the template functions which render a different result on every call.
+var nondeterministicFunctions = regexp.MustCompile(`\b(randAlphaNum|randAlpha|randNumeric|randAscii|randInt|randBytes|uuidv4|now|unixEpoch|genCA|genCAWithKey|genPrivateKey|genSelfSignedCert|genSelfSignedCertWithKey|genSignedCert|genSignedCertWithKey|shuffle)\b`)
+
+// templateActions match the actions of a template, functions outside of actions are plain text.
+var templateActions = regexp.MustCompile(`(?s)\{\{.*?\}\}`)
+
+// DeterminismError is returned if a HelmRelease renders differently twice.
+type DeterminismError struct {
+	// Release is the namespace and name of the HelmRelease.
+	Release string
+	// Resource is the kind, namespace and name of the first differing resource, empty if unknown.
+	Resource string
+	// Template is the path of the template the first difference is rendered by. It is only known for hooks as
+	// the post renderers drop the # Source comments of the manifest.
+	Template string
+	// Suspects are the templates calling nondeterministic functions as <template>: <functions>, only the
+	// Template is listed if it calls any.
+	Suspects []string
+	// Diff is an excerpt of both renders starting at the first differing line.
+	Diff string
+}
+
+func (e *DeterminismError) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "helmrelease `%s` does not render deterministically", e.Release)
+	switch {
+	case e.Resource != "" && e.Template != "":
+		fmt.Fprintf(&sb, ", the first difference is in %s rendered by %s", e.Resource, e.Template)
+	case e.Resource != "":
+		fmt.Fprintf(&sb, ", the first difference is in %s", e.Resource)
+	case e.Template != "":
+		fmt.Fprintf(&sb, ", the first difference is rendered by %s", e.Template)
+	}
+	if len(e.Suspects) > 0 {
+		fmt.Fprintf(&sb, ", likely caused by %s", strings.Join(e.Suspects, "; "))
+	}
+	fmt.Fprintf(&sb, ":\n%s", e.Diff)
+
+	return sb.String()
+}
+
+// renderDeterministic renders the release twice and fails with a DeterminismError if the manifests or hooks differ.
+// Both renders use their own copy of the values, maps are iterated in a different order by every render.
+func (h *Helm) renderDeterministic(ctx context.Context, hr helmv2.HelmRelease, legacyPostRenderers []helmv2beta2.PostRenderer, values chartutil.Values, chart *helmchart.Chart) (*release.Release, error) {
+	rerenderValues := copyValues(map[string]interface{}(values)).(map[string]interface{})
+
+	rel, err := h.renderRelease(ctx, hr, legacyPostRenderers, values, chart)
+	if err != nil {
+		return nil, err
+	}
+
+	rerendered, err := h.renderRelease(ctx, hr, legacyPostRenderers, rerenderValues, chart)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := compareRenders(hr, chart, renderedOutput(rel), renderedOutput(rerendered)); err != nil {
+		return nil, err
+	}
+
+	h.logger(ctx).V(1).Info("helm release renders deterministically")
+	return rel, nil
+}
+
+// renderedOutput returns the manifest followed by the hooks of the release.
+func renderedOutput(rel *release.Release) string {
+	var sb strings.Builder
+	sb.WriteString(rel.Manifest)
+	for _, hook := range rel.Hooks {
+		fmt.Fprintf(&sb, "\n---\n# Source: %s\n%s", hook.Path, hook.Manifest)
+	}
+
+	return sb.String()
+}
+
+func compareRenders(hr helmv2.HelmRelease, chart *helmchart.Chart, first, second string) error {
+	if first == second {
+		return nil
+	}
+
+	firstLines := strings.Split(first, "\n")
+	secondLines := strings.Split(second, "\n")
+
+	i := 0
+	for i < len(firstLines) && i < len(secondLines) && firstLines[i] == secondLines[i] {
+		i++
+	}
+
+	err := &DeterminismError{
+		Release: hr.GetNamespace() + "/" + hr.GetName(),
+		Diff:    diffExcerpt(firstLines[i:], secondLines[i:]),
+	}
+
+	// The document of the first difference is delimited by the separators around it
+	start := min(i, len(firstLines)-1)
+	for start > 0 && firstLines[start-1] != "---" {
+		start--
+	}
+	end := start
+	for end < len(firstLines) && firstLines[end] != "---" {
+		end++
+	}
+
+	document := firstLines[start:end]
+	for _, line := range document {
+		if source, ok := strings.CutPrefix(line, "# Source: "); ok {
+			err.Template = source
+			break
+		}
+	}
+
+	var obj metav1.PartialObjectMetadata
+	if yaml.Unmarshal([]byte(strings.Join(document, "\n")), &obj) == nil && obj.Kind != "" {
+		err.Resource = ResourceName(obj.Kind, obj.Namespace, obj.Name)
+	}
+
+	suspects := scanNondeterministicFunctions(chart)
+	if functions, ok := suspects[err.Template]; ok {
+		err.Suspects = []string{err.Template + ": " + strings.Join(functions, ", ")}
+		return err
+	}
+
+	for template, functions := range suspects {
+		err.Suspects = append(err.Suspects, template+": "+strings.Join(functions, ", "))
+	}
+	sort.Strings(err.Suspects)
+
+	return err
+}
+
+// diffExcerpt returns the lines of both renders until they match again.
+func diffExcerpt(first, second []string) string {
+	n := 0
+	for n < diffExcerptLines && (n >= len(first) || n >= len(second) || first[n] != second[n]) && (n < len(first) || n < len(second)) {
+		n++
+	}
+
+	var sb strings.Builder
+	for _, line := range first[:min(len(first), n)] {
+		fmt.Fprintf(&sb, "- %s\n", line)
+	}
+	for _, line := range second[:min(len(second), n)] {
+		fmt.Fprintf(&sb, "+ %s\n", line)
+	}
+
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+// scanNondeterministicFunctions returns the nondeterministic functions called by the templates of the chart and its
+// subcharts, keyed by the template path as it appears in the # Source comments of the rendered manifest.
+func scanNondeterministicFunctions(chart *helmchart.Chart) map[string][]string {
+	suspects := make(map[string][]string)
+	scanChartTemplates(chart, chart.Name(), suspects)
+	return suspects
+}
+
+func scanChartTemplates(chart *helmchart.Chart, prefix string, suspects map[string][]string) {
+	for _, template := range chart.Templates {
+		seen := make(map[string]struct{})
+		var functions []string
+		for _, action := range templateActions.FindAll(template.Data, -1) {
+			for _, function := range nondeterministicFunctions.FindAll(action, -1) {
+				if _, ok := seen[string(function)]; ok {
+					continue
+				}
+
+				seen[string(function)] = struct{}{}
+				functions = append(functions, string(function))
+			}
+		}
+
+		if len(functions) > 0 {
+			suspects[path.Join(prefix, template.Name)] = functions
+		}
+	}
+
+	for _, dependency := range chart.Dependencies() {
+		scanChartTemplates(dependency, path.Join(prefix, "charts", dependency.Name()), suspects)
+	}
+}
@@ -0,0 +1,130 @@
+package build
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"github.com/go-logr/logr"
+	. "github.com/onsi/gomega"
+	helmchart "helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newDeterminismChart(secret string) *helmchart.Chart {
+	sub := &helmchart.Chart{
+		Metadata: &helmchart.Metadata{APIVersion: helmchart.APIVersionV2, Name: "redis", Version: "1.0.0"},
+		Templates: []*helmchart.File{
+			{Name: "templates/_helpers.tpl", Data: []byte(`{{- define "redis.timestamp" }}{{ now | date "15:04" }}{{- end }}`)},
+		},
+	}
+
+	parent := &helmchart.Chart{
+		Metadata: &helmchart.Metadata{APIVersion: helmchart.APIVersionV2, Name: "podinfo", Version: "1.0.0"},
+		Templates: []*helmchart.File{
+			{
+				Name: "templates/configmap.yaml",
+				Data: []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: podinfo
+  annotations:
+    note: generate a password with randAlphaNum
+data:
+{{- range $key, $value := .Values.data }}
+  {{ $key }}: {{ $value | quote }}
+{{- end }}
+`),
+			},
+			{Name: "templates/secret.yaml", Data: []byte(secret)},
+		},
+	}
+	parent.AddDependency(sub)
+
+	return parent
+}
+
+func TestRenderDeterministic(t *testing.T) {
+	hr := helmv2.HelmRelease{
+		ObjectMeta: metav1.ObjectMeta{Name: "podinfo", Namespace: "apps"},
+	}
+	values := chartutil.Values{
+		"data": map[string]interface{}{"a": "1", "b": "2", "c": "3", "d": "4"},
+	}
+
+	tests := []struct {
+		name           string
+		secret         string
+		expectErr      bool
+		expectResource string
+		expectSuspects []string
+	}{
+		{
+			name: "deterministic",
+			secret: `apiVersion: v1
+kind: Secret
+metadata:
+  name: podinfo
+stringData:
+  password: {{ "static" | quote }}
+`,
+		},
+		{
+			name: "random password",
+			secret: `apiVersion: v1
+kind: Secret
+metadata:
+  name: podinfo
+stringData:
+  password: {{ randAlphaNum 32 | quote }}
+`,
+			expectErr:      true,
+			expectResource: "Secret/apps/podinfo",
+			expectSuspects: []string{
+				"podinfo/charts/redis/templates/_helpers.tpl: now",
+				"podinfo/templates/secret.yaml: randAlphaNum",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			h := NewHelmBuilder(logr.Discard(), HelmOpts{CheckDeterminism: true})
+			rel, err := h.renderDeterministic(context.Background(), hr, nil, values, newDeterminismChart(tt.secret))
+			if !tt.expectErr {
+				g.Expect(err).ToNot(HaveOccurred())
+				g.Expect(rel.Manifest).To(ContainSubstring("password: static"))
+				return
+			}
+
+			var determinismErr *DeterminismError
+			g.Expect(errors.As(err, &determinismErr)).To(BeTrue())
+			g.Expect(determinismErr.Release).To(Equal("apps/podinfo"))
+			g.Expect(determinismErr.Resource).To(Equal(tt.expectResource))
+			g.Expect(determinismErr.Suspects).To(Equal(tt.expectSuspects))
+			g.Expect(determinismErr.Diff).To(MatchRegexp(`^-   password: \w{32}\n\+   password: \w{32}$`))
+		})
+	}
+}
+
+func TestCompareRenders(t *testing.T) {
+	g := NewWithT(t)
+
+	hr := helmv2.HelmRelease{
+		ObjectMeta: metav1.ObjectMeta{Name: "podinfo", Namespace: "apps"},
+	}
+
+	err := compareRenders(hr, newDeterminismChart(`{{ uuidv4 }}`), "# Source: podinfo/templates/other.yaml\na: 1", "# Source: podinfo/templates/other.yaml\na: 2")
+
+	var determinismErr *DeterminismError
+	g.Expect(errors.As(err, &determinismErr)).To(BeTrue())
+	g.Expect(determinismErr.Template).To(Equal("podinfo/templates/other.yaml"))
+	g.Expect(determinismErr.Suspects).To(Equal([]string{
+		"podinfo/charts/redis/templates/_helpers.tpl: now",
+		"podinfo/templates/secret.yaml: uuidv4",
+	}))
+	g.Expect(err.Error()).To(Equal("helmrelease `apps/podinfo` does not render deterministically, the first difference is rendered by podinfo/templates/other.yaml, likely caused by podinfo/charts/redis/templates/_helpers.tpl: now; podinfo/templates/secret.yaml: uuidv4:\n- a: 1\n+ a: 2"))
+}
@@ -0,0 +1,158 @@
+package build
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"sigs.k8s.io/kustomize/api/resource"
+)
+
+// DuplicatePolicy decides how resources which are declared with different content by more than one path are handled.
+type DuplicatePolicy string
+
+const (
+	// DuplicateError fails the build.
+	DuplicateError DuplicatePolicy = "error"
+	// DuplicateWarn logs the paths of the declarations, the declaration of the last path wins.
+	DuplicateWarn DuplicatePolicy = "warn"
+	// DuplicateLastWins takes the declaration of the last path without a warning.
+	DuplicateLastWins DuplicatePolicy = "last-wins"
+)
+
+// ParseDuplicatePolicy parses error, warn or last-wins, an empty policy is warn.
+func ParseDuplicatePolicy(policy string) (DuplicatePolicy, error) {
+	switch DuplicatePolicy(policy) {
+	case "", DuplicateWarn:
+		return DuplicateWarn, nil
+	case DuplicateError, DuplicateLastWins:
+		return DuplicatePolicy(policy), nil
+	}
+
+	return "", fmt.Errorf("unknown duplicate policy `%s`, supported policies are %s, %s, %s", policy, DuplicateError, DuplicateWarn, DuplicateLastWins)
+}
+
+// Duplicate is a resource declared with different content by more than one path.
+type Duplicate struct {
+	// Resource is the kind, namespace and name of the resource.
+	Resource string
+	// Paths are the paths declaring the resource in the order they were given, the last one wins.
+	Paths []string
+}
+
+// DuplicateResourceError is returned for resources declared with different content by more than one path if the
+// DuplicatePolicy is DuplicateError.
+type DuplicateResourceError struct {
+	Duplicates []Duplicate
+}
+
+func (e *DuplicateResourceError) Error() string {
+	var msgs []string
+	for _, d := range e.Duplicates {
+		msgs = append(msgs, fmt.Sprintf("%s in %s", d.Resource, strings.Join(d.Paths, ", ")))
+	}
+
+	return fmt.Sprintf("%d resources are declared more than once with different content: %s", len(e.Duplicates), strings.Join(msgs, "; "))
+}
+
+type declaration struct {
+	order    int
+	path     string
+	resource *resource.Resource
+}
+
+// IndexLoader pushes the resources of several paths into a ResourceIndex and records which paths declare every resource.
+// Paths may be pushed in any order, the declaration of the path with the highest order is indexed.
+type IndexLoader struct {
+	index        ResourceIndex
+	declarations map[ref][]declaration
+}
+
+// NewIndexLoader returns an IndexLoader which pushes into index.
+func NewIndexLoader(index ResourceIndex) *IndexLoader {
+	return &IndexLoader{
+		index:        index,
+		declarations: make(map[ref][]declaration),
+	}
+}
+
+// Push indexes the resources declared by path, order is the position of path within the paths of the build.
+func (l *IndexLoader) Push(order int, path string, resources []*resource.Resource) error {
+	for _, res := range resources {
+		key, err := refOf(res)
+		if err != nil {
+			return err
+		}
+
+		declarations := l.declarations[key]
+		if len(declarations) == 0 || declarations[len(declarations)-1].order < order {
+			l.index[key] = res
+		}
+
+		declarations = append(declarations, declaration{order: order, path: path, resource: res})
+		sort.SliceStable(declarations, func(i, j int) bool {
+			return declarations[i].order < declarations[j].order
+		})
+		l.declarations[key] = declarations
+	}
+
+	return nil
+}
+
+// Path returns the path declaring the indexed resource, it reports false for resources which are not indexed.
+func (l *IndexLoader) Path(res *resource.Resource) (string, bool) {
+	key, err := refOf(res)
+	if err != nil {
+		return "", false
+	}
+
+	for _, declaration := range l.declarations[key] {
+		if declaration.resource == l.index[key] {
+			return declaration.path, true
+		}
+	}
+
+	return "", false
+}
+
+// Duplicates returns the resources declared with different content by more than one path sorted by their name.
+// Identical declarations, for instance of a base shared by several paths, are no duplicates.
+func (l *IndexLoader) Duplicates() []Duplicate {
+	var duplicates []Duplicate
+	for _, declarations := range l.declarations {
+		if len(declarations) < 2 || identicalDeclarations(declarations) {
+			continue
+		}
+
+		res := declarations[0].resource
+		d := Duplicate{Resource: ResourceName(res.GetKind(), res.GetNamespace(), res.GetName())}
+		for _, declaration := range declarations {
+			d.Paths = append(d.Paths, declaration.path)
+		}
+
+		duplicates = append(duplicates, d)
+	}
+
+	sort.Slice(duplicates, func(i, j int) bool {
+		return duplicates[i].Resource < duplicates[j].Resource
+	})
+
+	return duplicates
+}
+
+func identicalDeclarations(declarations []declaration) bool {
+	first, err := declarations[0].resource.Map()
+	if err != nil {
+		return false
+	}
+
+	for _, declaration := range declarations[1:] {
+		m, err := declaration.resource.Map()
+		if err != nil || !reflect.DeepEqual(first, m) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,131 @@
+package build
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"sigs.k8s.io/kustomize/api/provider"
+	"sigs.k8s.io/kustomize/api/resmap"
+)
+
+func TestIndexLoaderDuplicates(t *testing.T) {
+	release := func(version string) string {
+		return `apiVersion: helm.toolkit.fluxcd.io/v2
+kind: HelmRelease
+metadata:
+  name: podinfo
+  namespace: apps
+spec:
+  chart:
+    spec:
+      version: "` + version + `"
+`
+	}
+	repository := `apiVersion: source.toolkit.fluxcd.io/v1
+kind: HelmRepository
+metadata:
+  name: podinfo
+  namespace: apps
+spec:
+  url: https://stefanprodan.github.io/podinfo
+`
+
+	type push struct {
+		order     int
+		path      string
+		manifests string
+	}
+
+	tests := []struct {
+		name             string
+		pushes           []push
+		expectDuplicates []Duplicate
+		expectVersion    string
+		expectPath       string
+	}{
+		{
+			name: "no duplicates",
+			pushes: []push{
+				{order: 0, path: "apps", manifests: release("6.0.0")},
+				{order: 1, path: "sources", manifests: repository},
+			},
+			expectVersion: "6.0.0",
+			expectPath:    "apps",
+		},
+		{
+			name: "identical declarations",
+			pushes: []push{
+				{order: 0, path: "base", manifests: release("6.0.0") + "---\n" + repository},
+				{order: 1, path: "overlay", manifests: repository},
+			},
+			expectVersion: "6.0.0",
+			expectPath:    "base",
+		},
+		{
+			name: "last path wins",
+			pushes: []push{
+				{order: 0, path: "staging", manifests: release("6.0.0")},
+				{order: 1, path: "production", manifests: release("6.1.0")},
+			},
+			expectDuplicates: []Duplicate{{Resource: "HelmRelease/apps/podinfo", Paths: []string{"staging", "production"}}},
+			expectVersion:    "6.1.0",
+			expectPath:       "production",
+		},
+		{
+			name: "last path wins regardless of the push order",
+			pushes: []push{
+				{order: 2, path: "production", manifests: release("6.1.0")},
+				{order: 0, path: "staging", manifests: release("6.0.0")},
+				{order: 1, path: "testing", manifests: release("6.0.1")},
+			},
+			expectDuplicates: []Duplicate{{Resource: "HelmRelease/apps/podinfo", Paths: []string{"staging", "testing", "production"}}},
+			expectVersion:    "6.1.0",
+			expectPath:       "production",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			index := make(ResourceIndex)
+			loader := NewIndexLoader(index)
+			for _, push := range tt.pushes {
+				resMap, err := resmap.NewFactory(provider.NewDefaultDepProvider().GetResourceFactory()).NewResMapFromBytes([]byte(push.manifests))
+				g.Expect(err).ToNot(HaveOccurred())
+				g.Expect(loader.Push(push.order, push.path, resMap.Resources())).To(Succeed())
+			}
+
+			g.Expect(loader.Duplicates()).To(Equal(tt.expectDuplicates))
+
+			for _, res := range index {
+				if res.GetKind() == "HelmRelease" {
+					version, err := res.GetString("spec.chart.spec.version")
+					g.Expect(err).ToNot(HaveOccurred())
+					g.Expect(version).To(Equal(tt.expectVersion))
+
+					path, ok := loader.Path(res)
+					g.Expect(ok).To(BeTrue())
+					g.Expect(path).To(Equal(tt.expectPath))
+				}
+			}
+		})
+	}
+}
+
+func TestParseDuplicatePolicy(t *testing.T) {
+	g := NewWithT(t)
+
+	policy, err := ParseDuplicatePolicy("")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(policy).To(Equal(DuplicateWarn))
+
+	policy, err = ParseDuplicatePolicy("last-wins")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(policy).To(Equal(DuplicateLastWins))
+
+	_, err = ParseDuplicatePolicy("first-wins")
+	g.Expect(err).To(MatchError("unknown duplicate policy `first-wins`, supported policies are error, warn, last-wins"))
+
+	err = &DuplicateResourceError{Duplicates: []Duplicate{{Resource: "HelmRelease/apps/podinfo", Paths: []string{"staging", "production"}}}}
+	g.Expect(err).To(MatchError("1 resources are declared more than once with different content: HelmRelease/apps/podinfo in staging, production"))
+}
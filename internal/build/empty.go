@@ -0,0 +1,52 @@
+package build
+
+import (
+	"fmt"
+	"strings"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/releaseutil"
+	"sigs.k8s.io/yaml"
+)
+
+// EmptyReleaseError is returned if FailOnEmpty is set and the chart of a HelmRelease renders no resources.
+type EmptyReleaseError struct {
+	// Release is the namespace and name of the HelmRelease.
+	Release string
+	// Chart is the name of the chart.
+	Chart string
+	// Version is the version of the chart.
+	Version string
+}
+
+func (e *EmptyReleaseError) Error() string {
+	return fmt.Sprintf("chart `%s@%s` of helmrelease `%s` renders no resources, check whether its values disable all templates", e.Chart, e.Version, e.Release)
+}
+
+// checkEmptyRelease fails with an EmptyReleaseError if FailOnEmpty is set and the manifest of the release holds no
+// Kubernetes object. Hooks are not part of the manifest and don't count.
+func (h *Helm) checkEmptyRelease(hr *helmv2.HelmRelease, rel *release.Release) error {
+	if !h.opts.FailOnEmpty {
+		return nil
+	}
+
+	for _, manifest := range releaseutil.SplitManifests(rel.Manifest) {
+		var object struct {
+			Kind string `json:"kind"`
+		}
+
+		// Documents which are no objects are left to the kustomization of the release to report
+		if err := yaml.Unmarshal([]byte(manifest), &object); err != nil || strings.TrimSpace(object.Kind) != "" {
+			return nil
+		}
+	}
+
+	err := &EmptyReleaseError{Release: hr.GetNamespace() + "/" + hr.GetName()}
+	if rel.Chart != nil && rel.Chart.Metadata != nil {
+		err.Chart = rel.Chart.Metadata.Name
+		err.Version = rel.Chart.Metadata.Version
+	}
+
+	return err
+}
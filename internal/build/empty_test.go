@@ -0,0 +1,72 @@
+package build
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"github.com/go-logr/logr"
+	. "github.com/onsi/gomega"
+	helmchart "helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCheckEmptyRelease(t *testing.T) {
+	gatedChart := &helmchart.Chart{
+		Metadata: &helmchart.Metadata{APIVersion: helmchart.APIVersionV2, Name: "gated", Version: "1.0.0"},
+		Templates: []*helmchart.File{
+			{Name: "templates/configmap.yaml", Data: []byte("{{- if .Values.enabled }}\napiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: gated\n{{- end }}\n")},
+			{Name: "templates/comment.yaml", Data: []byte("# only rendered for documentation\n")},
+			{Name: "templates/hook.yaml", Data: []byte("apiVersion: batch/v1\nkind: Job\nmetadata:\n  name: migrate\n  annotations:\n    helm.sh/hook: pre-install\n")},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		failOnEmpty bool
+		values      chartutil.Values
+		expectErr   string
+	}{
+		{
+			name:        "rendered resources",
+			failOnEmpty: true,
+			values:      chartutil.Values{"enabled": true},
+		},
+		{
+			name:        "no resources",
+			failOnEmpty: true,
+			values:      chartutil.Values{"enabled": false},
+			expectErr:   "chart `gated@1.0.0` of helmrelease `apps/gated` renders no resources, check whether its values disable all templates",
+		},
+		{
+			name:   "no resources without fail on empty",
+			values: chartutil.Values{"enabled": false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			h := NewHelmBuilder(logr.Discard(), HelmOpts{FailOnEmpty: tt.failOnEmpty})
+			hr := &helmv2.HelmRelease{
+				ObjectMeta: metav1.ObjectMeta{Name: "gated", Namespace: "apps"},
+			}
+
+			rel, err := h.renderRelease(context.Background(), *hr, nil, tt.values, gatedChart)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(rel.Hooks).To(HaveLen(1))
+
+			err = h.checkEmptyRelease(hr, rel)
+			if tt.expectErr == "" {
+				g.Expect(err).ToNot(HaveOccurred())
+				return
+			}
+
+			g.Expect(err).To(MatchError(tt.expectErr))
+			var emptyErr *EmptyReleaseError
+			g.Expect(errors.As(err, &emptyErr)).To(BeTrue())
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package build
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/doodlescheduling/flux-build/internal/helm/postrenderer"
+	sigsyaml "sigs.k8s.io/yaml"
+)
+
+// ExecPostRenderers is the format of the file passed to LoadExecPostRenderers.
+type ExecPostRenderers struct {
+	PostRenderers []postrenderer.Exec `json:"postRenderers"`
+}
+
+// LoadExecPostRenderers reads the local commands the manifests of every release are piped through from a YAML file.
+// The file must be passed by the local configuration, exec post renderers are never read from the built manifests.
+func LoadExecPostRenderers(path string) ([]postrenderer.Exec, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var renderers ExecPostRenderers
+	if err := sigsyaml.UnmarshalStrict(b, &renderers); err != nil {
+		return nil, fmt.Errorf("invalid exec post renderers in %s: %w", path, err)
+	}
+
+	names := make(map[string]bool)
+	for i, renderer := range renderers.PostRenderers {
+		if renderer.Name == "" || renderer.Command == "" {
+			return nil, fmt.Errorf("invalid exec post renderer %d in %s: name and command are required", i, path)
+		}
+
+		if renderer.Timeout.Duration < 0 {
+			return nil, fmt.Errorf("invalid exec post renderer `%s` in %s: timeout must not be negative", renderer.Name, path)
+		}
+
+		if names[renderer.Name] {
+			return nil, fmt.Errorf("invalid exec post renderer `%s` in %s: name is not unique", renderer.Name, path)
+		}
+		names[renderer.Name] = true
+	}
+
+	return renderers.PostRenderers, nil
+}
@@ -0,0 +1,115 @@
+package build
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/doodlescheduling/flux-build/internal/helm/postrenderer"
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"github.com/go-logr/logr"
+	. "github.com/onsi/gomega"
+	helmchart "helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestLoadExecPostRenderers(t *testing.T) {
+	tests := []struct {
+		name            string
+		content         string
+		expectRenderers []postrenderer.Exec
+		expectErr       string
+	}{
+		{
+			name: "post renderers",
+			content: `postRenderers:
+- name: org
+  command: /usr/local/bin/org-mutate
+  args: ["--strict"]
+  timeout: 30s
+- name: sort
+  command: yq
+`,
+			expectRenderers: []postrenderer.Exec{
+				{Name: "org", Command: "/usr/local/bin/org-mutate", Args: []string{"--strict"}, Timeout: metav1.Duration{Duration: 30 * time.Second}},
+				{Name: "sort", Command: "yq"},
+			},
+		},
+		{
+			name:      "missing command",
+			content:   "postRenderers:\n- name: org\n",
+			expectErr: "invalid exec post renderer 0",
+		},
+		{
+			name:      "duplicate name",
+			content:   "postRenderers:\n- name: org\n  command: a\n- name: org\n  command: b\n",
+			expectErr: "invalid exec post renderer `org`",
+		},
+		{
+			name:      "unknown field",
+			content:   "postRenderers:\n- name: org\n  command: a\n  shell: true\n",
+			expectErr: "invalid exec post renderers",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			path := filepath.Join(t.TempDir(), "postrenderers.yaml")
+			g.Expect(os.WriteFile(path, []byte(tt.content), 0644)).To(Succeed())
+
+			renderers, err := LoadExecPostRenderers(path)
+			if tt.expectErr != "" {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tt.expectErr))
+				return
+			}
+
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(renderers).To(Equal(tt.expectRenderers))
+		})
+	}
+}
+
+func TestRenderReleaseExecPostRenderers(t *testing.T) {
+	g := NewWithT(t)
+
+	releaseChart := &helmchart.Chart{
+		Metadata: &helmchart.Metadata{
+			APIVersion: helmchart.APIVersionV2,
+			Name:       "exec",
+			Version:    "1.0.0",
+		},
+		Templates: []*helmchart.File{
+			{
+				Name: "templates/configmap.yaml",
+				Data: []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: release
+`),
+			},
+		},
+	}
+
+	h := NewHelmBuilder(logr.Discard(), HelmOpts{
+		ExecPostRenderers: []postrenderer.Exec{
+			{Name: "rename", Command: "sed", Args: []string{"s/name: release/name: mutated/"}},
+		},
+	})
+
+	hr := helmv2.HelmRelease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "exec",
+			Namespace: "default",
+		},
+	}
+
+	rel, err := h.renderRelease(context.Background(), hr, nil, chartutil.Values{}, releaseChart)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(rel.Manifest).To(ContainSubstring("name: mutated"))
+	g.Expect(rel.Manifest).To(ContainSubstring("namespace: default"))
+}
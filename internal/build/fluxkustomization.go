@@ -0,0 +1,357 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/doodlescheduling/flux-build/internal/helm/postrenderer"
+	"github.com/drone/envsubst"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+	sourcev1beta2 "github.com/fluxcd/source-controller/api/v1beta2"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/kustomize/api/provider"
+	"sigs.k8s.io/kustomize/api/resmap"
+	"sigs.k8s.io/kustomize/api/resource"
+	"sigs.k8s.io/yaml"
+)
+
+// fluxKustomizationGroup is the API group of Flux Kustomizations (not to be confused with kustomize.config.k8s.io).
+const fluxKustomizationGroup = "kustomize.toolkit.fluxcd.io"
+
+// KustomizationPaths returns path and the paths of all Flux Kustomizations reachable from it.
+// The output of each path is searched for Flux Kustomizations whose spec.path is resolved relative to root
+// and followed recursively. Paths which don't exist below root (for instance of another source) are skipped.
+func KustomizationPaths(ctx context.Context, root, path string) ([]string, error) {
+	paths := []string{path}
+	seen := map[string]bool{absPath(path): true}
+
+	for i := 0; i < len(paths); i++ {
+		index, err := Kustomize(ctx, paths[i])
+		if err != nil {
+			return nil, fmt.Errorf("failed to build %s: %w", paths[i], err)
+		}
+
+		for _, r := range index.Resources() {
+			gvk := schema.FromAPIVersionAndKind(r.GetApiVersion(), r.GetKind())
+			if gvk.Group != fluxKustomizationGroup || gvk.Kind != "Kustomization" {
+				continue
+			}
+
+			specPath, _ := r.GetString("spec.path")
+			target := filepath.Join(root, strings.TrimPrefix(filepath.Clean("/"+specPath), "/"))
+			if seen[absPath(target)] {
+				continue
+			}
+			seen[absPath(target)] = true
+
+			if _, err := os.Stat(target); err != nil {
+				continue
+			}
+
+			paths = append(paths, target)
+		}
+	}
+
+	return paths, nil
+}
+
+// absPath returns the absolute path or the cleaned path if it can't be resolved.
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+
+	return filepath.Clean(path)
+}
+
+// SubstituteAnnotation disables the post build substitution of a resource if set to disabled, like in the
+// kustomize-controller.
+const SubstituteAnnotation = "kustomize.toolkit.fluxcd.io/substitute"
+
+// fluxKustomization holds the fields of a Flux Kustomization supported by the builder.
+type fluxKustomization struct {
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              struct {
+		Path            string `json:"path,omitempty"`
+		TargetNamespace string `json:"targetNamespace,omitempty"`
+		SourceRef       struct {
+			Kind      string `json:"kind"`
+			Name      string `json:"name"`
+			Namespace string `json:"namespace,omitempty"`
+		} `json:"sourceRef"`
+		PostBuild *struct {
+			Substitute     map[string]string `json:"substitute,omitempty"`
+			SubstituteFrom []struct {
+				Kind     string `json:"kind"`
+				Name     string `json:"name"`
+				Optional bool   `json:"optional,omitempty"`
+			} `json:"substituteFrom,omitempty"`
+		} `json:"postBuild,omitempty"`
+	} `json:"spec"`
+}
+
+// KustomizationOpts configures the builder of Flux Kustomizations.
+type KustomizationOpts struct {
+	// RepositoryRoot is the directory spec.path is relative to for Kustomizations of a local source.
+	RepositoryRoot string
+	// LocalSources are the sources (namespace/name) which are the built repository itself, for instance the
+	// GitRepository Flux was bootstrapped with. Defaults to flux-system/flux-system.
+	LocalSources []string
+	// Sources checks out GitRepositories and Buckets, it should be the helm builder of the run so each source is
+	// only fetched once. A builder with the default options is used if not set.
+	Sources *Helm
+	// StrictSubstitution fails the build of resources referencing variables of spec.postBuild which are neither
+	// substituted nor have a default with an UnsetVariableError.
+	StrictSubstitution bool
+}
+
+// Kustomization builds Flux Kustomizations.
+type Kustomization struct {
+	Logger logr.Logger
+	opts   KustomizationOpts
+}
+
+// NewKustomizationBuilder returns a builder of Flux Kustomizations.
+func NewKustomizationBuilder(logger logr.Logger, opts KustomizationOpts) *Kustomization {
+	if opts.RepositoryRoot == "" {
+		opts.RepositoryRoot = "."
+	}
+
+	if opts.LocalSources == nil {
+		opts.LocalSources = []string{"flux-system/flux-system"}
+	}
+
+	if opts.Sources == nil {
+		opts.Sources = NewHelmBuilder(logger, HelmOpts{})
+	}
+
+	return &Kustomization{
+		Logger: logger,
+		opts:   opts,
+	}
+}
+
+// Build runs kustomize over spec.path of the source of the Kustomization, sets spec.targetNamespace on the namespaced
+// resources and substitutes the variables of spec.postBuild. The source is looked up in db unless it is a local source.
+// The scope of kinds is looked up like by the namespace post renderer of HelmReleases built by the Sources.
+func (k *Kustomization) Build(ctx context.Context, r *resource.Resource, db map[ref]*resource.Resource) (resmap.ResMap, error) {
+	raw, err := r.AsYAML()
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal kustomization as yaml: %w", err)
+	}
+
+	var ks fluxKustomization
+	if err := yaml.Unmarshal(raw, &ks); err != nil {
+		return nil, fmt.Errorf("failed decode resource to kustomization: %w", err)
+	}
+
+	root, err := k.sourceRoot(ctx, ks, db)
+	if err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(root, strings.TrimPrefix(filepath.Clean("/"+ks.Spec.Path), "/"))
+	k.logger(ctx).V(1).Info("build kustomization path", "path", path)
+
+	m, err := Kustomize(ctx, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build kustomization `%s/%s`: %w", ks.GetNamespace(), ks.GetName(), err)
+	}
+
+	if ks.Spec.TargetNamespace != "" {
+		if err := postrenderer.SetNamespace(m, ks.Spec.TargetNamespace, k.opts.Sources.scopes); err != nil {
+			return nil, fmt.Errorf("failed to set target namespace of kustomization `%s/%s`: %w", ks.GetNamespace(), ks.GetName(), err)
+		}
+
+		m.RemoveBuildAnnotations()
+	}
+
+	if ks.Spec.PostBuild == nil {
+		return m, nil
+	}
+
+	return k.substitute(ctx, ks, m, db)
+}
+
+// sourceRoot returns the directory of the source of the Kustomization.
+func (k *Kustomization) sourceRoot(ctx context.Context, ks fluxKustomization, db map[ref]*resource.Resource) (string, error) {
+	namespace := ks.Spec.SourceRef.Namespace
+	if namespace == "" {
+		namespace = ks.GetNamespace()
+	}
+
+	if slices.Contains(k.opts.LocalSources, namespace+"/"+ks.Spec.SourceRef.Name) {
+		return k.opts.RepositoryRoot, nil
+	}
+
+	lookupRef := ref{
+		GroupKind: schema.GroupKind{
+			Group: sourcev1.GroupVersion.Group,
+			Kind:  ks.Spec.SourceRef.Kind,
+		},
+		Name:      ks.Spec.SourceRef.Name,
+		Namespace: namespace,
+	}
+
+	source, ok := db[lookupRef]
+	if !ok {
+		return "", fmt.Errorf("no source `%s` found for kustomization `%s/%s`", ResourceName(lookupRef.Kind, namespace, lookupRef.Name), ks.GetNamespace(), ks.GetName())
+	}
+
+	b, err := source.AsYAML()
+	if err != nil {
+		return "", fmt.Errorf("failed marshal source as yaml: %w", err)
+	}
+
+	var checkout *sourceCheckout
+	switch lookupRef.Kind {
+	case sourcev1.GitRepositoryKind:
+		repo := &sourcev1.GitRepository{}
+		if err := yaml.Unmarshal(b, repo); err != nil {
+			return "", fmt.Errorf("failed to decode into gitrepository: %w", err)
+		}
+
+		checkout, err = k.opts.Sources.checkoutGitRepository(ctx, repo, db)
+	case sourcev1beta2.BucketKind:
+		obj := &sourcev1beta2.Bucket{}
+		if err := yaml.Unmarshal(b, obj); err != nil {
+			return "", fmt.Errorf("failed to decode into bucket: %w", err)
+		}
+
+		checkout, err = k.opts.Sources.checkoutBucket(ctx, obj, db)
+	default:
+		return "", fmt.Errorf("unsupported sourceRef kind `%s` of kustomization `%s/%s`, only %s and %s are supported", lookupRef.Kind, ks.GetNamespace(), ks.GetName(), sourcev1.GitRepositoryKind, sourcev1beta2.BucketKind)
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return checkout.root(), nil
+}
+
+// substitute replaces the variables of spec.postBuild in all resources which do not disable it by the
+// SubstituteAnnotation. The variables of substituteFrom are read in order, spec.postBuild.substitute wins.
+func (k *Kustomization) substitute(ctx context.Context, ks fluxKustomization, m resmap.ResMap, db map[ref]*resource.Resource) (resmap.ResMap, error) {
+	vars := make(map[string]Substitution)
+	sensitive := make(map[string]bool)
+
+	for _, from := range ks.Spec.PostBuild.SubstituteFrom {
+		lookupRef := ref{
+			GroupKind: schema.GroupKind{Kind: from.Kind},
+			Name:      from.Name,
+			Namespace: ks.GetNamespace(),
+		}
+
+		res, ok := db[lookupRef]
+		if !ok {
+			if from.Optional {
+				continue
+			}
+
+			return nil, fmt.Errorf("could not find substitutions `%s.%s/%s` for kustomization `%s/%s`", from.Kind, ks.GetNamespace(), from.Name, ks.GetNamespace(), ks.GetName())
+		}
+
+		b, err := res.AsYAML()
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal as yaml: %w", err)
+		}
+
+		source := ResourceName(from.Kind, ks.GetNamespace(), from.Name)
+		switch from.Kind {
+		case "ConfigMap":
+			var obj corev1.ConfigMap
+			if err := yaml.Unmarshal(b, &obj); err != nil {
+				return nil, fmt.Errorf("failed decode substitutions as `v1.%s`: %w", from.Kind, err)
+			}
+
+			for name, value := range obj.Data {
+				vars[name] = Substitution{Variable: name, Source: source, Value: value}
+				sensitive[name] = false
+			}
+		case "Secret":
+			var obj corev1.Secret
+			if err := yaml.Unmarshal(b, &obj); err != nil {
+				return nil, fmt.Errorf("failed decode substitutions as `v1.%s`: %w", from.Kind, err)
+			}
+
+			for name, value := range obj.StringData {
+				vars[name] = Substitution{Variable: name, Source: source, Value: value}
+				sensitive[name] = true
+			}
+
+			for name, value := range obj.Data {
+				vars[name] = Substitution{Variable: name, Source: source, Value: string(value)}
+				sensitive[name] = true
+			}
+		default:
+			return nil, fmt.Errorf("unsupported substituteFrom kind '%s'", from.Kind)
+		}
+	}
+
+	for name, value := range ks.Spec.PostBuild.Substitute {
+		vars[name] = Substitution{Variable: name, Source: "substitute", Value: value}
+		sensitive[name] = false
+	}
+
+	recorder := SubstitutionRecorderFrom(ctx)
+	var manifests []string
+	for _, r := range m.Resources() {
+		b, err := r.AsYAML()
+		if err != nil {
+			return nil, err
+		}
+
+		if r.GetAnnotations()[SubstituteAnnotation] == "disabled" || substitutionDisabled(r.GetAnnotations()) {
+			manifests = append(manifests, string(b))
+			continue
+		}
+
+		if k.opts.StrictSubstitution {
+			if err := checkUnsetVariables(ResourceName(r.GetKind(), r.GetNamespace(), r.GetName()), string(b), func(name string) bool {
+				_, ok := vars[name]
+				return ok
+			}); err != nil {
+				return nil, fmt.Errorf("failed to substitute variables in kustomization `%s/%s`: %w", ks.GetNamespace(), ks.GetName(), err)
+			}
+		}
+
+		recorded := make(map[string]bool)
+		substituted, err := envsubst.Eval(string(b), func(name string) string {
+			substitution, ok := vars[name]
+			if !ok {
+				substitution = Substitution{Variable: name, Source: "unset"}
+			}
+
+			if !recorded[name] {
+				recorded[name] = true
+				substitution.Resource = ResourceName(r.GetKind(), r.GetNamespace(), r.GetName())
+				recorder.Record(substitution, sensitive[name])
+			}
+
+			return substitution.Value
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to substitute variables of %s in kustomization `%s/%s`: %w", ResourceName(r.GetKind(), r.GetNamespace(), r.GetName()), ks.GetNamespace(), ks.GetName(), err)
+		}
+
+		manifests = append(manifests, substituted)
+	}
+
+	return resmap.NewFactory(provider.NewDefaultDepProvider().GetResourceFactory()).NewResMapFromBytes([]byte(strings.Join(manifests, "---\n")))
+}
+
+func (k *Kustomization) logger(ctx context.Context) logr.Logger {
+	if logger, err := logr.FromContext(ctx); err == nil {
+		return logger
+	}
+
+	return k.Logger
+}
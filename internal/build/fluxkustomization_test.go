@@ -0,0 +1,359 @@
+package build
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/doodlescheduling/flux-build/internal/helm/postrenderer"
+	"github.com/go-logr/logr"
+	. "github.com/onsi/gomega"
+	"sigs.k8s.io/kustomize/api/resmap"
+)
+
+func TestKustomizationPaths(t *testing.T) {
+	g := NewWithT(t)
+	root := t.TempDir()
+
+	files := map[string]string{
+		"clusters/prod/infrastructure.yaml": `apiVersion: kustomize.toolkit.fluxcd.io/v1
+kind: Kustomization
+metadata:
+  name: infrastructure
+  namespace: flux-system
+spec:
+  path: ./infrastructure/prod
+---
+apiVersion: kustomize.toolkit.fluxcd.io/v1
+kind: Kustomization
+metadata:
+  name: apps
+  namespace: flux-system
+spec:
+  dependsOn:
+  - name: infrastructure
+  path: ./apps
+---
+apiVersion: kustomize.toolkit.fluxcd.io/v1
+kind: Kustomization
+metadata:
+  name: external
+  namespace: flux-system
+spec:
+  path: ./does-not-exist`,
+		"infrastructure/prod/namespace.yaml": `apiVersion: v1
+kind: Namespace
+metadata:
+  name: infrastructure`,
+		// The apps refer back to the infrastructure which must not be built twice
+		"apps/infrastructure.yaml": `apiVersion: kustomize.toolkit.fluxcd.io/v1
+kind: Kustomization
+metadata:
+  name: infrastructure-again
+  namespace: flux-system
+spec:
+  path: infrastructure/prod`,
+	}
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		g.Expect(os.MkdirAll(filepath.Dir(path), 0700)).To(Succeed())
+		g.Expect(os.WriteFile(path, []byte(content), 0600)).To(Succeed())
+	}
+
+	paths, err := KustomizationPaths(context.Background(), root, filepath.Join(root, "clusters/prod"))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(paths).To(Equal([]string{
+		filepath.Join(root, "clusters/prod"),
+		filepath.Join(root, "infrastructure/prod"),
+		filepath.Join(root, "apps"),
+	}))
+}
+
+func TestKustomizationBuild(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"apps/configmap.yaml": `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app
+  namespace: apps
+data:
+  cluster: ${cluster}
+  region: ${region:=eu-west-1}
+  token: ${token}
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: raw
+  namespace: apps
+  annotations:
+    kustomize.toolkit.fluxcd.io/substitute: disabled
+data:
+  script: echo ${cluster}
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: operator
+  namespace: apps
+  annotations:
+    flux-build.doodlescheduling.com/envsubst: disabled
+data:
+  template: ${cluster}
+`,
+	}
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		NewWithT(t).Expect(os.MkdirAll(filepath.Dir(path), 0700)).To(Succeed())
+		NewWithT(t).Expect(os.WriteFile(path, []byte(content), 0600)).To(Succeed())
+	}
+
+	vars := `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: vars
+  namespace: flux-system
+data:
+  cluster: dev
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: secret-vars
+  namespace: flux-system
+data:
+  token: c2VjcmV0
+---
+apiVersion: source.toolkit.fluxcd.io/v1beta2
+kind: OCIRepository
+metadata:
+  name: manifests
+  namespace: flux-system
+spec:
+  url: oci://ghcr.io/org/manifests
+`
+
+	newKustomization := func(sourceRef, postBuild string) string {
+		return `apiVersion: kustomize.toolkit.fluxcd.io/v1
+kind: Kustomization
+metadata:
+  name: apps
+  namespace: flux-system
+spec:
+  path: ./apps
+  sourceRef:
+` + sourceRef + postBuild
+	}
+
+	tests := []struct {
+		name                string
+		kustomization       string
+		strict              bool
+		expectData          map[string]string
+		expectSubstitutions []Substitution
+		expectErr           string
+	}{
+		{
+			name:          "local source without post build",
+			kustomization: newKustomization("    kind: GitRepository\n    name: flux-system\n", ""),
+			expectData: map[string]string{
+				"cluster": "${cluster}",
+				"region":  "${region:=eu-west-1}",
+				"token":   "${token}",
+			},
+		},
+		{
+			name: "substitute",
+			kustomization: newKustomization("    kind: GitRepository\n    name: flux-system\n", `  postBuild:
+    substitute:
+      cluster: prod
+    substituteFrom:
+    - kind: ConfigMap
+      name: vars
+    - kind: Secret
+      name: secret-vars
+    - kind: ConfigMap
+      name: does-not-exist
+      optional: true
+`),
+			expectData: map[string]string{
+				"cluster": "prod",
+				"region":  "eu-west-1",
+				"token":   "secret",
+			},
+			expectSubstitutions: []Substitution{
+				{Resource: "ConfigMap/apps/app", Variable: "cluster", Source: "substitute", Value: "prod"},
+				{Resource: "ConfigMap/apps/app", Variable: "region", Source: "unset"},
+				{Resource: "ConfigMap/apps/app", Variable: "token", Source: "Secret/flux-system/secret-vars", Value: "<redacted>"},
+			},
+		},
+		{
+			name: "strict substitution",
+			kustomization: newKustomization("    kind: GitRepository\n    name: flux-system\n", `  postBuild:
+    substitute:
+      cluster: prod
+`),
+			strict:    true,
+			expectErr: "failed to substitute variables in kustomization `flux-system/apps`: variable `token` referenced in ConfigMap/apps/app is not set: `token: ${token}`",
+		},
+		{
+			name: "missing substitutions",
+			kustomization: newKustomization("    kind: GitRepository\n    name: flux-system\n", `  postBuild:
+    substituteFrom:
+    - kind: ConfigMap
+      name: does-not-exist
+`),
+			expectErr: "could not find substitutions `ConfigMap.flux-system/does-not-exist` for kustomization `flux-system/apps`",
+		},
+		{
+			name:          "missing source",
+			kustomization: newKustomization("    kind: GitRepository\n    name: apps\n", ""),
+			expectErr:     "no source `GitRepository/flux-system/apps` found for kustomization `flux-system/apps`",
+		},
+		{
+			name:          "unsupported source",
+			kustomization: newKustomization("    kind: OCIRepository\n    name: manifests\n", ""),
+			expectErr:     "unsupported sourceRef kind `OCIRepository` of kustomization `flux-system/apps`",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			k := NewKustomizationBuilder(logr.Discard(), KustomizationOpts{
+				RepositoryRoot:     root,
+				StrictSubstitution: tt.strict,
+			})
+
+			db := newResourceIndex(t, vars)
+			ks := newResourceIndex(t, tt.kustomization)
+
+			recorder := NewSubstitutionRecorder()
+			ctx := WithSubstitutionRecorder(context.Background(), recorder)
+
+			var m resmap.ResMap
+			var err error
+			for _, r := range ks {
+				m, err = k.Build(ctx, r, db)
+			}
+
+			if tt.expectErr != "" {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tt.expectErr))
+				return
+			}
+
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(m.Resources()).To(HaveLen(3))
+
+			g.Expect(m.Resources()[0].GetDataMap()).To(Equal(tt.expectData))
+
+			// Resources which disable the substitution are kept as is
+			g.Expect(m.Resources()[1].GetDataMap()).To(Equal(map[string]string{"script": "echo ${cluster}"}))
+			g.Expect(m.Resources()[2].GetDataMap()).To(Equal(map[string]string{"template": "${cluster}"}))
+
+			g.Expect(recorder.Substitutions()).To(Equal(tt.expectSubstitutions))
+		})
+	}
+}
+
+func TestKustomizationBuildTargetNamespace(t *testing.T) {
+	g := NewWithT(t)
+	root := t.TempDir()
+
+	manifests := `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app
+  namespace: apps
+data:
+  cluster: ${cluster}
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: defaults
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: app
+---
+apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: tenants.example.com
+spec:
+  group: example.com
+  scope: Cluster
+  names:
+    kind: Tenant
+---
+apiVersion: example.com/v1
+kind: Tenant
+metadata:
+  name: team
+---
+apiVersion: backup.example.com/v1
+kind: BackupPolicy
+metadata:
+  name: nightly
+`
+	g.Expect(os.MkdirAll(filepath.Join(root, "apps"), 0700)).To(Succeed())
+	g.Expect(os.WriteFile(filepath.Join(root, "apps", "manifests.yaml"), []byte(manifests), 0600)).To(Succeed())
+
+	// The scope of kinds whose CRDs are not part of the build is declared by the api resources of the Sources
+	k := NewKustomizationBuilder(logr.Discard(), KustomizationOpts{
+		RepositoryRoot: root,
+		Sources: NewHelmBuilder(logr.Discard(), HelmOpts{
+			APIResources: []postrenderer.APIResource{{APIVersion: "backup.example.com/v1", Kind: "BackupPolicy"}},
+		}),
+	})
+
+	ks := newResourceIndex(t, `apiVersion: kustomize.toolkit.fluxcd.io/v1
+kind: Kustomization
+metadata:
+  name: apps
+  namespace: flux-system
+spec:
+  path: ./apps
+  targetNamespace: tenant
+  sourceRef:
+    kind: GitRepository
+    name: flux-system
+  postBuild:
+    substitute:
+      cluster: prod
+`)
+
+	recorder := NewSubstitutionRecorder()
+	ctx := WithSubstitutionRecorder(context.Background(), recorder)
+
+	var m resmap.ResMap
+	var err error
+	for _, r := range ks {
+		m, err = k.Build(ctx, r, nil)
+	}
+	g.Expect(err).ToNot(HaveOccurred())
+
+	namespaces := make(map[string]string)
+	for _, r := range m.Resources() {
+		namespaces[r.GetKind()+"/"+r.GetName()] = r.GetNamespace()
+		g.Expect(r.GetAnnotations()).To(BeEmpty())
+	}
+	g.Expect(namespaces).To(Equal(map[string]string{
+		"ConfigMap/app":      "tenant",
+		"ConfigMap/defaults": "tenant",
+		"ClusterRole/app":    "",
+		"CustomResourceDefinition/tenants.example.com": "",
+		"Tenant/team":          "",
+		"BackupPolicy/nightly": "",
+	}))
+
+	// The variables are substituted once the target namespace is set
+	g.Expect(recorder.Substitutions()).To(Equal([]Substitution{
+		{Resource: "ConfigMap/tenant/app", Variable: "cluster", Source: "substitute", Value: "prod"},
+	}))
+}
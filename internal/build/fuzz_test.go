@@ -0,0 +1,118 @@
+package build
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/doodlescheduling/flux-build/internal/cachemgr"
+	"github.com/doodlescheduling/flux-build/internal/helm/postrenderer"
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+func FuzzDecodeRelease(f *testing.F) {
+	f.Add([]byte(`apiVersion: helm.toolkit.fluxcd.io/v2
+kind: HelmRelease
+metadata:
+  name: release
+  namespace: ${NAMESPACE:=default}
+spec:
+  chart:
+    spec:
+      chart: podinfo
+      version: ${VERSION}
+      sourceRef:
+        kind: HelmRepository
+        name: podinfo
+  values:
+    a: &a [1, 2]
+    b: *a
+`))
+	f.Add([]byte("a: &a [*a]"))
+	f.Add([]byte("${"))
+
+	cache, err := cachemgr.New("none", "")
+	if err != nil {
+		f.Fatal(err)
+	}
+	h := NewHelmBuilder(logr.Discard(), HelmOpts{Cache: cache})
+
+	f.Fuzz(func(t *testing.T, raw []byte) {
+		_, _, _, _ = h.decodeRelease(context.Background(), raw)
+	})
+}
+
+func FuzzComposeValues(f *testing.F) {
+	f.Add("a.b", "value")
+	f.Add("list[0]", "'quoted'")
+	f.Add("", "key: value")
+	f.Add("a[1].b", "\"1\"")
+	f.Add("a[99999999]", "1")
+
+	cache, err := cachemgr.New("none", "")
+	if err != nil {
+		f.Fatal(err)
+	}
+	h := NewHelmBuilder(logr.Discard(), HelmOpts{Cache: cache})
+
+	f.Fuzz(func(t *testing.T, targetPath, value string) {
+		cm := corev1.ConfigMap{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+			ObjectMeta: metav1.ObjectMeta{Name: "values", Namespace: "default"},
+			Data:       map[string]string{"values.yaml": value},
+		}
+		b, err := yaml.Marshal(cm)
+		if err != nil {
+			t.Skip()
+		}
+
+		hr := helmv2.HelmRelease{
+			ObjectMeta: metav1.ObjectMeta{Name: "release", Namespace: "default"},
+			Spec: helmv2.HelmReleaseSpec{
+				ValuesFrom: []helmv2.ValuesReference{
+					{Kind: "ConfigMap", Name: "values", TargetPath: targetPath},
+				},
+			},
+		}
+
+		_, _ = h.composeValues(context.Background(), newResourceIndex(t, string(b)), hr)
+	})
+}
+
+func FuzzPostRenderers(f *testing.F) {
+	f.Add([]byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: cm
+data:
+  a: b
+---
+apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: Secret
+  metadata:
+    name: secret
+`))
+	f.Add([]byte("a: &a [*a, *a]"))
+	f.Add([]byte("kind: List\nitems: [[], {}]"))
+
+	hr := &helmv2.HelmRelease{
+		ObjectMeta: metav1.ObjectMeta{Name: "release", Namespace: "default"},
+		Spec: helmv2.HelmReleaseSpec{
+			TargetNamespace: "target",
+		},
+	}
+
+	f.Fuzz(func(t *testing.T, manifests []byte) {
+		renderer, _ := postrenderer.BuildPostRenderers(hr, postrenderer.Options{
+			Validate: DefaultDocumentLimits.Check,
+		})
+		_, _ = renderer.Run(bytes.NewBuffer(manifests))
+	})
+}
@@ -0,0 +1,311 @@
+package build
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/doodlescheduling/flux-build/internal/git"
+	"github.com/doodlescheduling/flux-build/internal/helm/chart"
+	"github.com/doodlescheduling/flux-build/internal/helm/repository"
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+	gitignore "github.com/monochromegane/go-gitignore"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/kustomize/api/resource"
+	"sigs.k8s.io/yaml"
+)
+
+// defaultIgnorePatterns are excluded from GitRepository artifacts unless spec.ignore is set, like in the source-controller.
+const defaultIgnorePatterns = `.git/
+.gitignore
+.gitmodules
+.gitattributes
+*.jpg
+*.jpeg
+*.gif
+*.png
+*.wmv
+*.flv
+*.tar.gz
+*.zip
+.github/
+.circleci/
+.travis.yml
+.gitlab-ci.yml
+appveyor.yml
+.drone.yml
+cloudbuild.yaml
+codeship-services.yml
+codeship-steps.yml
+**/.goreleaser.yml
+**/.sops.yaml
+**/.flux.yaml
+`
+
+// sourceIgnoreFile holds additional ignore patterns within a GitRepository.
+const sourceIgnoreFile = ".sourceignore"
+
+// sourceCheckout is the content of a GitRepository or Bucket, it is shared by all releases of a run.
+type sourceCheckout struct {
+	once     sync.Once
+	dir      string
+	revision string
+	err      error
+}
+
+// root returns the path of the checked out content.
+func (c *sourceCheckout) root() string {
+	return filepath.Join(c.dir, "source")
+}
+
+// buildFromGitRepository packages the chart directory of a HelmRelease referencing a GitRepository.
+// Values files are resolved relative to the chart directory or the repository root and merged into the packaged chart.
+func (h *Helm) buildFromGitRepository(ctx context.Context, hr *helmv2.HelmRelease, source *resource.Resource, db map[ref]*resource.Resource) (*chart.Build, error) {
+	b, err := source.AsYAML()
+	if err != nil {
+		return nil, fmt.Errorf("failed marshal gitrepository as yaml: %w", err)
+	}
+
+	repo := &sourcev1.GitRepository{}
+	if err := yaml.Unmarshal(b, repo); err != nil {
+		return nil, fmt.Errorf("failed to decode into gitrepository: %w", err)
+	}
+
+	checkout, err := h.checkoutGitRepository(ctx, repo, db)
+	if err != nil {
+		return nil, err
+	}
+
+	return h.buildFromCheckout(ctx, hr, "gitrepository", repo, checkout, db)
+}
+
+// buildFromCheckout packages the chart directory of a HelmRelease from the checked out content of a source.
+// Values files are resolved relative to the chart directory, or relative to the root of the source if they don't
+// exist there, and merged into the packaged chart. They must not resolve outside of the source.
+func (h *Helm) buildFromCheckout(ctx context.Context, hr *helmv2.HelmRelease, kind string, source metav1.Object, checkout *sourceCheckout, db map[ref]*resource.Resource) (*chart.Build, error) {
+	spec := hr.Spec.Chart.Spec
+	valuesFiles := spec.ValuesFiles
+	if spec.IgnoreMissingValuesFiles {
+		var chartDir string
+		if info, err := os.Stat(filepath.Join(checkout.root(), spec.Chart)); err == nil && info.IsDir() {
+			chartDir = spec.Chart
+		}
+
+		var existing []string
+		for _, p := range valuesFiles {
+			// Values files outside of the source are kept so the build fails
+			if _, err := chart.ResolveValuesFile(checkout.root(), chartDir, p); !errors.Is(err, chart.ErrValuesFileNotFound) {
+				existing = append(existing, p)
+			}
+		}
+		valuesFiles = existing
+	}
+
+	var versionMetadata string
+	if spec.ReconcileStrategy == sourcev1.ReconcileStrategyRevision {
+		versionMetadata = checkout.revision[:min(12, len(checkout.revision))]
+	}
+
+	key := strings.Join([]string{kind, checkout.dir, spec.Chart, strings.Join(valuesFiles, ","), versionMetadata}, "/")
+	result, err, _ := h.charts.Do(key, func() (interface{}, error) {
+		out, err := os.CreateTemp(checkout.dir, "chart-*.tgz")
+		if err != nil {
+			return nil, err
+		}
+		_ = out.Close()
+
+		dm := chart.NewDependencyManager(chart.WithDownloaderCallback(func(url string) (repository.Downloader, error) {
+			repo, err := h.getDependencyRepository(url, db)
+			if err != nil {
+				return nil, err
+			}
+
+			return h.getChartRepository(ctx, repo, db)
+		}))
+
+		cb := chart.NewLocalBuilder(dm)
+		build, err := cb.Build(ctx, chart.LocalReference{
+			WorkDir: checkout.root(),
+			Path:    spec.Chart,
+		}, out.Name(), chart.BuildOptions{
+			ValuesFiles:              valuesFiles,
+			ChartRelativeValuesFiles: true,
+			VersionMetadata:          versionMetadata,
+			Force:                    true,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to build chart `%s` from %s %s/%s: %w", spec.Chart, kind, source.GetNamespace(), source.GetName(), err)
+		}
+
+		return build, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	build := *result.(*chart.Build)
+	return &build, nil
+}
+
+// checkoutGitRepository checks out the reference of the GitRepository once per run.
+func (h *Helm) checkoutGitRepository(ctx context.Context, repo *sourcev1.GitRepository, db map[ref]*resource.Resource) (*sourceCheckout, error) {
+	var reference sourcev1.GitRepositoryRef
+	if repo.Spec.Reference != nil {
+		reference = *repo.Spec.Reference
+	}
+
+	var secretName string
+	if repo.Spec.SecretRef != nil {
+		secretName = repo.Spec.SecretRef.Name
+	}
+
+	var ignore string
+	if repo.Spec.Ignore != nil {
+		ignore = *repo.Spec.Ignore
+	}
+
+	key := strings.Join([]string{repo.Spec.URL, reference.Branch, reference.Tag, reference.SemVer, reference.Name, reference.Commit, repo.Namespace, secretName, ignore}, "\x00")
+	v, _ := h.checkouts.LoadOrStore(key, &sourceCheckout{})
+	checkout := v.(*sourceCheckout)
+
+	checkout.once.Do(func() {
+		checkout.dir, checkout.revision, checkout.err = h.cloneGitRepository(ctx, repo, reference, db)
+	})
+
+	return checkout, checkout.err
+}
+
+func (h *Helm) cloneGitRepository(ctx context.Context, repo *sourcev1.GitRepository, reference sourcev1.GitRepositoryRef, db map[ref]*resource.Resource) (string, string, error) {
+	ctx, cancel := withTimeout(ctx, h.repositoryTimeout(repo, repo.Spec.URL))
+	defer cancel()
+
+	opts := git.Options{
+		Proxy: h.opts.Proxy,
+	}
+
+	if repo.Spec.SecretRef != nil {
+		secret, lookupRef, err := h.getSecret(repo.Spec.SecretRef.Name, repo.Namespace, db)
+		if err != nil {
+			return "", "", err
+		}
+
+		if secret == nil {
+			return "", "", fmt.Errorf("no secret `%v` found for gitrepository %s/%s", lookupRef, repo.Namespace, repo.Name)
+		}
+
+		caFile := secret.Data["ca.crt"]
+		if len(caFile) == 0 {
+			caFile = secret.Data["caFile"]
+		}
+
+		opts.Auth = &git.AuthOptions{
+			Username:    string(secret.Data["username"]),
+			Password:    string(secret.Data["password"]),
+			BearerToken: string(secret.Data["bearerToken"]),
+			Identity:    secret.Data["identity"],
+			KnownHosts:  secret.Data["known_hosts"],
+			CAFile:      caFile,
+		}
+	}
+
+	gitRef := git.Reference{
+		Branch: reference.Branch,
+		Tag:    reference.Tag,
+		Name:   reference.Name,
+		Commit: reference.Commit,
+	}
+
+	if reference.Commit == "" && reference.Name == "" && reference.SemVer != "" {
+		tags, err := git.ListTags(ctx, repo.Spec.URL, opts)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to list tags of gitrepository %s/%s: %w", repo.Namespace, repo.Name, err)
+		}
+
+		tag, err := tagBySemver(tags, reference.SemVer, "")
+		if err != nil {
+			return "", "", fmt.Errorf("failed to resolve semver `%s` of gitrepository %s/%s from the tags [%s]: %w", reference.SemVer, repo.Namespace, repo.Name, strings.Join(tags, ", "), err)
+		}
+
+		gitRef.Tag = tag
+	}
+
+	dir, err := os.MkdirTemp("", "gitrepository")
+	if err != nil {
+		return "", "", err
+	}
+
+	worktree := filepath.Join(dir, "source")
+	if err := os.Mkdir(worktree, 0700); err != nil {
+		return dir, "", err
+	}
+
+	commit, err := git.Checkout(ctx, repo.Spec.URL, worktree, gitRef, opts)
+	if err != nil {
+		return dir, "", fmt.Errorf("failed to checkout gitrepository %s/%s: %w", repo.Namespace, repo.Name, err)
+	}
+
+	h.logger(ctx).Info("checked out gitrepository", "gitrepository", repo.Namespace+"/"+repo.Name, "tag", gitRef.Tag, "commit", commit)
+
+	if err := applyIgnore(worktree, repo.Spec.Ignore); err != nil {
+		return dir, "", fmt.Errorf("failed to apply ignore patterns of gitrepository %s/%s: %w", repo.Namespace, repo.Name, err)
+	}
+
+	return dir, commit, nil
+}
+
+// applyIgnore removes the files excluded by spec.ignore (or the default patterns) and the
+// .sourceignore files of the repository from dir.
+func applyIgnore(dir string, ignore *string) error {
+	patterns := defaultIgnorePatterns
+	if ignore != nil {
+		patterns = *ignore
+	}
+
+	matchers := []gitignore.IgnoreMatcher{
+		gitignore.NewGitIgnoreFromReader(dir, strings.NewReader(patterns)),
+	}
+
+	// .git is always removed, it's not part of an artifact
+	if err := os.RemoveAll(filepath.Join(dir, ".git")); err != nil {
+		return err
+	}
+
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if path != dir {
+			for _, matcher := range matchers {
+				if !matcher.Match(path, d.IsDir()) {
+					continue
+				}
+
+				if err := os.RemoveAll(path); err != nil {
+					return err
+				}
+
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+
+				return nil
+			}
+		}
+
+		// Patterns of a .sourceignore apply to the directory and its descendants
+		if d.IsDir() {
+			if b, err := os.ReadFile(filepath.Join(path, sourceIgnoreFile)); err == nil {
+				matchers = append(matchers, gitignore.NewGitIgnoreFromReader(path, strings.NewReader(string(b))))
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,206 @@
+package build
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"github.com/go-logr/logr"
+	. "github.com/onsi/gomega"
+	"github.com/otiai10/copy"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// newChartRepository creates a git repository with the test chart in charts/helmchart and a tagged commit per tag.
+func newChartRepository(t *testing.T, tags ...string) string {
+	dir := t.TempDir()
+	if err := copy.Copy("../helm/testdata/charts/helmchart", filepath.Join(dir, "charts", "helmchart")); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"charts/helmchart/templates/ignored.yaml": "{{ fail \"ignored\" }}",
+		"charts/helmchart/ci/test.yaml":           "{{ fail \"ignored\" }}",
+		"charts/helmchart/.sourceignore":          "ci/\n",
+		"charts/helmchart/values-prod.yaml":       "replicaCount: 2\n",
+		"values/prod.yaml":                        "replicaCount: 3\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	git := func(args ...string) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+		)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %s: %s", strings.Join(args, " "), err, out)
+		}
+	}
+
+	git("init", "--quiet", "--initial-branch", "master")
+	git("add", ".")
+	git("commit", "--quiet", "-m", "init")
+	for _, tag := range tags {
+		git("commit", "--quiet", "--allow-empty", "-m", tag)
+		git("tag", tag)
+	}
+
+	return "file://" + dir
+}
+
+func TestBuildFromGitRepository(t *testing.T) {
+	url := newChartRepository(t, "v0.1.0", "v0.2.0", "v1.0.0")
+
+	newGitRepository := func(spec string) string {
+		return `apiVersion: source.toolkit.fluxcd.io/v1
+kind: GitRepository
+metadata:
+  name: charts
+  namespace: default
+spec:
+  url: ` + url + `
+` + spec
+	}
+
+	tests := []struct {
+		name          string
+		repository    string
+		valuesFiles   []string
+		expectVersion string
+		expectValues  map[string]interface{}
+		expectIgnored bool
+		expectErr     string
+	}{
+		{
+			name:          "default branch",
+			repository:    newGitRepository(""),
+			expectVersion: "0.1.0",
+		},
+		{
+			name:          "semver",
+			repository:    newGitRepository("  ref:\n    semver: \">=0.1.0 <1.0.0\"\n  ignore: |\n    /charts/helmchart/templates/ignored.yaml\n"),
+			expectVersion: "0.1.0",
+			expectIgnored: true,
+		},
+		{
+			name:        "values files relative to the repository",
+			repository:  newGitRepository("  ref:\n    tag: v1.0.0\n  ignore: |\n    /charts/helmchart/templates/ignored.yaml\n"),
+			valuesFiles: []string{"charts/helmchart/values.yaml", "values/prod.yaml"},
+			expectValues: map[string]interface{}{
+				"replicaCount": float64(3),
+			},
+			expectVersion: "0.1.0",
+			expectIgnored: true,
+		},
+		{
+			name:        "values files relative to the chart directory",
+			repository:  newGitRepository(""),
+			valuesFiles: []string{"values-prod.yaml"},
+			expectValues: map[string]interface{}{
+				"replicaCount": float64(2),
+			},
+			expectVersion: "0.1.0",
+		},
+		{
+			name:        "values files outside of the chart directory",
+			repository:  newGitRepository(""),
+			valuesFiles: []string{"values-prod.yaml", "../../values/prod.yaml"},
+			expectValues: map[string]interface{}{
+				"replicaCount": float64(3),
+			},
+			expectVersion: "0.1.0",
+		},
+		{
+			name:        "values files outside of the repository",
+			repository:  newGitRepository(""),
+			valuesFiles: []string{"../../../values.yaml"},
+			expectErr:   "values file '../../../values.yaml' resolves to '",
+		},
+		{
+			name:       "no matching tag",
+			repository: newGitRepository("  ref:\n    semver: \">=2.0.0\"\n"),
+			expectErr:  "failed to resolve semver `>=2.0.0` of gitrepository default/charts from the tags [v0.1.0, v0.2.0, v1.0.0]",
+		},
+		{
+			name:       "missing branch",
+			repository: newGitRepository("  ref:\n    branch: does-not-exist\n"),
+			expectErr:  "failed to checkout gitrepository default/charts",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			h := NewHelmBuilder(logr.Discard(), HelmOpts{})
+			defer h.Close()
+			db := newResourceIndex(t, tt.repository)
+
+			hr := &helmv2.HelmRelease{
+				ObjectMeta: metav1.ObjectMeta{Name: "release", Namespace: "default"},
+				Spec: helmv2.HelmReleaseSpec{
+					Chart: &helmv2.HelmChartTemplate{
+						Spec: helmv2.HelmChartTemplateSpec{
+							Chart:       "charts/helmchart",
+							ValuesFiles: tt.valuesFiles,
+							SourceRef: helmv2.CrossNamespaceObjectReference{
+								Kind: "GitRepository",
+								Name: "charts",
+							},
+						},
+					},
+				},
+			}
+
+			build, spec, err := h.resolveChart(context.Background(), hr, &chartVerification{}, db)
+			if tt.expectErr != "" {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tt.expectErr))
+				return
+			}
+
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(spec.ValuesFiles).To(BeEmpty())
+			g.Expect(build.Name).To(Equal("helmchart"))
+			g.Expect(build.Version).To(Equal(tt.expectVersion))
+
+			c, err := loader.Load(build.Path)
+			g.Expect(err).ToNot(HaveOccurred())
+			var templates []string
+			for _, template := range c.Templates {
+				templates = append(templates, template.Name)
+			}
+			if tt.expectIgnored {
+				g.Expect(templates).ToNot(ContainElement("templates/ignored.yaml"))
+			} else {
+				g.Expect(templates).To(ContainElement("templates/ignored.yaml"))
+			}
+
+			// The .sourceignore of the chart always applies
+			for _, file := range c.Files {
+				g.Expect(file.Name).ToNot(HavePrefix("ci/"))
+			}
+
+			for key, value := range tt.expectValues {
+				g.Expect(c.Values).To(HaveKeyWithValue(key, value))
+			}
+
+			g.Expect(h.Close()).To(Succeed())
+			g.Expect(build.Path).ToNot(BeAnExistingFile())
+		})
+	}
+}
@@ -2,13 +2,19 @@ package build
 
 import (
 	"context"
+	"crypto/sha256"
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/doodlescheduling/flux-build/internal/cachemgr"
@@ -18,8 +24,10 @@ import (
 	"github.com/doodlescheduling/flux-build/internal/helm/registry"
 	"github.com/doodlescheduling/flux-build/internal/helm/repository"
 	soci "github.com/doodlescheduling/flux-build/internal/oci"
+	"github.com/doodlescheduling/flux-build/internal/transport"
 	"github.com/drone/envsubst"
 	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	helmv2beta2 "github.com/fluxcd/helm-controller/api/v2beta2"
 	"github.com/fluxcd/pkg/oci"
 	"github.com/fluxcd/pkg/oci/auth/login"
 	"github.com/fluxcd/pkg/runtime/transform"
@@ -28,21 +36,29 @@ import (
 	"github.com/go-logr/logr"
 	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/sigstore/cosign/v2/pkg/cosign"
+	"golang.org/x/sync/singleflight"
 	helmaction "helm.sh/helm/v3/pkg/action"
+	helmchart "helm.sh/helm/v3/pkg/chart"
 	"helm.sh/helm/v3/pkg/chart/loader"
 	"helm.sh/helm/v3/pkg/chartutil"
 	helmgetter "helm.sh/helm/v3/pkg/getter"
+	"helm.sh/helm/v3/pkg/postrender"
 	helmreg "helm.sh/helm/v3/pkg/registry"
 	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/strvals"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
 	"k8s.io/apimachinery/pkg/types"
-	"k8s.io/helm/pkg/strvals"
 	"sigs.k8s.io/kustomize/api/resmap"
 	"sigs.k8s.io/kustomize/api/resource"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
 	"sigs.k8s.io/kustomize/kyaml/resid"
+	"sigs.k8s.io/yaml"
 )
 
 type HelmOpts struct {
@@ -53,26 +69,211 @@ type HelmOpts struct {
 	Getters          helmgetter.Providers
 	Decoder          runtime.Decoder
 	IncludeHelmHooks bool
+	// HelmHookTypes selects the hooks written to the output by their events, all hooks are included if empty.
+	// Hooks are included if IncludeHelmHooks is set or any hook type is selected.
+	HelmHookTypes []release.HookEvent
+	// InsecureRegistries is a list of OCI registry hosts (host:port) which are
+	// accessed via plain HTTP, in addition to HelmRepositories with spec.insecure.
+	InsecureRegistries []string
+	// SkipTLSVerify is a list of OCI registry hosts (host:port) whose TLS certificate is not verified, for instance
+	// registries with a self-signed certificate in a test environment. It is meant for development only.
+	SkipTLSVerify []string
+	// ClusterScopedKinds is a list of kinds in addition to the built-in cluster-scoped
+	// Kubernetes kinds which do not get a namespace assigned.
+	ClusterScopedKinds []string
+	// APIResources declare the scope of kinds like custom resources whose CRDs are not part of the build,
+	// see LoadAPIResources.
+	APIResources []postrenderer.APIResource
+	// NoDefaultKeychain disables the fallback to the docker config credentials for OCI
+	// HelmRepositories which neither have a secretRef nor a provider configured.
+	NoDefaultKeychain bool
+	// CommonLabels are added to all rendered resources unless the key is already set.
+	CommonLabels map[string]string
+	// CommonAnnotations are added to all rendered resources unless the key is already set.
+	CommonAnnotations map[string]string
+	// KeepLists keeps List objects in the rendered output instead of flattening them into their items.
+	KeepLists bool
+	// RekorURL is the Rekor transparency log used for keyless chart verification.
+	// The public Rekor instance is used if empty.
+	RekorURL string
+	// ControllerCompat toggles behaviour to match a specific helm-controller version.
+	// DefaultControllerCompat is used if nil.
+	ControllerCompat *ControllerCompat
+	// DocumentLimits bounds the HelmRelease, values and rendered manifests.
+	// DefaultDocumentLimits is used if nil.
+	DocumentLimits *DocumentLimits
+	// Proxy is used to access chart repositories and OCI registries.
+	// Hosts excluded by NO_PROXY are accessed directly. The proxy is configured
+	// from the environment (HTTPS_PROXY, HTTP_PROXY and NO_PROXY) if nil.
+	Proxy *url.URL
+	// MaxIdleConnsPerHost is the number of idle connections kept per host by the transports shared by all chart
+	// repositories and OCI registries, transport.DefaultMaxIdleConnsPerHost is used if nil.
+	MaxIdleConnsPerHost *int
+	// DisableKeepAlives uses every connection to chart repositories and OCI registries for a single request only.
+	DisableKeepAlives bool
+	// RepositoryTimeout bounds logging in, fetching the index and pulling a chart from a repository.
+	// DefaultRepositoryTimeout is used if nil, a zero duration disables the timeout.
+	RepositoryTimeout *time.Duration
+	// RepositoryTimeouts overrides the RepositoryTimeout of single repositories keyed by their URL
+	// or by namespace/name of the HelmRepository. The URL takes precedence.
+	RepositoryTimeouts map[string]time.Duration
+	// RepositoryMirrors are the URLs of mirrors of HelmRepositories keyed by the URL of the repository. If pulling a
+	// chart from a repository fails its mirrors are tried in order with the credentials of the repository.
+	RepositoryMirrors map[string][]string
+	// RetryMax is the number of retries of network operations against repositories which failed with a transient error
+	// (network errors, 5xx and 429 responses). DefaultRetryMax is used if nil.
+	RetryMax *int
+	// RetryBackoff is the initial backoff between retries, it doubles with every retry.
+	// DefaultRetryBackoff is used if nil.
+	RetryBackoff *time.Duration
+	// Action is the Helm action the releases are rendered with, ReleaseActionInstall if empty.
+	Action ReleaseAction
+	// ChartVersionOverrides replace spec.chart.spec.version of HelmReleases keyed by namespace/name of the HelmRelease
+	// or by the chart name, namespace/name takes precedence. Versions may be semver ranges.
+	ChartVersionOverrides map[string]string
+	// FailOnEmpty fails HelmReleases whose chart renders no resources, hooks don't count.
+	FailOnEmpty bool
+	// WarningsAsErrors fails HelmReleases with a RenderWarningsError if Helm warns while coalescing their values.
+	WarningsAsErrors bool
+	// UnsupportedVerify decides how charts whose spec.verify flux-build can't check are handled, UnsupportedVerifyError
+	// if empty.
+	UnsupportedVerify UnsupportedVerifyPolicy
+	// TargetPathTypes types the values set at valuesFrom targetPaths like helm-controller does instead of setting them
+	// as strings and enables type markers (!str, !int and !bool) at the end of targetPaths which override the typing,
+	// see setTargetPath. The markers are an extension of flux-build, the HelmRelease CRD rejects them.
+	TargetPathTypes bool
+	// Extensions enables extensions of flux-build to the Flux APIs which Flux itself rejects or ignores, for instance
+	// HelmRepositories of release assets declared by the RepositoryTypeAnnotation.
+	Extensions bool
+	// SkipSuspended builds charts of suspended HelmRepositories from the cache only.
+	SkipSuspended bool
+	// ExecPostRenderers pipe the manifests of every release through local commands after the post renderers of the
+	// HelmRelease, see LoadExecPostRenderers.
+	ExecPostRenderers []postrenderer.Exec
+	// KeepTempDirs writes the manifests of a release to a directory on disk for the kustomize step and keeps it, its
+	// path is logged at V(1). By default the manifests are only written to an in-memory filesystem.
+	KeepTempDirs bool
+	// SchemaWarnings logs values which violate the values.schema.json of the chart instead of failing the release.
+	SchemaWarnings bool
+	// UnusedValuesAllow are the paths of values which are never reported as not consumed by the chart, for instance
+	// extraEnv or *.podLabels. A path allows all keys below it, * matches a single key.
+	UnusedValuesAllow []string
+	// ValuesOverlays are merged in order on top of the values of the HelmReleases matched by their selector,
+	// they take precedence over spec.values.
+	ValuesOverlays []ValuesOverlay
+	// ValuesOverrides are applied in order after the ValuesOverlays with the semantics of helm --set and --set-string.
+	ValuesOverrides []ValuesOverride
+	// TLSPolicy restricts the TLS versions and cipher suites of the connections to repositories and registries,
+	// the Go defaults are used if nil.
+	TLSPolicy *TLSPolicy
+	// DumpValuesDir is the directory the merged values of every release are written to for debugging, annotated with
+	// the sources of the top-level keys. The rendered manifests are not affected. No values are written if empty.
+	DumpValuesDir string
+	// NotesDir is the directory the rendered NOTES.txt of every release is written to, nothing is written if empty.
+	NotesDir string
+	// ShowSecrets writes the values from Secrets to DumpValuesDir instead of redacting them.
+	ShowSecrets bool
+	// ReportValueConflicts records the keys of the values of every release which a later source overwrites while
+	// merging valuesFrom, spec.values, values overlays and overrides, see WithValueConflicts. The merged values are not
+	// affected.
+	ReportValueConflicts bool
+	// CheckDeterminism renders every release twice and fails it with a DeterminismError if the renders differ.
+	CheckDeterminism bool
+	// ReuseCharts keeps the resolved and loaded chart of every HelmRelease for the lifetime of the builder. Building
+	// a HelmRelease again whose chart, chart sources and their Secrets are unchanged skips resolving, downloading and
+	// loading the chart, for instance if only its values changed.
+	ReuseCharts bool
+	// StrictEnv fails HelmReleases referencing environment variables which are unset and have no default with an
+	// UnsetVariableError, they are substituted by an empty string otherwise.
+	StrictEnv bool
+	// NoEnvsubst disables the environment substitution of all HelmReleases, single HelmReleases are excluded by
+	// the EnvsubstAnnotation.
+	NoEnvsubst bool
+	// EnvDefaults are substituted for variables which are not set in the process environment, inline defaults
+	// like ${VAR:=x} only apply to variables which are neither set nor part of EnvDefaults.
+	EnvDefaults map[string]string
+	// EnvAllowlist restricts the environment substitution to the variables it allows, all others are kept as they are
+	// or fail HelmReleases with a DisallowedVariableError with StrictEnv. All variables are substituted if it is empty.
+	EnvAllowlist EnvAllowlist
+	// RepositoryRoot is the directory of the built repository, packaged charts (.tgz) of HelmReleases referencing a
+	// LocalSources GitRepository by a relative path are loaded from it instead of the GitRepository. Defaults to ".".
+	RepositoryRoot string
+	// LocalSources are the GitRepositories (namespace/name) which are the built repository itself.
+	// Defaults to flux-system/flux-system.
+	LocalSources []string
+	// SourcePolicy restricts the chart sources of HelmReleases, it is enforced before the chart is resolved and
+	// violating releases fail with a SourcePolicyError. No policy is enforced if nil.
+	SourcePolicy *SourcePolicy
 }
 
+// DefaultRepositoryTimeout is used if no repository timeout was configured.
+var DefaultRepositoryTimeout = time.Minute
+
 type Helm struct {
 	cache  *cachemgr.Cache
 	Logger logr.Logger
 	opts   HelmOpts
+	// proxy is nil unless an explicit proxy is configured
+	proxy transport.ProxyFunc
+	// transports are shared by all chart repositories and OCI registries, keyed by their TLS configuration
+	transports *transport.Shared
+	// tags caches the tag lists of OCI repositories for the lifetime of the builder
+	tags sync.Map
+	// charts deduplicates concurrent resolutions of identical synthesized HelmCharts
+	charts singleflight.Group
+	// checkouts caches the checkouts of GitRepositories and Buckets for the lifetime of the builder
+	checkouts sync.Map
+	// insecureRegistries remembers the registries which were reported to be accessed insecurely
+	insecureRegistries sync.Map
+	// reusable keeps the charts of HelmReleases (namespace/name) with ReuseCharts
+	reusable sync.Map
+	// scopes tells namespaced from cluster-scoped kinds, it learns the CRDs of the build
+	scopes *postrenderer.Scopes
+}
+
+// DefaultGetters are the getters of HelmRepositories which are always available. HelmOpts.Getters add getters for
+// further URL schemes (for instance gs://), a getter for the scheme of a default getter takes precedence over it.
+var DefaultGetters = helmgetter.Providers{
+	helmgetter.Provider{
+		Schemes: []string{"http", "https"},
+		New:     helmgetter.NewHTTPGetter,
+	},
+	helmgetter.Provider{
+		Schemes: []string{"oci"},
+		New:     helmgetter.NewOCIGetter,
+	},
 }
 
 func NewHelmBuilder(logger logr.Logger, opts HelmOpts) *Helm {
-	if opts.Getters == nil {
-		opts.Getters = helmgetter.Providers{
-			helmgetter.Provider{
-				Schemes: []string{"http", "https"},
-				New:     helmgetter.NewHTTPGetter,
-			},
-			helmgetter.Provider{
-				Schemes: []string{"oci"},
-				New:     helmgetter.NewOCIGetter,
-			},
-		}
+	// The first getter of a scheme is used, the given getters precede the defaults
+	opts.Getters = append(slices.Clone(opts.Getters), DefaultGetters...)
+
+	if opts.ControllerCompat == nil {
+		opts.ControllerCompat = &DefaultControllerCompat
+	}
+
+	if opts.DocumentLimits == nil {
+		opts.DocumentLimits = &DefaultDocumentLimits
+	}
+
+	if opts.RepositoryTimeout == nil {
+		opts.RepositoryTimeout = &DefaultRepositoryTimeout
+	}
+
+	if opts.RetryMax == nil {
+		opts.RetryMax = &DefaultRetryMax
+	}
+
+	if opts.RetryBackoff == nil {
+		opts.RetryBackoff = &DefaultRetryBackoff
+	}
+
+	if opts.RepositoryRoot == "" {
+		opts.RepositoryRoot = "."
+	}
+
+	if opts.LocalSources == nil {
+		opts.LocalSources = []string{"flux-system/flux-system"}
 	}
 
 	if opts.Decoder == nil {
@@ -86,14 +287,56 @@ func NewHelmBuilder(logger logr.Logger, opts HelmOpts) *Helm {
 		opts.Decoder = deserializer
 	}
 
-	return &Helm{
+	h := &Helm{
 		Logger: logger,
 		opts:   opts,
 		cache:  opts.Cache,
+		scopes: postrenderer.NewScopes(logger, opts.APIResources, opts.ClusterScopedKinds...),
+	}
+
+	if opts.Proxy != nil {
+		h.proxy = transport.NewProxyFunc(opts.Proxy)
+	}
+
+	h.transports = transport.NewShared(transport.SharedOpts{
+		Proxy:               h.proxy,
+		MaxIdleConnsPerHost: opts.MaxIdleConnsPerHost,
+		DisableKeepAlives:   opts.DisableKeepAlives,
+	})
+
+	return h
+}
+
+// AddCRDs declares the kinds of the CustomResourceDefinitions of the resource db for the namespace post renderer.
+func (h *Helm) AddCRDs(db ResourceIndex) {
+	for _, r := range db {
+		h.scopes.AddCRDs(r)
 	}
 }
 
+// Close removes the GitRepository and Bucket checkouts of the builder and closes its idle connections.
+func (h *Helm) Close() error {
+	h.transports.CloseIdleConnections()
+
+	var errs []error
+	h.checkouts.Range(func(key, v any) bool {
+		if dir := v.(*sourceCheckout).dir; dir != "" {
+			errs = append(errs, os.RemoveAll(dir))
+		}
+
+		h.checkouts.Delete(key)
+		return true
+	})
+
+	return errors.Join(errs...)
+}
+
 func (h *Helm) Build(ctx context.Context, r *resource.Resource, db map[ref]*resource.Resource) (resmap.ResMap, error) {
+	timings := releaseTimingsFrom(ctx)
+	defer func(start time.Time) {
+		timings.Total = time.Since(start)
+	}(time.Now())
+
 	r.SetGvk(resid.Gvk{
 		Group:   helmv2.GroupVersion.Group,
 		Version: helmv2.GroupVersion.Version,
@@ -105,19 +348,156 @@ func (h *Helm) Build(ctx context.Context, r *resource.Resource, db map[ref]*reso
 		return nil, fmt.Errorf("failed to marshal helmrelease as yaml: %w", err)
 	}
 
-	substituted, err := envsubst.EvalEnv(string(raw))
+	hr, legacy, verification, err := h.decodeRelease(ctx, raw)
 	if err != nil {
-		return nil, fmt.Errorf("failed to substitute envs: %w", err)
+		return nil, err
 	}
 
-	obj, _, err := h.opts.Decoder.Decode([]byte(substituted), nil, nil)
+	if err := h.enforceSourcePolicy(ctx, hr, db); err != nil {
+		return nil, err
+	}
+
+	h.overrideChartVersion(ctx, hr)
+	start := time.Now()
+	reused, fingerprint := h.reusedChart(ctx, hr, db)
+
+	var chartBuild *chart.Build
+	var chartSpec helmv2.HelmChartTemplateSpec
+	if reused != nil {
+		chartBuild, chartSpec = reused.build, reused.spec
+	} else if chartBuild, chartSpec, err = h.resolveChart(ctx, hr, verification, db); err != nil {
+		return nil, h.explainTLSError(err)
+	}
+	timings.Chart += time.Since(start)
+
+	h.recordResolvedChart(ctx, hr, chartBuild)
+
+	var trace *valuesTrace
+	if h.opts.DumpValuesDir != "" || h.opts.ReportValueConflicts {
+		trace = &valuesTrace{}
+	}
+
+	start = time.Now()
+	values, err := h.composeValues(withValuesTrace(ctx, trace), db, *hr)
 	if err != nil {
-		return nil, fmt.Errorf("failed decode resource to helmrelease: %w", err)
+		return nil, err
 	}
+	timings.Values = time.Since(start)
 
-	hr, ok := obj.(*helmv2.HelmRelease)
-	if !ok {
-		return nil, fmt.Errorf("expected type %T", helmv2.HelmRelease{})
+	if h.opts.ReportValueConflicts {
+		h.recordValueConflicts(ctx, trace)
+	}
+
+	if h.opts.DumpValuesDir != "" {
+		if err := h.dumpValues(ctx, *hr, values, trace); err != nil {
+			return nil, err
+		}
+	}
+
+	start = time.Now()
+	var loadedChart *helmchart.Chart
+	if reused != nil {
+		loadedChart = copyChart(reused.chart)
+	} else {
+		if loadedChart, err = h.loadChart(ctx, chartBuild, chartSpec, db); err != nil {
+			return nil, err
+		}
+
+		h.keepChart(hr, fingerprint, chartBuild, chartSpec, loadedChart)
+	}
+	timings.Chart += time.Since(start)
+
+	// The schema check processes the dependencies of the chart, disabled subcharts are removed
+	unprocessedChart := copyChart(loadedChart)
+	if err := h.checkValuesSchema(ctx, *hr, values, loadedChart); err != nil {
+		return nil, err
+	}
+
+	render := h.renderRelease
+	if h.opts.CheckDeterminism {
+		render = h.renderDeterministic
+	}
+
+	start = time.Now()
+	release, err := render(ctx, *hr, legacy.Spec.PostRenderers, values, loadedChart)
+	if err != nil {
+		return nil, err
+	}
+	timings.Render = time.Since(start)
+
+	if err := h.checkRenderWarnings(ctx, *hr, values, loadedChart); err != nil {
+		return nil, err
+	}
+
+	h.checkUnusedValues(ctx, *hr, values, unprocessedChart)
+
+	if err := h.checkEmptyRelease(hr, release); err != nil {
+		return nil, err
+	}
+
+	if h.opts.NotesDir != "" {
+		if err := h.writeNotes(ctx, hr, release); err != nil {
+			return nil, err
+		}
+	}
+
+	start = time.Now()
+	m, err := h.kustomizeRelease(ctx, hr, release)
+	timings.Kustomize = time.Since(start)
+
+	return m, err
+}
+
+// kustomizeRelease writes the manifests of the release to an in-memory filesystem and builds it with kustomize.
+// With KeepTempDirs they are written to a temporary directory instead which is kept after the build.
+func (h *Helm) kustomizeRelease(ctx context.Context, hr *helmv2.HelmRelease, release *release.Release) (resmap.ResMap, error) {
+	fsys, ksDir := filesys.MakeFsInMemory(), "/"
+
+	if h.opts.KeepTempDirs {
+		dir, err := os.MkdirTemp("", "helmrelease")
+		if err != nil {
+			return nil, err
+		}
+
+		h.logger(ctx).V(1).Info("keep helm release render directory", "path", dir)
+		fsys, ksDir = filesys.MakeFsOnDisk(), dir
+	}
+
+	err := fsys.WriteFile(filepath.Join(ksDir, "manifest.yaml"), []byte(release.Manifest))
+	if err != nil {
+		return nil, err
+	}
+
+	if h.includeHooks() {
+		if err := h.writeHooks(fsys, ksDir, hr, release.Hooks); err != nil {
+			return nil, err
+		}
+	}
+
+	start := time.Now()
+	m, err := KustomizeFS(ctx, fsys, ksDir)
+	if err != nil {
+		return nil, err
+	}
+
+	h.logger(ctx).V(1).Info("kustomized helm release", "release", releaseName(*hr), "duration", time.Since(start))
+	return m, nil
+}
+
+// resolveChart builds the chart of the HelmRelease either from spec.chartRef or the chart template.
+// The chart template spec is empty for charts referenced by spec.chartRef.
+func (h *Helm) resolveChart(ctx context.Context, hr *helmv2.HelmRelease, verification *chartVerification, db map[ref]*resource.Resource) (*chart.Build, helmv2.HelmChartTemplateSpec, error) {
+	if hr.HasChartRef() {
+		if hr.Spec.ChartRef.Kind != sourcev1beta2.OCIRepositoryKind {
+			return nil, helmv2.HelmChartTemplateSpec{}, fmt.Errorf("unsupported chartRef kind `%s` of helmrelease `%s/%s`, only %s is supported", hr.Spec.ChartRef.Kind, hr.GetNamespace(), hr.GetName(), sourcev1beta2.OCIRepositoryKind)
+		}
+
+		chartBuild, err := h.buildFromOCIRepository(ctx, hr, db)
+		return chartBuild, helmv2.HelmChartTemplateSpec{}, err
+	}
+
+	if hr.Spec.Chart == nil {
+		return nil, helmv2.HelmChartTemplateSpec{}, fmt.Errorf("helmrelease `%s/%s` has neither a chart nor a chartRef", hr.GetNamespace(), hr.GetName())
 	}
 
 	namespace := hr.Spec.Chart.Spec.SourceRef.Namespace
@@ -135,50 +515,191 @@ func (h *Helm) Build(ctx context.Context, r *resource.Resource, db map[ref]*reso
 	source, ok := db[lookupRef]
 
 	if !ok {
-		return nil, fmt.Errorf("no source `%v` found for helmrelease `%s/%s`", lookupRef, hr.GetNamespace(), hr.GetName())
+		return nil, helmv2.HelmChartTemplateSpec{}, fmt.Errorf("no source `%v` found for helmrelease `%s/%s`", lookupRef, hr.GetNamespace(), hr.GetName())
+	}
+
+	// Charts of a GitRepository or Bucket are never verified
+	if verify := hr.Spec.Chart.Spec.Verify; verify != nil && (lookupRef.Kind == sourcev1.GitRepositoryKind || lookupRef.Kind == sourcev1beta2.BucketKind) {
+		if _, err := h.verifyChart(ctx, verify.Provider, lookupRef.Kind); err != nil {
+			return nil, helmv2.HelmChartTemplateSpec{}, fmt.Errorf("failed to verify chart of helmrelease `%s/%s`: %w", hr.GetNamespace(), hr.GetName(), err)
+		}
+	}
+
+	// Values files of charts from a GitRepository or Bucket are relative to the chart directory or the source root and already merged into the packaged chart,
+	// except for packaged charts of the built repository whose values files are read from the archive
+	switch lookupRef.Kind {
+	case sourcev1.GitRepositoryKind:
+		if h.isLocalPackagedChart(hr, namespace) {
+			chartBuild, err := h.buildFromLocalPackagedChart(ctx, hr)
+			return chartBuild, hr.Spec.Chart.Spec, err
+		}
+
+		chartBuild, err := h.buildFromGitRepository(ctx, hr, source, db)
+		spec := hr.Spec.Chart.Spec
+		spec.ValuesFiles = nil
+		return chartBuild, spec, err
+	case sourcev1beta2.BucketKind:
+		chartBuild, err := h.buildFromBucket(ctx, hr, source, db)
+		spec := hr.Spec.Chart.Spec
+		spec.ValuesFiles = nil
+		return chartBuild, spec, err
 	}
 
 	repository, err := h.getRepository(source)
 	if err != nil {
-		return nil, err
+		return nil, helmv2.HelmChartTemplateSpec{}, err
 	}
 
 	chartBuild := &chart.Build{}
-	err = h.buildChart(ctx, repository, *hr, chartBuild, db)
+	err = h.buildChart(ctx, repository, *hr, verification.Spec.Chart.Spec.Verify.MatchOIDCIdentity, chartBuild, db)
 	if err != nil {
-		return nil, err
+		return nil, helmv2.HelmChartTemplateSpec{}, err
 	}
 
-	values, err := h.composeValues(ctx, db, *hr)
-	if err != nil {
-		return nil, err
+	return chartBuild, hr.Spec.Chart.Spec, nil
+}
+
+// overrideChartVersion replaces the chart version of the HelmRelease by its ChartVersionOverrides entry if any.
+// HelmReleases referencing a chart by chartRef have no chart version and are not overridden.
+func (h *Helm) overrideChartVersion(ctx context.Context, hr *helmv2.HelmRelease) {
+	if hr.Spec.Chart == nil {
+		return
+	}
+
+	version, ok := h.opts.ChartVersionOverrides[hr.GetNamespace()+"/"+hr.GetName()]
+	if !ok {
+		version, ok = h.opts.ChartVersionOverrides[hr.Spec.Chart.Spec.Chart]
+	}
+
+	if !ok {
+		return
 	}
 
-	release, err := h.renderRelease(ctx, *hr, values, chartBuild)
+	h.logger(ctx).Info("override chart version", "chart", hr.Spec.Chart.Spec.Chart, "version", hr.Spec.Chart.Spec.Version, "override", version)
+	hr.Spec.Chart.Spec.Version = version
+}
+
+// substituteEnvs substitutes the environment variables of the HelmRelease unless the substitution is disabled
+// globally by NoEnvsubst or for the HelmRelease by the EnvsubstAnnotation. Only well-formed variables allowed by the
+// EnvAllowlist are substituted and $$ escapes a dollar, see escapeLiterals. The substitution is a single pass, values are inserted as they are and
+// variables within them are never expanded. With StrictEnv such values fail with a NestedVariableError.
+func (h *Helm) substituteEnvs(ctx context.Context, raw []byte) (string, []Substitution, error) {
+	var meta metav1.PartialObjectMetadata
+	if err := yaml.Unmarshal(raw, &meta); err != nil {
+		return "", nil, fmt.Errorf("failed decode resource to helmrelease: %w", err)
+	}
+
+	if h.opts.NoEnvsubst || substitutionDisabled(meta.GetAnnotations()) {
+		h.logger(ctx).V(1).Info("skip env substitution")
+		return string(raw), nil, nil
+	}
+
+	resource := ResourceName(helmv2.HelmReleaseKind, meta.GetNamespace(), meta.GetName())
+	if h.opts.StrictEnv {
+		if err := checkDisallowedVariables(resource, escapeLiterals(string(raw), nil), h.opts.EnvAllowlist); err != nil {
+			return "", nil, fmt.Errorf("failed to substitute envs: %w", err)
+		}
+	}
+
+	document := escapeLiterals(string(raw), h.opts.EnvAllowlist)
+	if h.opts.StrictEnv {
+		if err := checkUnsetVariables(resource, document, func(name string) bool {
+			_, _, ok := h.lookupEnv(name)
+			return ok
+		}); err != nil {
+			return "", nil, fmt.Errorf("failed to substitute envs: %w", err)
+		}
+	}
+
+	var substitutions []Substitution
+	substituted, err := envsubst.Eval(document, func(name string) string {
+		value, source, _ := h.lookupEnv(name)
+		substitutions = append(substitutions, Substitution{Variable: name, Source: source, Value: value})
+		return value
+	})
 	if err != nil {
-		return nil, err
+		return "", nil, fmt.Errorf("failed to substitute envs: %w", err)
+	}
+
+	if h.opts.StrictEnv {
+		if err := checkNestedVariables(resource, substitutions); err != nil {
+			return "", nil, fmt.Errorf("failed to substitute envs: %w", err)
+		}
+	}
+
+	if growth, limit := len(substituted)-len(raw), h.opts.DocumentLimits.MaxSubstitutionGrowth; limit > 0 && growth > limit {
+		return "", nil, fmt.Errorf("%w: environment substitution grew %s by %d bytes which exceeds the maximum of %d bytes", ErrDocumentLimit, resource, growth, limit)
+	}
+
+	return substituted, substitutions, nil
+}
+
+// lookupEnv looks up the variable in the process environment and falls back to the EnvDefaults. The source of the
+// value is either env, env-defaults or unset.
+func (h *Helm) lookupEnv(name string) (string, string, bool) {
+	if value, ok := os.LookupEnv(name); ok {
+		return value, "env", true
+	}
+
+	if value, ok := h.opts.EnvDefaults[name]; ok {
+		return value, "env-defaults", true
+	}
+
+	return "", "unset", false
+}
+
+// decodeRelease substitutes the environment variables and decodes the HelmRelease alongside the
+// legacy post renderers and the chart verification which are not part of the v2 API.
+// The substituted variables are recorded if the context carries a SubstitutionRecorder.
+func (h *Helm) decodeRelease(ctx context.Context, raw []byte) (*helmv2.HelmRelease, *helmv2beta2.HelmRelease, *chartVerification, error) {
+	if err := h.opts.DocumentLimits.Check(raw); err != nil {
+		return nil, nil, nil, err
 	}
 
-	ksDir, err := os.MkdirTemp("", "helmrelease")
+	substituted, substitutions, err := h.substituteEnvs(ctx, raw)
 	if err != nil {
-		return nil, err
+		return nil, nil, nil, err
+	}
+
+	// Variables may expand the document
+	if err := h.opts.DocumentLimits.Check([]byte(substituted)); err != nil {
+		return nil, nil, nil, err
 	}
 
-	err = os.WriteFile(filepath.Join(ksDir, "manifest.yaml"), []byte(release.Manifest), 0644)
+	obj, _, err := h.opts.Decoder.Decode([]byte(substituted), nil, nil)
 	if err != nil {
-		return nil, err
+		return nil, nil, nil, fmt.Errorf("failed decode resource to helmrelease: %w", err)
 	}
 
-	if h.opts.IncludeHelmHooks {
-		for i, hook := range release.Hooks {
-			err := os.WriteFile(filepath.Join(ksDir, fmt.Sprintf("hook_%d.yaml", i)), []byte(hook.Manifest), 0644)
-			if err != nil {
-				return nil, err
-			}
+	hr, ok := obj.(*helmv2.HelmRelease)
+	if !ok {
+		return nil, nil, nil, fmt.Errorf("expected type %T", helmv2.HelmRelease{})
+	}
+
+	recorder := SubstitutionRecorderFrom(ctx)
+	recorded := make(map[string]bool)
+	for _, substitution := range substitutions {
+		if recorded[substitution.Variable] {
+			continue
 		}
+		recorded[substitution.Variable] = true
+
+		substitution.Resource = ResourceName(helmv2.HelmReleaseKind, hr.GetNamespace(), hr.GetName())
+		recorder.Record(substitution, false)
+	}
+
+	// patchesStrategicMerge and patchesJson6902 were removed from the v2 API but are still widely used.
+	legacy := helmv2beta2.HelmRelease{}
+	if err := yaml.Unmarshal([]byte(substituted), &legacy); err != nil {
+		return nil, nil, nil, fmt.Errorf("failed decode resource to helmrelease: %w", err)
+	}
+
+	verification := chartVerification{}
+	if err := yaml.Unmarshal([]byte(substituted), &verification); err != nil {
+		return nil, nil, nil, fmt.Errorf("failed decode resource to helmrelease: %w", err)
 	}
 
-	return Kustomize(ctx, ksDir)
+	return hr, &legacy, &verification, nil
 }
 
 func (h *Helm) getRepository(repository *resource.Resource) (runtime.Object, error) {
@@ -202,8 +723,22 @@ func (h *Helm) getRepository(repository *resource.Resource) (runtime.Object, err
 	return r, nil
 }
 
-func (h *Helm) buildChart(ctx context.Context, repository runtime.Object, release helmv2.HelmRelease, b *chart.Build, db map[ref]*resource.Resource) error {
-	chart := &sourcev1.HelmChart{
+// chartVerification holds the keyless identity matchers of spec.chart.spec.verify.
+// They are supported by the HelmChart API but not (yet) part of the HelmRelease API.
+type chartVerification struct {
+	Spec struct {
+		Chart struct {
+			Spec struct {
+				Verify struct {
+					MatchOIDCIdentity []sourcev1.OIDCIdentityMatch `json:"matchOIDCIdentity,omitempty"`
+				} `json:"verify,omitempty"`
+			} `json:"spec,omitempty"`
+		} `json:"chart,omitempty"`
+	} `json:"spec,omitempty"`
+}
+
+func (h *Helm) buildChart(ctx context.Context, repository runtime.Object, release helmv2.HelmRelease, identities []sourcev1.OIDCIdentityMatch, b *chart.Build, db map[ref]*resource.Resource) error {
+	helmChart := &sourcev1.HelmChart{
 		Spec: sourcev1.HelmChartSpec{
 			Chart:   release.Spec.Chart.Spec.Chart,
 			Version: release.Spec.Chart.Spec.Version,
@@ -213,39 +748,204 @@ func (h *Helm) buildChart(ctx context.Context, repository runtime.Object, releas
 				Name:       release.Spec.Chart.Spec.SourceRef.Name,
 			},
 			ValuesFiles: release.Spec.Chart.Spec.ValuesFiles,
-			//Verify:      release.Spec.Chart.Spec.Verify,
 		},
 	}
 
+	if verify := release.Spec.Chart.Spec.Verify; verify != nil {
+		helmChart.Spec.Verify = &sourcev1.OCIRepositoryVerification{
+			Provider:          verify.Provider,
+			SecretRef:         verify.SecretRef,
+			MatchOIDCIdentity: identities,
+		}
+	}
+
 	switch repository := repository.(type) {
 	case *sourcev1.HelmRepository:
-		return h.buildFromHelmRepository(ctx, chart, repository, b, db)
+		// HelmReleases referencing the same chart share one resolution, failures are propagated to all of them.
+		// The resolution logs to the builder logger and is not bound to the HelmRelease which started it, a
+		// HelmRelease which is canceled stops waiting for it without failing the others.
+		key := chartKey(repository, helmChart)
+		resolution := h.charts.DoChan(key, func() (interface{}, error) {
+			resolveCtx := logr.NewContext(context.Background(), h.Logger.WithValues("chart", helmChart.Spec.Chart, "version", helmChart.Spec.Version, "helmrepository", repository.Namespace+"/"+repository.Name))
+			build := &chart.Build{}
+			err := h.buildFromHelmRepository(resolveCtx, helmChart, repository, build, db)
+			return build, err
+		})
+
+		var result singleflight.Result
+		select {
+		case result = <-resolution:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		if result.Err != nil {
+			if result.Shared {
+				h.logger(ctx).Error(result.Err, "shared chart resolution failed", "chart", helmChart.Spec.Chart, "version", helmChart.Spec.Version)
+			}
+			return result.Err
+		}
 
+		*b = *result.Val.(*chart.Build)
+		return nil
 	}
 
 	return fmt.Errorf("unsupported chart repository `%T`", repository)
 }
 
-func (h *Helm) renderRelease(ctx context.Context, hr helmv2.HelmRelease, values chartutil.Values, b *chart.Build) (*release.Release, error) {
-	chart, err := loader.Load(b.Path)
+// chartKey identifies the resolution of a synthesized HelmChart.
+func chartKey(repository *sourcev1.HelmRepository, chart *sourcev1.HelmChart) string {
+	var verify string
+	if chart.Spec.Verify != nil {
+		verify = chart.Spec.Verify.Provider
+		if chart.Spec.Verify.SecretRef != nil {
+			verify += "=" + chart.Spec.Verify.SecretRef.Name
+		}
+		for _, identity := range chart.Spec.Verify.MatchOIDCIdentity {
+			verify += "," + identity.Issuer + "=" + identity.Subject
+		}
+	}
+
+	return strings.Join([]string{
+		repository.Namespace,
+		repository.Name,
+		repository.Spec.URL,
+		chart.Spec.Chart,
+		chart.Spec.Version,
+		strings.Join(chart.Spec.ValuesFiles, ","),
+		strconv.FormatInt(chart.Generation, 10),
+		verify,
+	}, "/")
+}
+
+// loadChart loads the built chart, merges the values files and adds missing dependencies.
+// Charts packaged without their dependencies get them resolved from the HelmRepositories of the resource set.
+func (h *Helm) loadChart(ctx context.Context, b *chart.Build, spec helmv2.HelmChartTemplateSpec, db map[ref]*resource.Resource) (*helmchart.Chart, error) {
+	loadedChart, err := loader.Load(b.Path)
 	if err != nil {
 		return nil, err
 	}
 
-	ns := hr.GetReleaseNamespace()
-	if ns == "" {
-		ns = "default"
+	// Like in Flux the values files replace the chart default values, values.yaml needs to be listed explicitly
+	valuesFiles := spec.ValuesFiles
+	if spec.IgnoreMissingValuesFiles {
+		valuesFiles = slices.DeleteFunc(slices.Clone(valuesFiles), func(p string) bool {
+			return p != chartutil.ValuesfileName && !slices.ContainsFunc(loadedChart.Files, func(f *helmchart.File) bool {
+				return f.Name == filepath.Clean(p)
+			})
+		})
+	}
+
+	if len(valuesFiles) > 0 {
+		values, err := chart.MergeChartValues(loadedChart, valuesFiles)
+		if err != nil {
+			return nil, fmt.Errorf("failed to merge values files of chart `%s`: %w", b.Name, err)
+		}
+
+		loadedChart.Values = values
+	}
+
+	dm := chart.NewDependencyManager(chart.WithDownloaderCallback(func(url string) (repository.Downloader, error) {
+		repo, err := h.getDependencyRepository(url, db)
+		if err != nil {
+			return nil, err
+		}
+
+		return h.getChartRepository(ctx, repo, db)
+	}))
+
+	// The downloaders are shared through the cache and must not be cleared
+	resolved, err := dm.Build(ctx, chart.RemoteReference{Name: b.Name, Version: b.Version}, loadedChart)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve dependencies of chart `%s`: %w", b.Name, err)
+	}
+
+	if resolved > 0 {
+		h.logger(ctx).V(1).Info("resolved chart dependencies", "chart", b.Name, "dependencies", resolved)
+	}
+
+	return loadedChart, nil
+}
+
+// getDependencyRepository looks up the HelmRepository for a chart dependency by its URL.
+func (h *Helm) getDependencyRepository(url string, db map[ref]*resource.Resource) (*sourcev1.HelmRepository, error) {
+	var candidates []*resource.Resource
+	for k, r := range db {
+		if k.Group == sourcev1.GroupVersion.Group && k.Kind == sourcev1.HelmRepositoryKind {
+			candidates = append(candidates, r)
+		}
+	}
+
+	// Sort for a deterministic result if several HelmRepositories point to the same URL
+	slices.SortFunc(candidates, func(a, b *resource.Resource) int {
+		return strings.Compare(a.GetNamespace()+"/"+a.GetName(), b.GetNamespace()+"/"+b.GetName())
+	})
+
+	for _, candidate := range candidates {
+		obj, err := h.getRepository(candidate.DeepCopy())
+		if err != nil {
+			return nil, err
+		}
+
+		repo, ok := obj.(*sourcev1.HelmRepository)
+		if !ok {
+			continue
+		}
+
+		normalizedURL, err := repository.NormalizeURL(repo.Spec.URL)
+		if err != nil {
+			continue
+		}
+
+		if normalizedURL == url {
+			return repo, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no helmrepository with url `%s` found, dependency repositories need to be declared as HelmRepository", url)
+}
+
+func (h *Helm) renderRelease(ctx context.Context, hr helmv2.HelmRelease, legacyPostRenderers []helmv2beta2.PostRenderer, values chartutil.Values, chart *helmchart.Chart) (*release.Release, error) {
+	start := time.Now()
+	cfg := &helmaction.Configuration{
+		Log: func(format string, v ...interface{}) {
+			h.logger(ctx).V(1).Info(fmt.Sprintf(format, v...))
+		},
+	}
+	rel, err := h.renderInstall(ctx, cfg, hr, legacyPostRenderers, values, chart)
+	if err != nil {
+		return nil, newRenderError(hr, chart, err)
+	}
+
+	if h.opts.Action == ReleaseActionUpgrade {
+		rel, err = h.renderUpgrade(ctx, cfg, rel, hr, legacyPostRenderers, values, chart)
+		if err != nil {
+			return nil, newRenderError(hr, chart, err)
+		}
 	}
 
-	cfg := &helmaction.Configuration{}
+	h.logger(ctx).V(1).Info("rendered helm release", "release", releaseName(hr), "chart", chart.Name(), "version", chart.Metadata.Version, "duration", time.Since(start))
+	return rel, nil
+}
+
+func (h *Helm) renderInstall(ctx context.Context, cfg *helmaction.Configuration, hr helmv2.HelmRelease, legacyPostRenderers []helmv2beta2.PostRenderer, values chartutil.Values, chart *helmchart.Chart) (*release.Release, error) {
+	h.logger(ctx).V(1).Info("render helm release", "release", releaseName(hr), "namespace", releaseNamespace(hr), "storageNamespace", hr.GetStorageNamespace())
+
 	client := helmaction.NewInstall(cfg)
-	client.ReleaseName = hr.GetReleaseName()
-	client.Namespace = ns
+	client.ReleaseName = releaseName(hr)
+	client.Namespace = releaseNamespace(hr)
 	client.DryRun = true
 
 	client.IncludeCRDs = true
-	if hr.Spec.Install != nil && (hr.Spec.Install.SkipCRDs || hr.Spec.Install.CRDs == helmv2.Skip) {
-		client.IncludeCRDs = false
+	if hr.Spec.Install != nil {
+		skipCRDs := hr.Spec.Install.SkipCRDs
+		if h.opts.ControllerCompat.CRDsPolicyOverridesSkipCRDs && hr.Spec.Install.CRDs != "" {
+			skipCRDs = false
+		}
+
+		if skipCRDs || hr.Spec.Install.CRDs == helmv2.Skip {
+			client.IncludeCRDs = false
+		}
 	}
 
 	client.KubeVersion = h.opts.KubeVersion
@@ -253,6 +953,8 @@ func (h *Helm) renderRelease(ctx context.Context, hr helmv2.HelmRelease, values
 	client.Timeout = hr.GetInstall().GetTimeout(hr.GetTimeout()).Duration
 	client.DisableHooks = hr.GetInstall().DisableHooks
 	client.DisableOpenAPIValidation = hr.GetInstall().DisableOpenAPIValidation
+	// The values are validated against the schema of the chart by checkValuesSchema
+	client.SkipSchemaValidation = true
 	client.Devel = true
 	client.EnableDNS = true
 
@@ -260,7 +962,11 @@ func (h *Helm) renderRelease(ctx context.Context, hr helmv2.HelmRelease, values
 	apiVersions = append(apiVersions, h.opts.APIVersions...)
 	client.APIVersions = apiVersions
 
-	client.PostRenderer = postrenderer.BuildPostRenderers(&hr)
+	postRenderer, err := h.postRenderer(ctx, hr, legacyPostRenderers)
+	if err != nil {
+		return nil, err
+	}
+	client.PostRenderer = postRenderer
 
 	// If user opted-in to install (or replace) CRDs, install them first.
 	var legacyCRDsPolicy = helmv2.Create
@@ -268,23 +974,86 @@ func (h *Helm) renderRelease(ctx context.Context, hr helmv2.HelmRelease, values
 		legacyCRDsPolicy = helmv2.Skip
 	}
 
-	_, err = h.validateCRDsPolicy(hr.GetInstall().CRDs, legacyCRDsPolicy)
-	if err != nil {
+	if _, err := h.validateCRDsPolicy(hr.GetInstall().CRDs, legacyCRDsPolicy); err != nil {
 		return nil, err
 	}
 
 	return client.RunWithContext(ctx, chart, values)
 }
 
-func (h *Helm) validateCRDsPolicy(policy helmv2.CRDsPolicy, defaultValue helmv2.CRDsPolicy) (helmv2.CRDsPolicy, error) {
-	switch policy {
-	case "":
-		return defaultValue, nil
-	case helmv2.Skip:
-		break
-	case helmv2.Create:
-		break
-	case helmv2.CreateReplace:
+// maxReleaseNameLength is the maximum length of a Helm release name.
+const maxReleaseNameLength = 53
+
+// releaseName returns the release name helm-controller installs the HelmRelease as. It is spec.releaseName or
+// defaults to <targetNamespace>-<name>, names exceeding the Helm limit are shortened and suffixed by a hash of the name.
+func releaseName(hr helmv2.HelmRelease) string {
+	name := hr.GetReleaseName()
+	if len(name) <= maxReleaseNameLength {
+		return name
+	}
+
+	const shortHashLength = 12
+	sum := fmt.Sprintf("%x", sha256.Sum256([]byte(name)))
+	return name[:maxReleaseNameLength-(shortHashLength+1)] + "-" + sum[:shortHashLength]
+}
+
+// releaseNamespace returns the namespace the resources of the release are rendered into, spec.targetNamespace or the
+// namespace of the HelmRelease. spec.storageNamespace only affects where helm-controller stores the release and is not
+// part of the output.
+func releaseNamespace(hr helmv2.HelmRelease) string {
+	if ns := hr.GetReleaseNamespace(); ns != "" {
+		return ns
+	}
+
+	return "default"
+}
+
+func (h *Helm) postRenderer(ctx context.Context, hr helmv2.HelmRelease, legacyPostRenderers []helmv2beta2.PostRenderer) (postrender.PostRenderer, error) {
+	skipped, err := postrenderer.Skipped(&hr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid post renderers of helmrelease `%s/%s`: %w", hr.GetNamespace(), hr.GetName(), err)
+	}
+
+	if len(skipped) > 0 {
+		h.logger(ctx).Info("skip built-in post renderers", "postRenderers", skipped)
+		if s := skippedPostRenderersFrom(ctx); s != nil {
+			*s = skipped
+		}
+	}
+
+	return postrenderer.BuildPostRenderers(&hr, postrenderer.Options{
+		Scopes:              h.scopes,
+		DisableFlattenLists: h.opts.KeepLists,
+		Labels:              h.opts.CommonLabels,
+		Annotations:         h.opts.CommonAnnotations,
+		LegacyPostRenderers: legacyPostRenderers,
+		Validate:            h.opts.DocumentLimits.Check,
+		Exec:                h.opts.ExecPostRenderers,
+	})
+}
+
+type skippedPostRenderersKey struct{}
+
+// WithSkippedPostRenderers returns a context which records the built-in post renderers the HelmRelease built with it
+// skips by its annotation into s.
+func WithSkippedPostRenderers(ctx context.Context, s *[]string) context.Context {
+	return context.WithValue(ctx, skippedPostRenderersKey{}, s)
+}
+
+func skippedPostRenderersFrom(ctx context.Context) *[]string {
+	s, _ := ctx.Value(skippedPostRenderersKey{}).(*[]string)
+	return s
+}
+
+func (h *Helm) validateCRDsPolicy(policy helmv2.CRDsPolicy, defaultValue helmv2.CRDsPolicy) (helmv2.CRDsPolicy, error) {
+	switch policy {
+	case "":
+		return defaultValue, nil
+	case helmv2.Skip:
+		break
+	case helmv2.Create:
+		break
+	case helmv2.CreateReplace:
 		break
 	default:
 		return policy, fmt.Errorf("invalid CRD policy '%s' defined in field CRDsPolicy, valid values are '%s', '%s' or '%s'",
@@ -294,15 +1063,88 @@ func (h *Helm) validateCRDsPolicy(policy helmv2.CRDsPolicy, defaultValue helmv2.
 	return policy, nil
 }
 
+// targetPathPattern is the validation pattern of the valuesFrom targetPath of the HelmRelease CRD, the length of
+// list indexes is not limited so that strvals reports indexes out of bounds.
+var targetPathPattern = regexp.MustCompile(`^([a-zA-Z0-9_\-.\\/]|\[[0-9]+\])+$`)
+
+// Type markers of a valuesFrom targetPath, see HelmOpts.TargetPathTypes.
+const (
+	targetPathTypeString = "str"
+	targetPathTypeInt    = "int"
+	targetPathTypeBool   = "bool"
+)
+
+// setTargetPath sets the value at the targetPath of a valuesFrom reference like helm --set does. The path supports
+// list indexes (hosts[0]) and escaped dots (metrics\.enabled). The data of a ConfigMap or Secret is a string, the value
+// is set as is including quotes and whitespace unless typed is set. Commas and braces are part of the value instead of
+// separating values or starting a list. A value of null in any case is null even if typed is not set, like in
+// helm-controller it deletes the key from the values of lower precedence, for instance a default of the chart.
+// If typed is set the value is typed like helm-controller does: true and false in any case become booleans, null
+// becomes nil and integers without a leading zero become int64, everything else including floats is a string.
+// The targetPath may end with a type marker (!str, !int or !bool) which overrides the typing of the reference, !int and
+// !bool fail for values which are no integer or boolean.
+func setTargetPath(values chartutil.Values, targetPath, value string, typed bool) error {
+	marker := ""
+	if typed {
+		if i := strings.LastIndex(targetPath, "!"); i >= 0 {
+			targetPath, marker = targetPath[:i], targetPath[i+1:]
+		}
+	}
+
+	if !targetPathPattern.MatchString(targetPath) {
+		return fmt.Errorf("invalid target path, it must match %s", targetPathPattern)
+	}
+
+	switch marker {
+	case "":
+		if typed || strings.EqualFold(value, "null") {
+			return strvals.ParseInto(targetPath+"="+escapeStrvalsValue(value), values)
+		}
+	case targetPathTypeString:
+	case targetPathTypeInt:
+		i, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			return fmt.Errorf("value of type marker !%s is no integer: %w", marker, err)
+		}
+
+		return strvals.ParseInto(targetPath+"="+strconv.FormatInt(i, 10), values)
+	case targetPathTypeBool:
+		b, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("value of type marker !%s is no boolean: %w", marker, err)
+		}
+
+		return strvals.ParseInto(targetPath+"="+strconv.FormatBool(b), values)
+	default:
+		return fmt.Errorf("unknown type marker `!%s`, supported markers are !%s, !%s, !%s", marker, targetPathTypeString, targetPathTypeInt, targetPathTypeBool)
+	}
+
+	return strvals.ParseIntoString(targetPath+"="+escapeStrvalsValue(value), values)
+}
+
+// escapeStrvalsValue escapes the characters strvals treats as syntax within a value, a backslash escapes the next
+// character, a comma separates values and a leading brace starts a list.
+func escapeStrvalsValue(value string) string {
+	var b strings.Builder
+	for i, r := range value {
+		if r == '\\' || r == ',' || (i == 0 && r == '{') {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
 // composeValues attempts to resolve all v2beta1.ValuesReference resources
 // and merges them as defined. Referenced resources are only retrieved once
 // to ensure a single version is taken into account during the merge.
-func (h *Helm) composeValues(_ context.Context, db map[ref]*resource.Resource, hr helmv2.HelmRelease) (chartutil.Values, error) {
+func (h *Helm) composeValues(ctx context.Context, db map[ref]*resource.Resource, hr helmv2.HelmRelease) (chartutil.Values, error) {
 	result := chartutil.Values{}
+	trace := valuesTraceFrom(ctx)
 
 	for _, v := range hr.Spec.ValuesFrom {
 		namespacedName := types.NamespacedName{Namespace: hr.Namespace, Name: v.Name}
-		var valuesData []byte
 
 		lookupRef := ref{
 			GroupKind: schema.GroupKind{
@@ -312,8 +1154,12 @@ func (h *Helm) composeValues(_ context.Context, db map[ref]*resource.Resource, h
 			Name:      v.Name,
 			Namespace: hr.Namespace,
 		}
-		res, ok := db[lookupRef]
-		if !ok {
+		res, err := lookupValues(db, lookupRef)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up values for helmrelease `%s/%s`: %w", hr.GetNamespace(), hr.GetName(), err)
+		}
+
+		if res == nil {
 			if !v.Optional {
 				return nil, fmt.Errorf("could not find values `%s.%s/%v` for helmrelease `%s/%s`", v.Kind, hr.GetNamespace(), v.Name, hr.GetNamespace(), hr.GetName())
 			} else {
@@ -337,55 +1183,98 @@ func (h *Helm) composeValues(_ context.Context, db map[ref]*resource.Resource, h
 			return nil, fmt.Errorf("failed decode values as `v1.%s`: %w", v.Kind, err)
 		}
 
-		switch obj := obj.(type) {
-		case *corev1.ConfigMap:
-			if data, ok := obj.Data[v.GetValuesKey()]; !ok {
-				return nil, fmt.Errorf("missing key '%s' in %s '%s'", v.GetValuesKey(), v.Kind, namespacedName)
-			} else {
-				valuesData = []byte(data)
-			}
-		case *corev1.Secret:
-			if data, ok := obj.Data[v.GetValuesKey()]; ok {
-				valuesData = data
-			} else if data, ok := obj.StringData[v.GetValuesKey()]; ok {
-				valuesData = []byte(data)
-			} else {
+		docs, ok := valuesDocuments(obj, v.GetValuesKey())
+		if !ok {
+			return nil, fmt.Errorf("unsupported ValuesReference kind '%s'", v.Kind)
+		}
+
+		// A missing key of an optional reference is skipped like a missing object, as helm-controller does
+		if len(docs) == 0 {
+			if !v.Optional {
 				return nil, fmt.Errorf("missing key '%s' in %s '%s'", v.GetValuesKey(), v.Kind, namespacedName)
 			}
-		default:
-			return nil, fmt.Errorf("unsupported ValuesReference kind '%s'", v.Kind)
+
+			h.logger(ctx).Info("skip optional values reference, the key is missing", "kind", v.Kind, "name", namespacedName.String(), "key", v.GetValuesKey())
+			continue
 		}
 
-		switch v.TargetPath {
-		case "":
-			values, err := chartutil.ReadValues(valuesData)
-			if err != nil {
-				return nil, fmt.Errorf("unable to read values from key '%s' in %s '%s': %w", v.GetValuesKey(), v.Kind, namespacedName, err)
+		if v.GetValuesKey() == AllValuesKeys && v.TargetPath != "" {
+			return nil, fmt.Errorf("valuesKey '%s' of %s '%s' can't be merged into target path '%s'", AllValuesKeys, v.Kind, namespacedName, v.TargetPath)
+		}
+
+		// The documents of a reference and the references themselves are merged in order, later values win
+		for _, doc := range docs {
+			valuesData := doc.data
+			if doc.binary {
+				valuesData, err = decompressValues(doc.data, h.opts.DocumentLimits.MaxSize)
+				if err != nil {
+					return nil, fmt.Errorf("invalid values from key '%s' in %s '%s': %w", doc.key, v.Kind, namespacedName, err)
+				}
 			}
-			result = transform.MergeMaps(result, values)
-		default:
-			// TODO(hidde): this is a bit of hack, as it mimics the way the option string is passed
-			// 	to Helm from a CLI perspective. Given the parser is however not publicly accessible
-			// 	while it contains all logic around parsing the target path, it is a fair trade-off.
-			stringValuesData := string(valuesData)
-			const singleQuote = "'"
-			const doubleQuote = "\""
-			var err error
-			if (strings.HasPrefix(stringValuesData, singleQuote) && strings.HasSuffix(stringValuesData, singleQuote)) || (strings.HasPrefix(stringValuesData, doubleQuote) && strings.HasSuffix(stringValuesData, doubleQuote)) {
-				stringValuesData = strings.Trim(stringValuesData, singleQuote+doubleQuote)
-				singleValue := v.TargetPath + "=" + stringValuesData
-				err = strvals.ParseIntoString(singleValue, result)
-			} else {
-				singleValue := v.TargetPath + "=" + stringValuesData
-				err = strvals.ParseInto(singleValue, result)
+
+			if err := h.opts.DocumentLimits.Check(valuesData); err != nil {
+				return nil, fmt.Errorf("invalid values from key '%s' in %s '%s': %w", doc.key, v.Kind, namespacedName, err)
 			}
-			if err != nil {
-				return nil, fmt.Errorf("unable to merge value from key '%s' in %s '%s' into target path '%s': %w", v.GetValuesKey(), v.Kind, namespacedName, v.TargetPath, err)
+
+			switch v.TargetPath {
+			case "":
+				values, err := chartutil.ReadValues(valuesData)
+				if err != nil {
+					return nil, newInvalidValuesError(v.Kind, namespacedName.String(), doc.key, valuesData, err)
+				}
+				result = transform.MergeMaps(result, values)
+				trace.record(fmt.Sprintf("%s[%s]", ResourceName(v.Kind, hr.Namespace, v.Name), doc.key), values, v.Kind == "Secret")
+			default:
+				if err := setTargetPath(result, v.TargetPath, string(valuesData), h.opts.TargetPathTypes); err != nil {
+					return nil, fmt.Errorf("unable to merge value from key '%s' in %s '%s' into target path '%s': %w", doc.key, v.Kind, namespacedName, v.TargetPath, err)
+				}
+
+				if trace != nil {
+					values := chartutil.Values{}
+					_ = setTargetPath(values, v.TargetPath, string(valuesData), h.opts.TargetPathTypes)
+					trace.record(fmt.Sprintf("%s[%s] -> %s", ResourceName(v.Kind, hr.Namespace, v.Name), doc.key, v.TargetPath), values, v.Kind == "Secret")
+				}
 			}
 		}
 	}
 
-	return transform.MergeMaps(result, hr.GetValues()), nil
+	result = transform.MergeMaps(result, hr.GetValues())
+	trace.record("spec.values", hr.GetValues(), false)
+
+	for _, overlay := range h.opts.ValuesOverlays {
+		if !overlay.Matches(hr.GetNamespace(), hr.GetName(), hr.GetLabels()) {
+			continue
+		}
+
+		h.logger(ctx).Info("apply values overlay", "selector", overlay.Selector, "path", overlay.Path)
+		result = transform.MergeMaps(result, overlay.Values)
+		trace.record("overlay "+overlay.Path, overlay.Values, false)
+	}
+
+	copied := false
+	for _, override := range h.opts.ValuesOverrides {
+		if !override.Matches(hr.GetNamespace(), hr.GetName()) {
+			continue
+		}
+
+		if !copied {
+			result = copyValues(result).(map[string]interface{})
+			copied = true
+		}
+
+		h.logger(ctx).Info("apply values override", "override", override.String())
+		if err := override.apply(result); err != nil {
+			return nil, fmt.Errorf("failed to apply values override `%s`: %w", override, err)
+		}
+
+		if trace != nil {
+			values := map[string]interface{}{}
+			_ = override.apply(values)
+			trace.record(override.String(), values, false)
+		}
+	}
+
+	return result, nil
 }
 
 func (h *Helm) getHelmRepositorySecret(ctx context.Context, repository *sourcev1.HelmRepository, db map[ref]*resource.Resource) (*corev1.Secret, error) {
@@ -393,30 +1282,62 @@ func (h *Helm) getHelmRepositorySecret(ctx context.Context, repository *sourcev1
 		return nil, nil
 	}
 
+	secret, lookupRef, err := h.getSecret(repository.Spec.SecretRef.Name, repository.ObjectMeta.Namespace, db)
+	if err != nil {
+		return nil, err
+	}
+
+	if secret == nil {
+		return nil, fmt.Errorf("no repository secret `%v` found for helmrepository %s/%s", lookupRef, repository.Namespace, repository.Name)
+	}
+
+	return secret, nil
+}
+
+func (h *Helm) getHelmRepositoryCertSecret(ctx context.Context, repository *sourcev1.HelmRepository, db map[ref]*resource.Resource) (*corev1.Secret, error) {
+	if repository.Spec.CertSecretRef == nil {
+		return nil, nil
+	}
+
+	secret, lookupRef, err := h.getSecret(repository.Spec.CertSecretRef.Name, repository.ObjectMeta.Namespace, db)
+	if err != nil {
+		return nil, err
+	}
+
+	if secret == nil {
+		return nil, fmt.Errorf("no certSecretRef secret `%v` found for helmrepository %s/%s", lookupRef, repository.Namespace, repository.Name)
+	}
+
+	return secret, nil
+}
+
+// getSecret looks up a secret in the resource db, it returns nil if the secret does not exist.
+func (h *Helm) getSecret(name, namespace string, db map[ref]*resource.Resource) (*corev1.Secret, ref, error) {
 	lookupRef := ref{
 		GroupKind: schema.GroupKind{
 			Group: "",
 			Kind:  "Secret",
 		},
-		Name:      repository.Spec.SecretRef.Name,
-		Namespace: repository.ObjectMeta.Namespace,
+		Name:      name,
+		Namespace: namespace,
 	}
 
-	if secret, ok := db[lookupRef]; ok {
-		raw, err := secret.AsYAML()
-		if err != nil {
-			return nil, err
-		}
+	secret, ok := db[lookupRef]
+	if !ok {
+		return nil, lookupRef, nil
+	}
 
-		obj, _, err := h.opts.Decoder.Decode(raw, nil, nil)
-		if err != nil {
-			return nil, err
-		}
+	raw, err := secret.AsYAML()
+	if err != nil {
+		return nil, lookupRef, err
+	}
 
-		return obj.(*corev1.Secret), nil
+	obj, _, err := h.opts.Decoder.Decode(raw, nil, nil)
+	if err != nil {
+		return nil, lookupRef, err
 	}
 
-	return nil, fmt.Errorf("no repository secret `%v` found for helmrepository %s/%s", lookupRef, repository.Namespace, repository.Name)
+	return obj.(*corev1.Secret), lookupRef, nil
 }
 
 func (h *Helm) clientOptionsFromSecret(secret *corev1.Secret, normalizedURL string) ([]helmgetter.Option, *tls.Config, error) {
@@ -433,198 +1354,379 @@ func (h *Helm) clientOptionsFromSecret(secret *corev1.Secret, normalizedURL stri
 	return opts, tlsConfig, nil
 }
 
-// buildFromHelmRepository attempts to pull and/or package a Helm chart with
-// the specified data from the v1beta2.HelmRepository and v1beta2.HelmChart
-// objects.
-// In case of a failure it records v1beta2.FetchFailedCondition on the chart
-// object, and returns early.
-func (h *Helm) buildFromHelmRepository(ctx context.Context, obj *sourcev1.HelmChart,
-	repo *sourcev1.HelmRepository, b *chart.Build, db map[ref]*resource.Resource) error {
+// getChartRepository returns the chart repository for the given HelmRepository.
+// Repositories are cached by their normalized URL and credentials and shared between all charts.
+func (h *Helm) getChartRepository(ctx context.Context, repo *sourcev1.HelmRepository, db map[ref]*resource.Resource) (chartRepo repository.Downloader, err error) {
 	var (
 		tlsConfig     *tls.Config
 		authenticator authn.Authenticator
 		keychain      authn.Keychain
 	)
 
+	normalizedURL, err := repository.NormalizeURL(repo.Spec.URL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to normalize url: %w", err)
+	}
+
+	releaseAssetProvider, err := h.releaseAssetProvider(repo)
+	if err != nil {
+		return nil, err
+	}
+
 	// Used to login with the repository declared provider
-	ctxTimeout, cancel := context.WithTimeout(ctx, 1*time.Minute)
+	timeout := h.repositoryTimeout(repo, normalizedURL, repo.Spec.URL)
+	ctxTimeout, cancel := withTimeout(ctx, timeout)
 	defer cancel()
 
-	normalizedURL, err := repository.NormalizeURL(repo.Spec.URL)
+	repoKey, err := h.repositoryKey(ctx, repo, normalizedURL, db)
 	if err != nil {
-		return fmt.Errorf("failed to normalize url: %w", err)
+		return nil, err
+	}
+
+	chartRepo, err = h.cache.RepoGetOrLock(repoKey)
+	if err != nil {
+		return nil, err
+	}
+	if chartRepo != nil {
+		return chartRepo, nil
 	}
 
-	chartRepo := h.cache.RepoGetOrLock(normalizedURL)
-	if chartRepo == nil {
+	// Releases waiting for the repository would block forever if it fails to be constructed
+	defer func() {
+		if err != nil {
+			h.cache.RepoFailUnlock(repoKey, err)
+		}
+	}()
+
+	h.logger(ctx).V(1).Info("using chart repo", "chartrepo", normalizedURL)
 
-		h.Logger.V(1).Info("using chart repo", "chartrepo", normalizedURL)
+	// Construct the Getter options from the HelmRepository data
+	clientOpts := []helmgetter.Option{
+		helmgetter.WithURL(normalizedURL),
+		helmgetter.WithTimeout(timeout),
+		helmgetter.WithPassCredentialsAll(repo.Spec.PassCredentials),
+	}
 
-		// Construct the Getter options from the HelmRepository data
-		clientOpts := []helmgetter.Option{
-			helmgetter.WithURL(normalizedURL),
-			helmgetter.WithTimeout(1 * time.Minute),
-			helmgetter.WithPassCredentialsAll(repo.Spec.PassCredentials),
+	if secret, err := h.getHelmRepositorySecret(ctx, repo, db); secret != nil || err != nil {
+		if err != nil {
+			return nil, err
 		}
 
-		if secret, err := h.getHelmRepositorySecret(ctx, repo, db); secret != nil || err != nil {
-			if err != nil {
-				return err
-			}
+		// Build client options from secret
+		opts, tlsCfg, err := h.clientOptionsFromSecret(secret, normalizedURL)
+		if err != nil {
+			return nil, err
+		}
+		clientOpts = append(clientOpts, opts...)
+		tlsConfig = tlsCfg
 
-			// Build client options from secret
-			opts, tlsCfg, err := h.clientOptionsFromSecret(secret, normalizedURL)
-			if err != nil {
-				return err
-			}
-			clientOpts = append(clientOpts, opts...)
-			tlsConfig = tlsCfg
+		// Build registryClient options from secret
+		keychain, err = registry.LoginOptionFromSecret(normalizedURL, *secret)
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure Helm client with secret data: %w", err)
+		}
+	} else if repo.Spec.Provider != sourcev1beta2.GenericOCIProvider && repo.Spec.Type == sourcev1beta2.HelmRepositoryTypeOCI {
+		auth, authErr := oidcAuth(ctxTimeout, repo.Spec.URL, repo.Spec.Provider)
+		if authErr != nil && !errors.Is(authErr, oci.ErrUnconfiguredProvider) {
+			return nil, fmt.Errorf("failed to get credential from %s: %w", repo.Spec.Provider, authErr)
+		}
+		if auth != nil {
+			authenticator = auth
+		}
+	}
 
-			// Build registryClient options from secret
-			keychain, err = registry.LoginOptionFromSecret(normalizedURL, *secret)
-			if err != nil {
-				return fmt.Errorf("failed to configure Helm client with secret data: %w", err)
-			}
-		} else if repo.Spec.Provider != sourcev1beta2.GenericOCIProvider && repo.Spec.Type == sourcev1beta2.HelmRepositoryTypeOCI {
-			auth, authErr := oidcAuth(ctxTimeout, repo.Spec.URL, repo.Spec.Provider)
-			if authErr != nil && !errors.Is(authErr, oci.ErrUnconfiguredProvider) {
-				return fmt.Errorf("failed to get credential from %s: %w", repo.Spec.Provider, authErr)
-			}
-			if auth != nil {
-				authenticator = auth
-			}
+	// TLS material from certSecretRef takes precedence over the one from secretRef
+	if certSecret, err := h.getHelmRepositoryCertSecret(ctx, repo, db); certSecret != nil || err != nil {
+		if err != nil {
+			return nil, err
 		}
 
-		loginOpt, err := makeLoginOption(authenticator, keychain, normalizedURL)
+		certTLSConfig, err := getter.TLSClientConfigFromCertSecret(*certSecret, normalizedURL)
 		if err != nil {
-			return err
+			return nil, fmt.Errorf("failed to create TLS client config for helmrepository %s/%s: %w", repo.Namespace, repo.Name, err)
 		}
 
-		// Initialize the chart repository
-		switch repo.Spec.Type {
-		case sourcev1beta2.HelmRepositoryTypeOCI:
-			if !helmreg.IsOCI(normalizedURL) {
-				return fmt.Errorf("invalid OCI registry URL: %s", normalizedURL)
-			}
+		tlsConfig = getter.MergeTLSClientConfig(tlsConfig, certTLSConfig)
+	}
 
-			// with this function call, we create a temporary file to store the credentials if needed.
-			// this is needed because otherwise the credentials are stored in ~/.docker/config.json.
-			// TODO@souleb: remove this once the registry move to Oras v2
-			// or rework to enable reusing credentials to avoid the unneccessary handshake operations
-			registryClient, _, err := registry.ClientGenerator(loginOpt != nil)
-			if err != nil {
-				return fmt.Errorf("failed to construct Helm client: %w", err)
-			}
+	tlsConfig, err = h.tlsConfig(repo, normalizedURL, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
 
-			/*if credentialsFile != "" {
-				defer func() {
-					if err := os.Remove(credentialsFile); err != nil {
-						//r.eventLogf(ctx, obj, corev1.EventTypeWarning, meta.FailedReason,
-						//		"failed to delete temporary credentials file: %s", err)
-					}
-				}()
-			}*/
+	if authenticator == nil && keychain == nil {
+		if keychain = h.defaultKeychain(repo); keychain != nil {
+			h.logger(ctx).V(1).Info("using default keychain for oci registry", "chartrepo", normalizedURL)
+		}
+	}
 
-			var verifiers []soci.Verifier
-			/*if obj.Spec.Verify != nil {
-				provider := obj.Spec.Verify.Provider
-				verifiers, err = h.makeVerifiers(ctx, obj, authenticator, keychain)
-				if err != nil {
-					if obj.Spec.Verify.SecretRef == nil {
-						provider = fmt.Sprintf("%s keyless", provider)
-					}
-					return fmt.Errorf("failed to verify the signature using provider '%s': %w", provider, err)
+	loginOpt, err := makeLoginOption(authenticator, keychain, normalizedURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// Initialize the chart repository
+	switch {
+	case releaseAssetProvider != "":
+		assetRepo, err := h.releaseAssetRepository(ctx, repo, releaseAssetProvider, tlsConfig, db)
+		if err != nil {
+			return nil, err
+		}
+		chartRepo = assetRepo
+	case repo.Spec.Type == sourcev1beta2.HelmRepositoryTypeOCI:
+		if !helmreg.IsOCI(normalizedURL) {
+			return nil, fmt.Errorf("invalid OCI registry URL: %s", normalizedURL)
+		}
+
+		// with this function call, we create a temporary file to store the credentials if needed.
+		// this is needed because otherwise the credentials are stored in ~/.docker/config.json.
+		// TODO@souleb: remove this once the registry move to Oras v2
+		// or rework to enable reusing credentials to avoid the unneccessary handshake operations
+		insecure := h.insecureRegistry(repo, normalizedURL)
+		if insecure {
+			h.warnInsecureRegistry(ctx, repositoryHost(normalizedURL), "plain http")
+		}
+		tlsConfig = h.registryTLSConfig(ctx, repositoryHost(normalizedURL), tlsConfig)
+		registryClient, _, err := registry.ClientGenerator(tlsConfig, h.proxy, h.transports, loginOpt != nil, insecure)
+		if err != nil {
+			return nil, fmt.Errorf("failed to construct Helm client: %w", err)
+		}
+
+		/*if credentialsFile != "" {
+			defer func() {
+				if err := os.Remove(credentialsFile); err != nil {
+					//r.eventLogf(ctx, obj, corev1.EventTypeWarning, meta.FailedReason,
+					//		"failed to delete temporary credentials file: %s", err)
 				}
-			}*/
-
-			// Tell the chart repository to use the OCI client with the configured getter
-			clientOpts = append(clientOpts, helmgetter.WithRegistryClient(registryClient))
-			ociChartRepo, err := repository.NewOCIChartRepository(normalizedURL,
-				repository.WithOCIGetter(h.opts.Getters),
-				repository.WithOCIGetterOptions(clientOpts),
-				repository.WithOCIRegistryClient(registryClient),
-				repository.WithVerifiers(verifiers))
+			}()
+		}*/
+
+		// Verifiers depend on the chart while the repository is shared, store the registry auth for later use
+		var remoteOpts []remote.Option
+		if authenticator != nil {
+			remoteOpts = append(remoteOpts, remote.WithAuth(authenticator))
+		} else if keychain != nil {
+			remoteOpts = append(remoteOpts, remote.WithAuthFromKeychain(keychain))
+		}
+		remoteOpts = append(remoteOpts, remote.WithTransport(h.transports.Get(tlsConfig)))
+
+		// Tell the chart repository to use the OCI client with the configured getter
+		clientOpts = append(clientOpts, helmgetter.WithRegistryClient(registryClient), helmgetter.WithPlainHTTP(insecure))
+		ociChartRepo, err := repository.NewOCIChartRepository(normalizedURL,
+			repository.WithOCIGetter(h.opts.Getters),
+			repository.WithOCIGetterOptions(clientOpts),
+			repository.WithOCIRegistryClient(registryClient),
+			repository.WithOCITLSConfig(tlsConfig),
+			repository.WithOCIProxy(h.proxy),
+			repository.WithOCITransports(h.transports),
+			repository.WithOCIRemoteOptions(remoteOpts...))
+		if err != nil {
+			return nil, err
+		}
+		chartRepo = ociChartRepo
+
+		// If login options are configured, use them to login to the registry
+		// The OCIGetter will later retrieve the stored credentials to pull the chart
+		if loginOpt != nil {
+			err = h.retry(ctxTimeout, "login", func() error {
+				return ociChartRepo.Login(loginOpt, helmreg.LoginOptInsecure(insecure))
+			})
 			if err != nil {
-				return err
+				return nil, fmt.Errorf("failed to login to OCI registry: %w", err)
 			}
-			chartRepo = ociChartRepo
-
-			// If login options are configured, use them to login to the registry
-			// The OCIGetter will later retrieve the stored credentials to pull the chart
-			if loginOpt != nil {
-				err = ociChartRepo.Login(loginOpt)
-				if err != nil {
-					return fmt.Errorf("failed to login to OCI registry: %w", err)
-				}
+		}
+	default:
+		httpChartRepo, err := repository.NewChartRepository(normalizedURL /*r.Storage.LocalPath(*repo.GetArtifact())*/, "/tmp", h.opts.Getters, tlsConfig, clientOpts...)
+		if err != nil {
+			return nil, err
+		}
+		httpChartRepo.Proxy = h.proxy
+		httpChartRepo.Transports = h.transports
+		httpChartRepo.Logger = h.logger(ctx)
+
+		// NB: this needs to be deferred first, as otherwise the Index will disappear
+		// before we had a chance to cache it.
+		/*defer func() {
+			if err := httpChartRepo.Clear(); err != nil {
+				ctrl.LoggerFrom(ctx).Error(err, "failed to clear Helm repository index")
 			}
-		default:
-			httpChartRepo, err := repository.NewChartRepository(normalizedURL /*r.Storage.LocalPath(*repo.GetArtifact())*/, "/tmp", h.opts.Getters, tlsConfig, clientOpts...)
-			if err != nil {
-				return err
+		}()*/
+
+		// Attempt to load the index from the cache.
+		/*if r.Cache != nil {
+			if index, ok := r.Cache.Get(repo.GetArtifact().Path); ok {
+				r.IncCacheEvents(cache.CacheEventTypeHit, repo.Name, repo.Namespace)
+				r.Cache.SetExpiration(repo.GetArtifact().Path, r.TTL)
+				httpChartRepo.Index = index.(*helmrepo.IndexFile)
+			} else {
+				r.IncCacheEvents(cache.CacheEventTypeMiss, repo.Name, repo.Namespace)
+				defer func() {
+					// If we succeed in loading the index, cache it.
+					if httpChartRepo.Index != nil {
+						if err = r.Cache.Set(repo.GetArtifact().Path, httpChartRepo.Index, r.TTL); err != nil {
+							r.eventLogf(ctx, obj, eventv1.EventTypeTrace, sourcev1.CacheOperationFailedReason, "failed to cache index: %s", err)
+						}
+					}
+				}()
 			}
+		}*/
+		chartRepo = httpChartRepo
+	}
 
-			// NB: this needs to be deferred first, as otherwise the Index will disappear
-			// before we had a chance to cache it.
-			/*defer func() {
-				if err := httpChartRepo.Clear(); err != nil {
-					ctrl.LoggerFrom(ctx).Error(err, "failed to clear Helm repository index")
-				}
-			}()*/
-
-			// Attempt to load the index from the cache.
-			/*if r.Cache != nil {
-				if index, ok := r.Cache.Get(repo.GetArtifact().Path); ok {
-					r.IncCacheEvents(cache.CacheEventTypeHit, repo.Name, repo.Namespace)
-					r.Cache.SetExpiration(repo.GetArtifact().Path, r.TTL)
-					httpChartRepo.Index = index.(*helmrepo.IndexFile)
-				} else {
-					r.IncCacheEvents(cache.CacheEventTypeMiss, repo.Name, repo.Namespace)
-					defer func() {
-						// If we succeed in loading the index, cache it.
-						if httpChartRepo.Index != nil {
-							if err = r.Cache.Set(repo.GetArtifact().Path, httpChartRepo.Index, r.TTL); err != nil {
-								r.eventLogf(ctx, obj, eventv1.EventTypeTrace, sourcev1.CacheOperationFailedReason, "failed to cache index: %s", err)
-							}
-						}
-					}()
-				}
-			}*/
-			chartRepo = httpChartRepo
+	h.cache.RepoSetUnlock(repoKey, chartRepo)
+	return chartRepo, nil
+}
+
+// buildFromHelmRepository attempts to pull and/or package a Helm chart with
+// the specified data from the v1beta2.HelmRepository and v1beta2.HelmChart
+// objects.
+// If the pull fails the mirrors of the repository (see HelmOpts.RepositoryMirrors) are tried in order, charts which
+// fail the verification are never pulled from a mirror.
+func (h *Helm) buildFromHelmRepository(ctx context.Context, obj *sourcev1.HelmChart,
+	repo *sourcev1.HelmRepository, b *chart.Build, db map[ref]*resource.Resource) error {
+	err := h.pullFromHelmRepository(ctx, obj, repo, b, db)
+	if err == nil || errors.Is(err, chart.ErrChartVerification) || ctx.Err() != nil {
+		return err
+	}
+
+	mirrors := h.repositoryMirrors(repo)
+	if len(mirrors) == 0 {
+		return err
+	}
+
+	errs := []error{err}
+	for _, mirrorURL := range mirrors {
+		h.logger(ctx).Info("pull chart from repository mirror", "chart", obj.Spec.Chart, "repository", repo.Spec.URL, "mirror", mirrorURL, "error", errs[len(errs)-1].Error())
+
+		// The mirror is accessed with the credentials and settings of the repository
+		mirror := repo.DeepCopy()
+		mirror.Spec.URL = mirrorURL
+		err := h.pullFromHelmRepository(ctx, obj, mirror, b, db)
+		if err == nil {
+			h.logger(ctx).Info("pulled chart from repository mirror", "chart", obj.Spec.Chart, "version", b.Version, "repository", repo.Spec.URL, "mirror", mirrorURL)
+			return nil
 		}
 
-		h.cache.RepoSetUnlock(normalizedURL, chartRepo)
+		errs = append(errs, fmt.Errorf("mirror `%s`: %w", mirrorURL, err))
+		if errors.Is(err, chart.ErrChartVerification) || ctx.Err() != nil {
+			break
+		}
+	}
+
+	return fmt.Errorf("failed to pull chart `%s` from helmrepository `%s/%s` and its mirrors: %w", obj.Spec.Chart, repo.Namespace, repo.Name, errors.Join(errs...))
+}
+
+// repositoryMirrors returns the mirror URLs of the repository looked up by its normalized and its declared URL.
+func (h *Helm) repositoryMirrors(repo *sourcev1.HelmRepository) []string {
+	normalizedURL, err := repository.NormalizeURL(repo.Spec.URL)
+	if err != nil {
+		return nil
+	}
+
+	for _, key := range []string{normalizedURL, repo.Spec.URL} {
+		if mirrors, ok := h.opts.RepositoryMirrors[key]; ok {
+			return mirrors
+		}
+	}
+
+	return nil
+}
+
+// pullFromHelmRepository pulls the chart from the repository, the chart is cached by the URL and credentials of the
+// repository.
+func (h *Helm) pullFromHelmRepository(ctx context.Context, obj *sourcev1.HelmChart,
+	repo *sourcev1.HelmRepository, b *chart.Build, db map[ref]*resource.Resource) error {
+	normalizedURL, err := repository.NormalizeURL(repo.Spec.URL)
+	if err != nil {
+		return fmt.Errorf("failed to normalize url: %w", err)
+	}
+
+	// Charts pulled with different credentials may differ and are never shared
+	repoKey, err := h.repositoryKey(ctx, repo, normalizedURL, db)
+	if err != nil {
+		return err
+	}
+
+	if h.opts.SkipSuspended && repo.Spec.Suspend {
+		return h.buildFromSuspendedRepository(ctx, obj, repo, repoKey, b)
+	}
+
+	timeout := h.repositoryTimeout(repo, normalizedURL, repo.Spec.URL)
+	ctx, cancel := withTimeout(ctx, timeout)
+	defer cancel()
+
+	var provider string
+	if obj.Spec.Verify != nil {
+		provider = obj.Spec.Verify.Provider
+	}
+
+	verify, err := h.verifyChart(ctx, provider, "")
+	if err != nil {
+		return fmt.Errorf("failed to verify chart `%s`: %w", obj.Spec.Chart, err)
+	}
+
+	chartRepo, err := h.getChartRepository(ctx, repo, db)
+	if err != nil {
+		return err
+	}
+
+	var verifierNames []string
+	if verify {
+		ociChartRepo, ok := chartRepo.(*repository.OCIChartRepository)
+		if !ok {
+			return fmt.Errorf("chart verification is only supported for OCI helmrepositories, `%s/%s` is not an OCI repository", repo.Namespace, repo.Name)
+		}
+
+		verifiers, names, err := h.makeVerifiers(ctx, obj, repo.Namespace, db, ociChartRepo.RemoteOptions())
+		if err != nil {
+			return fmt.Errorf("failed to create verifiers for chart `%s`: %w", obj.Spec.Chart, err)
+		}
+
+		verifierNames = names
+		chartRepo = ociChartRepo.WithChartVerifiers(verifiers)
 	}
 
 	// Construct the chart builder with scoped configuration
 	cb := chart.NewRemoteBuilder(chartRepo)
+	// Values files are merged at render time, the cached chart is shared by all releases and must stay untouched
 	opts := chart.BuildOptions{
-		ValuesFiles: obj.GetValuesFiles(),
 		//Force:       obj.Generation != obj.Status.ObservedGeneration,
 		// The remote builder will not attempt to download the chart if
 		// an artifact exists with the same name and version and `Force` is false.
 		// It will however try to verify the chart if `obj.Spec.Verify` is set, at every reconciliation.
-		Verify: obj.Spec.Verify != nil && obj.Spec.Verify.Provider != "",
+		Verify: verify,
 	}
 
 	ref := chart.RemoteReference{Name: obj.Spec.Chart, Version: obj.Spec.Version}
-	path, newItem, err := h.cache.GetOrLock(normalizedURL, ref)
+	path, newItem, err := h.cache.GetOrLock(repoKey, ref)
 	if err != nil {
 		return err
 	}
 	if newItem == nil {
 		opts.CachedChart = path
-		h.Logger.V(1).Info("using cached chart artifact", "chart", ref.String(), "path", path)
+		h.logger(ctx).V(1).Info("using cached chart artifact", "chart", ref.String(), "path", path)
 	}
 
-	// Set the VersionMetadata to the object's Generation if ValuesFiles is defined
-	// This ensures changes can be noticed by the Artifact consumer
-	if len(opts.GetValuesFiles()) > 0 {
-		opts.VersionMetadata = strconv.FormatInt(obj.Generation, 10)
+	// Build the chart, transient failures are retried while holding the cache lock
+	var build *chart.Build
+	start := time.Now()
+	err = h.retry(ctx, "pull chart "+ref.String(), func() error {
+		build, err = cb.Build(ctx, ref, path, opts)
+		return err
+	})
+	// A new chart is validated before it is published to concurrent pulls waiting for it
+	if err == nil && newItem != nil {
+		err = validateChartArtifact(build)
 	}
-
-	// Build the chart
-	build, err := cb.Build(ctx, ref, path, opts)
 	if err != nil {
+		// The waiting pulls fail with the error and the next pull of the chart downloads it again
+		_ = h.cache.FailUnlock(newItem, err)
+		if errors.Is(err, chart.ErrChartVerification) {
+			return fmt.Errorf("failed to verify chart `%s` using provider %s with %s: %w", ref.String(), obj.Spec.Verify.Provider, strings.Join(verifierNames, ", "), err)
+		}
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("failed to build chart `%s` within the repository timeout of %s: %w", ref.String(), timeout, err)
+		}
 		return err
 	}
 
@@ -633,13 +1735,142 @@ func (h *Helm) buildFromHelmRepository(ctx context.Context, obj *sourcev1.HelmCh
 		return err
 	}
 	if newItem != nil {
-		h.Logger.V(1).Info("cached new chart", "chart", ref.String(), "path", path)
+		h.logger(ctx).V(1).Info("pulled chart", "chart", build.Name, "version", build.Version, "url", normalizedURL, "path", path, "duration", time.Since(start))
 	}
 
 	*b = *build
 	return nil
 }
 
+// validateChartArtifact verifies that the chart written to the cache matches the digest of its download and loads.
+func validateChartArtifact(b *chart.Build) error {
+	if b.Digest != "" {
+		digest, err := fileDigest(b.Path)
+		if err != nil {
+			return fmt.Errorf("failed to read chart artifact `%s`: %w", b.Path, err)
+		}
+
+		if digest != b.Digest {
+			return fmt.Errorf("chart artifact of `%s@%s` has the digest %s but %s was downloaded", b.Name, b.Version, digest, b.Digest)
+		}
+	}
+
+	if _, err := loader.Load(b.Path); err != nil {
+		return fmt.Errorf("chart artifact of `%s@%s` can't be loaded: %w", b.Name, b.Version, err)
+	}
+
+	return nil
+}
+
+// buildFromSuspendedRepository returns the cached chart of a suspended HelmRepository, the repository is never accessed
+// the same way source-controller doesn't refresh the artifact of a suspended repository.
+func (h *Helm) buildFromSuspendedRepository(ctx context.Context, obj *sourcev1.HelmChart, repo *sourcev1.HelmRepository, repoKey string, b *chart.Build) error {
+	ref := chart.RemoteReference{Name: obj.Spec.Chart, Version: obj.Spec.Version}
+	path, ok := h.cache.Get(repoKey, ref)
+	if !ok {
+		return fmt.Errorf("helmrepository `%s/%s` is suspended and chart `%s` is not cached", repo.GetNamespace(), repo.GetName(), ref.String())
+	}
+
+	metadata, err := chart.LoadChartMetadataFromArchive(path)
+	if err != nil {
+		return fmt.Errorf("failed to load cached chart `%s` of suspended helmrepository `%s/%s`: %w", ref.String(), repo.GetNamespace(), repo.GetName(), err)
+	}
+
+	digest, err := fileDigest(path)
+	if err != nil {
+		return err
+	}
+
+	h.logger(ctx).V(1).Info("using cached chart artifact of suspended helmrepository", "chart", ref.String(), "path", path)
+	*b = chart.Build{Name: metadata.Name, Version: metadata.Version, Path: path, Digest: digest}
+	return nil
+}
+
+// repositoryTimeout returns the timeout of the given source. Overrides are looked up by the
+// given urls (for instance normalized and as declared) and namespace/name of the source.
+func (h *Helm) repositoryTimeout(source metav1.Object, urls ...string) time.Duration {
+	for _, key := range append(urls, source.GetNamespace()+"/"+source.GetName()) {
+		if timeout, ok := h.opts.RepositoryTimeouts[key]; ok {
+			return timeout
+		}
+	}
+
+	return *h.opts.RepositoryTimeout
+}
+
+// withTimeout returns a context with the given timeout, a zero timeout never expires.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout == 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+// logger returns the logger attached to the context or the builders logger otherwise.
+// This allows callers to capture the logs of a single release build.
+func (h *Helm) logger(ctx context.Context) logr.Logger {
+	if logger, err := logr.FromContext(ctx); err == nil {
+		return logger
+	}
+
+	return h.Logger
+}
+
+// defaultKeychain returns the keychain used for OCI HelmRepositories if neither a secret nor a provider
+// resolved any credentials. This is the docker config (~/.docker/config.json or $DOCKER_CONFIG) which covers
+// registries like GitHub Container Registry or a self-hosted Harbor after a docker login.
+func (h *Helm) defaultKeychain(repo *sourcev1.HelmRepository) authn.Keychain {
+	if repo.Spec.Type != sourcev1beta2.HelmRepositoryTypeOCI || h.opts.NoDefaultKeychain {
+		return nil
+	}
+
+	return authn.DefaultKeychain
+}
+
+// insecureRegistry returns true if the OCI registry is meant to be accessed via plain HTTP.
+// This is either requested by the HelmRepository itself or globally by HelmOpts.InsecureRegistries.
+func (h *Helm) insecureRegistry(repo *sourcev1.HelmRepository, registryURL string) bool {
+	if repo.Spec.Insecure {
+		return true
+	}
+
+	u, err := url.Parse(registryURL)
+	if err != nil {
+		return false
+	}
+
+	return slices.Contains(h.opts.InsecureRegistries, u.Host)
+}
+
+// registryTLSConfig returns a copy of cfg which skips the verification of the TLS certificate if the registry host is
+// listed in HelmOpts.SkipTLSVerify, else cfg as is.
+func (h *Helm) registryTLSConfig(ctx context.Context, host string, cfg *tls.Config) *tls.Config {
+	if !slices.Contains(h.opts.SkipTLSVerify, host) {
+		return cfg
+	}
+
+	h.warnInsecureRegistry(ctx, host, "tls certificate not verified")
+	if cfg == nil {
+		cfg = &tls.Config{}
+	} else {
+		cfg = cfg.Clone()
+	}
+
+	cfg.InsecureSkipVerify = true
+	return cfg
+}
+
+// warnInsecureRegistry logs once per registry and reason that the registry is accessed insecurely. It is logged
+// regardless of the verbosity as insecure registries are meant for development and tests only.
+func (h *Helm) warnInsecureRegistry(ctx context.Context, host, reason string) {
+	if _, reported := h.insecureRegistries.LoadOrStore(host+"\x00"+reason, true); reported {
+		return
+	}
+
+	h.logger(ctx).Info("WARNING: accessing oci registry insecurely, this is meant for development and tests only", "registry", host, "reason", reason)
+}
+
 // oidcAuth generates the OIDC credential authenticator based on the specified cloud provider.
 func oidcAuth(ctx context.Context, url, provider string) (authn.Authenticator, error) {
 	u := strings.TrimPrefix(url, sourcev1beta2.OCIRepositoryPrefix)
@@ -675,60 +1906,132 @@ func makeLoginOption(auth authn.Authenticator, keychain authn.Keychain, registry
 	return nil, nil
 }
 
-// makeVerifiers returns a list of verifiers for the given chart.
-/*func (h *Helm) makeVerifiers(ctx context.Context, obj *sourcev1.HelmChart, auth authn.Authenticator, keychain authn.Keychain) ([]soci.Verifier, error) {
-	var verifiers []soci.Verifier
-	verifyOpts := []remote.Option{}
-	if auth != nil {
-		verifyOpts = append(verifyOpts, remote.WithAuth(auth))
-	} else {
-		verifyOpts = append(verifyOpts, remote.WithAuthFromKeychain(keychain))
-	}
+// makeVerifiers returns a list of verifiers for the given chart alongside the name of each verifier.
+// Public keys are looked up from the resource db, the verification secret lives in the namespace of the repository.
+func (h *Helm) makeVerifiers(ctx context.Context, obj *sourcev1.HelmChart, namespace string, db map[ref]*resource.Resource, remoteOpts []remote.Option) ([]soci.Verifier, []string, error) {
+	var (
+		verifiers []soci.Verifier
+		names     []string
+	)
 
 	switch obj.Spec.Verify.Provider {
 	case "cosign":
 		defaultCosignOciOpts := []soci.Options{
-			soci.WithRemoteOptions(verifyOpts...),
+			soci.WithRemoteOptions(append([]remote.Option{remote.WithContext(ctx)}, remoteOpts...)...),
 		}
 
 		// get the public keys from the given secret
 		if secretRef := obj.Spec.Verify.SecretRef; secretRef != nil {
-			certSecretName := types.NamespacedName{
-				Namespace: obj.Namespace,
-				Name:      secretRef.Name,
+			pubSecret, lookupRef, err := h.getSecret(secretRef.Name, namespace, db)
+			if err != nil {
+				return nil, nil, err
 			}
 
-			var pubSecret corev1.Secret
-			if err := h.Get(ctx, certSecretName, &pubSecret); err != nil {
-				return nil, err
+			if pubSecret == nil {
+				return nil, nil, fmt.Errorf("no verification secret `%v` found", lookupRef)
 			}
 
-			for k, data := range pubSecret.Data {
+			keys := make([]string, 0, len(pubSecret.Data))
+			for k := range pubSecret.Data {
+				keys = append(keys, k)
+			}
+			slices.Sort(keys)
+
+			for _, k := range keys {
 				// search for public keys in the secret
 				if strings.HasSuffix(k, ".pub") {
-					verifier, err := soci.NewCosignVerifier(ctx, append(defaultCosignOciOpts, soci.WithPublicKey(data))...)
+					verifier, err := soci.NewCosignVerifier(ctx, append(defaultCosignOciOpts, soci.WithPublicKey(pubSecret.Data[k]))...)
 					if err != nil {
-						return nil, err
+						return nil, nil, fmt.Errorf("invalid public key `%s` in secret `%s/%s`: %w", k, namespace, secretRef.Name, err)
 					}
 					verifiers = append(verifiers, verifier)
+					names = append(names, fmt.Sprintf("key %s/%s/%s", namespace, secretRef.Name, k))
 				}
 			}
 
 			if len(verifiers) == 0 {
-				return nil, fmt.Errorf("no public keys found in secret '%s'", certSecretName)
+				return nil, nil, fmt.Errorf("no public keys (*.pub) found in secret `%s/%s`", namespace, secretRef.Name)
 			}
-			return verifiers, nil
+			return verifiers, names, nil
 		}
 
 		// if no secret is provided, add a keyless verifier
+		var identities []cosign.Identity
+		keyless := "keyless"
+		for _, match := range obj.Spec.Verify.MatchOIDCIdentity {
+			identities = append(identities, cosign.Identity{
+				IssuerRegExp:  match.Issuer,
+				SubjectRegExp: match.Subject,
+			})
+			keyless += fmt.Sprintf(" issuer=%s subject=%s", match.Issuer, match.Subject)
+		}
+
+		defaultCosignOciOpts = append(defaultCosignOciOpts, soci.WithIdentities(identities), soci.WithRekorURL(h.opts.RekorURL))
 		verifier, err := soci.NewCosignVerifier(ctx, defaultCosignOciOpts...)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
+		}
+		return append(verifiers, verifier), append(names, keyless), nil
+	case "notation":
+		// the trust policy and the trust store certificates are read from the same secret
+		secretRef := obj.Spec.Verify.SecretRef
+		if secretRef == nil {
+			return nil, nil, errors.New("notation verification requires a secretRef holding the trust policy")
+		}
+
+		secret, lookupRef, err := h.getSecret(secretRef.Name, namespace, db)
+		if err != nil {
+			return nil, nil, err
 		}
-		verifiers = append(verifiers, verifier)
-		return verifiers, nil
+
+		if secret == nil {
+			return nil, nil, fmt.Errorf("no verification secret `%v` found", lookupRef)
+		}
+
+		policyData, ok := secret.Data["trustpolicy.json"]
+		if !ok {
+			return nil, nil, fmt.Errorf("no trustpolicy.json found in secret `%s/%s`", namespace, secretRef.Name)
+		}
+
+		policy, err := soci.ParseTrustPolicy(policyData)
+		if err != nil {
+			return nil, nil, fmt.Errorf("secret `%s/%s`: %w", namespace, secretRef.Name, err)
+		}
+
+		keys := make([]string, 0, len(secret.Data))
+		for k := range secret.Data {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+
+		var certs []*x509.Certificate
+		for _, k := range keys {
+			if !strings.HasSuffix(k, ".crt") && !strings.HasSuffix(k, ".pem") {
+				continue
+			}
+
+			parsed, err := soci.ParseCertificates(secret.Data[k])
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid certificate `%s` in secret `%s/%s`: %w", k, namespace, secretRef.Name, err)
+			}
+			certs = append(certs, parsed...)
+		}
+
+		if len(certs) == 0 {
+			return nil, nil, fmt.Errorf("no trust store certificates (*.crt, *.pem) found in secret `%s/%s`", namespace, secretRef.Name)
+		}
+
+		verifier, err := soci.NewNotationVerifier(
+			soci.WithTrustPolicy(policy),
+			soci.WithTrustStore(certs),
+			soci.WithNotationRemoteOptions(remoteOpts...),
+		)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return []soci.Verifier{verifier}, []string{fmt.Sprintf("trust policy %s/%s", namespace, secretRef.Name)}, nil
 	default:
-		return nil, fmt.Errorf("unsupported verification provider: %s", obj.Spec.Verify.Provider)
+		return nil, nil, fmt.Errorf("unsupported verification provider: %s", obj.Spec.Verify.Provider)
 	}
 }
-*/
@@ -0,0 +1,2434 @@
+package build
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/doodlescheduling/flux-build/internal/cachemgr"
+	"github.com/doodlescheduling/flux-build/internal/helm/chart"
+	"github.com/doodlescheduling/flux-build/internal/helm/repository"
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/fluxcd/pkg/runtime/transform"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+	sourcev1beta2 "github.com/fluxcd/source-controller/api/v1beta2"
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/funcr"
+	. "github.com/onsi/gomega"
+	"github.com/sigstore/sigstore/pkg/cryptoutils"
+	helmchart "helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	helmgetter "helm.sh/helm/v3/pkg/getter"
+	"helm.sh/helm/v3/pkg/release"
+	helmrepo "helm.sh/helm/v3/pkg/repo"
+	"helm.sh/helm/v3/pkg/strvals"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/kustomize/api/provider"
+	"sigs.k8s.io/kustomize/api/resmap"
+	"sigs.k8s.io/yaml"
+)
+
+const testChart = "../helm/testdata/charts/helmchart-0.1.0.tgz"
+
+// newChartServer serves a helm repository with a single chart and counts the chart downloads.
+func newChartServer(t *testing.T, downloads *atomic.Int32) *httptest.Server {
+	g := NewWithT(t)
+
+	c, err := loader.Load(testChart)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	archive, err := os.ReadFile(testChart)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	index := helmrepo.NewIndexFile()
+	g.Expect(index.MustAdd(c.Metadata, "helmchart-0.1.0.tgz", server.URL, "")).To(Succeed())
+	indexYAML, err := yaml.Marshal(index)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	mux.HandleFunc("/index.yaml", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(indexYAML)
+	})
+	mux.HandleFunc("/helmchart-0.1.0.tgz", func(w http.ResponseWriter, r *http.Request) {
+		downloads.Add(1)
+		// Keep the download in flight so concurrent builds overlap
+		time.Sleep(200 * time.Millisecond)
+		_, _ = w.Write(archive)
+	})
+
+	return server
+}
+
+func TestBuildChartDeduplicatesConcurrentResolutions(t *testing.T) {
+	g := NewWithT(t)
+
+	var downloads atomic.Int32
+	server := newChartServer(t, &downloads)
+
+	cache, err := cachemgr.New("none", "")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	h := NewHelmBuilder(logr.Discard(), HelmOpts{
+		Cache: cache,
+	})
+
+	repository := &sourcev1.HelmRepository{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "repo",
+			Namespace: "default",
+		},
+		Spec: sourcev1.HelmRepositorySpec{
+			URL: server.URL,
+		},
+	}
+
+	hr := helmv2.HelmRelease{
+		Spec: helmv2.HelmReleaseSpec{
+			Chart: &helmv2.HelmChartTemplate{
+				Spec: helmv2.HelmChartTemplateSpec{
+					Chart:   "helmchart",
+					Version: "0.1.0",
+					SourceRef: helmv2.CrossNamespaceObjectReference{
+						Kind: sourcev1.HelmRepositoryKind,
+						Name: "repo",
+					},
+				},
+			},
+		},
+	}
+
+	var wg sync.WaitGroup
+	builds := make([]*chart.Build, 5)
+	errs := make([]error, len(builds))
+	for i := range builds {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			builds[i] = &chart.Build{}
+			errs[i] = h.buildChart(context.Background(), repository, hr, nil, builds[i], nil)
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range builds {
+		g.Expect(errs[i]).ToNot(HaveOccurred())
+		g.Expect(builds[i].Name).To(Equal("helmchart"))
+		g.Expect(builds[i].Path).To(Equal(builds[0].Path))
+	}
+
+	g.Expect(downloads.Load()).To(Equal(int32(1)))
+}
+
+func TestResolvedChartOfSemverRange(t *testing.T) {
+	g := NewWithT(t)
+
+	var downloads atomic.Int32
+	server := newChartServer(t, &downloads)
+
+	archive, err := os.ReadFile(testChart)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	cache, err := cachemgr.New("none", "")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	db := newResourceIndex(t, fmt.Sprintf(`apiVersion: source.toolkit.fluxcd.io/v1
+kind: HelmRepository
+metadata:
+  name: repo
+  namespace: default
+spec:
+  url: %s
+`, server.URL))
+
+	hr := &helmv2.HelmRelease{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+		Spec: helmv2.HelmReleaseSpec{
+			Chart: &helmv2.HelmChartTemplate{
+				Spec: helmv2.HelmChartTemplateSpec{
+					Chart:   "helmchart",
+					Version: ">=0.1.0 <1.0.0",
+					SourceRef: helmv2.CrossNamespaceObjectReference{
+						Kind: sourcev1.HelmRepositoryKind,
+						Name: "repo",
+					},
+				},
+			},
+		},
+	}
+
+	h := NewHelmBuilder(logr.Discard(), HelmOpts{Cache: cache})
+	chartBuild, _, err := h.resolveChart(context.Background(), hr, &chartVerification{}, db)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	resolved := &ResolvedChart{}
+	h.recordResolvedChart(WithResolvedChart(context.Background(), resolved), hr, chartBuild)
+	g.Expect(*resolved).To(Equal(ResolvedChart{
+		Name:       "helmchart",
+		Constraint: ">=0.1.0 <1.0.0",
+		Version:    "0.1.0",
+		Digest:     chart.ArchiveDigest(archive),
+	}))
+}
+
+func TestBuildChartThroughProxy(t *testing.T) {
+	tests := []struct {
+		name           string
+		noProxy        string
+		expectRequests []string
+		expectErr      string
+	}{
+		{
+			name:           "chart repository is accessed through the proxy",
+			expectRequests: []string{"charts.example.invalid/index.yaml", "charts.example.invalid/helmchart-0.1.0.tgz"},
+		},
+		{
+			name:      "chart repository excluded by NO_PROXY is accessed directly",
+			noProxy:   ".example.invalid",
+			expectErr: "charts.example.invalid",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			t.Setenv("NO_PROXY", tt.noProxy)
+
+			c, err := loader.Load(testChart)
+			g.Expect(err).ToNot(HaveOccurred())
+			archive, err := os.ReadFile(testChart)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			index := helmrepo.NewIndexFile()
+			g.Expect(index.MustAdd(c.Metadata, "helmchart-0.1.0.tgz", "http://charts.example.invalid", "")).To(Succeed())
+			indexYAML, err := yaml.Marshal(index)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			// The proxy serves the repository itself instead of forwarding the requests
+			var mu sync.Mutex
+			var requests []string
+			proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				requests = append(requests, r.URL.Host+r.URL.Path)
+				mu.Unlock()
+
+				switch r.URL.Path {
+				case "/index.yaml":
+					_, _ = w.Write(indexYAML)
+				case "/helmchart-0.1.0.tgz":
+					_, _ = w.Write(archive)
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			t.Cleanup(proxy.Close)
+
+			proxyURL, err := url.Parse(proxy.URL)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			cache, err := cachemgr.New("none", "")
+			g.Expect(err).ToNot(HaveOccurred())
+
+			h := NewHelmBuilder(logr.Discard(), HelmOpts{
+				Cache: cache,
+				Proxy: proxyURL,
+			})
+
+			repository := &sourcev1.HelmRepository{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "repo",
+					Namespace: "default",
+				},
+				Spec: sourcev1.HelmRepositorySpec{
+					URL: "http://charts.example.invalid",
+				},
+			}
+
+			hr := helmv2.HelmRelease{
+				Spec: helmv2.HelmReleaseSpec{
+					Chart: &helmv2.HelmChartTemplate{
+						Spec: helmv2.HelmChartTemplateSpec{
+							Chart:   "helmchart",
+							Version: "0.1.0",
+							SourceRef: helmv2.CrossNamespaceObjectReference{
+								Kind: sourcev1.HelmRepositoryKind,
+								Name: "repo",
+							},
+						},
+					},
+				},
+			}
+
+			b := &chart.Build{}
+			err = h.buildChart(context.Background(), repository, hr, nil, b, nil)
+			if tt.expectErr != "" {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tt.expectErr))
+				g.Expect(requests).To(BeEmpty())
+				return
+			}
+
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(b.Name).To(Equal("helmchart"))
+			g.Expect(requests).To(Equal(tt.expectRequests))
+		})
+	}
+}
+
+func TestRepositoryTimeout(t *testing.T) {
+	timeout := func(d time.Duration) *time.Duration {
+		return &d
+	}
+
+	tests := []struct {
+		name          string
+		opts          HelmOpts
+		url           string
+		expectTimeout time.Duration
+	}{
+		{
+			name:          "default timeout",
+			url:           "https://charts.example.com",
+			expectTimeout: DefaultRepositoryTimeout,
+		},
+		{
+			name:          "zero timeout",
+			opts:          HelmOpts{RepositoryTimeout: timeout(0)},
+			url:           "https://charts.example.com",
+			expectTimeout: 0,
+		},
+		{
+			name: "override by url",
+			opts: HelmOpts{RepositoryTimeouts: map[string]time.Duration{
+				"https://charts.example.com/": 5 * time.Minute,
+				"default/repo":                time.Second,
+			}},
+			url:           "https://charts.example.com",
+			expectTimeout: 5 * time.Minute,
+		},
+		{
+			name: "override by name",
+			opts: HelmOpts{
+				RepositoryTimeout:  timeout(time.Second),
+				RepositoryTimeouts: map[string]time.Duration{"default/repo": 10 * time.Minute},
+			},
+			url:           "https://charts.example.com",
+			expectTimeout: 10 * time.Minute,
+		},
+		{
+			name: "override of another repository",
+			opts: HelmOpts{
+				RepositoryTimeout:  timeout(time.Second),
+				RepositoryTimeouts: map[string]time.Duration{"default/other": 10 * time.Minute},
+			},
+			url:           "https://charts.example.com",
+			expectTimeout: time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			h := NewHelmBuilder(logr.Discard(), tt.opts)
+			repo := &sourcev1.HelmRepository{
+				ObjectMeta: metav1.ObjectMeta{Name: "repo", Namespace: "default"},
+				Spec:       sourcev1.HelmRepositorySpec{URL: tt.url},
+			}
+
+			normalizedURL, err := repository.NormalizeURL(tt.url)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(h.repositoryTimeout(repo, normalizedURL, tt.url)).To(Equal(tt.expectTimeout))
+		})
+	}
+}
+
+func TestBuildChartRepositoryTimeout(t *testing.T) {
+	g := NewWithT(t)
+
+	var downloads atomic.Int32
+	server := newChartServer(t, &downloads)
+
+	cache, err := cachemgr.New("none", "")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	h := NewHelmBuilder(logr.Discard(), HelmOpts{
+		Cache: cache,
+		// The chart download takes 200ms
+		RepositoryTimeouts: map[string]time.Duration{"default/repo": 50 * time.Millisecond},
+	})
+
+	repo := &sourcev1.HelmRepository{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "repo",
+			Namespace: "default",
+		},
+		Spec: sourcev1.HelmRepositorySpec{
+			URL: server.URL,
+		},
+	}
+
+	hr := helmv2.HelmRelease{
+		Spec: helmv2.HelmReleaseSpec{
+			Chart: &helmv2.HelmChartTemplate{
+				Spec: helmv2.HelmChartTemplateSpec{
+					Chart:   "helmchart",
+					Version: "0.1.0",
+					SourceRef: helmv2.CrossNamespaceObjectReference{
+						Kind: sourcev1.HelmRepositoryKind,
+						Name: "repo",
+					},
+				},
+			},
+		},
+	}
+
+	err = h.buildChart(context.Background(), repo, hr, nil, &chart.Build{}, nil)
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(err.Error()).To(ContainSubstring("within the repository timeout of 50ms"))
+}
+
+func TestBuildChartPropagatesSharedFailure(t *testing.T) {
+	g := NewWithT(t)
+
+	var downloads atomic.Int32
+	server := newChartServer(t, &downloads)
+
+	cache, err := cachemgr.New("none", "")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	h := NewHelmBuilder(logr.Discard(), HelmOpts{
+		Cache: cache,
+	})
+
+	repository := &sourcev1.HelmRepository{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "repo",
+			Namespace: "default",
+		},
+		Spec: sourcev1.HelmRepositorySpec{
+			URL: server.URL,
+		},
+	}
+
+	hr := helmv2.HelmRelease{
+		Spec: helmv2.HelmReleaseSpec{
+			Chart: &helmv2.HelmChartTemplate{
+				Spec: helmv2.HelmChartTemplateSpec{
+					Chart:   "does-not-exist",
+					Version: "0.1.0",
+				},
+			},
+		},
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, 3)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = h.buildChart(context.Background(), repository, hr, nil, &chart.Build{}, nil)
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(err).To(Equal(errs[0]))
+	}
+}
+
+func TestBuildChartSharedResolutionOutlivesCanceledRelease(t *testing.T) {
+	g := NewWithT(t)
+
+	var downloads atomic.Int32
+	server := newChartServer(t, &downloads)
+
+	cache, err := cachemgr.New("none", "")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	h := NewHelmBuilder(logr.Discard(), HelmOpts{
+		Cache: cache,
+	})
+
+	repository := &sourcev1.HelmRepository{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "repo",
+			Namespace: "default",
+		},
+		Spec: sourcev1.HelmRepositorySpec{
+			URL: server.URL,
+		},
+	}
+
+	hr := helmv2.HelmRelease{
+		Spec: helmv2.HelmReleaseSpec{
+			Chart: &helmv2.HelmChartTemplate{
+				Spec: helmv2.HelmChartTemplateSpec{
+					Chart:   "helmchart",
+					Version: "0.1.0",
+				},
+			},
+		},
+	}
+
+	// The first release starts the resolution and is canceled while the download is in flight
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled := make(chan error)
+	go func() {
+		canceled <- h.buildChart(ctx, repository, hr, nil, &chart.Build{}, nil)
+	}()
+
+	g.Eventually(downloads.Load).Should(Equal(int32(1)))
+	waiting := make(chan error)
+	build := &chart.Build{}
+	go func() {
+		waiting <- h.buildChart(context.Background(), repository, hr, nil, build, nil)
+	}()
+
+	cancel()
+	g.Expect(<-canceled).To(MatchError(context.Canceled))
+	g.Expect(<-waiting).ToNot(HaveOccurred())
+	g.Expect(build.Name).To(Equal("helmchart"))
+	g.Expect(downloads.Load()).To(Equal(int32(1)))
+}
+
+func TestBuildChartLogsSharedFailure(t *testing.T) {
+	g := NewWithT(t)
+
+	// The index is served slowly so the releases share the failed resolution
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	t.Cleanup(server.Close)
+
+	cache, err := cachemgr.New("none", "")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	h := NewHelmBuilder(logr.Discard(), HelmOpts{
+		Cache: cache,
+	})
+
+	repository := &sourcev1.HelmRepository{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "repo",
+			Namespace: "default",
+		},
+		Spec: sourcev1.HelmRepositorySpec{
+			URL: server.URL,
+		},
+	}
+
+	hr := helmv2.HelmRelease{
+		Spec: helmv2.HelmReleaseSpec{
+			Chart: &helmv2.HelmChartTemplate{
+				Spec: helmv2.HelmChartTemplateSpec{
+					Chart:   "helmchart",
+					Version: "0.1.0",
+				},
+			},
+		},
+	}
+
+	var wg sync.WaitGroup
+	logs := make([][]string, 3)
+	errs := make([]error, len(logs))
+	var mu sync.Mutex
+	for i := range logs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			logger := funcr.New(func(prefix, args string) {
+				mu.Lock()
+				defer mu.Unlock()
+				logs[i] = append(logs[i], args)
+			}, funcr.Options{})
+			errs[i] = h.buildChart(logr.NewContext(context.Background(), logger), repository, hr, nil, &chart.Build{}, nil)
+		}(i)
+	}
+	wg.Wait()
+
+	// Each release logs the root cause of the shared resolution into its own logger
+	for i := range logs {
+		g.Expect(errs[i]).To(HaveOccurred())
+		g.Expect(logs[i]).To(ContainElement(And(
+			ContainSubstring(`"msg"="shared chart resolution failed"`),
+			ContainSubstring(errs[i].Error()),
+		)))
+	}
+}
+
+// memGetter serves files from memory by the path of their URL and records the URLs.
+type memGetter struct {
+	files map[string][]byte
+	urls  []string
+}
+
+func (m *memGetter) Get(u string, _ ...helmgetter.Option) (*bytes.Buffer, error) {
+	m.urls = append(m.urls, u)
+
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return nil, err
+	}
+
+	b, ok := m.files[parsed.Path]
+	if !ok {
+		return nil, fmt.Errorf("%s not found", u)
+	}
+
+	return bytes.NewBuffer(b), nil
+}
+
+func TestBuildChartCustomGetters(t *testing.T) {
+	var downloads atomic.Int32
+	server := newChartServer(t, &downloads)
+
+	c, err := loader.Load(testChart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive, err := os.ReadFile(testChart)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name            string
+		url             string
+		scheme          string
+		expectGetter    bool
+		expectDownloads int32
+		expectErr       string
+	}{
+		{
+			name:         "getter of a further scheme",
+			url:          "mem://charts",
+			scheme:       "mem",
+			expectGetter: true,
+		},
+		{
+			name:            "default getters are kept",
+			url:             server.URL,
+			scheme:          "mem",
+			expectDownloads: 1,
+		},
+		{
+			name:         "getter takes precedence over the default getter of its scheme",
+			url:          server.URL,
+			scheme:       "http",
+			expectGetter: true,
+		},
+		{
+			name:      "scheme without getter",
+			url:       "gs://charts",
+			scheme:    "mem",
+			expectErr: "scheme \"gs\" not supported",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			downloads.Store(0)
+
+			repoURL, err := url.Parse(tt.url)
+			g.Expect(err).ToNot(HaveOccurred())
+			index := helmrepo.NewIndexFile()
+			g.Expect(index.MustAdd(c.Metadata, "helmchart-0.1.0.tgz", tt.url, "")).To(Succeed())
+			indexYAML, err := yaml.Marshal(index)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			getter := &memGetter{files: map[string][]byte{
+				repoURL.Path + "/index.yaml":          indexYAML,
+				repoURL.Path + "/helmchart-0.1.0.tgz": archive,
+			}}
+
+			cache, err := cachemgr.New("none", "")
+			g.Expect(err).ToNot(HaveOccurred())
+			h := NewHelmBuilder(logr.Discard(), HelmOpts{
+				Cache: cache,
+				Getters: helmgetter.Providers{{
+					Schemes: []string{tt.scheme},
+					New: func(...helmgetter.Option) (helmgetter.Getter, error) {
+						return getter, nil
+					},
+				}},
+			})
+
+			repo := &sourcev1.HelmRepository{
+				ObjectMeta: metav1.ObjectMeta{Name: "repo", Namespace: "default"},
+				Spec:       sourcev1.HelmRepositorySpec{URL: tt.url},
+			}
+
+			hr := helmv2.HelmRelease{
+				Spec: helmv2.HelmReleaseSpec{
+					Chart: &helmv2.HelmChartTemplate{
+						Spec: helmv2.HelmChartTemplateSpec{
+							Chart:   "helmchart",
+							Version: "0.1.0",
+							SourceRef: helmv2.CrossNamespaceObjectReference{
+								Kind: sourcev1.HelmRepositoryKind,
+								Name: "repo",
+							},
+						},
+					},
+				},
+			}
+
+			b := &chart.Build{}
+			err = h.buildChart(context.Background(), repo, hr, nil, b, nil)
+			if tt.expectErr != "" {
+				g.Expect(err).To(MatchError(ContainSubstring(tt.expectErr)))
+				return
+			}
+
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(b.Name).To(Equal("helmchart"))
+			g.Expect(downloads.Load()).To(Equal(tt.expectDownloads))
+			if tt.expectGetter {
+				g.Expect(getter.urls).To(Equal([]string{tt.url + "/index.yaml", tt.url + "/helmchart-0.1.0.tgz"}))
+			} else {
+				g.Expect(getter.urls).To(BeEmpty())
+			}
+		})
+	}
+}
+
+func TestBuildChartValidatesArtifactBeforeCaching(t *testing.T) {
+	g := NewWithT(t)
+
+	c, err := loader.Load(testChart)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	archive, err := os.ReadFile(testChart)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// The metadata of the corrupt chart is valid, its values are not
+	corruptPath, err := chartutil.Save(&helmchart.Chart{
+		Metadata: c.Metadata,
+		Raw:      []*helmchart.File{{Name: chartutil.ValuesfileName, Data: []byte("a: b: c")}},
+	}, t.TempDir())
+	g.Expect(err).ToNot(HaveOccurred())
+	corrupt, err := os.ReadFile(corruptPath)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	index := helmrepo.NewIndexFile()
+	g.Expect(index.MustAdd(c.Metadata, "helmchart-0.1.0.tgz", server.URL, "")).To(Succeed())
+	indexYAML, err := yaml.Marshal(index)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	var downloads atomic.Int32
+	mux.HandleFunc("/index.yaml", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(indexYAML)
+	})
+	mux.HandleFunc("/helmchart-0.1.0.tgz", func(w http.ResponseWriter, r *http.Request) {
+		// Keep the download in flight so the other consumers wait for it
+		time.Sleep(200 * time.Millisecond)
+		if downloads.Add(1) == 1 {
+			_, _ = w.Write(corrupt)
+			return
+		}
+		_, _ = w.Write(archive)
+	})
+
+	cache, err := cachemgr.New("inmemory", "")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	repository := &sourcev1.HelmRepository{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "repo",
+			Namespace: "default",
+		},
+		Spec: sourcev1.HelmRepositorySpec{
+			URL: server.URL,
+		},
+	}
+
+	hr := helmv2.HelmRelease{
+		Spec: helmv2.HelmReleaseSpec{
+			Chart: &helmv2.HelmChartTemplate{
+				Spec: helmv2.HelmChartTemplateSpec{
+					Chart:   "helmchart",
+					Version: "0.1.0",
+					SourceRef: helmv2.CrossNamespaceObjectReference{
+						Kind: sourcev1.HelmRepositoryKind,
+						Name: "repo",
+					},
+				},
+			},
+		},
+	}
+
+	// Builders don't share resolutions, the consumers meet at the cache
+	build := func() (*chart.Build, error) {
+		h := NewHelmBuilder(logr.Discard(), HelmOpts{Cache: cache})
+		b := &chart.Build{}
+		return b, h.buildChart(context.Background(), repository, hr, nil, b, nil)
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, 3)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = build()
+		}(i)
+		// The first consumer takes the lock of the chart
+		time.Sleep(50 * time.Millisecond)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(err.Error()).To(ContainSubstring("chart artifact of `helmchart@0.1.0` can't be loaded"))
+	}
+	g.Expect(downloads.Load()).To(Equal(int32(1)))
+
+	// The corrupt artifact is not cached, the next consumer downloads the chart again
+	b, err := build()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(downloads.Load()).To(Equal(int32(2)))
+	_, err = loader.Load(b.Path)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	b, err = build()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(downloads.Load()).To(Equal(int32(2)))
+}
+
+func TestBuildChartRepositoryMirrors(t *testing.T) {
+	var downloads, mirrorDownloads atomic.Int32
+	primary := newChartServer(t, &downloads)
+	mirror := newChartServer(t, &mirrorDownloads)
+	missing := httptest.NewServer(http.NotFoundHandler())
+	t.Cleanup(missing.Close)
+
+	tests := []struct {
+		name                  string
+		url                   string
+		mirrors               map[string][]string
+		expectDownloads       int32
+		expectMirrorDownloads int32
+		expectErr             []string
+	}{
+		{
+			name:            "mirrors are not used if the repository succeeds",
+			url:             primary.URL,
+			mirrors:         map[string][]string{primary.URL: {mirror.URL}},
+			expectDownloads: 1,
+		},
+		{
+			name:                  "mirrors are tried in order",
+			url:                   missing.URL,
+			mirrors:               map[string][]string{missing.URL: {missing.URL + "/mirror", mirror.URL}},
+			expectMirrorDownloads: 1,
+		},
+		{
+			name:                  "mirrors keyed by the normalized url",
+			url:                   missing.URL,
+			mirrors:               map[string][]string{missing.URL + "/": {mirror.URL}},
+			expectMirrorDownloads: 1,
+		},
+		{
+			name:    "all mirrors fail",
+			url:     missing.URL,
+			mirrors: map[string][]string{missing.URL: {missing.URL + "/mirror"}},
+			expectErr: []string{
+				"failed to pull chart `helmchart` from helmrepository `default/repo` and its mirrors",
+				"mirror `" + missing.URL + "/mirror`",
+			},
+		},
+		{
+			name:      "no mirrors",
+			url:       missing.URL,
+			mirrors:   map[string][]string{primary.URL: {mirror.URL}},
+			expectErr: []string{missing.URL + "/index.yaml"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			downloads.Store(0)
+			mirrorDownloads.Store(0)
+
+			cache, err := cachemgr.New("none", "")
+			g.Expect(err).ToNot(HaveOccurred())
+
+			h := NewHelmBuilder(logr.Discard(), HelmOpts{
+				Cache:             cache,
+				RepositoryMirrors: tt.mirrors,
+			})
+
+			repo := &sourcev1.HelmRepository{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "repo",
+					Namespace: "default",
+				},
+				Spec: sourcev1.HelmRepositorySpec{
+					URL: tt.url,
+				},
+			}
+
+			hr := helmv2.HelmRelease{
+				Spec: helmv2.HelmReleaseSpec{
+					Chart: &helmv2.HelmChartTemplate{
+						Spec: helmv2.HelmChartTemplateSpec{
+							Chart:   "helmchart",
+							Version: "0.1.0",
+							SourceRef: helmv2.CrossNamespaceObjectReference{
+								Kind: sourcev1.HelmRepositoryKind,
+								Name: "repo",
+							},
+						},
+					},
+				},
+			}
+
+			b := &chart.Build{}
+			err = h.buildChart(context.Background(), repo, hr, nil, b, nil)
+			if len(tt.expectErr) > 0 {
+				g.Expect(err).To(HaveOccurred())
+				for _, expectErr := range tt.expectErr {
+					g.Expect(err.Error()).To(ContainSubstring(expectErr))
+				}
+				return
+			}
+
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(b.Name).To(Equal("helmchart"))
+			g.Expect(downloads.Load()).To(Equal(tt.expectDownloads))
+			g.Expect(mirrorDownloads.Load()).To(Equal(tt.expectMirrorDownloads))
+		})
+	}
+}
+
+func newResourceIndex(t *testing.T, manifests string) ResourceIndex {
+	g := NewWithT(t)
+
+	resMap, err := resmap.NewFactory(provider.NewDefaultDepProvider().GetResourceFactory()).NewResMapFromBytes([]byte(manifests))
+	g.Expect(err).ToNot(HaveOccurred())
+
+	index := make(ResourceIndex)
+	g.Expect(index.Push(resMap.Resources())).To(Succeed())
+	return index
+}
+
+func newCertificatePEM(t *testing.T, key *ecdsa.PrivateKey) []byte {
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestMakeVerifiers(t *testing.T) {
+	g := NewWithT(t)
+
+	trustPolicy := `{"version":"1.0","trustPolicies":[{"name":"charts","registryScopes":["*"],"signatureVerification":{"level":"strict"},"trustStores":["ca:charts"],"trustedIdentities":["*"]}]}`
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	g.Expect(err).ToNot(HaveOccurred())
+	pub, err := cryptoutils.MarshalPublicKeyToPEM(key.Public())
+	g.Expect(err).ToNot(HaveOccurred())
+
+	db := newResourceIndex(t, fmt.Sprintf(`apiVersion: v1
+kind: Secret
+metadata:
+  name: cosign-keys
+  namespace: default
+data:
+  a.pub: %s
+  b.pub: %s
+  readme: %s
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: no-keys
+  namespace: default
+data:
+  readme: %s
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: notation
+  namespace: default
+data:
+  trustpolicy.json: %s
+  ca.crt: %s
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: notation-no-certs
+  namespace: default
+data:
+  trustpolicy.json: %s
+`, base64.StdEncoding.EncodeToString(pub), base64.StdEncoding.EncodeToString(pub), base64.StdEncoding.EncodeToString([]byte("none")), base64.StdEncoding.EncodeToString([]byte("none")),
+		base64.StdEncoding.EncodeToString([]byte(trustPolicy)), base64.StdEncoding.EncodeToString(newCertificatePEM(t, key)), base64.StdEncoding.EncodeToString([]byte(trustPolicy))))
+
+	tests := []struct {
+		name        string
+		verify      *sourcev1.OCIRepositoryVerification
+		expectNames []string
+		expectErr   string
+	}{
+		{
+			name: "public keys from secret",
+			verify: &sourcev1.OCIRepositoryVerification{
+				Provider:  "cosign",
+				SecretRef: &meta.LocalObjectReference{Name: "cosign-keys"},
+			},
+			expectNames: []string{"key default/cosign-keys/a.pub", "key default/cosign-keys/b.pub"},
+		},
+		{
+			name: "secret without public keys",
+			verify: &sourcev1.OCIRepositoryVerification{
+				Provider:  "cosign",
+				SecretRef: &meta.LocalObjectReference{Name: "no-keys"},
+			},
+			expectErr: "no public keys (*.pub) found in secret `default/no-keys`",
+		},
+		{
+			name: "missing secret",
+			verify: &sourcev1.OCIRepositoryVerification{
+				Provider:  "cosign",
+				SecretRef: &meta.LocalObjectReference{Name: "does-not-exist"},
+			},
+			expectErr: "no verification secret",
+		},
+		{
+			name: "notation trust policy from secret",
+			verify: &sourcev1.OCIRepositoryVerification{
+				Provider:  "notation",
+				SecretRef: &meta.LocalObjectReference{Name: "notation"},
+			},
+			expectNames: []string{"trust policy default/notation"},
+		},
+		{
+			name: "notation without certificates",
+			verify: &sourcev1.OCIRepositoryVerification{
+				Provider:  "notation",
+				SecretRef: &meta.LocalObjectReference{Name: "notation-no-certs"},
+			},
+			expectErr: "no trust store certificates (*.crt, *.pem) found in secret `default/notation-no-certs`",
+		},
+		{
+			name: "notation without trust policy",
+			verify: &sourcev1.OCIRepositoryVerification{
+				Provider:  "notation",
+				SecretRef: &meta.LocalObjectReference{Name: "no-keys"},
+			},
+			expectErr: "no trustpolicy.json found in secret `default/no-keys`",
+		},
+		{
+			name: "notation without secret",
+			verify: &sourcev1.OCIRepositoryVerification{
+				Provider: "notation",
+			},
+			expectErr: "notation verification requires a secretRef",
+		},
+		{
+			name: "unsupported provider",
+			verify: &sourcev1.OCIRepositoryVerification{
+				Provider: "pgp",
+			},
+			expectErr: "unsupported verification provider: pgp",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			cache, err := cachemgr.New("none", "")
+			g.Expect(err).ToNot(HaveOccurred())
+			h := NewHelmBuilder(logr.Discard(), HelmOpts{
+				Cache: cache,
+			})
+
+			obj := &sourcev1.HelmChart{
+				Spec: sourcev1.HelmChartSpec{
+					Verify: tt.verify,
+				},
+			}
+
+			verifiers, names, err := h.makeVerifiers(context.Background(), obj, "default", db, nil)
+			if tt.expectErr != "" {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tt.expectErr))
+				return
+			}
+
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(names).To(Equal(tt.expectNames))
+			g.Expect(verifiers).To(HaveLen(len(tt.expectNames)))
+		})
+	}
+}
+
+func TestLoadChartResolvesDependencies(t *testing.T) {
+	var downloads atomic.Int32
+	server := newChartServer(t, &downloads)
+
+	parent := &helmchart.Chart{
+		Metadata: &helmchart.Metadata{
+			APIVersion: helmchart.APIVersionV2,
+			Name:       "parent",
+			Version:    "1.0.0",
+			Dependencies: []*helmchart.Dependency{
+				{
+					Name:       "helmchart",
+					Version:    "0.1.0",
+					Repository: server.URL,
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		db        string
+		expectErr string
+	}{
+		{
+			name: "dependency from declared helmrepository",
+			db: fmt.Sprintf(`apiVersion: source.toolkit.fluxcd.io/v1
+kind: HelmRepository
+metadata:
+  name: repo
+  namespace: default
+spec:
+  url: %s
+`, server.URL),
+		},
+		{
+			name:      "undeclared helmrepository",
+			db:        "",
+			expectErr: "no helmrepository with url",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			path, err := chartutil.Save(parent, t.TempDir())
+			g.Expect(err).ToNot(HaveOccurred())
+
+			cache, err := cachemgr.New("none", "")
+			g.Expect(err).ToNot(HaveOccurred())
+			h := NewHelmBuilder(logr.Discard(), HelmOpts{
+				Cache: cache,
+			})
+
+			loaded, err := h.loadChart(context.Background(), &chart.Build{Name: "parent", Version: "1.0.0", Path: path}, helmv2.HelmChartTemplateSpec{}, newResourceIndex(t, tt.db))
+			if tt.expectErr != "" {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tt.expectErr))
+				return
+			}
+
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(loaded.Dependencies()).To(HaveLen(1))
+			g.Expect(loaded.Dependencies()[0].Name()).To(Equal("helmchart"))
+		})
+	}
+}
+
+func TestRenderReleaseMergesValuesFiles(t *testing.T) {
+	valuesChart := &helmchart.Chart{
+		Metadata: &helmchart.Metadata{
+			APIVersion: helmchart.APIVersionV2,
+			Name:       "values",
+			Version:    "1.0.0",
+		},
+		Templates: []*helmchart.File{
+			{
+				Name: "templates/configmap.yaml",
+				Data: []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: values
+data:
+  a: {{ .Values.a | quote }}
+  b: {{ .Values.b | quote }}
+  c: {{ .Values.c | quote }}
+`),
+			},
+		},
+		Raw: []*helmchart.File{
+			{Name: "values.yaml", Data: []byte("a: default\nb: default\nc: default\n")},
+		},
+		Files: []*helmchart.File{
+			{Name: "values-prod.yaml", Data: []byte("a: prod\nb: prod\n")},
+			{Name: "values-eu.yaml", Data: []byte("b: eu\n")},
+		},
+	}
+
+	tests := []struct {
+		name          string
+		valuesFiles   []string
+		ignoreMissing bool
+		values        string
+		expectData    map[string]string
+		expectErr     bool
+	}{
+		{
+			name:       "chart default values",
+			expectData: map[string]string{"a": "default", "b": "default", "c": "default"},
+		},
+		{
+			name:        "values files in declared order",
+			valuesFiles: []string{"values.yaml", "values-prod.yaml", "values-eu.yaml"},
+			expectData:  map[string]string{"a": "prod", "b": "eu", "c": "default"},
+		},
+		{
+			name:        "values files replace the default values",
+			valuesFiles: []string{"values-eu.yaml", "values-prod.yaml"},
+			expectData:  map[string]string{"a": "prod", "b": "prod", "c": ""},
+		},
+		{
+			name:        "release values take precedence",
+			valuesFiles: []string{"values.yaml", "values-prod.yaml"},
+			values:      `{"a": "release"}`,
+			expectData:  map[string]string{"a": "release", "b": "prod", "c": "default"},
+		},
+		{
+			name:        "missing values file",
+			valuesFiles: []string{"values.yaml", "values-missing.yaml"},
+			expectErr:   true,
+		},
+		{
+			name:          "ignore missing values file",
+			valuesFiles:   []string{"values.yaml", "values-missing.yaml", "values-eu.yaml"},
+			ignoreMissing: true,
+			expectData:    map[string]string{"a": "default", "b": "eu", "c": "default"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			path, err := chartutil.Save(valuesChart, t.TempDir())
+			g.Expect(err).ToNot(HaveOccurred())
+
+			cache, err := cachemgr.New("none", "")
+			g.Expect(err).ToNot(HaveOccurred())
+			h := NewHelmBuilder(logr.Discard(), HelmOpts{
+				Cache: cache,
+			})
+
+			hr := helmv2.HelmRelease{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "values",
+					Namespace: "default",
+				},
+				Spec: helmv2.HelmReleaseSpec{
+					Chart: &helmv2.HelmChartTemplate{
+						Spec: helmv2.HelmChartTemplateSpec{
+							Chart:                    "values",
+							ValuesFiles:              tt.valuesFiles,
+							IgnoreMissingValuesFiles: tt.ignoreMissing,
+						},
+					},
+				},
+			}
+			if tt.values != "" {
+				hr.Spec.Values = &apiextensionsv1.JSON{Raw: []byte(tt.values)}
+			}
+
+			loaded, err := h.loadChart(context.Background(), &chart.Build{Name: "values", Version: "1.0.0", Path: path}, hr.Spec.Chart.Spec, nil)
+			if tt.expectErr {
+				g.Expect(err).To(HaveOccurred())
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+
+			values, err := h.composeValues(context.Background(), nil, hr)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			rel, err := h.renderRelease(context.Background(), hr, nil, values, loaded)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			cm := corev1.ConfigMap{}
+			g.Expect(yaml.Unmarshal([]byte(strings.TrimPrefix(rel.Manifest, "---\n# Source: values/templates/configmap.yaml\n")), &cm)).To(Succeed())
+			g.Expect(cm.Data).To(Equal(tt.expectData))
+		})
+	}
+}
+
+func TestRenderReleaseNamespaces(t *testing.T) {
+	releaseChart := &helmchart.Chart{
+		Metadata: &helmchart.Metadata{
+			APIVersion: helmchart.APIVersionV2,
+			Name:       "release",
+			Version:    "1.0.0",
+		},
+		Templates: []*helmchart.File{
+			{
+				Name: "templates/configmap.yaml",
+				Data: []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: release
+data:
+  name: {{ .Release.Name | quote }}
+  namespace: {{ .Release.Namespace | quote }}
+`),
+			},
+		},
+	}
+
+	tests := []struct {
+		name            string
+		action          ReleaseAction
+		hrName          string
+		releaseName     string
+		targetNamespace string
+		expectName      string
+		expectNamespace string
+	}{
+		{
+			name:            "namespace of the helmrelease",
+			hrName:          "podinfo",
+			expectName:      "podinfo",
+			expectNamespace: "flux-system",
+		},
+		{
+			name:            "target namespace",
+			hrName:          "podinfo",
+			targetNamespace: "apps",
+			expectName:      "apps-podinfo",
+			expectNamespace: "apps",
+		},
+		{
+			name:            "target namespace upgrade",
+			action:          ReleaseActionUpgrade,
+			hrName:          "podinfo",
+			targetNamespace: "apps",
+			expectName:      "apps-podinfo",
+			expectNamespace: "apps",
+		},
+		{
+			name:            "release name",
+			hrName:          "podinfo",
+			releaseName:     "frontend",
+			targetNamespace: "apps",
+			expectName:      "frontend",
+			expectNamespace: "apps",
+		},
+		{
+			name:            "shortened release name",
+			hrName:          "a-very-long-helmrelease-name",
+			targetNamespace: "a-very-long-target-namespace-name",
+			expectName:      "a-very-long-target-namespace-name-a-very-f7f7161a336b",
+			expectNamespace: "a-very-long-target-namespace-name",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			h := NewHelmBuilder(logr.Discard(), HelmOpts{
+				Action: tt.action,
+			})
+
+			hr := helmv2.HelmRelease{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      tt.hrName,
+					Namespace: "flux-system",
+				},
+				Spec: helmv2.HelmReleaseSpec{
+					ReleaseName:      tt.releaseName,
+					TargetNamespace:  tt.targetNamespace,
+					StorageNamespace: "flux-system",
+				},
+			}
+
+			rel, err := h.renderRelease(context.Background(), hr, nil, chartutil.Values{}, releaseChart)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(rel.Name).To(Equal(tt.expectName))
+			g.Expect(rel.Namespace).To(Equal(tt.expectNamespace))
+
+			m, err := resmap.NewFactory(provider.NewDefaultDepProvider().GetResourceFactory()).NewResMapFromBytes([]byte(rel.Manifest))
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(m.Resources()).To(HaveLen(1))
+			g.Expect(m.Resources()[0].GetNamespace()).To(Equal(tt.expectNamespace))
+			g.Expect(m.Resources()[0].GetDataMap()).To(Equal(map[string]string{
+				"name":      tt.expectName,
+				"namespace": tt.expectNamespace,
+			}))
+		})
+	}
+}
+
+// setTargetPathController sets the value the way helm-controller does by concatenating the target path and the value.
+// testCertificatePEM is the data of a certificate as it's referenced at a targetPath, the base64 body contains slashes,
+// plus and equal signs.
+const testCertificatePEM = `-----BEGIN CERTIFICATE-----
+MIIBhTCCASugAwIBAgIQIRi6zePL6mKjOipn+dNuaTAKBggqhkjOPQQDAjASMRAw
+DgYDVQQKEwdBY21lIENvMB4XDTE3MTAyMDE5NDMwNloXDTE4MTAyMDE5NDMwNlow
+EjEQMA4GA1UEChMHQWNtZSBDbzBZMBMGByqGSM49AgEGCCqGSM49AwEHA0IABD0d
+7VNhbWvZLWPuj/RtHFjvtJBEwOkhbN/BnnE8rnZR8+sbwnc/KhCk3FhnpHZnQz7B
+5aETbbIgmuvewdjvSBSjYzBhMA4GA1UdDwEB/wQEAwICpDATBgNVHSUEDDAKBggr
+BgEFBQcDATAPBgNVHRMBAf8EBTADAQH/MCkGA1UdEQQiMCCCDmxvY2FsaG9zdDo1
+NDUzgg4xMjcuMC4wLjE6NTQ1MzAKBggqhkjOPQQDAgNIADBFAiEA2zpJEPQyz6/l
+Wf86aX6PepsntZv2GYlA5UpabfT2EZICICpJ5h/iI+i341gBmLiAFQOyTDT+/wQc
+6MF9+Yw1Yy0t
+-----END CERTIFICATE-----
+`
+
+func setTargetPathController(values chartutil.Values, targetPath, value string) error {
+	if (strings.HasPrefix(value, "'") && strings.HasSuffix(value, "'")) || (strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`)) {
+		return strvals.ParseIntoString(targetPath+"="+strings.Trim(value, `'"`), values)
+	}
+
+	return strvals.ParseInto(targetPath+"="+value, values)
+}
+
+func TestSetTargetPath(t *testing.T) {
+	tests := []struct {
+		name       string
+		values     string
+		targetPath string
+		value      string
+		literal    bool
+		expect     map[string]interface{}
+		expectErr  string
+		// controller is set if helm-controller produces the same values
+		controller bool
+	}{
+		{
+			name:       "nested keys",
+			targetPath: "server.ingress.enabled",
+			value:      "true",
+			expect:     map[string]interface{}{"server": map[string]interface{}{"ingress": map[string]interface{}{"enabled": true}}},
+			controller: true,
+		},
+		{
+			name:       "list index",
+			targetPath: "server.ingress.hosts[0]",
+			value:      "example.com",
+			expect:     map[string]interface{}{"server": map[string]interface{}{"ingress": map[string]interface{}{"hosts": []interface{}{"example.com"}}}},
+			controller: true,
+		},
+		{
+			name:       "nested key of list item",
+			targetPath: "hosts[1].name",
+			value:      "example.com",
+			expect:     map[string]interface{}{"hosts": []interface{}{nil, map[string]interface{}{"name": "example.com"}}},
+			controller: true,
+		},
+		{
+			name:       "append to list of a previous reference",
+			values:     `{"hosts": ["a.example.com"]}`,
+			targetPath: "hosts[1]",
+			value:      "b.example.com",
+			expect:     map[string]interface{}{"hosts": []interface{}{"a.example.com", "b.example.com"}},
+			controller: true,
+		},
+		{
+			name:       "replace list item of a previous reference",
+			values:     `{"hosts": ["a.example.com", "b.example.com"]}`,
+			targetPath: "hosts[0]",
+			value:      "c.example.com",
+			expect:     map[string]interface{}{"hosts": []interface{}{"c.example.com", "b.example.com"}},
+			controller: true,
+		},
+		{
+			name:       "null deletes a value of a previous reference",
+			values:     `{"server": {"ingress": {"enabled": true, "hosts": ["example.com"]}}}`,
+			targetPath: "server.ingress.hosts",
+			value:      "null",
+			literal:    true,
+			expect:     map[string]interface{}{"server": map[string]interface{}{"ingress": map[string]interface{}{"enabled": true, "hosts": nil}}},
+			controller: true,
+		},
+		{
+			name:       "escaped dot",
+			targetPath: `podAnnotations.prometheus\.io/scrape`,
+			value:      "true",
+			literal:    true,
+			expect:     map[string]interface{}{"podAnnotations": map[string]interface{}{"prometheus.io/scrape": "true"}},
+		},
+		{
+			name:       "dotted key",
+			targetPath: `metrics\.enabled`,
+			value:      "false",
+			expect:     map[string]interface{}{"metrics.enabled": false},
+			controller: true,
+		},
+		{
+			name:       "typed integer",
+			targetPath: "replicas",
+			value:      "3",
+			expect:     map[string]interface{}{"replicas": int64(3)},
+			controller: true,
+		},
+		{
+			name:       "quoted integer",
+			targetPath: "replicas",
+			value:      `"3"`,
+			expect:     map[string]interface{}{"replicas": `"3"`},
+		},
+		{
+			name:       "literal list index",
+			targetPath: "a.b[0]",
+			value:      "x",
+			literal:    true,
+			expect:     map[string]interface{}{"a": map[string]interface{}{"b": []interface{}{"x"}}},
+			controller: true,
+		},
+		{
+			name:       "literal zero-padded number in list",
+			targetPath: "ports[1]",
+			value:      "08",
+			literal:    true,
+			expect:     map[string]interface{}{"ports": []interface{}{nil, "08"}},
+			controller: true,
+		},
+		{
+			name:       "literal boolean in list",
+			targetPath: "a.flags[0]",
+			value:      "true",
+			literal:    true,
+			expect:     map[string]interface{}{"a": map[string]interface{}{"flags": []interface{}{"true"}}},
+		},
+		{
+			name:       "literal integer",
+			targetPath: "replicas",
+			value:      "3",
+			literal:    true,
+			expect:     map[string]interface{}{"replicas": "3"},
+		},
+		{
+			name:       "value containing equal signs",
+			targetPath: "token",
+			value:      "YWJj==",
+			expect:     map[string]interface{}{"token": "YWJj=="},
+			controller: true,
+		},
+		{
+			name:       "value containing commas",
+			targetPath: "config",
+			value:      "a=1,b=2",
+			expect:     map[string]interface{}{"config": "a=1,b=2"},
+		},
+		{
+			name:       "PEM certificate",
+			targetPath: "tls.ca",
+			value:      testCertificatePEM,
+			expect:     map[string]interface{}{"tls": map[string]interface{}{"ca": testCertificatePEM}},
+			controller: true,
+		},
+		{
+			name:       "literal PEM certificate at list index",
+			targetPath: "certificates[1]",
+			value:      testCertificatePEM,
+			literal:    true,
+			expect:     map[string]interface{}{"certificates": []interface{}{nil, testCertificatePEM}},
+			controller: true,
+		},
+		{
+			name:       "comma separated list",
+			targetPath: "ingress.hosts",
+			value:      "a.example.com,b.example.com",
+			expect:     map[string]interface{}{"ingress": map[string]interface{}{"hosts": "a.example.com,b.example.com"}},
+		},
+		{
+			name:       "literal comma separated list at list index",
+			values:     `{"ingress": {"hosts": ["a.example.com"]}}`,
+			targetPath: "ingress.hosts[0]",
+			value:      "b.example.com, c.example.com",
+			literal:    true,
+			expect:     map[string]interface{}{"ingress": map[string]interface{}{"hosts": []interface{}{"b.example.com, c.example.com"}}},
+		},
+		{
+			name:       "JSON object",
+			targetPath: "config.json",
+			value:      `{"endpoints": ["a", "b"], "timeout": "5s", "retry": {"max": 3}}`,
+			expect:     map[string]interface{}{"config": map[string]interface{}{"json": `{"endpoints": ["a", "b"], "timeout": "5s", "retry": {"max": 3}}`}},
+		},
+		{
+			name:       "JSON list",
+			targetPath: "args",
+			value:      `["--verbose", "--port=8080"]`,
+			expect:     map[string]interface{}{"args": `["--verbose", "--port=8080"]`},
+		},
+		{
+			name:       "braces within the value",
+			targetPath: "args[0]",
+			value:      "--name={{ .Release.Name }}",
+			expect:     map[string]interface{}{"args": []interface{}{"--name={{ .Release.Name }}"}},
+			controller: true,
+		},
+		{
+			name:       "value containing braces",
+			targetPath: "selector",
+			value:      "{app: podinfo}",
+			expect:     map[string]interface{}{"selector": "{app: podinfo}"},
+		},
+		{
+			name:       "value containing backslashes",
+			targetPath: "pattern",
+			value:      `^\d+$`,
+			expect:     map[string]interface{}{"pattern": `^\d+$`},
+		},
+		{
+			name:       "multi-line value",
+			targetPath: "config",
+			value:      "a: 1\nb: [1, 2]\n",
+			expect:     map[string]interface{}{"config": "a: 1\nb: [1, 2]\n"},
+		},
+		{
+			name:       "invalid target path",
+			targetPath: "a=b,c",
+			value:      "1",
+			expectErr:  "invalid target path",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			values := chartutil.Values{}
+			if tt.values != "" {
+				g.Expect(yaml.Unmarshal([]byte(tt.values), &values)).To(Succeed())
+			}
+			controllerValues := chartutil.Values{}
+			if tt.values != "" {
+				g.Expect(yaml.Unmarshal([]byte(tt.values), &controllerValues)).To(Succeed())
+			}
+
+			err := setTargetPath(values, tt.targetPath, tt.value, !tt.literal)
+			if tt.expectErr != "" {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tt.expectErr))
+				return
+			}
+
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(map[string]interface{}(values)).To(Equal(tt.expect))
+
+			controllerErr := setTargetPathController(controllerValues, tt.targetPath, tt.value)
+			if tt.controller {
+				g.Expect(controllerErr).ToNot(HaveOccurred())
+				g.Expect(controllerValues).To(Equal(values))
+			} else {
+				g.Expect(controllerErr == nil && reflect.DeepEqual(controllerValues, values)).To(BeFalse())
+			}
+		})
+	}
+}
+
+func TestSetTargetPathTypes(t *testing.T) {
+	tests := []struct {
+		targetPath string
+		value      string
+		typed      bool
+		expect     interface{}
+		expectErr  string
+	}{
+		{targetPath: "enabled", value: "true", expect: "true"},
+		{targetPath: "enabled", value: "null", expect: nil},
+		{targetPath: "enabled", value: "NULL", expect: nil},
+		{targetPath: "enabled", value: "null\n", expect: "null\n"},
+		{targetPath: "enabled", value: `"null"`, expect: `"null"`},
+		{targetPath: "port", value: "8080", expect: "8080"},
+		{targetPath: "port", value: "08", expect: "08"},
+		{targetPath: "port", value: `"8080"`, expect: `"8080"`},
+		{targetPath: "port", value: " 8080\n", expect: " 8080\n"},
+		{targetPath: "enabled", value: "true", typed: true, expect: true},
+		{targetPath: "enabled", value: "false", typed: true, expect: false},
+		{targetPath: "enabled", value: "True", typed: true, expect: true},
+		{targetPath: "enabled", value: "yes", typed: true, expect: "yes"},
+		{targetPath: "enabled", value: "null", typed: true, expect: nil},
+		{targetPath: "port", value: "8080", typed: true, expect: int64(8080)},
+		{targetPath: "port", value: "-1", typed: true, expect: int64(-1)},
+		{targetPath: "port", value: "0", typed: true, expect: int64(0)},
+		{targetPath: "mode", value: "0755", typed: true, expect: "0755"},
+		{targetPath: "port", value: "08", typed: true, expect: "08"},
+		{targetPath: "ratio", value: "1.5", typed: true, expect: "1.5"},
+		{targetPath: "port", value: `"8080"`, typed: true, expect: `"8080"`},
+		{targetPath: "enabled", value: "'true'", typed: true, expect: "'true'"},
+		{targetPath: "port", value: " 8080", typed: true, expect: " 8080"},
+		{targetPath: "port!int", value: "08", typed: true, expect: int64(8)},
+		{targetPath: "port!int", value: "8080\n", typed: true, expect: int64(8080)},
+		{targetPath: "port!str", value: "8080", typed: true, expect: "8080"},
+		{targetPath: "port!str", value: `"8080"`, typed: true, expect: `"8080"`},
+		{targetPath: "enabled!str", value: "true", typed: true, expect: "true"},
+		{targetPath: "enabled!str", value: "null", typed: true, expect: "null"},
+		{targetPath: "enabled!bool", value: "TRUE", typed: true, expect: true},
+		{targetPath: "port!int", value: `"8080"`, typed: true, expectErr: "value of type marker !int is no integer"},
+		{targetPath: "port!int", value: "1.5", typed: true, expectErr: "value of type marker !int is no integer"},
+		{targetPath: "enabled!bool", value: "yes", typed: true, expectErr: "value of type marker !bool is no boolean"},
+		{targetPath: "port!float", value: "1.5", typed: true, expectErr: "unknown type marker `!float`, supported markers are !str, !int, !bool"},
+		{targetPath: "port!int", value: "8080", expectErr: "invalid target path"},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s=%s", tt.targetPath, tt.value), func(t *testing.T) {
+			g := NewWithT(t)
+
+			values := chartutil.Values{}
+			err := setTargetPath(values, tt.targetPath, tt.value, tt.typed)
+			if tt.expectErr != "" {
+				g.Expect(err).To(MatchError(ContainSubstring(tt.expectErr)))
+				return
+			}
+
+			g.Expect(err).ToNot(HaveOccurred())
+			key, _, _ := strings.Cut(tt.targetPath, "!")
+			g.Expect(values).To(HaveKey(key))
+			if tt.expect == nil {
+				g.Expect(values[key]).To(BeNil())
+				return
+			}
+
+			g.Expect(values[key]).To(Equal(tt.expect))
+		})
+	}
+}
+
+func TestComposeValuesFrom(t *testing.T) {
+	tests := []struct {
+		name         string
+		dataKey      string
+		optional     bool
+		targetPath   string
+		typed        bool
+		value        string
+		limits       *DocumentLimits
+		expectValues map[string]interface{}
+		expectErr    string
+	}{
+		{
+			name:         "values document",
+			value:        "a: b",
+			expectValues: map[string]interface{}{"a": "b"},
+		},
+		{
+			name:         "target path",
+			targetPath:   "a.b[1]",
+			value:        "1",
+			expectValues: map[string]interface{}{"a": map[string]interface{}{"b": []interface{}{nil, "1"}}},
+		},
+		{
+			name:         "typed value at target path",
+			targetPath:   "a.b[1]",
+			typed:        true,
+			value:        "1",
+			expectValues: map[string]interface{}{"a": map[string]interface{}{"b": []interface{}{nil, int64(1)}}},
+		},
+		{
+			name:         "quoted value at target path",
+			targetPath:   "a",
+			value:        "'1'",
+			expectValues: map[string]interface{}{"a": "'1'"},
+		},
+		{
+			name:         "comma separated list at target path",
+			targetPath:   "ingress.hosts",
+			value:        "a.example.com,b.example.com",
+			expectValues: map[string]interface{}{"ingress": map[string]interface{}{"hosts": "a.example.com,b.example.com"}},
+		},
+		{
+			name:         "JSON at target path",
+			targetPath:   "config",
+			typed:        true,
+			value:        `{"a": [1, 2], "b": {"c": "d,e"}}`,
+			expectValues: map[string]interface{}{"config": `{"a": [1, 2], "b": {"c": "d,e"}}`},
+		},
+		{
+			name:       "target path index out of bounds",
+			targetPath: "a[99999999]",
+			value:      "1",
+			expectErr:  "index of 99999999 is greater than maximum supported index",
+		},
+		{
+			name:      "values exceeding the document limits",
+			value:     "a: {b: {c: d}}",
+			limits:    &DocumentLimits{MaxDepth: 2},
+			expectErr: "document depth of 4 exceeds the maximum of 2",
+		},
+		{
+			name:      "invalid values document",
+			value:     "a: b: c\nd: e",
+			expectErr: "value for key 'values.yaml' in ConfigMap 'default/values' is not valid YAML (12 bytes starting with 'a: b: c')",
+		},
+		{
+			name:      "missing key",
+			dataKey:   "other.yaml",
+			value:     "a: b",
+			expectErr: "missing key 'values.yaml' in ConfigMap 'default/values'",
+		},
+		{
+			name:         "missing key of optional reference",
+			dataKey:      "other.yaml",
+			optional:     true,
+			value:        "a: b",
+			expectValues: map[string]interface{}{},
+		},
+		{
+			name:         "missing key of optional reference at target path",
+			dataKey:      "other.yaml",
+			optional:     true,
+			targetPath:   "a",
+			value:        "b",
+			expectValues: map[string]interface{}{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			dataKey := tt.dataKey
+			if dataKey == "" {
+				dataKey = "values.yaml"
+			}
+
+			cm, err := yaml.Marshal(corev1.ConfigMap{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+				ObjectMeta: metav1.ObjectMeta{Name: "values", Namespace: "default"},
+				Data:       map[string]string{dataKey: tt.value},
+			})
+			g.Expect(err).ToNot(HaveOccurred())
+
+			cache, err := cachemgr.New("none", "")
+			g.Expect(err).ToNot(HaveOccurred())
+			h := NewHelmBuilder(logr.Discard(), HelmOpts{
+				Cache:           cache,
+				DocumentLimits:  tt.limits,
+				TargetPathTypes: tt.typed,
+			})
+
+			hr := helmv2.HelmRelease{
+				ObjectMeta: metav1.ObjectMeta{Name: "release", Namespace: "default"},
+				Spec: helmv2.HelmReleaseSpec{
+					ValuesFrom: []helmv2.ValuesReference{
+						{Kind: "ConfigMap", Name: "values", TargetPath: tt.targetPath, Optional: tt.optional},
+					},
+				},
+			}
+
+			values, err := h.composeValues(context.Background(), newResourceIndex(t, string(cm)), hr)
+			if tt.expectErr != "" {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tt.expectErr))
+				return
+			}
+
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(map[string]interface{}(values)).To(Equal(tt.expectValues))
+		})
+	}
+}
+
+func TestComposeValuesNull(t *testing.T) {
+	g := NewWithT(t)
+
+	db := newResourceIndex(t, `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: base
+  namespace: default
+data:
+  values.yaml: |
+    image:
+      pullPolicy: null
+    resources: null
+    nodeSelector: null
+    hosts: [null, b.example.com]
+    redis:
+      auth:
+        password: null
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: replicas
+  namespace: default
+data:
+  replicas: "null"
+`)
+
+	hr := helmv2.HelmRelease{
+		ObjectMeta: metav1.ObjectMeta{Name: "release", Namespace: "default"},
+		Spec: helmv2.HelmReleaseSpec{
+			ValuesFrom: []helmv2.ValuesReference{
+				{Kind: "ConfigMap", Name: "base"},
+				{Kind: "ConfigMap", Name: "replicas", ValuesKey: "replicas", TargetPath: "replicas"},
+			},
+			Values: &apiextensionsv1.JSON{Raw: []byte(`{"image": {"tag": null}, "nodeSelector": {"zone": "a"}, "redis": {"persistence": null}}`)},
+		},
+	}
+
+	h := NewHelmBuilder(logr.Discard(), HelmOpts{})
+	values, err := h.composeValues(context.Background(), db, hr)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// helm-controller merges the valuesFrom documents and spec.values by transform.MergeMaps
+	base, err := chartutil.ReadValues([]byte(db[ref{GroupKind: schema.GroupKind{Kind: "ConfigMap"}, Name: "base", Namespace: "default"}].GetDataMap()["values.yaml"]))
+	g.Expect(err).ToNot(HaveOccurred())
+	controllerValues := chartutil.Values(transform.MergeMaps(chartutil.Values{}, base))
+	g.Expect(setTargetPathController(controllerValues, "replicas", "null")).To(Succeed())
+	controllerValues = transform.MergeMaps(controllerValues, hr.GetValues())
+	g.Expect(values).To(Equal(controllerValues))
+
+	redis := &helmchart.Chart{
+		Metadata: &helmchart.Metadata{APIVersion: helmchart.APIVersionV2, Name: "redis", Version: "1.0.0"},
+		Values: map[string]interface{}{
+			"auth":        map[string]interface{}{"password": "secret", "username": "default"},
+			"persistence": map[string]interface{}{"enabled": true},
+		},
+	}
+	podinfo := &helmchart.Chart{
+		Metadata: &helmchart.Metadata{APIVersion: helmchart.APIVersionV2, Name: "podinfo", Version: "1.0.0"},
+		Values: map[string]interface{}{
+			"replicas":     int64(2),
+			"image":        map[string]interface{}{"repository": "podinfo", "tag": "6.0.0", "pullPolicy": "IfNotPresent"},
+			"resources":    map[string]interface{}{"limits": map[string]interface{}{"cpu": "100m"}},
+			"nodeSelector": map[string]interface{}{"disk": "ssd"},
+			"hosts":        []interface{}{"a.example.com"},
+		},
+	}
+	podinfo.AddDependency(redis)
+
+	coalesced, err := chartutil.CoalesceValues(podinfo, values)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// A top-level null deletes the default, also if it is set at a target path
+	g.Expect(coalesced).ToNot(HaveKey("replicas"))
+	g.Expect(coalesced).ToNot(HaveKey("resources"))
+	// A nested null deletes the nested default, from valuesFrom as well as from spec.values
+	g.Expect(coalesced["image"]).To(Equal(map[string]interface{}{"repository": "podinfo"}))
+	// A null of a valuesFrom document is overridden by spec.values, the defaults are merged again
+	g.Expect(coalesced["nodeSelector"]).To(Equal(map[string]interface{}{"disk": "ssd", "zone": "a"}))
+	// Lists are replaced as a whole, null items are kept
+	g.Expect(coalesced["hosts"]).To(Equal([]interface{}{nil, "b.example.com"}))
+	// Nulls delete the defaults of subcharts
+	g.Expect(coalesced["redis"]).To(HaveKeyWithValue("auth", map[string]interface{}{"username": "default"}))
+	g.Expect(coalesced["redis"]).ToNot(HaveKey("persistence"))
+}
+
+func TestOverrideChartVersion(t *testing.T) {
+	overrides := map[string]string{
+		"apps/podinfo": "6.1.0",
+		"podinfo":      ">=6.0.0",
+		"redis":        "18.x",
+	}
+
+	tests := []struct {
+		name          string
+		release       string
+		chart         string
+		chartRef      bool
+		expectVersion string
+	}{
+		{
+			name:          "by helmrelease",
+			release:       "podinfo",
+			chart:         "podinfo",
+			expectVersion: "6.1.0",
+		},
+		{
+			name:          "by chart name",
+			release:       "frontend",
+			chart:         "podinfo",
+			expectVersion: ">=6.0.0",
+		},
+		{
+			name:          "not overridden",
+			release:       "nginx",
+			chart:         "nginx",
+			expectVersion: "1.0.0",
+		},
+		{
+			name:     "chart ref",
+			release:  "podinfo",
+			chartRef: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			hr := &helmv2.HelmRelease{
+				ObjectMeta: metav1.ObjectMeta{Name: tt.release, Namespace: "apps"},
+			}
+			if tt.chartRef {
+				hr.Spec.ChartRef = &helmv2.CrossNamespaceSourceReference{Kind: sourcev1beta2.OCIRepositoryKind, Name: "podinfo"}
+			} else {
+				hr.Spec.Chart = &helmv2.HelmChartTemplate{
+					Spec: helmv2.HelmChartTemplateSpec{Chart: tt.chart, Version: "1.0.0"},
+				}
+			}
+
+			h := NewHelmBuilder(logr.Discard(), HelmOpts{ChartVersionOverrides: overrides})
+			h.overrideChartVersion(context.Background(), hr)
+			if tt.chartRef {
+				g.Expect(hr.Spec.Chart).To(BeNil())
+				return
+			}
+
+			g.Expect(hr.Spec.Chart.Spec.Version).To(Equal(tt.expectVersion))
+		})
+	}
+}
+
+func TestDefaultKeychain(t *testing.T) {
+	tests := []struct {
+		name              string
+		repoType          string
+		provider          string
+		noDefaultKeychain bool
+		expectKeychain    bool
+	}{
+		{
+			name:           "generic oci repository",
+			repoType:       sourcev1.HelmRepositoryTypeOCI,
+			provider:       sourcev1beta2.GenericOCIProvider,
+			expectKeychain: true,
+		},
+		{
+			name:           "oci repository without provider",
+			repoType:       sourcev1.HelmRepositoryTypeOCI,
+			expectKeychain: true,
+		},
+		{
+			name:              "disabled default keychain",
+			repoType:          sourcev1.HelmRepositoryTypeOCI,
+			provider:          sourcev1beta2.GenericOCIProvider,
+			noDefaultKeychain: true,
+		},
+		{
+			name:     "http repository",
+			repoType: sourcev1.HelmRepositoryTypeDefault,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			h := NewHelmBuilder(logr.Discard(), HelmOpts{
+				NoDefaultKeychain: tt.noDefaultKeychain,
+			})
+
+			keychain := h.defaultKeychain(&sourcev1.HelmRepository{
+				Spec: sourcev1.HelmRepositorySpec{
+					Type:     tt.repoType,
+					Provider: tt.provider,
+				},
+			})
+			g.Expect(keychain != nil).To(Equal(tt.expectKeychain))
+		})
+	}
+}
+
+func TestDefaultKeychainDockerConfig(t *testing.T) {
+	g := NewWithT(t)
+
+	dir := t.TempDir()
+	auth := base64.StdEncoding.EncodeToString([]byte("user:token"))
+	g.Expect(os.WriteFile(filepath.Join(dir, "config.json"), []byte(fmt.Sprintf(`{"auths": {"ghcr.io": {"auth": "%s"}}}`, auth)), 0600)).To(Succeed())
+	t.Setenv("DOCKER_CONFIG", dir)
+
+	h := NewHelmBuilder(logr.Discard(), HelmOpts{})
+	keychain := h.defaultKeychain(&sourcev1.HelmRepository{
+		Spec: sourcev1.HelmRepositorySpec{
+			Type: sourcev1.HelmRepositoryTypeOCI,
+		},
+	})
+
+	loginOpt, err := makeLoginOption(nil, keychain, "oci://ghcr.io/org/charts")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(loginOpt).ToNot(BeNil())
+
+	loginOpt, err = makeLoginOption(nil, keychain, "oci://registry.example.com/charts")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(loginOpt).To(BeNil())
+}
+
+func TestKustomizeReleaseTempDirs(t *testing.T) {
+	tests := []struct {
+		name         string
+		keepTempDirs bool
+	}{
+		{
+			name: "removed by default",
+		},
+		{
+			name:         "kept",
+			keepTempDirs: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			tmpDir := t.TempDir()
+			t.Setenv("TMPDIR", tmpDir)
+
+			h := NewHelmBuilder(logr.Discard(), HelmOpts{
+				KeepTempDirs: tt.keepTempDirs,
+			})
+
+			hr := &helmv2.HelmRelease{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "podinfo",
+					Namespace: "apps",
+				},
+			}
+
+			rel := &release.Release{
+				Manifest: "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: podinfo\n",
+			}
+
+			m, err := h.kustomizeRelease(context.Background(), hr, rel)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(m.Resources()).To(HaveLen(1))
+
+			dirs, err := filepath.Glob(filepath.Join(tmpDir, "helmrelease*"))
+			g.Expect(err).ToNot(HaveOccurred())
+
+			if !tt.keepTempDirs {
+				g.Expect(dirs).To(BeEmpty())
+				return
+			}
+
+			g.Expect(dirs).To(HaveLen(1))
+			manifest, err := os.ReadFile(filepath.Join(dirs[0], "manifest.yaml"))
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(string(manifest)).To(Equal(rel.Manifest))
+		})
+	}
+}
+
+func TestKustomizeReleaseRemovesTempDirs(t *testing.T) {
+	g := NewWithT(t)
+
+	tmpDir := t.TempDir()
+	t.Setenv("TMPDIR", tmpDir)
+
+	baseline, err := os.ReadDir(tmpDir)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	h := NewHelmBuilder(logr.Discard(), HelmOpts{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			hr := &helmv2.HelmRelease{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      fmt.Sprintf("release-%d", i),
+					Namespace: "apps",
+				},
+			}
+
+			// Every other release fails in the kustomize step
+			manifest := fmt.Sprintf("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: release-%d\n", i)
+			if i%2 == 1 {
+				manifest = "apiVersion: v1\nkind: ConfigMap\nmetadata: [\n"
+			}
+
+			_, _ = h.kustomizeRelease(context.Background(), hr, &release.Release{Manifest: manifest})
+		}(i)
+	}
+	wg.Wait()
+
+	entries, err := os.ReadDir(tmpDir)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(entries).To(HaveLen(len(baseline)))
+}
+
+func TestBuildReusesCharts(t *testing.T) {
+	manifests := func(url, version string, replicas int) string {
+		return fmt.Sprintf(`apiVersion: source.toolkit.fluxcd.io/v1
+kind: HelmRepository
+metadata:
+  name: repo
+  namespace: default
+spec:
+  url: %s
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: values
+  namespace: default
+data:
+  values.yaml: "replicaCount: %d"
+---
+apiVersion: helm.toolkit.fluxcd.io/v2
+kind: HelmRelease
+metadata:
+  name: app
+  namespace: default
+spec:
+  chart:
+    spec:
+      chart: helmchart
+      version: "%s"
+      sourceRef:
+        kind: HelmRepository
+        name: repo
+  valuesFrom:
+  - kind: ConfigMap
+    name: values
+`, url, replicas, version)
+	}
+
+	var downloads atomic.Int32
+	server := newChartServer(t, &downloads)
+	mirror := newChartServer(t, &downloads)
+
+	tests := []struct {
+		name            string
+		reuseCharts     bool
+		rebuild         string
+		expectDownloads int32
+	}{
+		{
+			name:            "values changed",
+			reuseCharts:     true,
+			rebuild:         manifests(server.URL, "0.1.0", 3),
+			expectDownloads: 1,
+		},
+		{
+			name:            "values changed without reuse",
+			rebuild:         manifests(server.URL, "0.1.0", 3),
+			expectDownloads: 2,
+		},
+		{
+			name:            "chart version changed",
+			reuseCharts:     true,
+			rebuild:         manifests(server.URL, "0.1.x", 3),
+			expectDownloads: 2,
+		},
+		{
+			name:            "helm repository changed",
+			reuseCharts:     true,
+			rebuild:         manifests(mirror.URL, "0.1.0", 3),
+			expectDownloads: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			downloads.Store(0)
+
+			cache, err := cachemgr.New("none", "")
+			g.Expect(err).ToNot(HaveOccurred())
+
+			h := NewHelmBuilder(logr.Discard(), HelmOpts{Cache: cache, ReuseCharts: tt.reuseCharts})
+			t.Cleanup(func() { _ = h.Close() })
+
+			replicas := func(manifests string) int64 {
+				db := newResourceIndex(t, manifests)
+				hr := db[ref{GroupKind: HelmReleaseGroupKind, Name: "app", Namespace: "default"}]
+				g.Expect(hr).ToNot(BeNil())
+
+				m, err := h.Build(context.Background(), hr, db)
+				g.Expect(err).ToNot(HaveOccurred())
+
+				for _, res := range m.Resources() {
+					if res.GetKind() == "Deployment" {
+						replicas, err := res.GetFieldValue("spec.replicas")
+						g.Expect(err).ToNot(HaveOccurred())
+						return int64(replicas.(int))
+					}
+				}
+
+				t.Fatal("no deployment rendered")
+				return 0
+			}
+
+			g.Expect(replicas(manifests(server.URL, "0.1.0", 1))).To(Equal(int64(1)))
+			g.Expect(replicas(tt.rebuild)).To(Equal(int64(3)))
+			g.Expect(downloads.Load()).To(Equal(tt.expectDownloads))
+		})
+	}
+}
+
+func TestGetChartRepositoryFailureReleasesLock(t *testing.T) {
+	// A registry which is permanently unavailable, logins fail once the retries are exhausted
+	unavailable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(unavailable.Close)
+	unavailableURL, err := url.Parse(unavailable.URL)
+	NewWithT(t).Expect(err).ToNot(HaveOccurred())
+
+	retryMax := 1
+	retryBackoff := time.Millisecond
+
+	tests := []struct {
+		name        string
+		opts        HelmOpts
+		annotations map[string]string
+		spec        sourcev1.HelmRepositorySpec
+		manifests   string
+		expectErr   string
+	}{
+		{
+			name: "invalid certSecret",
+			spec: sourcev1.HelmRepositorySpec{
+				URL:           "https://charts.example.com",
+				CertSecretRef: &meta.LocalObjectReference{Name: "ca"},
+			},
+			manifests: `apiVersion: v1
+kind: Secret
+metadata:
+  name: ca
+  namespace: default
+data:
+  ca.crt: aW52YWxpZA==
+`,
+			expectErr: "failed to create TLS client config for helmrepository default/charts",
+		},
+		{
+			name: "login retries exhausted",
+			opts: HelmOpts{
+				RetryMax:     &retryMax,
+				RetryBackoff: &retryBackoff,
+			},
+			spec: sourcev1.HelmRepositorySpec{
+				URL:       "oci://" + unavailableURL.Host + "/charts",
+				Type:      sourcev1.HelmRepositoryTypeOCI,
+				Insecure:  true,
+				SecretRef: &meta.LocalObjectReference{Name: "registry"},
+			},
+			manifests: `apiVersion: v1
+kind: Secret
+metadata:
+  name: registry
+  namespace: default
+stringData:
+  username: robot
+  password: secret
+`,
+			expectErr: "failed to login to OCI registry",
+		},
+		{
+			name: "TLS policy rejection",
+			opts: HelmOpts{
+				TLSPolicy: &TLSPolicy{MinVersion: tls.VersionTLS12},
+			},
+			annotations: map[string]string{TLSMinVersionAnnotation: "1.1"},
+			spec: sourcev1.HelmRepositorySpec{
+				URL: "https://charts.example.com",
+			},
+			expectErr: "the host `charts.example.com` is not a relaxed host",
+		},
+		{
+			name: "invalid release asset repository",
+			opts: HelmOpts{
+				Extensions: true,
+			},
+			annotations: map[string]string{RepositoryTypeAnnotation: string(repository.ReleaseAssetProviderGitHub)},
+			spec: sourcev1.HelmRepositorySpec{
+				URL: "https://github.com/charts",
+			},
+			expectErr: "invalid release asset repository of helmrepository `default/charts`",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			cache, err := cachemgr.New("inmemory", "")
+			g.Expect(err).ToNot(HaveOccurred())
+
+			tt.opts.Cache = cache
+			h := NewHelmBuilder(logr.Discard(), tt.opts)
+
+			repo := &sourcev1.HelmRepository{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "charts",
+					Namespace:   "default",
+					Annotations: tt.annotations,
+				},
+				Spec: tt.spec,
+			}
+
+			var db ResourceIndex
+			if tt.manifests != "" {
+				db = newResourceIndex(t, tt.manifests)
+			}
+
+			// Both releases use the same repository, the second one must not wait for the lock of the failed first one
+			errs := make(chan error)
+			go func() {
+				for _, name := range []string{"first", "second"} {
+					hr := helmv2.HelmRelease{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      name,
+							Namespace: "default",
+						},
+						Spec: helmv2.HelmReleaseSpec{
+							Chart: &helmv2.HelmChartTemplate{
+								Spec: helmv2.HelmChartTemplateSpec{
+									Chart:   "helmchart",
+									Version: "0.1.0",
+								},
+							},
+						},
+					}
+					errs <- h.buildChart(context.Background(), repo, hr, nil, &chart.Build{}, db)
+				}
+			}()
+
+			for range 2 {
+				var err error
+				g.Eventually(errs, 10*time.Second).Should(Receive(&err))
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tt.expectErr))
+			}
+		})
+	}
+}
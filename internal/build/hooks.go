@@ -0,0 +1,141 @@
+package build
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"helm.sh/helm/v3/pkg/release"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+// hookEvents are the hook events known to Helm 3, test-success is the Helm 2 alias of test.
+var hookEvents = map[string]release.HookEvent{
+	release.HookPreInstall.String():   release.HookPreInstall,
+	release.HookPostInstall.String():  release.HookPostInstall,
+	release.HookPreDelete.String():    release.HookPreDelete,
+	release.HookPostDelete.String():   release.HookPostDelete,
+	release.HookPreUpgrade.String():   release.HookPreUpgrade,
+	release.HookPostUpgrade.String():  release.HookPostUpgrade,
+	release.HookPreRollback.String():  release.HookPreRollback,
+	release.HookPostRollback.String(): release.HookPostRollback,
+	release.HookTest.String():         release.HookTest,
+	"test-success":                    release.HookTest,
+}
+
+// unsafeFileChars are replaced in the file names of hooks.
+var unsafeFileChars = regexp.MustCompile(`[^a-zA-Z0-9._+-]+`)
+
+// ParseHookEvents parses hook event names like pre-install or test.
+func ParseHookEvents(names []string) ([]release.HookEvent, error) {
+	var events []release.HookEvent
+	for _, name := range names {
+		event, ok := hookEvents[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown helm hook event `%s`", name)
+		}
+
+		events = append(events, event)
+	}
+
+	return events, nil
+}
+
+// includeHooks returns true if any hooks are written to the output.
+func (h *Helm) includeHooks() bool {
+	return h.opts.IncludeHelmHooks || len(h.opts.HelmHookTypes) > 0
+}
+
+// includeHook returns true if the hook has any of the selected events, all hooks are included if no events are selected.
+func (h *Helm) includeHook(hook *release.Hook) bool {
+	if len(h.opts.HelmHookTypes) == 0 {
+		return true
+	}
+
+	for _, event := range hook.Events {
+		if slices.Contains(h.opts.HelmHookTypes, event) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// writeHooks writes the selected hooks into dir of fsys. The files are named by kind, resource name and hook events
+// which keeps the output stable across renders as Helm doesn't guarantee the order of the hooks.
+func (h *Helm) writeHooks(fsys filesys.FileSystem, dir string, hr *helmv2.HelmRelease, hooks []*release.Hook) error {
+	var selected []*release.Hook
+	for _, hook := range hooks {
+		if h.includeHook(hook) {
+			selected = append(selected, hook)
+		}
+	}
+
+	sort.SliceStable(selected, func(i, j int) bool {
+		a, b := selected[i], selected[j]
+		if a.Weight != b.Weight {
+			return a.Weight < b.Weight
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		if hookFileEvents(a) != hookFileEvents(b) {
+			return hookFileEvents(a) < hookFileEvents(b)
+		}
+		return a.Manifest < b.Manifest
+	})
+
+	names := make(map[string]int)
+	for _, hook := range selected {
+		names[hookFileName(hook)]++
+	}
+
+	written := make(map[string]bool)
+	for _, hook := range selected {
+		if err := h.opts.DocumentLimits.Check([]byte(hook.Manifest)); err != nil {
+			return fmt.Errorf("invalid hook `%s` rendered for helmrelease `%s/%s`: %w", hook.Name, hr.GetNamespace(), hr.GetName(), err)
+		}
+
+		// Colliding hooks are told apart by their manifest, identical manifests by their position in the sorted hooks
+		name := hookFileName(hook)
+		if names[name] > 1 {
+			sum := sha256.Sum256([]byte(hook.Manifest))
+			name = fmt.Sprintf("%s_%s", name, hex.EncodeToString(sum[:])[:8])
+		}
+
+		file := name + ".yaml"
+		for i := 1; written[file]; i++ {
+			file = fmt.Sprintf("%s_%d.yaml", name, i)
+		}
+		written[file] = true
+
+		if err := fsys.WriteFile(filepath.Join(dir, file), []byte(hook.Manifest)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// hookFileName returns the file name of a hook without extension, for instance hook_job_db-migrate_pre-install.
+func hookFileName(hook *release.Hook) string {
+	return unsafeFileChars.ReplaceAllString(fmt.Sprintf("hook_%s_%s_%s", strings.ToLower(hook.Kind), hook.Name, hookFileEvents(hook)), "_")
+}
+
+func hookFileEvents(hook *release.Hook) string {
+	events := make([]string, 0, len(hook.Events))
+	for _, event := range hook.Events {
+		events = append(events, event.String())
+	}
+
+	return strings.Join(events, "+")
+}
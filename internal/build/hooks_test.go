@@ -0,0 +1,140 @@
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"github.com/go-logr/logr"
+	. "github.com/onsi/gomega"
+	"helm.sh/helm/v3/pkg/release"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+func TestParseHookEvents(t *testing.T) {
+	g := NewWithT(t)
+
+	events, err := ParseHookEvents([]string{"pre-install", " post-upgrade", "test-success"})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(events).To(Equal([]release.HookEvent{release.HookPreInstall, release.HookPostUpgrade, release.HookTest}))
+
+	_, err = ParseHookEvents([]string{"crd-install"})
+	g.Expect(err).To(MatchError("unknown helm hook event `crd-install`"))
+}
+
+func TestWriteHooks(t *testing.T) {
+	hooks := []*release.Hook{
+		{
+			Name:     "migrate",
+			Kind:     "Job",
+			Events:   []release.HookEvent{release.HookPreInstall, release.HookPreUpgrade},
+			Manifest: "kind: Job\nmetadata:\n  name: migrate\n",
+		},
+		{
+			Name:     "release-test-connection",
+			Kind:     "Pod",
+			Events:   []release.HookEvent{release.HookTest},
+			Manifest: "kind: Pod\nmetadata:\n  name: release-test-connection\n",
+		},
+		{
+			Name:     "cleanup",
+			Kind:     "Job",
+			Events:   []release.HookEvent{release.HookPostDelete},
+			Manifest: "kind: Job\nmetadata:\n  name: cleanup\n",
+		},
+		{
+			Name:     "cleanup",
+			Kind:     "Job",
+			Events:   []release.HookEvent{release.HookPostDelete},
+			Manifest: "kind: Job\nmetadata:\n  name: cleanup\n  namespace: other\n",
+		},
+	}
+
+	tests := []struct {
+		name        string
+		types       []release.HookEvent
+		expectFiles []string
+	}{
+		{
+			name: "all hooks",
+			expectFiles: []string{
+				"hook_job_cleanup_post-delete_32523c55.yaml",
+				"hook_job_cleanup_post-delete_5e53fc01.yaml",
+				"hook_job_migrate_pre-install+pre-upgrade.yaml",
+				"hook_pod_release-test-connection_test.yaml",
+			},
+		},
+		{
+			name:  "install and upgrade hooks without tests",
+			types: []release.HookEvent{release.HookPreUpgrade, release.HookPostInstall},
+			expectFiles: []string{
+				"hook_job_migrate_pre-install+pre-upgrade.yaml",
+			},
+		},
+		{
+			name:  "test hooks",
+			types: []release.HookEvent{release.HookTest},
+			expectFiles: []string{
+				"hook_pod_release-test-connection_test.yaml",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			h := NewHelmBuilder(logr.Discard(), HelmOpts{
+				HelmHookTypes: tt.types,
+			})
+
+			dir := t.TempDir()
+			g.Expect(h.writeHooks(filesys.MakeFsOnDisk(), dir, &helmv2.HelmRelease{}, hooks)).To(Succeed())
+
+			entries, err := os.ReadDir(dir)
+			g.Expect(err).ToNot(HaveOccurred())
+			var files []string
+			for _, entry := range entries {
+				files = append(files, entry.Name())
+			}
+			g.Expect(files).To(Equal(tt.expectFiles))
+		})
+	}
+}
+
+func TestWriteHooksStable(t *testing.T) {
+	g := NewWithT(t)
+
+	hooks := []*release.Hook{
+		{Name: "migrate", Kind: "Job", Weight: 5, Events: []release.HookEvent{release.HookPreInstall}, Manifest: "kind: Job\nmetadata:\n  name: migrate\n"},
+		{Name: "config", Kind: "ConfigMap", Weight: -5, Events: []release.HookEvent{release.HookPreInstall}, Manifest: "kind: ConfigMap\nmetadata:\n  name: config\n"},
+		{Name: "cleanup", Kind: "Job", Events: []release.HookEvent{release.HookPostDelete}, Manifest: "kind: Job\nmetadata:\n  name: cleanup\n"},
+		{Name: "cleanup", Kind: "Job", Events: []release.HookEvent{release.HookPostDelete}, Manifest: "kind: Job\nmetadata:\n  name: cleanup\n"},
+		{Name: "cleanup", Kind: "Job", Events: []release.HookEvent{release.HookPostDelete}, Manifest: "kind: Job\nmetadata:\n  name: cleanup\n  namespace: other\n"},
+	}
+
+	read := func(hooks []*release.Hook) map[string]string {
+		h := NewHelmBuilder(logr.Discard(), HelmOpts{})
+		dir := t.TempDir()
+		g.Expect(h.writeHooks(filesys.MakeFsOnDisk(), dir, &helmv2.HelmRelease{}, hooks)).To(Succeed())
+
+		entries, err := os.ReadDir(dir)
+		g.Expect(err).ToNot(HaveOccurred())
+
+		files := make(map[string]string)
+		for _, entry := range entries {
+			b, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+			g.Expect(err).ToNot(HaveOccurred())
+			files[entry.Name()] = string(b)
+		}
+		return files
+	}
+
+	files := read(hooks)
+	g.Expect(files).To(HaveLen(5))
+
+	reversed := slices.Clone(hooks)
+	slices.Reverse(reversed)
+	g.Expect(read(reversed)).To(Equal(files))
+}
@@ -9,21 +9,12 @@ type ResourceIndex map[ref]*resource.Resource
 
 func (r ResourceIndex) Push(resources []*resource.Resource) error {
 	for _, resource := range resources {
-		resMeta, err := resource.RNode.GetMeta()
+		key, err := refOf(resource)
 		if err != nil {
 			return err
 		}
 
-		gvk := schema.FromAPIVersionAndKind(resMeta.APIVersion, resMeta.Kind)
-
-		r[ref{
-			GroupKind: schema.GroupKind{
-				Group: gvk.Group,
-				Kind:  gvk.Kind,
-			},
-			Name:      resMeta.Name,
-			Namespace: resMeta.Namespace,
-		}] = resource
+		r[key] = resource
 	}
 
 	return nil
@@ -34,3 +25,21 @@ type ref struct {
 	Name      string
 	Namespace string
 }
+
+func refOf(resource *resource.Resource) (ref, error) {
+	resMeta, err := resource.RNode.GetMeta()
+	if err != nil {
+		return ref{}, err
+	}
+
+	gvk := schema.FromAPIVersionAndKind(resMeta.APIVersion, resMeta.Kind)
+
+	return ref{
+		GroupKind: schema.GroupKind{
+			Group: gvk.Group,
+			Kind:  gvk.Kind,
+		},
+		Name:      resMeta.Name,
+		Namespace: resMeta.Namespace,
+	}, nil
+}
@@ -1,13 +1,17 @@
 package build
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
 
+	"github.com/doodlescheduling/flux-build/internal/helm/postrenderer"
 	"sigs.k8s.io/kustomize/api/konfig"
 	"sigs.k8s.io/kustomize/api/krusty"
 	"sigs.k8s.io/kustomize/api/provider"
@@ -15,14 +19,17 @@ import (
 	"sigs.k8s.io/kustomize/api/resource"
 	kustypes "sigs.k8s.io/kustomize/api/types"
 	"sigs.k8s.io/kustomize/kyaml/filesys"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
 	"sigs.k8s.io/yaml"
 )
 
 var kustomizeBuildMutex sync.Mutex
 
+// Kustomize builds the kustomization at path on disk. A kustomization is generated for directories without one,
+// path may also be a single manifest, see ReadResources for stdin.
 func Kustomize(ctx context.Context, path string) (resmap.ResMap, error) {
 	kfile := filepath.Join(path, konfig.DefaultKustomizationFileName())
-	fs := filesys.MakeFsOnDisk()
 
 	_, err := os.Stat(kfile)
 	if err != nil {
@@ -31,28 +38,7 @@ func Kustomize(ctx context.Context, path string) (resmap.ResMap, error) {
 			return nil, err
 		}
 
-		if path == "/dev/stdin" || path == "-" {
-			d, err := os.MkdirTemp(os.TempDir(), "")
-			if err != nil {
-				return nil, err
-			}
-
-			f, err := os.OpenFile(filepath.Join(d, "stdin.yaml"), os.O_CREATE|os.O_RDWR, 0644)
-			if err != nil {
-				return nil, err
-			}
-
-			_, err = io.Copy(f, os.Stdin)
-			if err != nil {
-				return nil, err
-			}
-
-			path = d
-
-			defer func() {
-				_ = os.RemoveAll(d)
-			}()
-		} else if !stat.IsDir() {
+		if !stat.IsDir() {
 			d, err := os.MkdirTemp(os.TempDir(), "")
 			if err != nil {
 				return nil, err
@@ -73,13 +59,24 @@ func Kustomize(ctx context.Context, path string) (resmap.ResMap, error) {
 				_ = os.RemoveAll(d)
 			}()
 		}
+	}
+
+	return KustomizeFS(ctx, filesys.MakeFsOnDisk(), path)
+}
 
+// KustomizeFS builds the kustomization of the directory path of fsys, for instance of an in-memory filesystem.
+// A kustomization is generated if the directory has none and removed once the build finished.
+func KustomizeFS(ctx context.Context, fsys filesys.FileSystem, path string) (resmap.ResMap, error) {
+	kfile := filepath.Join(path, konfig.DefaultKustomizationFileName())
+	fs := newJSONListFs(fsys, documentLimitsFrom(ctx))
+
+	if !fsys.Exists(kfile) {
 		defer func() {
-			_ = os.Remove(kfile)
+			_ = fsys.RemoveAll(kfile)
 		}()
 
 		pvd := provider.NewDefaultDepProvider()
-		err = createKustomization(path, fs, pvd.GetResourceFactory())
+		err := createKustomization(path, fs, pvd.GetResourceFactory())
 		if err != nil {
 			return nil, fmt.Errorf("failed create kustomization: %w", err)
 		}
@@ -95,10 +92,16 @@ func Kustomize(ctx context.Context, path string) (resmap.ResMap, error) {
 	defer kustomizeBuildMutex.Unlock()
 
 	kustomizer := krusty.MakeKustomizer(buildOptions)
-	return kustomizer.Run(fs, path)
+	m, err := kustomizer.Run(fs, path)
+	if err != nil {
+		return nil, err
+	}
+
+	restoreListKinds(m.Resources())
+	return m, nil
 }
 
-func createKustomization(path string, fSys filesys.FileSystem, rf *resource.Factory) error {
+func createKustomization(path string, fSys jsonListFs, rf *resource.Factory) error {
 	kfile := filepath.Join(path, konfig.DefaultKustomizationFileName())
 	kus := kustypes.Kustomization{
 		TypeMeta: kustypes.TypeMeta{
@@ -119,10 +122,10 @@ func createKustomization(path string, fSys filesys.FileSystem, rf *resource.Fact
 		return err
 	}
 
-	return os.WriteFile(kfile, kd, os.ModePerm)
+	return fSys.WriteFile(kfile, kd)
 }
 
-func detectResources(fSys filesys.FileSystem, rf *resource.Factory, base string, recursive bool) ([]string, error) {
+func detectResources(fSys jsonListFs, rf *resource.Factory, base string, recursive bool) ([]string, error) {
 	var paths []string
 
 	err := fSys.Walk(base, func(path string, info os.FileInfo, err error) error {
@@ -153,7 +156,7 @@ func detectResources(fSys filesys.FileSystem, rf *resource.Factory, base string,
 			}
 			return nil
 		}
-		fContents, err := fSys.ReadFile(path)
+		fContents, err := fSys.ReadResource(path)
 		if err != nil {
 			return err
 		}
@@ -166,3 +169,276 @@ func detectResources(fSys filesys.FileSystem, rf *resource.Factory, base string,
 
 	return paths, err
 }
+
+// fileRole is the way kustomize loads a file named by a kustomization.
+type fileRole int
+
+const (
+	// roleResource files hold manifests, for instance the files of resources.
+	roleResource fileRole = iota + 1
+	// roleConfig files configure kustomize, for instance patches, replacements and configurations.
+	roleConfig
+)
+
+// jsonListFs is a filesystem which exposes JSON manifests holding an array of objects as v1 List.
+// Kustomize expects a single object per JSON document, the List is unwrapped into its items by the resource factory.
+// Only the resources of the kustomizations read so far are exposed as List. The kinds ending with List of the
+// kustomizations and the files they name are preserved, see preserveListKinds, other files like the sources of
+// generators are read as is. Every file read is checked against the limits.
+type jsonListFs struct {
+	filesys.FileSystem
+	limits DocumentLimits
+	roles  *sync.Map
+}
+
+func newJSONListFs(fsys filesys.FileSystem, limits DocumentLimits) jsonListFs {
+	return jsonListFs{FileSystem: fsys, limits: limits, roles: &sync.Map{}}
+}
+
+func (fs jsonListFs) ReadFile(path string) ([]byte, error) {
+	b, err := fs.read(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if slices.Contains(konfig.RecognizedKustomizationFileNames(), filepath.Base(path)) {
+		fs.addRoles(filepath.Dir(path), b)
+		return preserveConfigListKinds(b), nil
+	}
+
+	role, _ := fs.roles.Load(filepath.Clean(path))
+	switch role {
+	case roleResource:
+		return fs.resource(path, b)
+	case roleConfig:
+		return preserveConfigListKinds(b), nil
+	}
+
+	return b, nil
+}
+
+// ReadResource reads the manifests of the file at path, a JSON array of objects is exposed as v1 List.
+func (fs jsonListFs) ReadResource(path string) ([]byte, error) {
+	b, err := fs.read(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return fs.resource(path, b)
+}
+
+func (fs jsonListFs) read(path string) ([]byte, error) {
+	b, err := fs.FileSystem.ReadFile(path)
+	if err != nil {
+		return b, err
+	}
+
+	// kyaml recurses infinitely on cyclic aliases, the alias checks apply even if no limits are set
+	if err := fs.limits.Check(b); err != nil {
+		return nil, fmt.Errorf("invalid manifest %s: %w", path, err)
+	}
+
+	return b, nil
+}
+
+func (fs jsonListFs) resource(path string, b []byte) ([]byte, error) {
+	if !strings.EqualFold(filepath.Ext(path), ".json") {
+		return preserveListKinds(b), nil
+	}
+
+	trimmed := bytes.TrimSpace(b)
+	if len(trimmed) == 0 || trimmed[0] != '[' {
+		return preserveListKinds(b), nil
+	}
+
+	var items []json.RawMessage
+	if err := json.Unmarshal(trimmed, &items); err != nil {
+		return nil, fmt.Errorf("failed to decode json manifest %s: %w", path, err)
+	}
+
+	b, err := json.Marshal(map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "List",
+		"items":      items,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return preserveListKinds(b), nil
+}
+
+// addRoles records the roles of the files the kustomization in dir names. Kustomize resolves them relative to dir
+// before reading them. Invalid kustomizations are reported by kustomize.
+func (fs jsonListFs) addRoles(dir string, b []byte) {
+	var kus kustypes.Kustomization
+	if err := yaml.Unmarshal(b, &kus); err != nil {
+		return
+	}
+
+	add := func(role fileRole, paths ...string) {
+		for _, path := range paths {
+			if path != "" {
+				fs.roles.Store(filepath.Join(dir, path), role)
+			}
+		}
+	}
+
+	add(roleResource, kus.Resources...)
+	add(roleResource, kus.Bases...)
+
+	for _, patch := range kus.PatchesStrategicMerge {
+		add(roleConfig, string(patch))
+	}
+	for _, patch := range append(kus.Patches, kus.PatchesJson6902...) {
+		add(roleConfig, patch.Path)
+	}
+	for _, replacement := range kus.Replacements {
+		add(roleConfig, replacement.Path)
+	}
+	add(roleConfig, kus.Configurations...)
+	add(roleConfig, kus.Generators...)
+	add(roleConfig, kus.Transformers...)
+	add(roleConfig, kus.Validators...)
+}
+
+// preservedKindSuffix is appended to the kind of objects which end with List but are no list while kustomize builds
+// them. Kustomize unwraps every kind ending with List into its items and silently drops it if it has none, for instance
+// a custom IPAllowList.
+const preservedKindSuffix = ".flux-build-preserved"
+
+// preserveListKinds suffixes the kind of all objects of the manifests which end with List but are no list, see
+// postrenderer.IsList. Files which contain none of them are returned as is.
+func preserveListKinds(b []byte) []byte {
+	return preserveDocuments(b, preserveListKind)
+}
+
+// preserveConfigListKinds suffixes the kinds of a kustomization or a file it names to configure kustomize like
+// preserveListKinds. The kinds kustomizations, replacement and transformer configuration files select objects by are
+// suffixed as well, see preserveTargetKinds.
+func preserveConfigListKinds(b []byte) []byte {
+	return preserveDocuments(b, func(node *kyaml.RNode) bool {
+		if isKustomizeConfig(node) {
+			return preserveTargetKinds(node.YNode())
+		}
+
+		return preserveListKind(node)
+	})
+}
+
+// preserveDocuments calls preserve for every document of b and re-encodes the documents if any of them was changed.
+func preserveDocuments(b []byte, preserve func(node *kyaml.RNode) bool) []byte {
+	if !bytes.Contains(b, []byte("List")) {
+		return b
+	}
+
+	nodes, err := kio.FromBytes(b)
+	if err != nil {
+		return b
+	}
+
+	var preserved bool
+	for _, node := range nodes {
+		preserved = preserve(node) || preserved
+	}
+
+	if !preserved {
+		return b
+	}
+
+	out, err := kio.StringAll(nodes)
+	if err != nil {
+		return b
+	}
+
+	return []byte(out)
+}
+
+func preserveListKind(node *kyaml.RNode) bool {
+	if node.YNode().Kind != kyaml.MappingNode || !strings.HasSuffix(node.GetKind(), "List") {
+		return false
+	}
+
+	if !postrenderer.IsList(node) {
+		if node.GetApiVersion() == "" || node.GetName() == "" {
+			return false
+		}
+
+		node.SetKind(node.GetKind() + preservedKindSuffix)
+		return true
+	}
+
+	items := node.Field("items")
+	if items == nil {
+		return false
+	}
+
+	var preserved bool
+	for _, item := range items.Value.YNode().Content {
+		preserved = preserveListKind(kyaml.NewRNode(item)) || preserved
+	}
+
+	return preserved
+}
+
+// isKustomizeConfig reports whether the document configures kustomize instead of being a resource, for instance a
+// kustomization, a component or a list of replacements.
+func isKustomizeConfig(node *kyaml.RNode) bool {
+	if node.YNode().Kind != kyaml.MappingNode {
+		return node.YNode().Kind == kyaml.SequenceNode
+	}
+
+	switch node.GetKind() {
+	case kustypes.KustomizationKind, kustypes.ComponentKind:
+		return true
+	}
+
+	return node.GetApiVersion() == ""
+}
+
+// preserveTargetKinds suffixes the kinds ending with List which the kustomize configuration of node selects objects
+// by, for instance the targets of patches and replacements or the field specs of labels. Otherwise the selectors
+// would never match the objects suffixed by preserveListKind. Inline patches are preserved like manifests.
+func preserveTargetKinds(node *kyaml.Node) bool {
+	var preserved bool
+	switch node.Kind {
+	case kyaml.SequenceNode:
+		for _, item := range node.Content {
+			preserved = preserveTargetKinds(item) || preserved
+		}
+	case kyaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			switch {
+			case value.Kind == kyaml.ScalarNode && key.Value == "kind" && strings.HasSuffix(value.Value, "List"):
+				value.Value += preservedKindSuffix
+				preserved = true
+			case value.Kind == kyaml.ScalarNode && key.Value == "patch":
+				if patch := string(preserveConfigListKinds([]byte(value.Value))); patch != value.Value {
+					value.Value = patch
+					preserved = true
+				}
+			case value.Kind == kyaml.SequenceNode && key.Value == "patchesStrategicMerge":
+				for _, item := range value.Content {
+					if patch := string(preserveConfigListKinds([]byte(item.Value))); item.Kind == kyaml.ScalarNode && patch != item.Value {
+						item.Value = patch
+						preserved = true
+					}
+				}
+			default:
+				preserved = preserveTargetKinds(value) || preserved
+			}
+		}
+	}
+
+	return preserved
+}
+
+// restoreListKinds reverts the kinds suffixed by preserveListKinds once kustomize built the resources.
+func restoreListKinds(resources []*resource.Resource) {
+	for _, r := range resources {
+		if kind, ok := strings.CutSuffix(r.GetKind(), preservedKindSuffix); ok {
+			r.SetKind(kind)
+		}
+	}
+}
@@ -0,0 +1,334 @@
+package build
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+func TestKustomizeJSONManifests(t *testing.T) {
+	tests := []struct {
+		name          string
+		files         map[string]string
+		expectObjects []string
+		expectErr     string
+	}{
+		{
+			name: "yaml helmrelease with json helmrepository array",
+			files: map[string]string{
+				"release.yaml": `apiVersion: helm.toolkit.fluxcd.io/v2
+kind: HelmRelease
+metadata:
+  name: podinfo
+  namespace: default
+`,
+				"repositories.json": `[
+  {"apiVersion": "source.toolkit.fluxcd.io/v1", "kind": "HelmRepository", "metadata": {"name": "podinfo", "namespace": "default"}},
+  {"apiVersion": "source.toolkit.fluxcd.io/v1", "kind": "HelmRepository", "metadata": {"name": "bitnami", "namespace": "default"}}
+]`,
+			},
+			expectObjects: []string{"HelmRelease/podinfo", "HelmRepository/bitnami", "HelmRepository/podinfo"},
+		},
+		{
+			name: "json single object",
+			files: map[string]string{
+				"release.yaml": `apiVersion: helm.toolkit.fluxcd.io/v2
+kind: HelmRelease
+metadata:
+  name: podinfo
+  namespace: default
+`,
+				"repository.json": `{"apiVersion": "source.toolkit.fluxcd.io/v1", "kind": "HelmRepository", "metadata": {"name": "podinfo", "namespace": "default"}}`,
+			},
+			expectObjects: []string{"HelmRelease/podinfo", "HelmRepository/podinfo"},
+		},
+		{
+			name: "cyclic alias",
+			files: map[string]string{
+				"release.yaml": `apiVersion: helm.toolkit.fluxcd.io/v2
+kind: HelmRelease
+metadata:
+  name: podinfo
+  namespace: default
+spec:
+  values: &a [*a]
+`,
+			},
+			expectErr: "document contains a cyclic alias",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			dir := t.TempDir()
+			for name, content := range tt.files {
+				g.Expect(os.WriteFile(filepath.Join(dir, name), []byte(content), 0644)).To(Succeed())
+			}
+
+			resMap, err := Kustomize(context.Background(), dir)
+			if tt.expectErr != "" {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tt.expectErr))
+				return
+			}
+
+			g.Expect(err).ToNot(HaveOccurred())
+
+			var objects []string
+			for _, r := range resMap.Resources() {
+				objects = append(objects, r.GetKind()+"/"+r.GetName())
+			}
+			sort.Strings(objects)
+			g.Expect(objects).To(Equal(tt.expectObjects))
+
+			index := make(ResourceIndex)
+			g.Expect(index.Push(resMap.Resources())).To(Succeed())
+			g.Expect(index).To(HaveLen(len(tt.expectObjects)))
+		})
+	}
+}
+
+func TestKustomizeJSONGeneratorSources(t *testing.T) {
+	g := NewWithT(t)
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"kustomization.yaml": `resources:
+- repositories.json
+configMapGenerator:
+- name: data
+  files:
+  - data.json
+patches:
+- path: labels.json
+  target:
+    kind: HelmRepository
+`,
+		"repositories.json": `[{"apiVersion": "source.toolkit.fluxcd.io/v1", "kind": "HelmRepository", "metadata": {"name": "podinfo", "namespace": "default"}}]`,
+		"data.json":         `[{"a":1}]`,
+		"labels.json":       `[{"op": "add", "path": "/metadata/labels", "value": {"app": "podinfo"}}]`,
+	}
+	for name, content := range files {
+		g.Expect(os.WriteFile(filepath.Join(dir, name), []byte(content), 0644)).To(Succeed())
+	}
+
+	resMap, err := Kustomize(context.Background(), dir)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// The generator source and the JSON patch are not exposed as List, only the resources are
+	g.Expect(resMap.Resources()).To(HaveLen(2))
+	for _, r := range resMap.Resources() {
+		switch r.GetKind() {
+		case "ConfigMap":
+			g.Expect(r.GetDataMap()).To(Equal(map[string]string{"data.json": `[{"a":1}]`}))
+		case "HelmRepository":
+			g.Expect(r.GetLabels()).To(Equal(map[string]string{"app": "podinfo"}))
+		default:
+			t.Fatalf("unexpected resource %s", r.CurId())
+		}
+	}
+}
+
+func TestKustomizeGeneratorSourcesListKinds(t *testing.T) {
+	g := NewWithT(t)
+
+	values := `# policies rendered by the chart
+policies:
+    kind: NetworkPolicyList
+    items: []
+`
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"kustomization.yaml": `configMapGenerator:
+- name: values
+  files:
+  - values.yaml
+`,
+		"values.yaml": values,
+	}
+	for name, content := range files {
+		g.Expect(os.WriteFile(filepath.Join(dir, name), []byte(content), 0644)).To(Succeed())
+	}
+
+	resMap, err := Kustomize(context.Background(), dir)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	g.Expect(resMap.Resources()).To(HaveLen(1))
+	g.Expect(resMap.Resources()[0].GetDataMap()).To(Equal(map[string]string{"values.yaml": values}))
+}
+
+// unknownKinds are custom resources of CRDs which are not part of the build, their fields are sorted the way
+// resources are encoded into the output.
+var unknownKinds = []string{
+	`apiVersion: net.example.co.uk/v1alpha1
+kind: IPAllowList
+metadata:
+  name: office
+spec:
+  cidrs:
+  - 10.0.0.0/8
+`,
+	`apiVersion: net.example.co.uk/v1alpha1
+kind: Widget
+metadata:
+  name: widget
+  namespace: apps
+spec:
+  size: 3
+`,
+	`apiVersion: net.example.co.uk/v1alpha1
+items:
+- user: a
+kind: AccessList
+metadata:
+  name: users
+`,
+	`apiVersion: net.example.co.uk/v1alpha1
+items: []
+kind: PolicyList
+metadata:
+  name: empty
+`,
+}
+
+func TestKustomizeUnknownKinds(t *testing.T) {
+	g := NewWithT(t)
+
+	dir := t.TempDir()
+	g.Expect(os.WriteFile(filepath.Join(dir, "resources.yaml"), []byte(strings.Join(unknownKinds, "---\n")), 0644)).To(Succeed())
+	g.Expect(os.WriteFile(filepath.Join(dir, "resources.json"), []byte(`[{"apiVersion": "net.example.co.uk/v1alpha1", "kind": "IPAllowList", "metadata": {"name": "json"}}]`), 0644)).To(Succeed())
+
+	resMap, err := Kustomize(context.Background(), dir)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	var manifests []string
+	for _, r := range resMap.Resources() {
+		manifests = append(manifests, r.MustYaml())
+	}
+
+	g.Expect(manifests).To(ConsistOf(append(unknownKinds, `apiVersion: net.example.co.uk/v1alpha1
+kind: IPAllowList
+metadata:
+  name: json
+`)))
+}
+
+func TestKustomizePatchesListKinds(t *testing.T) {
+	g := NewWithT(t)
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"kustomization.yaml": `apiVersion: kustomize.config.k8s.io/v1beta1
+kind: Kustomization
+resources:
+- resources.yaml
+patches:
+- target:
+    kind: IPAllowList
+    name: office
+  patch: |
+    - op: add
+      path: /spec/cidrs/-
+      value: 192.168.0.0/16
+- path: patch.yaml
+- patch: |
+    apiVersion: net.example.co.uk/v1alpha1
+    kind: IPAllowList
+    metadata:
+      name: office
+      labels:
+        inline: patched
+replacements:
+- source:
+    kind: IPAllowList
+    name: office
+    fieldPath: spec.cidrs.0
+  targets:
+  - select:
+      kind: ConfigMap
+    fieldPaths:
+    - data.cidr
+`,
+		"patch.yaml": `apiVersion: net.example.co.uk/v1alpha1
+kind: IPAllowList
+metadata:
+  name: office
+  annotations:
+    file: patched
+`,
+		"resources.yaml": `apiVersion: net.example.co.uk/v1alpha1
+kind: IPAllowList
+metadata:
+  name: office
+spec:
+  cidrs:
+  - 10.0.0.0/8
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: cidr
+data:
+  cidr: unset
+`,
+	}
+	for name, content := range files {
+		g.Expect(os.WriteFile(filepath.Join(dir, name), []byte(content), 0644)).To(Succeed())
+	}
+
+	resMap, err := Kustomize(context.Background(), dir)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	var manifests []string
+	for _, r := range resMap.Resources() {
+		manifests = append(manifests, r.MustYaml())
+	}
+
+	g.Expect(manifests).To(ConsistOf(`apiVersion: net.example.co.uk/v1alpha1
+kind: IPAllowList
+metadata:
+  annotations:
+    file: patched
+  labels:
+    inline: patched
+  name: office
+spec:
+  cidrs:
+  - 10.0.0.0/8
+  - 192.168.0.0/16
+`, `apiVersion: v1
+data:
+  cidr: 10.0.0.0/8
+kind: ConfigMap
+metadata:
+  name: cidr
+`))
+}
+
+func TestKustomizeFS(t *testing.T) {
+	g := NewWithT(t)
+
+	fsys := filesys.MakeFsInMemory()
+	g.Expect(fsys.WriteFile("/release/manifest.yaml", []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: podinfo\n"))).To(Succeed())
+	g.Expect(fsys.WriteFile("/release/hooks.json", []byte(`[{"apiVersion": "batch/v1", "kind": "Job", "metadata": {"name": "migrate"}}]`))).To(Succeed())
+
+	resMap, err := KustomizeFS(context.Background(), fsys, "/release")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	var objects []string
+	for _, r := range resMap.Resources() {
+		objects = append(objects, r.GetKind()+"/"+r.GetName())
+	}
+	g.Expect(objects).To(ConsistOf("ConfigMap/podinfo", "Job/migrate"))
+
+	// The generated kustomization is removed once built
+	g.Expect(fsys.Exists("/release/kustomization.yaml")).To(BeFalse())
+}
@@ -0,0 +1,141 @@
+package build
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// DocumentLimits bounds the size and complexity of the YAML documents handled while building a HelmRelease.
+// A zero value disables the respective limit.
+type DocumentLimits struct {
+	// MaxSize is the maximum size in bytes of a YAML stream.
+	MaxSize int
+	// MaxDepth is the maximum nesting depth of a YAML document, aliases are expanded.
+	MaxDepth int
+	// MaxSubstitutionGrowth is the maximum number of bytes the environment substitution may add to a HelmRelease.
+	MaxSubstitutionGrowth int
+}
+
+// DefaultDocumentLimits are generous enough for large charts while rejecting pathological input.
+var DefaultDocumentLimits = DocumentLimits{
+	MaxSize:               64 << 20,
+	MaxDepth:              512,
+	MaxSubstitutionGrowth: 1 << 20,
+}
+
+type documentLimitsKey struct{}
+
+// WithDocumentLimits returns a context whose plain manifests, read by Kustomize or a StreamSource, are bounded by l.
+// Without the limits only the alias checks apply to plain manifests.
+func WithDocumentLimits(ctx context.Context, l DocumentLimits) context.Context {
+	return context.WithValue(ctx, documentLimitsKey{}, l)
+}
+
+// documentLimitsFrom returns the limits of the context, the zero value if it carries none.
+func documentLimitsFrom(ctx context.Context) DocumentLimits {
+	l, _ := ctx.Value(documentLimitsKey{}).(DocumentLimits)
+	return l
+}
+
+// maxAliasExpansion is the number of nodes an aliased document may expand to beyond
+// aliasExpansionRatio times its literal node count.
+const (
+	maxAliasExpansion   = 1_000_000
+	aliasExpansionRatio = 100
+)
+
+// ErrDocumentLimit is returned if a YAML document exceeds the DocumentLimits.
+var ErrDocumentLimit = errors.New("document limit exceeded")
+
+// Check verifies that the YAML stream is within the limits.
+// Aliases are not expanded while checking, documents which expand excessively are rejected.
+// Syntax errors are ignored as they are reported by the actual decoder.
+func (l DocumentLimits) Check(b []byte) error {
+	if l.MaxSize > 0 && len(b) > l.MaxSize {
+		return fmt.Errorf("%w: document size of %d bytes exceeds the maximum of %d bytes", ErrDocumentLimit, len(b), l.MaxSize)
+	}
+
+	dec := kyaml.NewDecoder(bytes.NewReader(b))
+	for {
+		var node kyaml.Node
+		// The check ends with the stream or at the first syntax error
+		if err := dec.Decode(&node); err != nil {
+			return nil
+		}
+
+		w := &nodeWalker{
+			expanded: make(map[*kyaml.Node]nodeStats),
+			visiting: make(map[*kyaml.Node]bool),
+		}
+		stats := w.walk(&node)
+
+		if w.cyclic {
+			return fmt.Errorf("%w: document contains a cyclic alias", ErrDocumentLimit)
+		}
+
+		if l.MaxDepth > 0 && stats.depth > l.MaxDepth {
+			return fmt.Errorf("%w: document depth of %d exceeds the maximum of %d", ErrDocumentLimit, stats.depth, l.MaxDepth)
+		}
+
+		if stats.nodes > maxAliasExpansion && stats.nodes > aliasExpansionRatio*w.literal {
+			return fmt.Errorf("%w: document contains excessive aliasing", ErrDocumentLimit)
+		}
+	}
+}
+
+type nodeStats struct {
+	depth int
+	nodes int
+}
+
+// nodeWalker computes the depth and node count of a document as if aliases were expanded.
+// Aliased nodes are only walked once.
+type nodeWalker struct {
+	expanded map[*kyaml.Node]nodeStats
+	visiting map[*kyaml.Node]bool
+	literal  int
+	// cyclic is set if an alias refers to one of its parents which can't be expanded at all
+	cyclic bool
+}
+
+func (w *nodeWalker) walk(node *kyaml.Node) nodeStats {
+	if node == nil {
+		return nodeStats{}
+	}
+
+	if w.visiting[node] {
+		w.cyclic = true
+		return nodeStats{}
+	}
+
+	if stats, ok := w.expanded[node]; ok {
+		return stats
+	}
+
+	w.visiting[node] = true
+	defer delete(w.visiting, node)
+	w.literal++
+
+	stats := nodeStats{nodes: 1}
+	if node.Kind == kyaml.AliasNode {
+		alias := w.walk(node.Alias)
+		stats = nodeStats{depth: alias.depth, nodes: alias.nodes}
+	}
+
+	for _, child := range node.Content {
+		childStats := w.walk(child)
+		stats.depth = max(stats.depth, childStats.depth)
+		stats.nodes = min(stats.nodes+childStats.nodes, 1<<62)
+	}
+
+	if node.Kind != kyaml.DocumentNode && node.Kind != kyaml.AliasNode {
+		stats.depth++
+	}
+
+	w.expanded[node] = stats
+	return stats
+}
@@ -0,0 +1,113 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+func TestDocumentLimitsCheck(t *testing.T) {
+	aliasBomb := "a: &a [x, x, x, x, x, x, x, x, x, x]\n"
+	for i := 'b'; i <= 'i'; i++ {
+		aliasBomb += fmt.Sprintf("%c: &%c [*%c, *%c, *%c, *%c, *%c, *%c, *%c, *%c, *%c, *%c]\n", i, i, i-1, i-1, i-1, i-1, i-1, i-1, i-1, i-1, i-1, i-1)
+	}
+
+	tests := []struct {
+		name      string
+		limits    DocumentLimits
+		document  string
+		expectErr string
+	}{
+		{
+			name:     "within limits",
+			limits:   DefaultDocumentLimits,
+			document: "a:\n  b:\n    c: [1, 2]\n---\nd: &d {e: f}\ng: *d\n",
+		},
+		{
+			name:      "exceeds size",
+			limits:    DocumentLimits{MaxSize: 8},
+			document:  "a: bcdefghijk",
+			expectErr: "document size of 13 bytes exceeds the maximum of 8 bytes",
+		},
+		{
+			name:      "exceeds depth",
+			limits:    DocumentLimits{MaxDepth: 10},
+			document:  strings.Repeat("[", 11) + strings.Repeat("]", 11),
+			expectErr: "document depth of 11 exceeds the maximum of 10",
+		},
+		{
+			name:      "exceeds depth in a subsequent document",
+			limits:    DocumentLimits{MaxDepth: 3},
+			document:  "a: b\n---\na: {b: {c: d}}\n",
+			expectErr: "document depth of 4 exceeds the maximum of 3",
+		},
+		{
+			name:      "aliases are expanded for the depth",
+			limits:    DocumentLimits{MaxDepth: 3},
+			document:  "a: &a {b: c}\nd: {e: *a}\n",
+			expectErr: "document depth of 4 exceeds the maximum of 3",
+		},
+		{
+			name:      "excessive aliasing",
+			limits:    DocumentLimits{},
+			document:  aliasBomb,
+			expectErr: "document contains excessive aliasing",
+		},
+		{
+			name:      "cyclic alias",
+			limits:    DocumentLimits{},
+			document:  "a: &a [*a, *a]",
+			expectErr: "document contains a cyclic alias",
+		},
+		{
+			name:     "disabled limits",
+			limits:   DocumentLimits{},
+			document: strings.Repeat("[", 100) + strings.Repeat("]", 100),
+		},
+		{
+			name:     "syntax errors are left to the decoder",
+			limits:   DefaultDocumentLimits,
+			document: "a: [",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			err := tt.limits.Check([]byte(tt.document))
+			if tt.expectErr != "" {
+				g.Expect(err).To(MatchError(ErrDocumentLimit))
+				g.Expect(err.Error()).To(ContainSubstring(tt.expectErr))
+				return
+			}
+
+			g.Expect(err).ToNot(HaveOccurred())
+		})
+	}
+}
+
+func TestPlainManifestDocumentLimits(t *testing.T) {
+	g := NewWithT(t)
+
+	manifest := []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: podinfo\ndata:\n  key: " + strings.Repeat("x", 64) + "\n")
+	limits := DocumentLimits{MaxSize: 64}
+
+	fsys := filesys.MakeFsInMemory()
+	g.Expect(fsys.WriteFile("/release/manifest.yaml", manifest)).To(Succeed())
+
+	// Without limits of the context only the alias checks apply
+	_, err := KustomizeFS(context.Background(), fsys, "/release")
+	g.Expect(err).ToNot(HaveOccurred())
+	_, err = NewStreamSource("-", manifest).Load(context.Background())
+	g.Expect(err).ToNot(HaveOccurred())
+
+	ctx := WithDocumentLimits(context.Background(), limits)
+	_, err = KustomizeFS(ctx, fsys, "/release")
+	g.Expect(err).To(MatchError(ErrDocumentLimit))
+	_, err = NewStreamSource("-", manifest).Load(ctx)
+	g.Expect(err).To(MatchError(ErrDocumentLimit))
+}
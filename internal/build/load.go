@@ -0,0 +1,107 @@
+package build
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+// manifestExtensions are the extensions of the manifests LoadResources reads from directories.
+var manifestExtensions = []string{".yaml", ".yml", ".json"}
+
+// LoadResources reads the Kubernetes objects of the manifests at paths into a ResourceIndex without building them.
+// A path is either a manifest, a directory whose manifests are read recursively or a glob pattern matching any of both.
+// Documents which are no Kubernetes objects are skipped, a resource declared more than once fails naming both manifests.
+func LoadResources(paths ...string) (ResourceIndex, error) {
+	var files []string
+	for _, path := range paths {
+		matches := []string{path}
+		if hasGlobMeta(path) {
+			var err error
+			matches, err = filepath.Glob(path)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern `%s`: %w", path, err)
+			}
+
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("pattern `%s` matches no manifests", path)
+			}
+		}
+
+		for _, match := range matches {
+			manifests, err := manifestFiles(match)
+			if err != nil {
+				return nil, err
+			}
+
+			files = append(files, manifests...)
+		}
+	}
+
+	index := make(ResourceIndex)
+	sources := make(map[ref]string)
+	fsys := newJSONListFs(filesys.MakeFsOnDisk(), DocumentLimits{})
+	for _, file := range files {
+		b, err := fsys.ReadResource(file)
+		if err != nil {
+			return nil, err
+		}
+
+		resources, err := decodeObjects(b, func(int) {})
+		if err != nil {
+			return nil, fmt.Errorf("invalid manifest %s: %w", file, err)
+		}
+
+		for _, res := range resources {
+			key, err := refOf(res)
+			if err != nil {
+				return nil, fmt.Errorf("invalid manifest %s: %w", file, err)
+			}
+
+			if source, ok := sources[key]; ok {
+				return nil, fmt.Errorf("resource %s is declared in %s and %s", ResourceName(res.GetKind(), res.GetNamespace(), res.GetName()), source, file)
+			}
+
+			sources[key] = file
+			index[key] = res
+		}
+	}
+
+	return index, nil
+}
+
+// manifestFiles returns path if it is a file or the manifests within the directory path and its subdirectories.
+func manifestFiles(path string) ([]string, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !stat.IsDir() {
+		return []string{path}, nil
+	}
+
+	var files []string
+	err = filepath.WalkDir(path, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !d.IsDir() && slices.Contains(manifestExtensions, strings.ToLower(filepath.Ext(path))) {
+			files = append(files, path)
+		}
+
+		return nil
+	})
+
+	return files, err
+}
+
+func hasGlobMeta(path string) bool {
+	return strings.ContainsAny(path, `*?[`)
+}
@@ -0,0 +1,123 @@
+package build
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestLoadResources(t *testing.T) {
+	release := `apiVersion: helm.toolkit.fluxcd.io/v2
+kind: HelmRelease
+metadata:
+  name: podinfo
+  namespace: apps
+`
+	repository := `apiVersion: source.toolkit.fluxcd.io/v1
+kind: HelmRepository
+metadata:
+  name: podinfo
+  namespace: apps
+`
+
+	tests := []struct {
+		name          string
+		files         map[string]string
+		paths         []string
+		expectObjects []string
+		expectErr     string
+	}{
+		{
+			name: "recursive directory",
+			files: map[string]string{
+				"apps/release.yaml":                 release,
+				"apps/sources/repository.yml":       repository,
+				"apps/sources/values.json":          `[{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "podinfo-values", "namespace": "apps"}}]`,
+				"apps/kustomization.yaml":           "apiVersion: kustomize.config.k8s.io/v1beta1\nkind: Kustomization\nresources:\n- release.yaml\n",
+				"apps/README.md":                    "# apps",
+				"apps/config/values.yaml":           "replicas: 2\nimage:\n  tag: 6.0.0\n",
+				"apps/secrets/credentials.YAML":     "apiVersion: v1\nkind: Secret\nmetadata:\n  name: podinfo-credentials\n  namespace: apps\n",
+				"apps/secrets/credentials.yaml.bak": "not: a manifest\n",
+			},
+			paths: []string{"apps"},
+			expectObjects: []string{
+				"ConfigMap/apps/podinfo-values",
+				"HelmRelease/apps/podinfo",
+				"HelmRepository/apps/podinfo",
+				"Secret/apps/podinfo-credentials",
+			},
+		},
+		{
+			name: "glob and file",
+			files: map[string]string{
+				"releases/podinfo.yaml": release,
+				"releases/redis.yaml":   "apiVersion: helm.toolkit.fluxcd.io/v2\nkind: HelmRelease\nmetadata:\n  name: redis\n  namespace: apps\n",
+				"releases/notes.txt":    "ignored",
+				"repository.txt":        repository,
+			},
+			paths:         []string{"releases/*.yaml", "repository.txt"},
+			expectObjects: []string{"HelmRelease/apps/podinfo", "HelmRelease/apps/redis", "HelmRepository/apps/podinfo"},
+		},
+		{
+			name: "duplicate across files",
+			files: map[string]string{
+				"a/release.yaml": release,
+				"b/release.yaml": release,
+			},
+			paths:     []string{"a", "b"},
+			expectErr: "resource HelmRelease/apps/podinfo is declared in %[1]s/a/release.yaml and %[1]s/b/release.yaml",
+		},
+		{
+			name: "duplicate within a file",
+			files: map[string]string{
+				"release.yaml": release + "---\n" + release,
+			},
+			paths:     []string{"release.yaml"},
+			expectErr: "resource HelmRelease/apps/podinfo is declared in %[1]s/release.yaml and %[1]s/release.yaml",
+		},
+		{
+			name:      "glob without matches",
+			paths:     []string{"*.yaml"},
+			expectErr: "pattern `%[1]s/*.yaml` matches no manifests",
+		},
+		{
+			name:      "missing path",
+			paths:     []string{"missing"},
+			expectErr: "stat %[1]s/missing: no such file or directory",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			dir := t.TempDir()
+			for name, content := range tt.files {
+				path := filepath.Join(dir, name)
+				g.Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(Succeed())
+				g.Expect(os.WriteFile(path, []byte(content), 0644)).To(Succeed())
+			}
+
+			var paths []string
+			for _, path := range tt.paths {
+				paths = append(paths, filepath.Join(dir, path))
+			}
+
+			index, err := LoadResources(paths...)
+			if tt.expectErr != "" {
+				g.Expect(err).To(MatchError(ContainSubstring(fmt.Sprintf(tt.expectErr, dir))))
+				return
+			}
+
+			g.Expect(err).ToNot(HaveOccurred())
+
+			var objects []string
+			for _, res := range index {
+				objects = append(objects, ResourceName(res.GetKind(), res.GetNamespace(), res.GetName()))
+			}
+			g.Expect(objects).To(ConsistOf(tt.expectObjects))
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package build
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/doodlescheduling/flux-build/internal/helm/chart"
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"helm.sh/helm/v3/pkg/chart/loader"
+)
+
+// PackagedChart is a packaged chart of the built repository a HelmRelease was rendered from.
+type PackagedChart struct {
+	// Path is the resolved path of the archive.
+	Path string `json:"path"`
+	// Digest is the sha256 digest of the archive.
+	Digest string `json:"digest"`
+}
+
+type packagedChartKey struct{}
+
+// WithPackagedChart returns a context which records the packaged chart into c if the HelmRelease built with it
+// is rendered from a packaged chart of the built repository.
+func WithPackagedChart(ctx context.Context, c *PackagedChart) context.Context {
+	return context.WithValue(ctx, packagedChartKey{}, c)
+}
+
+func packagedChartFrom(ctx context.Context) *PackagedChart {
+	c, _ := ctx.Value(packagedChartKey{}).(*PackagedChart)
+	return c
+}
+
+// isLocalPackagedChart returns true if the HelmRelease references a packaged chart by a relative path within a
+// local source, the chart is then loaded from the RepositoryRoot instead of the source.
+func (h *Helm) isLocalPackagedChart(hr *helmv2.HelmRelease, namespace string) bool {
+	spec := hr.Spec.Chart.Spec
+	return strings.HasSuffix(spec.Chart, ".tgz") && !filepath.IsAbs(spec.Chart) &&
+		slices.Contains(h.opts.LocalSources, namespace+"/"+spec.SourceRef.Name)
+}
+
+// buildFromLocalPackagedChart loads the packaged chart of the HelmRelease relative to the RepositoryRoot.
+// The archive is validated and its digest recorded, values files are read from the archive like for charts of a
+// HelmRepository.
+func (h *Helm) buildFromLocalPackagedChart(ctx context.Context, hr *helmv2.HelmRelease) (*chart.Build, error) {
+	path := absPath(filepath.Join(h.opts.RepositoryRoot, strings.TrimPrefix(filepath.Clean("/"+hr.Spec.Chart.Spec.Chart), "/")))
+
+	digest, err := fileDigest(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read packaged chart %s of helmrelease `%s/%s`: %w", path, hr.GetNamespace(), hr.GetName(), err)
+	}
+
+	loadedChart, err := loader.LoadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid packaged chart %s of helmrelease `%s/%s`: %w", path, hr.GetNamespace(), hr.GetName(), err)
+	}
+
+	h.logger(ctx).Info("loaded packaged chart", "path", path, "chart", loadedChart.Name(), "version", loadedChart.Metadata.Version, "digest", digest)
+	if c := packagedChartFrom(ctx); c != nil {
+		c.Path = path
+		c.Digest = digest
+	}
+
+	return &chart.Build{
+		Name:    loadedChart.Name(),
+		Version: loadedChart.Metadata.Version,
+		Path:    path,
+		Digest:  digest,
+	}, nil
+}
+
+func fileDigest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("sha256:%x", hash.Sum(nil)), nil
+}
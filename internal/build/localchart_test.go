@@ -0,0 +1,135 @@
+package build
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"github.com/go-logr/logr"
+	. "github.com/onsi/gomega"
+	helmchart "helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestBuildFromLocalPackagedChart(t *testing.T) {
+	root := t.TempDir()
+	g := NewWithT(t)
+
+	appChart := &helmchart.Chart{
+		Metadata: &helmchart.Metadata{APIVersion: helmchart.APIVersionV2, Name: "app", Version: "1.2.3"},
+		Templates: []*helmchart.File{
+			{Name: "templates/configmap.yaml", Data: []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: app\ndata:\n  replicas: {{ .Values.replicas | quote }}\n")},
+		},
+		Raw: []*helmchart.File{
+			{Name: "values.yaml", Data: []byte("replicas: 1\n")},
+		},
+		Files: []*helmchart.File{
+			{Name: "values-prod.yaml", Data: []byte("replicas: 3\n")},
+		},
+	}
+
+	g.Expect(os.MkdirAll(filepath.Join(root, "charts"), 0755)).To(Succeed())
+	path, err := chartutil.Save(appChart, filepath.Join(root, "charts"))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(os.WriteFile(filepath.Join(root, "charts/corrupt-1.0.0.tgz"), []byte("not a chart"), 0644)).To(Succeed())
+
+	archive, err := os.ReadFile(path)
+	g.Expect(err).ToNot(HaveOccurred())
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(archive))
+
+	// The GitRepository is never checked out, its URL does not exist
+	db := newResourceIndex(t, `apiVersion: source.toolkit.fluxcd.io/v1
+kind: GitRepository
+metadata:
+  name: flux-system
+  namespace: flux-system
+spec:
+  url: https://127.0.0.1:1/does-not-exist.git
+`)
+
+	tests := []struct {
+		name           string
+		chart          string
+		valuesFiles    []string
+		expectReplicas string
+		expectErr      string
+	}{
+		{
+			name:           "packaged chart",
+			chart:          "./charts/app-1.2.3.tgz",
+			expectReplicas: "1",
+		},
+		{
+			name:           "values files from the archive",
+			chart:          "charts/app-1.2.3.tgz",
+			valuesFiles:    []string{"values.yaml", "values-prod.yaml"},
+			expectReplicas: "3",
+		},
+		{
+			name:      "missing archive",
+			chart:     "./charts/app-2.0.0.tgz",
+			expectErr: fmt.Sprintf("failed to read packaged chart %s/charts/app-2.0.0.tgz of helmrelease `apps/app`", root),
+		},
+		{
+			name:      "corrupt archive",
+			chart:     "./charts/corrupt-1.0.0.tgz",
+			expectErr: fmt.Sprintf("invalid packaged chart %s/charts/corrupt-1.0.0.tgz of helmrelease `apps/app`", root),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			h := NewHelmBuilder(logr.Discard(), HelmOpts{RepositoryRoot: root})
+			hr := &helmv2.HelmRelease{
+				ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "apps"},
+				Spec: helmv2.HelmReleaseSpec{
+					Chart: &helmv2.HelmChartTemplate{
+						Spec: helmv2.HelmChartTemplateSpec{
+							Chart:       tt.chart,
+							ValuesFiles: tt.valuesFiles,
+							SourceRef: helmv2.CrossNamespaceObjectReference{
+								Kind:      "GitRepository",
+								Name:      "flux-system",
+								Namespace: "flux-system",
+							},
+						},
+					},
+				},
+			}
+
+			packaged := &PackagedChart{}
+			ctx := WithPackagedChart(context.Background(), packaged)
+			chartBuild, spec, err := h.resolveChart(ctx, hr, &chartVerification{}, db)
+			if tt.expectErr != "" {
+				g.Expect(err).To(MatchError(ContainSubstring(tt.expectErr)))
+				return
+			}
+
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(chartBuild.Name).To(Equal("app"))
+			g.Expect(chartBuild.Version).To(Equal("1.2.3"))
+			g.Expect(*packaged).To(Equal(PackagedChart{Path: path, Digest: digest}))
+			g.Expect(chartBuild.Digest).To(Equal(digest))
+
+			resolved := &ResolvedChart{}
+			h.recordResolvedChart(WithResolvedChart(ctx, resolved), hr, chartBuild)
+			g.Expect(*resolved).To(Equal(ResolvedChart{Name: "app", Version: "1.2.3", Digest: digest}))
+
+			loaded, err := h.loadChart(ctx, chartBuild, spec, db)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			values, err := h.composeValues(ctx, db, *hr)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			rel, err := h.renderRelease(ctx, *hr, nil, values, loaded)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(rel.Manifest).To(ContainSubstring(`replicas: "` + tt.expectReplicas + `"`))
+		})
+	}
+}
@@ -0,0 +1,238 @@
+package build
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"sigs.k8s.io/kustomize/api/hasher"
+	"sigs.k8s.io/kustomize/api/krusty"
+	"sigs.k8s.io/kustomize/api/resmap"
+	"sigs.k8s.io/kustomize/api/resource"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+	"sigs.k8s.io/kustomize/kyaml/resid"
+)
+
+// nameReferenceConfig extends the kustomize nameReference defaults with the flux references to ConfigMaps and Secrets.
+const nameReferenceConfig = `nameReference:
+- kind: ConfigMap
+  version: v1
+  fieldSpecs:
+  - kind: HelmRelease
+    group: helm.toolkit.fluxcd.io
+    path: spec/valuesFrom/name
+  - kind: Kustomization
+    group: kustomize.toolkit.fluxcd.io
+    path: spec/postBuild/substituteFrom/name
+- kind: Secret
+  version: v1
+  fieldSpecs:
+  - kind: HelmRelease
+    group: helm.toolkit.fluxcd.io
+    path: spec/valuesFrom/name
+  - kind: Kustomization
+    group: kustomize.toolkit.fluxcd.io
+    path: spec/postBuild/substituteFrom/name
+`
+
+var hashSuffix = regexp.MustCompile(`^(.+)-([a-z0-9]{10})$`)
+
+// NameReferenceFixup is a reference to a generated resource which was rewritten to its hash-suffixed name.
+type NameReferenceFixup struct {
+	Referrer      resid.ResId
+	Name          string
+	GeneratedName string
+}
+
+// NameReferences resolves references to hash-suffixed ConfigMaps and Secrets generated by kustomize
+// across source boundaries using the kustomize nameReference semantics.
+type NameReferences struct {
+	generated map[resid.ResId]*resource.Resource
+	names     map[resid.ResId]string
+}
+
+// NewNameReferences detects the generated ConfigMaps and Secrets among the given resources.
+// A resource is considered generated if its name ends with the kustomize content hash.
+func NewNameReferences(resources []*resource.Resource) (*NameReferences, error) {
+	n := &NameReferences{
+		generated: make(map[resid.ResId]*resource.Resource),
+		names:     make(map[resid.ResId]string),
+	}
+
+	for _, res := range resources {
+		name, ok, err := generatedName(res)
+		if err != nil {
+			return nil, err
+		}
+
+		if !ok {
+			continue
+		}
+
+		n.generated[res.CurId()] = res
+		n.names[res.CurId()] = name
+	}
+
+	return n, nil
+}
+
+// generatedName returns the name a ConfigMap or Secret generated by kustomize is declared with.
+// It reports false for resources whose name does not end with the kustomize content hash.
+func generatedName(res *resource.Resource) (string, bool, error) {
+	if res.GetKind() != "ConfigMap" && res.GetKind() != "Secret" {
+		return "", false, nil
+	}
+
+	match := hashSuffix.FindStringSubmatch(res.GetName())
+	if match == nil {
+		return "", false, nil
+	}
+
+	orig := res.DeepCopy()
+	if err := orig.SetName(match[1]); err != nil {
+		return "", false, err
+	}
+
+	hash, err := orig.Hash(&hasher.Hasher{})
+	if err != nil {
+		return "", false, fmt.Errorf("failed to hash %s: %w", res.CurId(), err)
+	}
+
+	if hash != match[2] {
+		return "", false, nil
+	}
+
+	return match[1], true, nil
+}
+
+// Len returns the number of detected generated resources.
+func (n *NameReferences) Len() int {
+	return len(n.generated)
+}
+
+// Resolve rewrites references to the unsuffixed name of generated resources in the given resources.
+// The input is not modified, a new ResMap is returned alongside all performed fixups.
+func (n *NameReferences) Resolve(m resmap.ResMap) (resmap.ResMap, []NameReferenceFixup, error) {
+	if len(n.generated) == 0 {
+		return m, nil, nil
+	}
+
+	combined := m.DeepCopy()
+	extra := make(map[resid.ResId]bool)
+	for id, res := range n.generated {
+		if _, err := combined.GetByCurrentId(id); err == nil {
+			continue
+		}
+
+		if err := combined.Append(res.DeepCopy()); err != nil {
+			return nil, nil, err
+		}
+
+		extra[id] = true
+	}
+
+	for _, res := range combined.Resources() {
+		name, ok := n.names[res.CurId()]
+		if !ok {
+			continue
+		}
+
+		generatedName := res.GetName()
+		if err := res.SetName(name); err != nil {
+			return nil, nil, err
+		}
+
+		res.StorePreviousId()
+		if err := res.SetName(generatedName); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	resolved, err := n.run(combined)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to resolve name references: %w", err)
+	}
+
+	var fixups []NameReferenceFixup
+	for _, res := range resolved.Resources() {
+		id := res.CurId()
+		if extra[id] {
+			continue
+		}
+
+		before, err := m.GetByCurrentId(id)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		fixups = append(fixups, n.fixups(before, res)...)
+	}
+
+	for id := range extra {
+		if err := resolved.Remove(id); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return resolved, fixups, nil
+}
+
+// fixups compares a resource before and after the resolution and returns the rewritten references.
+func (n *NameReferences) fixups(before, after *resource.Resource) []NameReferenceFixup {
+	if before.MustYaml() == after.MustYaml() {
+		return nil
+	}
+
+	var fixups []NameReferenceFixup
+	for id, res := range n.generated {
+		if id.Namespace != after.GetNamespace() {
+			continue
+		}
+
+		if strings.Count(after.MustYaml(), res.GetName()) > strings.Count(before.MustYaml(), res.GetName()) {
+			fixups = append(fixups, NameReferenceFixup{
+				Referrer:      after.CurId(),
+				Name:          n.names[id],
+				GeneratedName: res.GetName(),
+			})
+		}
+	}
+
+	slices.SortFunc(fixups, func(a, b NameReferenceFixup) int {
+		return strings.Compare(a.GeneratedName, b.GeneratedName)
+	})
+
+	return fixups
+}
+
+func (n *NameReferences) run(m resmap.ResMap) (resmap.ResMap, error) {
+	manifests, err := m.AsYaml()
+	if err != nil {
+		return nil, err
+	}
+
+	fs := newJSONListFs(filesys.MakeFsInMemory(), DocumentLimits{})
+	if err := fs.WriteFile("/resources.yaml", manifests); err != nil {
+		return nil, err
+	}
+
+	if err := fs.WriteFile("/namereference.yaml", []byte(nameReferenceConfig)); err != nil {
+		return nil, err
+	}
+
+	if err := fs.WriteFile("/kustomization.yaml", []byte("resources:\n- resources.yaml\nconfigurations:\n- namereference.yaml\n")); err != nil {
+		return nil, err
+	}
+
+	kustomizeBuildMutex.Lock()
+	defer kustomizeBuildMutex.Unlock()
+
+	resolved, err := krusty.MakeKustomizer(krusty.MakeDefaultOptions()).Run(fs, "/")
+	if err != nil {
+		return nil, err
+	}
+
+	restoreListKinds(resolved.Resources())
+	return resolved, nil
+}
@@ -0,0 +1,140 @@
+package build
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"sigs.k8s.io/kustomize/api/provider"
+	"sigs.k8s.io/kustomize/api/resmap"
+	"sigs.k8s.io/kustomize/kyaml/resid"
+)
+
+func TestNameReferencesResolve(t *testing.T) {
+	g := NewWithT(t)
+	factory := resmap.NewFactory(provider.NewDefaultDepProvider().GetResourceFactory())
+
+	generated, err := factory.NewResMapFromBytes([]byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: values-6gc9d749f7
+  namespace: apps
+data:
+  x: "y"
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: plain-abcdefghij
+  namespace: apps
+data:
+  x: "y"
+---
+apiVersion: helm.toolkit.fluxcd.io/v2
+kind: HelmRelease
+metadata:
+  name: podinfo
+  namespace: apps
+spec:
+  valuesFrom:
+  - kind: ConfigMap
+    name: values
+`))
+	g.Expect(err).NotTo(HaveOccurred())
+
+	refs, err := NewNameReferences(generated.Resources())
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(refs.Len()).To(Equal(1))
+
+	rendered, err := factory.NewResMapFromBytes([]byte(`apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: podinfo
+  namespace: apps
+spec:
+  template:
+    spec:
+      containers:
+      - name: podinfo
+        envFrom:
+        - configMapRef:
+            name: values
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: podinfo
+  namespace: other
+spec:
+  template:
+    spec:
+      containers:
+      - name: podinfo
+        envFrom:
+        - configMapRef:
+            name: values
+`))
+	g.Expect(err).NotTo(HaveOccurred())
+	before := rendered.DeepCopy()
+
+	resolved, fixups, err := refs.Resolve(rendered)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(rendered.Resources()).To(HaveLen(2))
+	g.Expect(rendered.ErrorIfNotEqualLists(before)).To(Succeed())
+
+	g.Expect(resolved.Resources()).To(HaveLen(2))
+	g.Expect(resolved.Resources()[0].MustYaml()).To(ContainSubstring("name: values-6gc9d749f7"))
+	g.Expect(resolved.Resources()[1].MustYaml()).NotTo(ContainSubstring("values-6gc9d749f7"))
+	g.Expect(resolved.AsYaml()).NotTo(ContainSubstring("internal.config.kubernetes.io"))
+	g.Expect(fixups).To(Equal([]NameReferenceFixup{
+		{
+			Referrer:      resid.NewResIdWithNamespace(resid.NewGvk("apps", "v1", "Deployment"), "podinfo", "apps"),
+			Name:          "values",
+			GeneratedName: "values-6gc9d749f7",
+		},
+	}))
+
+	resolved, fixups, err = refs.Resolve(generated)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(resolved.Resources()).To(HaveLen(3))
+	g.Expect(resolved.Resources()[2].MustYaml()).To(ContainSubstring("name: values-6gc9d749f7"))
+	g.Expect(fixups).To(HaveLen(1))
+	g.Expect(fixups[0].Referrer.Kind).To(Equal("HelmRelease"))
+}
+
+func TestNameReferencesResolveUnknownKinds(t *testing.T) {
+	g := NewWithT(t)
+	factory := resmap.NewFactory(provider.NewDefaultDepProvider().GetResourceFactory())
+
+	generated, err := factory.NewResMapFromBytes([]byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: values-6gc9d749f7
+  namespace: apps
+data:
+  x: "y"
+`))
+	g.Expect(err).NotTo(HaveOccurred())
+
+	refs, err := NewNameReferences(generated.Resources())
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(refs.Len()).To(Equal(1))
+
+	dir := t.TempDir()
+	g.Expect(os.WriteFile(filepath.Join(dir, "resources.yaml"), []byte(strings.Join(unknownKinds, "---\n")), 0644)).To(Succeed())
+	rendered, err := Kustomize(context.Background(), dir)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	resolved, fixups, err := refs.Resolve(rendered)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(fixups).To(BeEmpty())
+
+	var manifests []string
+	for _, r := range resolved.Resources() {
+		manifests = append(manifests, r.MustYaml())
+	}
+	g.Expect(manifests).To(ConsistOf(unknownKinds))
+}
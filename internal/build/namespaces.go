@@ -0,0 +1,107 @@
+package build
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/kustomize/api/provider"
+	"sigs.k8s.io/kustomize/api/resmap"
+	"sigs.k8s.io/kustomize/api/resource"
+)
+
+// CreateNamespace returns the namespace helm-controller creates for a HelmRelease with spec.install.createNamespace.
+// It is the target namespace or the namespace of the HelmRelease.
+func CreateNamespace(r *resource.Resource) (string, bool) {
+	create, err := r.GetFieldValue("spec.install.createNamespace")
+	if err != nil {
+		return "", false
+	}
+
+	if b, ok := create.(bool); !ok || !b {
+		return "", false
+	}
+
+	if namespace, err := r.GetString("spec.targetNamespace"); err == nil && namespace != "" {
+		return namespace, true
+	}
+
+	if namespace := r.GetNamespace(); namespace != "" {
+		return namespace, true
+	}
+
+	return "default", true
+}
+
+// Namespaces collects the namespaces created by HelmReleases and the Namespaces written to the output.
+// Namespaces declared by any resource of the build always win over the bare Namespaces synthesized for HelmReleases,
+// regardless of the order the HelmReleases and Kustomizations are built in. It is safe for concurrent use.
+type Namespaces struct {
+	mu        sync.Mutex
+	requested map[string]struct{}
+	emitted   map[string]struct{}
+}
+
+// NewNamespaces returns an empty collection.
+func NewNamespaces() *Namespaces {
+	return &Namespaces{
+		requested: make(map[string]struct{}),
+		emitted:   make(map[string]struct{}),
+	}
+}
+
+// Request adds a namespace which is created by a HelmRelease.
+func (n *Namespaces) Request(name string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.requested[name] = struct{}{}
+}
+
+// Observe records the Namespaces of resources written to the output.
+func (n *Namespaces) Observe(m resmap.ResMap) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for _, r := range m.Resources() {
+		if isNamespace(r) {
+			n.emitted[r.GetName()] = struct{}{}
+		}
+	}
+}
+
+// Missing returns bare Namespaces sorted by name for the requested namespaces which are neither declared in the
+// resource db nor written to the output.
+func (n *Namespaces) Missing(db ResourceIndex) (resmap.ResMap, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	var names []string
+	for name := range n.requested {
+		if _, ok := n.emitted[name]; ok {
+			continue
+		}
+
+		if _, ok := db[ref{GroupKind: namespaceGroupKind, Name: name}]; ok {
+			continue
+		}
+
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var manifests []string
+	for _, name := range names {
+		manifests = append(manifests, fmt.Sprintf("apiVersion: v1\nkind: Namespace\nmetadata:\n  name: %s\n", name))
+	}
+
+	return resmap.NewFactory(provider.NewDefaultDepProvider().GetResourceFactory()).NewResMapFromBytes([]byte(strings.Join(manifests, "---\n")))
+}
+
+var namespaceGroupKind = schema.GroupKind{Kind: "Namespace"}
+
+func isNamespace(r *resource.Resource) bool {
+	return schema.FromAPIVersionAndKind(r.GetApiVersion(), r.GetKind()).GroupKind() == namespaceGroupKind
+}
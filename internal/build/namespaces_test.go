@@ -0,0 +1,151 @@
+package build
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"sigs.k8s.io/kustomize/api/provider"
+	"sigs.k8s.io/kustomize/api/resmap"
+)
+
+func TestCreateNamespace(t *testing.T) {
+	g := NewWithT(t)
+
+	m, err := resmap.NewFactory(provider.NewDefaultDepProvider().GetResourceFactory()).NewResMapFromBytes([]byte(`apiVersion: helm.toolkit.fluxcd.io/v2
+kind: HelmRelease
+metadata:
+  name: target
+  namespace: flux-system
+spec:
+  targetNamespace: apps
+  install:
+    createNamespace: true
+---
+apiVersion: helm.toolkit.fluxcd.io/v2
+kind: HelmRelease
+metadata:
+  name: release
+  namespace: monitoring
+spec:
+  install:
+    createNamespace: true
+---
+apiVersion: helm.toolkit.fluxcd.io/v2
+kind: HelmRelease
+metadata:
+  name: disabled
+  namespace: flux-system
+spec:
+  targetNamespace: other
+  install:
+    createNamespace: false
+---
+apiVersion: helm.toolkit.fluxcd.io/v2
+kind: HelmRelease
+metadata:
+  name: default
+  namespace: flux-system
+spec: {}
+`))
+	g.Expect(err).ToNot(HaveOccurred())
+
+	var namespaces []string
+	for _, r := range m.Resources() {
+		if namespace, ok := CreateNamespace(r); ok {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	g.Expect(namespaces).To(Equal([]string{"apps", "monitoring"}))
+}
+
+func TestNamespacesMissing(t *testing.T) {
+	declared := `apiVersion: v1
+kind: Namespace
+metadata:
+  name: apps
+  labels:
+    istio-injection: enabled
+    pod-security.kubernetes.io/enforce: restricted
+`
+
+	newResMap := func(t *testing.T, manifests string) resmap.ResMap {
+		m, err := resmap.NewFactory(provider.NewDefaultDepProvider().GetResourceFactory()).NewResMapFromBytes([]byte(manifests))
+		NewWithT(t).Expect(err).ToNot(HaveOccurred())
+		return m
+	}
+
+	tests := []struct {
+		name      string
+		requested []string
+		db        string
+		// outputs are observed in order, the HelmRelease output comes before the Kustomization declaring the Namespace
+		outputs []string
+		expect  []string
+	}{
+		{
+			name:      "synthesized",
+			requested: []string{"monitoring", "apps"},
+			expect:    []string{"apps", "monitoring"},
+		},
+		{
+			name:      "declared in the resource db",
+			requested: []string{"apps", "monitoring"},
+			db:        declared,
+			expect:    []string{"monitoring"},
+		},
+		{
+			name:      "declared by a kustomization built after the helmrelease",
+			requested: []string{"apps"},
+			db:        declared,
+			outputs: []string{
+				"apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: podinfo\n  namespace: apps\n",
+				declared,
+			},
+		},
+		{
+			name:      "rendered by a chart",
+			requested: []string{"apps"},
+			outputs:   []string{"apiVersion: v1\nkind: Namespace\nmetadata:\n  name: apps\n"},
+		},
+		{
+			name:      "requested by several helmreleases",
+			requested: []string{"apps", "apps"},
+			expect:    []string{"apps"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			db := make(ResourceIndex)
+			if tt.db != "" {
+				db = newResourceIndex(t, tt.db)
+			}
+
+			namespaces := NewNamespaces()
+			for _, name := range tt.requested {
+				namespaces.Request(name)
+			}
+
+			for _, output := range tt.outputs {
+				namespaces.Observe(newResMap(t, output))
+			}
+
+			m, err := namespaces.Missing(db)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			var names []string
+			for _, r := range m.Resources() {
+				g.Expect(r.GetKind()).To(Equal("Namespace"))
+				g.Expect(r.GetLabels()).To(BeEmpty())
+				names = append(names, r.GetName())
+			}
+			g.Expect(names).To(Equal(tt.expect))
+
+			if tt.db != "" {
+				declared := db[ref{GroupKind: namespaceGroupKind, Name: "apps"}]
+				g.Expect(declared.GetLabels()).To(HaveKeyWithValue("istio-injection", "enabled"))
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"helm.sh/helm/v3/pkg/release"
+)
+
+// writeNotes writes the rendered NOTES.txt of the release to <namespace>_<name>.txt within NotesDir. Nothing is
+// written for charts without notes.
+func (h *Helm) writeNotes(ctx context.Context, hr *helmv2.HelmRelease, rel *release.Release) error {
+	if rel.Info == nil || rel.Info.Notes == "" {
+		h.logger(ctx).V(1).Info("chart has no notes", "release", releaseName(*hr))
+		return nil
+	}
+
+	if err := os.MkdirAll(h.opts.NotesDir, 0o755); err != nil {
+		return err
+	}
+
+	path := filepath.Join(h.opts.NotesDir, hr.GetNamespace()+"_"+hr.GetName()+".txt")
+	h.logger(ctx).V(1).Info("write notes", "path", path)
+
+	if err := os.WriteFile(path, []byte(rel.Info.Notes), 0o644); err != nil {
+		return fmt.Errorf("failed to write notes of helmrelease `%s/%s`: %w", hr.GetNamespace(), hr.GetName(), err)
+	}
+
+	return nil
+}
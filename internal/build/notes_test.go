@@ -0,0 +1,76 @@
+package build
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/doodlescheduling/flux-build/internal/cachemgr"
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"github.com/go-logr/logr"
+	. "github.com/onsi/gomega"
+	helmchart "helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestWriteNotes(t *testing.T) {
+	tests := []struct {
+		name        string
+		notes       string
+		values      chartutil.Values
+		expectNotes string
+	}{
+		{
+			name:        "notes rendered with the release values",
+			notes:       "Visit https://{{ .Values.host }} to use {{ .Release.Name }} in {{ .Release.Namespace }}.\n",
+			values:      chartutil.Values{"host": "podinfo.example.com"},
+			expectNotes: "Visit https://podinfo.example.com to use podinfo in apps.\n",
+		},
+		{
+			name: "chart without notes",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			c := &helmchart.Chart{
+				Metadata: &helmchart.Metadata{APIVersion: helmchart.APIVersionV2, Name: "podinfo", Version: "1.0.0"},
+				Templates: []*helmchart.File{
+					{Name: "templates/configmap.yaml", Data: []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: podinfo\n")},
+				},
+			}
+			if tt.notes != "" {
+				c.Templates = append(c.Templates, &helmchart.File{Name: "templates/NOTES.txt", Data: []byte(tt.notes)})
+			}
+
+			cache, err := cachemgr.New("none", "")
+			g.Expect(err).ToNot(HaveOccurred())
+			dir := filepath.Join(t.TempDir(), "notes")
+			h := NewHelmBuilder(logr.Discard(), HelmOpts{
+				Cache:    cache,
+				NotesDir: dir,
+			})
+
+			hr := &helmv2.HelmRelease{
+				ObjectMeta: metav1.ObjectMeta{Name: "podinfo", Namespace: "apps"},
+				Spec:       helmv2.HelmReleaseSpec{Chart: &helmv2.HelmChartTemplate{Spec: helmv2.HelmChartTemplateSpec{Chart: "podinfo"}}},
+			}
+
+			rel, err := h.renderRelease(context.Background(), *hr, nil, tt.values, c)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(h.writeNotes(context.Background(), hr, rel)).To(Succeed())
+
+			b, err := os.ReadFile(filepath.Join(dir, "apps_podinfo.txt"))
+			if tt.expectNotes == "" {
+				g.Expect(os.IsNotExist(err)).To(BeTrue())
+				return
+			}
+
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(string(b)).To(Equal(tt.expectNotes))
+		})
+	}
+}
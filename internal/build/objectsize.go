@@ -0,0 +1,67 @@
+package build
+
+import (
+	"fmt"
+
+	"sigs.k8s.io/kustomize/api/resmap"
+)
+
+// ObjectSizeLimits are the practical size limits of objects stored by the Kubernetes API server.
+// A zero value disables the respective limit.
+type ObjectSizeLimits struct {
+	// MaxSize is the maximum size in bytes of the serialized object.
+	MaxSize int
+	// MaxAnnotationsSize is the maximum total size in bytes of the annotation keys and values.
+	MaxAnnotationsSize int
+}
+
+// DefaultObjectSizeLimits are the default request limit of etcd and the annotation limit enforced by the API server.
+var DefaultObjectSizeLimits = ObjectSizeLimits{
+	MaxSize:            1 << 20,
+	MaxAnnotationsSize: 256 << 10,
+}
+
+// ObjectSizeFinding is a resource exceeding the ObjectSizeLimits.
+type ObjectSizeFinding struct {
+	// Resource is the kind, namespace and name of the resource.
+	Resource string
+	// Limit is the exceeded limit, either object or annotations.
+	Limit string
+	// Size is the size in bytes of the object or its annotations.
+	Size int
+	// MaxSize is the exceeded limit in bytes.
+	MaxSize int
+}
+
+// Check returns the resources which exceed the limits.
+// The size of an object is measured as JSON which is how the API server receives it.
+func (l ObjectSizeLimits) Check(m resmap.ResMap) ([]ObjectSizeFinding, error) {
+	var findings []ObjectSizeFinding
+	for _, r := range m.Resources() {
+		name := ResourceName(r.GetKind(), r.GetNamespace(), r.GetName())
+
+		if l.MaxSize > 0 {
+			b, err := r.MarshalJSON()
+			if err != nil {
+				return nil, fmt.Errorf("failed to encode %s as json: %w", name, err)
+			}
+
+			if len(b) > l.MaxSize {
+				findings = append(findings, ObjectSizeFinding{Resource: name, Limit: "object", Size: len(b), MaxSize: l.MaxSize})
+			}
+		}
+
+		if l.MaxAnnotationsSize > 0 {
+			var size int
+			for k, v := range r.GetAnnotations() {
+				size += len(k) + len(v)
+			}
+
+			if size > l.MaxAnnotationsSize {
+				findings = append(findings, ObjectSizeFinding{Resource: name, Limit: "annotations", Size: size, MaxSize: l.MaxAnnotationsSize})
+			}
+		}
+	}
+
+	return findings, nil
+}
@@ -0,0 +1,88 @@
+package build
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"sigs.k8s.io/kustomize/api/provider"
+	"sigs.k8s.io/kustomize/api/resmap"
+)
+
+func TestObjectSizeLimitsCheck(t *testing.T) {
+	dashboard := strings.Repeat("x", 2048)
+
+	tests := []struct {
+		name           string
+		limits         ObjectSizeLimits
+		manifests      string
+		expectFindings []ObjectSizeFinding
+	}{
+		{
+			name:   "within limits",
+			limits: DefaultObjectSizeLimits,
+			manifests: fmt.Sprintf(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: dashboards
+  namespace: monitoring
+data:
+  dashboard.json: %s`, dashboard),
+		},
+		{
+			name:   "object too large",
+			limits: ObjectSizeLimits{MaxSize: 1024},
+			manifests: fmt.Sprintf(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: dashboards
+  namespace: monitoring
+data:
+  dashboard.json: %s
+---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: monitoring`, dashboard),
+			expectFindings: []ObjectSizeFinding{
+				{Resource: "ConfigMap/monitoring/dashboards", Limit: "object", Size: 2173, MaxSize: 1024},
+			},
+		},
+		{
+			name:   "annotations too large",
+			limits: ObjectSizeLimits{MaxSize: 4096, MaxAnnotationsSize: 1024},
+			manifests: fmt.Sprintf(`apiVersion: v1
+kind: Namespace
+metadata:
+  name: monitoring
+  annotations:
+    example.com/dashboard: %s`, dashboard),
+			expectFindings: []ObjectSizeFinding{
+				{Resource: "Namespace/monitoring", Limit: "annotations", Size: 2069, MaxSize: 1024},
+			},
+		},
+		{
+			name:   "limits disabled",
+			limits: ObjectSizeLimits{},
+			manifests: fmt.Sprintf(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: dashboards
+data:
+  dashboard.json: %s`, dashboard),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			m, err := resmap.NewFactory(provider.NewDefaultDepProvider().GetResourceFactory()).NewResMapFromBytes([]byte(tt.manifests))
+			g.Expect(err).ToNot(HaveOccurred())
+
+			findings, err := tt.limits.Check(m)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(findings).To(Equal(tt.expectFindings))
+		})
+	}
+}
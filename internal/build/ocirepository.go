@@ -0,0 +1,406 @@
+package build
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/doodlescheduling/flux-build/internal/helm/chart"
+	"github.com/doodlescheduling/flux-build/internal/helm/getter"
+	"github.com/doodlescheduling/flux-build/internal/helm/registry"
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"github.com/fluxcd/pkg/version"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+	sourcev1beta2 "github.com/fluxcd/source-controller/api/v1beta2"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	helmreg "helm.sh/helm/v3/pkg/registry"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/kustomize/api/resource"
+	"sigs.k8s.io/yaml"
+)
+
+// tagList is the result of listing the tags of an OCI repository, it is shared by all releases of a run.
+type tagList struct {
+	once sync.Once
+	tags []string
+	err  error
+}
+
+// buildFromOCIRepository pulls the chart of a HelmRelease referencing an OCIRepository via spec.chartRef.
+// The artifact is resolved by digest, semver range or tag in this order like the source-controller does.
+func (h *Helm) buildFromOCIRepository(ctx context.Context, hr *helmv2.HelmRelease, db map[ref]*resource.Resource) (*chart.Build, error) {
+	namespace := hr.Spec.ChartRef.Namespace
+	if len(namespace) == 0 {
+		namespace = hr.GetNamespace()
+	}
+
+	lookupRef := ref{
+		GroupKind: schema.GroupKind{
+			Group: sourcev1.GroupVersion.Group,
+			Kind:  sourcev1beta2.OCIRepositoryKind,
+		},
+		Name:      hr.Spec.ChartRef.Name,
+		Namespace: namespace,
+	}
+
+	source, ok := db[lookupRef]
+	if !ok {
+		return nil, fmt.Errorf("no source `%v` found for helmrelease `%s/%s`", lookupRef, hr.GetNamespace(), hr.GetName())
+	}
+
+	b, err := source.AsYAML()
+	if err != nil {
+		return nil, fmt.Errorf("failed marshal ocirepository as yaml: %w", err)
+	}
+
+	repo := &sourcev1beta2.OCIRepository{}
+	if err := yaml.Unmarshal(b, repo); err != nil {
+		return nil, fmt.Errorf("failed to decode into ocirepository: %w", err)
+	}
+
+	key := strings.Join([]string{"ocirepository", repo.Namespace, repo.Name, string(b)}, "/")
+	result, err, _ := h.charts.Do(key, func() (interface{}, error) {
+		return h.pullOCIRepositoryChart(ctx, repo, db)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	build := *result.(*chart.Build)
+	return &build, nil
+}
+
+func (h *Helm) pullOCIRepositoryChart(ctx context.Context, repo *sourcev1beta2.OCIRepository, db map[ref]*resource.Resource) (*chart.Build, error) {
+	if !strings.HasPrefix(repo.Spec.URL, sourcev1beta2.OCIRepositoryPrefix) {
+		return nil, fmt.Errorf("invalid url `%s` of ocirepository %s/%s, expected %s prefix", repo.Spec.URL, repo.Namespace, repo.Name, sourcev1beta2.OCIRepositoryPrefix)
+	}
+
+	ctx, cancel := withTimeout(ctx, h.repositoryTimeout(repo, repo.Spec.URL))
+	defer cancel()
+
+	remoteOpts, err := h.ociRepositoryRemoteOptions(ctx, repo, db)
+	if err != nil {
+		return nil, err
+	}
+
+	var nameOpts []name.Option
+	if h.ociRepositoryInsecure(repo) {
+		h.warnInsecureRegistry(ctx, repositoryHost(repo.Spec.URL), "plain http")
+		nameOpts = append(nameOpts, name.Insecure)
+	}
+
+	reference, tag, err := h.resolveOCIRepositoryRef(repo, nameOpts, remoteOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	img, err := remote.Image(reference, remoteOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to pull artifact %s of ocirepository %s/%s: %w", reference, repo.Namespace, repo.Name, err)
+	}
+
+	digest, err := img.Digest()
+	if err != nil {
+		return nil, err
+	}
+
+	digestRef := reference.Context().Digest(digest.String())
+	h.logger(ctx).Info("resolved ocirepository", "ocirepository", repo.Namespace+"/"+repo.Name, "tag", tag, "digest", digest.String())
+
+	if repo.Spec.Verify != nil && repo.Spec.Verify.Provider != "" {
+		if err := h.verifyOCIRepository(ctx, repo, digestRef, db, remoteOpts); err != nil {
+			return nil, err
+		}
+	}
+
+	mediaType := helmreg.ChartLayerMediaType
+	if repo.Spec.LayerSelector != nil && repo.Spec.LayerSelector.MediaType != "" {
+		mediaType = repo.Spec.LayerSelector.MediaType
+	}
+
+	layers, err := img.Layers()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list layers of artifact %s: %w", digestRef, err)
+	}
+
+	var blob io.ReadCloser
+	for _, layer := range layers {
+		mt, err := layer.MediaType()
+		if err != nil {
+			return nil, err
+		}
+
+		if string(mt) == mediaType {
+			if blob, err = layer.Compressed(); err != nil {
+				return nil, fmt.Errorf("failed to pull layer %s of artifact %s: %w", mediaType, digestRef, err)
+			}
+			break
+		}
+	}
+
+	if blob == nil {
+		return nil, fmt.Errorf("no layer with media type %s found in artifact %s", mediaType, digestRef)
+	}
+	defer blob.Close()
+
+	// The artifact is immutable, the digest identifies the chart in the cache
+	chartRef := chart.RemoteReference{Name: repo.Name, Version: digest.String()}
+	path, newItem, err := h.cache.GetOrLock(repo.Spec.URL, chartRef)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(path); newItem != nil || err != nil {
+		if err := writeChart(path, blob); err != nil {
+			_ = h.cache.FailUnlock(newItem, err)
+			return nil, err
+		}
+	} else {
+		h.logger(ctx).V(1).Info("using cached chart artifact", "chart", chartRef.String(), "path", path)
+	}
+
+	// The chart is loaded before it is published to concurrent pulls waiting for it
+	loaded, err := loader.Load(path)
+	if err != nil {
+		err = fmt.Errorf("artifact %s of ocirepository %s/%s is not a helm chart: %w", digestRef, repo.Namespace, repo.Name, err)
+		_ = h.cache.FailUnlock(newItem, err)
+		return nil, err
+	}
+
+	if err := h.cache.SetUnlock(newItem); err != nil {
+		return nil, err
+	}
+
+	layerDigest, err := fileDigest(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &chart.Build{
+		Name:    loaded.Name(),
+		Version: loaded.Metadata.Version,
+		Path:    path,
+		Digest:  layerDigest,
+	}, nil
+}
+
+// resolveOCIRepositoryRef returns the reference of the artifact and the tag it was resolved from.
+func (h *Helm) resolveOCIRepositoryRef(repo *sourcev1beta2.OCIRepository, nameOpts []name.Option, remoteOpts []remote.Option) (name.Reference, string, error) {
+	u := strings.TrimPrefix(repo.Spec.URL, sourcev1beta2.OCIRepositoryPrefix)
+	repository, err := name.NewRepository(u, nameOpts...)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid url `%s` of ocirepository %s/%s: %w", repo.Spec.URL, repo.Namespace, repo.Name, err)
+	}
+
+	reference := repo.Spec.Reference
+	if reference == nil {
+		reference = &sourcev1beta2.OCIRepositoryRef{}
+	}
+
+	switch {
+	case reference.Digest != "":
+		return repository.Digest(reference.Digest), "", nil
+	case reference.SemVer != "":
+		tags, err := h.listTags(repository, remoteOpts)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to list tags of ocirepository %s/%s: %w", repo.Namespace, repo.Name, err)
+		}
+
+		tag, err := tagBySemver(tags, reference.SemVer, reference.SemverFilter)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to resolve semver of ocirepository %s/%s: %w", repo.Namespace, repo.Name, err)
+		}
+
+		return repository.Tag(tag), tag, nil
+	case reference.Tag != "":
+		return repository.Tag(reference.Tag), reference.Tag, nil
+	}
+
+	return repository.Tag(name.DefaultTag), name.DefaultTag, nil
+}
+
+// listTags lists the tags of the repository once per run.
+func (h *Helm) listTags(repository name.Repository, remoteOpts []remote.Option) ([]string, error) {
+	v, _ := h.tags.LoadOrStore(repository.String(), &tagList{})
+	list := v.(*tagList)
+	list.once.Do(func() {
+		list.tags, list.err = remote.List(repository, remoteOpts...)
+	})
+
+	return list.tags, list.err
+}
+
+// tagBySemver returns the highest tag matching the semver range. Tags which are no semver
+// or do not match the filter regex are ignored.
+func tagBySemver(tags []string, semverRange, filter string) (string, error) {
+	constraint, err := semver.NewConstraint(semverRange)
+	if err != nil {
+		return "", fmt.Errorf("semver `%s` parse error: %w", semverRange, err)
+	}
+
+	if filter != "" {
+		re, err := regexp.Compile(filter)
+		if err != nil {
+			return "", fmt.Errorf("semver filter `%s` parse error: %w", filter, err)
+		}
+
+		tags = slices.DeleteFunc(slices.Clone(tags), func(tag string) bool {
+			return !re.MatchString(tag)
+		})
+	}
+
+	var matchingVersions []*semver.Version
+	for _, tag := range tags {
+		v, err := version.ParseVersion(tag)
+		if err != nil {
+			continue
+		}
+
+		if constraint.Check(v) {
+			matchingVersions = append(matchingVersions, v)
+		}
+	}
+
+	if len(matchingVersions) == 0 {
+		return "", fmt.Errorf("no match found for semver: %s", semverRange)
+	}
+
+	sort.Sort(sort.Reverse(semver.Collection(matchingVersions)))
+	return matchingVersions[0].Original(), nil
+}
+
+// ociRepositoryRemoteOptions returns the registry options of the OCIRepository. Credentials are taken from the
+// secretRef, the provider or the default keychain in this order.
+func (h *Helm) ociRepositoryRemoteOptions(ctx context.Context, repo *sourcev1beta2.OCIRepository, db map[ref]*resource.Resource) ([]remote.Option, error) {
+	opts := []remote.Option{remote.WithContext(ctx)}
+	registryURL := strings.TrimPrefix(repo.Spec.URL, sourcev1beta2.OCIRepositoryPrefix)
+
+	switch {
+	case repo.Spec.SecretRef != nil:
+		secret, lookupRef, err := h.getSecret(repo.Spec.SecretRef.Name, repo.Namespace, db)
+		if err != nil {
+			return nil, err
+		}
+
+		if secret == nil {
+			return nil, fmt.Errorf("no repository secret `%v` found for ocirepository %s/%s", lookupRef, repo.Namespace, repo.Name)
+		}
+
+		keychain, err := registry.LoginOptionFromSecret(registryURL, *secret)
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure registry credentials of ocirepository %s/%s: %w", repo.Namespace, repo.Name, err)
+		}
+
+		opts = append(opts, remote.WithAuthFromKeychain(keychain))
+	case repo.Spec.Provider != "" && repo.Spec.Provider != sourcev1beta2.GenericOCIProvider:
+		auth, err := oidcAuth(ctx, repo.Spec.URL, repo.Spec.Provider)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get credential from %s: %w", repo.Spec.Provider, err)
+		}
+
+		opts = append(opts, remote.WithAuth(auth))
+	case !h.opts.NoDefaultKeychain:
+		opts = append(opts, remote.WithAuthFromKeychain(authn.DefaultKeychain))
+	}
+
+	var tlsConfig *tls.Config
+	if repo.Spec.CertSecretRef != nil {
+		secret, lookupRef, err := h.getSecret(repo.Spec.CertSecretRef.Name, repo.Namespace, db)
+		if err != nil {
+			return nil, err
+		}
+
+		if secret == nil {
+			return nil, fmt.Errorf("no certSecretRef secret `%v` found for ocirepository %s/%s", lookupRef, repo.Namespace, repo.Name)
+		}
+
+		tlsConfig, err = getter.TLSClientConfigFromCertSecret(*secret, "https://"+registryURL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create TLS client config for ocirepository %s/%s: %w", repo.Namespace, repo.Name, err)
+		}
+	}
+
+	tlsConfig, err := h.tlsConfig(repo, registryURL, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig = h.registryTLSConfig(ctx, repositoryHost(registryURL), tlsConfig)
+
+	return append(opts, remote.WithTransport(h.transports.Get(tlsConfig))), nil
+}
+
+// ociRepositoryInsecure returns true if the registry of the OCIRepository is meant to be accessed via plain HTTP.
+func (h *Helm) ociRepositoryInsecure(repo *sourcev1beta2.OCIRepository) bool {
+	if repo.Spec.Insecure {
+		return true
+	}
+
+	u, err := url.Parse("https://" + strings.TrimPrefix(repo.Spec.URL, sourcev1beta2.OCIRepositoryPrefix))
+	if err != nil {
+		return false
+	}
+
+	return slices.Contains(h.opts.InsecureRegistries, u.Host)
+}
+
+// verifyOCIRepository verifies the artifact with the verifiers of spec.verify, a single valid signature is sufficient.
+func (h *Helm) verifyOCIRepository(ctx context.Context, repo *sourcev1beta2.OCIRepository, digestRef name.Digest, db map[ref]*resource.Resource, remoteOpts []remote.Option) error {
+	obj := &sourcev1.HelmChart{
+		Spec: sourcev1.HelmChartSpec{
+			Chart:  repo.Name,
+			Verify: repo.Spec.Verify,
+		},
+	}
+
+	verifiers, names, err := h.makeVerifiers(ctx, obj, repo.Namespace, db, remoteOpts)
+	if err != nil {
+		return fmt.Errorf("failed to create verifiers for ocirepository %s/%s: %w", repo.Namespace, repo.Name, err)
+	}
+
+	var errs []error
+	for _, verifier := range verifiers {
+		verified, err := verifier.Verify(ctx, digestRef)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		if verified {
+			return nil
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to verify artifact %s using provider %s with %s: %w", digestRef, repo.Spec.Verify.Provider, strings.Join(names, ", "), errors.Join(errs...))
+	}
+
+	return fmt.Errorf("no matching signatures were found for artifact %s using provider %s with %s", digestRef, repo.Spec.Verify.Provider, strings.Join(names, ", "))
+}
+
+func writeChart(path string, r io.Reader) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o640)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
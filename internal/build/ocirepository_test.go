@@ -0,0 +1,210 @@
+package build
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/doodlescheduling/flux-build/internal/cachemgr"
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"github.com/go-logr/logr"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	. "github.com/onsi/gomega"
+	helmreg "helm.sh/helm/v3/pkg/registry"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestTagBySemver(t *testing.T) {
+	tags := []string{"latest", "0.9.0", "1.0.0", "v1.2.0", "1.3.0-rc.1", "1.4.0-alpha", "2.0.0", "1.1", "main-abc123"}
+
+	tests := []struct {
+		name      string
+		semver    string
+		filter    string
+		expectTag string
+		expectErr string
+	}{
+		{
+			name:      "highest match within range",
+			semver:    ">=1.0.0 <2.0.0",
+			expectTag: "v1.2.0",
+		},
+		{
+			name:      "prereleases with prerelease range",
+			semver:    ">=1.0.0-0 <2.0.0-0",
+			expectTag: "1.4.0-alpha",
+		},
+		{
+			name:      "prereleases filtered",
+			semver:    ">=1.0.0-0 <2.0.0-0",
+			filter:    ".*-rc.*",
+			expectTag: "1.3.0-rc.1",
+		},
+		{
+			name:      "no match",
+			semver:    ">=3.0.0",
+			expectErr: "no match found for semver: >=3.0.0",
+		},
+		{
+			name:      "invalid range",
+			semver:    "not-a-range",
+			expectErr: "semver `not-a-range` parse error",
+		},
+		{
+			name:      "invalid filter",
+			semver:    "*",
+			filter:    "(",
+			expectErr: "semver filter `(` parse error",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			tag, err := tagBySemver(tags, tt.semver, tt.filter)
+			if tt.expectErr != "" {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tt.expectErr))
+				return
+			}
+
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(tag).To(Equal(tt.expectTag))
+		})
+	}
+}
+
+func TestBuildFromOCIRepository(t *testing.T) {
+	var tagLists atomic.Int32
+	reg := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/tags/list") {
+			tagLists.Add(1)
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	archive, err := os.ReadFile(testChart)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tag := range []string{"0.1.0", "0.2.0-rc.1", "1.0.0"} {
+		img, err := mutate.AppendLayers(empty.Image, static.NewLayer(archive, helmreg.ChartLayerMediaType))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ref, err := name.ParseReference(host + "/charts/helmchart:" + tag)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := remote.Write(ref, img); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	newOCIRepository := func(ref string) string {
+		return `apiVersion: source.toolkit.fluxcd.io/v1beta2
+kind: OCIRepository
+metadata:
+  name: helmchart
+  namespace: default
+spec:
+  url: oci://` + host + `/charts/helmchart
+  insecure: true
+  ref:
+` + ref
+	}
+
+	tests := []struct {
+		name       string
+		repository string
+		releases   int
+		expectErr  string
+	}{
+		{
+			name:       "semver range is resolved from a single tag list",
+			repository: newOCIRepository("    semver: \">=0.1.0 <1.0.0\""),
+			releases:   3,
+		},
+		{
+			name:       "tag",
+			repository: newOCIRepository("    tag: 1.0.0"),
+			releases:   1,
+		},
+		{
+			name:       "semver filter",
+			repository: newOCIRepository("    semver: \">=0.1.0-0\"\n    semverFilter: \".*-rc.*\""),
+			releases:   1,
+		},
+		{
+			name:       "no matching tag",
+			repository: newOCIRepository("    semver: \">=2.0.0\""),
+			releases:   1,
+			expectErr:  "no match found for semver: >=2.0.0",
+		},
+		{
+			name: "missing layer",
+			repository: newOCIRepository(`    tag: 1.0.0
+  layerSelector:
+    mediaType: application/vnd.cncf.flux.content.v1.tar+gzip`),
+			releases:  1,
+			expectErr: "no layer with media type application/vnd.cncf.flux.content.v1.tar+gzip found",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			tagLists.Store(0)
+
+			cache, err := cachemgr.New("none", "")
+			g.Expect(err).ToNot(HaveOccurred())
+			h := NewHelmBuilder(logr.Discard(), HelmOpts{Cache: cache})
+			db := newResourceIndex(t, tt.repository)
+
+			for i := 0; i < tt.releases; i++ {
+				hr := &helmv2.HelmRelease{
+					ObjectMeta: metav1.ObjectMeta{Name: "release", Namespace: "default"},
+					Spec: helmv2.HelmReleaseSpec{
+						ChartRef: &helmv2.CrossNamespaceSourceReference{
+							Kind: "OCIRepository",
+							Name: "helmchart",
+						},
+					},
+				}
+
+				build, err := h.buildFromOCIRepository(context.Background(), hr, db)
+				if tt.expectErr != "" {
+					g.Expect(err).To(HaveOccurred())
+					g.Expect(err.Error()).To(ContainSubstring(tt.expectErr))
+					return
+				}
+
+				g.Expect(err).ToNot(HaveOccurred())
+				g.Expect(build.Name).To(Equal("helmchart"))
+				g.Expect(build.Path).To(BeAnExistingFile())
+			}
+
+			if strings.Contains(tt.repository, "semver") {
+				g.Expect(tagLists.Load()).To(Equal(int32(1)))
+			} else {
+				g.Expect(tagLists.Load()).To(Equal(int32(0)))
+			}
+		})
+	}
+}
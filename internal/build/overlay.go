@@ -0,0 +1,152 @@
+package build
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/strvals"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// namespacedNameSelector matches a values overlay selector of the form namespace/name.
+var namespacedNameSelector = regexp.MustCompile(`^[a-z0-9]([-a-z0-9.]*[a-z0-9])?/[a-z0-9]([-a-z0-9.]*[a-z0-9])?$`)
+
+// ValuesOverlay is a values file merged on top of the values of the HelmReleases matched by its selector.
+type ValuesOverlay struct {
+	// Selector is either namespace/name of a HelmRelease or a label selector.
+	Selector string
+	// Path is the path of the values file.
+	Path string
+	// Values are the values of the file.
+	Values chartutil.Values
+
+	namespace, name string
+	labels          labels.Selector
+}
+
+// LoadValuesOverlay parses an overlay of the form <selector>=<file> and reads the values file.
+// The selector is separated from the file by the last `=`, it may be a label selector like app=podinfo itself.
+func LoadValuesOverlay(overlay string) (ValuesOverlay, error) {
+	i := strings.LastIndex(overlay, "=")
+	if i <= 0 || i == len(overlay)-1 {
+		return ValuesOverlay{}, fmt.Errorf("invalid values overlay `%s`, expected <selector>=<file>", overlay)
+	}
+
+	o := ValuesOverlay{
+		Selector: overlay[:i],
+		Path:     overlay[i+1:],
+	}
+
+	if namespacedNameSelector.MatchString(o.Selector) {
+		o.namespace, o.name, _ = strings.Cut(o.Selector, "/")
+	} else {
+		selector, err := labels.Parse(o.Selector)
+		if err != nil {
+			return ValuesOverlay{}, fmt.Errorf("invalid selector of values overlay `%s`: %w", overlay, err)
+		}
+
+		o.labels = selector
+	}
+
+	b, err := os.ReadFile(o.Path)
+	if err != nil {
+		return ValuesOverlay{}, err
+	}
+
+	o.Values, err = chartutil.ReadValues(b)
+	if err != nil {
+		return ValuesOverlay{}, fmt.Errorf("invalid values overlay %s: %w", o.Path, err)
+	}
+
+	return o, nil
+}
+
+// Matches returns true if the HelmRelease is selected by the overlay.
+func (o ValuesOverlay) Matches(namespace, name string, releaseLabels map[string]string) bool {
+	if o.labels != nil {
+		return o.labels.Matches(labels.Set(releaseLabels))
+	}
+
+	return o.namespace == namespace && o.name == name
+}
+
+// ValuesOverride sets values of a single HelmRelease like helm --set or --set-string.
+type ValuesOverride struct {
+	// Namespace of the HelmRelease.
+	Namespace string
+	// Name of the HelmRelease.
+	Name string
+	// Values are strvals expressions, for instance image.tag=abc123 or a=1,b=2.
+	Values string
+	// AsString sets all values as strings like --set-string, --set parses booleans, numbers and null.
+	AsString bool
+}
+
+// ParseValuesOverride parses an override of the form <namespace>/<name>:<key>=<value>.
+func ParseValuesOverride(override string, asString bool) (ValuesOverride, error) {
+	target, values, ok := strings.Cut(override, ":")
+	if !ok || !namespacedNameSelector.MatchString(target) || values == "" {
+		return ValuesOverride{}, fmt.Errorf("invalid values override `%s`, expected <namespace>/<name>:<key>=<value>", override)
+	}
+
+	o := ValuesOverride{
+		Values:   values,
+		AsString: asString,
+	}
+	o.Namespace, o.Name, _ = strings.Cut(target, "/")
+
+	if err := o.apply(map[string]interface{}{}); err != nil {
+		return ValuesOverride{}, fmt.Errorf("invalid values override `%s`: %w", override, err)
+	}
+
+	return o, nil
+}
+
+// Matches returns true if the override targets the HelmRelease.
+func (o ValuesOverride) Matches(namespace, name string) bool {
+	return o.Namespace == namespace && o.Name == name
+}
+
+// String returns the override as passed on the command line.
+func (o ValuesOverride) String() string {
+	flag := "--set"
+	if o.AsString {
+		flag = "--set-string"
+	}
+
+	return fmt.Sprintf("%s %s/%s:%s", flag, o.Namespace, o.Name, o.Values)
+}
+
+func (o ValuesOverride) apply(values map[string]interface{}) error {
+	if o.AsString {
+		return strvals.ParseIntoString(o.Values, values)
+	}
+
+	return strvals.ParseInto(o.Values, values)
+}
+
+// copyValues deep copies maps and lists of values, strvals modifies nested maps in place which may be shared
+// with the values of an overlay.
+func copyValues(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			out[key] = copyValues(value)
+		}
+		return out
+	case chartutil.Values:
+		return copyValues(map[string]interface{}(v))
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, value := range v {
+			out[i] = copyValues(value)
+		}
+		return out
+	default:
+		return v
+	}
+}
@@ -0,0 +1,301 @@
+package build
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"github.com/go-logr/logr"
+	. "github.com/onsi/gomega"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func writeValuesFile(t *testing.T, name, values string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(values), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestLoadValuesOverlay(t *testing.T) {
+	path := writeValuesFile(t, "overlay.yaml", "replicas: 1\n")
+
+	tests := []struct {
+		name      string
+		overlay   string
+		release   string
+		labels    map[string]string
+		expectErr string
+		expect    bool
+	}{
+		{
+			name:    "namespaced name",
+			overlay: "apps/podinfo=" + path,
+			release: "apps/podinfo",
+			expect:  true,
+		},
+		{
+			name:    "namespaced name of another release",
+			overlay: "apps/podinfo=" + path,
+			release: "apps/frontend",
+		},
+		{
+			name:    "label selector",
+			overlay: "app=podinfo,tier in (frontend,backend)=" + path,
+			release: "apps/frontend",
+			labels:  map[string]string{"app": "podinfo", "tier": "frontend"},
+			expect:  true,
+		},
+		{
+			name:    "label selector not matching",
+			overlay: "app=podinfo,tier in (frontend,backend)=" + path,
+			release: "apps/podinfo",
+			labels:  map[string]string{"app": "podinfo"},
+		},
+		{
+			name:    "prefixed label selector",
+			overlay: "app.kubernetes.io/name=podinfo=" + path,
+			release: "apps/frontend",
+			labels:  map[string]string{"app.kubernetes.io/name": "podinfo"},
+			expect:  true,
+		},
+		{
+			name:      "missing file",
+			overlay:   "apps/podinfo",
+			expectErr: "expected <selector>=<file>",
+		},
+		{
+			name:      "missing selector",
+			overlay:   "=" + path,
+			expectErr: "expected <selector>=<file>",
+		},
+		{
+			name:      "invalid selector",
+			overlay:   "app in podinfo=" + path,
+			expectErr: "invalid selector of values overlay",
+		},
+		{
+			name:      "invalid values",
+			overlay:   "apps/podinfo=" + writeValuesFile(t, "invalid.yaml", "- a\n"),
+			expectErr: "invalid values overlay",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			o, err := LoadValuesOverlay(tt.overlay)
+			if tt.expectErr != "" {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tt.expectErr))
+				return
+			}
+
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(o.Path).To(Equal(path))
+			g.Expect(o.Values).To(HaveKeyWithValue("replicas", float64(1)))
+
+			namespace, name, _ := strings.Cut(tt.release, "/")
+			g.Expect(o.Matches(namespace, name, tt.labels)).To(Equal(tt.expect))
+		})
+	}
+}
+
+func TestComposeValuesOverlays(t *testing.T) {
+	g := NewWithT(t)
+
+	var overlays []ValuesOverlay
+	for _, overlay := range []string{
+		"apps/podinfo=" + writeValuesFile(t, "first.yaml", "image:\n  tag: first\ncloud: stub\n"),
+		"app=podinfo=" + writeValuesFile(t, "second.yaml", "image:\n  tag: second\n"),
+		"apps/frontend=" + writeValuesFile(t, "other.yaml", "other: true\n"),
+	} {
+		o, err := LoadValuesOverlay(overlay)
+		g.Expect(err).ToNot(HaveOccurred())
+		overlays = append(overlays, o)
+	}
+
+	h := NewHelmBuilder(logr.Discard(), HelmOpts{
+		ValuesOverlays: overlays,
+	})
+
+	hr := helmv2.HelmRelease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "podinfo",
+			Namespace: "apps",
+			Labels:    map[string]string{"app": "podinfo"},
+		},
+		Spec: helmv2.HelmReleaseSpec{
+			Values: &apiextensionsv1.JSON{Raw: []byte(`{"image":{"repository":"podinfo","tag":"release"},"cloud":"aws"}`)},
+		},
+	}
+
+	values, err := h.composeValues(context.Background(), make(ResourceIndex), hr)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(map[string]interface{}(values)).To(Equal(map[string]interface{}{
+		"cloud": "stub",
+		"image": map[string]interface{}{
+			"repository": "podinfo",
+			"tag":        "second",
+		},
+	}))
+}
+
+func TestParseValuesOverride(t *testing.T) {
+	tests := []struct {
+		name      string
+		override  string
+		asString  bool
+		expect    ValuesOverride
+		expectErr string
+	}{
+		{
+			name:     "set",
+			override: "apps/podinfo:image.tag=abc123",
+			expect:   ValuesOverride{Namespace: "apps", Name: "podinfo", Values: "image.tag=abc123"},
+		},
+		{
+			name:     "set-string with several values",
+			override: "apps/podinfo:a=1,b=true",
+			asString: true,
+			expect:   ValuesOverride{Namespace: "apps", Name: "podinfo", Values: "a=1,b=true", AsString: true},
+		},
+		{
+			name:     "value containing a colon",
+			override: "apps/podinfo:url=https://example.com",
+			expect:   ValuesOverride{Namespace: "apps", Name: "podinfo", Values: "url=https://example.com"},
+		},
+		{
+			name:      "missing release",
+			override:  "image.tag=abc123",
+			expectErr: "expected <namespace>/<name>:<key>=<value>",
+		},
+		{
+			name:      "release without namespace",
+			override:  "podinfo:image.tag=abc123",
+			expectErr: "expected <namespace>/<name>:<key>=<value>",
+		},
+		{
+			name:      "invalid expression",
+			override:  "apps/podinfo:image.tag",
+			expectErr: "key \"tag\" has no value",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			o, err := ParseValuesOverride(tt.override, tt.asString)
+			if tt.expectErr != "" {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tt.expectErr))
+				return
+			}
+
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(o).To(Equal(tt.expect))
+		})
+	}
+}
+
+func TestComposeValuesOverrides(t *testing.T) {
+	tests := []struct {
+		name      string
+		overrides []string
+		asString  bool
+		expect    map[string]interface{}
+	}{
+		{
+			name:      "typed values",
+			overrides: []string{"apps/podinfo:image.tag=1.0,replicas=3,debug=true,resources=null,hosts[1]=b.example.com"},
+			// Like helm only integers are parsed, null keys are removed once the values are coalesced with the chart
+			expect: map[string]interface{}{
+				"image":     map[string]interface{}{"repository": "podinfo", "tag": "1.0"},
+				"replicas":  int64(3),
+				"debug":     true,
+				"resources": nil,
+				"hosts":     []interface{}{"a.example.com", "b.example.com"},
+				"cloud":     "stub",
+			},
+		},
+		{
+			name:      "string values",
+			overrides: []string{"apps/podinfo:image.tag=1.0,replicas=3,debug=true,resources=null"},
+			asString:  true,
+			expect: map[string]interface{}{
+				"image":     map[string]interface{}{"repository": "podinfo", "tag": "1.0"},
+				"replicas":  "3",
+				"debug":     "true",
+				"resources": "null",
+				"hosts":     []interface{}{"a.example.com"},
+				"cloud":     "stub",
+			},
+		},
+		{
+			name:      "applied in order",
+			overrides: []string{"apps/podinfo:image.tag=first", "apps/podinfo:image.tag=second"},
+			expect: map[string]interface{}{
+				"image":     map[string]interface{}{"repository": "podinfo", "tag": "second"},
+				"resources": map[string]interface{}{"limits": map[string]interface{}{"cpu": "1"}},
+				"hosts":     []interface{}{"a.example.com"},
+				"cloud":     "stub",
+			},
+		},
+		{
+			name:      "other release",
+			overrides: []string{"apps/frontend:image.tag=abc123"},
+			expect: map[string]interface{}{
+				"image":     map[string]interface{}{"repository": "podinfo", "tag": "release"},
+				"resources": map[string]interface{}{"limits": map[string]interface{}{"cpu": "1"}},
+				"hosts":     []interface{}{"a.example.com"},
+				"cloud":     "stub",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			overlay, err := LoadValuesOverlay("apps/podinfo=" + writeValuesFile(t, "overlay.yaml", "cloud: stub\nresources:\n  limits:\n    cpu: \"1\"\n"))
+			g.Expect(err).ToNot(HaveOccurred())
+
+			var overrides []ValuesOverride
+			for _, override := range tt.overrides {
+				o, err := ParseValuesOverride(override, tt.asString)
+				g.Expect(err).ToNot(HaveOccurred())
+				overrides = append(overrides, o)
+			}
+
+			h := NewHelmBuilder(logr.Discard(), HelmOpts{
+				ValuesOverlays:  []ValuesOverlay{overlay},
+				ValuesOverrides: overrides,
+			})
+
+			hr := helmv2.HelmRelease{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "podinfo",
+					Namespace: "apps",
+				},
+				Spec: helmv2.HelmReleaseSpec{
+					Values: &apiextensionsv1.JSON{Raw: []byte(`{"image":{"repository":"podinfo","tag":"release"},"hosts":["a.example.com"]}`)},
+				},
+			}
+
+			values, err := h.composeValues(context.Background(), make(ResourceIndex), hr)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(map[string]interface{}(values)).To(Equal(tt.expect))
+
+			// The values of the overlay are shared by all releases and never modified
+			g.Expect(map[string]interface{}(overlay.Values)).To(Equal(map[string]interface{}{
+				"cloud":     "stub",
+				"resources": map[string]interface{}{"limits": map[string]interface{}{"cpu": "1"}},
+			}))
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package build
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"sigs.k8s.io/kustomize/api/resmap"
+)
+
+// DefaultPartOfLabel is the label naming the Flux Kustomization a resource is part of.
+const DefaultPartOfLabel = "app.kubernetes.io/part-of"
+
+// Ownership attributes the paths of a build to the Flux Kustomizations building them. A path is owned by the
+// Kustomization whose spec.path resolves to it, the resources rendered by a HelmRelease are owned by the owner of the
+// path declaring the HelmRelease.
+type Ownership struct {
+	owners map[string]string
+}
+
+// NewOwnership returns the owners of the paths of the Flux Kustomizations of the index, spec.path is resolved relative
+// to root like by KustomizationPaths. A path built by several Kustomizations is owned by the first by namespace and name.
+func NewOwnership(root string, index ResourceIndex) *Ownership {
+	var kustomizations []ref
+	for key := range index {
+		if key.GroupKind == KustomizationGroupKind {
+			kustomizations = append(kustomizations, key)
+		}
+	}
+
+	sort.Slice(kustomizations, func(i, j int) bool {
+		if kustomizations[i].Namespace != kustomizations[j].Namespace {
+			return kustomizations[i].Namespace < kustomizations[j].Namespace
+		}
+
+		return kustomizations[i].Name < kustomizations[j].Name
+	})
+
+	o := &Ownership{owners: make(map[string]string)}
+	for _, key := range kustomizations {
+		specPath, _ := index[key].GetString("spec.path")
+		target := absPath(filepath.Join(root, strings.TrimPrefix(filepath.Clean("/"+specPath), "/")))
+		if _, ok := o.owners[target]; !ok {
+			o.owners[target] = key.Name
+		}
+	}
+
+	return o
+}
+
+// Owner returns the name of the Flux Kustomization building path.
+func (o *Ownership) Owner(path string) (string, bool) {
+	owner, ok := o.owners[absPath(path)]
+	return owner, ok
+}
+
+// PartOfCounts are the resources seen by a PartOfLabeler.
+type PartOfCounts struct {
+	// Label is the label set to the name of the owning Flux Kustomization.
+	Label string `json:"label"`
+	// Labeled is the number of resources the label was added to.
+	Labeled int `json:"labeled"`
+	// AlreadyLabeled is the number of resources which defined the label already.
+	AlreadyLabeled int `json:"alreadyLabeled"`
+	// Unowned is the number of resources without the label which are not built by a Flux Kustomization.
+	Unowned int `json:"unowned,omitempty"`
+}
+
+// PartOfLabeler sets a label to the name of the owning Flux Kustomization on resources which don't define it.
+// It is not safe for concurrent use.
+type PartOfLabeler struct {
+	counts PartOfCounts
+}
+
+// NewPartOfLabeler returns a labeler setting label, DefaultPartOfLabel is used if it is empty.
+func NewPartOfLabeler(label string) *PartOfLabeler {
+	if label == "" {
+		label = DefaultPartOfLabel
+	}
+
+	return &PartOfLabeler{counts: PartOfCounts{Label: label}}
+}
+
+// Label sets the label to owner on the resources which don't define it, owner is empty for unowned resources.
+func (l *PartOfLabeler) Label(owner string, m resmap.ResMap) error {
+	for _, res := range m.Resources() {
+		labels := res.GetLabels()
+		if _, ok := labels[l.counts.Label]; ok {
+			l.counts.AlreadyLabeled++
+			continue
+		}
+
+		if owner == "" {
+			l.counts.Unowned++
+			continue
+		}
+
+		if err := addLabels(res, map[string]string{l.counts.Label: owner}); err != nil {
+			return err
+		}
+
+		l.counts.Labeled++
+	}
+
+	return nil
+}
+
+// Counts returns the resources labeled so far.
+func (l *PartOfLabeler) Counts() PartOfCounts {
+	return l.counts
+}
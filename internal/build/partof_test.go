@@ -0,0 +1,149 @@
+package build
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"sigs.k8s.io/kustomize/api/resmap"
+)
+
+func TestOwnership(t *testing.T) {
+	g := NewWithT(t)
+
+	index := newResourceIndex(t, `apiVersion: kustomize.toolkit.fluxcd.io/v1
+kind: Kustomization
+metadata:
+  name: infrastructure
+  namespace: flux-system
+spec:
+  path: ./infrastructure
+---
+apiVersion: kustomize.toolkit.fluxcd.io/v1
+kind: Kustomization
+metadata:
+  name: apps
+  namespace: flux-system
+spec:
+  path: ./apps/production
+---
+apiVersion: kustomize.toolkit.fluxcd.io/v1
+kind: Kustomization
+metadata:
+  name: apps-copy
+  namespace: flux-system
+spec:
+  path: apps/production/
+---
+apiVersion: kustomize.config.k8s.io/v1beta1
+kind: Kustomization
+metadata:
+  name: overlay
+  namespace: flux-system
+`)
+
+	o := NewOwnership("/repository", index)
+
+	tests := []struct {
+		path        string
+		expectOwner string
+	}{
+		{path: "/repository/infrastructure", expectOwner: "infrastructure"},
+		{path: "/repository/infrastructure/", expectOwner: "infrastructure"},
+		{path: "/repository/apps/production", expectOwner: "apps"},
+		{path: "/repository/apps"},
+		{path: "/repository/infrastructure/controllers"},
+	}
+	for _, tt := range tests {
+		owner, ok := o.Owner(tt.path)
+		g.Expect(ok).To(Equal(tt.expectOwner != ""), tt.path)
+		g.Expect(owner).To(Equal(tt.expectOwner), tt.path)
+	}
+}
+
+func TestPartOfLabeler(t *testing.T) {
+	g := NewWithT(t)
+
+	manifests := `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: unlabeled
+  namespace: apps
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: labeled
+  namespace: apps
+  labels:
+    app.kubernetes.io/part-of: billing
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: podinfo
+  namespace: apps
+  labels:
+    app: podinfo
+spec:
+  selector:
+    matchLabels:
+      app: podinfo
+`
+
+	l := NewPartOfLabeler("")
+
+	owned := newResMap(t, manifests)
+	g.Expect(l.Label("apps", owned)).To(Succeed())
+	g.Expect(labelsOf(owned)).To(Equal(map[string]map[string]string{
+		"unlabeled": {"app.kubernetes.io/part-of": "apps"},
+		"labeled":   {"app.kubernetes.io/part-of": "billing"},
+		"podinfo":   {"app": "podinfo", "app.kubernetes.io/part-of": "apps"},
+	}))
+
+	deployment := owned.Resources()[2]
+	selector, err := deployment.GetFieldValue("spec.selector.matchLabels")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(selector).To(Equal(map[string]interface{}{"app": "podinfo"}))
+
+	unowned := newResMap(t, manifests)
+	g.Expect(l.Label("", unowned)).To(Succeed())
+	g.Expect(labelsOf(unowned)).To(Equal(map[string]map[string]string{
+		"unlabeled": {},
+		"labeled":   {"app.kubernetes.io/part-of": "billing"},
+		"podinfo":   {"app": "podinfo"},
+	}))
+
+	g.Expect(l.Counts()).To(Equal(PartOfCounts{
+		Label:          DefaultPartOfLabel,
+		Labeled:        2,
+		AlreadyLabeled: 2,
+		Unowned:        2,
+	}))
+
+	custom := NewPartOfLabeler("example.com/system")
+	owned = newResMap(t, manifests)
+	g.Expect(custom.Label("apps", owned)).To(Succeed())
+	g.Expect(owned.Resources()[1].GetLabels()).To(Equal(map[string]string{
+		"app.kubernetes.io/part-of": "billing",
+		"example.com/system":        "apps",
+	}))
+	g.Expect(custom.Counts()).To(Equal(PartOfCounts{Label: "example.com/system", Labeled: 3}))
+}
+
+func newResMap(t *testing.T, manifests string) resmap.ResMap {
+	m := resmap.New()
+	for _, res := range newResources(t, manifests) {
+		NewWithT(t).Expect(m.Append(res)).To(Succeed())
+	}
+
+	return m
+}
+
+func labelsOf(m resmap.ResMap) map[string]map[string]string {
+	labels := make(map[string]map[string]string)
+	for _, res := range m.Resources() {
+		labels[res.GetName()] = res.GetLabels()
+	}
+
+	return labels
+}
@@ -0,0 +1,160 @@
+package build
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/kustomize/api/resource"
+)
+
+// lookupValues looks up a ConfigMap or Secret referenced by valuesFrom. A resource generated by kustomize with a
+// hash suffix is found by the name it is declared with unless the index holds a resource of the exact name.
+// It returns nil if no resource matches and an error if several generated resources match.
+func lookupValues(db map[ref]*resource.Resource, key ref) (*resource.Resource, error) {
+	if res, ok := db[key]; ok {
+		return res, nil
+	}
+
+	var matches []*resource.Resource
+	for k, res := range db {
+		if k.GroupKind != key.GroupKind || k.Namespace != key.Namespace || !strings.HasPrefix(k.Name, key.Name+"-") {
+			continue
+		}
+
+		name, ok, err := generatedName(res)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok && name == key.Name {
+			matches = append(matches, res)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return nil, nil
+	case 1:
+		return matches[0], nil
+	}
+
+	var names []string
+	for _, res := range matches {
+		names = append(names, res.GetName())
+	}
+
+	sort.Strings(names)
+	return nil, fmt.Errorf("values `%s.%s/%s` are ambiguous, generated as %s", key.Kind, key.Namespace, key.Name, strings.Join(names, ", "))
+}
+
+// ReleasePasses orders HelmReleases into passes so their valuesFrom may reference ConfigMaps and Secrets rendered by
+// other HelmReleases. A HelmRelease is built in the first pass all of its values are indexed in. Once a pass is
+// ready to build no HelmRelease the remaining ones are blocked, their values are either missing or cyclic.
+type ReleasePasses struct {
+	index    ResourceIndex
+	pending  []*resource.Resource
+	mu       sync.Mutex
+	rendered []*resource.Resource
+}
+
+// NewReleasePasses returns the passes of the HelmReleases, rendered values are added to index between passes.
+func NewReleasePasses(index ResourceIndex, releases []*resource.Resource) *ReleasePasses {
+	return &ReleasePasses{
+		index:   index,
+		pending: releases,
+	}
+}
+
+// Next indexes the values rendered by the previous pass and returns the HelmReleases of the next pass.
+// If no pending HelmRelease is ready all of them are returned as blocked. No HelmReleases are returned once all
+// passes are done.
+func (p *ReleasePasses) Next() (releases []*resource.Resource, blocked bool, err error) {
+	p.mu.Lock()
+	rendered := p.rendered
+	p.rendered = nil
+	p.mu.Unlock()
+
+	for _, res := range rendered {
+		key, err := refOf(res)
+		if err != nil {
+			return nil, false, err
+		}
+
+		// Declared resources take precedence over rendered ones
+		if _, ok := p.index[key]; !ok {
+			p.index[key] = res
+		}
+	}
+
+	var waiting []*resource.Resource
+	for _, res := range p.pending {
+		ready, err := p.ready(res)
+		if err != nil {
+			return nil, false, err
+		}
+
+		if ready {
+			releases = append(releases, res)
+		} else {
+			waiting = append(waiting, res)
+		}
+	}
+
+	if len(releases) == 0 {
+		p.pending = nil
+		return waiting, len(waiting) > 0, nil
+	}
+
+	p.pending = waiting
+	return releases, false, nil
+}
+
+// Add records the resources rendered by a HelmRelease, its ConfigMaps and Secrets are indexed before the next pass.
+// It is safe for concurrent use.
+func (p *ReleasePasses) Add(resources []*resource.Resource) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, res := range resources {
+		if res.GetKind() == "ConfigMap" || res.GetKind() == "Secret" {
+			p.rendered = append(p.rendered, res.DeepCopy())
+		}
+	}
+}
+
+// ready reports whether all values referenced by the HelmRelease are indexed. Ambiguous values are ready as the
+// build of the HelmRelease reports them.
+func (p *ReleasePasses) ready(res *resource.Resource) (bool, error) {
+	raw, err := res.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+
+	var hr struct {
+		Spec struct {
+			ValuesFrom []helmv2.ValuesReference `json:"valuesFrom"`
+		} `json:"spec"`
+	}
+
+	if err := json.Unmarshal(raw, &hr); err != nil {
+		return false, fmt.Errorf("failed to decode values references of %s: %w", ResourceName(res.GetKind(), res.GetNamespace(), res.GetName()), err)
+	}
+
+	for _, v := range hr.Spec.ValuesFrom {
+		values, err := lookupValues(p.index, ref{
+			GroupKind: schema.GroupKind{Kind: v.Kind},
+			Name:      v.Name,
+			Namespace: res.GetNamespace(),
+		})
+		if err == nil && values == nil {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
@@ -0,0 +1,204 @@
+package build
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/kustomize/api/provider"
+	"sigs.k8s.io/kustomize/api/resmap"
+	"sigs.k8s.io/kustomize/api/resource"
+)
+
+func TestLookupValues(t *testing.T) {
+	tests := []struct {
+		name       string
+		manifests  string
+		key        string
+		expectName string
+		expectErr  string
+	}{
+		{
+			name: "exact name",
+			manifests: `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: values
+  namespace: apps
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: values-6gc9d749f7
+  namespace: apps
+data:
+  x: "y"
+`,
+			key:        "values",
+			expectName: "values",
+		},
+		{
+			name: "generated name",
+			manifests: `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: values-6gc9d749f7
+  namespace: apps
+data:
+  x: "y"
+`,
+			key:        "values",
+			expectName: "values-6gc9d749f7",
+		},
+		{
+			name: "suffix which is no content hash",
+			manifests: `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: values-abcdefghij
+  namespace: apps
+data:
+  x: "y"
+`,
+			key: "values",
+		},
+		{
+			name: "generated in another namespace",
+			manifests: `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: values-6gc9d749f7
+  namespace: other
+data:
+  x: "y"
+`,
+			key: "values",
+		},
+		{
+			name: "ambiguous generated names",
+			manifests: `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: values-6gc9d749f7
+  namespace: apps
+data:
+  x: "y"
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: values-fkm59ghtbg
+  namespace: apps
+data:
+  x: "z"
+`,
+			key:       "values",
+			expectErr: "values `ConfigMap.apps/values` are ambiguous, generated as values-6gc9d749f7, values-fkm59ghtbg",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			res, err := lookupValues(newResourceIndex(t, tt.manifests), ref{
+				GroupKind: schema.GroupKind{Kind: "ConfigMap"},
+				Name:      tt.key,
+				Namespace: "apps",
+			})
+			if tt.expectErr != "" {
+				g.Expect(err).To(MatchError(tt.expectErr))
+				return
+			}
+
+			g.Expect(err).ToNot(HaveOccurred())
+			if tt.expectName == "" {
+				g.Expect(res).To(BeNil())
+				return
+			}
+
+			g.Expect(res.GetName()).To(Equal(tt.expectName))
+		})
+	}
+}
+
+func TestReleasePasses(t *testing.T) {
+	g := NewWithT(t)
+
+	release := func(name, values string) string {
+		return `apiVersion: helm.toolkit.fluxcd.io/v2
+kind: HelmRelease
+metadata:
+  name: ` + name + `
+  namespace: apps
+spec:
+  valuesFrom:
+  - kind: ConfigMap
+    name: ` + values + `
+`
+	}
+
+	index := newResourceIndex(t, `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: values-6gc9d749f7
+  namespace: apps
+data:
+  x: "y"
+`)
+	releases := newResources(t, release("frontend", "backend-endpoints")+"---\n"+
+		release("backend", "values")+"---\n"+
+		release("worker", "missing"))
+
+	passes := NewReleasePasses(index, releases)
+
+	batch, blocked, err := passes.Next()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(blocked).To(BeFalse())
+	g.Expect(resourceNames(batch)).To(Equal([]string{"backend"}))
+
+	passes.Add(newResources(t, `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: backend-endpoints
+  namespace: apps
+data:
+  url: http://backend
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: backend
+  namespace: apps
+`))
+
+	batch, blocked, err = passes.Next()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(blocked).To(BeFalse())
+	g.Expect(resourceNames(batch)).To(Equal([]string{"frontend"}))
+	g.Expect(index).To(HaveKey(ref{GroupKind: schema.GroupKind{Kind: "ConfigMap"}, Name: "backend-endpoints", Namespace: "apps"}))
+	g.Expect(index).ToNot(HaveKey(ref{GroupKind: schema.GroupKind{Group: "apps", Kind: "Deployment"}, Name: "backend", Namespace: "apps"}))
+
+	batch, blocked, err = passes.Next()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(blocked).To(BeTrue())
+	g.Expect(resourceNames(batch)).To(Equal([]string{"worker"}))
+
+	batch, _, err = passes.Next()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(batch).To(BeEmpty())
+}
+
+func newResources(t *testing.T, manifests string) []*resource.Resource {
+	resMap, err := resmap.NewFactory(provider.NewDefaultDepProvider().GetResourceFactory()).NewResMapFromBytes([]byte(manifests))
+	NewWithT(t).Expect(err).ToNot(HaveOccurred())
+	return resMap.Resources()
+}
+
+func resourceNames(resources []*resource.Resource) []string {
+	var names []string
+	for _, res := range resources {
+		names = append(names, res.GetName())
+	}
+
+	return names
+}
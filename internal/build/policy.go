@@ -0,0 +1,226 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+	sourcev1beta2 "github.com/fluxcd/source-controller/api/v1beta2"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/kustomize/api/resource"
+)
+
+// sourceKinds are the kinds of chart sources a HelmRelease may reference.
+var sourceKinds = []string{sourcev1.HelmRepositoryKind, sourcev1.GitRepositoryKind, sourcev1beta2.BucketKind, sourcev1beta2.OCIRepositoryKind}
+
+// Decisions of a SourcePolicy.
+const (
+	SourcePolicyAllowed = "Allowed"
+	SourcePolicyDenied  = "Denied"
+	SourcePolicyExempt  = "Exempt"
+)
+
+// SourcePolicy restricts the chart sources HelmReleases may use. It is enforced before the chart is resolved.
+type SourcePolicy struct {
+	// Kinds are the allowed source kinds, all kinds are allowed if empty.
+	Kinds []string
+	// URLs are the allowed source URLs as anchored regular expressions, all URLs are allowed if empty.
+	URLs []*regexp.Regexp
+	// ExemptNamespaces are the namespaces whose HelmReleases are exempt from the policy.
+	ExemptNamespaces []string
+	// ExemptSelector selects HelmReleases which are exempt from the policy by their labels if set.
+	ExemptSelector labels.Selector
+	// Message is the policy text violating HelmReleases fail with.
+	Message string
+}
+
+// NewSourcePolicy validates the allowed source kinds and compiles the URL patterns and the exempt label selector.
+func NewSourcePolicy(kinds, urls, exemptNamespaces []string, exemptSelector, message string) (*SourcePolicy, error) {
+	p := &SourcePolicy{
+		ExemptNamespaces: exemptNamespaces,
+		Message:          message,
+	}
+
+	for _, kind := range kinds {
+		i := slices.IndexFunc(sourceKinds, func(k string) bool {
+			return strings.EqualFold(k, kind)
+		})
+		if i < 0 {
+			return nil, fmt.Errorf("invalid source kind `%s`, expected any of %s", kind, strings.Join(sourceKinds, ", "))
+		}
+
+		p.Kinds = append(p.Kinds, sourceKinds[i])
+	}
+
+	for _, url := range urls {
+		pattern, err := regexp.Compile("^(?:" + url + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid source url pattern `%s`: %w", url, err)
+		}
+
+		p.URLs = append(p.URLs, pattern)
+	}
+
+	if exemptSelector != "" {
+		selector, err := labels.Parse(exemptSelector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exempt selector of source policy: %w", err)
+		}
+
+		p.ExemptSelector = selector
+	}
+
+	if p.Message == "" {
+		p.Message = defaultSourcePolicyMessage(p.Kinds, urls)
+	}
+
+	return p, nil
+}
+
+func defaultSourcePolicyMessage(kinds, urls []string) string {
+	var rules []string
+	if len(kinds) > 0 {
+		rules = append(rules, "sources of kind "+strings.Join(kinds, ", "))
+	}
+
+	if len(urls) > 0 {
+		rules = append(rules, "source urls matching "+strings.Join(urls, ", "))
+	}
+
+	return "helmreleases may only use " + strings.Join(rules, " and ")
+}
+
+// SourcePolicyDecision is the outcome of the SourcePolicy for a single HelmRelease.
+type SourcePolicyDecision struct {
+	// Kind is the kind of the chart source.
+	Kind string `json:"kind"`
+	// URL is the URL of the chart source, empty if it is not part of the build.
+	URL string `json:"url,omitempty"`
+	// Decision is either Allowed, Denied or Exempt.
+	Decision string `json:"decision"`
+	// Reason explains denied and exempt decisions.
+	Reason string `json:"reason,omitempty"`
+}
+
+// SourcePolicyError is returned for HelmReleases which violate the SourcePolicy.
+type SourcePolicyError struct {
+	// Release is the namespace and name of the HelmRelease.
+	Release string
+	// Reason is the violated rule.
+	Reason string
+	// Message is the policy text.
+	Message string
+}
+
+func (e *SourcePolicyError) Error() string {
+	return fmt.Sprintf("helmrelease `%s` violates the source policy, %s: %s", e.Release, e.Reason, e.Message)
+}
+
+// Evaluate decides whether the HelmRelease may use its chart source. The source is looked up in db for its URL.
+func (p *SourcePolicy) Evaluate(hr *helmv2.HelmRelease, db map[ref]*resource.Resource) SourcePolicyDecision {
+	kind, namespace, name := chartSource(hr)
+	decision := SourcePolicyDecision{
+		Kind:     kind,
+		Decision: SourcePolicyAllowed,
+	}
+
+	if source, ok := db[ref{GroupKind: schema.GroupKind{Group: sourcev1.GroupVersion.Group, Kind: kind}, Name: name, Namespace: namespace}]; ok {
+		decision.URL = sourceURL(source)
+	}
+
+	switch {
+	case slices.Contains(p.ExemptNamespaces, hr.GetNamespace()):
+		decision.Decision = SourcePolicyExempt
+		decision.Reason = fmt.Sprintf("namespace %s is exempt", hr.GetNamespace())
+	case p.ExemptSelector != nil && p.ExemptSelector.Matches(labels.Set(hr.GetLabels())):
+		decision.Decision = SourcePolicyExempt
+		decision.Reason = fmt.Sprintf("labels match %s", p.ExemptSelector.String())
+	case len(p.Kinds) > 0 && !slices.Contains(p.Kinds, kind):
+		decision.Decision = SourcePolicyDenied
+		decision.Reason = fmt.Sprintf("source kind %s is not allowed", kind)
+	case len(p.URLs) > 0 && !slices.ContainsFunc(p.URLs, func(url *regexp.Regexp) bool {
+		return url.MatchString(decision.URL)
+	}):
+		decision.Decision = SourcePolicyDenied
+		decision.Reason = fmt.Sprintf("source url `%s` of %s is not allowed", decision.URL, ResourceName(kind, namespace, name))
+	}
+
+	return decision
+}
+
+// chartSource returns the kind, namespace and name of the chart source of the HelmRelease.
+func chartSource(hr *helmv2.HelmRelease) (string, string, string) {
+	if hr.HasChartRef() {
+		namespace := hr.Spec.ChartRef.Namespace
+		if namespace == "" {
+			namespace = hr.GetNamespace()
+		}
+
+		return hr.Spec.ChartRef.Kind, namespace, hr.Spec.ChartRef.Name
+	}
+
+	if hr.Spec.Chart == nil {
+		return "", hr.GetNamespace(), ""
+	}
+
+	namespace := hr.Spec.Chart.Spec.SourceRef.Namespace
+	if namespace == "" {
+		namespace = hr.GetNamespace()
+	}
+
+	return hr.Spec.Chart.Spec.SourceRef.Kind, namespace, hr.Spec.Chart.Spec.SourceRef.Name
+}
+
+// sourceURL returns spec.url of the source, or spec.endpoint and spec.bucketName of a Bucket.
+func sourceURL(source *resource.Resource) string {
+	if source.GetKind() == sourcev1beta2.BucketKind {
+		endpoint, _ := source.GetString("spec.endpoint")
+		bucket, _ := source.GetString("spec.bucketName")
+		return strings.TrimSuffix(endpoint, "/") + "/" + bucket
+	}
+
+	url, _ := source.GetString("spec.url")
+	return url
+}
+
+// enforceSourcePolicy evaluates the SourcePolicy for the HelmRelease and records the decision if the context
+// carries a SourcePolicyDecision, see WithSourcePolicyDecision.
+func (h *Helm) enforceSourcePolicy(ctx context.Context, hr *helmv2.HelmRelease, db map[ref]*resource.Resource) error {
+	if h.opts.SourcePolicy == nil {
+		return nil
+	}
+
+	decision := h.opts.SourcePolicy.Evaluate(hr, db)
+	if d := sourcePolicyDecisionFrom(ctx); d != nil {
+		*d = decision
+	}
+
+	h.logger(ctx).V(1).Info("source policy decision", "kind", decision.Kind, "url", decision.URL, "decision", decision.Decision, "reason", decision.Reason)
+	if decision.Decision != SourcePolicyDenied {
+		return nil
+	}
+
+	return &SourcePolicyError{
+		Release: hr.GetNamespace() + "/" + hr.GetName(),
+		Reason:  decision.Reason,
+		Message: h.opts.SourcePolicy.Message,
+	}
+}
+
+type sourcePolicyDecisionKey struct{}
+
+// WithSourcePolicyDecision returns a context which records the SourcePolicy decision of the HelmRelease built with
+// it into d.
+func WithSourcePolicyDecision(ctx context.Context, d *SourcePolicyDecision) context.Context {
+	return context.WithValue(ctx, sourcePolicyDecisionKey{}, d)
+}
+
+func sourcePolicyDecisionFrom(ctx context.Context) *SourcePolicyDecision {
+	d, _ := ctx.Value(sourcePolicyDecisionKey{}).(*SourcePolicyDecision)
+	return d
+}
@@ -0,0 +1,164 @@
+package build
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"github.com/go-logr/logr"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSourcePolicy(t *testing.T) {
+	db := `apiVersion: source.toolkit.fluxcd.io/v1
+kind: GitRepository
+metadata:
+  name: charts
+  namespace: apps
+spec:
+  url: https://github.com/org/charts
+---
+apiVersion: source.toolkit.fluxcd.io/v1
+kind: HelmRepository
+metadata:
+  name: internal
+  namespace: apps
+spec:
+  type: oci
+  url: oci://ghcr.io/org/charts
+---
+apiVersion: source.toolkit.fluxcd.io/v1
+kind: HelmRepository
+metadata:
+  name: public
+  namespace: apps
+spec:
+  url: https://charts.example.com
+---
+apiVersion: source.toolkit.fluxcd.io/v1beta2
+kind: OCIRepository
+metadata:
+  name: podinfo
+  namespace: apps
+spec:
+  url: oci://ghcr.io/org/podinfo
+`
+
+	newRelease := func(kind, name string, labels map[string]string) *helmv2.HelmRelease {
+		hr := &helmv2.HelmRelease{
+			ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "apps", Labels: labels},
+		}
+
+		if kind == "OCIRepository" {
+			hr.Spec.ChartRef = &helmv2.CrossNamespaceSourceReference{Kind: kind, Name: name}
+			return hr
+		}
+
+		hr.Spec.Chart = &helmv2.HelmChartTemplate{
+			Spec: helmv2.HelmChartTemplateSpec{
+				Chart:     "app",
+				SourceRef: helmv2.CrossNamespaceObjectReference{Kind: kind, Name: name},
+			},
+		}
+		return hr
+	}
+
+	tests := []struct {
+		name             string
+		kinds            []string
+		urls             []string
+		exemptNamespaces []string
+		exemptSelector   string
+		message          string
+		hr               *helmv2.HelmRelease
+		expectDecision   SourcePolicyDecision
+		expectErr        string
+	}{
+		{
+			name:           "allowed kind",
+			kinds:          []string{"ocirepository", "HelmRepository"},
+			hr:             newRelease("OCIRepository", "podinfo", nil),
+			expectDecision: SourcePolicyDecision{Kind: "OCIRepository", URL: "oci://ghcr.io/org/podinfo", Decision: SourcePolicyAllowed},
+		},
+		{
+			name:           "denied kind",
+			kinds:          []string{"OCIRepository", "HelmRepository"},
+			hr:             newRelease("GitRepository", "charts", nil),
+			expectDecision: SourcePolicyDecision{Kind: "GitRepository", URL: "https://github.com/org/charts", Decision: SourcePolicyDenied, Reason: "source kind GitRepository is not allowed"},
+			expectErr:      "helmrelease `apps/app` violates the source policy, source kind GitRepository is not allowed: helmreleases may only use sources of kind OCIRepository, HelmRepository",
+		},
+		{
+			name:           "allowed url",
+			urls:           []string{`oci://ghcr\.io/org/.*`},
+			hr:             newRelease("HelmRepository", "internal", nil),
+			expectDecision: SourcePolicyDecision{Kind: "HelmRepository", URL: "oci://ghcr.io/org/charts", Decision: SourcePolicyAllowed},
+		},
+		{
+			name:           "denied url",
+			kinds:          []string{"HelmRepository"},
+			urls:           []string{`oci://ghcr\.io/org/.*`},
+			message:        "only signed OCI charts are allowed in production, see the platform handbook",
+			hr:             newRelease("HelmRepository", "public", nil),
+			expectDecision: SourcePolicyDecision{Kind: "HelmRepository", URL: "https://charts.example.com", Decision: SourcePolicyDenied, Reason: "source url `https://charts.example.com` of HelmRepository/apps/public is not allowed"},
+			expectErr:      "helmrelease `apps/app` violates the source policy, source url `https://charts.example.com` of HelmRepository/apps/public is not allowed: only signed OCI charts are allowed in production, see the platform handbook",
+		},
+		{
+			name:           "urls are anchored",
+			urls:           []string{`oci://ghcr\.io/org`},
+			hr:             newRelease("HelmRepository", "internal", nil),
+			expectDecision: SourcePolicyDecision{Kind: "HelmRepository", URL: "oci://ghcr.io/org/charts", Decision: SourcePolicyDenied, Reason: "source url `oci://ghcr.io/org/charts` of HelmRepository/apps/internal is not allowed"},
+			expectErr:      "helmreleases may only use source urls matching oci://ghcr\\.io/org",
+		},
+		{
+			name:             "exempt namespace",
+			kinds:            []string{"OCIRepository"},
+			exemptNamespaces: []string{"apps"},
+			hr:               newRelease("GitRepository", "charts", nil),
+			expectDecision:   SourcePolicyDecision{Kind: "GitRepository", URL: "https://github.com/org/charts", Decision: SourcePolicyExempt, Reason: "namespace apps is exempt"},
+		},
+		{
+			name:           "exempt labels",
+			kinds:          []string{"OCIRepository"},
+			exemptSelector: "policy.example.com/exempt=true",
+			hr:             newRelease("GitRepository", "charts", map[string]string{"policy.example.com/exempt": "true"}),
+			expectDecision: SourcePolicyDecision{Kind: "GitRepository", URL: "https://github.com/org/charts", Decision: SourcePolicyExempt, Reason: "labels match policy.example.com/exempt=true"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			policy, err := NewSourcePolicy(tt.kinds, tt.urls, tt.exemptNamespaces, tt.exemptSelector, tt.message)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			h := NewHelmBuilder(logr.Discard(), HelmOpts{SourcePolicy: policy})
+			decision := &SourcePolicyDecision{}
+			err = h.enforceSourcePolicy(WithSourcePolicyDecision(context.Background(), decision), tt.hr, newResourceIndex(t, db))
+			g.Expect(*decision).To(Equal(tt.expectDecision))
+
+			if tt.expectErr == "" {
+				g.Expect(err).ToNot(HaveOccurred())
+				return
+			}
+
+			g.Expect(err).To(MatchError(ContainSubstring(tt.expectErr)))
+			var policyErr *SourcePolicyError
+			g.Expect(errors.As(err, &policyErr)).To(BeTrue())
+		})
+	}
+}
+
+func TestNewSourcePolicyInvalid(t *testing.T) {
+	g := NewWithT(t)
+
+	_, err := NewSourcePolicy([]string{"HelmChart"}, nil, nil, "", "")
+	g.Expect(err).To(MatchError("invalid source kind `HelmChart`, expected any of HelmRepository, GitRepository, Bucket, OCIRepository"))
+
+	_, err = NewSourcePolicy(nil, []string{"oci://("}, nil, "", "")
+	g.Expect(err).To(MatchError(ContainSubstring("invalid source url pattern `oci://(`")))
+
+	_, err = NewSourcePolicy([]string{"OCIRepository"}, nil, nil, "a in (", "")
+	g.Expect(err).To(MatchError(ContainSubstring("invalid exempt selector of source policy")))
+}
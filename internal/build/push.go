@@ -0,0 +1,203 @@
+package build
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/doodlescheduling/flux-build/internal/transport"
+	"github.com/fluxcd/pkg/oci"
+	sourcev1beta2 "github.com/fluxcd/source-controller/api/v1beta2"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"sigs.k8s.io/kustomize/api/resmap"
+)
+
+// ManifestsFile is the name of the file within the artifact which holds the rendered resources.
+const ManifestsFile = "manifests.yaml"
+
+// PushOptions configures how PushManifests publishes the rendered resources.
+type PushOptions struct {
+	// Provider is the cloud provider (aws, azure or gcp) the registry credentials are exchanged with
+	Provider string
+	// NoDefaultKeychain disables the docker config credentials if no provider is set
+	NoDefaultKeychain bool
+	// Insecure pushes via plain HTTP
+	Insecure bool
+	// Proxy is the HTTP proxy used to access the registry
+	Proxy *url.URL
+	// TLSPolicy restricts the TLS connection to the registry
+	TLSPolicy *TLSPolicy
+	// Source is recorded as org.opencontainers.image.source, for instance the URL of the git repository
+	Source string
+	// Revision is recorded as org.opencontainers.image.revision, for instance the branch and commit sha
+	Revision string
+	// Created is recorded as org.opencontainers.image.created, defaults to the current time.
+	// A fixed time results in the same digest for the same resources.
+	Created time.Time
+	// Annotations are added to the manifest of the artifact, the source, revision and created annotations
+	// take precedence
+	Annotations map[string]string
+}
+
+// PushResult references the pushed artifact.
+type PushResult struct {
+	// Tag is the tag the artifact was pushed to
+	Tag name.Tag
+	// Digest is the immutable reference of the artifact
+	Digest name.Digest
+}
+
+// PushManifests packages the resources into a tarball and pushes it as Flux OCI artifact to the tag ref
+// (for instance oci://ghcr.io/org/manifests:v1.0.0). The artifact can be consumed by a Flux OCIRepository.
+// Registry credentials are resolved like the ones of OCIRepositories without a secretRef, either by the provider
+// or the default keychain.
+func PushManifests(ctx context.Context, ref string, rm resmap.ResMap, opts PushOptions) (PushResult, error) {
+	var nameOpts []name.Option
+	if opts.Insecure {
+		nameOpts = append(nameOpts, name.Insecure)
+	}
+
+	tag, err := name.NewTag(strings.TrimPrefix(ref, sourcev1beta2.OCIRepositoryPrefix), nameOpts...)
+	if err != nil {
+		return PushResult{}, fmt.Errorf("invalid artifact reference `%s`, a tag is required: %w", ref, err)
+	}
+
+	remoteOpts, err := pushRemoteOptions(ctx, ref, opts)
+	if err != nil {
+		return PushResult{}, err
+	}
+
+	img, err := manifestsArtifact(rm, opts)
+	if err != nil {
+		return PushResult{}, fmt.Errorf("failed to package artifact %s: %w", tag, err)
+	}
+
+	if err := remote.Write(tag, img, remoteOpts...); err != nil {
+		return PushResult{}, fmt.Errorf("failed to push artifact %s: %w", tag, err)
+	}
+
+	digest, err := img.Digest()
+	if err != nil {
+		return PushResult{}, err
+	}
+
+	return PushResult{
+		Tag:    tag,
+		Digest: tag.Context().Digest(digest.String()),
+	}, nil
+}
+
+func pushRemoteOptions(ctx context.Context, ref string, opts PushOptions) ([]remote.Option, error) {
+	remoteOpts := []remote.Option{remote.WithContext(ctx)}
+
+	switch {
+	case opts.Provider != "" && opts.Provider != sourcev1beta2.GenericOCIProvider:
+		auth, err := oidcAuth(ctx, ref, opts.Provider)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get credential from %s: %w", opts.Provider, err)
+		}
+
+		remoteOpts = append(remoteOpts, remote.WithAuth(auth))
+	case !opts.NoDefaultKeychain:
+		remoteOpts = append(remoteOpts, remote.WithAuthFromKeychain(authn.DefaultKeychain))
+	}
+
+	if opts.Proxy != nil || opts.TLSPolicy != nil {
+		t := remote.DefaultTransport.(*http.Transport).Clone()
+		if opts.Proxy != nil {
+			t.Proxy = transport.NewProxyFunc(opts.Proxy)
+		}
+		if opts.TLSPolicy != nil {
+			t.TLSClientConfig = opts.TLSPolicy.Config()
+		}
+
+		remoteOpts = append(remoteOpts, remote.WithTransport(t))
+	}
+
+	return remoteOpts, nil
+}
+
+// manifestsArtifact builds the artifact the same way as flux push artifact does, the resources are written to
+// ManifestsFile of a gzip compressed tarball.
+func manifestsArtifact(rm resmap.ResMap, opts PushOptions) (v1.Image, error) {
+	manifests, err := rm.AsYaml()
+	if err != nil {
+		return nil, err
+	}
+
+	created := opts.Created
+	if created.IsZero() {
+		created = time.Now()
+	}
+
+	layer, err := tarManifests(manifests, created)
+	if err != nil {
+		return nil, err
+	}
+
+	annotations := make(map[string]string, len(opts.Annotations)+3)
+	for k, v := range opts.Annotations {
+		annotations[k] = v
+	}
+
+	annotations[oci.CreatedAnnotation] = created.UTC().Format(time.RFC3339)
+	if opts.Source != "" {
+		annotations[oci.SourceAnnotation] = opts.Source
+	}
+	if opts.Revision != "" {
+		annotations[oci.RevisionAnnotation] = opts.Revision
+	}
+
+	img := mutate.MediaType(empty.Image, types.OCIManifestSchema1)
+	img = mutate.ConfigMediaType(img, oci.CanonicalConfigMediaType)
+	img = mutate.Annotations(img, annotations).(v1.Image)
+
+	return mutate.Append(img, mutate.Addendum{
+		Layer: static.NewLayer(layer, oci.CanonicalContentMediaType),
+	})
+}
+
+func tarManifests(manifests []byte, modTime time.Time) ([]byte, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+
+	header := &tar.Header{
+		Name:     ManifestsFile,
+		Typeflag: tar.TypeReg,
+		Mode:     0o644,
+		Size:     int64(len(manifests)),
+		ModTime:  modTime.UTC().Truncate(time.Second),
+	}
+
+	if err := tw.WriteHeader(header); err != nil {
+		return nil, err
+	}
+
+	if _, err := tw.Write(manifests); err != nil {
+		return nil, err
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
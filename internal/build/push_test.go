@@ -0,0 +1,119 @@
+package build
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	soci "github.com/doodlescheduling/flux-build/internal/oci"
+	"github.com/fluxcd/pkg/oci"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	. "github.com/onsi/gomega"
+	"sigs.k8s.io/kustomize/api/provider"
+	"sigs.k8s.io/kustomize/api/resmap"
+)
+
+func TestPushManifests(t *testing.T) {
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	manifests := `apiVersion: v1
+data:
+  key: value
+kind: ConfigMap
+metadata:
+  name: podinfo
+  namespace: apps
+`
+	m, err := resmap.NewFactory(provider.NewDefaultDepProvider().GetResourceFactory()).NewResMapFromBytes([]byte(manifests))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	opts := PushOptions{
+		Insecure:          true,
+		NoDefaultKeychain: true,
+		Source:            "https://github.com/org/fleet",
+		Revision:          "main@sha1:8766915",
+		Created:           created,
+		Annotations:       map[string]string{"org.opencontainers.image.title": "apps"},
+	}
+
+	tests := []struct {
+		name      string
+		ref       string
+		expectErr string
+	}{
+		{
+			name: "tag",
+			ref:  "oci://" + host + "/org/manifests:v1.0.0",
+		},
+		{
+			name: "without scheme",
+			ref:  host + "/org/manifests:v1.0.1",
+		},
+		{
+			name:      "digest",
+			ref:       "oci://" + host + "/org/manifests@sha256:0000000000000000000000000000000000000000000000000000000000000000",
+			expectErr: "a tag is required",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			result, err := PushManifests(context.Background(), tt.ref, m, opts)
+			if tt.expectErr != "" {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tt.expectErr))
+				return
+			}
+
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(result.Tag.String()).To(Equal(strings.TrimPrefix(tt.ref, "oci://")))
+
+			img, err := remote.Image(result.Digest)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			manifest, err := img.Manifest()
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(manifest.Config.MediaType).To(Equal(oci.CanonicalConfigMediaType))
+			g.Expect(manifest.Layers).To(HaveLen(1))
+			g.Expect(manifest.Layers[0].MediaType).To(Equal(oci.CanonicalContentMediaType))
+			g.Expect(manifest.Annotations).To(Equal(map[string]string{
+				"org.opencontainers.image.title": "apps",
+				oci.SourceAnnotation:             "https://github.com/org/fleet",
+				oci.RevisionAnnotation:           "main@sha1:8766915",
+				oci.CreatedAnnotation:            "2024-01-01T00:00:00Z",
+			}))
+
+			dir := t.TempDir()
+			digest, err := soci.PullArtifact(context.Background(), "oci://"+result.Digest.String(), dir, true)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(digest).To(Equal(result.Digest))
+
+			b, err := os.ReadFile(filepath.Join(dir, ManifestsFile))
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(string(b)).To(Equal(manifests))
+		})
+	}
+
+	t.Run("reproducible", func(t *testing.T) {
+		g := NewWithT(t)
+
+		first, err := PushManifests(context.Background(), host+"/org/manifests:a", m, opts)
+		g.Expect(err).ToNot(HaveOccurred())
+		second, err := PushManifests(context.Background(), host+"/org/manifests:b", m, opts)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(second.Digest.DigestStr()).To(Equal(first.Digest.DigestStr()))
+	})
+}
@@ -0,0 +1,372 @@
+package build
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/doodlescheduling/flux-build/internal/cachemgr"
+	"github.com/doodlescheduling/flux-build/internal/helm/chart"
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"github.com/fluxcd/pkg/apis/meta"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+	"github.com/go-logr/logr"
+	"github.com/google/go-containerregistry/pkg/registry"
+	. "github.com/onsi/gomega"
+	helmchart "helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	helmreg "helm.sh/helm/v3/pkg/registry"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// plainHTTPRegistry is a registry serving plain HTTP with testChart pushed as charts/helmchart.
+// Helm talks plain HTTP to registries on localhost, the registry is accessed as example.com through the proxy URL
+// to test the plain HTTP settings, it answers the proxied requests itself and refuses to tunnel TLS connections.
+type plainHTTPRegistry struct {
+	// host is the address of the registry for direct access, the login of Helm never uses a proxy
+	host     string
+	proxyURL *url.URL
+	// authorized are the requests which carried credentials
+	authorized atomic.Int32
+}
+
+// newPlainHTTPRegistry starts a plainHTTPRegistry, pulls require the basic auth credentials unless username is empty.
+func newPlainHTTPRegistry(t *testing.T, username, password string) *plainHTTPRegistry {
+	r := &plainHTTPRegistry{}
+	var pushed atomic.Bool
+
+	reg := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodConnect {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		user, pass, ok := req.BasicAuth()
+		if ok {
+			r.authorized.Add(1)
+		}
+
+		if pushed.Load() && username != "" && (user != username || pass != password) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		reg.ServeHTTP(w, req)
+	}))
+	t.Cleanup(server.Close)
+
+	proxyURL, err := url.Parse(server.URL)
+	NewWithT(t).Expect(err).ToNot(HaveOccurred())
+	r.proxyURL = proxyURL
+	r.host = proxyURL.Host
+
+	pushClient, err := helmreg.NewClient(
+		helmreg.ClientOptHTTPClient(&http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}),
+		helmreg.ClientOptPlainHTTP(),
+		helmreg.ClientOptWriter(io.Discard))
+	NewWithT(t).Expect(err).ToNot(HaveOccurred())
+
+	archive, err := os.ReadFile(testChart)
+	NewWithT(t).Expect(err).ToNot(HaveOccurred())
+	_, err = pushClient.Push(archive, "example.com/charts/helmchart:0.1.0")
+	NewWithT(t).Expect(err).ToNot(HaveOccurred())
+	pushed.Store(true)
+
+	return r
+}
+
+func TestBuildChartInsecureRegistry(t *testing.T) {
+	proxyURL := newPlainHTTPRegistry(t, "", "").proxyURL
+
+	tests := []struct {
+		name               string
+		insecure           bool
+		insecureRegistries []string
+		expectErr          bool
+	}{
+		{
+			name:     "spec.insecure",
+			insecure: true,
+		},
+		{
+			name:               "insecure registries",
+			insecureRegistries: []string{"example.com"},
+		},
+		{
+			name:               "other insecure registry",
+			insecureRegistries: []string{"example.org"},
+			expectErr:          true,
+		},
+		{
+			name:      "secure",
+			expectErr: true,
+		},
+	}
+	retryMax := 0
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			cache, err := cachemgr.New("none", "")
+			g.Expect(err).ToNot(HaveOccurred())
+			h := NewHelmBuilder(logr.Discard(), HelmOpts{
+				Cache:              cache,
+				Proxy:              proxyURL,
+				InsecureRegistries: tt.insecureRegistries,
+				NoDefaultKeychain:  true,
+				RetryMax:           &retryMax,
+			})
+
+			repo := &sourcev1.HelmRepository{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "charts",
+					Namespace: "default",
+				},
+				Spec: sourcev1.HelmRepositorySpec{
+					URL:      "oci://example.com/charts",
+					Type:     sourcev1.HelmRepositoryTypeOCI,
+					Insecure: tt.insecure,
+				},
+			}
+
+			hr := helmv2.HelmRelease{
+				Spec: helmv2.HelmReleaseSpec{
+					Chart: &helmv2.HelmChartTemplate{
+						Spec: helmv2.HelmChartTemplateSpec{
+							Chart:   "helmchart",
+							Version: "0.1.0",
+						},
+					},
+				},
+			}
+
+			build := &chart.Build{}
+			err = h.buildChart(context.Background(), repo, hr, nil, build, nil)
+			if tt.expectErr {
+				g.Expect(err).To(MatchError(ContainSubstring("Method Not Allowed")))
+				return
+			}
+
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(build.Name).To(Equal("helmchart"))
+			g.Expect(build.Version).To(Equal("0.1.0"))
+		})
+	}
+}
+
+func TestLoadChartInsecureRegistryDependency(t *testing.T) {
+	proxyURL := newPlainHTTPRegistry(t, "", "").proxyURL
+
+	parent := &helmchart.Chart{
+		Metadata: &helmchart.Metadata{
+			APIVersion: helmchart.APIVersionV2,
+			Name:       "parent",
+			Version:    "1.0.0",
+			Dependencies: []*helmchart.Dependency{
+				{
+					Name:       "helmchart",
+					Version:    "0.1.0",
+					Repository: "oci://example.com/charts",
+				},
+			},
+		},
+	}
+
+	newRepository := func(insecure bool) string {
+		repo := `apiVersion: source.toolkit.fluxcd.io/v1
+kind: HelmRepository
+metadata:
+  name: charts
+  namespace: default
+spec:
+  type: oci
+  url: oci://example.com/charts
+`
+		if insecure {
+			repo += "  insecure: true\n"
+		}
+
+		return repo
+	}
+
+	tests := []struct {
+		name               string
+		db                 string
+		insecureRegistries []string
+		expectErr          bool
+	}{
+		{
+			name: "spec.insecure",
+			db:   newRepository(true),
+		},
+		{
+			name:               "insecure registries",
+			db:                 newRepository(false),
+			insecureRegistries: []string{"example.com"},
+		},
+		{
+			name:      "secure",
+			db:        newRepository(false),
+			expectErr: true,
+		},
+	}
+	retryMax := 0
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			path, err := chartutil.Save(parent, t.TempDir())
+			g.Expect(err).ToNot(HaveOccurred())
+
+			cache, err := cachemgr.New("none", "")
+			g.Expect(err).ToNot(HaveOccurred())
+			h := NewHelmBuilder(logr.Discard(), HelmOpts{
+				Cache:              cache,
+				Proxy:              proxyURL,
+				InsecureRegistries: tt.insecureRegistries,
+				NoDefaultKeychain:  true,
+				RetryMax:           &retryMax,
+			})
+
+			loaded, err := h.loadChart(context.Background(), &chart.Build{Name: "parent", Version: "1.0.0", Path: path}, helmv2.HelmChartTemplateSpec{}, newResourceIndex(t, tt.db))
+			if tt.expectErr {
+				g.Expect(err).To(MatchError(ContainSubstring("Method Not Allowed")))
+				return
+			}
+
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(loaded.Dependencies()).To(HaveLen(1))
+			g.Expect(loaded.Dependencies()[0].Name()).To(Equal("helmchart"))
+		})
+	}
+}
+
+func TestBuildChartDefaultKeychain(t *testing.T) {
+	dockerConfig := func(host, username, password string) string {
+		auth := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+		return fmt.Sprintf(`{"auths": {"%s": {"auth": "%s"}}}`, host, auth)
+	}
+
+	tests := []struct {
+		name              string
+		username          string
+		dockerConfig      string
+		noDefaultKeychain bool
+		secret            string
+		expectErr         string
+		expectAuthorized  bool
+	}{
+		{
+			name:             "docker config",
+			username:         "user",
+			dockerConfig:     dockerConfig("registry", "user", "token"),
+			expectAuthorized: true,
+		},
+		{
+			name:              "no default keychain",
+			username:          "user",
+			dockerConfig:      dockerConfig("registry", "user", "token"),
+			noDefaultKeychain: true,
+			expectErr:         "no basic auth credentials",
+		},
+		{
+			// The docker config holds no credentials for the registry, the keychain resolves to anonymous
+			name:         "anonymous",
+			dockerConfig: dockerConfig("ghcr.io", "user", "token"),
+		},
+		{
+			name:         "secret ref takes precedence",
+			username:     "robot",
+			dockerConfig: dockerConfig("registry", "user", "token"),
+			secret: `apiVersion: v1
+kind: Secret
+metadata:
+  name: credentials
+  namespace: default
+stringData:
+  username: robot
+  password: secret
+`,
+			expectAuthorized: true,
+		},
+	}
+	retryMax := 0
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			password := "token"
+			if tt.secret != "" {
+				password = "secret"
+			}
+			reg := newPlainHTTPRegistry(t, tt.username, password)
+
+			dir := t.TempDir()
+			config := strings.ReplaceAll(tt.dockerConfig, `"registry"`, `"`+reg.host+`"`)
+			g.Expect(os.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0600)).To(Succeed())
+			t.Setenv("DOCKER_CONFIG", dir)
+
+			cache, err := cachemgr.New("none", "")
+			g.Expect(err).ToNot(HaveOccurred())
+			h := NewHelmBuilder(logr.Discard(), HelmOpts{
+				Cache:             cache,
+				NoDefaultKeychain: tt.noDefaultKeychain,
+				RetryMax:          &retryMax,
+			})
+
+			repo := &sourcev1.HelmRepository{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "charts",
+					Namespace: "default",
+				},
+				Spec: sourcev1.HelmRepositorySpec{
+					URL:      "oci://" + reg.host + "/charts",
+					Type:     sourcev1.HelmRepositoryTypeOCI,
+					Insecure: true,
+				},
+			}
+
+			var db ResourceIndex
+			if tt.secret != "" {
+				repo.Spec.SecretRef = &meta.LocalObjectReference{Name: "credentials"}
+				db = newResourceIndex(t, tt.secret)
+			}
+
+			hr := helmv2.HelmRelease{
+				Spec: helmv2.HelmReleaseSpec{
+					Chart: &helmv2.HelmChartTemplate{
+						Spec: helmv2.HelmChartTemplateSpec{
+							Chart:   "helmchart",
+							Version: "0.1.0",
+						},
+					},
+				},
+			}
+
+			build := &chart.Build{}
+			err = h.buildChart(context.Background(), repo, hr, nil, build, db)
+			if tt.expectErr != "" {
+				g.Expect(err).To(MatchError(ContainSubstring(tt.expectErr)))
+				return
+			}
+
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(build.Version).To(Equal("0.1.0"))
+
+			// Without credentials there is no login and no request carries any
+			g.Expect(reg.authorized.Load() > 0).To(Equal(tt.expectAuthorized))
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package build
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+
+	"github.com/doodlescheduling/flux-build/internal/helm/repository"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+	"sigs.k8s.io/kustomize/api/resource"
+)
+
+// RepositoryTypeAnnotation declares a HelmRepository as a repository of release assets instead of a Helm repository
+// with an index, for instance github-releases, see repository.ReleaseAssetProvider. It requires HelmOpts.Extensions.
+const RepositoryTypeAnnotation = "flux-build.doodlescheduling.com/repository-type"
+
+// ReleaseAssetAnnotation overrides the name of the chart archive attached to the releases of a release asset
+// repository, see repository.DefaultReleaseAsset.
+const ReleaseAssetAnnotation = "flux-build.doodlescheduling.com/release-asset"
+
+// releaseAssetProvider returns the provider of the release assets the HelmRepository is declared with by the
+// RepositoryTypeAnnotation, it is empty for Helm repositories.
+func (h *Helm) releaseAssetProvider(repo *sourcev1.HelmRepository) (repository.ReleaseAssetProvider, error) {
+	repositoryType, ok := repo.GetAnnotations()[RepositoryTypeAnnotation]
+	if !ok {
+		return "", nil
+	}
+
+	provider, err := repository.ParseReleaseAssetProvider(repositoryType)
+	if err != nil {
+		return "", fmt.Errorf("invalid annotation %s of helmrepository `%s/%s`: %w", RepositoryTypeAnnotation, repo.Namespace, repo.Name, err)
+	}
+
+	if !h.opts.Extensions {
+		return "", fmt.Errorf("helmrepository `%s/%s` is of the repository type %s which is an extension of flux-build, extensions are not enabled", repo.Namespace, repo.Name, provider)
+	}
+
+	return provider, nil
+}
+
+// releaseAssetRepository returns the repository of the release assets of the project the HelmRepository points to.
+// The token of the API is the key token, or password, of the secret of spec.secretRef.
+func (h *Helm) releaseAssetRepository(ctx context.Context, repo *sourcev1.HelmRepository, provider repository.ReleaseAssetProvider, tlsConfig *tls.Config, db map[ref]*resource.Resource) (*repository.ReleaseAssetRepository, error) {
+	assetRepo, err := repository.NewReleaseAssetRepository(repo.Spec.URL, provider, tlsConfig)
+	if err != nil {
+		return nil, fmt.Errorf("invalid release asset repository of helmrepository `%s/%s`: %w", repo.Namespace, repo.Name, err)
+	}
+
+	secret, err := h.getHelmRepositorySecret(ctx, repo, db)
+	if err != nil {
+		return nil, err
+	}
+
+	if secret != nil {
+		for _, key := range []string{"token", "password"} {
+			token, ok := secret.StringData[key]
+			if !ok {
+				token = string(secret.Data[key])
+			}
+
+			if token != "" {
+				assetRepo.Token = token
+				break
+			}
+		}
+	}
+
+	assetRepo.Asset = repo.GetAnnotations()[ReleaseAssetAnnotation]
+	assetRepo.Timeout = h.repositoryTimeout(repo, repo.Spec.URL)
+	assetRepo.Proxy = h.proxy
+	assetRepo.Transports = h.transports
+	assetRepo.Logger = h.logger(ctx)
+
+	return assetRepo, nil
+}
@@ -0,0 +1,108 @@
+package build
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/doodlescheduling/flux-build/internal/cachemgr"
+	"github.com/doodlescheduling/flux-build/internal/helm/chart"
+	"github.com/doodlescheduling/flux-build/internal/helm/repository"
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"github.com/fluxcd/pkg/apis/meta"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+	"github.com/go-logr/logr"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestBuildChartFromReleaseAssets(t *testing.T) {
+	g := NewWithT(t)
+
+	archive, err := os.ReadFile(testChart)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/api/v3/repos/vendor/charts/releases":
+			_ = json.NewEncoder(w).Encode([]map[string]interface{}{
+				{"tag_name": "v0.1.0", "assets": []map[string]string{{"name": "helmchart-0.1.0.tgz", "url": server.URL + "/assets/1"}}},
+				{"tag_name": "v0.2.0", "assets": []map[string]string{{"name": "helmchart-0.2.0.tgz", "url": server.URL + "/assets/2"}}},
+			})
+		case "/assets/1":
+			_, _ = w.Write(archive)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	repo := &sourcev1.HelmRepository{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "vendor",
+			Namespace: "default",
+			Annotations: map[string]string{
+				RepositoryTypeAnnotation: string(repository.ReleaseAssetProviderGitHub),
+			},
+		},
+		Spec: sourcev1.HelmRepositorySpec{
+			URL:       server.URL + "/vendor/charts",
+			SecretRef: &meta.LocalObjectReference{Name: "github-token"},
+		},
+	}
+
+	db := newResourceIndex(t, `apiVersion: v1
+kind: Secret
+metadata:
+  name: github-token
+  namespace: default
+stringData:
+  token: token
+`)
+
+	hr := helmv2.HelmRelease{
+		Spec: helmv2.HelmReleaseSpec{
+			Chart: &helmv2.HelmChartTemplate{
+				Spec: helmv2.HelmChartTemplateSpec{
+					Chart:   "helmchart",
+					Version: "<0.2.0",
+					SourceRef: helmv2.CrossNamespaceObjectReference{
+						Kind: sourcev1.HelmRepositoryKind,
+						Name: "vendor",
+					},
+				},
+			},
+		},
+	}
+
+	dir := t.TempDir()
+	cache, err := cachemgr.New("fs", dir)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	h := NewHelmBuilder(logr.Discard(), HelmOpts{Cache: cache})
+	err = h.buildChart(context.Background(), repo, hr, nil, &chart.Build{}, db)
+	g.Expect(err).To(MatchError("helmrepository `default/vendor` is of the repository type github-releases which is an extension of flux-build, extensions are not enabled"))
+
+	h = NewHelmBuilder(logr.Discard(), HelmOpts{Cache: cache, Extensions: true})
+	build := &chart.Build{}
+	g.Expect(h.buildChart(context.Background(), repo, hr, nil, build, db)).To(Succeed())
+	g.Expect(build.Name).To(Equal("helmchart"))
+	g.Expect(build.Version).To(Equal("0.1.0"))
+	g.Expect(build.Digest).To(Equal(chart.ArchiveDigest(archive)))
+
+	// The chart is cached by the URL of the project like the chart of any other repository
+	entries, err := cachemgr.ListEntries(dir)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(entries).To(HaveLen(1))
+	g.Expect(entries[0].Repository).To(Equal(server.URL + "/vendor/charts/"))
+	g.Expect(entries[0].Chart).To(Equal("helmchart"))
+}
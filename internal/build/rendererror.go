@@ -0,0 +1,91 @@
+package build
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	helmchart "helm.sh/helm/v3/pkg/chart"
+)
+
+// RenderError is returned if the chart of a HelmRelease fails to render.
+type RenderError struct {
+	// Release is the namespace and name of the HelmRelease.
+	Release string
+	// Chart is the name of the chart.
+	Chart string
+	// Version is the version of the chart.
+	Version string
+	// Template is the path of the failed template within the chart if Helm reports it.
+	Template string
+	// Line is the line of the template if Helm reports it, zero otherwise.
+	Line int
+	// Err is the error returned by Helm.
+	Err error
+}
+
+func (e *RenderError) Error() string {
+	location := ""
+	switch {
+	case e.Template != "" && e.Line > 0:
+		location = fmt.Sprintf(" at %s:%d", e.Template, e.Line)
+	case e.Template != "":
+		location = fmt.Sprintf(" at %s", e.Template)
+	}
+
+	return fmt.Sprintf("failed to render chart `%s@%s` of helmrelease `%s`%s: %s", e.Chart, e.Version, e.Release, location, e.Err)
+}
+
+func (e *RenderError) Unwrap() error {
+	return e.Err
+}
+
+// templateLocations match the template path and line of the errors of the Helm template engine:
+//
+//	template: podinfo/templates/deployment.yaml:12:20: executing "podinfo/templates/deployment.yaml" at <...>: ...
+//	parse error at (podinfo/templates/deployment.yaml:5): function "foo" not defined
+//	execution error at (podinfo/templates/deployment.yaml:3:4): message of fail
+//	YAML parse error on podinfo/templates/deployment.yaml: error converting YAML to JSON: ...
+//
+// The line of a YAML parse error refers to the rendered manifest and not to the template, it is not reported.
+var templateLocations = []*regexp.Regexp{
+	regexp.MustCompile(`template: ([^\s:]+):(\d+)`),
+	regexp.MustCompile(`(?:parse|execution) error at \(([^\s:]+):(\d+)`),
+	regexp.MustCompile(`YAML parse error on ([^\s:]+):`),
+}
+
+// newRenderError wraps the error of rendering the chart of a HelmRelease.
+func newRenderError(hr helmv2.HelmRelease, chart *helmchart.Chart, err error) *RenderError {
+	renderErr := &RenderError{
+		Release: hr.GetNamespace() + "/" + hr.GetName(),
+		Err:     err,
+	}
+
+	if chart != nil && chart.Metadata != nil {
+		renderErr.Chart = chart.Metadata.Name
+		renderErr.Version = chart.Metadata.Version
+	}
+
+	renderErr.Template, renderErr.Line = parseTemplateLocation(err.Error())
+	return renderErr
+}
+
+// parseTemplateLocation returns the template path and line of an error of the Helm template engine.
+func parseTemplateLocation(msg string) (string, int) {
+	for _, pattern := range templateLocations {
+		match := pattern.FindStringSubmatch(msg)
+		if match == nil {
+			continue
+		}
+
+		line := 0
+		if len(match) > 2 {
+			line, _ = strconv.Atoi(match[2])
+		}
+
+		return match[1], line
+	}
+
+	return "", 0
+}
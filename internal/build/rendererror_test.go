@@ -0,0 +1,137 @@
+package build
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"github.com/go-logr/logr"
+	. "github.com/onsi/gomega"
+	helmchart "helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseTemplateLocation(t *testing.T) {
+	tests := []struct {
+		name           string
+		msg            string
+		expectTemplate string
+		expectLine     int
+	}{
+		{
+			name:           "execution error",
+			msg:            `template: podinfo/templates/deployment.yaml:12:20: executing "podinfo/templates/deployment.yaml" at <.Values.image.tag>: nil pointer evaluating interface {}.tag`,
+			expectTemplate: "podinfo/templates/deployment.yaml",
+			expectLine:     12,
+		},
+		{
+			name:           "parse error",
+			msg:            `parse error at (podinfo/templates/service.yaml:5): function "foo" not defined`,
+			expectTemplate: "podinfo/templates/service.yaml",
+			expectLine:     5,
+		},
+		{
+			name:           "fail",
+			msg:            `execution error at (podinfo/templates/NOTES.txt:3:4): image.tag is required`,
+			expectTemplate: "podinfo/templates/NOTES.txt",
+			expectLine:     3,
+		},
+		{
+			name:           "yaml parse error",
+			msg:            `YAML parse error on podinfo/templates/hpa.yaml: error converting YAML to JSON: yaml: line 7: did not find expected key`,
+			expectTemplate: "podinfo/templates/hpa.yaml",
+		},
+		{
+			name: "no template",
+			msg:  `chart requires kubeVersion: >=1.30.0 which is incompatible with Kubernetes v1.20.0`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			template, line := parseTemplateLocation(tt.msg)
+			g.Expect(template).To(Equal(tt.expectTemplate))
+			g.Expect(line).To(Equal(tt.expectLine))
+		})
+	}
+}
+
+func TestRenderReleaseError(t *testing.T) {
+	tests := []struct {
+		name           string
+		template       string
+		expectTemplate string
+		expectLine     int
+		expectErr      string
+	}{
+		{
+			name:           "nil pointer",
+			template:       "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: broken\ndata:\n  tag: {{ .Values.image.tag }}\n",
+			expectTemplate: "broken/templates/configmap.yaml",
+			expectLine:     6,
+			expectErr:      "failed to render chart `broken@1.2.3` of helmrelease `apps/podinfo` at broken/templates/configmap.yaml:6: ",
+		},
+		{
+			name:           "undefined function",
+			template:       "apiVersion: v1\n{{ foo }}\n",
+			expectTemplate: "broken/templates/configmap.yaml",
+			expectLine:     2,
+			expectErr:      "failed to render chart `broken@1.2.3` of helmrelease `apps/podinfo` at broken/templates/configmap.yaml:2: ",
+		},
+		{
+			name:           "fail",
+			template:       "{{ fail \"image.tag is required\" }}\n",
+			expectTemplate: "broken/templates/configmap.yaml",
+			expectLine:     1,
+			expectErr:      "image.tag is required",
+		},
+		{
+			name:           "invalid yaml",
+			template:       "apiVersion: v1\nkind: ConfigMap\n  metadata: [\n",
+			expectTemplate: "broken/templates/configmap.yaml",
+			expectErr:      "failed to render chart `broken@1.2.3` of helmrelease `apps/podinfo` at broken/templates/configmap.yaml: ",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			brokenChart := &helmchart.Chart{
+				Metadata: &helmchart.Metadata{
+					APIVersion: helmchart.APIVersionV2,
+					Name:       "broken",
+					Version:    "1.2.3",
+				},
+				Templates: []*helmchart.File{
+					{
+						Name: "templates/configmap.yaml",
+						Data: []byte(tt.template),
+					},
+				},
+			}
+
+			hr := helmv2.HelmRelease{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "podinfo",
+					Namespace: "apps",
+				},
+			}
+
+			h := NewHelmBuilder(logr.Discard(), HelmOpts{})
+			_, err := h.renderRelease(context.Background(), hr, nil, chartutil.Values{}, brokenChart)
+			g.Expect(err).To(HaveOccurred())
+			g.Expect(err.Error()).To(ContainSubstring(tt.expectErr))
+
+			var renderErr *RenderError
+			g.Expect(errors.As(err, &renderErr)).To(BeTrue())
+			g.Expect(renderErr.Release).To(Equal("apps/podinfo"))
+			g.Expect(renderErr.Chart).To(Equal("broken"))
+			g.Expect(renderErr.Version).To(Equal("1.2.3"))
+			g.Expect(renderErr.Template).To(Equal(tt.expectTemplate))
+			g.Expect(renderErr.Line).To(Equal(tt.expectLine))
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package build
+
+import (
+	"context"
+
+	"github.com/doodlescheduling/flux-build/internal/helm/chart"
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+)
+
+// ResolvedChart is the chart version a HelmRelease was rendered from.
+type ResolvedChart struct {
+	// Name is the name of the chart.
+	Name string `json:"name"`
+	// Constraint is the version or semver range of the HelmRelease, empty for charts referenced by chartRef.
+	Constraint string `json:"constraint,omitempty"`
+	// Version is the resolved version of the chart.
+	Version string `json:"version"`
+	// Digest is the sha256 digest of the chart archive, for OCI the digest of the chart layer. It is empty for charts
+	// packaged from a GitRepository or Bucket.
+	Digest string `json:"digest,omitempty"`
+}
+
+type resolvedChartKey struct{}
+
+// WithResolvedChart returns a context which records the chart version the HelmRelease built with it is resolved to
+// into c.
+func WithResolvedChart(ctx context.Context, c *ResolvedChart) context.Context {
+	return context.WithValue(ctx, resolvedChartKey{}, c)
+}
+
+func resolvedChartFrom(ctx context.Context) *ResolvedChart {
+	c, _ := ctx.Value(resolvedChartKey{}).(*ResolvedChart)
+	return c
+}
+
+// recordResolvedChart logs the resolved chart version of the HelmRelease and records it if the context carries a
+// ResolvedChart, see WithResolvedChart.
+func (h *Helm) recordResolvedChart(ctx context.Context, hr *helmv2.HelmRelease, b *chart.Build) {
+	resolved := ResolvedChart{
+		Name:    b.Name,
+		Version: b.Version,
+		Digest:  b.Digest,
+	}
+
+	if hr.Spec.Chart != nil {
+		resolved.Constraint = hr.Spec.Chart.Spec.Version
+	}
+
+	h.logger(ctx).V(1).Info("resolved chart version", "chart", resolved.Name, "constraint", resolved.Constraint, "version", resolved.Version, "digest", resolved.Digest)
+
+	if c := resolvedChartFrom(ctx); c != nil {
+		*c = resolved
+	}
+}
@@ -0,0 +1,92 @@
+package build
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+// DefaultRetryMax is used if no retry maximum was configured.
+var DefaultRetryMax = 3
+
+// DefaultRetryBackoff is used if no retry backoff was configured.
+var DefaultRetryBackoff = time.Second
+
+// statusCodePattern matches the HTTP status codes embedded into the errors of the helm getters and the registry client.
+var statusCodePattern = regexp.MustCompile(`(?:: |status code |status: )(\d{3})\b`)
+
+// retry calls fn until it succeeds, fails with a permanent error or RetryMax retries are exhausted.
+// The backoff starts at RetryBackoff and doubles with every retry, each wait is jittered.
+func (h *Helm) retry(ctx context.Context, operation string, fn func() error) error {
+	backoff := *h.opts.RetryBackoff
+
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= *h.opts.RetryMax || ctx.Err() != nil || !isRetryable(err) {
+			return err
+		}
+
+		// Full jitter within the upper half of the backoff
+		wait := backoff/2 + rand.N(backoff/2+1)
+		h.logger(ctx).Info("retrying after transient error", "operation", operation, "attempt", attempt+1, "backoff", wait.String(), "error", err.Error())
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+
+		backoff *= 2
+	}
+}
+
+// isRetryable returns true for network errors, server errors and rate limiting.
+// Errors like a missing chart or failed authentication are permanent.
+func isRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var transportErr *transport.Error
+	if errors.As(err, &transportErr) {
+		return retryableStatus(transportErr.StatusCode)
+	}
+
+	var netErr net.Error
+	var recordErr tls.RecordHeaderError
+	if errors.As(err, &netErr) || errors.As(err, &recordErr) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	msg := err.Error()
+	for _, match := range statusCodePattern.FindAllStringSubmatch(msg, -1) {
+		if code, err := strconv.Atoi(match[1]); err == nil && code >= 100 && code < 600 {
+			return retryableStatus(code)
+		}
+	}
+
+	for _, transient := range []string{"connection reset by peer", "TLS handshake timeout", "tls: bad record MAC", "unexpected EOF", "i/o timeout"} {
+		if strings.Contains(msg, transient) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func retryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= http.StatusInternalServerError
+}
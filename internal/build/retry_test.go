@@ -0,0 +1,211 @@
+package build
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/doodlescheduling/flux-build/internal/cachemgr"
+	"github.com/doodlescheduling/flux-build/internal/helm/chart"
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+	"github.com/go-logr/logr"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	. "github.com/onsi/gomega"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	helmrepo "helm.sh/helm/v3/pkg/repo"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		expect bool
+	}{
+		{
+			name:   "server error from the http getter",
+			err:    errors.New("failed to fetch https://charts.example.com/index.yaml : 503 Service Unavailable"),
+			expect: true,
+		},
+		{
+			name:   "rate limited",
+			err:    fmt.Errorf("failed to download chart: %w", errors.New("failed to fetch https://charts.example.com/chart.tgz : 429 Too Many Requests")),
+			expect: true,
+		},
+		{
+			name:   "not found",
+			err:    errors.New("failed to fetch https://charts.example.com/index.yaml : 404 Not Found"),
+			expect: false,
+		},
+		{
+			name:   "unauthorized",
+			err:    errors.New("failed to fetch https://charts.example.com/index.yaml : 401 Unauthorized"),
+			expect: false,
+		},
+		{
+			name:   "registry server error",
+			err:    &transport.Error{StatusCode: http.StatusBadGateway},
+			expect: true,
+		},
+		{
+			name:   "registry denied",
+			err:    &transport.Error{StatusCode: http.StatusForbidden},
+			expect: false,
+		},
+		{
+			name:   "connection reset",
+			err:    fmt.Errorf("read: %w", syscall.ECONNRESET),
+			expect: true,
+		},
+		{
+			name:   "unexpected EOF",
+			err:    fmt.Errorf("failed to download chart: %w", io.ErrUnexpectedEOF),
+			expect: true,
+		},
+		{
+			name:   "tls handshake timeout",
+			err:    errors.New("Get \"https://charts.example.com/index.yaml\": net/http: TLS handshake timeout"),
+			expect: true,
+		},
+		{
+			name:   "missing chart version",
+			err:    errors.New("no 'helmchart' chart with version matching '9.9.9' found"),
+			expect: false,
+		},
+		{
+			name:   "context deadline",
+			err:    fmt.Errorf("failed to fetch: %w", context.DeadlineExceeded),
+			expect: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			g.Expect(isRetryable(tt.err)).To(Equal(tt.expect))
+		})
+	}
+}
+
+func TestBuildChartRetry(t *testing.T) {
+	tests := []struct {
+		name            string
+		indexFailures   int32
+		chartFailures   int32
+		status          int
+		retryMax        int
+		expectErr       string
+		expectDownloads int32
+	}{
+		{
+			name:            "transient index and chart failures",
+			indexFailures:   1,
+			chartFailures:   1,
+			status:          http.StatusServiceUnavailable,
+			retryMax:        3,
+			expectDownloads: 2,
+		},
+		{
+			name:            "retries exhausted",
+			chartFailures:   5,
+			status:          http.StatusTooManyRequests,
+			retryMax:        2,
+			expectErr:       "429 Too Many Requests",
+			expectDownloads: 3,
+		},
+		{
+			name:            "permanent failure is not retried",
+			chartFailures:   5,
+			status:          http.StatusNotFound,
+			retryMax:        3,
+			expectErr:       "404 Not Found",
+			expectDownloads: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			c, err := loader.Load(testChart)
+			g.Expect(err).ToNot(HaveOccurred())
+			archive, err := os.ReadFile(testChart)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			var indexRequests, downloads atomic.Int32
+			mux := http.NewServeMux()
+			server := httptest.NewServer(mux)
+			t.Cleanup(server.Close)
+
+			index := helmrepo.NewIndexFile()
+			g.Expect(index.MustAdd(c.Metadata, "helmchart-0.1.0.tgz", server.URL, "")).To(Succeed())
+			indexYAML, err := yaml.Marshal(index)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			mux.HandleFunc("/index.yaml", func(w http.ResponseWriter, r *http.Request) {
+				if indexRequests.Add(1) <= tt.indexFailures {
+					w.WriteHeader(tt.status)
+					return
+				}
+				_, _ = w.Write(indexYAML)
+			})
+			mux.HandleFunc("/helmchart-0.1.0.tgz", func(w http.ResponseWriter, r *http.Request) {
+				if downloads.Add(1) <= tt.chartFailures {
+					w.WriteHeader(tt.status)
+					return
+				}
+				_, _ = w.Write(archive)
+			})
+
+			cache, err := cachemgr.New("none", "")
+			g.Expect(err).ToNot(HaveOccurred())
+
+			backoff := time.Millisecond
+			h := NewHelmBuilder(logr.Discard(), HelmOpts{
+				Cache:        cache,
+				RetryMax:     &tt.retryMax,
+				RetryBackoff: &backoff,
+			})
+
+			repo := &sourcev1.HelmRepository{
+				ObjectMeta: metav1.ObjectMeta{Name: "repo", Namespace: "default"},
+				Spec:       sourcev1.HelmRepositorySpec{URL: server.URL},
+			}
+
+			hr := helmv2.HelmRelease{
+				Spec: helmv2.HelmReleaseSpec{
+					Chart: &helmv2.HelmChartTemplate{
+						Spec: helmv2.HelmChartTemplateSpec{
+							Chart:   "helmchart",
+							Version: "0.1.0",
+							SourceRef: helmv2.CrossNamespaceObjectReference{
+								Kind: sourcev1.HelmRepositoryKind,
+								Name: "repo",
+							},
+						},
+					},
+				},
+			}
+
+			b := &chart.Build{}
+			err = h.buildChart(context.Background(), repo, hr, nil, b, nil)
+			if tt.expectErr != "" {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tt.expectErr))
+			} else {
+				g.Expect(err).ToNot(HaveOccurred())
+				g.Expect(b.Path).To(BeAnExistingFile())
+			}
+
+			g.Expect(downloads.Load()).To(Equal(tt.expectDownloads))
+		})
+	}
+}
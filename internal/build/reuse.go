@@ -0,0 +1,150 @@
+package build
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"slices"
+	"strings"
+
+	"github.com/doodlescheduling/flux-build/internal/helm/chart"
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+	helmchart "helm.sh/helm/v3/pkg/chart"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/kustomize/api/resource"
+)
+
+// sourceSecretRefs are the fields of source objects which name a Secret in the namespace of the source.
+var sourceSecretRefs = []string{"spec.secretRef.name", "spec.certSecretRef.name", "spec.proxySecretRef.name", "spec.verify.secretRef.name"}
+
+// reusableChart is the resolved and loaded chart of a HelmRelease kept with ReuseCharts.
+type reusableChart struct {
+	// fingerprint hashes the inputs the chart was resolved from, see chartFingerprint.
+	fingerprint string
+	build       *chart.Build
+	spec        helmv2.HelmChartTemplateSpec
+	// chart is never rendered itself as Helm modifies the charts it renders, see copyChart.
+	chart *helmchart.Chart
+}
+
+// reusedChart returns the chart kept for the HelmRelease if its inputs are unchanged and the fingerprint of its
+// current inputs. Nothing is reused without ReuseCharts or for packaged charts of the built repository which may
+// change on disk.
+func (h *Helm) reusedChart(ctx context.Context, hr *helmv2.HelmRelease, db map[ref]*resource.Resource) (*reusableChart, string) {
+	if !h.opts.ReuseCharts {
+		return nil, ""
+	}
+
+	if hr.Spec.Chart != nil {
+		namespace := hr.Spec.Chart.Spec.SourceRef.Namespace
+		if namespace == "" {
+			namespace = hr.GetNamespace()
+		}
+
+		if h.isLocalPackagedChart(hr, namespace) {
+			return nil, ""
+		}
+	}
+
+	fingerprint, err := chartFingerprint(hr, db)
+	if err != nil {
+		h.logger(ctx).V(1).Info("chart inputs can't be fingerprinted, resolve the chart", "error", err.Error())
+		return nil, ""
+	}
+
+	v, ok := h.reusable.Load(hr.GetNamespace() + "/" + hr.GetName())
+	if !ok || v.(*reusableChart).fingerprint != fingerprint {
+		return nil, fingerprint
+	}
+
+	reused := v.(*reusableChart)
+	h.logger(ctx).V(1).Info("reuse resolved chart, only the values changed", "chart", reused.build.Name, "version", reused.build.Version)
+	return reused, fingerprint
+}
+
+// keepChart keeps the resolved and loaded chart of the HelmRelease for later builds, nothing is kept without a
+// fingerprint. It must be called before the chart is rendered.
+func (h *Helm) keepChart(hr *helmv2.HelmRelease, fingerprint string, b *chart.Build, spec helmv2.HelmChartTemplateSpec, c *helmchart.Chart) {
+	if fingerprint == "" {
+		return
+	}
+
+	h.reusable.Store(hr.GetNamespace()+"/"+hr.GetName(), &reusableChart{
+		fingerprint: fingerprint,
+		build:       b,
+		spec:        spec,
+		chart:       copyChart(c),
+	})
+}
+
+// chartFingerprint hashes the inputs of the chart of a HelmRelease: its chart template or chartRef and all source
+// objects of db (HelmRepositories of dependencies included) with the Secrets they reference. The values of the
+// HelmRelease are no inputs of its chart.
+func chartFingerprint(hr *helmv2.HelmRelease, db map[ref]*resource.Resource) (string, error) {
+	h := sha256.New()
+	spec, err := json.Marshal([]interface{}{hr.GetNamespace(), hr.Spec.Chart, hr.Spec.ChartRef})
+	if err != nil {
+		return "", err
+	}
+	h.Write(spec)
+
+	var inputs []ref
+	for key, res := range db {
+		if key.Group != sourcev1.GroupVersion.Group {
+			continue
+		}
+
+		inputs = append(inputs, key)
+		for _, field := range sourceSecretRefs {
+			if name, err := res.GetString(field); err == nil && name != "" {
+				inputs = append(inputs, ref{GroupKind: schema.GroupKind{Kind: "Secret"}, Name: name, Namespace: key.Namespace})
+			}
+		}
+	}
+
+	slices.SortFunc(inputs, func(a, b ref) int {
+		return strings.Compare(a.String(), b.String())
+	})
+
+	for i, key := range inputs {
+		if i > 0 && key == inputs[i-1] {
+			continue
+		}
+
+		h.Write([]byte("\x00" + key.String() + "\x00"))
+		if res, ok := db[key]; ok {
+			b, err := res.AsYAML()
+			if err != nil {
+				return "", err
+			}
+			h.Write(b)
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// copyChart returns a copy of the chart and its dependencies which Helm may modify while rendering, for instance
+// by removing disabled dependencies. Templates and files are shared, rendering only replaces the values.
+func copyChart(c *helmchart.Chart) *helmchart.Chart {
+	copied := *c
+	if c.Metadata != nil {
+		metadata := *c.Metadata
+		metadata.Dependencies = make([]*helmchart.Dependency, 0, len(c.Metadata.Dependencies))
+		for _, d := range c.Metadata.Dependencies {
+			dependency := *d
+			metadata.Dependencies = append(metadata.Dependencies, &dependency)
+		}
+		copied.Metadata = &metadata
+	}
+
+	dependencies := make([]*helmchart.Chart, 0, len(c.Dependencies()))
+	for _, d := range c.Dependencies() {
+		dependencies = append(dependencies, copyChart(d))
+	}
+	copied.SetDependencies(dependencies...)
+
+	return &copied
+}
@@ -0,0 +1,42 @@
+package build
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	helmchart "helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+)
+
+func TestCopyChart(t *testing.T) {
+	g := NewWithT(t)
+
+	sub := &helmchart.Chart{Metadata: &helmchart.Metadata{Name: "sub", Version: "0.1.0", APIVersion: helmchart.APIVersionV2}}
+	c := &helmchart.Chart{
+		Metadata: &helmchart.Metadata{
+			Name:         "app",
+			Version:      "0.1.0",
+			APIVersion:   helmchart.APIVersionV2,
+			Dependencies: []*helmchart.Dependency{{Name: "sub", Version: "0.1.0", Condition: "sub.enabled"}},
+		},
+		Templates: []*helmchart.File{{Name: "templates/cm.yaml"}},
+	}
+	c.SetDependencies(sub)
+
+	// Helm removes disabled dependencies from the chart it renders, the original chart is left as is
+	copied := copyChart(c)
+	g.Expect(chartutil.ProcessDependencies(copied, chartutil.Values{"sub": map[string]interface{}{"enabled": false}})).To(Succeed())
+	g.Expect(copied.Dependencies()).To(BeEmpty())
+	g.Expect(copied.Metadata.Dependencies).To(BeEmpty())
+	g.Expect(copied.Templates).To(Equal(c.Templates))
+
+	g.Expect(c.Dependencies()).To(ConsistOf(sub))
+	g.Expect(c.Metadata.Dependencies).To(HaveLen(1))
+	g.Expect(sub.Parent()).To(Equal(c))
+
+	copied = copyChart(c)
+	g.Expect(chartutil.ProcessDependencies(copied, chartutil.Values{"sub": map[string]interface{}{"enabled": true}})).To(Succeed())
+	g.Expect(copied.Dependencies()).To(HaveLen(1))
+	g.Expect(copied.Dependencies()[0]).ToNot(BeIdenticalTo(sub))
+	g.Expect(copied.Dependencies()[0].Parent()).To(BeIdenticalTo(copied))
+}
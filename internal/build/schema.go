@@ -0,0 +1,153 @@
+package build
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"github.com/xeipuuv/gojsonschema"
+	helmchart "helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"sigs.k8s.io/yaml"
+)
+
+// SchemaViolation is a value of a HelmRelease which violates the values.schema.json of its chart or a subchart.
+type SchemaViolation struct {
+	// Chart is the name of the chart whose schema is violated, subcharts are prefixed by their parents.
+	Chart string
+	// Path is the JSON path of the value within the values of the HelmRelease, for instance $.image.tag.
+	Path string
+	// Value is the offending value.
+	Value interface{}
+	// Description is the violated constraint.
+	Description string
+}
+
+func (v SchemaViolation) String() string {
+	return fmt.Sprintf("%s: %s: %s (value: %v)", v.Chart, v.Path, v.Description, v.Value)
+}
+
+// SchemaError is returned if the values of a HelmRelease violate the schema of its chart.
+type SchemaError struct {
+	// Release is the namespace and name of the HelmRelease.
+	Release string
+	// Violations are all violations of the chart and its subcharts.
+	Violations []SchemaViolation
+}
+
+func (e *SchemaError) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "values of helmrelease `%s` violate the chart schema:", e.Release)
+	for _, v := range e.Violations {
+		fmt.Fprintf(&sb, "\n- %s", v)
+	}
+
+	return sb.String()
+}
+
+// validateValues validates the values of a HelmRelease against the values.schema.json of the chart and its enabled
+// subcharts like Helm does on install. The values are coalesced with the chart defaults before they are validated,
+// the violations are sorted by chart and path.
+func validateValues(chart *helmchart.Chart, values chartutil.Values) ([]SchemaViolation, error) {
+	if err := chartutil.ProcessDependenciesWithMerge(chart, values); err != nil {
+		return nil, err
+	}
+
+	coalesced, err := chartutil.CoalesceValues(chart, values)
+	if err != nil {
+		return nil, err
+	}
+
+	violations, err := validateChartValues(chart, coalesced, chart.Name(), "$")
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(violations, func(i, j int) bool {
+		if violations[i].Chart != violations[j].Chart {
+			return violations[i].Chart < violations[j].Chart
+		}
+		return violations[i].Path < violations[j].Path
+	})
+
+	return violations, nil
+}
+
+func validateChartValues(chart *helmchart.Chart, values map[string]interface{}, name, path string) ([]SchemaViolation, error) {
+	var violations []SchemaViolation
+	if chart.Schema != nil {
+		valuesJSON, err := yaml.Marshal(values)
+		if err != nil {
+			return nil, err
+		}
+
+		valuesJSON, err = yaml.YAMLToJSON(valuesJSON)
+		if err != nil {
+			return nil, err
+		}
+
+		if bytes.Equal(valuesJSON, []byte("null")) {
+			valuesJSON = []byte("{}")
+		}
+
+		result, err := gojsonschema.Validate(gojsonschema.NewBytesLoader(chart.Schema), gojsonschema.NewBytesLoader(valuesJSON))
+		if err != nil {
+			return nil, fmt.Errorf("invalid values.schema.json of chart `%s`: %w", name, err)
+		}
+
+		for _, desc := range result.Errors() {
+			fieldPath := path
+			if field := desc.Field(); field != gojsonschema.STRING_ROOT_SCHEMA_PROPERTY {
+				fieldPath = path + "." + field
+			}
+
+			violations = append(violations, SchemaViolation{
+				Chart:       name,
+				Path:        fieldPath,
+				Value:       desc.Value(),
+				Description: desc.Description(),
+			})
+		}
+	}
+
+	for _, subchart := range chart.Dependencies() {
+		subchartValues, _ := values[subchart.Name()].(map[string]interface{})
+		subchartViolations, err := validateChartValues(subchart, subchartValues, name+"/"+subchart.Name(), path+"."+subchart.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		violations = append(violations, subchartViolations...)
+	}
+
+	return violations, nil
+}
+
+// checkValuesSchema validates the values of the HelmRelease, violations are logged instead of failing the build
+// with a SchemaError if SchemaWarnings is set.
+func (h *Helm) checkValuesSchema(ctx context.Context, hr helmv2.HelmRelease, values chartutil.Values, chart *helmchart.Chart) error {
+	violations, err := validateValues(chart, values)
+	if err != nil {
+		return fmt.Errorf("failed to validate values of helmrelease `%s/%s`: %w", hr.GetNamespace(), hr.GetName(), err)
+	}
+
+	if len(violations) == 0 {
+		return nil
+	}
+
+	if h.opts.SchemaWarnings {
+		for _, v := range violations {
+			h.logger(ctx).Info("values violate the chart schema", "chart", v.Chart, "path", v.Path, "value", v.Value, "violation", v.Description)
+		}
+
+		return nil
+	}
+
+	return &SchemaError{
+		Release:    hr.GetNamespace() + "/" + hr.GetName(),
+		Violations: violations,
+	}
+}
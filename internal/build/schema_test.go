@@ -0,0 +1,119 @@
+package build
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"github.com/go-logr/logr"
+	. "github.com/onsi/gomega"
+	helmchart "helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newSchemaChart() *helmchart.Chart {
+	sub := &helmchart.Chart{
+		Metadata: &helmchart.Metadata{APIVersion: "v2", Name: "redis", Version: "0.1.0"},
+		Values: map[string]interface{}{
+			"port": 6379,
+		},
+		Schema: []byte(`{"type": "object", "properties": {"port": {"type": "integer", "maximum": 65535}}}`),
+	}
+
+	parent := &helmchart.Chart{
+		Metadata: &helmchart.Metadata{
+			APIVersion: "v2",
+			Name:       "podinfo",
+			Version:    "0.1.0",
+			Dependencies: []*helmchart.Dependency{
+				{Name: "redis", Version: "0.1.0", Condition: "redis.enabled"},
+			},
+		},
+		Values: map[string]interface{}{
+			"replicas": 1,
+			"image":    map[string]interface{}{"tag": "latest"},
+			"redis":    map[string]interface{}{"enabled": true},
+		},
+		Schema: []byte(`{
+  "type": "object",
+  "required": ["image"],
+  "properties": {
+    "replicas": {"type": "integer", "minimum": 1},
+    "image": {"type": "object", "properties": {"tag": {"type": "string"}}}
+  }
+}`),
+	}
+	parent.AddDependency(sub)
+
+	return parent
+}
+
+func TestValidateValues(t *testing.T) {
+	tests := []struct {
+		name             string
+		values           map[string]interface{}
+		expectViolations []SchemaViolation
+	}{
+		{
+			name:   "valid",
+			values: map[string]interface{}{"replicas": 3},
+		},
+		{
+			name: "violations of chart and subchart",
+			values: map[string]interface{}{
+				"replicas": 0,
+				"image":    map[string]interface{}{"tag": 1},
+				"redis":    map[string]interface{}{"port": 70000},
+			},
+			expectViolations: []SchemaViolation{
+				{Chart: "podinfo", Path: "$.image.tag", Value: json.Number("1"), Description: "Invalid type. Expected: string, given: integer"},
+				{Chart: "podinfo", Path: "$.replicas", Value: json.Number("0"), Description: "Must be greater than or equal to 1"},
+				{Chart: "podinfo/redis", Path: "$.redis.port", Value: json.Number("70000"), Description: "Must be less than or equal to 65535"},
+			},
+		},
+		{
+			name: "disabled subchart",
+			values: map[string]interface{}{
+				"redis": map[string]interface{}{"enabled": false, "port": 70000},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			violations, err := validateValues(newSchemaChart(), tt.values)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(violations).To(Equal(tt.expectViolations))
+		})
+	}
+}
+
+func TestCheckValuesSchema(t *testing.T) {
+	hr := helmv2.HelmRelease{
+		ObjectMeta: metav1.ObjectMeta{Name: "podinfo", Namespace: "apps"},
+	}
+	values := chartutil.Values{"replicas": 0}
+
+	t.Run("fails", func(t *testing.T) {
+		g := NewWithT(t)
+
+		h := NewHelmBuilder(logr.Discard(), HelmOpts{})
+		err := h.checkValuesSchema(context.Background(), hr, values, newSchemaChart())
+
+		var schemaErr *SchemaError
+		g.Expect(errors.As(err, &schemaErr)).To(BeTrue())
+		g.Expect(schemaErr.Release).To(Equal("apps/podinfo"))
+		g.Expect(err.Error()).To(Equal("values of helmrelease `apps/podinfo` violate the chart schema:\n- podinfo: $.replicas: Must be greater than or equal to 1 (value: 0)"))
+	})
+
+	t.Run("warnings", func(t *testing.T) {
+		g := NewWithT(t)
+
+		h := NewHelmBuilder(logr.Discard(), HelmOpts{SchemaWarnings: true})
+		g.Expect(h.checkValuesSchema(context.Background(), hr, values, newSchemaChart())).To(Succeed())
+	})
+}
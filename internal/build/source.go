@@ -0,0 +1,146 @@
+package build
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/kustomize/api/provider"
+	"sigs.k8s.io/kustomize/api/resmap"
+	"sigs.k8s.io/kustomize/api/resource"
+)
+
+// Source is an input of a build whose resources are indexed and whose HelmReleases are built, for instance a
+// kustomize path, a YAML stream or objects parsed by the caller.
+type Source interface {
+	// Name identifies the source in logs and in reports of resources declared by more than one source.
+	Name() string
+	// Load returns the resources of the source. The logger of ctx is used if it carries one.
+	Load(ctx context.Context) (resmap.ResMap, error)
+}
+
+// PathSource is a kustomize path, a directory or a single manifest, see Kustomize.
+type PathSource string
+
+// Name returns the path.
+func (p PathSource) Name() string {
+	return string(p)
+}
+
+// Load builds the path with kustomize.
+func (p PathSource) Load(ctx context.Context) (resmap.ResMap, error) {
+	return Kustomize(ctx, string(p))
+}
+
+// StreamSource is a multi-document YAML stream which is taken as is, see ReadResources.
+type StreamSource struct {
+	name string
+	data []byte
+}
+
+// NewStreamSource returns a source reading the resources from the YAML stream data.
+func NewStreamSource(name string, data []byte) *StreamSource {
+	return &StreamSource{name: name, data: data}
+}
+
+// Name returns the name the source was created with.
+func (s *StreamSource) Name() string {
+	return s.name
+}
+
+// Load decodes the resources of the stream, the stream is bounded by the DocumentLimits of ctx.
+func (s *StreamSource) Load(ctx context.Context) (resmap.ResMap, error) {
+	return ReadResources(logr.FromContextOrDiscard(ctx), bytes.NewReader(s.data), documentLimitsFrom(ctx))
+}
+
+// ObjectSource takes objects which are already parsed by the caller as they are, they are neither serialized nor built
+// by kustomize. Like for a StreamSource objects of kind List are unwrapped into their items and if an object is passed
+// more than once the last one wins.
+type ObjectSource struct {
+	name      string
+	objects   []unstructured.Unstructured
+	resources []*resource.Resource
+	labels    map[string]string
+}
+
+// NewObjectSource returns a source of unstructured objects. The labels are added to every object which does not set
+// them already, for instance to record where the objects originate from.
+func NewObjectSource(name string, objects []unstructured.Unstructured, labels map[string]string) *ObjectSource {
+	return &ObjectSource{name: name, objects: objects, labels: labels}
+}
+
+// NewResourceSource returns a source of kustomize resources, the labels are added like by NewObjectSource.
+// The resources are copied and never modified.
+func NewResourceSource(name string, resources []*resource.Resource, labels map[string]string) *ObjectSource {
+	return &ObjectSource{name: name, resources: resources, labels: labels}
+}
+
+// Name returns the name the source was created with.
+func (s *ObjectSource) Name() string {
+	return s.name
+}
+
+// Load converts the objects into resources and labels them.
+func (s *ObjectSource) Load(ctx context.Context) (resmap.ResMap, error) {
+	rf := provider.NewDefaultDepProvider().GetResourceFactory()
+
+	var resources []*resource.Resource
+	for _, res := range s.resources {
+		resources = append(resources, res.DeepCopy())
+	}
+
+	for i, obj := range s.objects {
+		items := []unstructured.Unstructured{obj}
+		if obj.GetKind() == "List" {
+			list, err := obj.ToList()
+			if err != nil {
+				return nil, fmt.Errorf("invalid list %d of source %s: %w", i, s.name, err)
+			}
+
+			items = list.Items
+		}
+
+		for _, item := range items {
+			if item.GetAPIVersion() == "" || item.GetKind() == "" || item.GetName() == "" {
+				return nil, fmt.Errorf("object %d of source %s is no kubernetes object, it requires an apiVersion, a kind and a name", i, s.name)
+			}
+
+			res, err := rf.FromMap(item.DeepCopy().Object)
+			if err != nil {
+				return nil, fmt.Errorf("invalid object %d of source %s: %w", i, s.name, err)
+			}
+
+			resources = append(resources, res)
+		}
+	}
+
+	for _, res := range resources {
+		if err := addLabels(res, s.labels); err != nil {
+			return nil, err
+		}
+	}
+
+	return indexStream(logr.FromContextOrDiscard(ctx), resources)
+}
+
+// addLabels adds the labels to the resource unless it sets them already.
+func addLabels(res *resource.Resource, labels map[string]string) error {
+	if len(labels) == 0 {
+		return nil
+	}
+
+	merged := res.GetLabels()
+	if merged == nil {
+		merged = make(map[string]string, len(labels))
+	}
+
+	for k, v := range labels {
+		if _, ok := merged[k]; !ok {
+			merged[k] = v
+		}
+	}
+
+	return res.SetLabels(merged)
+}
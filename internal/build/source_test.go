@@ -0,0 +1,105 @@
+package build
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestObjectSource(t *testing.T) {
+	object := func(kind, name string, labels map[string]interface{}) unstructured.Unstructured {
+		obj := unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       kind,
+			"metadata": map[string]interface{}{
+				"name":      name,
+				"namespace": "apps",
+			},
+		}}
+		if labels != nil {
+			obj.Object["metadata"].(map[string]interface{})["labels"] = labels
+		}
+
+		return obj
+	}
+
+	tests := []struct {
+		name         string
+		objects      []unstructured.Unstructured
+		labels       map[string]string
+		expectNames  []string
+		expectLabels []map[string]string
+		expectErr    string
+	}{
+		{
+			name:         "objects are labeled",
+			objects:      []unstructured.Unstructured{object("ConfigMap", "a", nil), object("ConfigMap", "b", map[string]interface{}{"origin": "b"})},
+			labels:       map[string]string{"origin": "caller"},
+			expectNames:  []string{"a", "b"},
+			expectLabels: []map[string]string{{"origin": "caller"}, {"origin": "b"}},
+		},
+		{
+			name: "list is unwrapped",
+			objects: []unstructured.Unstructured{{Object: map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "List",
+				"items": []interface{}{
+					object("ConfigMap", "a", nil).Object,
+					object("Secret", "b", nil).Object,
+				},
+			}}},
+			expectNames:  []string{"a", "b"},
+			expectLabels: []map[string]string{{}, {}},
+		},
+		{
+			name:         "last object wins",
+			objects:      []unstructured.Unstructured{object("ConfigMap", "a", map[string]interface{}{"n": "1"}), object("ConfigMap", "a", map[string]interface{}{"n": "2"})},
+			expectNames:  []string{"a"},
+			expectLabels: []map[string]string{{"n": "2"}},
+		},
+		{
+			name:      "object without name",
+			objects:   []unstructured.Unstructured{object("ConfigMap", "a", nil), object("ConfigMap", "", nil)},
+			expectErr: "object 1 of source objects is no kubernetes object, it requires an apiVersion, a kind and a name",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			s := NewObjectSource("objects", tt.objects, tt.labels)
+			g.Expect(s.Name()).To(Equal("objects"))
+
+			index, err := s.Load(context.Background())
+			if tt.expectErr != "" {
+				g.Expect(err).To(MatchError(tt.expectErr))
+				return
+			}
+
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(resourceNames(index.Resources())).To(Equal(tt.expectNames))
+			for i, res := range index.Resources() {
+				g.Expect(res.GetLabels()).To(Equal(tt.expectLabels[i]))
+			}
+		})
+	}
+}
+
+func TestResourceSource(t *testing.T) {
+	g := NewWithT(t)
+
+	resources := newResources(t, `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: values
+  namespace: apps
+`)
+
+	index, err := NewResourceSource("resources", resources, map[string]string{"origin": "caller"}).Load(context.Background())
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(index.Resources()).To(HaveLen(1))
+	g.Expect(index.Resources()[0].GetLabels()).To(Equal(map[string]string{"origin": "caller"}))
+	g.Expect(resources[0].GetLabels()).To(BeEmpty())
+}
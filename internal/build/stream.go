@@ -0,0 +1,107 @@
+package build
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/go-logr/logr"
+	"sigs.k8s.io/kustomize/api/provider"
+	"sigs.k8s.io/kustomize/api/resmap"
+	"sigs.k8s.io/kustomize/api/resource"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// ReadResources decodes a multi-document YAML stream, for instance the output of kustomize build, into a ResMap.
+// Lists are unwrapped into their items and documents which are no Kubernetes objects are skipped.
+// If a resource is declared more than once the last declaration wins. The stream is bounded by limits.
+func ReadResources(logger logr.Logger, r io.Reader, limits DocumentLimits) (resmap.ResMap, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// kyaml recurses infinitely on cyclic aliases, the alias checks apply even if no limits are set
+	if err := limits.Check(b); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+
+	resources, err := decodeObjects(preserveListKinds(b), func(document int) {
+		logger.V(1).Info("skip document which is no kubernetes object", "document", document)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return indexStream(logger, resources)
+}
+
+// indexStream collects the resources of a stream into a ResMap, if a resource is declared more than once the last
+// declaration wins.
+func indexStream(logger logr.Logger, resources []*resource.Resource) (resmap.ResMap, error) {
+	m := resmap.New()
+	for _, res := range resources {
+		if idx, _ := m.GetIndexOfCurrentId(res.CurId()); idx >= 0 {
+			logger.Info("resource declared more than once, the last declaration wins", "resource", ResourceName(res.GetKind(), res.GetNamespace(), res.GetName()))
+			if _, err := m.Replace(res); err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		if err := m.Append(res); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// decodeObjects decodes the Kubernetes objects of multi-document YAML whose list kinds are preserved by
+// preserveListKinds, skip is called with the index of every document which is no Kubernetes object.
+func decodeObjects(b []byte, skip func(document int)) ([]*resource.Resource, error) {
+	nodes, err := kio.FromBytes(b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode manifests: %w", err)
+	}
+
+	rf := provider.NewDefaultDepProvider().GetResourceFactory()
+	var resources []*resource.Resource
+	for i, node := range nodes {
+		if node.IsNilOrEmpty() {
+			continue
+		}
+
+		if !isObject(node) {
+			skip(i)
+			continue
+		}
+
+		s, err := node.String()
+		if err != nil {
+			return nil, err
+		}
+
+		objects, err := rf.SliceFromBytes([]byte(s))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode document %d: %w", i, err)
+		}
+
+		resources = append(resources, objects...)
+	}
+
+	restoreListKinds(resources)
+	return resources, nil
+}
+
+// isObject returns true if the node is a Kubernetes object, a mapping with an apiVersion, a kind and a name.
+// Lists don't require a name.
+func isObject(node *kyaml.RNode) bool {
+	if node.YNode().Kind != kyaml.MappingNode {
+		return false
+	}
+
+	meta, err := node.GetValidatedMetadata()
+	return err == nil && meta.APIVersion != ""
+}
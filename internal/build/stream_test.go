@@ -0,0 +1,146 @@
+package build
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	. "github.com/onsi/gomega"
+)
+
+func TestReadResources(t *testing.T) {
+	tests := []struct {
+		name          string
+		stream        string
+		expectObjects []string
+		expectData    map[string]string
+		expectErr     string
+	}{
+		{
+			name: "kustomize build output",
+			stream: `apiVersion: helm.toolkit.fluxcd.io/v2
+kind: HelmRelease
+metadata:
+  name: podinfo
+  namespace: apps
+---
+apiVersion: source.toolkit.fluxcd.io/v1
+kind: HelmRepository
+metadata:
+  name: podinfo
+  namespace: apps
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: podinfo-values
+  namespace: apps
+data:
+  replicas: "2"
+`,
+			expectObjects: []string{"ConfigMap/apps/podinfo-values", "HelmRelease/apps/podinfo", "HelmRepository/apps/podinfo"},
+		},
+		{
+			name: "duplicate resource",
+			stream: `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: podinfo-values
+  namespace: apps
+data:
+  replicas: "1"
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: podinfo-values
+  namespace: apps
+data:
+  replicas: "2"
+`,
+			expectObjects: []string{"ConfigMap/apps/podinfo-values"},
+			expectData:    map[string]string{"replicas": "2"},
+		},
+		{
+			name: "documents which are no objects",
+			stream: `---
+# only a comment
+---
+just a string
+---
+- a
+- b
+---
+replicas: 2
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  namespace: apps
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: podinfo-credentials
+  namespace: apps
+`,
+			expectObjects: []string{"Secret/apps/podinfo-credentials"},
+		},
+		{
+			name: "lists",
+			stream: `apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: ConfigMap
+  metadata:
+    name: a
+    namespace: apps
+- apiVersion: v1
+  kind: ConfigMap
+  metadata:
+    name: b
+    namespace: apps
+---
+apiVersion: networking.example.com/v1
+kind: IPAllowList
+metadata:
+  name: office
+  namespace: apps
+`,
+			expectObjects: []string{"ConfigMap/apps/a", "ConfigMap/apps/b", "IPAllowList/apps/office"},
+		},
+		{
+			name:      "invalid yaml",
+			stream:    "apiVersion: v1\nkind: [ConfigMap\n",
+			expectErr: "failed to decode manifests",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			m, err := ReadResources(logr.Discard(), strings.NewReader(tt.stream), DocumentLimits{})
+			if tt.expectErr != "" {
+				g.Expect(err).To(MatchError(ContainSubstring(tt.expectErr)))
+				return
+			}
+
+			g.Expect(err).ToNot(HaveOccurred())
+
+			var objects []string
+			for _, res := range m.Resources() {
+				objects = append(objects, ResourceName(res.GetKind(), res.GetNamespace(), res.GetName()))
+				if tt.expectData != nil {
+					g.Expect(res.GetDataMap()).To(Equal(tt.expectData))
+				}
+			}
+			g.Expect(objects).To(ConsistOf(tt.expectObjects))
+
+			// The index of the builder is populated from the stream alone
+			index := make(ResourceIndex)
+			g.Expect(index.Push(m.Resources())).To(Succeed())
+			g.Expect(index).To(HaveLen(len(tt.expectObjects)))
+		})
+	}
+}
@@ -0,0 +1,442 @@
+package build
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/drone/envsubst/parse"
+)
+
+// Substitution is a variable substituted in a resource.
+type Substitution struct {
+	// Resource is the kind, namespace and name of the resource the variable was substituted in.
+	Resource string `json:"resource"`
+	// Variable is the name of the substituted variable.
+	Variable string `json:"variable"`
+	// Source is where the value was looked up, for instance env.
+	Source string `json:"source"`
+	// Value is the substituted value, it is redacted for variables which likely hold credentials.
+	Value string `json:"value"`
+}
+
+// EnvsubstAnnotation disables the substitution of a resource if set to disabled, for instance to keep ${...}
+// strings which are consumed by an operator in the cluster. It is honored for the environment substitution of
+// HelmReleases and the post build substitution of all resources of Flux Kustomizations.
+const EnvsubstAnnotation = "flux-build.doodlescheduling.com/envsubst"
+
+func substitutionDisabled(annotations map[string]string) bool {
+	return annotations[EnvsubstAnnotation] == "disabled"
+}
+
+// wellFormedVariable matches the variable expressions which are substituted in HelmReleases, a name optionally
+// followed by an operator supported by envsubst, for instance ${VAR}, ${VAR:=x} or ${VAR,,}.
+var wellFormedVariable = regexp.MustCompile(`^\$\{[A-Za-z_][A-Za-z0-9_]*((:[-=?+0-9]|[=,^#%/]).*)?\}$`)
+
+// literalReplacer escapes dollars and backslashes for envsubst.
+var literalReplacer = strings.NewReplacer(`$`, `$$`, `\\`, `\\\\`)
+
+// escapeLiterals escapes everything of the document envsubst would alter besides well-formed variables and doubled
+// dollars, which still pass through as a single dollar. Malformed expressions like ${aws:username}, expressions
+// referencing variables the allowlist does not allow and backslashes, which envsubst treats as escape characters, are
+// kept as they are.
+func escapeLiterals(document string, allowlist EnvAllowlist) string {
+	var b strings.Builder
+	for i := 0; i < len(document); i++ {
+		switch {
+		case strings.HasPrefix(document[i:], "$$"):
+			b.WriteString("$$")
+			i++
+		case strings.HasPrefix(document[i:], "${"):
+			end := variableEnd(document, i)
+			if end < 0 {
+				b.WriteString("$$")
+				continue
+			}
+
+			if wellFormedVariable.MatchString(document[i:end]) && allowlist.allowsAll(document[i:end]) {
+				b.WriteString(document[i:end])
+			} else {
+				b.WriteString(literalReplacer.Replace(document[i:end]))
+			}
+			i = end - 1
+		case document[i] == '\\':
+			b.WriteString(`\\`)
+		default:
+			b.WriteByte(document[i])
+		}
+	}
+
+	return b.String()
+}
+
+// variableEnd returns the end of the variable expression starting at i including nested expressions, or -1 if it is
+// not closed within the line.
+func variableEnd(document string, i int) int {
+	depth := 0
+	for j := i; j < len(document); j++ {
+		switch {
+		case document[j] == '\n':
+			return -1
+		case strings.HasPrefix(document[j:], "${"):
+			depth++
+			j++
+		case document[j] == '}':
+			depth--
+			if depth == 0 {
+				return j + 1
+			}
+		}
+	}
+
+	return -1
+}
+
+// envName matches valid names of environment variables.
+var envName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// LoadEnvDefaults reads the KEY=VALUE lines of an env file. Blank lines and lines starting with # are skipped,
+// a leading export and quotes around the value are stripped.
+func LoadEnvDefaults(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read env defaults: %w", err)
+	}
+	defer f.Close()
+
+	defaults := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name, value, ok := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		name = strings.TrimSpace(name)
+		if !ok || !envName.MatchString(name) {
+			return nil, fmt.Errorf("invalid env defaults %s:%d, expected KEY=VALUE: `%s`", path, n, line)
+		}
+
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+
+		defaults[name] = value
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read env defaults: %w", err)
+	}
+
+	return defaults, nil
+}
+
+// redactedValue replaces the value of sensitive substitutions.
+const redactedValue = "<redacted>"
+
+// sensitiveVariable matches the names of variables which likely hold credentials.
+var sensitiveVariable = regexp.MustCompile(`(?i)(secret|token|passw(or)?d|credential|private|key|auth)`)
+
+// SubstitutionRecorder collects the substitutions of a build. A nil recorder discards all substitutions.
+// It is passed to the substitution paths through the context, see WithSubstitutionRecorder.
+type SubstitutionRecorder struct {
+	mu            sync.Mutex
+	substitutions []Substitution
+}
+
+// NewSubstitutionRecorder returns an empty recorder.
+func NewSubstitutionRecorder() *SubstitutionRecorder {
+	return &SubstitutionRecorder{}
+}
+
+// Record adds a substitution, the value is redacted if sensitive is set or the variable name looks like a credential.
+func (r *SubstitutionRecorder) Record(s Substitution, sensitive bool) {
+	if r == nil {
+		return
+	}
+
+	if sensitive || sensitiveVariable.MatchString(s.Variable) {
+		s.Value = redactedValue
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.substitutions = append(r.substitutions, s)
+}
+
+// Substitutions returns the recorded substitutions sorted by resource and variable.
+func (r *SubstitutionRecorder) Substitutions() []Substitution {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	substitutions := append([]Substitution(nil), r.substitutions...)
+	r.mu.Unlock()
+
+	sort.SliceStable(substitutions, func(i, j int) bool {
+		if substitutions[i].Resource != substitutions[j].Resource {
+			return substitutions[i].Resource < substitutions[j].Resource
+		}
+		return substitutions[i].Variable < substitutions[j].Variable
+	})
+
+	return substitutions
+}
+
+type substitutionRecorderKey struct{}
+
+// WithSubstitutionRecorder returns a context which records the substitutions of a build into r.
+func WithSubstitutionRecorder(ctx context.Context, r *SubstitutionRecorder) context.Context {
+	return context.WithValue(ctx, substitutionRecorderKey{}, r)
+}
+
+// SubstitutionRecorderFrom returns the recorder of the context or nil if substitutions are not recorded.
+func SubstitutionRecorderFrom(ctx context.Context) *SubstitutionRecorder {
+	r, _ := ctx.Value(substitutionRecorderKey{}).(*SubstitutionRecorder)
+	return r
+}
+
+// UnsetVariableError is returned in strict mode for a variable which is neither set nor has a default.
+type UnsetVariableError struct {
+	// Resource is the kind, namespace and name of the resource the variable is referenced in.
+	Resource string
+	// Variable is the name of the unset variable.
+	Variable string
+	// Line is the first line referencing the variable without a default.
+	Line string
+}
+
+func (e *UnsetVariableError) Error() string {
+	return fmt.Sprintf("variable `%s` referenced in %s is not set: `%s`", e.Variable, e.Resource, e.Line)
+}
+
+// NestedVariableError is returned in strict mode for a substituted value which references a variable itself.
+// The substitution is a single pass, the referenced variable would be kept unresolved in the resource.
+type NestedVariableError struct {
+	// Resource is the kind, namespace and name of the resource the variable is substituted in.
+	Resource string
+	// Variable is the name of the substituted variable.
+	Variable string
+	// Nested is the variable expression within the value, for instance ${OTHER}.
+	Nested string
+}
+
+func (e *NestedVariableError) Error() string {
+	return fmt.Sprintf("value of variable `%s` substituted in %s references `%s` which is not expanded, substitution is a single pass", e.Variable, e.Resource, e.Nested)
+}
+
+// nestedVariable matches variable expressions within substituted values, an escaping dollar is matched as well.
+var nestedVariable = regexp.MustCompile(`\$?\$\{[A-Za-z_][A-Za-z0-9_]*((:[-=?+0-9]|[=,^#%/])[^}]*)?\}`)
+
+// checkNestedVariables returns a NestedVariableError for every substitution whose value references a variable, values
+// escaping the expression by a doubled dollar are fine.
+func checkNestedVariables(resource string, substitutions []Substitution) error {
+	var errs []error
+	seen := make(map[string]bool)
+	for _, s := range substitutions {
+		if seen[s.Variable] {
+			continue
+		}
+		seen[s.Variable] = true
+
+		for _, match := range nestedVariable.FindAllString(s.Value, -1) {
+			if strings.HasPrefix(match, "$$") {
+				continue
+			}
+
+			errs = append(errs, &NestedVariableError{Resource: resource, Variable: s.Variable, Nested: match})
+			break
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// defaultOperators are the operators of variables with a default value, for instance ${VAR:=x} or ${VAR:-x}.
+var defaultOperators = map[string]bool{"=": true, ":=": true, ":-": true}
+
+// checkUnsetVariables returns an UnsetVariableError for every variable of the document which is neither set
+// according to isSet nor has a default.
+func checkUnsetVariables(resource, document string, isSet func(name string) bool) error {
+	tree, err := parse.Parse(document)
+	if err != nil {
+		return err
+	}
+
+	var unset []string
+	seen := make(map[string]bool)
+	var walk func(node parse.Node)
+	walk = func(node parse.Node) {
+		switch node := node.(type) {
+		case *parse.ListNode:
+			for _, n := range node.Nodes {
+				walk(n)
+			}
+		case *parse.FuncNode:
+			for _, n := range node.Args {
+				walk(n)
+			}
+
+			if defaultOperators[node.Name] || seen[node.Param] || isSet(node.Param) {
+				return
+			}
+
+			seen[node.Param] = true
+			unset = append(unset, node.Param)
+		}
+	}
+	walk(tree.Root)
+
+	errs := make([]error, 0, len(unset))
+	for _, name := range unset {
+		errs = append(errs, &UnsetVariableError{
+			Resource: resource,
+			Variable: name,
+			Line:     variableLine(document, name),
+		})
+	}
+
+	return errors.Join(errs...)
+}
+
+// variableLine returns the first line of the document which references the variable without a default.
+func variableLine(document, name string) string {
+	reference := regexp.MustCompile(`\$\{` + regexp.QuoteMeta(name) + `([^\w:=]|:[^=-])`)
+	for _, line := range strings.Split(document, "\n") {
+		if reference.MatchString(line) {
+			return strings.TrimSpace(line)
+		}
+	}
+
+	return ""
+}
+
+// EnvAllowlist restricts the environment substitution to the variables it allows. Entries are variable names or
+// prefixes followed by *, for instance FLUX_*. An empty allowlist allows all variables.
+type EnvAllowlist []string
+
+// ParseEnvAllowlist validates the entries of an allowlist.
+func ParseEnvAllowlist(entries []string) (EnvAllowlist, error) {
+	var allowlist EnvAllowlist
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if name := strings.TrimSuffix(entry, "*"); name != "" && !envName.MatchString(name) || strings.Count(entry, "*") > 1 {
+			return nil, fmt.Errorf("invalid env allowlist entry `%s`, expected a variable name or a prefix followed by *", entry)
+		}
+
+		allowlist = append(allowlist, entry)
+	}
+
+	return allowlist, nil
+}
+
+// Allows reports whether the variable may be substituted.
+func (l EnvAllowlist) Allows(name string) bool {
+	if len(l) == 0 {
+		return true
+	}
+
+	for _, entry := range l {
+		if prefix, ok := strings.CutSuffix(entry, "*"); ok && strings.HasPrefix(name, prefix) || entry == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// allowsAll reports whether the allowlist allows all variables of the expression, including the ones within its
+// default value.
+func (l EnvAllowlist) allowsAll(expression string) bool {
+	if len(l) == 0 {
+		return true
+	}
+
+	for _, name := range variableNames(expression) {
+		if !l.Allows(name) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// variableNames returns the names of the variables of the document in the order they are referenced.
+func variableNames(document string) []string {
+	tree, err := parse.Parse(document)
+	if err != nil {
+		return nil
+	}
+
+	var names []string
+	var walk func(node parse.Node)
+	walk = func(node parse.Node) {
+		switch node := node.(type) {
+		case *parse.ListNode:
+			for _, n := range node.Nodes {
+				walk(n)
+			}
+		case *parse.FuncNode:
+			names = append(names, node.Param)
+			for _, n := range node.Args {
+				walk(n)
+			}
+		}
+	}
+	walk(tree.Root)
+
+	return names
+}
+
+// DisallowedVariableError is returned in strict mode for a variable which is referenced but not allowed by the
+// EnvAllowlist.
+type DisallowedVariableError struct {
+	// Resource is the kind, namespace and name of the resource the variable is referenced in.
+	Resource string
+	// Variable is the name of the variable.
+	Variable string
+	// Line is the first line referencing the variable.
+	Line string
+}
+
+func (e *DisallowedVariableError) Error() string {
+	return fmt.Sprintf("variable `%s` referenced in %s is not allowed by the env allowlist: `%s`", e.Variable, e.Resource, e.Line)
+}
+
+// checkDisallowedVariables returns a DisallowedVariableError for every variable of the document which the allowlist
+// does not allow. The document is expected to be escaped by escapeLiterals without an allowlist.
+func checkDisallowedVariables(resource, document string, allowlist EnvAllowlist) error {
+	var errs []error
+	seen := make(map[string]bool)
+	for _, name := range variableNames(document) {
+		if seen[name] || allowlist.Allows(name) {
+			continue
+		}
+		seen[name] = true
+
+		var line string
+		for _, l := range strings.Split(document, "\n") {
+			if strings.Contains(l, "${"+name) {
+				line = strings.TrimSpace(l)
+				break
+			}
+		}
+
+		errs = append(errs, &DisallowedVariableError{Resource: resource, Variable: name, Line: line})
+	}
+
+	return errors.Join(errs...)
+}
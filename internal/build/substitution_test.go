@@ -0,0 +1,535 @@
+package build
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/drone/envsubst"
+	"github.com/go-logr/logr"
+	. "github.com/onsi/gomega"
+)
+
+func TestSubstitutionRecorder(t *testing.T) {
+	g := NewWithT(t)
+
+	var discard *SubstitutionRecorder
+	discard.Record(Substitution{Variable: "CLUSTER"}, false)
+	g.Expect(discard.Substitutions()).To(BeNil())
+	g.Expect(SubstitutionRecorderFrom(context.Background())).To(BeNil())
+
+	r := NewSubstitutionRecorder()
+	ctx := WithSubstitutionRecorder(context.Background(), r)
+	g.Expect(SubstitutionRecorderFrom(ctx)).To(BeIdenticalTo(r))
+
+	r.Record(Substitution{Resource: "HelmRelease/default/podinfo", Variable: "REGISTRY_PASSWORD", Source: "env", Value: "s3cr3t"}, false)
+	r.Record(Substitution{Resource: "HelmRelease/default/podinfo", Variable: "CLUSTER", Source: "env", Value: "prod"}, false)
+	r.Record(Substitution{Resource: "HelmRelease/apps/podinfo", Variable: "REPLICAS", Source: "env", Value: "3"}, true)
+
+	g.Expect(r.Substitutions()).To(Equal([]Substitution{
+		{Resource: "HelmRelease/apps/podinfo", Variable: "REPLICAS", Source: "env", Value: redactedValue},
+		{Resource: "HelmRelease/default/podinfo", Variable: "CLUSTER", Source: "env", Value: "prod"},
+		{Resource: "HelmRelease/default/podinfo", Variable: "REGISTRY_PASSWORD", Source: "env", Value: redactedValue},
+	}))
+}
+
+func TestDecodeReleaseRecordsSubstitutions(t *testing.T) {
+	g := NewWithT(t)
+	t.Setenv("FLUX_BUILD_TEST_CLUSTER", "prod")
+	t.Setenv("FLUX_BUILD_TEST_TOKEN", "s3cr3t")
+
+	h := NewHelmBuilder(logr.Discard(), HelmOpts{})
+	r := NewSubstitutionRecorder()
+
+	hr, _, _, err := h.decodeRelease(WithSubstitutionRecorder(context.Background(), r), []byte(`apiVersion: helm.toolkit.fluxcd.io/v2
+kind: HelmRelease
+metadata:
+  name: podinfo
+  namespace: default
+spec:
+  releaseName: podinfo-${FLUX_BUILD_TEST_CLUSTER}
+  values:
+    cluster: ${FLUX_BUILD_TEST_CLUSTER}
+    token: ${FLUX_BUILD_TEST_TOKEN}
+    region: ${FLUX_BUILD_TEST_REGION:=eu-west-1}
+`))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(hr.Spec.ReleaseName).To(Equal("podinfo-prod"))
+
+	g.Expect(r.Substitutions()).To(Equal([]Substitution{
+		{Resource: "HelmRelease/default/podinfo", Variable: "FLUX_BUILD_TEST_CLUSTER", Source: "env", Value: "prod"},
+		{Resource: "HelmRelease/default/podinfo", Variable: "FLUX_BUILD_TEST_REGION", Source: "unset"},
+		{Resource: "HelmRelease/default/podinfo", Variable: "FLUX_BUILD_TEST_TOKEN", Source: "env", Value: redactedValue},
+	}))
+}
+
+func TestCheckUnsetVariables(t *testing.T) {
+	tests := []struct {
+		name      string
+		document  string
+		set       []string
+		expectErr string
+	}{
+		{
+			name:     "set",
+			document: "tag: ${TAG}\n",
+			set:      []string{"TAG"},
+		},
+		{
+			name:     "defaults and plain text",
+			document: "tag: ${TAG:=latest}\nregion: ${REGION:-eu}\nzone: ${ZONE=a}\nname: $NAME\n",
+		},
+		{
+			name:      "unset variable in default",
+			document:  "tag: ${TAG:=${FALLBACK}}\n",
+			expectErr: "variable `FALLBACK` referenced in HelmRelease/apps/podinfo is not set: `tag: ${TAG:=${FALLBACK}}`",
+		},
+		{
+			name:     "unset",
+			document: "spec:\n  values:\n    image:\n      tag: ${TAG:=latest}\n      digest: ${TAG}\n    name: ${NAME,,}\n    copy: ${TAG}\n",
+			expectErr: "variable `TAG` referenced in HelmRelease/apps/podinfo is not set: `digest: ${TAG}`\n" +
+				"variable `NAME` referenced in HelmRelease/apps/podinfo is not set: `name: ${NAME,,}`",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			err := checkUnsetVariables("HelmRelease/apps/podinfo", tt.document, func(name string) bool {
+				return slices.Contains(tt.set, name)
+			})
+			if tt.expectErr == "" {
+				g.Expect(err).ToNot(HaveOccurred())
+				return
+			}
+
+			g.Expect(err).To(HaveOccurred())
+			g.Expect(err.Error()).To(Equal(tt.expectErr))
+
+			var unsetErr *UnsetVariableError
+			g.Expect(errors.As(err, &unsetErr)).To(BeTrue())
+		})
+	}
+}
+
+func TestDecodeReleaseStrictEnv(t *testing.T) {
+	g := NewWithT(t)
+	t.Setenv("FLUX_BUILD_TEST_CLUSTER", "prod")
+
+	raw := []byte(`apiVersion: helm.toolkit.fluxcd.io/v2
+kind: HelmRelease
+metadata:
+  name: podinfo
+  namespace: default
+spec:
+  releaseName: podinfo-${FLUX_BUILD_TEST_CLUSTER}
+  values:
+    region: ${FLUX_BUILD_TEST_REGION:=eu-west-1}
+    image:
+      tag: ${FLUX_BUILD_TEST_TAG}
+`)
+
+	_, _, _, err := NewHelmBuilder(logr.Discard(), HelmOpts{}).decodeRelease(context.Background(), raw)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	_, _, _, err = NewHelmBuilder(logr.Discard(), HelmOpts{StrictEnv: true}).decodeRelease(context.Background(), raw)
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(err.Error()).To(Equal("failed to substitute envs: variable `FLUX_BUILD_TEST_TAG` referenced in HelmRelease/default/podinfo is not set: `tag: ${FLUX_BUILD_TEST_TAG}`"))
+}
+
+func TestDecodeReleaseNoEnvsubst(t *testing.T) {
+	t.Setenv("FLUX_BUILD_TEST_CLUSTER", "prod")
+
+	release := func(annotations string) []byte {
+		return []byte(`apiVersion: helm.toolkit.fluxcd.io/v2
+kind: HelmRelease
+metadata:
+  name: podinfo
+  namespace: default
+` + annotations + `spec:
+  releaseName: podinfo-${FLUX_BUILD_TEST_CLUSTER}
+  values:
+    template: ${FLUX_BUILD_TEST_TAG}
+`)
+	}
+
+	tests := []struct {
+		name              string
+		raw               []byte
+		opts              HelmOpts
+		expectReleaseName string
+		expectValues      string
+		expectRecorded    int
+	}{
+		{
+			name:              "substituted",
+			raw:               release(""),
+			expectReleaseName: "podinfo-prod",
+			expectValues:      `{"template":null}`,
+			expectRecorded:    2,
+		},
+		{
+			name:              "disabled globally",
+			raw:               release(""),
+			opts:              HelmOpts{NoEnvsubst: true, StrictEnv: true},
+			expectReleaseName: "podinfo-${FLUX_BUILD_TEST_CLUSTER}",
+			expectValues:      `{"template":"${FLUX_BUILD_TEST_TAG}"}`,
+		},
+		{
+			name:              "disabled by annotation",
+			raw:               release("  annotations:\n    flux-build.doodlescheduling.com/envsubst: disabled\n"),
+			opts:              HelmOpts{StrictEnv: true},
+			expectReleaseName: "podinfo-${FLUX_BUILD_TEST_CLUSTER}",
+			expectValues:      `{"template":"${FLUX_BUILD_TEST_TAG}"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			r := NewSubstitutionRecorder()
+			hr, _, _, err := NewHelmBuilder(logr.Discard(), tt.opts).decodeRelease(WithSubstitutionRecorder(context.Background(), r), tt.raw)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(hr.Spec.ReleaseName).To(Equal(tt.expectReleaseName))
+			g.Expect(string(hr.Spec.Values.Raw)).To(Equal(tt.expectValues))
+
+			g.Expect(r.Substitutions()).To(HaveLen(tt.expectRecorded))
+		})
+	}
+}
+
+func TestDecodeReleaseEnvDefaults(t *testing.T) {
+	raw := []byte(`apiVersion: helm.toolkit.fluxcd.io/v2
+kind: HelmRelease
+metadata:
+  name: podinfo
+  namespace: default
+spec:
+  values:
+    image:
+      tag: ${FLUX_BUILD_TEST_IMAGE_TAG:=latest}
+      pullPolicy: "${FLUX_BUILD_TEST_PULL_POLICY:-IfNotPresent}"
+`)
+
+	tests := []struct {
+		name         string
+		env          map[string]string
+		defaults     map[string]string
+		strict       bool
+		expectValues string
+		expectSource string
+		expectValue  string
+	}{
+		{
+			name:         "inline defaults",
+			strict:       true,
+			expectValues: `{"image":{"pullPolicy":"IfNotPresent","tag":"latest"}}`,
+			expectSource: "unset",
+		},
+		{
+			name:         "defaults file before inline defaults",
+			defaults:     map[string]string{"FLUX_BUILD_TEST_IMAGE_TAG": "6.0.0"},
+			expectValues: `{"image":{"pullPolicy":"IfNotPresent","tag":"6.0.0"}}`,
+			expectSource: "env-defaults",
+			expectValue:  "6.0.0",
+		},
+		{
+			name:         "environment before defaults file",
+			env:          map[string]string{"FLUX_BUILD_TEST_IMAGE_TAG": "6.1.0", "FLUX_BUILD_TEST_PULL_POLICY": "Always"},
+			defaults:     map[string]string{"FLUX_BUILD_TEST_IMAGE_TAG": "6.0.0"},
+			expectValues: `{"image":{"pullPolicy":"Always","tag":"6.1.0"}}`,
+			expectSource: "env",
+			expectValue:  "6.1.0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+
+			r := NewSubstitutionRecorder()
+			h := NewHelmBuilder(logr.Discard(), HelmOpts{EnvDefaults: tt.defaults, StrictEnv: tt.strict})
+			hr, _, _, err := h.decodeRelease(WithSubstitutionRecorder(context.Background(), r), raw)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(string(hr.Spec.Values.Raw)).To(Equal(tt.expectValues))
+			g.Expect(r.Substitutions()).To(ContainElement(Substitution{
+				Resource: "HelmRelease/default/podinfo",
+				Variable: "FLUX_BUILD_TEST_IMAGE_TAG",
+				Source:   tt.expectSource,
+				Value:    tt.expectValue,
+			}))
+		})
+	}
+}
+
+func TestLoadEnvDefaults(t *testing.T) {
+	g := NewWithT(t)
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, "defaults.env")
+	g.Expect(os.WriteFile(path, []byte("# image defaults\nIMAGE_TAG=6.0.0\n\nexport REGION = \"eu-west-1\"\nGREETING='hello world'\nEMPTY=\n"), 0644)).To(Succeed())
+
+	defaults, err := LoadEnvDefaults(path)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(defaults).To(Equal(map[string]string{
+		"IMAGE_TAG": "6.0.0",
+		"REGION":    "eu-west-1",
+		"GREETING":  "hello world",
+		"EMPTY":     "",
+	}))
+
+	invalid := filepath.Join(dir, "invalid.env")
+	g.Expect(os.WriteFile(invalid, []byte("IMAGE_TAG=6.0.0\nREGION\n"), 0644)).To(Succeed())
+
+	_, err = LoadEnvDefaults(invalid)
+	g.Expect(err).To(MatchError("invalid env defaults " + invalid + ":2, expected KEY=VALUE: `REGION`"))
+}
+
+func TestEscapeLiterals(t *testing.T) {
+	env := map[string]string{"CLUSTER": "prod", "DS_PROMETHEUS": "prometheus"}
+
+	tests := []struct {
+		name     string
+		document string
+		expect   string
+	}{
+		{
+			name:     "variables",
+			document: "name: ${CLUSTER}-${REGION:=eu-west-1}-${CLUSTER,,}",
+			expect:   "name: prod-eu-west-1-prod",
+		},
+		{
+			name:     "bcrypt hash",
+			document: "password: $2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy",
+			expect:   "password: $2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy",
+		},
+		{
+			name:     "escaped grafana variable",
+			document: `expr: rate(http_requests_total{job="$job"}[$__rate_interval]) by $${DS_PROMETHEUS}`,
+			expect:   `expr: rate(http_requests_total{job="$job"}[$__rate_interval]) by ${DS_PROMETHEUS}`,
+		},
+		{
+			name:     "grafana variable",
+			document: "datasource: ${DS_PROMETHEUS}",
+			expect:   "datasource: prometheus",
+		},
+		{
+			name:     "aws policy variable",
+			document: `"Resource": ["arn:aws:s3:::bucket/home/${aws:username}/*", "arn:aws:s3:::bucket/${CLUSTER}/*"]`,
+			expect:   `"Resource": ["arn:aws:s3:::bucket/home/${aws:username}/*", "arn:aws:s3:::bucket/prod/*"]`,
+		},
+		{
+			name:     "backslashes",
+			document: `path: "C:\\Users\\${CLUSTER}" pattern: a\/b\d`,
+			expect:   `path: "C:\\Users\\prod" pattern: a\/b\d`,
+		},
+		{
+			name:     "malformed expressions",
+			document: "a: ${} b: ${1} c: ${VAR-x} d: ${ unclosed\ne: ${CLUSTER}",
+			expect:   "a: ${} b: ${1} c: ${VAR-x} d: ${ unclosed\ne: prod",
+		},
+		{
+			name:     "doubled dollars",
+			document: "price: 5$$ shell: $$HOME $HOME",
+			expect:   "price: 5$ shell: $HOME $HOME",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			substituted, err := envsubst.Eval(escapeLiterals(tt.document, nil), func(name string) string {
+				return env[name]
+			})
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(substituted).To(Equal(tt.expect))
+		})
+	}
+}
+
+func TestDecodeReleaseKeepsLiterals(t *testing.T) {
+	g := NewWithT(t)
+	t.Setenv("FLUX_BUILD_TEST_CLUSTER", "prod")
+
+	hr, _, _, err := NewHelmBuilder(logr.Discard(), HelmOpts{StrictEnv: true}).decodeRelease(context.Background(), []byte(`apiVersion: helm.toolkit.fluxcd.io/v2
+kind: HelmRelease
+metadata:
+  name: podinfo
+  namespace: default
+spec:
+  values:
+    adminPassword: $2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy
+    dashboard: '{"datasource": "$${DS_PROMETHEUS}", "cluster": "${FLUX_BUILD_TEST_CLUSTER}"}'
+    policy: |
+      {"Effect": "Allow", "Resource": "arn:aws:s3:::bucket/home/${aws:username}/*"}
+    path: "C:\\data"
+`))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(string(hr.Spec.Values.Raw)).To(MatchJSON(`{
+		"adminPassword": "$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy",
+		"dashboard": "{\"datasource\": \"${DS_PROMETHEUS}\", \"cluster\": \"prod\"}",
+		"policy": "{\"Effect\": \"Allow\", \"Resource\": \"arn:aws:s3:::bucket/home/${aws:username}/*\"}\n",
+		"path": "C:\\data"
+	}`))
+}
+
+func TestDecodeReleaseSinglePass(t *testing.T) {
+	raw := []byte(`apiVersion: helm.toolkit.fluxcd.io/v2
+kind: HelmRelease
+metadata:
+  name: podinfo
+  namespace: default
+spec:
+  values:
+    value: "${FLUX_BUILD_TEST_VALUE}"
+`)
+
+	tests := []struct {
+		name         string
+		value        string
+		strict       bool
+		limits       *DocumentLimits
+		expectValues string
+		expectErr    string
+	}{
+		{
+			name:         "self-referencing variable",
+			value:        "${FLUX_BUILD_TEST_VALUE}",
+			expectValues: `{"value":"${FLUX_BUILD_TEST_VALUE}"}`,
+		},
+		{
+			name:      "self-referencing variable in strict mode",
+			value:     "${FLUX_BUILD_TEST_VALUE}",
+			strict:    true,
+			expectErr: "failed to substitute envs: value of variable `FLUX_BUILD_TEST_VALUE` substituted in HelmRelease/default/podinfo references `${FLUX_BUILD_TEST_VALUE}` which is not expanded, substitution is a single pass",
+		},
+		{
+			name:         "variable referencing another variable",
+			value:        "prefix-${FLUX_BUILD_TEST_OTHER:=x}",
+			expectValues: `{"value":"prefix-${FLUX_BUILD_TEST_OTHER:=x}"}`,
+		},
+		{
+			name:      "variable referencing another variable in strict mode",
+			value:     "prefix-${FLUX_BUILD_TEST_OTHER:=x}",
+			strict:    true,
+			expectErr: "references `${FLUX_BUILD_TEST_OTHER:=x}` which is not expanded",
+		},
+		{
+			name:         "dollars are kept",
+			value:        "a$b $ ${ $$ $${FLUX_BUILD_TEST_OTHER} ${aws:username}",
+			strict:       true,
+			expectValues: `{"value":"a$b $ ${ $$ $${FLUX_BUILD_TEST_OTHER} ${aws:username}"}`,
+		},
+		{
+			name:      "growth exceeds the limit",
+			value:     strings.Repeat("x", 100),
+			limits:    &DocumentLimits{MaxSubstitutionGrowth: 64},
+			expectErr: "document limit exceeded: environment substitution grew HelmRelease/default/podinfo by 76 bytes which exceeds the maximum of 64 bytes",
+		},
+		{
+			name:         "growth within the limit",
+			value:        strings.Repeat("x", 80),
+			limits:       &DocumentLimits{MaxSubstitutionGrowth: 64},
+			expectValues: `{"value":"` + strings.Repeat("x", 80) + `"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			t.Setenv("FLUX_BUILD_TEST_VALUE", tt.value)
+
+			h := NewHelmBuilder(logr.Discard(), HelmOpts{StrictEnv: tt.strict, DocumentLimits: tt.limits})
+			hr, _, _, err := h.decodeRelease(context.Background(), raw)
+			if tt.expectErr != "" {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tt.expectErr))
+				return
+			}
+
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(string(hr.Spec.Values.Raw)).To(Equal(tt.expectValues))
+		})
+	}
+}
+
+func TestParseEnvAllowlist(t *testing.T) {
+	g := NewWithT(t)
+
+	allowlist, err := ParseEnvAllowlist([]string{"CLUSTER", " FLUX_*", ""})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(allowlist).To(Equal(EnvAllowlist{"CLUSTER", "FLUX_*"}))
+	g.Expect(allowlist.Allows("CLUSTER")).To(BeTrue())
+	g.Expect(allowlist.Allows("FLUX_IMAGE_TAG")).To(BeTrue())
+	g.Expect(allowlist.Allows("CLUSTER_NAME")).To(BeFalse())
+	g.Expect(allowlist.Allows("HOME")).To(BeFalse())
+	g.Expect(EnvAllowlist(nil).Allows("HOME")).To(BeTrue())
+
+	for _, entry := range []string{"FLUX-*", "*FLUX", "FLUX_**", "1FLUX"} {
+		_, err := ParseEnvAllowlist([]string{entry})
+		g.Expect(err).To(MatchError("invalid env allowlist entry `" + entry + "`, expected a variable name or a prefix followed by *"))
+	}
+}
+
+func TestDecodeReleaseEnvAllowlist(t *testing.T) {
+	raw := []byte(`apiVersion: helm.toolkit.fluxcd.io/v2
+kind: HelmRelease
+metadata:
+  name: podinfo
+  namespace: default
+spec:
+  values:
+    cluster: ${FLUX_BUILD_TEST_CLUSTER}
+    tag: ${FLUX_BUILD_TEST_IMAGE_TAG:=${FLUX_BUILD_TEST_SECRET}}
+    secret: ${FLUX_BUILD_TEST_SECRET}
+`)
+
+	tests := []struct {
+		name         string
+		allowlist    EnvAllowlist
+		strict       bool
+		expectValues string
+		expectErr    string
+	}{
+		{
+			name:         "no allowlist",
+			expectValues: `{"cluster":"prod","secret":"hunter2","tag":"hunter2"}`,
+		},
+		{
+			name:         "disallowed variables are kept",
+			allowlist:    EnvAllowlist{"FLUX_BUILD_TEST_CLUSTER", "FLUX_BUILD_TEST_IMAGE_*"},
+			expectValues: `{"cluster":"prod","secret":"${FLUX_BUILD_TEST_SECRET}","tag":"${FLUX_BUILD_TEST_IMAGE_TAG:=${FLUX_BUILD_TEST_SECRET}}"}`,
+		},
+		{
+			name:      "disallowed variables fail in strict mode",
+			allowlist: EnvAllowlist{"FLUX_BUILD_TEST_CLUSTER", "FLUX_BUILD_TEST_IMAGE_*"},
+			strict:    true,
+			expectErr: "failed to substitute envs: variable `FLUX_BUILD_TEST_SECRET` referenced in HelmRelease/default/podinfo is not allowed by the env allowlist: `tag: ${FLUX_BUILD_TEST_IMAGE_TAG:=${FLUX_BUILD_TEST_SECRET}}`",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			t.Setenv("FLUX_BUILD_TEST_CLUSTER", "prod")
+			t.Setenv("FLUX_BUILD_TEST_SECRET", "hunter2")
+
+			h := NewHelmBuilder(logr.Discard(), HelmOpts{EnvAllowlist: tt.allowlist, StrictEnv: tt.strict})
+			hr, _, _, err := h.decodeRelease(context.Background(), raw)
+			if tt.expectErr != "" {
+				g.Expect(err).To(MatchError(tt.expectErr))
+
+				var disallowed *DisallowedVariableError
+				g.Expect(errors.As(err, &disallowed)).To(BeTrue())
+				return
+			}
+
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(string(hr.Spec.Values.Raw)).To(Equal(tt.expectValues))
+		})
+	}
+}
@@ -0,0 +1,16 @@
+package build
+
+import (
+	"sigs.k8s.io/kustomize/api/resource"
+)
+
+// Suspended returns true if spec.suspend of a Flux resource is set.
+func Suspended(r *resource.Resource) bool {
+	suspend, err := r.GetFieldValue("spec.suspend")
+	if err != nil {
+		return false
+	}
+
+	b, ok := suspend.(bool)
+	return ok && b
+}
@@ -0,0 +1,113 @@
+package build
+
+import (
+	"context"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"github.com/doodlescheduling/flux-build/internal/cachemgr"
+	"github.com/doodlescheduling/flux-build/internal/helm/chart"
+	"github.com/doodlescheduling/flux-build/internal/helm/repository"
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+	"github.com/go-logr/logr"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/kustomize/api/provider"
+	"sigs.k8s.io/kustomize/api/resmap"
+)
+
+func TestSuspended(t *testing.T) {
+	g := NewWithT(t)
+
+	m, err := resmap.NewFactory(provider.NewDefaultDepProvider().GetResourceFactory()).NewResMapFromBytes([]byte(`apiVersion: helm.toolkit.fluxcd.io/v2
+kind: HelmRelease
+metadata:
+  name: suspended
+spec:
+  suspend: true
+---
+apiVersion: helm.toolkit.fluxcd.io/v2
+kind: HelmRelease
+metadata:
+  name: resumed
+spec:
+  suspend: false
+---
+apiVersion: helm.toolkit.fluxcd.io/v2
+kind: HelmRelease
+metadata:
+  name: default
+spec: {}
+`))
+	g.Expect(err).ToNot(HaveOccurred())
+
+	var suspended []string
+	for _, r := range m.Resources() {
+		if Suspended(r) {
+			suspended = append(suspended, r.GetName())
+		}
+	}
+	g.Expect(suspended).To(Equal([]string{"suspended"}))
+}
+
+func TestBuildChartSuspendedRepository(t *testing.T) {
+	g := NewWithT(t)
+
+	var downloads atomic.Int32
+	server := newChartServer(t, &downloads)
+
+	cache, err := cachemgr.New("fs", t.TempDir())
+	g.Expect(err).ToNot(HaveOccurred())
+
+	h := NewHelmBuilder(logr.Discard(), HelmOpts{
+		Cache:         cache,
+		SkipSuspended: true,
+	})
+
+	repo := &sourcev1.HelmRepository{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "repo",
+			Namespace: "default",
+		},
+		Spec: sourcev1.HelmRepositorySpec{
+			URL:     server.URL,
+			Suspend: true,
+		},
+	}
+
+	hr := helmv2.HelmRelease{
+		Spec: helmv2.HelmReleaseSpec{
+			Chart: &helmv2.HelmChartTemplate{
+				Spec: helmv2.HelmChartTemplateSpec{
+					Chart:   "helmchart",
+					Version: "0.1.0",
+					SourceRef: helmv2.CrossNamespaceObjectReference{
+						Kind: sourcev1.HelmRepositoryKind,
+						Name: "repo",
+					},
+				},
+			},
+		},
+	}
+
+	err = h.buildChart(context.Background(), repo, hr, nil, &chart.Build{}, nil)
+	g.Expect(err).To(MatchError("helmrepository `default/repo` is suspended and chart `helmchart%0.1.0` is not cached"))
+
+	normalizedURL, err := repository.NormalizeURL(server.URL)
+	g.Expect(err).ToNot(HaveOccurred())
+	path, key, err := cache.GetOrLock(normalizedURL, chart.RemoteReference{Name: "helmchart", Version: "0.1.0"})
+	g.Expect(err).ToNot(HaveOccurred())
+	b, err := os.ReadFile("../helm/testdata/charts/helmchart-0.1.0.tgz")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(os.WriteFile(path, b, 0644)).To(Succeed())
+	g.Expect(cache.SetUnlock(key)).To(Succeed())
+
+	build := &chart.Build{}
+	g.Expect(h.buildChart(context.Background(), repo, hr, nil, build, nil)).To(Succeed())
+	g.Expect(build.Name).To(Equal("helmchart"))
+	g.Expect(build.Version).To(Equal("0.1.0"))
+	g.Expect(build.Path).To(Equal(path))
+	g.Expect(downloads.Load()).To(BeZero())
+}
@@ -0,0 +1,50 @@
+package build
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// ReleaseTimings are the durations of the operations building a HelmRelease. Operations which are skipped, for
+// instance as an earlier one failed, have no duration.
+type ReleaseTimings struct {
+	// Chart is the duration of resolving, pulling and loading the chart.
+	Chart time.Duration
+	// Values is the duration of composing the values from valuesFrom, spec.values, overlays and overrides.
+	Values time.Duration
+	// Render is the duration of rendering the chart, with CheckDeterminism both renders.
+	Render time.Duration
+	// Kustomize is the duration of building the rendered manifests with kustomize.
+	Kustomize time.Duration
+	// Total is the duration of the whole build of the HelmRelease.
+	Total time.Duration
+}
+
+// MarshalJSON encodes the durations as strings like 1.5s.
+func (t ReleaseTimings) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]string{
+		"chart":     t.Chart.String(),
+		"values":    t.Values.String(),
+		"render":    t.Render.String(),
+		"kustomize": t.Kustomize.String(),
+		"total":     t.Total.String(),
+	})
+}
+
+type releaseTimingsKey struct{}
+
+// WithReleaseTimings returns a context which records the durations of the operations building the HelmRelease built
+// with it into t.
+func WithReleaseTimings(ctx context.Context, t *ReleaseTimings) context.Context {
+	return context.WithValue(ctx, releaseTimingsKey{}, t)
+}
+
+// releaseTimingsFrom returns the timings of the context, timings which are recorded nowhere otherwise.
+func releaseTimingsFrom(ctx context.Context) *ReleaseTimings {
+	if t, ok := ctx.Value(releaseTimingsKey{}).(*ReleaseTimings); ok && t != nil {
+		return t
+	}
+
+	return &ReleaseTimings{}
+}
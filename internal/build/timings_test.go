@@ -0,0 +1,69 @@
+package build
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/doodlescheduling/flux-build/internal/cachemgr"
+	"github.com/go-logr/logr"
+	. "github.com/onsi/gomega"
+)
+
+func TestBuildRecordsTimings(t *testing.T) {
+	g := NewWithT(t)
+
+	var downloads atomic.Int32
+	server := newChartServer(t, &downloads)
+
+	db := newResourceIndex(t, fmt.Sprintf(`apiVersion: source.toolkit.fluxcd.io/v1
+kind: HelmRepository
+metadata:
+  name: repo
+  namespace: default
+spec:
+  url: %s
+---
+apiVersion: helm.toolkit.fluxcd.io/v2
+kind: HelmRelease
+metadata:
+  name: app
+  namespace: default
+spec:
+  chart:
+    spec:
+      chart: helmchart
+      version: "0.1.0"
+      sourceRef:
+        kind: HelmRepository
+        name: repo
+`, server.URL))
+
+	cache, err := cachemgr.New("none", "")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	h := NewHelmBuilder(logr.Discard(), HelmOpts{Cache: cache})
+	t.Cleanup(func() { _ = h.Close() })
+
+	timings := &ReleaseTimings{}
+	hr := db[ref{GroupKind: HelmReleaseGroupKind, Name: "app", Namespace: "default"}]
+	_, err = h.Build(WithReleaseTimings(context.Background(), timings), hr, db)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	g.Expect(timings.Chart).To(BeNumerically(">", 0))
+	g.Expect(timings.Values).To(BeNumerically(">", 0))
+	g.Expect(timings.Render).To(BeNumerically(">", 0))
+	g.Expect(timings.Kustomize).To(BeNumerically(">", 0))
+	g.Expect(timings.Total).To(BeNumerically(">=", timings.Chart+timings.Values+timings.Render+timings.Kustomize))
+}
+
+func TestReleaseTimingsJSON(t *testing.T) {
+	g := NewWithT(t)
+
+	b, err := json.Marshal(ReleaseTimings{Chart: 1500 * time.Millisecond, Render: 20 * time.Millisecond, Total: 2 * time.Second})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(string(b)).To(Equal(`{"chart":"1.5s","kustomize":"0s","render":"20ms","total":"2s","values":"0s"}`))
+}
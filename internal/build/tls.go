@@ -0,0 +1,151 @@
+package build
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TLSMinVersionAnnotation relaxes the minimum TLS version of the TLSPolicy for a single HelmRepository or
+// OCIRepository, for instance to "1.0". It is only honored for hosts listed in TLSPolicy.RelaxedHosts.
+const TLSMinVersionAnnotation = "flux-build.doodlescheduling.com/tls-min-version"
+
+// TLSPolicy restricts the TLS connections to chart repositories and OCI registries.
+type TLSPolicy struct {
+	// MinVersion is the minimum TLS version, for instance tls.VersionTLS12.
+	MinVersion uint16
+	// CipherSuites restricts the cipher suites of TLS 1.0 to 1.2, the Go defaults are used if empty.
+	// The cipher suites of TLS 1.3 are not configurable.
+	CipherSuites []uint16
+	// RelaxedHosts are the hosts (host or host:port) whose repositories may relax the policy by the
+	// TLSMinVersionAnnotation.
+	RelaxedHosts []string
+}
+
+// Config returns a TLS config which enforces the policy.
+func (p *TLSPolicy) Config() *tls.Config {
+	return &tls.Config{
+		MinVersion:   p.MinVersion,
+		CipherSuites: p.CipherSuites,
+	}
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ParseTLSVersion parses a TLS version like 1.2.
+func ParseTLSVersion(version string) (uint16, error) {
+	v, ok := tlsVersions[strings.TrimPrefix(version, "TLS")]
+	if !ok {
+		return 0, fmt.Errorf("unknown TLS version `%s`, expected one of 1.0, 1.1, 1.2 or 1.3", version)
+	}
+
+	return v, nil
+}
+
+// ParseCipherSuites parses the names of cipher suites like TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256.
+func ParseCipherSuites(names []string) ([]uint16, error) {
+	suites := append(tls.CipherSuites(), tls.InsecureCipherSuites()...)
+
+	var ids []uint16
+	for _, name := range names {
+		i := slices.IndexFunc(suites, func(suite *tls.CipherSuite) bool {
+			return suite.Name == name
+		})
+
+		if i == -1 {
+			return nil, fmt.Errorf("unknown TLS cipher suite `%s`", name)
+		}
+
+		ids = append(ids, suites[i].ID)
+	}
+
+	return ids, nil
+}
+
+// tlsConfig applies the TLSPolicy to the TLS config of a repository, base is never modified.
+// The config is nil if neither base nor a policy is set.
+func (h *Helm) tlsConfig(obj metav1.Object, repositoryURL string, base *tls.Config) (*tls.Config, error) {
+	policy := h.opts.TLSPolicy
+	if policy == nil {
+		return base, nil
+	}
+
+	cfg := &tls.Config{}
+	if base != nil {
+		cfg = base.Clone()
+	}
+
+	cfg.MinVersion = policy.MinVersion
+	cfg.CipherSuites = policy.CipherSuites
+
+	version, ok := obj.GetAnnotations()[TLSMinVersionAnnotation]
+	if !ok {
+		return cfg, nil
+	}
+
+	host := repositoryHost(repositoryURL)
+	if !slices.Contains(policy.RelaxedHosts, host) {
+		return nil, fmt.Errorf("%s/%s relaxes the TLS policy by the %s annotation but the host `%s` is not a relaxed host", obj.GetNamespace(), obj.GetName(), TLSMinVersionAnnotation, host)
+	}
+
+	minVersion, err := ParseTLSVersion(version)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s annotation of %s/%s: %w", TLSMinVersionAnnotation, obj.GetNamespace(), obj.GetName(), err)
+	}
+
+	cfg.MinVersion = minVersion
+	cfg.CipherSuites = nil
+	return cfg, nil
+}
+
+// repositoryHost returns the host of a repository URL with or without a scheme like oci://.
+func repositoryHost(repositoryURL string) string {
+	if !strings.Contains(repositoryURL, "://") {
+		repositoryURL = "https://" + repositoryURL
+	}
+
+	u, err := url.Parse(repositoryURL)
+	if err != nil {
+		return ""
+	}
+
+	return u.Host
+}
+
+// unsupportedTLSVersion matches the error of a handshake with a server which negotiated a TLS version below MinVersion.
+var unsupportedTLSVersion = regexp.MustCompile(`tls: server selected unsupported protocol version ([0-9a-f]+)`)
+
+// explainTLSError adds the negotiated and the required TLS versions to the error of a failed handshake.
+func (h *Helm) explainTLSError(err error) error {
+	if err == nil || h.opts.TLSPolicy == nil {
+		return err
+	}
+
+	match := unsupportedTLSVersion.FindStringSubmatch(err.Error())
+	if match == nil {
+		// The server rejected the versions offered by the client
+		if strings.Contains(err.Error(), "tls: protocol version not supported") {
+			return fmt.Errorf("%w (the TLS policy requires at least %s)", err, tls.VersionName(h.opts.TLSPolicy.MinVersion))
+		}
+
+		return err
+	}
+
+	negotiated, parseErr := strconv.ParseUint(match[1], 16, 16)
+	if parseErr != nil {
+		return err
+	}
+
+	return fmt.Errorf("%w (the server negotiated %s but the TLS policy requires at least %s)", err, tls.VersionName(uint16(negotiated)), tls.VersionName(h.opts.TLSPolicy.MinVersion))
+}
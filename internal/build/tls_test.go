@@ -0,0 +1,557 @@
+package build
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/doodlescheduling/flux-build/internal/cachemgr"
+	"github.com/doodlescheduling/flux-build/internal/helm/chart"
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"github.com/fluxcd/pkg/apis/meta"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/funcr"
+	"github.com/google/go-containerregistry/pkg/registry"
+	. "github.com/onsi/gomega"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	helmreg "helm.sh/helm/v3/pkg/registry"
+	helmrepo "helm.sh/helm/v3/pkg/repo"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+func TestParseTLSVersion(t *testing.T) {
+	g := NewWithT(t)
+
+	v, err := ParseTLSVersion("1.2")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(v).To(Equal(uint16(tls.VersionTLS12)))
+
+	v, err = ParseTLSVersion("TLS1.3")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(v).To(Equal(uint16(tls.VersionTLS13)))
+
+	_, err = ParseTLSVersion("1.4")
+	g.Expect(err).To(MatchError("unknown TLS version `1.4`, expected one of 1.0, 1.1, 1.2 or 1.3"))
+}
+
+func TestParseCipherSuites(t *testing.T) {
+	g := NewWithT(t)
+
+	suites, err := ParseCipherSuites([]string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_RSA_WITH_AES_128_CBC_SHA"})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(suites).To(Equal([]uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_RSA_WITH_AES_128_CBC_SHA}))
+
+	_, err = ParseCipherSuites([]string{"TLS_NULL"})
+	g.Expect(err).To(MatchError("unknown TLS cipher suite `TLS_NULL`"))
+}
+
+func TestTLSConfig(t *testing.T) {
+	policy := &TLSPolicy{
+		MinVersion:   tls.VersionTLS12,
+		CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+		RelaxedHosts: []string{"legacy.example.com"},
+	}
+
+	tests := []struct {
+		name             string
+		policy           *TLSPolicy
+		url              string
+		annotation       string
+		base             *tls.Config
+		expectNil        bool
+		expectMinVersion uint16
+		expectSuites     []uint16
+		expectErr        string
+	}{
+		{
+			name:      "no policy",
+			url:       "https://charts.example.com",
+			expectNil: true,
+		},
+		{
+			name:             "policy",
+			policy:           policy,
+			url:              "https://charts.example.com",
+			expectMinVersion: tls.VersionTLS12,
+			expectSuites:     []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+		},
+		{
+			name:             "policy applied to the config of a secret",
+			policy:           policy,
+			url:              "oci://registry.example.com/charts",
+			base:             &tls.Config{ServerName: "registry.example.com", MinVersion: tls.VersionTLS10},
+			expectMinVersion: tls.VersionTLS12,
+			expectSuites:     []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+		},
+		{
+			name:             "relaxed host",
+			policy:           policy,
+			url:              "https://legacy.example.com/charts",
+			annotation:       "1.0",
+			expectMinVersion: tls.VersionTLS10,
+		},
+		{
+			name:       "relaxed by a host which is not allowed to",
+			policy:     policy,
+			url:        "https://charts.example.com",
+			annotation: "1.0",
+			expectErr:  "default/repo relaxes the TLS policy by the flux-build.doodlescheduling.com/tls-min-version annotation but the host `charts.example.com` is not a relaxed host",
+		},
+		{
+			name:       "invalid annotation",
+			policy:     policy,
+			url:        "https://legacy.example.com/charts",
+			annotation: "ssl3",
+			expectErr:  "invalid flux-build.doodlescheduling.com/tls-min-version annotation of default/repo",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			h := NewHelmBuilder(logr.Discard(), HelmOpts{
+				TLSPolicy: tt.policy,
+			})
+
+			repo := &sourcev1.HelmRepository{
+				ObjectMeta: metav1.ObjectMeta{Name: "repo", Namespace: "default"},
+			}
+			if tt.annotation != "" {
+				repo.Annotations = map[string]string{TLSMinVersionAnnotation: tt.annotation}
+			}
+
+			var baseMinVersion uint16
+			if tt.base != nil {
+				baseMinVersion = tt.base.MinVersion
+			}
+
+			cfg, err := h.tlsConfig(repo, tt.url, tt.base)
+			if tt.expectErr != "" {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tt.expectErr))
+				return
+			}
+
+			g.Expect(err).ToNot(HaveOccurred())
+			if tt.expectNil {
+				g.Expect(cfg).To(BeNil())
+				return
+			}
+
+			g.Expect(cfg.MinVersion).To(Equal(tt.expectMinVersion))
+			g.Expect(cfg.CipherSuites).To(Equal(tt.expectSuites))
+
+			if tt.base != nil {
+				g.Expect(cfg.ServerName).To(Equal(tt.base.ServerName))
+				g.Expect(tt.base.MinVersion).To(Equal(baseMinVersion))
+			}
+		})
+	}
+}
+
+func TestExplainTLSError(t *testing.T) {
+	g := NewWithT(t)
+
+	h := NewHelmBuilder(logr.Discard(), HelmOpts{
+		TLSPolicy: &TLSPolicy{MinVersion: tls.VersionTLS12},
+	})
+
+	err := h.explainTLSError(errors.New("Get \"https://legacy.example.com/index.yaml\": tls: server selected unsupported protocol version 301"))
+	g.Expect(err).To(MatchError("Get \"https://legacy.example.com/index.yaml\": tls: server selected unsupported protocol version 301 (the server negotiated TLS 1.0 but the TLS policy requires at least TLS 1.2)"))
+
+	err = h.explainTLSError(errors.New("chart not found"))
+	g.Expect(err).To(MatchError("chart not found"))
+}
+
+func TestBuildChartTLSPolicy(t *testing.T) {
+	c, err := loader.Load(testChart)
+	NewWithT(t).Expect(err).ToNot(HaveOccurred())
+	archive, err := os.ReadFile(testChart)
+	NewWithT(t).Expect(err).ToNot(HaveOccurred())
+
+	mux := http.NewServeMux()
+	server := httptest.NewUnstartedServer(mux)
+	server.TLS = &tls.Config{MinVersion: tls.VersionTLS11, MaxVersion: tls.VersionTLS11}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	index := helmrepo.NewIndexFile()
+	NewWithT(t).Expect(index.MustAdd(c.Metadata, "helmchart-0.1.0.tgz", server.URL, "")).To(Succeed())
+	indexYAML, err := yaml.Marshal(index)
+	NewWithT(t).Expect(err).ToNot(HaveOccurred())
+
+	mux.HandleFunc("/index.yaml", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(indexYAML)
+	})
+	mux.HandleFunc("/helmchart-0.1.0.tgz", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(archive)
+	})
+
+	caSecret, err := yaml.Marshal(corev1.Secret{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+		ObjectMeta: metav1.ObjectMeta{Name: "ca", Namespace: "default"},
+		Data: map[string][]byte{
+			"ca.crt": pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}),
+		},
+	})
+	NewWithT(t).Expect(err).ToNot(HaveOccurred())
+	db := newResourceIndex(t, string(caSecret))
+
+	u, err := url.Parse(server.URL)
+	NewWithT(t).Expect(err).ToNot(HaveOccurred())
+
+	tests := []struct {
+		name         string
+		relaxedHosts []string
+		annotation   string
+		expectErr    string
+	}{
+		{
+			// The server rejects the client hello as it does not support any of the offered versions
+			name:      "legacy server",
+			expectErr: "tls: protocol version not supported (the TLS policy requires at least TLS 1.2)",
+		},
+		{
+			name:         "relaxed legacy server",
+			relaxedHosts: []string{u.Host},
+			annotation:   "1.1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			cache, err := cachemgr.New("none", "")
+			g.Expect(err).ToNot(HaveOccurred())
+
+			h := NewHelmBuilder(logr.Discard(), HelmOpts{
+				Cache: cache,
+				TLSPolicy: &TLSPolicy{
+					MinVersion:   tls.VersionTLS12,
+					RelaxedHosts: tt.relaxedHosts,
+				},
+			})
+
+			repo := &sourcev1.HelmRepository{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "legacy",
+					Namespace: "default",
+				},
+				Spec: sourcev1.HelmRepositorySpec{
+					URL:           server.URL,
+					CertSecretRef: &meta.LocalObjectReference{Name: "ca"},
+				},
+			}
+			if tt.annotation != "" {
+				repo.Annotations = map[string]string{TLSMinVersionAnnotation: tt.annotation}
+			}
+
+			hr := helmv2.HelmRelease{
+				Spec: helmv2.HelmReleaseSpec{
+					Chart: &helmv2.HelmChartTemplate{
+						Spec: helmv2.HelmChartTemplateSpec{
+							Chart:   "helmchart",
+							Version: "0.1.0",
+						},
+					},
+				},
+			}
+
+			build := &chart.Build{}
+			err = h.explainTLSError(h.buildChart(context.Background(), repo, hr, nil, build, db))
+			if tt.expectErr != "" {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tt.expectErr))
+				return
+			}
+
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(build.Name).To(Equal("helmchart"))
+		})
+	}
+}
+
+func TestBuildChartOCIClientCertificate(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	NewWithT(t).Expect(err).ToNot(HaveOccurred())
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	NewWithT(t).Expect(err).ToNot(HaveOccurred())
+	certPEM := newCertificatePEM(t, key)
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	clientCAs := x509.NewCertPool()
+	NewWithT(t).Expect(clientCAs.AppendCertsFromPEM(certPEM)).To(BeTrue())
+
+	// The registry requires mutual TLS for every request
+	server := httptest.NewUnstartedServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+
+	// Helm talks plain HTTP to registries on localhost, the registry is accessed as example.com (which its
+	// certificate is issued for) through a proxy tunneling every connection to it instead
+	proxyURL := newTunnelProxy(t, server.Listener.Addr().String())
+
+	clientCert, err := tls.X509KeyPair(certPEM, keyPEM)
+	NewWithT(t).Expect(err).ToNot(HaveOccurred())
+	pushTransport := server.Client().Transport.(*http.Transport).Clone()
+	pushTransport.TLSClientConfig.Certificates = []tls.Certificate{clientCert}
+	pushTransport.TLSClientConfig.ServerName = "example.com"
+	pushTransport.Proxy = http.ProxyURL(proxyURL)
+	pushClient, err := helmreg.NewClient(helmreg.ClientOptHTTPClient(&http.Client{Transport: pushTransport}), helmreg.ClientOptWriter(io.Discard))
+	NewWithT(t).Expect(err).ToNot(HaveOccurred())
+
+	archive, err := os.ReadFile(testChart)
+	NewWithT(t).Expect(err).ToNot(HaveOccurred())
+	_, err = pushClient.Push(archive, "example.com/charts/helmchart:0.1.0")
+	NewWithT(t).Expect(err).ToNot(HaveOccurred())
+
+	tests := []struct {
+		name      string
+		data      map[string][]byte
+		expectErr string
+	}{
+		{
+			name: "client certificate",
+			data: map[string][]byte{
+				"certFile": certPEM,
+				"keyFile":  keyPEM,
+				"caFile":   caPEM,
+			},
+		},
+		{
+			name: "without client certificate",
+			data: map[string][]byte{
+				"caFile": caPEM,
+			},
+			expectErr: "certificate required",
+		},
+	}
+	retryMax := 0
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			secret, err := yaml.Marshal(corev1.Secret{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+				ObjectMeta: metav1.ObjectMeta{Name: "mtls", Namespace: "default"},
+				Data:       tt.data,
+			})
+			g.Expect(err).ToNot(HaveOccurred())
+			db := newResourceIndex(t, string(secret))
+
+			cache, err := cachemgr.New("none", "")
+			g.Expect(err).ToNot(HaveOccurred())
+			h := NewHelmBuilder(logr.Discard(), HelmOpts{
+				Cache:             cache,
+				Proxy:             proxyURL,
+				NoDefaultKeychain: true,
+				RetryMax:          &retryMax,
+			})
+
+			repo := &sourcev1.HelmRepository{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "mtls",
+					Namespace: "default",
+				},
+				Spec: sourcev1.HelmRepositorySpec{
+					URL:       "oci://example.com/charts",
+					Type:      sourcev1.HelmRepositoryTypeOCI,
+					SecretRef: &meta.LocalObjectReference{Name: "mtls"},
+				},
+			}
+
+			hr := helmv2.HelmRelease{
+				Spec: helmv2.HelmReleaseSpec{
+					Chart: &helmv2.HelmChartTemplate{
+						Spec: helmv2.HelmChartTemplateSpec{
+							Chart:   "helmchart",
+							Version: "0.1.0",
+						},
+					},
+				},
+			}
+
+			build := &chart.Build{}
+			err = h.buildChart(context.Background(), repo, hr, nil, build, db)
+			if tt.expectErr != "" {
+				g.Expect(err).To(MatchError(ContainSubstring(tt.expectErr)))
+				return
+			}
+
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(build.Name).To(Equal("helmchart"))
+			g.Expect(build.Version).To(Equal("0.1.0"))
+		})
+	}
+}
+
+// newTunnelProxy starts a proxy which tunnels every CONNECT request to target and returns its URL. NO_PROXY is
+// cleared so requests to any host go through it.
+func newTunnelProxy(t *testing.T, target string) *url.URL {
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		upstream, err := net.Dial("tcp", target)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			upstream.Close()
+			return
+		}
+
+		_, _ = conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		go func() {
+			_, _ = io.Copy(upstream, conn)
+			upstream.Close()
+		}()
+		go func() {
+			_, _ = io.Copy(conn, upstream)
+			conn.Close()
+		}()
+	}))
+	t.Cleanup(proxy.Close)
+	t.Setenv("NO_PROXY", "")
+
+	proxyURL, err := url.Parse(proxy.URL)
+	NewWithT(t).Expect(err).ToNot(HaveOccurred())
+	return proxyURL
+}
+
+func TestBuildChartOCISkipTLSVerify(t *testing.T) {
+	// The registry has a self-signed certificate for example.com
+	server := httptest.NewUnstartedServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	proxyURL := newTunnelProxy(t, server.Listener.Addr().String())
+
+	pushTransport := server.Client().Transport.(*http.Transport).Clone()
+	pushTransport.TLSClientConfig.ServerName = "example.com"
+	pushTransport.Proxy = http.ProxyURL(proxyURL)
+	pushClient, err := helmreg.NewClient(helmreg.ClientOptHTTPClient(&http.Client{Transport: pushTransport}), helmreg.ClientOptWriter(io.Discard))
+	NewWithT(t).Expect(err).ToNot(HaveOccurred())
+
+	archive, err := os.ReadFile(testChart)
+	NewWithT(t).Expect(err).ToNot(HaveOccurred())
+	_, err = pushClient.Push(archive, "example.com/charts/helmchart:0.1.0")
+	NewWithT(t).Expect(err).ToNot(HaveOccurred())
+
+	tests := []struct {
+		name          string
+		skipTLSVerify []string
+		expectErr     string
+		expectWarning bool
+	}{
+		{
+			name:      "certificate verified",
+			expectErr: "certificate signed by unknown authority",
+		},
+		{
+			name:          "certificate of another registry not verified",
+			skipTLSVerify: []string{"registry.example.com"},
+			expectErr:     "certificate signed by unknown authority",
+		},
+		{
+			name:          "certificate not verified",
+			skipTLSVerify: []string{"example.com"},
+			expectWarning: true,
+		},
+	}
+	retryMax := 0
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			var logs []string
+			logger := funcr.New(func(prefix, args string) {
+				logs = append(logs, args)
+			}, funcr.Options{})
+
+			cache, err := cachemgr.New("none", "")
+			g.Expect(err).ToNot(HaveOccurred())
+			h := NewHelmBuilder(logger, HelmOpts{
+				Cache:             cache,
+				Proxy:             proxyURL,
+				NoDefaultKeychain: true,
+				RetryMax:          &retryMax,
+				SkipTLSVerify:     tt.skipTLSVerify,
+			})
+
+			repo := &sourcev1.HelmRepository{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "self-signed",
+					Namespace: "default",
+				},
+				Spec: sourcev1.HelmRepositorySpec{
+					URL:  "oci://example.com/charts",
+					Type: sourcev1.HelmRepositoryTypeOCI,
+				},
+			}
+
+			hr := helmv2.HelmRelease{
+				Spec: helmv2.HelmReleaseSpec{
+					Chart: &helmv2.HelmChartTemplate{
+						Spec: helmv2.HelmChartTemplateSpec{
+							Chart:   "helmchart",
+							Version: "0.1.0",
+						},
+					},
+				},
+			}
+
+			// The warning is logged once per registry
+			for i := 0; i < 2; i++ {
+				build := &chart.Build{}
+				err = h.buildChart(context.Background(), repo, hr, nil, build, nil)
+				if tt.expectErr != "" {
+					g.Expect(err).To(MatchError(ContainSubstring(tt.expectErr)))
+					continue
+				}
+
+				g.Expect(err).ToNot(HaveOccurred())
+				g.Expect(build.Name).To(Equal("helmchart"))
+			}
+
+			var warnings []string
+			for _, l := range logs {
+				if strings.Contains(l, "WARNING") {
+					warnings = append(warnings, l)
+				}
+			}
+			if !tt.expectWarning {
+				g.Expect(warnings).To(BeEmpty())
+				return
+			}
+
+			g.Expect(warnings).To(HaveLen(1))
+			g.Expect(warnings[0]).To(ContainSubstring(`"registry"="example.com" "reason"="tls certificate not verified"`))
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package build
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	helmchart "helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+)
+
+// UnusedValuesAnnotation disables the check for values keys the chart never consumes of a HelmRelease if set to
+// disabled, for instance for charts passing arbitrary values to their templates.
+const UnusedValuesAnnotation = "flux-build.doodlescheduling.com/unused-values"
+
+// UnusedValue is a key of the values of a HelmRelease which is neither part of the default values nor of the
+// values.schema.json of its chart, for instance a misspelled key.
+type UnusedValue struct {
+	// Chart is the name of the chart the key is unknown to, subcharts are prefixed by their parents.
+	Chart string
+	// Path is the JSON path of the key within the values of the HelmRelease, for instance $.replicacount.
+	Path string
+}
+
+// findUnusedValues returns the keys of values which are unknown to the chart and its subcharts sorted by path.
+// Keys below a map whose default is empty or null, or whose schema allows additional properties, are free-form and
+// never reported. Paths matching allowed (dotted paths, * matches a single key) are skipped with all keys below.
+func findUnusedValues(chart *helmchart.Chart, values chartutil.Values, allowed []string) []UnusedValue {
+	unused := unusedChartValues(chart, values, chart.Name(), nil, allowed)
+	sort.SliceStable(unused, func(i, j int) bool {
+		return unused[i].Path < unused[j].Path
+	})
+
+	return unused
+}
+
+func unusedChartValues(chart *helmchart.Chart, values map[string]interface{}, name string, path []string, allowed []string) []UnusedValue {
+	var schema map[string]interface{}
+	if chart.Schema != nil {
+		// An invalid schema is reported by the schema validation
+		_ = json.Unmarshal(chart.Schema, &schema)
+	}
+
+	subcharts := make(map[string]*helmchart.Chart)
+	for _, subchart := range chart.Dependencies() {
+		subcharts[subchart.Name()] = subchart
+	}
+
+	// Dependencies may be disabled and missing from the chart, their values are never reported. Their conditions
+	// are consumed by Helm itself.
+	dependencies := make(map[string]bool)
+	allowed = append([]string(nil), allowed...)
+	if chart.Metadata != nil {
+		for _, d := range chart.Metadata.Dependencies {
+			for _, condition := range strings.Split(d.Condition, ",") {
+				if condition = strings.TrimSpace(condition); condition != "" {
+					allowed = append(allowed, strings.Join(childPath(path, condition), "."))
+				}
+			}
+
+			dependencies[d.Name] = true
+			if d.Alias != "" {
+				dependencies[d.Alias] = true
+				if subchart, ok := subcharts[d.Name]; ok {
+					subcharts[d.Alias] = subchart
+				}
+			}
+		}
+	}
+
+	var unused []UnusedValue
+	chartValues := make(map[string]interface{}, len(values))
+	for key, value := range values {
+		switch {
+		case key == chartutil.GlobalKey || (key == "tags" && len(dependencies) > 0):
+		case subcharts[key] != nil:
+			subchartValues, _ := value.(map[string]interface{})
+			unused = append(unused, unusedChartValues(subcharts[key], subchartValues, name+"/"+subcharts[key].Name(), childPath(path, key), allowed)...)
+		case dependencies[key]:
+		default:
+			chartValues[key] = value
+		}
+	}
+
+	return append(unused, unusedValues(chartValues, map[string]interface{}(chart.Values), schema, name, path, allowed)...)
+}
+
+// unusedValues returns the keys of value which are neither part of the defaults nor of the schema, and the unknown
+// keys below the known ones.
+func unusedValues(value, defaults interface{}, schema map[string]interface{}, chart string, path []string, allowed []string) []UnusedValue {
+	if allowedPath(allowed, path) {
+		return nil
+	}
+
+	valueMap, ok := value.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	defaultsMap, _ := defaults.(map[string]interface{})
+	if len(defaultsMap) == 0 && !closedSchema(schema) {
+		return nil
+	}
+
+	var unused []UnusedValue
+	for key, v := range valueMap {
+		keyPath := childPath(path, key)
+		keyDefaults, inDefaults := defaultsMap[key]
+		keySchema := schemaProperty(schema, key)
+		if !inDefaults && keySchema == nil {
+			if !openSchema(schema) && !allowedPath(allowed, keyPath) {
+				unused = append(unused, UnusedValue{Chart: chart, Path: "$." + strings.Join(keyPath, ".")})
+			}
+			continue
+		}
+
+		unused = append(unused, unusedValues(v, keyDefaults, keySchema, chart, keyPath, allowed)...)
+	}
+
+	return unused
+}
+
+// schemaProperty returns the schema of the property key of an object schema, or nil if it declares none.
+func schemaProperty(schema map[string]interface{}, key string) map[string]interface{} {
+	properties, _ := schema["properties"].(map[string]interface{})
+	property, _ := properties[key].(map[string]interface{})
+	return property
+}
+
+// closedSchema reports whether the object schema declares its properties without allowing others.
+func closedSchema(schema map[string]interface{}) bool {
+	_, ok := schema["properties"]
+	return ok && !openSchema(schema)
+}
+
+// openSchema reports whether the object schema allows properties it does not declare.
+func openSchema(schema map[string]interface{}) bool {
+	if _, ok := schema["patternProperties"]; ok {
+		return true
+	}
+
+	additional, ok := schema["additionalProperties"]
+	return ok && additional != false
+}
+
+// allowedPath reports whether the path or one of its parents matches an allowed path.
+func allowedPath(allowed []string, path []string) bool {
+	for _, pattern := range allowed {
+		segments := strings.Split(strings.TrimPrefix(pattern, "$."), ".")
+		if len(segments) > len(path) {
+			continue
+		}
+
+		matches := true
+		for i, segment := range segments {
+			if segment != "*" && segment != path[i] {
+				matches = false
+				break
+			}
+		}
+
+		if matches {
+			return true
+		}
+	}
+
+	return false
+}
+
+// childPath returns the path of key below path without modifying path.
+func childPath(path []string, key string) []string {
+	return append(append(make([]string, 0, len(path)+1), path...), key)
+}
+
+// checkUnusedValues logs the keys of the values of the HelmRelease which its chart never consumes unless disabled by
+// the UnusedValuesAnnotation. chart must not have been processed for rendering yet, which removes disabled subcharts.
+func (h *Helm) checkUnusedValues(ctx context.Context, hr helmv2.HelmRelease, values chartutil.Values, chart *helmchart.Chart) {
+	if hr.GetAnnotations()[UnusedValuesAnnotation] == "disabled" {
+		return
+	}
+
+	for _, v := range findUnusedValues(chart, values, h.opts.UnusedValuesAllow) {
+		h.logger(ctx).Info("values key is not consumed by the chart", "chart", v.Chart, "path", v.Path)
+	}
+}
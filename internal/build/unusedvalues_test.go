@@ -0,0 +1,147 @@
+package build
+
+import (
+	"context"
+	"testing"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"github.com/go-logr/logr/funcr"
+	. "github.com/onsi/gomega"
+	helmchart "helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+func TestFindUnusedValues(t *testing.T) {
+	newChart := func(values, schema string, dependencies ...*helmchart.Chart) *helmchart.Chart {
+		c := &helmchart.Chart{Metadata: &helmchart.Metadata{Name: "app", Version: "0.1.0", APIVersion: helmchart.APIVersionV2}}
+		if err := yaml.Unmarshal([]byte(values), &c.Values); err != nil {
+			t.Fatal(err)
+		}
+		if schema != "" {
+			c.Schema = []byte(schema)
+		}
+		for _, d := range dependencies {
+			c.Metadata.Dependencies = append(c.Metadata.Dependencies, &helmchart.Dependency{Name: d.Name(), Condition: d.Name() + ".enabled"})
+		}
+		c.SetDependencies(dependencies...)
+		return c
+	}
+
+	subchart := newChart("replicaCount: 1", "")
+	subchart.Metadata.Name = "sub"
+
+	tests := []struct {
+		name         string
+		chart        *helmchart.Chart
+		values       string
+		allowed      []string
+		expectUnused []UnusedValue
+	}{
+		{
+			name:   "known keys",
+			chart:  newChart("replicaCount: 1\nimage:\n  tag: latest", ""),
+			values: "replicaCount: 2\nimage:\n  tag: v1",
+		},
+		{
+			name:   "misspelled keys",
+			chart:  newChart("replicaCount: 1\nimage:\n  tag: latest", ""),
+			values: "replicacount: 2\nimage:\n  tga: v1",
+			expectUnused: []UnusedValue{
+				{Chart: "app", Path: "$.image.tga"},
+				{Chart: "app", Path: "$.replicacount"},
+			},
+		},
+		{
+			name:   "free-form maps",
+			chart:  newChart("podAnnotations: {}\nextraEnv:\nresources: {}", ""),
+			values: "podAnnotations:\n  a: b\nextraEnv:\n  FOO: bar\nresources:\n  limits:\n    cpu: 1",
+		},
+		{
+			name:   "keys declared by the schema",
+			chart:  newChart("replicaCount: 1", `{"properties": {"nameOverride": {"type": "string"}, "ingress": {"properties": {"enabled": {"type": "boolean"}}}}}`),
+			values: "nameOverride: app\ningress:\n  enabled: true\n  host: example.com",
+			expectUnused: []UnusedValue{
+				{Chart: "app", Path: "$.ingress.host"},
+			},
+		},
+		{
+			name:   "schema allowing additional properties",
+			chart:  newChart("replicaCount: 1", `{"properties": {"replicaCount": {"type": "integer"}}, "additionalProperties": true}`),
+			values: "anything: true",
+		},
+		{
+			name:   "subcharts",
+			chart:  newChart("replicaCount: 1", "", subchart),
+			values: "global:\n  domain: example.com\ntags:\n  all: true\nsub:\n  enabled: true\n  replicacount: 2",
+			expectUnused: []UnusedValue{
+				{Chart: "app/sub", Path: "$.sub.replicacount"},
+			},
+		},
+		{
+			name: "disabled dependencies",
+			chart: func() *helmchart.Chart {
+				c := newChart("replicaCount: 1", "")
+				c.Metadata.Dependencies = append(c.Metadata.Dependencies, &helmchart.Dependency{Name: "redis", Alias: "cache"})
+				return c
+			}(),
+			values: "redis:\n  enabled: false\ncache:\n  enabled: false",
+		},
+		{
+			name:    "allowed paths",
+			chart:   newChart("replicaCount: 1\nserver:\n  port: 80\nworker:\n  port: 81", ""),
+			values:  "extraConfig:\n  a: b\nserver:\n  labels:\n    a: b\nworker:\n  labels:\n    a: b\n  lables:\n    a: b",
+			allowed: []string{"extraConfig", "*.labels"},
+			expectUnused: []UnusedValue{
+				{Chart: "app", Path: "$.worker.lables"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			values := chartutil.Values{}
+			g.Expect(yaml.Unmarshal([]byte(tt.values), &values)).To(Succeed())
+			g.Expect(findUnusedValues(tt.chart, values, tt.allowed)).To(Equal(tt.expectUnused))
+		})
+	}
+}
+
+func TestCheckUnusedValues(t *testing.T) {
+	c := &helmchart.Chart{
+		Metadata: &helmchart.Metadata{Name: "app", Version: "0.1.0", APIVersion: helmchart.APIVersionV2},
+		Values:   map[string]interface{}{"replicaCount": 1},
+	}
+	values := chartutil.Values{"replicacount": 2}
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		expectLogs  []string
+	}{
+		{
+			name:       "reported",
+			expectLogs: []string{`"level"=0 "msg"="values key is not consumed by the chart" "chart"="app" "path"="$.replicacount"`},
+		},
+		{
+			name:        "disabled by annotation",
+			annotations: map[string]string{UnusedValuesAnnotation: "disabled"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			var logs []string
+			logger := funcr.New(func(prefix, args string) {
+				logs = append(logs, args)
+			}, funcr.Options{})
+
+			hr := helmv2.HelmRelease{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", Annotations: tt.annotations}}
+			NewHelmBuilder(logger, HelmOpts{}).checkUnusedValues(context.Background(), hr, values, c)
+			g.Expect(logs).To(Equal(tt.expectLogs))
+		})
+	}
+}
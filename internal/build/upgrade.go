@@ -0,0 +1,91 @@
+package build
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	helmv2beta2 "github.com/fluxcd/helm-controller/api/v2beta2"
+	helmaction "helm.sh/helm/v3/pkg/action"
+	helmchart "helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/release"
+)
+
+// ReleaseAction is the Helm action HelmReleases are rendered with.
+type ReleaseAction string
+
+const (
+	// ReleaseActionInstall renders releases as a dry-run install.
+	ReleaseActionInstall ReleaseAction = "install"
+	// ReleaseActionUpgrade renders releases as a dry-run upgrade of an installed revision, which sets .Release.IsUpgrade
+	// and the revision to 2.
+	ReleaseActionUpgrade ReleaseAction = "upgrade"
+)
+
+// ParseReleaseAction parses install or upgrade, an empty action is install.
+func ParseReleaseAction(action string) (ReleaseAction, error) {
+	switch ReleaseAction(action) {
+	case "", ReleaseActionInstall:
+		return ReleaseActionInstall, nil
+	case ReleaseActionUpgrade:
+		return ReleaseActionUpgrade, nil
+	}
+
+	return "", fmt.Errorf("unknown helm action `%s`, supported actions are %s, %s", action, ReleaseActionInstall, ReleaseActionUpgrade)
+}
+
+// renderUpgrade renders a dry-run upgrade of the installed release using the upgrade settings of the HelmRelease.
+// cfg must be the client-only configuration the installed release was rendered with, the release is stored in its
+// in-memory storage as the deployed revision.
+func (h *Helm) renderUpgrade(ctx context.Context, cfg *helmaction.Configuration, installed *release.Release, hr helmv2.HelmRelease, legacyPostRenderers []helmv2beta2.PostRenderer, values chartutil.Values, chart *helmchart.Chart) (*release.Release, error) {
+	crdsPolicy, err := h.validateCRDsPolicy(hr.GetUpgrade().CRDs, helmv2.Skip)
+	if err != nil {
+		return nil, err
+	}
+
+	installed.Info.Status = release.StatusDeployed
+	if err := cfg.Releases.Create(installed); err != nil {
+		return nil, fmt.Errorf("failed to store installed release: %w", err)
+	}
+
+	client := helmaction.NewUpgrade(cfg)
+	client.Namespace = installed.Namespace
+	client.DryRun = true
+	client.Timeout = hr.GetUpgrade().GetTimeout(hr.GetTimeout()).Duration
+	client.DisableHooks = hr.GetUpgrade().DisableHooks
+	client.DisableOpenAPIValidation = hr.GetUpgrade().DisableOpenAPIValidation
+	// The values are validated against the schema of the chart by checkValuesSchema
+	client.SkipSchemaValidation = true
+	client.Devel = true
+	client.EnableDNS = true
+	postRenderer, err := h.postRenderer(ctx, hr, legacyPostRenderers)
+	if err != nil {
+		return nil, err
+	}
+	client.PostRenderer = postRenderer
+
+	rel, err := client.RunWithContext(ctx, releaseName(hr), chart, values)
+	if err != nil {
+		return nil, err
+	}
+
+	// Helm never renders CRDs on upgrade, helm-controller applies them unless the policy is Skip
+	if crdsPolicy == helmv2.Skip || len(chart.CRDObjects()) == 0 {
+		return rel, nil
+	}
+
+	var crds bytes.Buffer
+	for _, crd := range chart.CRDObjects() {
+		fmt.Fprintf(&crds, "---\n# Source: %s\n%s\n", crd.Filename, string(crd.File.Data))
+	}
+
+	rendered, err := client.PostRenderer.Run(&crds)
+	if err != nil {
+		return nil, fmt.Errorf("error while running post render on CRDs: %w", err)
+	}
+
+	rel.Manifest = rendered.String() + "\n---\n" + rel.Manifest
+	return rel, nil
+}
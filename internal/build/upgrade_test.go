@@ -0,0 +1,148 @@
+package build
+
+import (
+	"context"
+	"testing"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"github.com/go-logr/logr"
+	. "github.com/onsi/gomega"
+	helmchart "helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/kustomize/api/provider"
+	"sigs.k8s.io/kustomize/api/resmap"
+)
+
+func TestParseReleaseAction(t *testing.T) {
+	g := NewWithT(t)
+
+	action, err := ParseReleaseAction("")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(action).To(Equal(ReleaseActionInstall))
+
+	action, err = ParseReleaseAction("upgrade")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(action).To(Equal(ReleaseActionUpgrade))
+
+	_, err = ParseReleaseAction("rollback")
+	g.Expect(err).To(MatchError("unknown helm action `rollback`, supported actions are install, upgrade"))
+}
+
+func TestRenderReleaseAction(t *testing.T) {
+	upgradeChart := &helmchart.Chart{
+		Metadata: &helmchart.Metadata{
+			APIVersion: helmchart.APIVersionV2,
+			Name:       "upgrade",
+			Version:    "1.0.0",
+		},
+		Templates: []*helmchart.File{
+			{
+				Name: "templates/configmap.yaml",
+				Data: []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: release
+data:
+  upgrade: {{ .Release.IsUpgrade | quote }}
+  revision: {{ .Release.Revision | quote }}
+`),
+			},
+			{
+				Name: "templates/hook.yaml",
+				Data: []byte(`apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrate
+  annotations:
+    helm.sh/hook: pre-install,pre-upgrade
+`),
+			},
+		},
+		Files: []*helmchart.File{
+			{
+				Name: "crds/crd.yaml",
+				Data: []byte(`apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: examples.example.com
+`),
+			},
+		},
+	}
+
+	tests := []struct {
+		name         string
+		action       ReleaseAction
+		upgrade      *helmv2.Upgrade
+		expectData   map[string]string
+		expectCRDs   bool
+		expectEvents []string
+	}{
+		{
+			name:         "install",
+			expectData:   map[string]string{"upgrade": "false", "revision": "1"},
+			expectCRDs:   true,
+			expectEvents: []string{"pre-install", "pre-upgrade"},
+		},
+		{
+			name:         "upgrade skips crds by default",
+			action:       ReleaseActionUpgrade,
+			expectData:   map[string]string{"upgrade": "true", "revision": "2"},
+			expectEvents: []string{"pre-install", "pre-upgrade"},
+		},
+		{
+			name:         "upgrade with crds policy",
+			action:       ReleaseActionUpgrade,
+			upgrade:      &helmv2.Upgrade{CRDs: helmv2.CreateReplace, DisableHooks: true},
+			expectData:   map[string]string{"upgrade": "true", "revision": "2"},
+			expectCRDs:   true,
+			expectEvents: []string{"pre-install", "pre-upgrade"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			h := NewHelmBuilder(logr.Discard(), HelmOpts{
+				Action: tt.action,
+			})
+
+			hr := helmv2.HelmRelease{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "upgrade",
+					Namespace: "default",
+				},
+				Spec: helmv2.HelmReleaseSpec{
+					Upgrade: tt.upgrade,
+				},
+			}
+
+			rel, err := h.renderRelease(context.Background(), hr, nil, chartutil.Values{}, upgradeChart)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			m, err := resmap.NewFactory(provider.NewDefaultDepProvider().GetResourceFactory()).NewResMapFromBytes([]byte(rel.Manifest))
+			g.Expect(err).ToNot(HaveOccurred())
+
+			var data map[string]string
+			var crds bool
+			for _, r := range m.Resources() {
+				switch r.GetKind() {
+				case "ConfigMap":
+					data = r.GetDataMap()
+				case "CustomResourceDefinition":
+					crds = true
+				}
+			}
+			g.Expect(data).To(Equal(tt.expectData))
+			g.Expect(crds).To(Equal(tt.expectCRDs))
+
+			g.Expect(rel.Hooks).To(HaveLen(1))
+			var events []string
+			for _, event := range rel.Hooks[0].Events {
+				events = append(events, event.String())
+			}
+			g.Expect(events).To(Equal(tt.expectEvents))
+		})
+	}
+}
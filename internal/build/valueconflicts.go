@@ -0,0 +1,192 @@
+package build
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ValueConflict is a key of the values of a HelmRelease whose value was overwritten by a later source while the values
+// were merged. Values contributed by Secrets are redacted.
+type ValueConflict struct {
+	// Path is the JSON path of the key, for instance $.image.tag.
+	Path string `json:"path"`
+	// Sources are the sources of the overwritten value, more than one if it is a map merged from several sources.
+	Sources []string `json:"sources"`
+	// OverwrittenBy is the source of the value which won.
+	OverwrittenBy string `json:"overwrittenBy"`
+	// Before is the overwritten value.
+	Before interface{} `json:"before"`
+	// After is the value which won, it may be overwritten by a later source again.
+	After interface{} `json:"after"`
+}
+
+// mergedValue is a value which is no map within the values merged by conflicts alongside its source.
+type mergedValue struct {
+	value  interface{}
+	source *valuesSource
+}
+
+// conflicts replays the merge of the recorded sources and returns every key whose value a later source overwrites with
+// a different value, in the order they occur. It only observes the merge, the values of the HelmRelease are merged
+// by composeValues.
+func (t *valuesTrace) conflicts() []ValueConflict {
+	if t == nil {
+		return nil
+	}
+
+	var conflicts []ValueConflict
+	merged := make(map[string]interface{})
+	for i := range t.sources {
+		conflicts = mergeConflicts(merged, t.sources[i].values, &t.sources[i], nil, conflicts)
+	}
+
+	return conflicts
+}
+
+// mergeConflicts merges values into merged like transform.MergeMaps and appends the keys it overwrites to conflicts.
+func mergeConflicts(merged, values map[string]interface{}, source *valuesSource, path []string, conflicts []ValueConflict) []ValueConflict {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := values[key]
+		existing, ok := merged[key]
+		existingMap, existingIsMap := existing.(map[string]interface{})
+		valueMap, valueIsMap := value.(map[string]interface{})
+
+		switch {
+		case ok && existingIsMap && valueIsMap:
+			conflicts = mergeConflicts(existingMap, valueMap, source, childPath(path, key), conflicts)
+			continue
+		case ok && !reflect.DeepEqual(unmergedValue(existing), value):
+			conflicts = append(conflicts, ValueConflict{
+				Path:          "$." + strings.Join(childPath(path, key), "."),
+				Sources:       mergedSources(existing),
+				OverwrittenBy: source.name,
+				Before:        displayedValue(existing),
+				After:         displayedValue(newMergedValue(value, source)),
+			})
+		}
+
+		merged[key] = newMergedValue(value, source)
+	}
+
+	return conflicts
+}
+
+// newMergedValue returns a copy of value whose maps are merged by mergeConflicts and whose other values are
+// attributed to source.
+func newMergedValue(value interface{}, source *valuesSource) interface{} {
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return mergedValue{value: value, source: source}
+	}
+
+	merged := make(map[string]interface{}, len(m))
+	for key, v := range m {
+		merged[key] = newMergedValue(v, source)
+	}
+
+	return merged
+}
+
+// unmergedValue strips the sources from a value merged by mergeConflicts.
+func unmergedValue(value interface{}) interface{} {
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return value.(mergedValue).value
+	}
+
+	unmerged := make(map[string]interface{}, len(m))
+	for key, v := range m {
+		unmerged[key] = unmergedValue(v)
+	}
+
+	return unmerged
+}
+
+// displayedValue strips the sources from a value merged by mergeConflicts and redacts the values of Secrets.
+func displayedValue(value interface{}) interface{} {
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		if v := value.(mergedValue); !v.source.sensitive {
+			return v.value
+		}
+
+		return redactedValue
+	}
+
+	displayed := make(map[string]interface{}, len(m))
+	for key, v := range m {
+		displayed[key] = displayedValue(v)
+	}
+
+	return displayed
+}
+
+// mergedSources returns the names of the distinct sources of a value merged by mergeConflicts in the order they were
+// merged.
+func mergedSources(value interface{}) []string {
+	sources := collectSources(value, nil)
+	sort.SliceStable(sources, func(i, j int) bool {
+		return sources[i].order < sources[j].order
+	})
+
+	names := make([]string, 0, len(sources))
+	for _, source := range sources {
+		names = append(names, source.name)
+	}
+
+	return names
+}
+
+func collectSources(value interface{}, sources []*valuesSource) []*valuesSource {
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		source := value.(mergedValue).source
+		for _, s := range sources {
+			if s == source {
+				return sources
+			}
+		}
+
+		return append(sources, source)
+	}
+
+	for _, v := range m {
+		sources = collectSources(v, sources)
+	}
+
+	return sources
+}
+
+type valueConflictsKey struct{}
+
+// WithValueConflicts returns a context which records the value conflicts of the HelmRelease built with it into c,
+// they are only recorded if HelmOpts.ReportValueConflicts is set.
+func WithValueConflicts(ctx context.Context, c *[]ValueConflict) context.Context {
+	return context.WithValue(ctx, valueConflictsKey{}, c)
+}
+
+func valueConflictsFrom(ctx context.Context) *[]ValueConflict {
+	c, _ := ctx.Value(valueConflictsKey{}).(*[]ValueConflict)
+	return c
+}
+
+// recordValueConflicts logs the value conflicts of the trace and records them if the context carries value conflicts,
+// see WithValueConflicts.
+func (h *Helm) recordValueConflicts(ctx context.Context, trace *valuesTrace) {
+	conflicts := trace.conflicts()
+	for _, c := range conflicts {
+		h.logger(ctx).V(1).Info("values key is overwritten by a later source", "path", c.Path, "sources", c.Sources, "overwrittenBy", c.OverwrittenBy)
+	}
+
+	if c := valueConflictsFrom(ctx); c != nil {
+		*c = conflicts
+	}
+}
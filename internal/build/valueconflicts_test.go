@@ -0,0 +1,154 @@
+package build
+
+import (
+	"context"
+	"testing"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"github.com/go-logr/logr"
+	. "github.com/onsi/gomega"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestValueConflicts(t *testing.T) {
+	db := `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: podinfo-values
+  namespace: apps
+data:
+  values.yaml: |
+    replicas: 2
+    image:
+      repository: ghcr.io/stefanprodan/podinfo
+      tag: 6.0.0
+    resources:
+      limits:
+        cpu: 100m
+  replicas: "3"
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: podinfo-credentials
+  namespace: apps
+stringData:
+  values.yaml: |
+    database:
+      password: s3cr3t
+`
+
+	tests := []struct {
+		name            string
+		valuesFrom      []helmv2.ValuesReference
+		values          string
+		overrides       []string
+		expectConflicts []ValueConflict
+	}{
+		{
+			name:       "no conflicts",
+			valuesFrom: []helmv2.ValuesReference{{Kind: "ConfigMap", Name: "podinfo-values"}},
+			values:     `{"image":{"pullPolicy":"Always"},"replicas":2}`,
+		},
+		{
+			name: "valuesFrom and spec.values",
+			valuesFrom: []helmv2.ValuesReference{
+				{Kind: "ConfigMap", Name: "podinfo-values"},
+				{Kind: "ConfigMap", Name: "podinfo-values", ValuesKey: "replicas", TargetPath: "replicas"},
+			},
+			values: `{"image":{"tag":"6.1.0"}}`,
+			expectConflicts: []ValueConflict{
+				{
+					Path:          "$.replicas",
+					Sources:       []string{"ConfigMap/apps/podinfo-values[values.yaml]"},
+					OverwrittenBy: "ConfigMap/apps/podinfo-values[replicas] -> replicas",
+					Before:        float64(2),
+					After:         "3",
+				},
+				{
+					Path:          "$.image.tag",
+					Sources:       []string{"ConfigMap/apps/podinfo-values[values.yaml]"},
+					OverwrittenBy: "spec.values",
+					Before:        "6.0.0",
+					After:         "6.1.0",
+				},
+			},
+		},
+		{
+			name:       "maps replaced by other values",
+			valuesFrom: []helmv2.ValuesReference{{Kind: "ConfigMap", Name: "podinfo-values"}},
+			values:     `{"resources":{"requests":{"cpu":"50m"}}}`,
+			overrides:  []string{"apps/podinfo:resources=null"},
+			expectConflicts: []ValueConflict{
+				{
+					Path:          "$.resources",
+					Sources:       []string{"ConfigMap/apps/podinfo-values[values.yaml]", "spec.values"},
+					OverwrittenBy: "--set apps/podinfo:resources=null",
+					Before:        map[string]interface{}{"limits": map[string]interface{}{"cpu": "100m"}, "requests": map[string]interface{}{"cpu": "50m"}},
+					After:         nil,
+				},
+			},
+		},
+		{
+			name:       "values of secrets are redacted",
+			valuesFrom: []helmv2.ValuesReference{{Kind: "Secret", Name: "podinfo-credentials"}},
+			values:     `{"database":{"password":"changeme"}}`,
+			overrides:  []string{"apps/podinfo:database.password=override"},
+			expectConflicts: []ValueConflict{
+				{
+					Path:          "$.database.password",
+					Sources:       []string{"Secret/apps/podinfo-credentials[values.yaml]"},
+					OverwrittenBy: "spec.values",
+					Before:        redactedValue,
+					After:         "changeme",
+				},
+				{
+					Path:          "$.database.password",
+					Sources:       []string{"spec.values"},
+					OverwrittenBy: "--set apps/podinfo:database.password=override",
+					Before:        "changeme",
+					After:         "override",
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			var overrides []ValuesOverride
+			for _, o := range tt.overrides {
+				override, err := ParseValuesOverride(o, false)
+				g.Expect(err).ToNot(HaveOccurred())
+				overrides = append(overrides, override)
+			}
+
+			hr := helmv2.HelmRelease{
+				ObjectMeta: metav1.ObjectMeta{Name: "podinfo", Namespace: "apps"},
+				Spec: helmv2.HelmReleaseSpec{
+					ValuesFrom: tt.valuesFrom,
+					Values:     &apiextensionsv1.JSON{Raw: []byte(tt.values)},
+				},
+			}
+
+			h := NewHelmBuilder(logr.Discard(), HelmOpts{
+				ReportValueConflicts: true,
+				ValuesOverrides:      overrides,
+			})
+
+			trace := &valuesTrace{}
+			values, err := h.composeValues(withValuesTrace(context.Background(), trace), newResourceIndex(t, db), hr)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			var conflicts []ValueConflict
+			h.recordValueConflicts(WithValueConflicts(context.Background(), &conflicts), trace)
+			g.Expect(conflicts).To(Equal(tt.expectConflicts))
+
+			// Reporting never changes the values the release is rendered with
+			plain, err := NewHelmBuilder(logr.Discard(), HelmOpts{ValuesOverrides: overrides}).composeValues(context.Background(), newResourceIndex(t, db), hr)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(values).To(Equal(plain))
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package build
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// AllValuesKeys is the valuesKey of a ValuesReference which merges the values of all keys of the referenced object,
+// sorted by key name.
+const AllValuesKeys = "*"
+
+// gzipMagic are the first bytes of gzip compressed data.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// InvalidValuesError is returned if the value of a valuesFrom reference is no valid YAML values document.
+type InvalidValuesError struct {
+	// Kind of the referenced object, ConfigMap or Secret.
+	Kind string
+	// Name is the namespace and name of the referenced object.
+	Name string
+	// Key is the key of the value in the referenced object.
+	Key string
+	// Size is the size of the value in bytes, after decompression.
+	Size int
+	// FirstLine is the start of the value, it is empty for Secrets.
+	FirstLine string
+	// Err is the error of reading the values.
+	Err error
+}
+
+func (e *InvalidValuesError) Error() string {
+	if e.Kind == "Secret" {
+		return fmt.Sprintf("value for key '%s' in %s '%s' is not valid YAML (%d bytes, content redacted): %s", e.Key, e.Kind, e.Name, e.Size, e.Err)
+	}
+
+	return fmt.Sprintf("value for key '%s' in %s '%s' is not valid YAML (%d bytes starting with '%s'): %s", e.Key, e.Kind, e.Name, e.Size, e.FirstLine, e.Err)
+}
+
+func (e *InvalidValuesError) Unwrap() error {
+	return e.Err
+}
+
+// newInvalidValuesError returns an InvalidValuesError for the value, the content of Secret values is left out.
+func newInvalidValuesError(kind, name, key string, value []byte, err error) *InvalidValuesError {
+	e := &InvalidValuesError{Kind: kind, Name: name, Key: key, Size: len(value), Err: err}
+	if kind != "Secret" {
+		e.FirstLine = firstLine(value)
+		if len(e.FirstLine) > 80 {
+			e.FirstLine = e.FirstLine[:80] + "..."
+		}
+	}
+
+	return e
+}
+
+// valuesDocument is the value of a key of a ConfigMap or Secret referenced by valuesFrom.
+type valuesDocument struct {
+	key  string
+	data []byte
+	// binary is set for values which may be gzip compressed, binaryData of ConfigMaps and data of Secrets.
+	binary bool
+}
+
+// valuesDocuments returns the value of key of a ConfigMap or Secret or, for AllValuesKeys, the values of all its keys
+// sorted by key name. Both kinds look up a key alike, data takes precedence over binaryData of ConfigMaps and over
+// stringData of Secrets (Secrets of generators may only hold stringData). It reports false for other objects.
+func valuesDocuments(obj any, key string) ([]valuesDocument, bool) {
+	var keys []string
+	var lookup func(key string) (valuesDocument, bool)
+	switch obj := obj.(type) {
+	case *corev1.ConfigMap:
+		keys = mapKeys(obj.Data, obj.BinaryData)
+		lookup = func(key string) (valuesDocument, bool) {
+			if data, ok := obj.Data[key]; ok {
+				return valuesDocument{key: key, data: []byte(data)}, true
+			}
+			data, ok := obj.BinaryData[key]
+			return valuesDocument{key: key, data: data, binary: true}, ok
+		}
+	case *corev1.Secret:
+		keys = mapKeys(obj.Data, obj.StringData)
+		lookup = func(key string) (valuesDocument, bool) {
+			// The data of Secrets is decoded from base64 already
+			if data, ok := obj.Data[key]; ok {
+				return valuesDocument{key: key, data: data, binary: true}, true
+			}
+			data, ok := obj.StringData[key]
+			return valuesDocument{key: key, data: []byte(data)}, ok
+		}
+	default:
+		return nil, false
+	}
+
+	if key != AllValuesKeys {
+		keys = []string{key}
+	}
+
+	var docs []valuesDocument
+	for _, k := range keys {
+		if doc, ok := lookup(k); ok {
+			docs = append(docs, doc)
+		}
+	}
+
+	return docs, true
+}
+
+// mapKeys returns the keys of both maps sorted and without duplicates.
+func mapKeys[A, B any](a map[string]A, b map[string]B) []string {
+	keys := make([]string, 0, len(a)+len(b))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// decompressValues returns the value decompressed if it is gzip compressed, else as is. At most maxSize bytes plus
+// one are decompressed so the document limits reject the result instead of exhausting memory, maxSize 0 is unlimited.
+func decompressValues(value []byte, maxSize int) ([]byte, error) {
+	if !bytes.HasPrefix(value, gzipMagic) {
+		return value, nil
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(value))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress gzip value: %w", err)
+	}
+	defer zr.Close()
+
+	var r io.Reader = zr
+	if maxSize > 0 {
+		r = io.LimitReader(zr, int64(maxSize)+1)
+	}
+
+	decompressed, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress gzip value: %w", err)
+	}
+
+	return decompressed, nil
+}
@@ -0,0 +1,325 @@
+package build
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/doodlescheduling/flux-build/internal/cachemgr"
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"github.com/go-logr/logr"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+func TestComposeValuesFromSecret(t *testing.T) {
+	tests := []struct {
+		name         string
+		data         map[string][]byte
+		stringData   map[string]string
+		targetPath   string
+		limits       *DocumentLimits
+		expectValues map[string]interface{}
+		expectErr    string
+	}{
+		{
+			name:         "data",
+			data:         map[string][]byte{"values.yaml": []byte("password: data")},
+			expectValues: map[string]interface{}{"password": "data"},
+		},
+		{
+			name:         "stringData",
+			stringData:   map[string]string{"values.yaml": "password: stringData"},
+			expectValues: map[string]interface{}{"password": "stringData"},
+		},
+		{
+			name:         "data takes precedence over stringData",
+			data:         map[string][]byte{"values.yaml": []byte("password: data")},
+			stringData:   map[string]string{"values.yaml": "password: stringData"},
+			expectValues: map[string]interface{}{"password": "data"},
+		},
+		{
+			name:         "stringData of another key",
+			data:         map[string][]byte{"other.yaml": []byte("password: data")},
+			stringData:   map[string]string{"values.yaml": "password: stringData"},
+			expectValues: map[string]interface{}{"password": "stringData"},
+		},
+		{
+			name:         "gzip compressed data",
+			data:         map[string][]byte{"values.yaml": gzipValues(t, "password: compressed")},
+			expectValues: map[string]interface{}{"password": "compressed"},
+		},
+		{
+			name:      "gzip compressed data exceeding the document limits",
+			data:      map[string][]byte{"values.yaml": gzipValues(t, "password: "+string(bytes.Repeat([]byte("a"), 1024)))},
+			limits:    &DocumentLimits{MaxSize: 512},
+			expectErr: "document size of 513 bytes exceeds the maximum of 512 bytes",
+		},
+		{
+			name:      "truncated gzip data",
+			data:      map[string][]byte{"values.yaml": gzipValues(t, "password: compressed")[:12]},
+			expectErr: "invalid values from key 'values.yaml' in Secret 'default/values': failed to decompress gzip value",
+		},
+		{
+			name:         "PEM certificate at target path",
+			data:         map[string][]byte{"values.yaml": []byte(testCertificatePEM)},
+			targetPath:   "tls.ca",
+			expectValues: map[string]interface{}{"tls": map[string]interface{}{"ca": testCertificatePEM}},
+		},
+		{
+			name:         "gzip compressed PEM certificate at target path",
+			data:         map[string][]byte{"values.yaml": gzipValues(t, testCertificatePEM)},
+			targetPath:   "tls.ca",
+			expectValues: map[string]interface{}{"tls": map[string]interface{}{"ca": testCertificatePEM}},
+		},
+		{
+			name:         "JSON stringData at target path",
+			stringData:   map[string]string{"values.yaml": `{"user": "admin", "roles": ["read", "write"]}`},
+			targetPath:   "auth",
+			expectValues: map[string]interface{}{"auth": `{"user": "admin", "roles": ["read", "write"]}`},
+		},
+		{
+			name:      "invalid data",
+			data:      map[string][]byte{"values.yaml": []byte("password: hunter2: x")},
+			expectErr: "value for key 'values.yaml' in Secret 'default/values' is not valid YAML (20 bytes, content redacted)",
+		},
+		{
+			name:      "invalid gzip compressed data",
+			data:      map[string][]byte{"values.yaml": gzipValues(t, "hunter2")},
+			expectErr: "value for key 'values.yaml' in Secret 'default/values' is not valid YAML (7 bytes, content redacted)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			secret, err := yaml.Marshal(corev1.Secret{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+				ObjectMeta: metav1.ObjectMeta{Name: "values", Namespace: "default"},
+				Data:       tt.data,
+				StringData: tt.stringData,
+			})
+			g.Expect(err).ToNot(HaveOccurred())
+
+			cache, err := cachemgr.New("none", "")
+			g.Expect(err).ToNot(HaveOccurred())
+			h := NewHelmBuilder(logr.Discard(), HelmOpts{
+				Cache:          cache,
+				DocumentLimits: tt.limits,
+			})
+
+			hr := helmv2.HelmRelease{
+				ObjectMeta: metav1.ObjectMeta{Name: "release", Namespace: "default"},
+				Spec: helmv2.HelmReleaseSpec{
+					ValuesFrom: []helmv2.ValuesReference{
+						{Kind: "Secret", Name: "values", TargetPath: tt.targetPath},
+					},
+				},
+			}
+
+			values, err := h.composeValues(context.Background(), newResourceIndex(t, string(secret)), hr)
+			if tt.expectErr != "" {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tt.expectErr))
+				g.Expect(err.Error()).ToNot(ContainSubstring("hunter2"))
+				return
+			}
+
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(map[string]interface{}(values)).To(Equal(tt.expectValues))
+		})
+	}
+}
+
+func TestComposeValuesFromKeys(t *testing.T) {
+	tests := []struct {
+		name         string
+		valuesFrom   []helmv2.ValuesReference
+		expectValues map[string]interface{}
+		expectErr    string
+	}{
+		{
+			name: "keys in reference order",
+			valuesFrom: []helmv2.ValuesReference{
+				{Kind: "ConfigMap", Name: "values", ValuesKey: "base.yaml"},
+				{Kind: "ConfigMap", Name: "values", ValuesKey: "region.yaml"},
+			},
+			expectValues: map[string]interface{}{
+				"replicas": float64(1),
+				"region":   "us",
+				"image":    map[string]interface{}{"repository": "podinfo", "tag": "v2"},
+			},
+		},
+		{
+			name: "keys in reversed reference order",
+			valuesFrom: []helmv2.ValuesReference{
+				{Kind: "ConfigMap", Name: "values", ValuesKey: "region.yaml"},
+				{Kind: "ConfigMap", Name: "values", ValuesKey: "base.yaml"},
+			},
+			expectValues: map[string]interface{}{
+				"replicas": float64(1),
+				"region":   "eu",
+				"image":    map[string]interface{}{"repository": "podinfo", "tag": "v1"},
+			},
+		},
+		{
+			name: "all keys sorted by key name",
+			valuesFrom: []helmv2.ValuesReference{
+				{Kind: "ConfigMap", Name: "values", ValuesKey: AllValuesKeys},
+			},
+			expectValues: map[string]interface{}{
+				"replicas": float64(1),
+				"region":   "us-east",
+				"zone":     "a",
+				"image":    map[string]interface{}{"repository": "podinfo", "tag": "v2"},
+			},
+		},
+		{
+			name: "reference order takes precedence over all keys",
+			valuesFrom: []helmv2.ValuesReference{
+				{Kind: "ConfigMap", Name: "values", ValuesKey: AllValuesKeys},
+				{Kind: "ConfigMap", Name: "values", ValuesKey: "base.yaml"},
+			},
+			expectValues: map[string]interface{}{
+				"replicas": float64(1),
+				"region":   "eu",
+				"zone":     "a",
+				"image":    map[string]interface{}{"repository": "podinfo", "tag": "v1"},
+			},
+		},
+		{
+			name: "gzip compressed binaryData",
+			valuesFrom: []helmv2.ValuesReference{
+				{Kind: "ConfigMap", Name: "values", ValuesKey: "zone.yaml"},
+			},
+			expectValues: map[string]interface{}{"region": "us-east", "zone": "a"},
+		},
+		{
+			name: "default key of ConfigMaps and Secrets",
+			valuesFrom: []helmv2.ValuesReference{
+				{Kind: "ConfigMap", Name: "defaults"},
+				{Kind: "Secret", Name: "values"},
+			},
+			expectValues: map[string]interface{}{"replicas": float64(2), "password": "data"},
+		},
+		{
+			name: "all keys of a Secret",
+			valuesFrom: []helmv2.ValuesReference{
+				{Kind: "Secret", Name: "values", ValuesKey: AllValuesKeys},
+			},
+			expectValues: map[string]interface{}{"password": "data", "user": "admin"},
+		},
+		{
+			name: "all keys of an empty ConfigMap",
+			valuesFrom: []helmv2.ValuesReference{
+				{Kind: "ConfigMap", Name: "empty", ValuesKey: AllValuesKeys},
+			},
+			expectErr: "missing key '*' in ConfigMap 'default/empty'",
+		},
+		{
+			name: "all keys of an optional empty ConfigMap",
+			valuesFrom: []helmv2.ValuesReference{
+				{Kind: "ConfigMap", Name: "empty", ValuesKey: AllValuesKeys, Optional: true},
+			},
+			expectValues: map[string]interface{}{},
+		},
+		{
+			name: "all keys at target path",
+			valuesFrom: []helmv2.ValuesReference{
+				{Kind: "ConfigMap", Name: "values", ValuesKey: AllValuesKeys, TargetPath: "config"},
+			},
+			expectErr: "valuesKey '*' of ConfigMap 'default/values' can't be merged into target path 'config'",
+		},
+	}
+
+	resources := []interface{}{
+		corev1.ConfigMap{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+			ObjectMeta: metav1.ObjectMeta{Name: "values", Namespace: "default"},
+			Data: map[string]string{
+				"base.yaml":   "replicas: 1\nregion: eu\nimage:\n  repository: podinfo\n  tag: v1\n",
+				"region.yaml": "region: us\nimage:\n  tag: v2\n",
+			},
+			BinaryData: map[string][]byte{
+				"zone.yaml": gzipValues(t, "zone: a\nregion: us-east\n"),
+			},
+		},
+		corev1.ConfigMap{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+			ObjectMeta: metav1.ObjectMeta{Name: "defaults", Namespace: "default"},
+			Data:       map[string]string{"values.yaml": "replicas: 2\n"},
+		},
+		corev1.ConfigMap{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+			ObjectMeta: metav1.ObjectMeta{Name: "empty", Namespace: "default"},
+		},
+		corev1.Secret{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+			ObjectMeta: metav1.ObjectMeta{Name: "values", Namespace: "default"},
+			Data:       map[string][]byte{"values.yaml": []byte("password: data")},
+			StringData: map[string]string{"user.yaml": "user: admin\npassword: stringData"},
+		},
+	}
+
+	var documents []string
+	for _, r := range resources {
+		b, err := yaml.Marshal(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		documents = append(documents, string(b))
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			cache, err := cachemgr.New("none", "")
+			g.Expect(err).ToNot(HaveOccurred())
+			h := NewHelmBuilder(logr.Discard(), HelmOpts{
+				Cache: cache,
+			})
+
+			hr := helmv2.HelmRelease{
+				ObjectMeta: metav1.ObjectMeta{Name: "release", Namespace: "default"},
+				Spec: helmv2.HelmReleaseSpec{
+					ValuesFrom: tt.valuesFrom,
+				},
+			}
+
+			values, err := h.composeValues(context.Background(), newResourceIndex(t, strings.Join(documents, "---\n")), hr)
+			if tt.expectErr != "" {
+				g.Expect(err).To(MatchError(ContainSubstring(tt.expectErr)))
+				return
+			}
+
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(map[string]interface{}(values)).To(Equal(tt.expectValues))
+		})
+	}
+}
+
+func TestInvalidValuesError(t *testing.T) {
+	g := NewWithT(t)
+
+	cause := errors.New("yaml: mapping values are not allowed in this context")
+	err := newInvalidValuesError("ConfigMap", "default/values", "values.yaml", bytes.Repeat([]byte("a"), 100), cause)
+	g.Expect(err.FirstLine).To(HaveLen(83))
+	g.Expect(errors.Is(err, cause)).To(BeTrue())
+}
+
+func gzipValues(t *testing.T, values string) []byte {
+	g := NewWithT(t)
+
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	_, err := zw.Write([]byte(values))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(zw.Close()).To(Succeed())
+	return b.Bytes()
+}
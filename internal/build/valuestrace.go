@@ -0,0 +1,143 @@
+package build
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"sigs.k8s.io/yaml"
+)
+
+// valuesSource is one of the sources merged into the values of a HelmRelease.
+type valuesSource struct {
+	// name describes the source, for instance ConfigMap/apps/podinfo-values[values.yaml] or spec.values.
+	name string
+	// values are the values contributed by the source.
+	values map[string]interface{}
+	// sensitive is set for values from Secrets.
+	sensitive bool
+	// order is the position of the source within the trace.
+	order int
+}
+
+// valuesTrace records the sources of the values of a HelmRelease in the order they are merged.
+// A nil trace discards all sources, it is passed to composeValues through the context.
+type valuesTrace struct {
+	sources []valuesSource
+}
+
+func (t *valuesTrace) record(name string, values map[string]interface{}, sensitive bool) {
+	if t == nil {
+		return
+	}
+
+	t.sources = append(t.sources, valuesSource{name: name, values: values, sensitive: sensitive, order: len(t.sources)})
+}
+
+// origins returns the names of the sources of every top-level key in the order they are merged, the last one wins.
+func (t *valuesTrace) origins() map[string][]string {
+	origins := make(map[string][]string)
+	for _, source := range t.sources {
+		for key := range source.values {
+			origins[key] = append(origins[key], source.name)
+		}
+	}
+
+	return origins
+}
+
+// redact returns a copy of values in which every value contributed by a Secret is redacted, even if a later
+// source overrides it.
+func (t *valuesTrace) redact(values map[string]interface{}) map[string]interface{} {
+	redacted := copyValues(values).(map[string]interface{})
+	for _, source := range t.sources {
+		if source.sensitive {
+			redactValues(redacted, source.values)
+		}
+	}
+
+	return redacted
+}
+
+func redactValues(values, sensitive map[string]interface{}) {
+	for key, value := range sensitive {
+		if _, ok := values[key]; !ok {
+			continue
+		}
+
+		nestedSensitive, isMap := value.(map[string]interface{})
+		nestedValues, ok := values[key].(map[string]interface{})
+		if isMap && ok {
+			redactValues(nestedValues, nestedSensitive)
+			continue
+		}
+
+		values[key] = redactedValue
+	}
+}
+
+type valuesTraceKey struct{}
+
+func withValuesTrace(ctx context.Context, t *valuesTrace) context.Context {
+	return context.WithValue(ctx, valuesTraceKey{}, t)
+}
+
+func valuesTraceFrom(ctx context.Context) *valuesTrace {
+	t, _ := ctx.Value(valuesTraceKey{}).(*valuesTrace)
+	return t
+}
+
+// marshalValues encodes the merged values as YAML. A header comment lists the sources of every top-level key,
+// values from Secrets are redacted unless showSecrets is set.
+func marshalValues(hr helmv2.HelmRelease, values chartutil.Values, trace *valuesTrace, showSecrets bool) ([]byte, error) {
+	if !showSecrets {
+		values = trace.redact(values)
+	}
+
+	b, err := yaml.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+
+	origins := trace.origins()
+	keys := make([]string, 0, len(origins))
+	for key := range origins {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# Values of helmrelease %s/%s\n", hr.GetNamespace(), hr.GetName())
+	if len(keys) > 0 {
+		buf.WriteString("# Sources of the top-level keys in the order they are merged, the last one wins:\n")
+	}
+	for _, key := range keys {
+		fmt.Fprintf(&buf, "#   %s: %s\n", key, strings.Join(origins[key], ", "))
+	}
+	buf.Write(b)
+
+	return buf.Bytes(), nil
+}
+
+// dumpValues writes the merged values of the HelmRelease to <namespace>_<name>.yaml within DumpValuesDir.
+func (h *Helm) dumpValues(ctx context.Context, hr helmv2.HelmRelease, values chartutil.Values, trace *valuesTrace) error {
+	b, err := marshalValues(hr, values, trace, h.opts.ShowSecrets)
+	if err != nil {
+		return fmt.Errorf("failed to encode values of helmrelease `%s/%s`: %w", hr.GetNamespace(), hr.GetName(), err)
+	}
+
+	if err := os.MkdirAll(h.opts.DumpValuesDir, 0o755); err != nil {
+		return err
+	}
+
+	path := filepath.Join(h.opts.DumpValuesDir, hr.GetNamespace()+"_"+hr.GetName()+".yaml")
+	h.logger(ctx).V(1).Info("write values", "path", path)
+
+	return os.WriteFile(path, b, 0o644)
+}
@@ -0,0 +1,128 @@
+package build
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"github.com/go-logr/logr"
+	. "github.com/onsi/gomega"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDumpValues(t *testing.T) {
+	db := `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: podinfo-values
+  namespace: apps
+data:
+  values.yaml: |
+    replicas: 2
+    image:
+      repository: ghcr.io/stefanprodan/podinfo
+      tag: 6.0.0
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: podinfo-credentials
+  namespace: apps
+stringData:
+  values.yaml: |
+    database:
+      user: podinfo
+      password: s3cr3t
+  token: abc
+`
+
+	hr := helmv2.HelmRelease{
+		ObjectMeta: metav1.ObjectMeta{Name: "podinfo", Namespace: "apps"},
+		Spec: helmv2.HelmReleaseSpec{
+			ValuesFrom: []helmv2.ValuesReference{
+				{Kind: "ConfigMap", Name: "podinfo-values"},
+				{Kind: "Secret", Name: "podinfo-credentials"},
+				{Kind: "Secret", Name: "podinfo-credentials", ValuesKey: "token", TargetPath: "auth.token"},
+			},
+			Values: &apiextensionsv1.JSON{Raw: []byte(`{"image":{"tag":"6.1.0"},"database":{"host":"db"}}`)},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		showSecrets bool
+		expect      string
+	}{
+		{
+			name: "secrets are redacted",
+			expect: `# Values of helmrelease apps/podinfo
+# Sources of the top-level keys in the order they are merged, the last one wins:
+#   auth: Secret/apps/podinfo-credentials[token] -> auth.token
+#   database: Secret/apps/podinfo-credentials[values.yaml], spec.values
+#   image: ConfigMap/apps/podinfo-values[values.yaml], spec.values, --set apps/podinfo:image.tag=6.2.0
+#   replicas: ConfigMap/apps/podinfo-values[values.yaml]
+auth:
+  token: <redacted>
+database:
+  host: db
+  password: <redacted>
+  user: <redacted>
+image:
+  repository: ghcr.io/stefanprodan/podinfo
+  tag: 6.2.0
+replicas: 2
+`,
+		},
+		{
+			name:        "show secrets",
+			showSecrets: true,
+			expect: `# Values of helmrelease apps/podinfo
+# Sources of the top-level keys in the order they are merged, the last one wins:
+#   auth: Secret/apps/podinfo-credentials[token] -> auth.token
+#   database: Secret/apps/podinfo-credentials[values.yaml], spec.values
+#   image: ConfigMap/apps/podinfo-values[values.yaml], spec.values, --set apps/podinfo:image.tag=6.2.0
+#   replicas: ConfigMap/apps/podinfo-values[values.yaml]
+auth:
+  token: abc
+database:
+  host: db
+  password: s3cr3t
+  user: podinfo
+image:
+  repository: ghcr.io/stefanprodan/podinfo
+  tag: 6.2.0
+replicas: 2
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			override, err := ParseValuesOverride("apps/podinfo:image.tag=6.2.0", false)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			dir := filepath.Join(t.TempDir(), "values")
+			h := NewHelmBuilder(logr.Discard(), HelmOpts{
+				DumpValuesDir:   dir,
+				ShowSecrets:     tt.showSecrets,
+				ValuesOverrides: []ValuesOverride{override},
+			})
+
+			trace := &valuesTrace{}
+			values, err := h.composeValues(withValuesTrace(context.Background(), trace), newResourceIndex(t, db), hr)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(h.dumpValues(context.Background(), hr, values, trace)).To(Succeed())
+
+			b, err := os.ReadFile(filepath.Join(dir, "apps_podinfo.yaml"))
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(string(b)).To(Equal(tt.expect))
+
+			// Dumping never changes the values the release is rendered with
+			g.Expect(values["database"]).To(HaveKeyWithValue("password", "s3cr3t"))
+		})
+	}
+}
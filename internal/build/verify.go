@@ -0,0 +1,69 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"slices"
+)
+
+// verifyProviders are the chart verification providers flux-build implements for OCI HelmRepositories.
+var verifyProviders = []string{"cosign", "notation"}
+
+// UnsupportedVerifyPolicy decides how charts whose spec.verify flux-build can't check are handled.
+type UnsupportedVerifyPolicy string
+
+const (
+	// UnsupportedVerifyError fails the build of the HelmRelease.
+	UnsupportedVerifyError UnsupportedVerifyPolicy = "error"
+	// UnsupportedVerifyWarn logs that the chart is not verified and builds it unverified.
+	UnsupportedVerifyWarn UnsupportedVerifyPolicy = "warn"
+)
+
+// ParseUnsupportedVerifyPolicy parses error or warn, an empty policy is error.
+func ParseUnsupportedVerifyPolicy(policy string) (UnsupportedVerifyPolicy, error) {
+	switch UnsupportedVerifyPolicy(policy) {
+	case "", UnsupportedVerifyError:
+		return UnsupportedVerifyError, nil
+	case UnsupportedVerifyWarn:
+		return UnsupportedVerifyWarn, nil
+	}
+
+	return "", fmt.Errorf("unknown unsupported verify policy `%s`, supported policies are %s, %s", policy, UnsupportedVerifyError, UnsupportedVerifyWarn)
+}
+
+// VerifyNotImplementedError is returned for charts whose spec.verify flux-build can't check.
+type VerifyNotImplementedError struct {
+	// Provider is the verification provider.
+	Provider string
+	// SourceKind is the kind of the chart source if flux-build does not verify charts of that kind at all.
+	SourceKind string
+}
+
+func (e *VerifyNotImplementedError) Error() string {
+	if e.SourceKind != "" {
+		return fmt.Sprintf("verification not implemented for provider %s of %s charts in flux-build", e.Provider, e.SourceKind)
+	}
+
+	return fmt.Sprintf("verification not implemented for provider %s in flux-build", e.Provider)
+}
+
+// verifyChart reports whether a chart from a source of sourceKind is verified by provider. A chart without a provider
+// is not verified. Charts which flux-build can't verify fail with a VerifyNotImplementedError unless UnsupportedVerify is
+// warn, they are built unverified then. Only charts of OCI HelmRepositories are verified, sourceKind is empty for them.
+func (h *Helm) verifyChart(ctx context.Context, provider, sourceKind string) (bool, error) {
+	if provider == "" {
+		return false, nil
+	}
+
+	if sourceKind == "" && slices.Contains(verifyProviders, provider) {
+		return true, nil
+	}
+
+	err := &VerifyNotImplementedError{Provider: provider, SourceKind: sourceKind}
+	if h.opts.UnsupportedVerify != UnsupportedVerifyWarn {
+		return false, err
+	}
+
+	h.logger(ctx).Info("chart is not verified", "reason", err.Error())
+	return false, nil
+}
@@ -0,0 +1,150 @@
+package build
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"github.com/go-logr/logr"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestVerifyChart(t *testing.T) {
+	tests := []struct {
+		name         string
+		provider     string
+		sourceKind   string
+		policy       UnsupportedVerifyPolicy
+		expectVerify bool
+		expectErr    string
+	}{
+		{
+			name: "no provider",
+		},
+		{
+			name:         "cosign",
+			provider:     "cosign",
+			expectVerify: true,
+		},
+		{
+			name:         "notation",
+			provider:     "notation",
+			policy:       UnsupportedVerifyWarn,
+			expectVerify: true,
+		},
+		{
+			name:      "unknown provider",
+			provider:  "sigstore",
+			expectErr: "verification not implemented for provider sigstore in flux-build",
+		},
+		{
+			name:     "unknown provider with warn policy",
+			provider: "sigstore",
+			policy:   UnsupportedVerifyWarn,
+		},
+		{
+			name:       "chart of a GitRepository",
+			provider:   "cosign",
+			sourceKind: "GitRepository",
+			policy:     UnsupportedVerifyError,
+			expectErr:  "verification not implemented for provider cosign of GitRepository charts in flux-build",
+		},
+		{
+			name:       "chart of a Bucket with warn policy",
+			provider:   "cosign",
+			sourceKind: "Bucket",
+			policy:     UnsupportedVerifyWarn,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			h := NewHelmBuilder(logr.Discard(), HelmOpts{UnsupportedVerify: tt.policy})
+			verify, err := h.verifyChart(context.Background(), tt.provider, tt.sourceKind)
+			g.Expect(verify).To(Equal(tt.expectVerify))
+			if tt.expectErr == "" {
+				g.Expect(err).ToNot(HaveOccurred())
+				return
+			}
+
+			g.Expect(err).To(MatchError(tt.expectErr))
+			var verifyErr *VerifyNotImplementedError
+			g.Expect(errors.As(err, &verifyErr)).To(BeTrue())
+		})
+	}
+}
+
+func TestResolveChartUnsupportedVerify(t *testing.T) {
+	// The GitRepository is never checked out, its URL does not exist
+	db := newResourceIndex(t, `apiVersion: source.toolkit.fluxcd.io/v1
+kind: GitRepository
+metadata:
+  name: charts
+  namespace: apps
+spec:
+  url: https://127.0.0.1:1/does-not-exist.git
+---
+apiVersion: source.toolkit.fluxcd.io/v1
+kind: HelmRepository
+metadata:
+  name: charts
+  namespace: apps
+spec:
+  type: oci
+  url: oci://127.0.0.1:1/charts
+`)
+
+	tests := []struct {
+		name      string
+		kind      string
+		provider  string
+		policy    UnsupportedVerifyPolicy
+		expectErr string
+	}{
+		{
+			name:      "GitRepository",
+			kind:      "GitRepository",
+			provider:  "cosign",
+			expectErr: "failed to verify chart of helmrelease `apps/app`: verification not implemented for provider cosign of GitRepository charts in flux-build",
+		},
+		{
+			name:      "GitRepository with warn policy",
+			kind:      "GitRepository",
+			provider:  "cosign",
+			policy:    UnsupportedVerifyWarn,
+			expectErr: "does-not-exist.git",
+		},
+		{
+			name:      "unknown provider of an OCI HelmRepository",
+			kind:      "HelmRepository",
+			provider:  "sigstore",
+			expectErr: "failed to verify chart `app`: verification not implemented for provider sigstore in flux-build",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			h := NewHelmBuilder(logr.Discard(), HelmOpts{UnsupportedVerify: tt.policy})
+			hr := &helmv2.HelmRelease{
+				ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "apps"},
+				Spec: helmv2.HelmReleaseSpec{
+					Chart: &helmv2.HelmChartTemplate{
+						Spec: helmv2.HelmChartTemplateSpec{
+							Chart:     "app",
+							Version:   "1.0.0",
+							SourceRef: helmv2.CrossNamespaceObjectReference{Kind: tt.kind, Name: "charts"},
+							Verify:    &helmv2.HelmChartTemplateVerification{Provider: tt.provider},
+						},
+					},
+				},
+			}
+
+			_, _, err := h.resolveChart(context.Background(), hr, &chartVerification{}, db)
+			g.Expect(err).To(MatchError(ContainSubstring(tt.expectErr)))
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/doodlescheduling/flux-build/internal/helm/coalesce"
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	helmchart "helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+)
+
+// RenderWarningsError is returned if WarningsAsErrors is set and Helm warned while rendering a HelmRelease.
+type RenderWarningsError struct {
+	// Release is the namespace and name of the HelmRelease.
+	Release string
+	// Warnings are the warnings of Helm.
+	Warnings []string
+}
+
+func (e *RenderWarningsError) Error() string {
+	return fmt.Sprintf("helm warned while rendering helmrelease `%s`: %s", e.Release, strings.Join(e.Warnings, "; "))
+}
+
+// checkRenderWarnings logs the warnings Helm emits while coalescing the values of the HelmRelease with the values of
+// chart, for instance a table overwritten by a scalar, and records them if the context carries render warnings, see
+// WithRenderWarnings. chart must be the chart as rendered, disabled subcharts are not coalesced.
+// It fails with a RenderWarningsError if WarningsAsErrors is set.
+func (h *Helm) checkRenderWarnings(ctx context.Context, hr helmv2.HelmRelease, values chartutil.Values, chart *helmchart.Chart) error {
+	warnings, err := coalesce.Warnings(chart, values)
+	if err != nil {
+		// Helm fails the render on the same error
+		return nil
+	}
+
+	for _, warning := range warnings {
+		h.logger(ctx).Info("helm warning", "release", releaseName(hr), "warning", warning)
+	}
+
+	if w := renderWarningsFrom(ctx); w != nil {
+		*w = warnings
+	}
+
+	if h.opts.WarningsAsErrors && len(warnings) > 0 {
+		return &RenderWarningsError{Release: hr.GetNamespace() + "/" + hr.GetName(), Warnings: warnings}
+	}
+
+	return nil
+}
+
+type renderWarningsKey struct{}
+
+// WithRenderWarnings returns a context which records the warnings of Helm while rendering the HelmRelease built with
+// it into w.
+func WithRenderWarnings(ctx context.Context, w *[]string) context.Context {
+	return context.WithValue(ctx, renderWarningsKey{}, w)
+}
+
+func renderWarningsFrom(ctx context.Context) *[]string {
+	w, _ := ctx.Value(renderWarningsKey{}).(*[]string)
+	return w
+}
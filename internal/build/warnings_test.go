@@ -0,0 +1,72 @@
+package build
+
+import (
+	"context"
+	"testing"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"github.com/go-logr/logr/funcr"
+	. "github.com/onsi/gomega"
+	helmchart "helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCheckRenderWarnings(t *testing.T) {
+	c := &helmchart.Chart{
+		Metadata: &helmchart.Metadata{Name: "app", Version: "0.1.0", APIVersion: helmchart.APIVersionV2},
+		Values:   map[string]interface{}{"image": "nginx"},
+	}
+
+	tests := []struct {
+		name             string
+		values           chartutil.Values
+		warningsAsErrors bool
+		expectWarnings   []string
+		expectLogs       []string
+		expectErr        string
+	}{
+		{
+			name:   "no warnings",
+			values: chartutil.Values{"image": "httpd"},
+		},
+		{
+			name:           "warnings",
+			values:         chartutil.Values{"image": map[string]interface{}{"tag": "v1"}},
+			expectWarnings: []string{"warning: skipped value for app.image: Not a table."},
+			expectLogs:     []string{`"level"=0 "msg"="helm warning" "release"="app" "warning"="warning: skipped value for app.image: Not a table."`},
+		},
+		{
+			name:             "warnings as errors",
+			values:           chartutil.Values{"image": map[string]interface{}{"tag": "v1"}},
+			warningsAsErrors: true,
+			expectWarnings:   []string{"warning: skipped value for app.image: Not a table."},
+			expectLogs:       []string{`"level"=0 "msg"="helm warning" "release"="app" "warning"="warning: skipped value for app.image: Not a table."`},
+			expectErr:        "helm warned while rendering helmrelease `default/app`: warning: skipped value for app.image: Not a table.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			var logs []string
+			logger := funcr.New(func(prefix, args string) {
+				logs = append(logs, args)
+			}, funcr.Options{})
+
+			var warnings []string
+			hr := helmv2.HelmRelease{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"}}
+			h := NewHelmBuilder(logger, HelmOpts{WarningsAsErrors: tt.warningsAsErrors})
+			err := h.checkRenderWarnings(WithRenderWarnings(context.Background(), &warnings), hr, tt.values, c)
+			if tt.expectErr != "" {
+				g.Expect(err).To(MatchError(tt.expectErr))
+				g.Expect(err).To(BeAssignableToTypeOf(&RenderWarningsError{}))
+			} else {
+				g.Expect(err).ToNot(HaveOccurred())
+			}
+
+			g.Expect(warnings).To(Equal(tt.expectWarnings))
+			g.Expect(logs).To(Equal(tt.expectLogs))
+		})
+	}
+}
@@ -63,34 +63,40 @@ func (c *Cache[K]) Get(key K) (any, bool) {
 	return item, true
 }
 
-type valueLock chan struct{}
+// valueLock is the item of a key whose value is being set, done is closed once the value is set or the lock failed.
+type valueLock struct {
+	done chan struct{}
+	err  error
+}
 
 // GetOrLock returns an item from the cache or creats lock for the first requestor of specific key
-// and locks others until the item will be set.
-func (c *Cache[K]) GetOrLock(key K) (any, bool) {
+// and locks others until the item will be set. If the lock fails the waiting requestors get its error.
+func (c *Cache[K]) GetOrLock(key K) (any, bool, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	item, found := c.items[key]
 	if !found {
 		// Create lock, return to the first caller.
-		vl := make(valueLock)
-		c.items[key] = vl
-		return nil, false
+		c.items[key] = &valueLock{done: make(chan struct{})}
+		return nil, false, nil
 	}
-	if vl, ok := item.(valueLock); ok {
+	if vl, ok := item.(*valueLock); ok {
 		// No value yet, unlock and block until ready.
 		c.mu.Unlock()
-		<-vl
+		<-vl.done
 		// Done waiting, re-locking.
 		c.mu.Lock()
+		if vl.err != nil {
+			return nil, false, vl.err
+		}
 		item, found = c.items[key]
-		if _, ok := item.(valueLock); !found || ok {
+		if _, ok := item.(*valueLock); !found || ok {
 			// Can happen only if the cache was cleared while waiting or the cache is over capacity.
-			return nil, false
+			return nil, false, nil
 		}
 	}
 
-	return item, true
+	return item, true, nil
 }
 
 // SetUnlock sets value for the key, if there was a lock for the key, unlocks it.
@@ -99,14 +105,27 @@ func (c *Cache[K]) SetUnlock(key K, value any) {
 	defer c.mu.Unlock()
 
 	if v, found := c.items[key]; found {
-		if vl, ok := v.(valueLock); ok {
-			close(vl)
+		if vl, ok := v.(*valueLock); ok {
+			close(vl.done)
 		}
 	}
 
 	c.items[key] = value
 }
 
+// FailUnlock removes the lock of the key and returns err to the requestors waiting for it, the next requestor
+// of the key gets the lock. It does nothing if the key isn't locked.
+func (c *Cache[K]) FailUnlock(key K, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if vl, ok := c.items[key].(*valueLock); ok {
+		vl.err = err
+		close(vl.done)
+		delete(c.items, key)
+	}
+}
+
 // Delete an item from the cache. Does nothing if the key is not in the cache.
 func (c *Cache[K]) Delete(key K) {
 	c.mu.Lock()
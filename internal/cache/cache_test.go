@@ -17,7 +17,10 @@ limitations under the License.
 package cache
 
 import (
+	"errors"
+	"sync"
 	"testing"
+	"time"
 
 	. "github.com/onsi/gomega"
 )
@@ -76,15 +79,56 @@ func TestCache(t *testing.T) {
 	g.Expect(found).To(BeTrue())
 	g.Expect(item).To(Equal("value1"))
 
-	item, found = cache2.GetOrLock(3)
+	item, found, err = cache2.GetOrLock(3)
+	g.Expect(err).ToNot(HaveOccurred())
 	g.Expect(found).To(BeFalse())
 
 	go func() {
 		// Locks until item is set.
-		item, found = cache2.GetOrLock(3)
+		item, found, err := cache2.GetOrLock(3)
+		g.Expect(err).ToNot(HaveOccurred())
 		g.Expect(found).To(BeTrue())
 		g.Expect(item).To(Equal("value3"))
 	}()
 
 	cache2.SetUnlock(3, "value3")
 }
+
+func TestCacheFailUnlock(t *testing.T) {
+	g := NewWithT(t)
+
+	cache := New[string]()
+	_, found, err := cache.GetOrLock("key")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(found).To(BeFalse())
+
+	var wg sync.WaitGroup
+	errs := make([]error, 3)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, _, errs[i] = cache.GetOrLock("key")
+		}(i)
+	}
+
+	// Give the waiters time to block on the lock
+	time.Sleep(50 * time.Millisecond)
+	cache.FailUnlock("key", errors.New("corrupt"))
+	wg.Wait()
+
+	for _, err := range errs {
+		g.Expect(err).To(MatchError("corrupt"))
+	}
+
+	// The lock is gone, the next requestor takes it
+	_, found, err = cache.GetOrLock("key")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(found).To(BeFalse())
+
+	cache.SetUnlock("key", "value")
+	item, found, err := cache.GetOrLock("key")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(found).To(BeTrue())
+	g.Expect(item).To(Equal("value"))
+}
@@ -8,6 +8,7 @@ import (
 	"hash/fnv"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/doodlescheduling/flux-build/internal/cache"
 	"github.com/doodlescheduling/flux-build/internal/fcache"
@@ -44,6 +45,12 @@ type CacheKey struct {
 	Repo string
 }
 
+// memLock is the key to unlock a chart of the in-memory cache.
+type memLock struct {
+	key  CacheKey
+	path string
+}
+
 type Cache struct {
 	dir      string
 	inmemory *cache.Cache[CacheKey]
@@ -56,6 +63,30 @@ func (c *Cache) filepath(basename string) string {
 	return filepath.Join(c.dir, basename+"-"+hex.EncodeToString(randBytes)+".tgz")
 }
 
+// credentialsSeparator separates the URL and the credentials of a repository key.
+const credentialsSeparator = "#"
+
+// RepositoryKey returns the key repositories and charts are cached by. credentials identifies the credentials the
+// repository is accessed with and is empty for anonymous repositories, repositories with the same URL but different
+// credentials never share cached repositories or charts.
+func RepositoryKey(url, credentials string) string {
+	if credentials == "" {
+		return url
+	}
+
+	return url + credentialsSeparator + credentials
+}
+
+// splitRepositoryKey returns the URL and the credentials of a repository key.
+func splitRepositoryKey(key string) (string, string) {
+	i := strings.LastIndex(key, credentialsSeparator)
+	if i < 0 {
+		return key, ""
+	}
+
+	return key[:i], key[i+len(credentialsSeparator):]
+}
+
 func basename(repo string, ref chart.RemoteReference) string {
 	h := fnv.New32a()
 	h.Write([]byte(repo))
@@ -63,7 +94,8 @@ func basename(repo string, ref chart.RemoteReference) string {
 }
 
 // GetOrLock returns path of Helm chart to store to or read from and a key to unlock.
-// If the key is nil, the file is cached already and can be used.
+// If the key is nil, the file is cached already and can be used. Callers waiting for a chart which fails to be
+// cached get the error of FailUnlock with the in-memory cache, with the filesystem cache they take the lock instead.
 func (c *Cache) GetOrLock(repo string, ref chart.RemoteReference) (string, any, error) {
 	fn := basename(repo, ref)
 	if c.fs != nil {
@@ -74,23 +106,55 @@ func (c *Cache) GetOrLock(repo string, ref chart.RemoteReference) (string, any,
 			return "", nil, err
 		}
 		if flock != nil {
-			return path, flock, nil
+			return path, &fsLock{file: flock, path: path, repo: repo, ref: ref}, nil
 		}
+
+		// The last usage is informational, a failure must not fail the build
+		_ = touch(path)
 		return path, nil, nil
 	}
 
 	if c.inmemory != nil {
 		key := CacheKey{RemoteReference: ref, Repo: repo}
-		p, ok := c.inmemory.GetOrLock(key)
+		p, ok, err := c.inmemory.GetOrLock(key)
+		if err != nil {
+			return "", nil, err
+		}
 		if ok {
 			return p.(string), nil, nil
 		}
-		return c.filepath(fn), key, nil
+		path := c.filepath(fn)
+		return path, &memLock{key: key, path: path}, nil
 	}
 
 	return c.filepath(fn), nil, nil
 }
 
+// Get returns the path of a cached Helm chart without locking it, it returns false if the chart isn't cached
+// or is being written.
+func (c *Cache) Get(repo string, ref chart.RemoteReference) (string, bool) {
+	fn := basename(repo, ref)
+	if c.fs != nil {
+		fn += ".tgz"
+		if !c.fs.Ready(fn) {
+			return "", false
+		}
+
+		path := c.fs.Filename(fn)
+		_ = touch(path)
+		return path, true
+	}
+
+	if c.inmemory != nil {
+		p, ok := c.inmemory.Get(CacheKey{RemoteReference: ref, Repo: repo})
+		if path, isPath := p.(string); ok && isPath {
+			return path, true
+		}
+	}
+
+	return "", false
+}
+
 // SetUnlock unlocks Helm chart by the key.
 // It's safe to pass a nil.
 func (c *Cache) SetUnlock(a any) error {
@@ -99,15 +163,17 @@ func (c *Cache) SetUnlock(a any) error {
 	}
 
 	if c.fs != nil {
-		fl, ok := a.(*os.File)
+		fl, ok := a.(*fsLock)
 		if !ok {
-			return fmt.Errorf("unlock failed, can't convert to *os.File, type is %t", a)
+			return fmt.Errorf("unlock failed, can't convert to *fsLock, type is %T", a)
 		}
 		if fl == nil {
 			// Nothing to unlock
 			return nil
 		}
-		err := c.fs.SetUnlock(fl)
+		// Charts which failed to be written are left unindexed and detected by Verify
+		_ = index(fl.path, fl.repo, fl.ref)
+		err := c.fs.SetUnlock(fl.file)
 		if err != nil {
 			return err
 		}
@@ -115,11 +181,49 @@ func (c *Cache) SetUnlock(a any) error {
 	}
 
 	if c.inmemory != nil {
-		key, ok := a.(CacheKey)
+		ml, ok := a.(*memLock)
+		if !ok {
+			return fmt.Errorf("unlock failed, can't convert to *memLock, type is %T", a)
+		}
+		c.inmemory.SetUnlock(ml.key, ml.path)
+		return nil
+	}
+
+	return nil
+}
+
+// FailUnlock removes the chart written for the key and unlocks it without caching it, err is returned to the callers
+// waiting for the chart in GetOrLock. The next caller of GetOrLock takes the lock and pulls the chart again.
+// It's safe to pass a nil.
+func (c *Cache) FailUnlock(a any, err error) error {
+	if a == nil {
+		return nil
+	}
+
+	if c.fs != nil {
+		fl, ok := a.(*fsLock)
+		if !ok {
+			return fmt.Errorf("unlock failed, can't convert to *fsLock, type is %T", a)
+		}
+		if fl == nil {
+			return nil
+		}
+		if err := os.Remove(fl.path); err != nil && !os.IsNotExist(err) {
+			_ = c.fs.Unlock(fl.file)
+			return err
+		}
+		return c.fs.Unlock(fl.file)
+	}
+
+	if c.inmemory != nil {
+		ml, ok := a.(*memLock)
 		if !ok {
-			return fmt.Errorf("unlock failed, can't convert to CacheKey, type is %t", a)
+			return fmt.Errorf("unlock failed, can't convert to *memLock, type is %T", a)
+		}
+		c.inmemory.FailUnlock(ml.key, err)
+		if err := os.Remove(ml.path); err != nil && !os.IsNotExist(err) {
+			return err
 		}
-		c.inmemory.SetUnlock(key, c.filepath(basename(key.Repo, key.RemoteReference)))
 		return nil
 	}
 
@@ -127,18 +231,22 @@ func (c *Cache) SetUnlock(a any) error {
 }
 
 // RepoGetOrLock returns repository.Downloader if it was already cached or nil and
-// blocks further calls until unlocked.
-func (c *Cache) RepoGetOrLock(url string) repository.Downloader {
+// blocks further calls until unlocked. Callers waiting for a repository which fails to be
+// constructed get the error of RepoFailUnlock.
+func (c *Cache) RepoGetOrLock(url string) (repository.Downloader, error) {
 	if c.inmemory == nil {
-		return nil
+		return nil, nil
 	}
 
 	key := CacheKey{Repo: url}
-	r, ok := c.inmemory.GetOrLock(key)
+	r, ok, err := c.inmemory.GetOrLock(key)
+	if err != nil {
+		return nil, err
+	}
 	if ok {
-		return r.(repository.Downloader)
+		return r.(repository.Downloader), nil
 	}
-	return nil
+	return nil, nil
 }
 
 // RepoSetUnlock stores repository.Downloader in the cache and unlocks it.
@@ -151,6 +259,16 @@ func (c *Cache) RepoSetUnlock(url string, repo repository.Downloader) {
 	c.inmemory.SetUnlock(key, repo)
 }
 
+// RepoFailUnlock unlocks the repository without caching it, err is returned to the callers waiting for it in
+// RepoGetOrLock. The next caller of RepoGetOrLock takes the lock and constructs the repository again.
+func (c *Cache) RepoFailUnlock(url string, err error) {
+	if c.inmemory == nil {
+		return
+	}
+
+	c.inmemory.FailUnlock(CacheKey{Repo: url}, err)
+}
+
 func New(cacheType, cacheDir string) (*Cache, error) {
 	ct, err := StringToCacheType(cacheType)
 	if err != nil {
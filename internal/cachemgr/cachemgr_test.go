@@ -0,0 +1,132 @@
+package cachemgr
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/doodlescheduling/flux-build/internal/helm/chart"
+	"github.com/doodlescheduling/flux-build/internal/helm/repository"
+	. "github.com/onsi/gomega"
+)
+
+func TestGet(t *testing.T) {
+	for _, cacheType := range []string{"none", "inmemory", "fs"} {
+		t.Run(cacheType, func(t *testing.T) {
+			g := NewWithT(t)
+
+			c, err := New(cacheType, t.TempDir())
+			g.Expect(err).ToNot(HaveOccurred())
+
+			ref := chart.RemoteReference{Name: "podinfo", Version: "6.0.0"}
+			_, ok := c.Get("https://charts.example.com/", ref)
+			g.Expect(ok).To(BeFalse())
+
+			path, key, err := c.GetOrLock("https://charts.example.com/", ref)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			// A chart which is being written is not returned
+			_, ok = c.Get("https://charts.example.com/", ref)
+			g.Expect(ok).To(BeFalse())
+
+			g.Expect(c.SetUnlock(key)).To(Succeed())
+
+			cached, ok := c.Get("https://charts.example.com/", ref)
+			if cacheType == "none" {
+				g.Expect(ok).To(BeFalse())
+				return
+			}
+
+			g.Expect(ok).To(BeTrue())
+			g.Expect(cached).To(Equal(path))
+		})
+	}
+}
+
+func TestFailUnlock(t *testing.T) {
+	for _, cacheType := range []string{"none", "inmemory", "fs"} {
+		t.Run(cacheType, func(t *testing.T) {
+			g := NewWithT(t)
+
+			c, err := New(cacheType, t.TempDir())
+			g.Expect(err).ToNot(HaveOccurred())
+
+			ref := chart.RemoteReference{Name: "podinfo", Version: "6.0.0"}
+			path, key, err := c.GetOrLock("https://charts.example.com/", ref)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(os.WriteFile(path, []byte("corrupt"), 0644)).To(Succeed())
+
+			g.Expect(c.FailUnlock(key, errors.New("corrupt chart"))).To(Succeed())
+
+			_, ok := c.Get("https://charts.example.com/", ref)
+			g.Expect(ok).To(BeFalse())
+			if cacheType != "none" {
+				g.Expect(path).ToNot(BeAnExistingFile())
+			}
+
+			// The next caller takes the lock
+			_, key, err = c.GetOrLock("https://charts.example.com/", ref)
+			g.Expect(err).ToNot(HaveOccurred())
+			if cacheType != "none" {
+				g.Expect(key).ToNot(BeNil())
+			}
+			g.Expect(c.SetUnlock(key)).To(Succeed())
+		})
+	}
+}
+
+func TestRepoFailUnlock(t *testing.T) {
+	for _, cacheType := range []string{"none", "inmemory", "fs"} {
+		t.Run(cacheType, func(t *testing.T) {
+			g := NewWithT(t)
+
+			c, err := New(cacheType, t.TempDir())
+			g.Expect(err).ToNot(HaveOccurred())
+
+			repo, err := c.RepoGetOrLock("https://charts.example.com/")
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(repo).To(BeNil())
+
+			var wg sync.WaitGroup
+			errs := make([]error, 3)
+			for i := range errs {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					_, errs[i] = c.RepoGetOrLock("https://charts.example.com/")
+				}(i)
+			}
+
+			// Give the waiters time to block on the lock
+			time.Sleep(50 * time.Millisecond)
+			c.RepoFailUnlock("https://charts.example.com/", errors.New("invalid ca.crt"))
+			wg.Wait()
+
+			for _, err := range errs {
+				if cacheType == "none" {
+					g.Expect(err).ToNot(HaveOccurred())
+					continue
+				}
+				g.Expect(err).To(MatchError("invalid ca.crt"))
+			}
+
+			// The next caller takes the lock
+			repo, err = c.RepoGetOrLock("https://charts.example.com/")
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(repo).To(BeNil())
+
+			chartRepo := &repository.ChartRepository{}
+			c.RepoSetUnlock("https://charts.example.com/", chartRepo)
+
+			repo, err = c.RepoGetOrLock("https://charts.example.com/")
+			g.Expect(err).ToNot(HaveOccurred())
+			if cacheType == "none" {
+				g.Expect(repo).To(BeNil())
+				return
+			}
+			g.Expect(repo).To(BeIdenticalTo(chartRepo))
+		})
+	}
+}
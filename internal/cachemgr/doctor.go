@@ -0,0 +1,271 @@
+package cachemgr
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/doodlescheduling/flux-build/internal/fcache"
+	"github.com/doodlescheduling/flux-build/internal/helm/chart"
+	"github.com/doodlescheduling/flux-build/internal/helm/repository"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"sigs.k8s.io/yaml"
+)
+
+// EntryStatus is the result of verifying an Entry.
+type EntryStatus string
+
+const (
+	// EntryValid is a chart matching its indexed digest.
+	EntryValid EntryStatus = "valid"
+	// EntryUnindexed is a loadable chart without metadata.
+	EntryUnindexed EntryStatus = "unindexed"
+	// EntryCorrupted is a chart which doesn't match its indexed digest or can't be loaded.
+	EntryCorrupted EntryStatus = "corrupted"
+	// EntryIncomplete is a chart which was never completely written, for instance due to a crash.
+	EntryIncomplete EntryStatus = "incomplete"
+)
+
+// LockEntry references a chart which must be kept when pruning.
+type LockEntry struct {
+	Repository string `json:"repository"`
+	Chart      string `json:"chart"`
+	Version    string `json:"version"`
+}
+
+// Lockfile lists the charts in use, for instance by a repository of HelmReleases.
+type Lockfile struct {
+	Charts []LockEntry `json:"charts"`
+}
+
+// LoadLockfile reads the charts of a lockfile.
+func LoadLockfile(path string) ([]LockEntry, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var lockfile Lockfile
+	if err := yaml.UnmarshalStrict(b, &lockfile); err != nil {
+		return nil, fmt.Errorf("invalid lockfile %s: %w", path, err)
+	}
+
+	return lockfile.Charts, nil
+}
+
+// PruneOptions select the entries removed by Prune.
+type PruneOptions struct {
+	// OlderThan prunes entries cached longer ago, zero disables pruning by age.
+	OlderThan time.Duration
+	// Lockfile prunes entries which are not referenced by any of its charts if set.
+	Lockfile []LockEntry
+	// DryRun only returns the entries which would be pruned.
+	DryRun bool
+}
+
+// ListEntries returns the charts of the filesystem cache dir sorted by repository, chart and version.
+// Entries without metadata carry the chart and version encoded into their file name.
+func ListEntries(dir string) ([]Entry, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, "*.tgz"))
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	for _, path := range matches {
+		entry, err := readMetadata(path)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read metadata of %s: %w", path, err)
+		}
+
+		if !entry.Indexed {
+			stat, err := os.Stat(path)
+			if err != nil {
+				return nil, err
+			}
+
+			_, entry.Chart, entry.Version = parseBasename(filepath.Base(path))
+			entry.Size = stat.Size()
+			entry.Created = stat.ModTime().UTC()
+			entry.LastUsed = entry.Created
+		}
+
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.Repository != b.Repository {
+			return a.Repository < b.Repository
+		}
+		if a.Chart != b.Chart {
+			return a.Chart < b.Chart
+		}
+		if a.Version != b.Version {
+			return a.Version < b.Version
+		}
+		return a.Path < b.Path
+	})
+
+	return entries, nil
+}
+
+// Verify checks every entry of the cache dir. Indexed entries are verified against their digest,
+// all entries must be complete and loadable as a chart.
+func Verify(dir string) ([]Entry, error) {
+	entries, err := ListEntries(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	fc, err := fcache.New(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, entry := range entries {
+		entries[i].Status = verify(fc, entry)
+	}
+
+	return entries, nil
+}
+
+func verify(fc *fcache.Cache, entry Entry) EntryStatus {
+	if !fc.Ready(filepath.Base(entry.Path)) {
+		return EntryIncomplete
+	}
+
+	if entry.Indexed {
+		digest, _, err := digestFile(entry.Path)
+		if err != nil || digest != entry.Digest {
+			return EntryCorrupted
+		}
+	}
+
+	if _, err := loader.Load(entry.Path); err != nil {
+		return EntryCorrupted
+	}
+
+	if !entry.Indexed {
+		return EntryUnindexed
+	}
+
+	return EntryValid
+}
+
+// Prune removes the entries selected by opts and returns them.
+func Prune(dir string, opts PruneOptions) ([]Entry, error) {
+	entries, err := ListEntries(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	fc, err := fcache.New(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(-opts.OlderThan)
+	var pruned []Entry
+	for _, entry := range entries {
+		expired := opts.OlderThan > 0 && entry.Created.Before(deadline)
+		unreferenced := opts.Lockfile != nil && !referenced(entry, opts.Lockfile)
+		if !expired && !unreferenced {
+			continue
+		}
+
+		if !opts.DryRun {
+			if err := remove(fc, entry); err != nil {
+				return pruned, err
+			}
+		}
+
+		pruned = append(pruned, entry)
+	}
+
+	return pruned, nil
+}
+
+// Repair removes corrupted and incomplete entries and indexes valid entries without metadata.
+// The repaired entries are returned with their status before the repair.
+func Repair(dir string) ([]Entry, error) {
+	entries, err := Verify(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	fc, err := fcache.New(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var repaired []Entry
+	for _, entry := range entries {
+		switch entry.Status {
+		case EntryCorrupted, EntryIncomplete:
+			if err := remove(fc, entry); err != nil {
+				return repaired, err
+			}
+		case EntryUnindexed:
+			// The repository of an unindexed entry is unknown, only its hash is part of the file name
+			digest, size, err := digestFile(entry.Path)
+			if err != nil {
+				return repaired, err
+			}
+
+			entry.Digest, entry.Size = digest, size
+			if err := writeMetadata(entry); err != nil {
+				return repaired, err
+			}
+		default:
+			continue
+		}
+
+		repaired = append(repaired, entry)
+	}
+
+	return repaired, nil
+}
+
+func remove(fc *fcache.Cache, entry Entry) error {
+	name := filepath.Base(entry.Path)
+	return fc.Remove(name, name+metadataSuffix)
+}
+
+// referenced returns true if any chart of the lockfile refers to the entry regardless of the credentials it was
+// pulled with. The repository of unindexed entries is compared by the hash of the cache key as their URL is unknown.
+func referenced(entry Entry, lockfile []LockEntry) bool {
+	name := filepath.Base(entry.Path)
+	for _, l := range lockfile {
+		ref := chart.RemoteReference{Name: l.Chart, Version: l.Version}
+		repos := []string{l.Repository}
+		if normalized, err := repository.NormalizeURL(l.Repository); err == nil {
+			repos = append(repos, normalized)
+		}
+
+		for _, repo := range repos {
+			if entry.Indexed && entry.Repository == repo && entry.Chart == ref.Name && entry.Version == ref.Version {
+				return true
+			}
+
+			if !entry.Indexed && basename(repo, ref)+".tgz" == name {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// parseBasename splits the file name of a cached chart into the repository hash, chart and version.
+func parseBasename(name string) (string, string, string) {
+	parts := strings.SplitN(strings.TrimSuffix(name, ".tgz"), "%", 3)
+	for len(parts) < 3 {
+		parts = append(parts, "")
+	}
+
+	return parts[0], parts[1], parts[2]
+}
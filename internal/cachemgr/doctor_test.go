@@ -0,0 +1,238 @@
+package cachemgr
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/doodlescheduling/flux-build/internal/helm/chart"
+	. "github.com/onsi/gomega"
+)
+
+const testChart = "../helm/testdata/charts/helmchart-0.1.0.tgz"
+
+func cacheChart(t *testing.T, c *Cache, repo string, ref chart.RemoteReference) string {
+	t.Helper()
+
+	path, key, err := c.GetOrLock(repo, ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(testChart)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.SetUnlock(key); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestListEntries(t *testing.T) {
+	g := NewWithT(t)
+	dir := t.TempDir()
+
+	c, err := New("fs", dir)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	path := cacheChart(t, c, "https://charts.example.com", chart.RemoteReference{Name: "helmchart", Version: "0.1.0"})
+	unindexed := cacheChart(t, c, "https://charts.example.com", chart.RemoteReference{Name: "podinfo", Version: "6.0.0"})
+	g.Expect(os.Remove(unindexed + metadataSuffix)).To(Succeed())
+
+	entries, err := ListEntries(dir)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(entries).To(HaveLen(2))
+
+	g.Expect(entries[0].Path).To(Equal(unindexed))
+	g.Expect(entries[0].Indexed).To(BeFalse())
+	g.Expect(entries[0].Repository).To(BeEmpty())
+	g.Expect(entries[0].Chart).To(Equal("podinfo"))
+	g.Expect(entries[0].Version).To(Equal("6.0.0"))
+
+	g.Expect(entries[1].Path).To(Equal(path))
+	g.Expect(entries[1].Indexed).To(BeTrue())
+	g.Expect(entries[1].Repository).To(Equal("https://charts.example.com"))
+	g.Expect(entries[1].Chart).To(Equal("helmchart"))
+	g.Expect(entries[1].Version).To(Equal("0.1.0"))
+	g.Expect(entries[1].Digest).To(HavePrefix("sha256:"))
+	g.Expect(entries[1].Size).To(BeNumerically(">", 0))
+	g.Expect(entries[1].LastUsed).To(Equal(entries[1].Created))
+
+	_, key, err := c.GetOrLock("https://charts.example.com", chart.RemoteReference{Name: "helmchart", Version: "0.1.0"})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(key).To(BeNil())
+
+	entry, err := readMetadata(path)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(entry.LastUsed).To(BeTemporally(">", entries[1].Created))
+}
+
+func TestVerifyAndRepair(t *testing.T) {
+	g := NewWithT(t)
+	dir := t.TempDir()
+
+	c, err := New("fs", dir)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	valid := cacheChart(t, c, "https://charts.example.com", chart.RemoteReference{Name: "valid", Version: "1.0.0"})
+	unindexed := cacheChart(t, c, "https://charts.example.com", chart.RemoteReference{Name: "unindexed", Version: "1.0.0"})
+	g.Expect(os.Remove(unindexed + metadataSuffix)).To(Succeed())
+	corrupted := cacheChart(t, c, "https://charts.example.com", chart.RemoteReference{Name: "corrupted", Version: "1.0.0"})
+	g.Expect(os.WriteFile(corrupted, []byte("truncated"), 0644)).To(Succeed())
+
+	// A chart written by a build which crashed before marking it as ready
+	incomplete := filepath.Join(dir, basename("https://charts.example.com", chart.RemoteReference{Name: "incomplete", Version: "1.0.0"})+".tgz")
+	g.Expect(os.WriteFile(incomplete, []byte("partial"), 0644)).To(Succeed())
+	g.Expect(os.WriteFile(incomplete+".lock", nil, 0644)).To(Succeed())
+
+	statuses := func(entries []Entry) map[string]EntryStatus {
+		m := make(map[string]EntryStatus)
+		for _, entry := range entries {
+			m[entry.Path] = entry.Status
+		}
+		return m
+	}
+
+	entries, err := Verify(dir)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(statuses(entries)).To(Equal(map[string]EntryStatus{
+		valid:      EntryValid,
+		unindexed:  EntryUnindexed,
+		corrupted:  EntryCorrupted,
+		incomplete: EntryIncomplete,
+	}))
+
+	repaired, err := Repair(dir)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(statuses(repaired)).To(Equal(map[string]EntryStatus{
+		unindexed:  EntryUnindexed,
+		corrupted:  EntryCorrupted,
+		incomplete: EntryIncomplete,
+	}))
+
+	for _, path := range []string{corrupted, incomplete} {
+		g.Expect(path).ToNot(BeAnExistingFile())
+		g.Expect(path + metadataSuffix).ToNot(BeAnExistingFile())
+		g.Expect(path + ".lock").ToNot(BeAnExistingFile())
+	}
+
+	entries, err = Verify(dir)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(statuses(entries)).To(Equal(map[string]EntryStatus{
+		valid:     EntryValid,
+		unindexed: EntryValid,
+	}))
+
+	// A removed entry is cached again by the next build
+	_, key, err := c.GetOrLock("https://charts.example.com", chart.RemoteReference{Name: "corrupted", Version: "1.0.0"})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(key).ToNot(BeNil())
+	g.Expect(c.SetUnlock(key)).To(Succeed())
+}
+
+func TestPrune(t *testing.T) {
+	tests := []struct {
+		name         string
+		opts         PruneOptions
+		expectPruned []string
+	}{
+		{
+			name: "nothing selected",
+		},
+		{
+			name:         "older than",
+			opts:         PruneOptions{OlderThan: 24 * time.Hour},
+			expectPruned: []string{"old"},
+		},
+		{
+			name: "not in lockfile",
+			opts: PruneOptions{Lockfile: []LockEntry{
+				{Repository: "https://charts.example.com", Chart: "old", Version: "1.0.0"},
+				{Repository: "https://charts.example.com/", Chart: "new", Version: "1.0.0"},
+			}},
+			expectPruned: []string{"unused"},
+		},
+		{
+			name: "older than or not in lockfile",
+			opts: PruneOptions{OlderThan: 24 * time.Hour, Lockfile: []LockEntry{
+				{Repository: "https://charts.example.com", Chart: "old", Version: "1.0.0"},
+				{Repository: "https://charts.example.com", Chart: "new", Version: "1.0.0"},
+			}},
+			expectPruned: []string{"old", "unused"},
+		},
+		{
+			name:         "dry run",
+			opts:         PruneOptions{OlderThan: 24 * time.Hour, DryRun: true},
+			expectPruned: []string{"old"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			dir := t.TempDir()
+
+			c, err := New("fs", dir)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			paths := make(map[string]string)
+			for _, name := range []string{"old", "new", "unused"} {
+				paths[name] = cacheChart(t, c, "https://charts.example.com/", chart.RemoteReference{Name: name, Version: "1.0.0"})
+			}
+			// The same chart pulled with credentials is referenced by the lockfile as well
+			paths["new with credentials"] = cacheChart(t, c, RepositoryKey("https://charts.example.com/", "0123456789abcdef"), chart.RemoteReference{Name: "new", Version: "1.0.0"})
+
+			old, err := readMetadata(paths["old"])
+			g.Expect(err).ToNot(HaveOccurred())
+			old.Created = old.Created.Add(-48 * time.Hour)
+			g.Expect(writeMetadata(old)).To(Succeed())
+
+			pruned, err := Prune(dir, tt.opts)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			var names []string
+			for _, entry := range pruned {
+				names = append(names, entry.Chart)
+			}
+			g.Expect(names).To(ConsistOf(tt.expectPruned))
+
+			for name, path := range paths {
+				if !tt.opts.DryRun && slices.Contains(tt.expectPruned, name) {
+					g.Expect(path).ToNot(BeAnExistingFile())
+				} else {
+					g.Expect(path).To(BeAnExistingFile())
+				}
+			}
+		})
+	}
+}
+
+func TestLoadLockfile(t *testing.T) {
+	g := NewWithT(t)
+	path := filepath.Join(t.TempDir(), "charts.lock")
+
+	g.Expect(os.WriteFile(path, []byte(`charts:
+- repository: https://charts.example.com
+  chart: podinfo
+  version: 6.0.0
+`), 0644)).To(Succeed())
+
+	entries, err := LoadLockfile(path)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(entries).To(Equal([]LockEntry{{Repository: "https://charts.example.com", Chart: "podinfo", Version: "6.0.0"}}))
+
+	g.Expect(os.WriteFile(path, []byte(`charts:
+- repo: https://charts.example.com
+`), 0644)).To(Succeed())
+
+	_, err = LoadLockfile(path)
+	g.Expect(err).To(HaveOccurred())
+}
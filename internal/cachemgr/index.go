@@ -0,0 +1,139 @@
+package cachemgr
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/doodlescheduling/flux-build/internal/helm/chart"
+)
+
+// metadataSuffix is appended to the file name of a cached chart for its entry of the on-disk index.
+const metadataSuffix = ".json"
+
+// Entry is a chart of the filesystem cache.
+type Entry struct {
+	// Path is the path of the chart archive.
+	Path       string `json:"-"`
+	Repository string `json:"repository"`
+	// Credentials identifies the credentials the chart was pulled with, it is empty for anonymous repositories.
+	Credentials string `json:"credentials,omitempty"`
+	Chart       string `json:"chart"`
+	Version     string `json:"version"`
+	// Digest is the sha256 digest of the chart archive when it was cached.
+	Digest   string    `json:"digest"`
+	Size     int64     `json:"size"`
+	Created  time.Time `json:"created"`
+	LastUsed time.Time `json:"lastUsed"`
+	// Indexed is false for entries without metadata, for instance cached by an older version.
+	Indexed bool `json:"-"`
+	// Status is set by Verify and Repair.
+	Status EntryStatus `json:"-"`
+}
+
+// fsLock is the key to unlock a chart of the filesystem cache.
+type fsLock struct {
+	file *os.File
+	path string
+	repo string
+	ref  chart.RemoteReference
+}
+
+// index writes the metadata of a newly cached chart, repo is the repository key.
+func index(path, repo string, ref chart.RemoteReference) error {
+	digest, size, err := digestFile(path)
+	if err != nil {
+		return err
+	}
+
+	url, credentials := splitRepositoryKey(repo)
+	now := time.Now().UTC()
+	return writeMetadata(Entry{
+		Path:        path,
+		Repository:  url,
+		Credentials: credentials,
+		Chart:       ref.Name,
+		Version:     ref.Version,
+		Digest:      digest,
+		Size:        size,
+		Created:     now,
+		LastUsed:    now,
+	})
+}
+
+// touch updates the last usage of a cached chart, charts without metadata are skipped.
+func touch(path string) error {
+	entry, err := readMetadata(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	entry.LastUsed = time.Now().UTC()
+	return writeMetadata(entry)
+}
+
+func readMetadata(path string) (Entry, error) {
+	entry := Entry{Path: path}
+	b, err := os.ReadFile(path + metadataSuffix)
+	if err != nil {
+		return entry, err
+	}
+
+	if err := json.Unmarshal(b, &entry); err != nil {
+		return entry, err
+	}
+
+	entry.Indexed = true
+	return entry, nil
+}
+
+// writeMetadata replaces the metadata atomically as it may be read by concurrent builds.
+func writeMetadata(entry Entry) error {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(entry.Path), filepath.Base(entry.Path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(b); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+
+	return os.Rename(f.Name(), entry.Path+metadataSuffix)
+}
+
+// digestFile returns the sha256 digest and the size of a file.
+func digestFile(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), size, nil
+}
@@ -107,3 +107,45 @@ func (c *Cache) SetUnlock(file *os.File) error {
 	}
 	return nil
 }
+
+// Unlock releases the lock without marking the data ready, the next caller of GetOrLock takes the lock.
+func (c *Cache) Unlock(file *os.File) error {
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("Can't close lock file: %v", err)
+	}
+	return nil
+}
+
+// Ready returns true if the data file is complete.
+func (c *Cache) Ready(filename string) bool {
+	f, err := os.Open(c.Filename(filename) + lockSuffix)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	b, err := isReady(f)
+	return err == nil && b
+}
+
+// Remove removes the data file, the given related files and the lock while holding the lock.
+func (c *Cache) Remove(filename string, related ...string) error {
+	lockname := c.Filename(filename) + lockSuffix
+	f, err := os.OpenFile(lockname, os.O_CREATE|os.O_RDWR, 0664)
+	if err != nil {
+		return fmt.Errorf("Can't open lock file %s: %v", lockname, err)
+	}
+	defer f.Close()
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("Can't lock file %s: %v", lockname, err)
+	}
+
+	for _, name := range append([]string{filename}, related...) {
+		if err := os.Remove(c.Filename(name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return os.Remove(lockname)
+}
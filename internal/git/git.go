@@ -0,0 +1,301 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// DefaultBranch is checked out if no reference is given, like the GitRepository API does.
+const DefaultBranch = "master"
+
+// AuthOptions holds the credentials of a git repository.
+// The fields correspond to the keys of a GitRepository secretRef.
+type AuthOptions struct {
+	// Username and Password are used for HTTP basic auth.
+	// For SSH the Password is the passphrase of the Identity.
+	Username string
+	Password string
+	// BearerToken is used for HTTP bearer token auth.
+	BearerToken string
+	// Identity is the private SSH key.
+	Identity []byte
+	// KnownHosts is required for SSH, the host key is verified against it.
+	KnownHosts []byte
+	// CAFile is the CA certificate used to verify HTTPS servers.
+	CAFile []byte
+}
+
+// Reference is the reference to check out, the precedence is Commit, Name, Tag and Branch.
+// A Commit is fetched from the Branch if it can't be fetched directly.
+type Reference struct {
+	Branch string
+	Tag    string
+	// Name is a full git reference (for instance refs/pull/1/head).
+	Name   string
+	Commit string
+}
+
+// Options configure the access to a git repository.
+type Options struct {
+	Auth *AuthOptions
+	// Proxy is used for HTTP(S) repositories, the environment is used if nil.
+	Proxy *url.URL
+}
+
+// Checkout makes a shallow clone of the reference into dir and returns the checked out commit.
+func Checkout(ctx context.Context, repositoryURL, dir string, reference Reference, opts Options) (string, error) {
+	env, cleanup, err := opts.env(repositoryURL)
+	if err != nil {
+		return "", err
+	}
+	defer cleanup()
+
+	git := func(args ...string) ([]byte, error) {
+		return run(ctx, dir, env, args...)
+	}
+
+	if _, err := git("init", "--quiet"); err != nil {
+		return "", err
+	}
+
+	if _, err := git("remote", "add", "origin", repositoryURL); err != nil {
+		return "", err
+	}
+
+	switch {
+	case reference.Commit != "":
+		// Servers which do not allow fetching unadvertised objects require the history of the branch
+		if _, err := git("fetch", "--quiet", "--depth", "1", "origin", reference.Commit); err != nil {
+			branch := reference.Branch
+			if branch == "" {
+				branch = DefaultBranch
+			}
+
+			if _, err := git("fetch", "--quiet", "origin", "refs/heads/"+branch); err != nil {
+				return "", err
+			}
+		}
+
+		if _, err := git("checkout", "--quiet", reference.Commit); err != nil {
+			return "", err
+		}
+	case reference.Name != "" || reference.Tag != "":
+		name := reference.Name
+		if name == "" {
+			name = "refs/tags/" + reference.Tag
+		}
+
+		if _, err := git("fetch", "--quiet", "--depth", "1", "origin", name); err != nil {
+			return "", err
+		}
+
+		if _, err := git("checkout", "--quiet", "FETCH_HEAD"); err != nil {
+			return "", err
+		}
+	default:
+		branch := reference.Branch
+		if branch == "" {
+			branch = DefaultBranch
+		}
+
+		if _, err := git("fetch", "--quiet", "--depth", "1", "origin", "refs/heads/"+branch); err != nil {
+			return "", err
+		}
+
+		if _, err := git("checkout", "--quiet", "FETCH_HEAD"); err != nil {
+			return "", err
+		}
+	}
+
+	commit, err := git("rev-parse", "HEAD")
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(commit)), nil
+}
+
+// ListTags returns the tags of the remote repository sorted by name.
+func ListTags(ctx context.Context, repositoryURL string, opts Options) ([]string, error) {
+	env, cleanup, err := opts.env(repositoryURL)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	out, err := run(ctx, "", env, "ls-remote", "--tags", "--refs", repositoryURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var tags []string
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+
+		tags = append(tags, strings.TrimPrefix(fields[1], "refs/tags/"))
+	}
+
+	sort.Strings(tags)
+	return tags, nil
+}
+
+func run(ctx context.Context, dir string, env []string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = env
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("git %s: %w", args[0], ctx.Err())
+		}
+
+		return nil, fmt.Errorf("git %s failed: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	return out, nil
+}
+
+// env returns the environment of the git commands. Credentials are passed as configuration through the
+// environment and written to a temporary directory which is removed by cleanup.
+func (o Options) env(repositoryURL string) ([]string, func(), error) {
+	env := append(os.Environ(),
+		"GIT_TERMINAL_PROMPT=0",
+		"GIT_CONFIG_NOSYSTEM=1",
+	)
+
+	var config []string
+	if o.Proxy != nil {
+		config = append(config, "http.proxy", o.Proxy.String())
+	}
+
+	auth := o.Auth
+	if auth == nil {
+		auth = &AuthOptions{}
+	}
+
+	switch {
+	case auth.BearerToken != "":
+		config = append(config, "http.extraHeader", "Authorization: Bearer "+auth.BearerToken)
+	case auth.Username != "" && auth.Password != "" && len(auth.Identity) == 0:
+		basic := base64.StdEncoding.EncodeToString([]byte(auth.Username + ":" + auth.Password))
+		config = append(config, "http.extraHeader", "Authorization: Basic "+basic)
+	}
+
+	dir, err := os.MkdirTemp("", "git-auth")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cleanup := func() {
+		_ = os.RemoveAll(dir)
+	}
+
+	if len(auth.CAFile) > 0 {
+		caFile := filepath.Join(dir, "ca.crt")
+		if err := os.WriteFile(caFile, auth.CAFile, 0600); err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+
+		config = append(config, "http.sslCAInfo", caFile)
+	}
+
+	if isSSH(repositoryURL) {
+		sshCommand, err := auth.sshCommand(dir)
+		if err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+
+		env = append(env, "GIT_SSH_COMMAND="+sshCommand)
+	}
+
+	env = append(env, "GIT_CONFIG_COUNT="+strconv.Itoa(len(config)/2))
+	for i := 0; i < len(config); i += 2 {
+		env = append(env,
+			fmt.Sprintf("GIT_CONFIG_KEY_%d=%s", i/2, config[i]),
+			fmt.Sprintf("GIT_CONFIG_VALUE_%d=%s", i/2, config[i+1]),
+		)
+	}
+
+	return env, cleanup, nil
+}
+
+// sshCommand writes the identity and known hosts to dir and returns the ssh command using them.
+// Like Flux the host key is always verified.
+func (a *AuthOptions) sshCommand(dir string) (string, error) {
+	if len(a.KnownHosts) == 0 {
+		return "", errors.New("known_hosts is required for ssh repositories")
+	}
+
+	knownHosts := filepath.Join(dir, "known_hosts")
+	if err := os.WriteFile(knownHosts, a.KnownHosts, 0600); err != nil {
+		return "", err
+	}
+
+	args := []string{"ssh",
+		"-F", "/dev/null",
+		"-o", "BatchMode=yes",
+		"-o", "StrictHostKeyChecking=yes",
+		"-o", "UserKnownHostsFile=" + knownHosts,
+	}
+
+	if len(a.Identity) > 0 {
+		identity := a.Identity
+		if a.Password != "" {
+			key, err := ssh.ParseRawPrivateKeyWithPassphrase(a.Identity, []byte(a.Password))
+			if err != nil {
+				return "", fmt.Errorf("failed to decrypt ssh identity: %w", err)
+			}
+
+			block, err := ssh.MarshalPrivateKey(key, "")
+			if err != nil {
+				return "", err
+			}
+
+			identity = pem.EncodeToMemory(block)
+		}
+
+		identityFile := filepath.Join(dir, "identity")
+		if err := os.WriteFile(identityFile, identity, 0600); err != nil {
+			return "", err
+		}
+
+		args = append(args, "-o", "IdentitiesOnly=yes", "-i", identityFile)
+	}
+
+	for i, arg := range args {
+		args[i] = strconv.Quote(arg)
+	}
+
+	return strings.Join(args, " "), nil
+}
+
+// isSSH returns true for ssh:// urls and the scp-like syntax (git@github.com:org/repo).
+func isSSH(repositoryURL string) bool {
+	if strings.HasPrefix(repositoryURL, "ssh://") {
+		return true
+	}
+
+	return !strings.Contains(repositoryURL, "://") && strings.Contains(repositoryURL, "@")
+}
@@ -0,0 +1,149 @@
+package git
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+// newRepository creates a repository with a tagged commit on master per tag and a commit on the feature branch.
+// It returns the url and the commits keyed by tag and feature.
+func newRepository(t *testing.T, tags ...string) (string, map[string]string) {
+	dir := t.TempDir()
+	commits := make(map[string]string)
+
+	git := func(args ...string) string {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+		)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %s: %s: %s", strings.Join(args, " "), err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+
+	git("init", "--quiet", "--initial-branch", "master")
+	for _, tag := range tags {
+		if err := os.WriteFile(filepath.Join(dir, "version"), []byte(tag), 0600); err != nil {
+			t.Fatal(err)
+		}
+		git("add", "version")
+		git("commit", "--quiet", "-m", tag)
+		git("tag", tag)
+		commits[tag] = git("rev-parse", "HEAD")
+	}
+
+	git("checkout", "--quiet", "-b", "feature")
+	if err := os.WriteFile(filepath.Join(dir, "version"), []byte("feature"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	git("commit", "--quiet", "-am", "feature")
+	commits["feature"] = git("rev-parse", "HEAD")
+	git("checkout", "--quiet", "master")
+
+	return "file://" + dir, commits
+}
+
+func TestCheckout(t *testing.T) {
+	url, commits := newRepository(t, "v0.1.0", "v0.2.0", "v1.0.0")
+
+	tests := []struct {
+		name          string
+		reference     Reference
+		expectVersion string
+		expectCommit  string
+		expectErr     string
+	}{
+		{
+			name:          "default branch",
+			expectVersion: "v1.0.0",
+			expectCommit:  commits["v1.0.0"],
+		},
+		{
+			name:          "branch",
+			reference:     Reference{Branch: "feature"},
+			expectVersion: "feature",
+			expectCommit:  commits["feature"],
+		},
+		{
+			name:          "tag",
+			reference:     Reference{Tag: "v0.1.0", Branch: "feature"},
+			expectVersion: "v0.1.0",
+			expectCommit:  commits["v0.1.0"],
+		},
+		{
+			name:          "commit",
+			reference:     Reference{Commit: commits["v0.2.0"]},
+			expectVersion: "v0.2.0",
+			expectCommit:  commits["v0.2.0"],
+		},
+		{
+			name:          "name",
+			reference:     Reference{Name: "refs/heads/feature"},
+			expectVersion: "feature",
+			expectCommit:  commits["feature"],
+		},
+		{
+			name:      "missing branch",
+			reference: Reference{Branch: "does-not-exist"},
+			expectErr: "git fetch failed",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			dir := t.TempDir()
+
+			commit, err := Checkout(context.Background(), url, dir, tt.reference, Options{})
+			if tt.expectErr != "" {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tt.expectErr))
+				return
+			}
+
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(commit).To(Equal(tt.expectCommit))
+
+			version, err := os.ReadFile(filepath.Join(dir, "version"))
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(string(version)).To(Equal(tt.expectVersion))
+		})
+	}
+}
+
+func TestListTags(t *testing.T) {
+	g := NewWithT(t)
+	url, _ := newRepository(t, "v1.0.0", "v0.1.0", "latest")
+
+	tags, err := ListTags(context.Background(), url, Options{})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(tags).To(Equal([]string{"latest", "v0.1.0", "v1.0.0"}))
+}
+
+func TestSSHRequiresKnownHosts(t *testing.T) {
+	g := NewWithT(t)
+
+	_, err := ListTags(context.Background(), "ssh://git@example.invalid/org/repo", Options{
+		Auth: &AuthOptions{Identity: []byte("key")},
+	})
+	g.Expect(err).To(MatchError("known_hosts is required for ssh repositories"))
+}
+
+func TestIsSSH(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect(isSSH("ssh://git@github.com/org/repo")).To(BeTrue())
+	g.Expect(isSSH("git@github.com:org/repo.git")).To(BeTrue())
+	g.Expect(isSSH("https://github.com/org/repo")).To(BeFalse())
+	g.Expect(isSSH("https://user@github.com/org/repo")).To(BeFalse())
+	g.Expect(isSSH("file:///tmp/repo")).To(BeFalse())
+}
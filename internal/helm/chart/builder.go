@@ -18,6 +18,7 @@ package chart
 
 import (
 	"context"
+	"crypto/sha256"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -111,6 +112,11 @@ type BuildOptions struct {
 	// ValuesFiles can be set to a list of relative paths, used to compose
 	// and overwrite an alternative default "values.yaml" for the chart.
 	ValuesFiles []string
+	// ChartRelativeValuesFiles can be set to resolve the ValuesFiles of a
+	// LocalReference relative to the chart directory before the WorkDir.
+	// They may reference files outside the chart directory, but never
+	// outside the WorkDir.
+	ChartRelativeValuesFiles bool
 	// CachedChart can be set to the absolute path of a chart stored on
 	// the local filesystem, and is used for simple validation by metadata
 	// comparisons.
@@ -151,6 +157,15 @@ type Build struct {
 	// This can for example be false if ValuesFiles is empty and the chart
 	// source was already packaged.
 	Packaged bool
+	// Digest is the sha256 digest of the chart archive as pulled from the
+	// repository, for OCI repositories it is the digest of the chart layer.
+	// It is empty if the chart was packaged by the Builder.
+	Digest string
+}
+
+// ArchiveDigest returns the sha256 digest of a chart archive.
+func ArchiveDigest(b []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(b))
 }
 
 // Summary returns a human-readable summary of the Build.
@@ -18,8 +18,10 @@ package chart
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/Masterminds/semver/v3"
@@ -142,7 +144,11 @@ func (b *localChartBuilder) Build(ctx context.Context, ref Reference, p string,
 	// Merge chart values, if instructed
 	var mergedValues map[string]interface{}
 	if len(opts.GetValuesFiles()) > 0 {
-		if mergedValues, err = mergeFileValues(localRef.WorkDir, opts.ValuesFiles); err != nil {
+		var chartDir string
+		if isChartDir && opts.ChartRelativeValuesFiles {
+			chartDir = localRef.Path
+		}
+		if mergedValues, err = mergeFileValues(localRef.WorkDir, chartDir, opts.ValuesFiles); err != nil {
 			return result, &BuildError{Reason: ErrValuesFilesMerge, Err: err}
 		}
 	}
@@ -186,23 +192,58 @@ func (b *localChartBuilder) Build(ctx context.Context, ref Reference, p string,
 	return result, nil
 }
 
+// ErrValuesFileNotFound is returned by ResolveValuesFile if the values file
+// does not exist.
+var ErrValuesFileNotFound = errors.New("no values file found")
+
+// ResolveValuesFile returns the absolute path of the values file p within
+// workDir. If chartDir is not empty, p is resolved relative to the chart
+// directory within workDir first and relative to workDir if it does not exist
+// there. The values file may be outside the chart directory, but resolving to
+// a path outside workDir is an error. Symlinks never escape workDir.
+func ResolveValuesFile(workDir, chartDir, p string) (string, error) {
+	workDir, err := filepath.Abs(workDir)
+	if err != nil {
+		return "", err
+	}
+
+	var candidates []string
+	if chartDir != "" {
+		candidates = append(candidates, filepath.Join(workDir, chartDir, p))
+	}
+	candidates = append(candidates, filepath.Join(workDir, p))
+
+	for _, candidate := range candidates {
+		rel, err := filepath.Rel(workDir, candidate)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return "", fmt.Errorf("values file '%s' resolves to '%s' outside of the source root '%s'", p, candidate, workDir)
+		}
+
+		secureP, err := securejoin.SecureJoin(workDir, rel)
+		if err != nil {
+			return "", err
+		}
+		if f, err := os.Stat(secureP); err == nil && f.Mode().IsRegular() {
+			return secureP, nil
+		}
+	}
+
+	return "", fmt.Errorf("%w at path '%s' (reference '%s')", ErrValuesFileNotFound, strings.Join(candidates, "' or '"), p)
+}
+
 // mergeFileValues merges the given value file paths into a single "values.yaml" map.
-// The provided (relative) paths may not traverse outside baseDir. It returns the merge
-// result, or an error.
-func mergeFileValues(baseDir string, paths []string) (map[string]interface{}, error) {
+// The provided (relative) paths are resolved by ResolveValuesFile and may not traverse
+// outside baseDir. It returns the merge result, or an error.
+func mergeFileValues(baseDir, chartDir string, paths []string) (map[string]interface{}, error) {
 	mergedValues := make(map[string]interface{})
 	for _, p := range paths {
-		secureP, err := securejoin.SecureJoin(baseDir, p)
+		secureP, err := ResolveValuesFile(baseDir, chartDir, p)
 		if err != nil {
 			return nil, err
 		}
-		if f, err := os.Stat(secureP); err != nil || !f.Mode().IsRegular() {
-			return nil, fmt.Errorf("no values file found at path '%s' (reference '%s')",
-				strings.TrimPrefix(secureP, baseDir), p)
-		}
 		b, err := os.ReadFile(secureP)
 		if err != nil {
-			return nil, fmt.Errorf("could not read values from file '%s': %w", p, err)
+			return nil, fmt.Errorf("could not read values from file '%s': %w", secureP, err)
 		}
 		values := make(map[string]interface{})
 		err = yaml.Unmarshal(b, &values)
@@ -20,6 +20,7 @@ import (
 	"context"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 
@@ -281,11 +282,12 @@ func TestLocalBuilder_Build_CachedChart(t *testing.T) {
 
 func Test_mergeFileValues(t *testing.T) {
 	tests := []struct {
-		name    string
-		files   []*helmchart.File
-		paths   []string
-		want    map[string]interface{}
-		wantErr string
+		name     string
+		files    []*helmchart.File
+		chartDir string
+		paths    []string
+		want     map[string]interface{}
+		wantErr  string
 	}{
 		{
 			name: "merges values from files",
@@ -300,10 +302,43 @@ func Test_mergeFileValues(t *testing.T) {
 				"b": "d",
 			},
 		},
+		{
+			name: "relative to the chart directory",
+			files: []*helmchart.File{
+				{Name: "chart/values.yaml", Data: []byte("a: b")},
+				{Name: "chart/values-prod.yaml", Data: []byte("b: c")},
+				{Name: "values-prod.yaml", Data: []byte("b: d")},
+			},
+			chartDir: "chart",
+			paths:    []string{"values.yaml", "values-prod.yaml"},
+			want: map[string]interface{}{
+				"a": "b",
+				"b": "c",
+			},
+		},
+		{
+			name: "outside the chart directory",
+			files: []*helmchart.File{
+				{Name: "common/values.yaml", Data: []byte("a: b")},
+				{Name: "values/prod.yaml", Data: []byte("b: c")},
+			},
+			chartDir: "charts/app",
+			paths:    []string{"../../common/values.yaml", "values/prod.yaml"},
+			want: map[string]interface{}{
+				"a": "b",
+				"b": "c",
+			},
+		},
 		{
 			name:    "illegal traverse",
 			paths:   []string{"../../../traversing/illegally/a/p/a/b"},
-			wantErr: "no values file found at path '/traversing/illegally/a/p/a/b'",
+			wantErr: "values file '../../../traversing/illegally/a/p/a/b' resolves to '",
+		},
+		{
+			name:     "illegal traverse from the chart directory",
+			chartDir: "chart",
+			paths:    []string{"../../values.yaml"},
+			wantErr:  "outside of the source root",
 		},
 		{
 			name: "unmarshal error",
@@ -316,7 +351,13 @@ func Test_mergeFileValues(t *testing.T) {
 		{
 			name:    "error on invalid path",
 			paths:   []string{"a.yaml"},
-			wantErr: "no values file found at path '/a.yaml'",
+			wantErr: "no values file found at path '<base>/a.yaml' (reference 'a.yaml')",
+		},
+		{
+			name:     "error on invalid path relative to the chart directory",
+			chartDir: "chart",
+			paths:    []string{"a.yaml"},
+			wantErr:  "no values file found at path '<base>/chart/a.yaml' or '<base>/a.yaml' (reference 'a.yaml')",
 		},
 	}
 	for _, tt := range tests {
@@ -326,13 +367,14 @@ func Test_mergeFileValues(t *testing.T) {
 			baseDir := t.TempDir()
 
 			for _, f := range tt.files {
+				g.Expect(os.MkdirAll(filepath.Dir(filepath.Join(baseDir, f.Name)), 0o750)).To(Succeed())
 				g.Expect(os.WriteFile(filepath.Join(baseDir, f.Name), f.Data, 0o640)).To(Succeed())
 			}
 
-			got, err := mergeFileValues(baseDir, tt.paths)
+			got, err := mergeFileValues(baseDir, tt.chartDir, tt.paths)
 			if tt.wantErr != "" {
 				g.Expect(err).To(HaveOccurred())
-				g.Expect(err.Error()).To(ContainSubstring(tt.wantErr))
+				g.Expect(err.Error()).To(ContainSubstring(strings.ReplaceAll(tt.wantErr, "<base>", baseDir)))
 				g.Expect(got).To(BeNil())
 				return
 			}
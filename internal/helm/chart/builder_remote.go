@@ -87,6 +87,7 @@ func (b *remoteChartBuilder) Build(ctx context.Context, ref Reference, p string,
 	// Use literal chart copy from remote if no custom values files options are
 	// set or version metadata isn't set.
 	if !requiresPackaging {
+		result.Digest = ArchiveDigest(res.Bytes())
 		if err = validatePackageAndWriteToPath(res, p); err != nil {
 			return nil, &BuildError{Reason: ErrChartPull, Err: err}
 		}
@@ -198,6 +199,11 @@ func generateBuildResult(cv *repo.ChartVersion, opts BuildOptions) (*Build, bool
 					result.Path = opts.CachedChart
 					result.ValuesFiles = opts.GetValuesFiles()
 					result.Packaged = requiresPackaging
+					if !requiresPackaging {
+						if b, err := os.ReadFile(opts.CachedChart); err == nil {
+							result.Digest = ArchiveDigest(b)
+						}
+					}
 					return result, true, nil
 				}
 			}
@@ -219,6 +225,12 @@ func setBuildMetaData(version, versionMetadata string) (*semver.Version, error)
 	return ver, nil
 }
 
+// MergeChartValues merges the values files at the given paths of the chart in the declared order.
+// Later files take precedence, "values.yaml" refers to the default values of the chart.
+func MergeChartValues(chart *helmchart.Chart, paths []string) (map[string]interface{}, error) {
+	return mergeChartValues(chart, paths)
+}
+
 // mergeChartValues merges the given chart.Chart Files paths into a single "values.yaml" map.
 // It returns the merge result, or an error.
 func mergeChartValues(chart *helmchart.Chart, paths []string) (map[string]interface{}, error) {
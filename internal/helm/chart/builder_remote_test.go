@@ -211,6 +211,13 @@ entries:
 			g.Expect(err).ToNot(HaveOccurred())
 			g.Expect(cb.Packaged).To(Equal(tt.wantPackaged), "unexpected Build.Packaged value")
 			g.Expect(cb.Path).ToNot(BeEmpty(), "empty Build.Path")
+			if tt.wantPackaged {
+				g.Expect(cb.Digest).To(BeEmpty())
+			} else {
+				archive, err := os.ReadFile(cb.Path)
+				g.Expect(err).ToNot(HaveOccurred())
+				g.Expect(cb.Digest).To(Equal(ArchiveDigest(archive)))
+			}
 
 			// Load the resulting chart and verify the values.
 			resultChart, err := secureloader.LoadFile(cb.Path)
@@ -364,6 +371,13 @@ func TestRemoteBuilder_BuildFromOCIChartRepository(t *testing.T) {
 			g.Expect(err).ToNot(HaveOccurred())
 			g.Expect(cb.Packaged).To(Equal(tt.wantPackaged), "unexpected Build.Packaged value")
 			g.Expect(cb.Path).ToNot(BeEmpty(), "empty Build.Path")
+			if tt.wantPackaged {
+				g.Expect(cb.Digest).To(BeEmpty())
+			} else {
+				archive, err := os.ReadFile(cb.Path)
+				g.Expect(err).ToNot(HaveOccurred())
+				g.Expect(cb.Digest).To(Equal(ArchiveDigest(archive)))
+			}
 
 			// Load the resulting chart and verify the values.
 			resultChart, err := secureloader.LoadFile(cb.Path)
@@ -433,12 +447,14 @@ entries:
 	cb, err = b.Build(context.TODO(), reference, targetPath2, buildOpts)
 	g.Expect(err).ToNot(HaveOccurred())
 	g.Expect(cb.Path).To(Equal(targetPath))
+	g.Expect(cb.Digest).To(Equal(ArchiveDigest(chartGrafana)))
 
 	// Rebuild with build option Force.
 	buildOpts.Force = true
 	cb, err = b.Build(context.TODO(), reference, targetPath2, buildOpts)
 	g.Expect(err).ToNot(HaveOccurred())
 	g.Expect(cb.Path).To(Equal(targetPath2))
+	g.Expect(cb.Digest).To(Equal(ArchiveDigest(chartGrafana)))
 }
 
 func Test_mergeChartValues(t *testing.T) {
@@ -0,0 +1,229 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package coalesce replays the coalescing of chart values of helm.sh/helm/v3/pkg/chartutil to collect its warnings.
+// Helm writes them to the standard logger of the process which can't attribute them to the release rendered
+// concurrently.
+package coalesce
+
+import (
+	"fmt"
+	"sort"
+
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+)
+
+// Warnings returns the warnings chartutil.CoalesceValues logs while coalescing vals with the values of the chart and
+// its subcharts, in the order they are logged and without duplicates. Neither vals nor the chart are modified.
+func Warnings(chrt *chart.Chart, vals map[string]interface{}) ([]string, error) {
+	w := &warnings{seen: make(map[string]bool)}
+	dest, _ := copyValue(vals).(map[string]interface{})
+	if dest == nil {
+		dest = make(map[string]interface{})
+	}
+
+	_, err := w.coalesce(chrt, dest, "", false)
+	return w.list, err
+}
+
+type warnings struct {
+	list []string
+	seen map[string]bool
+}
+
+func (w *warnings) printf(format string, v ...interface{}) {
+	msg := fmt.Sprintf(format, v...)
+	if !w.seen[msg] {
+		w.seen[msg] = true
+		w.list = append(w.list, msg)
+	}
+}
+
+func (w *warnings) coalesce(ch *chart.Chart, dest map[string]interface{}, prefix string, merge bool) (map[string]interface{}, error) {
+	w.coalesceValues(ch, dest, prefix, merge)
+	return w.coalesceDeps(ch, dest, prefix, merge)
+}
+
+func (w *warnings) coalesceDeps(chrt *chart.Chart, dest map[string]interface{}, prefix string, merge bool) (map[string]interface{}, error) {
+	for _, subchart := range chrt.Dependencies() {
+		if c, ok := dest[subchart.Name()]; !ok {
+			dest[subchart.Name()] = make(map[string]interface{})
+		} else if !istable(c) {
+			return dest, fmt.Errorf("type mismatch on %s: %t", subchart.Name(), c)
+		}
+		if dv, ok := dest[subchart.Name()]; ok {
+			dvmap := dv.(map[string]interface{})
+			subPrefix := concatPrefix(prefix, chrt.Metadata.Name)
+			w.coalesceGlobals(dvmap, dest, subPrefix)
+
+			var err error
+			dest[subchart.Name()], err = w.coalesce(subchart, dvmap, subPrefix, merge)
+			if err != nil {
+				return dest, err
+			}
+		}
+	}
+	return dest, nil
+}
+
+func (w *warnings) coalesceGlobals(dest, src map[string]interface{}, prefix string) {
+	var dg, sg map[string]interface{}
+
+	if destglob, ok := dest[chartutil.GlobalKey]; !ok {
+		dg = make(map[string]interface{})
+	} else if dg, ok = destglob.(map[string]interface{}); !ok {
+		w.printf("warning: skipping globals because destination %s is not a table.", chartutil.GlobalKey)
+		return
+	}
+
+	if srcglob, ok := src[chartutil.GlobalKey]; !ok {
+		sg = make(map[string]interface{})
+	} else if sg, ok = srcglob.(map[string]interface{}); !ok {
+		w.printf("warning: skipping globals because source %s is not a table.", chartutil.GlobalKey)
+		return
+	}
+
+	for _, key := range sortedKeys(sg) {
+		val := sg[key]
+		if istable(val) {
+			vv := copyMap(val.(map[string]interface{}))
+			if destv, ok := dg[key]; !ok {
+				dg[key] = vv
+			} else {
+				if destvmap, ok := destv.(map[string]interface{}); !ok {
+					w.printf("Conflict: cannot merge map onto non-map for %q. Skipping.", key)
+				} else {
+					w.coalesceTablesFullKey(vv, destvmap, concatPrefix(prefix, key), true)
+					dg[key] = vv
+				}
+			}
+		} else if dv, ok := dg[key]; ok && istable(dv) {
+			w.printf("key %s is table. Skipping", key)
+		} else {
+			dg[key] = val
+		}
+	}
+	dest[chartutil.GlobalKey] = dg
+}
+
+func (w *warnings) coalesceValues(c *chart.Chart, v map[string]interface{}, prefix string, merge bool) {
+	subPrefix := concatPrefix(prefix, c.Metadata.Name)
+	vc, _ := copyValue(map[string]interface{}(c.Values)).(map[string]interface{})
+
+	for _, key := range sortedKeys(vc) {
+		val := vc[key]
+		if value, ok := v[key]; ok {
+			if value == nil && !merge {
+				delete(v, key)
+			} else if dest, ok := value.(map[string]interface{}); ok {
+				src, ok := val.(map[string]interface{})
+				if !ok {
+					if val != nil {
+						w.printf("warning: skipped value for %s.%s: Not a table.", subPrefix, key)
+					}
+				} else {
+					w.coalesceTablesFullKey(dest, src, concatPrefix(subPrefix, key), merge)
+				}
+			}
+		} else {
+			v[key] = val
+		}
+	}
+}
+
+func (w *warnings) coalesceTablesFullKey(dst, src map[string]interface{}, prefix string, merge bool) map[string]interface{} {
+	if src == nil {
+		return dst
+	}
+	if dst == nil {
+		return src
+	}
+	for _, key := range sortedKeys(src) {
+		val := src[key]
+		fullkey := concatPrefix(prefix, key)
+		if dv, ok := dst[key]; ok && !merge && dv == nil {
+			delete(dst, key)
+		} else if !ok {
+			dst[key] = val
+		} else if istable(val) {
+			if istable(dv) {
+				w.coalesceTablesFullKey(dv.(map[string]interface{}), val.(map[string]interface{}), fullkey, merge)
+			} else {
+				w.printf("warning: cannot overwrite table with non table for %s (%v)", fullkey, val)
+			}
+		} else if istable(dv) && val != nil {
+			w.printf("warning: destination for %s is a table. Ignoring non-table value (%v)", fullkey, val)
+		}
+	}
+	return dst
+}
+
+func concatPrefix(a, b string) string {
+	if a == "" {
+		return b
+	}
+	return fmt.Sprintf("%s.%s", a, b)
+}
+
+func istable(v interface{}) bool {
+	_, ok := v.(map[string]interface{})
+	return ok
+}
+
+func copyMap(src map[string]interface{}) map[string]interface{} {
+	m := make(map[string]interface{}, len(src))
+	for k, v := range src {
+		m[k] = v
+	}
+	return m
+}
+
+// copyValue deep copies maps and lists keeping their types like the copystructure package used by Helm.
+func copyValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case chartutil.Values:
+		m, _ := copyValue(map[string]interface{}(v)).(map[string]interface{})
+		return chartutil.Values(m)
+	case map[string]interface{}:
+		if v == nil {
+			return map[string]interface{}(nil)
+		}
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[key] = copyValue(value)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, value := range v {
+			l[i] = copyValue(value)
+		}
+		return l
+	default:
+		return v
+	}
+}
+
+// sortedKeys makes the order of the warnings deterministic, Helm ranges over the maps.
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
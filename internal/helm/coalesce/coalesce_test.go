@@ -0,0 +1,114 @@
+package coalesce
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"sigs.k8s.io/yaml"
+)
+
+func newChart(t *testing.T, name, values string, dependencies ...*chart.Chart) *chart.Chart {
+	c := &chart.Chart{Metadata: &chart.Metadata{Name: name, Version: "0.1.0", APIVersion: chart.APIVersionV2}}
+	if err := yaml.Unmarshal([]byte(values), &c.Values); err != nil {
+		t.Fatal(err)
+	}
+	c.SetDependencies(dependencies...)
+	return c
+}
+
+func TestWarnings(t *testing.T) {
+	tests := []struct {
+		name           string
+		chart          *chart.Chart
+		values         string
+		expectWarnings []string
+		expectErr      bool
+	}{
+		{
+			name:   "no warnings",
+			chart:  newChart(t, "app", "image:\n  tag: latest\nreplicas: 1"),
+			values: "image:\n  tag: v1\nreplicas: null",
+		},
+		{
+			name:   "table overwritten by a scalar",
+			chart:  newChart(t, "app", "image:\n  tag: latest\nresources:\n  limits:\n    cpu: 1"),
+			values: "image: nginx\nresources:\n  limits: none",
+			expectWarnings: []string{
+				"warning: cannot overwrite table with non table for app.resources.limits (map[cpu:1])",
+			},
+		},
+		{
+			name:   "scalar overwritten by a table",
+			chart:  newChart(t, "app", "image: nginx"),
+			values: "image:\n  tag: v1",
+			expectWarnings: []string{
+				"warning: skipped value for app.image: Not a table.",
+			},
+		},
+		{
+			name:   "subcharts and globals",
+			chart:  newChart(t, "app", "global:\n  domain: example.com", newChart(t, "sub", "ingress:\n  hosts:\n    - a")),
+			values: "global: example.com\nsub:\n  ingress:\n    hosts: b",
+			expectWarnings: []string{
+				"warning: skipping globals because source global is not a table.",
+			},
+		},
+		{
+			name:      "subchart values which are no table",
+			chart:     newChart(t, "app", "", newChart(t, "sub", "replicas: 1")),
+			values:    "sub: disabled",
+			expectErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			values := chartutil.Values{}
+			g.Expect(yaml.Unmarshal([]byte(tt.values), &values)).To(Succeed())
+			original := values.AsMap()
+
+			warnings, err := Warnings(tt.chart, values)
+			if tt.expectErr {
+				g.Expect(err).To(HaveOccurred())
+				return
+			}
+
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(warnings).To(Equal(tt.expectWarnings))
+			g.Expect(values.AsMap()).To(Equal(original))
+		})
+	}
+}
+
+// TestWarningsMatchHelm compares the warnings with the log of chartutil.CoalesceValues, the test must not run in
+// parallel with others using the standard logger.
+func TestWarningsMatchHelm(t *testing.T) {
+	g := NewWithT(t)
+
+	c := newChart(t, "app", "image:\n  tag: latest\nresources:\n  limits:\n    cpu: 1")
+	values := chartutil.Values{"resources": map[string]interface{}{"limits": "none"}}
+
+	var buf bytes.Buffer
+	writer, flags, prefix := log.Writer(), log.Flags(), log.Prefix()
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	log.SetPrefix("")
+	defer func() {
+		log.SetOutput(writer)
+		log.SetFlags(flags)
+		log.SetPrefix(prefix)
+	}()
+
+	_, err := chartutil.CoalesceValues(c, values)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	warnings, err := Warnings(c, values)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(warnings).To(Equal(strings.Split(strings.TrimSpace(buf.String()), "\n")))
+}
@@ -103,3 +103,86 @@ func TLSClientConfigFromSecret(secret corev1.Secret, repositoryUrl string) (*tls
 
 	return tlsConf, nil
 }
+
+// TLSClientConfigFromCertSecret constructs a TLS client config for the given v1.Secret
+// referenced by spec.certSecretRef. The secret may hold a CA certificate (`ca.crt`)
+// and/or a client key pair (`tls.crt` and `tls.key`).
+//
+// In contrast to TLSClientConfigFromSecret a secret without any of these keys
+// results in an error as it was explicitly referenced for its TLS material.
+// The keys are read from stringData as well, which takes precedence over data.
+func TLSClientConfigFromCertSecret(secret corev1.Secret, repositoryUrl string) (*tls.Config, error) {
+	certBytes, keyBytes, caBytes := secretValue(secret, "tls.crt"), secretValue(secret, "tls.key"), secretValue(secret, "ca.crt")
+	switch {
+	case len(certBytes)+len(keyBytes)+len(caBytes) == 0:
+		return nil, fmt.Errorf("invalid '%s' certSecretRef data: requires 'ca.crt' and/or 'tls.crt' with 'tls.key'", secret.Name)
+	case (len(certBytes) > 0 && len(keyBytes) == 0) || (len(keyBytes) > 0 && len(certBytes) == 0):
+		return nil, fmt.Errorf("invalid '%s' certSecretRef data: fields 'tls.crt' and 'tls.key' require each other's presence",
+			secret.Name)
+	}
+
+	tlsConf := &tls.Config{}
+	if len(certBytes) > 0 && len(keyBytes) > 0 {
+		cert, err := tls.X509KeyPair(certBytes, keyBytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid '%s' certSecretRef data: failed to parse 'tls.crt' and 'tls.key': %w", secret.Name, err)
+		}
+		tlsConf.Certificates = append(tlsConf.Certificates, cert)
+	}
+
+	if len(caBytes) > 0 {
+		cp, err := x509.SystemCertPool()
+		if err != nil {
+			return nil, fmt.Errorf("cannot retrieve system certificate pool: %w", err)
+		}
+		if !cp.AppendCertsFromPEM(caBytes) {
+			return nil, fmt.Errorf("invalid '%s' certSecretRef data: cannot append 'ca.crt' into certificate pool", secret.Name)
+		}
+
+		tlsConf.RootCAs = cp
+	}
+
+	u, err := url.Parse(repositoryUrl)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse repository URL: %w", err)
+	}
+
+	tlsConf.ServerName = u.Hostname()
+
+	return tlsConf, nil
+}
+
+// MergeTLSClientConfig merges two TLS client configs, the client certificates and
+// root CAs of override take precedence over the ones of base if set.
+// Either of the configs may be nil.
+func MergeTLSClientConfig(base, override *tls.Config) *tls.Config {
+	switch {
+	case base == nil:
+		return override
+	case override == nil:
+		return base
+	}
+
+	merged := base.Clone()
+	if len(override.Certificates) > 0 {
+		merged.Certificates = override.Certificates
+	}
+	if override.RootCAs != nil {
+		merged.RootCAs = override.RootCAs
+	}
+	if override.ServerName != "" {
+		merged.ServerName = override.ServerName
+	}
+
+	return merged
+}
+
+// secretValue returns the value of key of the secret. The manifests are not applied to an API server which would merge
+// stringData into data, stringData takes precedence the same way.
+func secretValue(secret corev1.Secret, key string) []byte {
+	if val, ok := secret.StringData[key]; ok {
+		return []byte(val)
+	}
+
+	return secret.Data[key]
+}
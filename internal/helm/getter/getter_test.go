@@ -19,6 +19,7 @@ package getter
 import (
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/tls"
 	"crypto/x509"
 	"encoding/pem"
 	"math/big"
@@ -133,6 +134,68 @@ func TestTLSClientConfigFromSecret(t *testing.T) {
 	}
 }
 
+func TestTLSClientConfigFromCertSecret(t *testing.T) {
+	tlsSecret := validTlsSecret(t)
+	certSecretFixture := corev1.Secret{
+		Data: map[string][]byte{
+			"tls.crt": tlsSecret.Data["certFile"],
+			"tls.key": tlsSecret.Data["keyFile"],
+			"ca.crt":  tlsSecret.Data["caFile"],
+		},
+	}
+
+	tests := []struct {
+		name    string
+		secret  corev1.Secret
+		modify  func(secret *corev1.Secret)
+		wantErr bool
+	}{
+		{"tls.crt, tls.key and ca.crt", certSecretFixture, nil, false},
+		{"without tls.crt", certSecretFixture, func(s *corev1.Secret) { delete(s.Data, "tls.crt") }, true},
+		{"without tls.key", certSecretFixture, func(s *corev1.Secret) { delete(s.Data, "tls.key") }, true},
+		{"ca.crt only", certSecretFixture, func(s *corev1.Secret) { delete(s.Data, "tls.crt"); delete(s.Data, "tls.key") }, false},
+		{"invalid ca.crt", certSecretFixture, func(s *corev1.Secret) { s.Data["ca.crt"] = []byte("invalid") }, true},
+		{"ca.crt in stringData", corev1.Secret{StringData: map[string]string{"ca.crt": string(certSecretFixture.Data["ca.crt"])}}, nil, false},
+		{"stringData takes precedence", certSecretFixture, func(s *corev1.Secret) { s.StringData = map[string]string{"ca.crt": "invalid"} }, true},
+		{"legacy keys", tlsSecret, nil, true},
+		{"empty", corev1.Secret{}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secret := tt.secret.DeepCopy()
+			if tt.modify != nil {
+				tt.modify(secret)
+			}
+
+			got, err := TLSClientConfigFromCertSecret(*secret, "https://example.com")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("TLSClientConfigFromCertSecret() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && got.ServerName != "example.com" {
+				t.Errorf("TLSClientConfigFromCertSecret() ServerName = %s, want example.com", got.ServerName)
+			}
+		})
+	}
+}
+
+func TestMergeTLSClientConfig(t *testing.T) {
+	base := &tls.Config{Certificates: []tls.Certificate{{}}, ServerName: "base"}
+	override := &tls.Config{RootCAs: x509.NewCertPool()}
+
+	if got := MergeTLSClientConfig(nil, override); got != override {
+		t.Error("MergeTLSClientConfig() with nil base must return override")
+	}
+	if got := MergeTLSClientConfig(base, nil); got != base {
+		t.Error("MergeTLSClientConfig() with nil override must return base")
+	}
+
+	got := MergeTLSClientConfig(base, override)
+	if len(got.Certificates) != 1 || got.RootCAs != override.RootCAs || got.ServerName != "base" {
+		t.Errorf("MergeTLSClientConfig() = %v, expected certificates from base and root CAs from override", got)
+	}
+}
+
 // validTlsSecret creates a secret containing key pair and CA certificate that are
 // valid from a syntax (minimum requirements) perspective.
 func validTlsSecret(t *testing.T) corev1.Secret {
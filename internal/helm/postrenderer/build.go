@@ -18,35 +18,132 @@ package postrenderer
 
 import (
 	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
 
 	"github.com/opencontainers/go-digest"
 	helmpostrender "helm.sh/helm/v3/pkg/postrender"
 
 	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	helmv2beta2 "github.com/fluxcd/helm-controller/api/v2beta2"
 )
 
+// Options configures which post renderers are built by BuildPostRenderers.
+type Options struct {
+	// Scopes tells the namespace post renderer which kinds are cluster-scoped.
+	Scopes *Scopes
+	// Labels are added to all rendered resources which do not have them set already.
+	// The release placeholders in their values are expanded, see NamespacePlaceholder.
+	Labels map[string]string
+	// Annotations are added to all rendered resources which do not have them set already.
+	// The release placeholders in their values are expanded, see NamespacePlaceholder.
+	Annotations map[string]string
+	// DisableFlattenLists keeps List objects instead of replacing them with their items.
+	DisableFlattenLists bool
+	// LegacyPostRenderers are the post renderers of the HelmRelease as declared by the deprecated
+	// v2beta2 API. They carry the patchesStrategicMerge and patchesJson6902 fields which are gone in v2.
+	LegacyPostRenderers []helmv2beta2.PostRenderer
+	// Validate is called with the rendered manifests before any other post renderer runs.
+	Validate func(manifests []byte) error
+	// Exec are local commands which run after the post renderers of the HelmRelease.
+	Exec []Exec
+}
+
+// Placeholders in the values of Options.Labels and Options.Annotations which are replaced by the namespace and the name
+// of the HelmRelease, for instance flux-build/source=HelmRelease/{namespace}/{name}.
+const (
+	NamespacePlaceholder = "{namespace}"
+	NamePlaceholder      = "{name}"
+)
+
+// SkipAnnotation lists the built-in post renderers (comma separated) which are omitted for a HelmRelease.
+const SkipAnnotation = "flux-build.doodlescheduling.com/skip-postrenderer"
+
+// Names of the built-in post renderers which can be skipped by the SkipAnnotation.
+const (
+	FlattenListsName = "flatten-lists"
+	NamespaceName    = "namespace"
+	OriginLabelsName = "origin-labels"
+	CommonLabelsName = "common-labels"
+)
+
+var builtinNames = []string{FlattenListsName, NamespaceName, OriginLabelsName, CommonLabelsName}
+
+// Skipped returns the built-in post renderers the SkipAnnotation of the HelmRelease omits.
+// Unknown names are an error listing the valid ones.
+func Skipped(rel *helmv2.HelmRelease) ([]string, error) {
+	value := rel.GetAnnotations()[SkipAnnotation]
+	if value == "" {
+		return nil, nil
+	}
+
+	var skipped []string
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if !slices.Contains(builtinNames, name) {
+			return nil, fmt.Errorf("unknown post renderer `%s` in annotation %s, valid post renderers are %s", name, SkipAnnotation, strings.Join(builtinNames, ", "))
+		}
+
+		if !slices.Contains(skipped, name) {
+			skipped = append(skipped, name)
+		}
+	}
+
+	return skipped, nil
+}
+
 // BuildPostRenderers creates the post-renderer instances from a HelmRelease
 // and combines them into a single Combined post renderer.
-func BuildPostRenderers(rel *helmv2.HelmRelease) helmpostrender.PostRenderer {
+// Built-in post renderers listed by the SkipAnnotation of the HelmRelease are omitted.
+func BuildPostRenderers(rel *helmv2.HelmRelease, opts Options) (helmpostrender.PostRenderer, error) {
 	if rel == nil {
-		return nil
+		return nil, nil
+	}
+	skipped, err := Skipped(rel)
+	if err != nil {
+		return nil, err
 	}
 	renderers := make([]helmpostrender.PostRenderer, 0)
-	renderers = append(renderers, NewPostRendererNamespace(rel))
+	if opts.Validate != nil {
+		renderers = append(renderers, NewValidate(opts.Validate))
+	}
+	// Lists are flattened first so all subsequent post renderers see the unwrapped resources.
+	if !opts.DisableFlattenLists && !slices.Contains(skipped, FlattenListsName) {
+		renderers = append(renderers, NewFlattenLists())
+	}
+	if !slices.Contains(skipped, NamespaceName) {
+		renderers = append(renderers, NewPostRendererNamespace(rel, opts.Scopes))
+	}
 
-	for _, r := range rel.Spec.PostRenderers {
+	for i, r := range rel.Spec.PostRenderers {
 		if r.Kustomize != nil {
-			renderers = append(renderers, &Kustomize{
+			k := &Kustomize{
 				Patches: r.Kustomize.Patches,
 				Images:  r.Kustomize.Images,
-			})
+			}
+
+			if i < len(opts.LegacyPostRenderers) && opts.LegacyPostRenderers[i].Kustomize != nil {
+				k.PatchesStrategicMerge = opts.LegacyPostRenderers[i].Kustomize.PatchesStrategicMerge
+				k.PatchesJSON6902 = opts.LegacyPostRenderers[i].Kustomize.PatchesJSON6902
+			}
+
+			renderers = append(renderers, k)
 		}
 	}
-	renderers = append(renderers, NewOriginLabels(helmv2.GroupVersion.Group, rel.Namespace, rel.Name))
+	for i := range opts.Exec {
+		renderers = append(renderers, &opts.Exec[i])
+	}
+	if !slices.Contains(skipped, OriginLabelsName) {
+		renderers = append(renderers, NewOriginLabels(helmv2.GroupVersion.Group, rel.Namespace, rel.Name))
+	}
+	if (len(opts.Labels) > 0 || len(opts.Annotations) > 0) && !slices.Contains(skipped, CommonLabelsName) {
+		renderers = append(renderers, NewPostRendererLabels(expandPlaceholders(opts.Labels, rel), expandPlaceholders(opts.Annotations, rel)))
+	}
 	if len(renderers) == 0 {
-		return nil
+		return nil, nil
 	}
-	return NewCombined(renderers...)
+	return NewCombined(renderers...), nil
 }
 
 func Digest(algo digest.Algorithm, postrenders []helmv2.PostRenderer) digest.Digest {
@@ -57,3 +154,19 @@ func Digest(algo digest.Algorithm, postrenders []helmv2.PostRenderer) digest.Dig
 	}
 	return digester.Digest()
 }
+
+// expandPlaceholders returns the values with the release placeholders replaced by the namespace and the name of the
+// HelmRelease.
+func expandPlaceholders(values map[string]string, rel *helmv2.HelmRelease) map[string]string {
+	if len(values) == 0 {
+		return values
+	}
+
+	replacer := strings.NewReplacer(NamespacePlaceholder, rel.Namespace, NamePlaceholder, rel.Name)
+	expanded := make(map[string]string, len(values))
+	for key, value := range values {
+		expanded[key] = replacer.Replace(value)
+	}
+
+	return expanded
+}
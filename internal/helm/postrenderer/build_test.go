@@ -0,0 +1,132 @@
+package postrenderer
+
+import (
+	"bytes"
+	"testing"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/kustomize/api/provider"
+	"sigs.k8s.io/kustomize/api/resmap"
+)
+
+func TestBuildPostRenderersSkip(t *testing.T) {
+	manifests := `apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: ConfigMap
+  metadata:
+    name: app
+`
+
+	tests := []struct {
+		name           string
+		skip           string
+		expectSkipped  []string
+		expectContains []string
+		expectExcludes []string
+		expectErr      string
+	}{
+		{
+			name:           "no annotation",
+			expectContains: []string{"kind: ConfigMap", "namespace: apps", "helm.toolkit.fluxcd.io/name: app", "team: platform"},
+			expectExcludes: []string{"kind: List"},
+		},
+		{
+			name:           "skip namespace and origin labels",
+			skip:           "namespace, origin-labels",
+			expectSkipped:  []string{"namespace", "origin-labels"},
+			expectContains: []string{"kind: ConfigMap", "team: platform"},
+			expectExcludes: []string{"namespace: apps", "helm.toolkit.fluxcd.io/name"},
+		},
+		{
+			name:           "skip flatten lists and common labels",
+			skip:           "flatten-lists,common-labels,flatten-lists",
+			expectSkipped:  []string{"flatten-lists", "common-labels"},
+			expectContains: []string{"kind: ConfigMap", "namespace: apps"},
+			expectExcludes: []string{"team: platform"},
+		},
+		{
+			name:      "unknown post renderer",
+			skip:      "namespace,kustomize",
+			expectErr: "unknown post renderer `kustomize` in annotation flux-build.doodlescheduling.com/skip-postrenderer, valid post renderers are flatten-lists, namespace, origin-labels, common-labels",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			rel := &helmv2.HelmRelease{
+				ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "apps"},
+			}
+			if tt.skip != "" {
+				rel.Annotations = map[string]string{SkipAnnotation: tt.skip}
+			}
+
+			skipped, err := Skipped(rel)
+			renderer, buildErr := BuildPostRenderers(rel, Options{Labels: map[string]string{"team": "platform"}})
+			if tt.expectErr != "" {
+				g.Expect(err).To(MatchError(tt.expectErr))
+				g.Expect(buildErr).To(MatchError(tt.expectErr))
+				return
+			}
+
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(buildErr).ToNot(HaveOccurred())
+			g.Expect(skipped).To(Equal(tt.expectSkipped))
+
+			out, err := renderer.Run(bytes.NewBufferString(manifests))
+			g.Expect(err).ToNot(HaveOccurred())
+			for _, s := range tt.expectContains {
+				g.Expect(out.String()).To(ContainSubstring(s))
+			}
+			for _, s := range tt.expectExcludes {
+				g.Expect(out.String()).ToNot(ContainSubstring(s))
+			}
+		})
+	}
+}
+
+func TestBuildPostRenderersReleasePlaceholders(t *testing.T) {
+	g := NewWithT(t)
+
+	manifests := `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app
+  annotations:
+    flux-build/source: custom
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: app
+`
+
+	rel := &helmv2.HelmRelease{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "apps"},
+	}
+
+	opts := Options{
+		Labels:      map[string]string{"app.kubernetes.io/instance": "{name}"},
+		Annotations: map[string]string{"flux-build/source": "HelmRelease/{namespace}/{name}"},
+	}
+	renderer, err := BuildPostRenderers(rel, opts)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	out, err := renderer.Run(bytes.NewBufferString(manifests))
+	g.Expect(err).ToNot(HaveOccurred())
+
+	resMap, err := resmap.NewFactory(provider.NewDefaultDepProvider().GetResourceFactory()).NewResMapFromBytes(out.Bytes())
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(resMap.Resources()).To(HaveLen(2))
+
+	// Existing keys keep their value, the options are not modified by the expansion
+	configMap, secret := resMap.Resources()[0], resMap.Resources()[1]
+	g.Expect(configMap.GetAnnotations()).To(HaveKeyWithValue("flux-build/source", "custom"))
+	g.Expect(secret.GetAnnotations()).To(HaveKeyWithValue("flux-build/source", "HelmRelease/apps/app"))
+	g.Expect(secret.GetLabels()).To(HaveKeyWithValue("app.kubernetes.io/instance", "app"))
+	g.Expect(opts.Annotations).To(Equal(map[string]string{"flux-build/source": "HelmRelease/{namespace}/{name}"}))
+}
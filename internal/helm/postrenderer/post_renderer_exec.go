@@ -0,0 +1,88 @@
+package postrenderer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+)
+
+// DefaultExecTimeout bounds a single run of an Exec post renderer without a timeout.
+const DefaultExecTimeout = time.Minute
+
+// maxExecStderr is the number of trailing bytes of the stderr of a failed command included in the error.
+const maxExecStderr = 4 << 10
+
+// Exec is a post renderer which pipes the rendered manifests through a local executable. As it runs arbitrary
+// commands it is only configured by the local flux-build configuration and never by a HelmRelease.
+type Exec struct {
+	// Name identifies the post renderer in errors.
+	Name string `json:"name"`
+	// Command is the path of the executable or its name looked up in PATH.
+	Command string `json:"command"`
+	// Args are passed to the command.
+	Args []string `json:"args,omitempty"`
+	// Timeout bounds a single run, DefaultExecTimeout is used if zero.
+	Timeout metav1.Duration `json:"timeout,omitempty"`
+}
+
+// Run writes the rendered manifests to the stdin of the command and returns its stdout.
+// The output must consist of objects, the stderr of a failed command is part of the error.
+func (e *Exec) Run(renderedManifests *bytes.Buffer) (modifiedManifests *bytes.Buffer, err error) {
+	timeout := e.Timeout.Duration
+	if timeout == 0 {
+		timeout = DefaultExecTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, e.Command, e.Args...)
+	cmd.Stdin = bytes.NewReader(renderedManifests.Bytes())
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	// Children of the command may keep the pipes open after it was killed
+	cmd.WaitDelay = time.Second
+
+	if err := cmd.Run(); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("timed out after %s", timeout)
+		}
+
+		return nil, fmt.Errorf("exec post renderer `%s` failed: %w%s", e.Name, err, stderrContext(stderr.Bytes()))
+	}
+
+	nodes, err := kio.FromBytes(stdout.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("exec post renderer `%s` returned invalid manifests: %w", e.Name, err)
+	}
+
+	for _, node := range nodes {
+		if err := validateObject(node); err != nil {
+			return nil, fmt.Errorf("exec post renderer `%s` returned invalid manifests: %w", e.Name, err)
+		}
+	}
+
+	return &stdout, nil
+}
+
+// stderrContext formats the trailing stderr of a command for an error message.
+func stderrContext(stderr []byte) string {
+	stderr = bytes.TrimSpace(stderr)
+	if len(stderr) == 0 {
+		return ""
+	}
+
+	if len(stderr) > maxExecStderr {
+		stderr = stderr[len(stderr)-maxExecStderr:]
+	}
+
+	return ": " + strings.ReplaceAll(string(stderr), "\n", "; ")
+}
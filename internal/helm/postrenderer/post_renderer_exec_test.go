@@ -0,0 +1,75 @@
+package postrenderer
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_Exec_Run(t *testing.T) {
+	renderedManifests := `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: a
+`
+
+	tests := []struct {
+		name            string
+		exec            Exec
+		expectManifests string
+		expectErr       string
+	}{
+		{
+			name: "mutates manifests",
+			exec: Exec{Name: "rename", Command: "sed", Args: []string{"s/name: a/name: b/"}},
+			expectManifests: `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: b
+`,
+		},
+		{
+			name:      "failure with stderr",
+			exec:      Exec{Name: "fail", Command: "sh", Args: []string{"-c", "echo policy violated >&2; exit 3"}},
+			expectErr: "exec post renderer `fail` failed: exit status 3: policy violated",
+		},
+		{
+			name:      "timeout",
+			exec:      Exec{Name: "slow", Command: "sleep", Args: []string{"5"}, Timeout: metav1.Duration{Duration: 100 * time.Millisecond}},
+			expectErr: "exec post renderer `slow` failed: timed out after 100ms",
+		},
+		{
+			name:      "missing command",
+			exec:      Exec{Name: "missing", Command: "flux-build-does-not-exist"},
+			expectErr: "exec post renderer `missing` failed",
+		},
+		{
+			name:      "invalid yaml",
+			exec:      Exec{Name: "invalid", Command: "echo", Args: []string{"a: ["}},
+			expectErr: "exec post renderer `invalid` returned invalid manifests",
+		},
+		{
+			name:      "no objects",
+			exec:      Exec{Name: "sequence", Command: "echo", Args: []string{"- a"}},
+			expectErr: "exec post renderer `sequence` returned invalid manifests: invalid manifest, expected an object but got `!!seq`",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			gotModifiedManifests, err := tt.exec.Run(bytes.NewBufferString(renderedManifests))
+			if tt.expectErr != "" {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tt.expectErr))
+				return
+			}
+
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(gotModifiedManifests.String()).To(Equal(tt.expectManifests))
+		})
+	}
+}
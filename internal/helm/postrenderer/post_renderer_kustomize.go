@@ -19,9 +19,12 @@ package postrenderer
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"sync"
 
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	"sigs.k8s.io/kustomize/api/krusty"
+	"sigs.k8s.io/kustomize/api/provider"
 	"sigs.k8s.io/kustomize/api/resmap"
 	kustypes "sigs.k8s.io/kustomize/api/types"
 	"sigs.k8s.io/kustomize/kyaml/filesys"
@@ -33,11 +36,23 @@ import (
 type Kustomize struct {
 	// Patches is a list of patches to apply to the rendered manifests.
 	Patches []kustomize.Patch
+	// PatchesStrategicMerge is a list of strategic merge patches to apply to the rendered manifests.
+	// Only set by HelmReleases of the deprecated v2beta1 and v2beta2 APIs.
+	PatchesStrategicMerge []apiextensionsv1.JSON
+	// PatchesJSON6902 is a list of JSON6902 patches to apply to the rendered manifests.
+	// Only set by HelmReleases of the deprecated v2beta1 and v2beta2 APIs.
+	PatchesJSON6902 []kustomize.JSON6902Patch
 	// Images is a list of images to replace in the rendered manifests.
 	Images []kustomize.Image
 }
 
 func (k *Kustomize) Run(renderedManifests *bytes.Buffer) (modifiedManifests *bytes.Buffer, err error) {
+	resFactory := provider.NewDefaultDepProvider().GetResourceFactory()
+	rendered, err := resmap.NewFactory(resFactory).NewResMapFromBytes(renderedManifests.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
 	fs := filesys.MakeFsInMemory()
 	cfg := kustypes.Kustomization{}
 	cfg.APIVersion = kustypes.KustomizationVersion
@@ -52,10 +67,37 @@ func (k *Kustomize) Run(renderedManifests *bytes.Buffer) (modifiedManifests *byt
 	}
 
 	// Add patches.
-	for _, m := range k.Patches {
+	for i, m := range k.Patches {
+		target := adaptSelector(m.Target)
+		if err := selectsAny(rendered, target); err != nil {
+			return nil, fmt.Errorf("patch %d: %w", i, err)
+		}
+
 		cfg.Patches = append(cfg.Patches, kustypes.Patch{
 			Patch:  m.Patch,
-			Target: adaptSelector(m.Target),
+			Target: target,
+		})
+	}
+
+	// Add strategic merge patches.
+	for _, m := range k.PatchesStrategicMerge {
+		cfg.PatchesStrategicMerge = append(cfg.PatchesStrategicMerge, kustypes.PatchStrategicMerge(m.Raw))
+	}
+
+	// Add JSON 6902 patches.
+	for i, m := range k.PatchesJSON6902 {
+		target := adaptSelector(&m.Target)
+		if err := selectsAny(rendered, target); err != nil {
+			return nil, fmt.Errorf("json6902 patch %d: %w", i, err)
+		}
+
+		patch, err := json.Marshal(m.Patch)
+		if err != nil {
+			return nil, err
+		}
+		cfg.PatchesJson6902 = append(cfg.PatchesJson6902, kustypes.Patch{
+			Patch:  string(patch),
+			Target: target,
 		})
 	}
 
@@ -132,6 +174,25 @@ func adaptSelector(selector *kustomize.Selector) (output *kustypes.Selector) {
 	return
 }
 
+// selectsAny returns an error if the given patch target selector does not match
+// any of the rendered resources.
+func selectsAny(rendered resmap.ResMap, target *kustypes.Selector) error {
+	if target == nil {
+		return nil
+	}
+
+	matches, err := rendered.Select(*target)
+	if err != nil {
+		return fmt.Errorf("invalid patch target: %w", err)
+	}
+
+	if len(matches) == 0 {
+		return fmt.Errorf("patch target `%s` does not match any rendered resource", target.String())
+	}
+
+	return nil
+}
+
 // TODO: remove mutex when kustomize fixes the concurrent map read/write panic
 var kustomizeRenderMutex sync.Mutex
 
@@ -21,6 +21,7 @@ import (
 	"testing"
 
 	. "github.com/onsi/gomega"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	"sigs.k8s.io/yaml"
 
 	"github.com/fluxcd/pkg/apis/kustomize"
@@ -62,6 +63,8 @@ func Test_postRendererKustomize_Run(t *testing.T) {
 		name              string
 		renderedManifests string
 		patches           string
+		patchesSM         string
+		patchesJSON6902   string
 		images            string
 		expectManifests   string
 		expectErr         bool
@@ -219,22 +222,99 @@ spec:
         name: nginx
 `,
 		},
+		{
+			name:              "targeted patch without match",
+			renderedManifests: strategicMergeMock,
+			patches: `
+- target:
+    kind: Deployment
+    name: other
+  patch: |
+    - op: add
+      path: /metadata/annotations/c
+      value: foo
+`,
+			expectErr: true,
+		},
+		{
+			name:              "legacy strategic merge",
+			renderedManifests: strategicMergeMock,
+			patchesSM: `
+- apiVersion: apps/v1
+  kind: Deployment
+  metadata:
+    name: nginx
+  spec:
+    template:
+      spec:
+        containers:
+          - name: nginx
+            image: nignx:latest
+`,
+			expectManifests: `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: nginx
+spec:
+  template:
+    spec:
+      containers:
+      - image: nignx:latest
+        name: nginx
+`,
+		},
+		{
+			name:              "legacy json 6902",
+			renderedManifests: json6902Mock,
+			patchesJSON6902: `
+- target:
+    version: v1
+    kind: Pod
+    name: json6902
+  patch:
+    - op: replace
+      path: /metadata/annotations/c
+      value: bar
+`,
+			expectManifests: `apiVersion: v1
+kind: Pod
+metadata:
+  annotations:
+    c: bar
+  name: json6902
+`,
+		},
+		{
+			name:              "legacy json 6902 without match",
+			renderedManifests: json6902Mock,
+			patchesJSON6902: `
+- target:
+    version: v1
+    kind: Pod
+    name: other
+  patch:
+    - op: remove
+      path: /metadata/annotations/c
+`,
+			expectErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			g := NewWithT(t)
 
-			spec, err := mockKustomize(tt.patches, tt.images)
+			spec, err := mockKustomize(tt.patches, tt.patchesSM, tt.patchesJSON6902, tt.images)
 			g.Expect(err).ToNot(HaveOccurred())
 
 			k := &Kustomize{
-				Patches: spec.Patches,
-				Images:  spec.Images,
+				Patches:               spec.Patches,
+				PatchesStrategicMerge: spec.PatchesStrategicMerge,
+				PatchesJSON6902:       spec.PatchesJSON6902,
+				Images:                spec.Images,
 			}
 			gotModifiedManifests, err := k.Run(bytes.NewBufferString(tt.renderedManifests))
 			if tt.expectErr {
-				g.Expect(err).ToNot(HaveOccurred())
-				g.Expect(gotModifiedManifests.String()).To(BeEmpty())
+				g.Expect(err).To(HaveOccurred())
 				return
 			}
 
@@ -244,17 +324,27 @@ spec:
 	}
 }
 
-func mockKustomize(patches, images string) (*helmv1.Kustomize, error) {
+func mockKustomize(patches, patchesSM, patchesJSON6902, images string) (*helmv1.Kustomize, error) {
 	var targeted []kustomize.Patch
 	if err := yaml.Unmarshal([]byte(patches), &targeted); err != nil {
 		return nil, err
 	}
+	var strategicMerge []apiextensionsv1.JSON
+	if err := yaml.Unmarshal([]byte(patchesSM), &strategicMerge); err != nil {
+		return nil, err
+	}
+	var json6902 []kustomize.JSON6902Patch
+	if err := yaml.Unmarshal([]byte(patchesJSON6902), &json6902); err != nil {
+		return nil, err
+	}
 	var imgs []kustomize.Image
 	if err := yaml.Unmarshal([]byte(images), &imgs); err != nil {
 		return nil, err
 	}
 	return &helmv1.Kustomize{
-		Patches: targeted,
-		Images:  imgs,
+		Patches:               targeted,
+		PatchesStrategicMerge: strategicMerge,
+		PatchesJSON6902:       json6902,
+		Images:                imgs,
 	}, nil
 }
@@ -0,0 +1,100 @@
+package postrenderer
+
+import (
+	"bytes"
+
+	"sigs.k8s.io/kustomize/api/builtins"
+	"sigs.k8s.io/kustomize/api/provider"
+	"sigs.k8s.io/kustomize/api/resmap"
+	kustypes "sigs.k8s.io/kustomize/api/types"
+)
+
+// NewPostRendererLabels returns a post renderer which adds the given labels and annotations
+// to the metadata of all rendered resources.
+// Keys which are already set on a resource keep their value.
+func NewPostRendererLabels(labels, annotations map[string]string) *postRendererLabels {
+	return &postRendererLabels{
+		labels:      labels,
+		annotations: annotations,
+	}
+}
+
+type postRendererLabels struct {
+	labels      map[string]string
+	annotations map[string]string
+}
+
+func (k *postRendererLabels) Run(renderedManifests *bytes.Buffer) (modifiedManifests *bytes.Buffer, err error) {
+	resFactory := provider.NewDefaultDepProvider().GetResourceFactory()
+	resMapFactory := resmap.NewFactory(resFactory)
+
+	resMap, err := resMapFactory.NewResMapFromBytes(renderedManifests.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	// Remember the existing metadata so it can be restored after the transformation,
+	// the transformers would otherwise overwrite keys which are already set.
+	existingLabels := make([]map[string]string, resMap.Size())
+	existingAnnotations := make([]map[string]string, resMap.Size())
+	for i, res := range resMap.Resources() {
+		existingLabels[i] = res.GetLabels()
+		existingAnnotations[i] = res.GetAnnotations()
+	}
+
+	// Only the object metadata is touched, selectors and pod templates are left as they are.
+	if len(k.labels) > 0 {
+		labelTransformer := builtins.LabelTransformerPlugin{
+			Labels: k.labels,
+			FieldSpecs: []kustypes.FieldSpec{
+				{Path: "metadata/labels", CreateIfNotPresent: true},
+			},
+		}
+		if err := labelTransformer.Transform(resMap); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(k.annotations) > 0 {
+		annotationsTransformer := builtins.AnnotationsTransformerPlugin{
+			Annotations: k.annotations,
+			FieldSpecs: []kustypes.FieldSpec{
+				{Path: "metadata/annotations", CreateIfNotPresent: true},
+			},
+		}
+		if err := annotationsTransformer.Transform(resMap); err != nil {
+			return nil, err
+		}
+	}
+
+	for i, res := range resMap.Resources() {
+		if err := res.SetLabels(mergeMetadata(res.GetLabels(), existingLabels[i])); err != nil {
+			return nil, err
+		}
+
+		if err := res.SetAnnotations(mergeMetadata(res.GetAnnotations(), existingAnnotations[i])); err != nil {
+			return nil, err
+		}
+	}
+
+	resMap.RemoveBuildAnnotations()
+	yaml, err := resMap.AsYaml()
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.NewBuffer(yaml), nil
+}
+
+// mergeMetadata returns dst with all keys from src applied on top.
+func mergeMetadata(dst, src map[string]string) map[string]string {
+	if dst == nil {
+		dst = make(map[string]string, len(src))
+	}
+
+	for key, value := range src {
+		dst[key] = value
+	}
+
+	return dst
+}
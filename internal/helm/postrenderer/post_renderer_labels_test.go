@@ -0,0 +1,119 @@
+package postrenderer
+
+import (
+	"bytes"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+const labelsMock = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  labels:
+    app.kubernetes.io/managed-by: Helm
+  name: deployment
+spec:
+  selector:
+    matchLabels:
+      app: deployment
+  template:
+    metadata:
+      labels:
+        app: deployment
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: configmap
+`
+
+func Test_postRendererLabels_Run(t *testing.T) {
+	tests := []struct {
+		name              string
+		renderedManifests string
+		labels            map[string]string
+		annotations       map[string]string
+		expectManifests   string
+	}{
+		{
+			name:              "merges labels and annotations",
+			renderedManifests: labelsMock,
+			labels: map[string]string{
+				"app.kubernetes.io/managed-by": "flux-build",
+				"team":                         "platform",
+			},
+			annotations: map[string]string{
+				"flux-build/source": "default/release",
+			},
+			expectManifests: `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  annotations:
+    flux-build/source: default/release
+  labels:
+    app.kubernetes.io/managed-by: Helm
+    team: platform
+  name: deployment
+spec:
+  selector:
+    matchLabels:
+      app: deployment
+  template:
+    metadata:
+      labels:
+        app: deployment
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  annotations:
+    flux-build/source: default/release
+  labels:
+    app.kubernetes.io/managed-by: flux-build
+    team: platform
+  name: configmap
+`,
+		},
+		{
+			name:              "labels only",
+			renderedManifests: labelsMock,
+			labels: map[string]string{
+				"team": "platform",
+			},
+			expectManifests: `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  labels:
+    app.kubernetes.io/managed-by: Helm
+    team: platform
+  name: deployment
+spec:
+  selector:
+    matchLabels:
+      app: deployment
+  template:
+    metadata:
+      labels:
+        app: deployment
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  labels:
+    team: platform
+  name: configmap
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			k := NewPostRendererLabels(tt.labels, tt.annotations)
+			gotModifiedManifests, err := k.Run(bytes.NewBufferString(tt.renderedManifests))
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(gotModifiedManifests.String()).To(Equal(tt.expectManifests))
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package postrenderer
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/openapi"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// NewFlattenLists returns a post renderer which replaces List objects (kind List or any kind
+// with a List suffix carrying items) with their items.
+func NewFlattenLists() *FlattenLists {
+	return &FlattenLists{}
+}
+
+// FlattenLists is a Helm post renderer which unwraps List objects into their items.
+// Nested lists are unwrapped recursively and empty lists are dropped.
+// Each item keeps its own metadata.
+type FlattenLists struct{}
+
+func (k *FlattenLists) Run(renderedManifests *bytes.Buffer) (modifiedManifests *bytes.Buffer, err error) {
+	nodes, err := kio.FromBytes(renderedManifests.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	var flattened []*yaml.RNode
+	for _, node := range nodes {
+		items, err := flattenList(node)
+		if err != nil {
+			return nil, err
+		}
+
+		flattened = append(flattened, items...)
+	}
+
+	out, err := kio.StringAll(flattened)
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.NewBufferString(out), nil
+}
+
+// flattenList returns the items of the given node if it is a list or the node itself otherwise.
+func flattenList(node *yaml.RNode) ([]*yaml.RNode, error) {
+	if err := validateObject(node); err != nil {
+		return nil, err
+	}
+
+	if !IsList(node) {
+		return []*yaml.RNode{node}, nil
+	}
+
+	items := node.Field("items")
+	if items == nil || items.Value.IsNilOrEmpty() {
+		return nil, nil
+	}
+
+	elements, err := items.Value.Elements()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read items of %s: %w", node.GetKind(), err)
+	}
+
+	var result []*yaml.RNode
+	for _, element := range elements {
+		if element.IsNilOrEmpty() {
+			continue
+		}
+
+		nested, err := flattenList(element)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, nested...)
+	}
+
+	return result, nil
+}
+
+// IsList returns true if the object is a list which is unwrapped into its items, kind List or any kind with a List
+// suffix carrying items. A custom resource whose kind happens to end with List (for instance an IPAllowList) is only
+// considered a list if all of its items are objects. An empty list is only unwrapped if it is a built-in Kubernetes
+// list type, for instance a RoleList, otherwise it is preserved.
+func IsList(node *yaml.RNode) bool {
+	if node.YNode().Kind != yaml.MappingNode {
+		return false
+	}
+
+	kind := node.GetKind()
+	if kind == "List" {
+		return true
+	}
+
+	if !strings.HasSuffix(kind, "List") {
+		return false
+	}
+
+	items := node.Field("items")
+	if items == nil || items.Value.YNode().Kind != yaml.SequenceNode {
+		return false
+	}
+
+	elements := items.Value.YNode().Content
+	if len(elements) == 0 {
+		return openapi.SchemaForResourceType(yaml.TypeMeta{APIVersion: node.GetApiVersion(), Kind: kind}) != nil
+	}
+
+	for _, element := range elements {
+		item := yaml.NewRNode(element)
+		if element.Kind != yaml.MappingNode || item.GetKind() == "" || item.GetApiVersion() == "" {
+			return false
+		}
+	}
+
+	return true
+}
+
+// validateObject verifies the structure kyaml relies on when accessing the kind and metadata of an object,
+// its accessors panic if the object or its metadata is not a mapping.
+func validateObject(node *yaml.RNode) error {
+	if node.YNode().Kind != yaml.MappingNode {
+		return fmt.Errorf("invalid manifest, expected an object but got `%s`", node.YNode().ShortTag())
+	}
+
+	metadata := node.Field(yaml.MetadataField)
+	if metadata == nil || metadata.Value.IsNil() {
+		return nil
+	}
+
+	if metadata.Value.YNode().Kind != yaml.MappingNode {
+		return fmt.Errorf("invalid manifest, expected metadata to be an object but got `%s`", metadata.Value.YNode().ShortTag())
+	}
+
+	for _, field := range []string{yaml.LabelsField, yaml.AnnotationsField} {
+		value := metadata.Value.Field(field)
+		if value == nil || value.Value.IsNil() {
+			continue
+		}
+
+		if value.Value.YNode().Kind != yaml.MappingNode {
+			return fmt.Errorf("invalid manifest, expected metadata.%s to be an object but got `%s`", field, value.Value.YNode().ShortTag())
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,347 @@
+package postrenderer
+
+import (
+	"bytes"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+func Test_FlattenLists_Run(t *testing.T) {
+	tests := []struct {
+		name              string
+		renderedManifests string
+		expectManifests   string
+		expectErr         string
+	}{
+		{
+			name: "list",
+			renderedManifests: `apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: ConfigMap
+  metadata:
+    name: a
+    labels:
+      app: a
+- apiVersion: v1
+  kind: Service
+  metadata:
+    name: b
+---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: c
+`,
+			expectManifests: `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: a
+  labels:
+    app: a
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: b
+---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: c
+`,
+		},
+		{
+			name: "nested list",
+			renderedManifests: `apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: ConfigMapList
+  items:
+  - apiVersion: v1
+    kind: ConfigMap
+    metadata:
+      name: a
+- apiVersion: v1
+  kind: Service
+  metadata:
+    name: b
+`,
+			expectManifests: `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: a
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: b
+`,
+		},
+		{
+			name: "empty lists",
+			renderedManifests: `apiVersion: v1
+kind: List
+items: []
+---
+apiVersion: v1
+kind: List
+---
+apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: List
+  items: []
+---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: c
+`,
+			expectManifests: `apiVersion: v1
+kind: Pod
+metadata:
+  name: c
+`,
+		},
+		{
+			name: "empty built-in lists",
+			renderedManifests: `apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleList
+items: []
+---
+apiVersion: networking.k8s.io/v1
+kind: NetworkPolicyList
+items: []
+---
+apiVersion: apps/v1
+kind: DeploymentList
+items: []
+---
+apiVersion: v1
+kind: ConfigMapList
+items: []
+---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: c
+`,
+			expectManifests: `apiVersion: v1
+kind: Pod
+metadata:
+  name: c
+`,
+		},
+		{
+			name: "kind with list suffix",
+			renderedManifests: `apiVersion: example.com/v1
+kind: AllowList
+metadata:
+  name: a
+spec:
+  hosts: []
+`,
+			expectManifests: `apiVersion: example.com/v1
+kind: AllowList
+metadata:
+  name: a
+spec:
+  hosts: []
+`,
+		},
+		{
+			name: "custom resources with list suffix",
+			renderedManifests: `apiVersion: net.example.com/v1alpha1
+kind: IPAllowList
+metadata:
+  name: a
+items:
+- 10.0.0.0/8
+---
+apiVersion: net.example.com/v1alpha1
+kind: IPAllowList
+metadata:
+  name: b
+items: []
+---
+apiVersion: net.example.com/v1alpha1
+kind: AccessList
+metadata:
+  name: c
+items:
+- user: a
+`,
+			expectManifests: `apiVersion: net.example.com/v1alpha1
+kind: IPAllowList
+metadata:
+  name: a
+items:
+- 10.0.0.0/8
+---
+apiVersion: net.example.com/v1alpha1
+kind: IPAllowList
+metadata:
+  name: b
+items: []
+---
+apiVersion: net.example.com/v1alpha1
+kind: AccessList
+metadata:
+  name: c
+items:
+- user: a
+`,
+		},
+		{
+			name: "custom resource list",
+			renderedManifests: `apiVersion: net.example.com/v1alpha1
+kind: IPAllowListList
+items:
+- apiVersion: net.example.com/v1alpha1
+  kind: IPAllowList
+  metadata:
+    name: a
+`,
+			expectManifests: `apiVersion: net.example.com/v1alpha1
+kind: IPAllowList
+metadata:
+  name: a
+`,
+		},
+		{
+			name:              "sequence document",
+			renderedManifests: "- a\n",
+			expectErr:         "expected an object but got `!!seq`",
+		},
+		{
+			name: "scalar list item",
+			renderedManifests: `apiVersion: v1
+kind: List
+items:
+- a
+`,
+			expectErr: "expected an object but got `!!str`",
+		},
+		{
+			name: "metadata sequence",
+			renderedManifests: `apiVersion: v1
+kind: Pod
+metadata:
+- a
+`,
+			expectErr: "expected metadata to be an object but got `!!seq`",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			k := NewFlattenLists()
+			gotModifiedManifests, err := k.Run(bytes.NewBufferString(tt.renderedManifests))
+			if tt.expectErr != "" {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tt.expectErr))
+				return
+			}
+
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(gotModifiedManifests.String()).To(Equal(tt.expectManifests))
+		})
+	}
+}
+
+func Test_IsList(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+		expect   bool
+	}{
+		{
+			name: "list",
+			manifest: `apiVersion: v1
+kind: List
+items: []
+`,
+			expect: true,
+		},
+		{
+			name: "empty core list",
+			manifest: `apiVersion: v1
+kind: ConfigMapList
+items: []
+`,
+			expect: true,
+		},
+		{
+			name: "empty rbac list",
+			manifest: `apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleList
+items: []
+`,
+			expect: true,
+		},
+		{
+			name: "empty networking list",
+			manifest: `apiVersion: networking.k8s.io/v1
+kind: NetworkPolicyList
+items: []
+`,
+			expect: true,
+		},
+		{
+			name: "empty custom resource of a dotted group",
+			manifest: `apiVersion: net.example.com/v1alpha1
+kind: IPAllowList
+metadata:
+  name: a
+items: []
+`,
+		},
+		{
+			name: "empty unknown core kind",
+			manifest: `apiVersion: v1
+kind: PolicyList
+metadata:
+  name: a
+items: []
+`,
+		},
+		{
+			name: "custom resource list",
+			manifest: `apiVersion: net.example.com/v1alpha1
+kind: WidgetList
+items:
+- apiVersion: net.example.com/v1alpha1
+  kind: Widget
+  metadata:
+    name: a
+`,
+			expect: true,
+		},
+		{
+			name: "custom resource with scalar items",
+			manifest: `apiVersion: net.example.com/v1alpha1
+kind: IPAllowList
+metadata:
+  name: a
+items:
+- 10.0.0.0/8
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			node, err := yaml.Parse(tt.manifest)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(IsList(node)).To(Equal(tt.expect))
+		})
+	}
+}
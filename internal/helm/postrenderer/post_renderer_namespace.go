@@ -2,58 +2,124 @@ package postrenderer
 
 import (
 	"bytes"
-	"encoding/json"
 
 	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"github.com/go-logr/logr"
+	"sigs.k8s.io/kustomize/api/builtins"
+	"sigs.k8s.io/kustomize/api/filters/namespace"
+	"sigs.k8s.io/kustomize/api/provider"
+	"sigs.k8s.io/kustomize/api/resmap"
 	kustypes "sigs.k8s.io/kustomize/api/types"
-	"sigs.k8s.io/kustomize/kyaml/filesys"
 )
 
-func NewPostRendererNamespace(release *helmv2.HelmRelease) *postRendererNamespace {
+// DefaultClusterScopedKinds is the list of built-in Kubernetes kinds which are not namespaced.
+// The namespace post renderer never stamps a namespace onto these.
+var DefaultClusterScopedKinds = []string{
+	"APIService",
+	"CertificateSigningRequest",
+	"ClusterRole",
+	"ClusterRoleBinding",
+	"ComponentStatus",
+	"CSIDriver",
+	"CSINode",
+	"CustomResourceDefinition",
+	"FlowSchema",
+	"IngressClass",
+	"MutatingWebhookConfiguration",
+	"Namespace",
+	"Node",
+	"PersistentVolume",
+	"PriorityClass",
+	"PriorityLevelConfiguration",
+	"RuntimeClass",
+	"StorageClass",
+	"ValidatingAdmissionPolicy",
+	"ValidatingAdmissionPolicyBinding",
+	"ValidatingWebhookConfiguration",
+	"VolumeAttachment",
+}
+
+// NewPostRendererNamespace returns a post renderer which sets the release namespace
+// on all rendered resources. Kinds which are cluster-scoped according to scopes are left untouched,
+// the CRDs of the rendered resources are added to scopes. Built-in kinds are looked up if scopes is nil.
+func NewPostRendererNamespace(release *helmv2.HelmRelease, scopes *Scopes) *postRendererNamespace {
 	ns := release.GetReleaseNamespace()
 	if ns == "" {
 		ns = "default"
 	}
 
+	if scopes == nil {
+		scopes = NewScopes(logr.Discard(), nil)
+	}
+
 	return &postRendererNamespace{
 		namespace: ns,
+		scopes:    scopes,
 	}
 }
 
 type postRendererNamespace struct {
 	namespace string
+	scopes    *Scopes
 }
 
 func (k *postRendererNamespace) Run(renderedManifests *bytes.Buffer) (modifiedManifests *bytes.Buffer, err error) {
-	fs := filesys.MakeFsInMemory()
-	cfg := kustypes.Kustomization{}
-	cfg.APIVersion = kustypes.KustomizationVersion
-	cfg.Kind = kustypes.KustomizationKind
-	cfg.Namespace = k.namespace
-
-	// Add rendered Helm output as input resource to the Kustomization.
-	const input = "helm-output.yaml"
-	cfg.Resources = append(cfg.Resources, input)
-	if err := writeFile(fs, input, renderedManifests); err != nil {
-		return nil, err
-	}
+	resFactory := provider.NewDefaultDepProvider().GetResourceFactory()
+	resMapFactory := resmap.NewFactory(resFactory)
 
-	// Write kustomization config to file.
-	kustomization, err := json.Marshal(cfg)
+	resMap, err := resMapFactory.NewResMapFromBytes(renderedManifests.Bytes())
 	if err != nil {
 		return nil, err
 	}
-	if err := writeToFile(fs, "kustomization.yaml", kustomization); err != nil {
-		return nil, err
-	}
-	resMap, err := buildKustomization(fs, ".")
-	if err != nil {
+
+	if err := SetNamespace(resMap, k.namespace, k.scopes); err != nil {
 		return nil, err
 	}
+
+	resMap.RemoveBuildAnnotations()
 	yaml, err := resMap.AsYaml()
 	if err != nil {
 		return nil, err
 	}
+
 	return bytes.NewBuffer(yaml), nil
+}
+
+// SetNamespace sets the namespace ns on all resources of resMap which are namespaced according to scopes, like the
+// namespace transformer of kustomize the subjects of RoleBindings and ClusterRoleBindings in the default namespace are
+// updated. The CRDs of resMap are added to scopes.
+func SetNamespace(resMap resmap.ResMap, ns string, scopes *Scopes) error {
+	// Remember the namespace of cluster-scoped resources so it can be restored after the transformation.
+	// The transformer still needs to see them as it updates the subjects of ClusterRoleBindings.
+	scopes.AddCRDs(resMap.Resources()...)
+
+	clusterScoped := make(map[int]string)
+	for i, res := range resMap.Resources() {
+		if !scopes.IsNamespaced(res.GetApiVersion(), res.GetKind()) {
+			clusterScoped[i] = res.GetNamespace()
+		}
+	}
+
+	namespaceTransformer := builtins.NamespaceTransformerPlugin{
+		ObjectMeta: kustypes.ObjectMeta{
+			Namespace: ns,
+		},
+		FieldSpecs: []kustypes.FieldSpec{
+			{Path: "metadata/namespace", CreateIfNotPresent: true},
+		},
+		SetRoleBindingSubjects: namespace.DefaultSubjectsOnly,
+	}
+	if err := namespaceTransformer.Transform(resMap); err != nil {
+		return err
+	}
+
+	for i, res := range resMap.Resources() {
+		if ns, ok := clusterScoped[i]; ok {
+			if err := res.SetNamespace(ns); err != nil {
+				return err
+			}
+		}
+	}
 
+	return nil
 }
@@ -0,0 +1,164 @@
+package postrenderer
+
+import (
+	"bytes"
+	"testing"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"github.com/go-logr/logr"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const clusterScopedMock = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: deployment
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: clusterrole
+---
+apiVersion: cert-manager.io/v1
+kind: ClusterIssuer
+metadata:
+  name: issuer
+`
+
+func Test_postRendererNamespace_Run(t *testing.T) {
+	tests := []struct {
+		name               string
+		renderedManifests  string
+		clusterScopedKinds []string
+		apiResources       []APIResource
+		expectManifests    string
+	}{
+		{
+			name:              "skips built-in cluster-scoped kinds",
+			renderedManifests: clusterScopedMock,
+			expectManifests: `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: deployment
+  namespace: target
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: clusterrole
+---
+apiVersion: cert-manager.io/v1
+kind: ClusterIssuer
+metadata:
+  name: issuer
+  namespace: target
+`,
+		},
+		{
+			name:               "skips additional cluster-scoped kinds",
+			renderedManifests:  clusterScopedMock,
+			clusterScopedKinds: []string{"ClusterIssuer"},
+			expectManifests: `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: deployment
+  namespace: target
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: clusterrole
+---
+apiVersion: cert-manager.io/v1
+kind: ClusterIssuer
+metadata:
+  name: issuer
+`,
+		},
+		{
+			name:              "skips kinds declared cluster-scoped by api resources",
+			renderedManifests: clusterScopedMock,
+			apiResources: []APIResource{
+				{APIVersion: "cert-manager.io/v1", Kind: "ClusterIssuer"},
+				{APIVersion: "cert-manager.io/v1", Kind: "Issuer", Namespaced: true},
+			},
+			expectManifests: `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: deployment
+  namespace: target
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: clusterrole
+---
+apiVersion: cert-manager.io/v1
+kind: ClusterIssuer
+metadata:
+  name: issuer
+`,
+		},
+		{
+			name: "skips kinds of cluster-scoped CRDs",
+			renderedManifests: `apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: clusterissuers.cert-manager.io
+spec:
+  group: cert-manager.io
+  names:
+    kind: ClusterIssuer
+  scope: Cluster
+---
+` + clusterScopedMock,
+			expectManifests: `apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: clusterissuers.cert-manager.io
+spec:
+  group: cert-manager.io
+  names:
+    kind: ClusterIssuer
+  scope: Cluster
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: deployment
+  namespace: target
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: clusterrole
+---
+apiVersion: cert-manager.io/v1
+kind: ClusterIssuer
+metadata:
+  name: issuer
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			hr := &helmv2.HelmRelease{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "release",
+					Namespace: "default",
+				},
+				Spec: helmv2.HelmReleaseSpec{
+					TargetNamespace: "target",
+				},
+			}
+
+			k := NewPostRendererNamespace(hr, NewScopes(logr.Discard(), tt.apiResources, tt.clusterScopedKinds...))
+			gotModifiedManifests, err := k.Run(bytes.NewBufferString(tt.renderedManifests))
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(gotModifiedManifests.String()).To(Equal(tt.expectManifests))
+		})
+	}
+}
@@ -0,0 +1,24 @@
+package postrenderer
+
+import (
+	"bytes"
+)
+
+// NewValidate returns a post renderer which rejects the rendered manifests if validate fails.
+// The manifests are passed on unchanged otherwise.
+func NewValidate(validate func(manifests []byte) error) *Validate {
+	return &Validate{validate: validate}
+}
+
+// Validate is a Helm post renderer which guards the subsequent post renderers against invalid input.
+type Validate struct {
+	validate func(manifests []byte) error
+}
+
+func (k *Validate) Run(renderedManifests *bytes.Buffer) (modifiedManifests *bytes.Buffer, err error) {
+	if err := k.validate(renderedManifests.Bytes()); err != nil {
+		return nil, err
+	}
+
+	return renderedManifests, nil
+}
@@ -0,0 +1,104 @@
+package postrenderer
+
+import (
+	"slices"
+	"sync"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/kustomize/api/resource"
+	"sigs.k8s.io/kustomize/kyaml/openapi"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// APIResource declares the scope of a kind like a line of kubectl api-resources.
+type APIResource struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespaced bool   `json:"namespaced"`
+}
+
+var crdGroupKind = schema.GroupKind{Group: "apiextensions.k8s.io", Kind: "CustomResourceDefinition"}
+
+// Scopes tells namespaced from cluster-scoped kinds. A kind is looked up in the api resources, the CRDs added by
+// AddCRDs, DefaultClusterScopedKinds and the additional cluster-scoped kinds and finally the built-in Kubernetes types.
+// Unknown kinds are considered namespaced, a warning is logged once per kind. It is safe for concurrent use.
+type Scopes struct {
+	logger             logr.Logger
+	apiResources       map[schema.GroupKind]bool
+	clusterScopedKinds []string
+
+	mu      sync.RWMutex
+	crds    map[schema.GroupKind]bool
+	unknown map[schema.GroupKind]struct{}
+}
+
+// NewScopes returns a lookup of the api resources and the cluster-scoped kinds in addition to
+// DefaultClusterScopedKinds.
+func NewScopes(logger logr.Logger, apiResources []APIResource, clusterScopedKinds ...string) *Scopes {
+	s := &Scopes{
+		logger:             logger,
+		apiResources:       make(map[schema.GroupKind]bool),
+		clusterScopedKinds: append(slices.Clone(DefaultClusterScopedKinds), clusterScopedKinds...),
+		crds:               make(map[schema.GroupKind]bool),
+		unknown:            make(map[schema.GroupKind]struct{}),
+	}
+
+	for _, r := range apiResources {
+		s.apiResources[schema.FromAPIVersionAndKind(r.APIVersion, r.Kind).GroupKind()] = r.Namespaced
+	}
+
+	return s
+}
+
+// AddCRDs adds the kinds of all CustomResourceDefinitions of resources.
+func (s *Scopes) AddCRDs(resources ...*resource.Resource) {
+	for _, r := range resources {
+		if schema.FromAPIVersionAndKind(r.GetApiVersion(), r.GetKind()).GroupKind() != crdGroupKind {
+			continue
+		}
+
+		group, _ := r.GetString("spec.group")
+		kind, _ := r.GetString("spec.names.kind")
+		scope, _ := r.GetString("spec.scope")
+		if kind == "" {
+			continue
+		}
+
+		s.mu.Lock()
+		s.crds[schema.GroupKind{Group: group, Kind: kind}] = scope != "Cluster"
+		s.mu.Unlock()
+	}
+}
+
+// IsNamespaced returns true unless the kind is known to be cluster-scoped.
+func (s *Scopes) IsNamespaced(apiVersion, kind string) bool {
+	gk := schema.FromAPIVersionAndKind(apiVersion, kind).GroupKind()
+	if namespaced, ok := s.apiResources[gk]; ok {
+		return namespaced
+	}
+
+	s.mu.RLock()
+	namespaced, ok := s.crds[gk]
+	s.mu.RUnlock()
+	if ok {
+		return namespaced
+	}
+
+	if slices.Contains(s.clusterScopedKinds, kind) {
+		return false
+	}
+
+	if namespaced, ok := openapi.IsNamespaceScoped(yaml.TypeMeta{APIVersion: apiVersion, Kind: kind}); ok {
+		return namespaced
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.unknown[gk]; !ok {
+		s.unknown[gk] = struct{}{}
+		s.logger.Info("scope of kind is unknown, assuming it is namespaced, declare it by --api-resources if it is cluster-scoped", "kind", gk.String())
+	}
+
+	return true
+}
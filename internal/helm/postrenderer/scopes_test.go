@@ -0,0 +1,107 @@
+package postrenderer
+
+import (
+	"testing"
+
+	"github.com/go-logr/logr/funcr"
+	. "github.com/onsi/gomega"
+	"sigs.k8s.io/kustomize/api/provider"
+	"sigs.k8s.io/kustomize/api/resmap"
+)
+
+func TestScopesIsNamespaced(t *testing.T) {
+	crds := `apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: clusterissuers.cert-manager.io
+spec:
+  group: cert-manager.io
+  names:
+    kind: ClusterIssuer
+  scope: Cluster
+---
+apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: certificates.cert-manager.io
+spec:
+  group: cert-manager.io
+  names:
+    kind: Certificate
+  scope: Namespaced
+`
+
+	tests := []struct {
+		name               string
+		apiVersion         string
+		kind               string
+		apiResources       []APIResource
+		clusterScopedKinds []string
+		expectNamespaced   bool
+		expectWarnings     int
+	}{
+		{
+			name:             "built-in namespaced kind",
+			apiVersion:       "apps/v1",
+			kind:             "Deployment",
+			expectNamespaced: true,
+		},
+		{
+			name:       "built-in cluster-scoped kind",
+			apiVersion: "rbac.authorization.k8s.io/v1",
+			kind:       "ClusterRole",
+		},
+		{
+			name:       "cluster-scoped CRD",
+			apiVersion: "cert-manager.io/v1",
+			kind:       "ClusterIssuer",
+		},
+		{
+			name:             "namespaced CRD",
+			apiVersion:       "cert-manager.io/v1",
+			kind:             "Certificate",
+			expectNamespaced: true,
+		},
+		{
+			name:             "api resources win over CRDs",
+			apiVersion:       "cert-manager.io/v1",
+			kind:             "ClusterIssuer",
+			apiResources:     []APIResource{{APIVersion: "cert-manager.io/v1", Kind: "ClusterIssuer", Namespaced: true}},
+			expectNamespaced: true,
+		},
+		{
+			name:               "additional cluster-scoped kind",
+			apiVersion:         "example.com/v1",
+			kind:               "ClusterWidget",
+			clusterScopedKinds: []string{"ClusterWidget"},
+		},
+		{
+			name:             "unknown kind",
+			apiVersion:       "example.com/v1",
+			kind:             "Widget",
+			expectNamespaced: true,
+			expectWarnings:   1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			var warnings int
+			logger := funcr.New(func(prefix, args string) {
+				warnings++
+			}, funcr.Options{})
+
+			m, err := resmap.NewFactory(provider.NewDefaultDepProvider().GetResourceFactory()).NewResMapFromBytes([]byte(crds))
+			g.Expect(err).ToNot(HaveOccurred())
+
+			scopes := NewScopes(logger, tt.apiResources, tt.clusterScopedKinds...)
+			scopes.AddCRDs(m.Resources()...)
+
+			g.Expect(scopes.IsNamespaced(tt.apiVersion, tt.kind)).To(Equal(tt.expectNamespaced))
+			// Unknown kinds are only reported once
+			g.Expect(scopes.IsNamespaced(tt.apiVersion, tt.kind)).To(Equal(tt.expectNamespaced))
+			g.Expect(warnings).To(Equal(tt.expectWarnings))
+		})
+	}
+}
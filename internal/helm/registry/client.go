@@ -17,9 +17,12 @@ limitations under the License.
 package registry
 
 import (
+	"crypto/tls"
 	"io"
+	"net/http"
 	"os"
 
+	"github.com/doodlescheduling/flux-build/internal/transport"
 	"helm.sh/helm/v3/pkg/registry"
 	"k8s.io/apimachinery/pkg/util/errors"
 )
@@ -27,7 +30,11 @@ import (
 // ClientGenerator generates a registry client and a temporary credential file.
 // The client is meant to be used for a single reconciliation.
 // The file is meant to be used for a single reconciliation and deleted after.
-func ClientGenerator(isLogin bool) (*registry.Client, string, error) {
+// If insecureHTTP is set the client talks plain HTTP to the registry.
+// If tlsConfig is set it is used for the connections to the registry.
+// If proxy is set the connections to the registry go through it, otherwise the proxy is configured from the environment.
+// If transports is set the client uses its transport of tlsConfig and proxy is ignored.
+func ClientGenerator(tlsConfig *tls.Config, proxy transport.ProxyFunc, transports *transport.Shared, isLogin, insecureHTTP bool) (*registry.Client, string, error) {
 	if isLogin {
 		// create a temporary file to store the credentials
 		// this is needed because otherwise the credentials are stored in ~/.docker/config.json.
@@ -37,7 +44,7 @@ func ClientGenerator(isLogin bool) (*registry.Client, string, error) {
 		}
 
 		var errs []error
-		rClient, err := registry.NewClient(registry.ClientOptWriter(io.Discard), registry.ClientOptCredentialsFile(credentialsFile.Name()))
+		rClient, err := newClient(credentialsFile.Name(), tlsConfig, proxy, transports, insecureHTTP)
 		if err != nil {
 			errs = append(errs, err)
 			// attempt to delete the temporary file
@@ -52,9 +59,37 @@ func ClientGenerator(isLogin bool) (*registry.Client, string, error) {
 		return rClient, credentialsFile.Name(), nil
 	}
 
-	rClient, err := registry.NewClient(registry.ClientOptWriter(io.Discard))
+	rClient, err := newClient("", tlsConfig, proxy, transports, insecureHTTP)
 	if err != nil {
 		return nil, "", err
 	}
 	return rClient, "", nil
 }
+
+func newClient(credentialsFile string, tlsConfig *tls.Config, proxy transport.ProxyFunc, transports *transport.Shared, insecureHTTP bool) (*registry.Client, error) {
+	opts := []registry.ClientOption{
+		registry.ClientOptWriter(io.Discard),
+	}
+	if transports != nil {
+		opts = append(opts, registry.ClientOptHTTPClient(&http.Client{
+			Transport: transports.Get(tlsConfig),
+		}))
+	} else if tlsConfig != nil || proxy != nil {
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.TLSClientConfig = tlsConfig
+		if proxy != nil {
+			t.Proxy = proxy
+		}
+		opts = append(opts, registry.ClientOptHTTPClient(&http.Client{
+			Transport: t,
+		}))
+	}
+	if insecureHTTP {
+		opts = append(opts, registry.ClientOptPlainHTTP())
+	}
+	if credentialsFile != "" {
+		opts = append(opts, registry.ClientOptCredentialsFile(credentialsFile))
+	}
+
+	return registry.NewClient(opts...)
+}
@@ -19,7 +19,9 @@ package repository
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"crypto/tls"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -27,9 +29,12 @@ import (
 	"os"
 	"path"
 	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/Masterminds/semver/v3"
+	"github.com/go-logr/logr"
 	"github.com/opencontainers/go-digest"
 	"helm.sh/helm/v3/pkg/chart"
 	"helm.sh/helm/v3/pkg/getter"
@@ -119,6 +124,16 @@ type ChartRepository struct {
 	// Options to configure the Client with while downloading the Index
 	// or a chart from the URL.
 	Options []getter.Option
+	// Proxy is used for the requests to the URL, the proxy is configured
+	// from the environment if nil.
+	Proxy transport.ProxyFunc
+	// Transports are used for the requests to the URL if set, their proxy
+	// takes precedence over Proxy. A transport of the TransportPool is used
+	// otherwise.
+	Transports *transport.Shared
+	// Logger receives the duration of index downloads at V(1), nothing is
+	// logged if it is unset.
+	Logger logr.Logger
 
 	tlsConfig *tls.Config
 
@@ -182,7 +197,13 @@ func (r *ChartRepository) getChartVersion(name, ver string) (*repo.ChartVersion,
 	if r.Index == nil {
 		return nil, ErrNoChartIndex
 	}
-	cvs, ok := r.Index.Entries[name]
+	return findChartVersion(r.Index, name, ver)
+}
+
+// findChartVersion returns the latest version of the chart in the index satisfying the semver constraint ver, an
+// exact match of ver takes precedence.
+func findChartVersion(index *repo.IndexFile, name, ver string) (*repo.ChartVersion, error) {
+	cvs, ok := index.Entries[name]
 	if !ok {
 		return nil, repo.ErrNoChartName
 	}
@@ -273,13 +294,50 @@ func (r *ChartRepository) DownloadChart(chart *repo.ChartVersion) (*bytes.Buffer
 		return nil, err
 	}
 
-	t := transport.NewOrIdle(r.tlsConfig)
-	clientOpts := append(r.Options, getter.WithTransport(t))
-	defer func() {
-		_ = transport.Release(t)
-	}()
+	t, release := transport.Acquire(r.Transports, r.tlsConfig, r.Proxy)
+	recording, responses := recordResponses(t)
+	clientOpts := append(r.Options, getter.WithTransport(recording))
+	defer release()
+
+	res, err := r.Client.Get(resolvedUrl, clientOpts...)
+	if err := responses.check(resolvedUrl, fmt.Sprintf("chart '%s'", chart.Name), res, err); err != nil {
+		return nil, err
+	}
 
-	return r.Client.Get(resolvedUrl, clientOpts...)
+	if err := r.verifyDigest(chart, res.Bytes()); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// verifyDigest compares the sha256 digest of a downloaded chart with the digest of its index entry.
+// Index entries without a digest are not verified.
+func (r *ChartRepository) verifyDigest(chart *repo.ChartVersion, b []byte) error {
+	return verifyChartDigest(r.URL, chart, b)
+}
+
+// verifyChartDigest compares the sha256 digest of a chart downloaded from the repository with the digest of its
+// version, versions without a digest are not verified.
+func verifyChartDigest(repositoryURL string, chart *repo.ChartVersion, b []byte) error {
+	expected := strings.TrimPrefix(chart.Digest, string(digest.SHA256)+":")
+	if expected == "" {
+		return nil
+	}
+
+	sum := sha256.Sum256(b)
+	actual := hex.EncodeToString(sum[:])
+	if !strings.EqualFold(expected, actual) {
+		return &ErrDigestMismatch{
+			Repository: repositoryURL,
+			Chart:      chart.Name,
+			Version:    chart.Version,
+			Expected:   expected,
+			Actual:     actual,
+		}
+	}
+
+	return nil
 }
 
 // CacheIndex attempts to write the index from the remote into a new temporary file
@@ -292,6 +350,7 @@ func (r *ChartRepository) CacheIndex() error {
 		return fmt.Errorf("failed to create temp file to cache index to: %w", err)
 	}
 
+	start := time.Now()
 	if err = r.DownloadIndex(f); err != nil {
 		f.Close()
 		os.Remove(f.Name())
@@ -301,6 +360,7 @@ func (r *ChartRepository) CacheIndex() error {
 		os.Remove(f.Name())
 		return fmt.Errorf("failed to close cached index file '%s': %w", f.Name(), err)
 	}
+	r.Logger.V(1).Info("fetched chart repository index", "url", r.URL, "duration", time.Since(start))
 
 	r.Lock()
 	r.Path = f.Name()
@@ -367,15 +427,14 @@ func (r *ChartRepository) DownloadIndex(w io.Writer) (err error) {
 	u.RawPath = path.Join(u.RawPath, "index.yaml")
 	u.Path = path.Join(u.Path, "index.yaml")
 
-	t := transport.NewOrIdle(r.tlsConfig)
-	clientOpts := append(r.Options, getter.WithTransport(t))
-	defer func() {
-		_ = transport.Release(t)
-	}()
+	t, release := transport.Acquire(r.Transports, r.tlsConfig, r.Proxy)
+	recording, responses := recordResponses(t)
+	clientOpts := append(r.Options, getter.WithTransport(recording))
+	defer release()
 
 	var res *bytes.Buffer
 	res, err = r.Client.Get(u.String(), clientOpts...)
-	if err != nil {
+	if err = responses.check(u.String(), "the repository index", res, err); err != nil {
 		return err
 	}
 	if _, err = io.Copy(w, res); err != nil {
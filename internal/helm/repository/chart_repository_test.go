@@ -18,12 +18,19 @@ package repository
 
 import (
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -34,6 +41,7 @@ import (
 	"helm.sh/helm/v3/pkg/repo"
 
 	"github.com/doodlescheduling/flux-build/internal/helm"
+	"github.com/doodlescheduling/flux-build/internal/transport"
 )
 
 var now = time.Now()
@@ -334,8 +342,9 @@ func TestChartRepository_DownloadChart(t *testing.T) {
 		name         string
 		url          string
 		chartVersion *repo.ChartVersion
+		response     []byte
 		wantURL      string
-		wantErr      bool
+		wantErr      string
 	}{
 		{
 			name: "relative URL",
@@ -346,10 +355,43 @@ func TestChartRepository_DownloadChart(t *testing.T) {
 			},
 			wantURL: "https://example.com/charts/foo-1.0.0.tgz",
 		},
+		{
+			name: "matching digest",
+			url:  "https://example.com",
+			chartVersion: &repo.ChartVersion{
+				Metadata: &chart.Metadata{Name: "chart", Version: "1.0.0"},
+				URLs:     []string{"charts/foo-1.0.0.tgz"},
+				Digest:   "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae",
+			},
+			response: []byte("foo"),
+			wantURL:  "https://example.com/charts/foo-1.0.0.tgz",
+		},
+		{
+			name: "matching prefixed digest",
+			url:  "https://example.com",
+			chartVersion: &repo.ChartVersion{
+				Metadata: &chart.Metadata{Name: "chart", Version: "1.0.0"},
+				URLs:     []string{"charts/foo-1.0.0.tgz"},
+				Digest:   "sha256:2C26B46B68FFC68FF99B453C1D30413413422D706483BFA0F98A5E886266E7AE",
+			},
+			response: []byte("foo"),
+			wantURL:  "https://example.com/charts/foo-1.0.0.tgz",
+		},
+		{
+			name: "digest mismatch",
+			url:  "https://example.com",
+			chartVersion: &repo.ChartVersion{
+				Metadata: &chart.Metadata{Name: "chart", Version: "1.0.0"},
+				URLs:     []string{"charts/foo-1.0.0.tgz"},
+				Digest:   "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae",
+			},
+			response: []byte("bar"),
+			wantErr:  "digest mismatch of chart 'chart' version '1.0.0' from repository 'https://example.com': expected sha256 '2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae', got 'fcde2b2edba56bf408601fb721fe9b5c338d10ee429ea04fae5511b68fbf8fb9'",
+		},
 		{
 			name:         "no chart URL",
 			chartVersion: &repo.ChartVersion{Metadata: &chart.Metadata{Name: "chart"}},
-			wantErr:      true,
+			wantErr:      "chart 'chart' has no downloadable URLs",
 		},
 		{
 			name: "invalid chart URL",
@@ -357,7 +399,7 @@ func TestChartRepository_DownloadChart(t *testing.T) {
 				Metadata: &chart.Metadata{Name: "chart"},
 				URLs:     []string{"https://ex ample.com/charts/foo-1.0.0.tgz"},
 			},
-			wantErr: true,
+			wantErr: "invalid character",
 		},
 	}
 	for _, tt := range tests {
@@ -366,14 +408,14 @@ func TestChartRepository_DownloadChart(t *testing.T) {
 			g := NewWithT(t)
 			t.Parallel()
 
-			mg := mockGetter{}
+			mg := mockGetter{Response: tt.response}
 			r := &ChartRepository{
 				URL:    tt.url,
 				Client: &mg,
 			}
 			res, err := r.DownloadChart(tt.chartVersion)
-			if tt.wantErr {
-				g.Expect(err).To(HaveOccurred())
+			if tt.wantErr != "" {
+				g.Expect(err).To(MatchError(ContainSubstring(tt.wantErr)))
 				g.Expect(res).To(BeNil())
 				return
 			}
@@ -427,6 +469,187 @@ func TestChartRepository_DownloadIndex(t *testing.T) {
 	g.Expect(err).To(BeNil())
 }
 
+func TestChartRepository_UnexpectedResponses(t *testing.T) {
+	index, err := os.ReadFile(chartmuseumTestFile)
+	NewWithT(t).Expect(err).ToNot(HaveOccurred())
+
+	html := func(status int) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte("\n<!DOCTYPE html>\n<html><body>Welcome to nginx!</body></html>\n"))
+		}
+	}
+
+	tests := []struct {
+		name      string
+		handler   http.HandlerFunc
+		chart     bool
+		expectErr func(url string) string
+		errType   error
+	}{
+		{
+			name: "index",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				_, _ = w.Write(index)
+			},
+		},
+		{
+			name:    "HTML page instead of the index",
+			handler: html(http.StatusOK),
+			expectErr: func(url string) string {
+				return "expected the repository index from '" + url + "/index.yaml' but got an HTML page (status 200, content type 'text/html; charset=utf-8') starting with '<!DOCTYPE html>'"
+			},
+			errType: &ErrUnexpectedContent{},
+		},
+		{
+			name: "HTML page without content type",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/octet-stream")
+				_, _ = w.Write([]byte("<html><head><title>Error</title></head></html>"))
+			},
+			expectErr: func(url string) string {
+				return "got an HTML page (status 200, content type 'application/octet-stream') starting with '<html><head><title>Error</title></head></html>'"
+			},
+			errType: &ErrUnexpectedContent{},
+		},
+		{
+			name:    "HTML error page",
+			handler: html(http.StatusBadGateway),
+			expectErr: func(url string) string {
+				return "got an HTML page (status 502, content type 'text/html; charset=utf-8')"
+			},
+			errType: &ErrUnexpectedContent{},
+		},
+		{
+			name:    "not found",
+			handler: http.NotFound,
+			expectErr: func(url string) string {
+				return "failed to fetch " + url + "/index.yaml : 404 Not Found"
+			},
+		},
+		{
+			name: "redirect loop",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				target := "/index.yaml"
+				if r.URL.Path == target {
+					target = "/mirror/index.yaml"
+				}
+				http.Redirect(w, r, target, http.StatusFound)
+			},
+			expectErr: func(url string) string {
+				return "redirect loop fetching '" + url + "/index.yaml': " + url + "/index.yaml -> " + url + "/mirror/index.yaml -> " + url + "/index.yaml"
+			},
+			errType: &ErrRedirectLoop{},
+		},
+		{
+			name: "redirect to a login page",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/login" {
+					html(http.StatusOK)(w, r)
+					return
+				}
+				http.Redirect(w, r, "/login?next="+r.URL.Path, http.StatusFound)
+			},
+			expectErr: func(url string) string {
+				return "'" + url + "/index.yaml' was redirected (status 302) to the HTML page '" + url + "/login?next=/index.yaml' (content type 'text/html; charset=utf-8') starting with '<!DOCTYPE html>', the repository likely requires a login"
+			},
+			errType: &ErrAuthRedirect{},
+		},
+		{
+			name:    "HTML page instead of the chart",
+			handler: html(http.StatusOK),
+			chart:   true,
+			expectErr: func(url string) string {
+				return "expected chart 'foo' from '" + url + "/charts/foo-1.0.0.tgz' but got an HTML page (status 200"
+			},
+			errType: &ErrUnexpectedContent{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			server := httptest.NewServer(tt.handler)
+			defer server.Close()
+
+			providers := helmgetter.Providers{
+				helmgetter.Provider{
+					Schemes: []string{"http"},
+					New:     helmgetter.NewHTTPGetter,
+				},
+			}
+			r, err := NewChartRepository(server.URL, "", providers, nil, helmgetter.WithURL(server.URL))
+			g.Expect(err).ToNot(HaveOccurred())
+
+			if tt.chart {
+				_, err = r.DownloadChart(&repo.ChartVersion{
+					Metadata: &chart.Metadata{Name: "foo", Version: "1.0.0"},
+					URLs:     []string{"charts/foo-1.0.0.tgz"},
+				})
+			} else {
+				err = r.DownloadIndex(&bytes.Buffer{})
+			}
+
+			if tt.expectErr == nil {
+				g.Expect(err).ToNot(HaveOccurred())
+				return
+			}
+
+			g.Expect(err).To(MatchError(ContainSubstring(tt.expectErr(server.URL))))
+			if tt.errType != nil {
+				g.Expect(err).To(BeAssignableToTypeOf(tt.errType))
+			}
+		})
+	}
+}
+
+func TestChartRepository_SharedTransports(t *testing.T) {
+	g := NewWithT(t)
+
+	var connections atomic.Int64
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("apiVersion: v1\nentries: {}\n"))
+	}))
+	server.EnableHTTP2 = true
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			connections.Add(1)
+		}
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	providers := helmgetter.Providers{
+		helmgetter.Provider{
+			Schemes: []string{"https"},
+			New:     helmgetter.NewHTTPGetter,
+		},
+	}
+
+	// Repositories of a host with equal TLS settings share a connection
+	transports := transport.NewShared(transport.SharedOpts{})
+	for i := 0; i < 5; i++ {
+		pool := x509.NewCertPool()
+		pool.AddCert(server.Certificate())
+
+		url := fmt.Sprintf("%s/repository-%d", server.URL, i)
+		r, err := NewChartRepository(url, "", providers, &tls.Config{RootCAs: pool}, helmgetter.WithURL(url))
+		g.Expect(err).ToNot(HaveOccurred())
+		r.Transports = transports
+
+		g.Expect(r.DownloadIndex(&bytes.Buffer{})).To(Succeed())
+	}
+
+	g.Expect(connections.Load()).To(Equal(int64(1)))
+	g.Expect(logs.String()).To(BeEmpty())
+}
+
 func TestChartRepository_StrategicallyLoadIndex(t *testing.T) {
 	t.Run("loads from path", func(t *testing.T) {
 		g := NewWithT(t)
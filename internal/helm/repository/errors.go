@@ -16,6 +16,11 @@ limitations under the License.
 
 package repository
 
+import (
+	"fmt"
+	"strings"
+)
+
 // ErrReference indicate invalid chart reference.
 type ErrReference struct {
 	Err error
@@ -45,3 +50,61 @@ func (ee *ErrExternal) Error() string {
 func (ee *ErrExternal) Unwrap() error {
 	return ee.Err
 }
+
+// ErrDigestMismatch indicates a downloaded chart which doesn't match the digest of the repository index.
+type ErrDigestMismatch struct {
+	Repository string
+	Chart      string
+	Version    string
+	Expected   string
+	Actual     string
+}
+
+// Error implements the error interface.
+func (ed *ErrDigestMismatch) Error() string {
+	return fmt.Sprintf("digest mismatch of chart '%s' version '%s' from repository '%s': expected sha256 '%s', got '%s'",
+		ed.Chart, ed.Version, ed.Repository, ed.Expected, ed.Actual)
+}
+
+// ErrUnexpectedContent indicates a repository responding with an HTML page instead of the requested document, for
+// instance the error page of a misconfigured ingress.
+type ErrUnexpectedContent struct {
+	URL         string
+	Document    string
+	StatusCode  int
+	ContentType string
+	FirstLine   string
+}
+
+// Error implements the error interface.
+func (eu *ErrUnexpectedContent) Error() string {
+	return fmt.Sprintf("expected %s from '%s' but got an HTML page (status %d, content type '%s') starting with '%s', check the repository URL and any ingress or proxy in front of the repository",
+		eu.Document, eu.URL, eu.StatusCode, eu.ContentType, eu.FirstLine)
+}
+
+// ErrRedirectLoop indicates a download which was redirected in a loop or more often than followed.
+type ErrRedirectLoop struct {
+	URL       string
+	Redirects []string
+}
+
+// Error implements the error interface.
+func (er *ErrRedirectLoop) Error() string {
+	return fmt.Sprintf("redirect loop fetching '%s': %s", er.URL, strings.Join(er.Redirects, " -> "))
+}
+
+// ErrAuthRedirect indicates a download which was redirected to an HTML page, usually the login page of an
+// authentication portal in front of the repository.
+type ErrAuthRedirect struct {
+	URL         string
+	StatusCode  int
+	Location    string
+	ContentType string
+	FirstLine   string
+}
+
+// Error implements the error interface.
+func (ea *ErrAuthRedirect) Error() string {
+	return fmt.Sprintf("'%s' was redirected (status %d) to the HTML page '%s' (content type '%s') starting with '%s', the repository likely requires a login through an authentication portal, configure credentials the repository accepts without redirect",
+		ea.URL, ea.StatusCode, ea.Location, ea.ContentType, ea.FirstLine)
+}
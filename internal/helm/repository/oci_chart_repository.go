@@ -34,6 +34,7 @@ import (
 
 	"github.com/Masterminds/semver/v3"
 	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
 
 	"github.com/doodlescheduling/flux-build/internal/oci"
 	"github.com/doodlescheduling/flux-build/internal/transport"
@@ -60,6 +61,12 @@ type OCIChartRepository struct {
 	// Options to configure the Client with while downloading tags
 	// or a chart from the URL.
 	Options []getter.Option
+	// Proxy is used for the requests to the repository, the proxy is configured
+	// from the environment if nil.
+	Proxy transport.ProxyFunc
+	// Transports are used for the requests to the repository if set, their
+	// proxy takes precedence over Proxy.
+	Transports *transport.Shared
 
 	tlsConfig *tls.Config
 
@@ -70,6 +77,8 @@ type OCIChartRepository struct {
 
 	// verifiers is a list of verifiers to use when verifying a chart.
 	verifiers []oci.Verifier
+	// remoteOptions are the options used by verifiers to access the registry.
+	remoteOptions []remote.Option
 }
 
 // OCIChartRepositoryOption is a function that can be passed to NewOCIChartRepository
@@ -84,6 +93,28 @@ func WithVerifiers(verifiers []oci.Verifier) OCIChartRepositoryOption {
 	}
 }
 
+// WithOCIRemoteOptions returns a ChartRepositoryOption that will set the remote options
+// which are used by verifiers to access the registry.
+func WithOCIRemoteOptions(opts ...remote.Option) OCIChartRepositoryOption {
+	return func(r *OCIChartRepository) error {
+		r.remoteOptions = opts
+		return nil
+	}
+}
+
+// RemoteOptions returns the remote options to access the registry.
+func (r *OCIChartRepository) RemoteOptions() []remote.Option {
+	return r.remoteOptions
+}
+
+// WithChartVerifiers returns a copy of the repository which verifies charts using the given verifiers.
+// The repository itself is left untouched which allows sharing it between charts with different verifiers.
+func (r *OCIChartRepository) WithChartVerifiers(verifiers []oci.Verifier) *OCIChartRepository {
+	clone := *r
+	clone.verifiers = verifiers
+	return &clone
+}
+
 // WithOCIRegistryClient returns a ChartRepositoryOption that will set the registry client
 func WithOCIRegistryClient(client RegistryClient) OCIChartRepositoryOption {
 	return func(r *OCIChartRepository) error {
@@ -104,6 +135,34 @@ func WithOCIGetter(providers getter.Providers) OCIChartRepositoryOption {
 	}
 }
 
+// WithOCIProxy returns a ChartRepositoryOption that will set the proxy
+// used for the requests to the repository.
+func WithOCIProxy(proxy transport.ProxyFunc) OCIChartRepositoryOption {
+	return func(r *OCIChartRepository) error {
+		r.Proxy = proxy
+		return nil
+	}
+}
+
+// WithOCITransports returns a ChartRepositoryOption that will set the shared
+// transports used for the requests to the repository.
+func WithOCITransports(transports *transport.Shared) OCIChartRepositoryOption {
+	return func(r *OCIChartRepository) error {
+		r.Transports = transports
+		return nil
+	}
+}
+
+// WithOCITLSConfig returns a ChartRepositoryOption that will set the TLS
+// configuration, for instance with a client certificate, of the requests to the
+// repository.
+func WithOCITLSConfig(tlsConfig *tls.Config) OCIChartRepositoryOption {
+	return func(r *OCIChartRepository) error {
+		r.tlsConfig = tlsConfig
+		return nil
+	}
+}
+
 // WithOCIGetterOptions returns a ChartRepositoryOption that will set the getter.Options
 func WithOCIGetterOptions(getterOpts []getter.Option) OCIChartRepositoryOption {
 	return func(r *OCIChartRepository) error {
@@ -226,11 +285,9 @@ func (r *OCIChartRepository) DownloadChart(chart *repo.ChartVersion) (*bytes.Buf
 		return nil, err
 	}
 
-	t := transport.NewOrIdle(r.tlsConfig)
+	t, release := transport.Acquire(r.Transports, r.tlsConfig, r.Proxy)
 	clientOpts := append(r.Options, getter.WithTransport(t))
-	defer func() {
-		_ = transport.Release(t)
-	}()
+	defer release()
 
 	// trim the oci scheme prefix if needed
 	b, err := r.Client.Get(strings.TrimPrefix(u.String(), fmt.Sprintf("%s://", registry.OCIScheme)), clientOpts...)
@@ -0,0 +1,395 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fluxcd/pkg/version"
+	"github.com/go-logr/logr"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/repo"
+
+	"github.com/doodlescheduling/flux-build/internal/transport"
+)
+
+// ReleaseAssetProvider is the forge a ReleaseAssetRepository lists the releases of a project from.
+type ReleaseAssetProvider string
+
+const (
+	// ReleaseAssetProviderGitHub lists the releases of a GitHub or GitHub Enterprise repository.
+	ReleaseAssetProviderGitHub ReleaseAssetProvider = "github-releases"
+	// ReleaseAssetProviderGitLab lists the releases of a GitLab project.
+	ReleaseAssetProviderGitLab ReleaseAssetProvider = "gitlab-releases"
+)
+
+// DefaultReleaseAsset is the name of the chart archive attached to a release if none is configured.
+const DefaultReleaseAsset = "{chart}-{version}.tgz"
+
+// releasesPerPage is the number of releases requested per page of the releases API.
+const releasesPerPage = 100
+
+// maxReleasePages limits the pages of releases listed of a single project.
+const maxReleasePages = 50
+
+// ErrUnknownReleaseAssetProvider is returned for a provider of release assets which is not supported.
+var ErrUnknownReleaseAssetProvider = errors.New("unknown release asset provider")
+
+// ParseReleaseAssetProvider returns the ReleaseAssetProvider named s.
+func ParseReleaseAssetProvider(s string) (ReleaseAssetProvider, error) {
+	switch provider := ReleaseAssetProvider(s); provider {
+	case ReleaseAssetProviderGitHub, ReleaseAssetProviderGitLab:
+		return provider, nil
+	}
+
+	return "", fmt.Errorf("%w `%s`, expected one of %s, %s", ErrUnknownReleaseAssetProvider, s, ReleaseAssetProviderGitHub, ReleaseAssetProviderGitLab)
+}
+
+// ReleaseAssetRepository is a chart repository without an index whose charts are attached as archives to the releases
+// of a GitHub or GitLab project. The tags of the releases are the chart versions, a leading `<chart>-` and `v` are
+// stripped and tags which are no semver are skipped. The releases are listed once and shared by all charts.
+// All methods are thread safe.
+type ReleaseAssetRepository struct {
+	// URL is the URL of the project, for instance https://github.com/org/charts.
+	URL string
+	// Provider is the forge of the project.
+	Provider ReleaseAssetProvider
+	// Asset is the name of the chart archive attached to the releases, {chart} and {version} are replaced by the
+	// name and the version of the chart. DefaultReleaseAsset is used if it is empty.
+	Asset string
+	// Token authenticates the requests to the API of the forge, the releases of public projects are listed
+	// anonymously if it is empty.
+	Token string
+	// Timeout limits every request, zero disables the timeout.
+	Timeout time.Duration
+	// Proxy is used for the requests to the API, the proxy is configured from the environment if nil.
+	Proxy transport.ProxyFunc
+	// Transports are used for the requests to the API if set, their proxy takes precedence over Proxy.
+	Transports *transport.Shared
+	// Logger receives the duration of listing the releases at V(1), nothing is logged if it is unset.
+	Logger logr.Logger
+
+	tlsConfig *tls.Config
+	apiURL    string
+
+	mu       sync.Mutex
+	listed   bool
+	releases []releaseAssets
+}
+
+// releaseAssets are the assets of a release by their name.
+type releaseAssets struct {
+	tag    string
+	assets map[string]releaseAsset
+}
+
+// releaseAsset is a file attached to a release.
+type releaseAsset struct {
+	url    string
+	digest string
+}
+
+// NewReleaseAssetRepository returns a repository of the release assets of the project at projectURL.
+func NewReleaseAssetRepository(projectURL string, provider ReleaseAssetProvider, tlsConfig *tls.Config) (*ReleaseAssetRepository, error) {
+	apiURL, err := releasesAPIURL(projectURL, provider)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ReleaseAssetRepository{
+		URL:       projectURL,
+		Provider:  provider,
+		tlsConfig: tlsConfig,
+		apiURL:    apiURL,
+	}, nil
+}
+
+// releasesAPIURL returns the URL of the releases API of a project. Projects of github.com are listed from
+// api.github.com, other GitHub hosts are expected to be GitHub Enterprise servers.
+func releasesAPIURL(projectURL string, provider ReleaseAssetProvider) (string, error) {
+	u, err := url.Parse(projectURL)
+	if err != nil {
+		return "", err
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("invalid release asset repository URL `%s`, expected an http or https URL of a project", projectURL)
+	}
+
+	project := strings.TrimSuffix(strings.Trim(u.Path, "/"), ".git")
+	segments := strings.Split(project, "/")
+
+	switch provider {
+	case ReleaseAssetProviderGitHub:
+		if len(segments) != 2 || segments[0] == "" {
+			return "", fmt.Errorf("invalid github repository URL `%s`, expected https://<host>/<owner>/<repository>", projectURL)
+		}
+
+		if u.Host == "github.com" || u.Host == "www.github.com" {
+			return "https://api.github.com/repos/" + project + "/releases", nil
+		}
+
+		return u.Scheme + "://" + u.Host + "/api/v3/repos/" + project + "/releases", nil
+	case ReleaseAssetProviderGitLab:
+		if len(segments) < 2 || slices.Contains(segments, "") {
+			return "", fmt.Errorf("invalid gitlab project URL `%s`, expected https://<host>/<group>/<project>", projectURL)
+		}
+
+		return u.Scheme + "://" + u.Host + "/api/v4/projects/" + url.PathEscape(project) + "/releases", nil
+	}
+
+	return "", fmt.Errorf("%w `%s`", ErrUnknownReleaseAssetProvider, provider)
+}
+
+// GetChartVersion returns the repo.ChartVersion of the release whose tag matches the version and which has the asset
+// of the chart attached. The version is expected to be a semver.Constraints compatible string, if it is empty the
+// latest stable version is returned.
+func (r *ReleaseAssetRepository) GetChartVersion(name, ver string) (*repo.ChartVersion, error) {
+	releases, err := r.listedReleases()
+	if err != nil {
+		return nil, &ErrExternal{Err: err}
+	}
+
+	index := repo.NewIndexFile()
+	for _, release := range releases {
+		chartVersion := tagVersion(name, release.tag)
+		if chartVersion == "" {
+			continue
+		}
+
+		asset, ok := release.assets[r.assetName(name, chartVersion)]
+		if !ok {
+			continue
+		}
+
+		index.Entries[name] = append(index.Entries[name], &repo.ChartVersion{
+			Metadata: &chart.Metadata{Name: name, Version: chartVersion},
+			URLs:     []string{asset.url},
+			Digest:   asset.digest,
+		})
+	}
+
+	if len(index.Entries[name]) == 0 {
+		return nil, &ErrReference{Err: fmt.Errorf("no release of `%s` has the asset `%s` attached", r.URL, r.assetName(name, "<version>"))}
+	}
+
+	cv, err := findChartVersion(index, name, ver)
+	if err != nil {
+		return nil, &ErrReference{Err: err}
+	}
+	return cv, nil
+}
+
+// tagVersion returns the chart version of a release tag like 1.2.0, v1.2.0 or <chart>-1.2.0, it is empty for tags
+// which are no semver.
+func tagVersion(name, tag string) string {
+	v := strings.TrimPrefix(strings.TrimPrefix(tag, name+"-"), "v")
+	if _, err := version.ParseVersion(v); err != nil {
+		return ""
+	}
+
+	return v
+}
+
+// assetName returns the name of the asset of a chart version.
+func (r *ReleaseAssetRepository) assetName(name, chartVersion string) string {
+	asset := r.Asset
+	if asset == "" {
+		asset = DefaultReleaseAsset
+	}
+
+	return strings.NewReplacer("{chart}", name, "{version}", chartVersion).Replace(asset)
+}
+
+// listedReleases returns the releases of the project, they are listed by the first call. Failures are not kept and
+// the next call, for instance a retry, lists the releases again.
+func (r *ReleaseAssetRepository) listedReleases() ([]releaseAssets, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.listed {
+		releases, err := r.listReleases()
+		if err != nil {
+			return nil, err
+		}
+
+		r.releases, r.listed = releases, true
+	}
+
+	return r.releases, nil
+}
+
+// listReleases lists all published releases of the project page by page.
+func (r *ReleaseAssetRepository) listReleases() ([]releaseAssets, error) {
+	start := time.Now()
+
+	var releases []releaseAssets
+	for page := 1; page <= maxReleasePages; page++ {
+		u := r.apiURL + "?per_page=" + strconv.Itoa(releasesPerPage) + "&page=" + strconv.Itoa(page)
+		body, err := r.get(u, "application/json")
+		if err != nil {
+			return nil, fmt.Errorf("failed to list releases of `%s`: %w", r.URL, err)
+		}
+
+		n, err := r.decodeReleases(body.Bytes(), &releases)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode releases of `%s` from %s: %w", r.URL, u, err)
+		}
+
+		if n < releasesPerPage {
+			break
+		}
+	}
+
+	r.Logger.V(1).Info("listed releases", "url", r.URL, "releases", len(releases), "duration", time.Since(start))
+	return releases, nil
+}
+
+// decodeReleases appends the published releases of a page of the releases API to releases and returns the number of
+// releases of the page.
+func (r *ReleaseAssetRepository) decodeReleases(b []byte, releases *[]releaseAssets) (int, error) {
+	switch r.Provider {
+	case ReleaseAssetProviderGitLab:
+		var page []struct {
+			TagName         string `json:"tag_name"`
+			UpcomingRelease bool   `json:"upcoming_release"`
+			Assets          struct {
+				Links []struct {
+					Name           string `json:"name"`
+					URL            string `json:"url"`
+					DirectAssetURL string `json:"direct_asset_url"`
+				} `json:"links"`
+			} `json:"assets"`
+		}
+		if err := json.Unmarshal(b, &page); err != nil {
+			return 0, err
+		}
+
+		for _, release := range page {
+			if release.UpcomingRelease {
+				continue
+			}
+
+			assets := make(map[string]releaseAsset, len(release.Assets.Links))
+			for _, link := range release.Assets.Links {
+				assetURL := link.DirectAssetURL
+				if assetURL == "" {
+					assetURL = link.URL
+				}
+				assets[link.Name] = releaseAsset{url: assetURL}
+			}
+			*releases = append(*releases, releaseAssets{tag: release.TagName, assets: assets})
+		}
+
+		return len(page), nil
+	default:
+		var page []struct {
+			TagName string `json:"tag_name"`
+			Draft   bool   `json:"draft"`
+			Assets  []struct {
+				Name   string `json:"name"`
+				URL    string `json:"url"`
+				Digest string `json:"digest"`
+			} `json:"assets"`
+		}
+		if err := json.Unmarshal(b, &page); err != nil {
+			return 0, err
+		}
+
+		for _, release := range page {
+			if release.Draft {
+				continue
+			}
+
+			assets := make(map[string]releaseAsset, len(release.Assets))
+			for _, asset := range release.Assets {
+				// The browser download URL does not accept tokens, the API URL serves the asset to
+				// application/octet-stream requests
+				assets[asset.Name] = releaseAsset{url: asset.URL, digest: asset.Digest}
+			}
+			*releases = append(*releases, releaseAssets{tag: release.TagName, assets: assets})
+		}
+
+		return len(page), nil
+	}
+}
+
+// DownloadChart downloads the release asset of the chart version and verifies its digest if the forge declares one.
+func (r *ReleaseAssetRepository) DownloadChart(cv *repo.ChartVersion) (*bytes.Buffer, error) {
+	if len(cv.URLs) == 0 {
+		return nil, fmt.Errorf("chart '%s' has no downloadable URLs", cv.Name)
+	}
+
+	res, err := r.get(cv.URLs[0], "application/octet-stream")
+	if err != nil {
+		return nil, fmt.Errorf("failed to download release asset of chart '%s': %w", cv.Name, err)
+	}
+
+	if err := verifyChartDigest(r.URL, cv, res.Bytes()); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// get requests u with the token of the repository and returns the body of a successful response.
+func (r *ReleaseAssetRepository) get(u, accept string) (*bytes.Buffer, error) {
+	ctx := context.Background()
+	if r.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.Timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", accept)
+	if r.Token != "" {
+		// The http client drops the header on redirects to other hosts, for instance the storage of GitHub
+		req.Header.Set("Authorization", "Bearer "+r.Token)
+	}
+
+	t, release := transport.Acquire(r.Transports, r.tlsConfig, r.Proxy)
+	defer release()
+
+	res, err := (&http.Client{Transport: t}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var body bytes.Buffer
+	if _, err := io.Copy(&body, res.Body); err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s responded with %s: %s", u, res.Status, firstLine(body.Bytes()))
+	}
+
+	return &body, nil
+}
+
+// VerifyChart is not supported by release assets and always fails.
+func (r *ReleaseAssetRepository) VerifyChart(_ context.Context, _ *repo.ChartVersion) error {
+	return fmt.Errorf("chart verification is not supported for release assets")
+}
+
+// Clear keeps the listed releases as the repository is shared by all charts of the build.
+func (r *ReleaseAssetRepository) Clear() error {
+	return nil
+}
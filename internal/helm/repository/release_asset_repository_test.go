@@ -0,0 +1,207 @@
+package repository
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func newReleaseAssetServer(t *testing.T, provider ReleaseAssetProvider, token string) *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token != "" && r.Header.Get("Authorization") != "Bearer "+token {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"message":"Bad credentials"}`))
+			return
+		}
+
+		asset := func(name string) string {
+			return server.URL + "/assets/" + name
+		}
+
+		var releases interface{}
+		switch r.URL.EscapedPath() {
+		case "/api/v3/repos/org/charts/releases":
+			releases = []map[string]interface{}{
+				{"tag_name": "v0.1.0", "assets": []map[string]string{{"name": "app-0.1.0.tgz", "url": asset("app-0.1.0.tgz")}}},
+				{"tag_name": "app-0.2.0", "assets": []map[string]string{{"name": "app-0.2.0.tgz", "url": asset("app-0.2.0.tgz"), "digest": "sha256:" + digestOf("app-0.2.0")}}},
+				{"tag_name": "v0.3.0", "draft": true, "assets": []map[string]string{{"name": "app-0.3.0.tgz", "url": asset("app-0.3.0.tgz")}}},
+				{"tag_name": "v0.4.0", "assets": []map[string]string{{"name": "other-0.4.0.tgz", "url": asset("other-0.4.0.tgz")}}},
+				{"tag_name": "nightly", "assets": []map[string]string{{"name": "app-nightly.tgz", "url": asset("app-nightly.tgz")}}},
+				{"tag_name": "v0.5.0-rc.1", "assets": []map[string]string{{"name": "app-0.5.0-rc.1.tgz", "url": asset("app-0.5.0-rc.1.tgz"), "digest": "sha256:" + digestOf("tampered")}}},
+			}
+		case "/api/v4/projects/group%2Fcharts/releases":
+			link := func(name string) map[string]interface{} {
+				return map[string]interface{}{"links": []map[string]string{{"name": name, "url": server.URL + "/permalink", "direct_asset_url": asset(name)}}}
+			}
+			releases = []map[string]interface{}{
+				{"tag_name": "v0.1.0", "assets": link("app-0.1.0.tgz")},
+				{"tag_name": "app-0.2.0", "assets": link("app-0.2.0.tgz")},
+				{"tag_name": "v0.3.0", "upcoming_release": true, "assets": link("app-0.3.0.tgz")},
+			}
+		default:
+			if name, ok := strings.CutPrefix(r.URL.Path, "/assets/"); ok {
+				if provider == ReleaseAssetProviderGitHub && r.Header.Get("Accept") != "application/octet-stream" {
+					w.WriteHeader(http.StatusNotAcceptable)
+					return
+				}
+				_, _ = w.Write([]byte(strings.TrimSuffix(name, ".tgz")))
+				return
+			}
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(releases)
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func digestOf(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestReleaseAssetRepository(t *testing.T) {
+	tests := []struct {
+		name          string
+		provider      ReleaseAssetProvider
+		project       string
+		token         string
+		version       string
+		expectVersion string
+		expectErr     string
+	}{
+		{
+			name:          "github latest stable version",
+			provider:      ReleaseAssetProviderGitHub,
+			project:       "/org/charts",
+			token:         "secret",
+			expectVersion: "0.2.0",
+		},
+		{
+			name:          "github version constraint",
+			provider:      ReleaseAssetProviderGitHub,
+			project:       "/org/charts",
+			token:         "secret",
+			version:       "<0.2.0",
+			expectVersion: "0.1.0",
+		},
+		{
+			name:      "github drafts are skipped",
+			provider:  ReleaseAssetProviderGitHub,
+			project:   "/org/charts",
+			token:     "secret",
+			version:   "0.3.0",
+			expectErr: "no 'app' chart with version matching '0.3.0' found",
+		},
+		{
+			name:      "github digest mismatch",
+			provider:  ReleaseAssetProviderGitHub,
+			project:   "/org/charts",
+			token:     "secret",
+			version:   "0.5.0-rc.1",
+			expectErr: "digest mismatch",
+		},
+		{
+			name:          "gitlab nested project",
+			provider:      ReleaseAssetProviderGitLab,
+			project:       "/group/charts",
+			version:       ">=0.1.0",
+			expectVersion: "0.2.0",
+		},
+		{
+			name:      "unknown project",
+			provider:  ReleaseAssetProviderGitLab,
+			project:   "/group/unknown",
+			expectErr: "failed to list releases",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			server := newReleaseAssetServer(t, tt.provider, tt.token)
+
+			r, err := NewReleaseAssetRepository(server.URL+tt.project, tt.provider, nil)
+			g.Expect(err).ToNot(HaveOccurred())
+			r.Token = tt.token
+
+			cv, err := r.GetChartVersion("app", tt.version)
+			if err == nil {
+				_, err = r.DownloadChart(cv)
+			}
+			if tt.expectErr != "" {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tt.expectErr))
+				return
+			}
+
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(cv.Version).To(Equal(tt.expectVersion))
+
+			b, err := r.DownloadChart(cv)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(b.String()).To(Equal("app-" + tt.expectVersion))
+		})
+	}
+}
+
+func TestReleaseAssetRepositoryUnauthorized(t *testing.T) {
+	g := NewWithT(t)
+	server := newReleaseAssetServer(t, ReleaseAssetProviderGitHub, "secret")
+
+	r, err := NewReleaseAssetRepository(server.URL+"/org/charts", ReleaseAssetProviderGitHub, nil)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	_, err = r.GetChartVersion("app", "")
+	g.Expect(err).To(MatchError(ContainSubstring("401 Unauthorized: {\"message\":\"Bad credentials\"}")))
+
+	var external *ErrExternal
+	g.Expect(errors.As(err, &external)).To(BeTrue())
+
+	// A failed listing is not kept
+	r.Token = "secret"
+	cv, err := r.GetChartVersion("app", "")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(cv.Version).To(Equal("0.2.0"))
+}
+
+func TestReleasesAPIURL(t *testing.T) {
+	tests := []struct {
+		url       string
+		provider  ReleaseAssetProvider
+		expect    string
+		expectErr bool
+	}{
+		{url: "https://github.com/org/charts", provider: ReleaseAssetProviderGitHub, expect: "https://api.github.com/repos/org/charts/releases"},
+		{url: "https://github.com/org/charts.git/", provider: ReleaseAssetProviderGitHub, expect: "https://api.github.com/repos/org/charts/releases"},
+		{url: "https://git.example.com/org/charts", provider: ReleaseAssetProviderGitHub, expect: "https://git.example.com/api/v3/repos/org/charts/releases"},
+		{url: "https://github.com/org", provider: ReleaseAssetProviderGitHub, expectErr: true},
+		{url: "https://gitlab.com/group/sub/charts", provider: ReleaseAssetProviderGitLab, expect: "https://gitlab.com/api/v4/projects/group%2Fsub%2Fcharts/releases"},
+		{url: "https://gitlab.com/charts", provider: ReleaseAssetProviderGitLab, expectErr: true},
+		{url: "oci://ghcr.io/org/charts", provider: ReleaseAssetProviderGitHub, expectErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			g := NewWithT(t)
+
+			apiURL, err := releasesAPIURL(tt.url, tt.provider)
+			if tt.expectErr {
+				g.Expect(err).To(HaveOccurred())
+				return
+			}
+
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(apiURL).To(Equal(tt.expect))
+		})
+	}
+}
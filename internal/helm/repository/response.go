@@ -0,0 +1,195 @@
+package repository
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// sniffLen is the number of bytes of a response body kept to detect HTML and to report its first line.
+const sniffLen = 512
+
+// maxRedirects is the number of redirects after which the http client of Go gives up.
+const maxRedirects = 10
+
+// recordedResponse is a response of a single request of a download, a download may follow redirects.
+type recordedResponse struct {
+	url         string
+	statusCode  int
+	contentType string
+	location    string
+	body        []byte
+}
+
+// responseRecorder records the responses of a download including redirects. The getter of Helm hides the
+// response and only fails for a final status other than 200, the recorder explains the failure instead.
+type responseRecorder struct {
+	next      http.RoundTripper
+	mu        sync.Mutex
+	responses []recordedResponse
+}
+
+// recordResponses returns a clone of t whose requests are recorded, the requests are sent by t itself.
+func recordResponses(t *http.Transport) (*http.Transport, *responseRecorder) {
+	r := &responseRecorder{next: t}
+	recording := t.Clone()
+	// The clone never connects itself, a non-nil TLSNextProto keeps it from configuring HTTP/2 for https which is
+	// registered to the recorder
+	recording.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	recording.RegisterProtocol("http", r)
+	recording.RegisterProtocol("https", r)
+
+	return recording, r
+}
+
+// RoundTrip sends the request and records the response, the start of the body is kept and read again by the caller.
+func (r *responseRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := r.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	body := make([]byte, sniffLen)
+	n, _ := io.ReadFull(res.Body, body)
+	body = body[:n]
+	res.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), res.Body), res.Body}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.responses = append(r.responses, recordedResponse{
+		url:         req.URL.String(),
+		statusCode:  res.StatusCode,
+		contentType: res.Header.Get("Content-Type"),
+		location:    res.Header.Get("Location"),
+		body:        body,
+	})
+
+	return res, nil
+}
+
+// check explains a download of the document (for instance the repository index) from u which failed with err or
+// returned body. It fails for redirect loops and HTML pages, else err is returned as is.
+func (r *responseRecorder) check(u, document string, body *bytes.Buffer, err error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var last recordedResponse
+	if len(r.responses) > 0 {
+		last = r.responses[len(r.responses)-1]
+	}
+
+	if err != nil {
+		if loop := r.redirectLoop(); loop != nil {
+			return &ErrRedirectLoop{URL: u, Redirects: loop}
+		}
+
+		if last.statusCode == 0 || !isHTML(last.contentType, last.body) {
+			return err
+		}
+	} else {
+		if len(r.responses) == 0 && body != nil {
+			last = recordedResponse{url: u, statusCode: http.StatusOK, body: body.Bytes()}
+		}
+
+		if !isHTML(last.contentType, last.body) {
+			return nil
+		}
+
+		for _, res := range r.responses[:max(len(r.responses)-1, 0)] {
+			if isRedirect(res.statusCode) {
+				return &ErrAuthRedirect{
+					URL:         u,
+					StatusCode:  res.statusCode,
+					Location:    last.url,
+					ContentType: last.contentType,
+					FirstLine:   firstLine(last.body),
+				}
+			}
+		}
+	}
+
+	return &ErrUnexpectedContent{
+		URL:         last.url,
+		Document:    document,
+		StatusCode:  last.statusCode,
+		ContentType: last.contentType,
+		FirstLine:   firstLine(last.body),
+	}
+}
+
+// redirectLoop returns the URLs of the redirects if the download failed because it was redirected in a loop or
+// more often than the http client follows.
+func (r *responseRecorder) redirectLoop() []string {
+	seen := make(map[string]bool)
+	var urls []string
+	for _, res := range r.responses {
+		if !isRedirect(res.statusCode) {
+			return nil
+		}
+
+		seen[res.url] = true
+		urls = append(urls, res.url)
+	}
+
+	if len(urls) == 0 {
+		return nil
+	}
+
+	next, err := url.Parse(r.responses[len(r.responses)-1].url)
+	if err != nil {
+		return nil
+	}
+
+	location, err := next.Parse(r.responses[len(r.responses)-1].location)
+	if err != nil || (!seen[location.String()] && len(urls) < maxRedirects) {
+		return nil
+	}
+
+	return append(urls, location.String())
+}
+
+func isRedirect(statusCode int) bool {
+	return statusCode >= 300 && statusCode < 400
+}
+
+// isHTML reports whether a response is an HTML page by its content type or, if it has none, by its body.
+func isHTML(contentType string, body []byte) bool {
+	if contentType == "" || strings.HasPrefix(contentType, "application/octet-stream") {
+		contentType = http.DetectContentType(body)
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	return mediaType == "text/html" || mediaType == "application/xhtml+xml"
+}
+
+// firstLine returns the first non-empty line of body shortened to 80 characters.
+func firstLine(body []byte) string {
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if len(line) > 80 {
+			line = line[:80] + "..."
+		}
+
+		return line
+	}
+
+	return ""
+}
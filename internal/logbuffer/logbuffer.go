@@ -0,0 +1,136 @@
+package logbuffer
+
+import (
+	"sync"
+
+	"github.com/go-logr/logr"
+)
+
+// flushMu serializes flushes so the log block of one buffer is never interleaved
+// with the block of another buffer flushed concurrently.
+var flushMu sync.Mutex
+
+type entry struct {
+	level  int
+	err    error
+	isErr  bool
+	msg    string
+	names  []string
+	values []any
+	kv     []any
+}
+
+// Buffer collects log entries in memory until they are either flushed to the target logger or discarded.
+// A Buffer is safe for concurrent use.
+type Buffer struct {
+	target  logr.Logger
+	mu      sync.Mutex
+	entries []entry
+}
+
+// New returns a Buffer which eventually writes to target.
+func New(target logr.Logger) *Buffer {
+	return &Buffer{
+		target: target,
+	}
+}
+
+// Logger returns a logger which writes into the buffer.
+// Levels which are not enabled on the target logger are dropped right away.
+func (b *Buffer) Logger() logr.Logger {
+	return logr.New(&sink{buffer: b})
+}
+
+// Flush writes all buffered entries as one block to the target logger and resets the buffer.
+func (b *Buffer) Flush() {
+	b.mu.Lock()
+	entries := b.entries
+	b.entries = nil
+	b.mu.Unlock()
+
+	flushMu.Lock()
+	defer flushMu.Unlock()
+
+	for _, e := range entries {
+		logger := b.target
+		for _, name := range e.names {
+			logger = logger.WithName(name)
+		}
+
+		logger = logger.WithValues(e.values...)
+		if e.isErr {
+			logger.Error(e.err, e.msg, e.kv...)
+		} else {
+			logger.V(e.level).Info(e.msg, e.kv...)
+		}
+	}
+}
+
+// Discard drops all buffered entries.
+func (b *Buffer) Discard() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.entries = nil
+}
+
+// Len returns the number of buffered entries.
+func (b *Buffer) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.entries)
+}
+
+func (b *Buffer) push(e entry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.entries = append(b.entries, e)
+}
+
+type sink struct {
+	buffer *Buffer
+	names  []string
+	values []any
+}
+
+func (s *sink) Init(info logr.RuntimeInfo) {}
+
+func (s *sink) Enabled(level int) bool {
+	return s.buffer.target.V(level).Enabled()
+}
+
+func (s *sink) Info(level int, msg string, keysAndValues ...any) {
+	s.buffer.push(entry{
+		level:  level,
+		msg:    msg,
+		names:  s.names,
+		values: s.values,
+		kv:     keysAndValues,
+	})
+}
+
+func (s *sink) Error(err error, msg string, keysAndValues ...any) {
+	s.buffer.push(entry{
+		err:    err,
+		isErr:  true,
+		msg:    msg,
+		names:  s.names,
+		values: s.values,
+		kv:     keysAndValues,
+	})
+}
+
+func (s *sink) WithValues(keysAndValues ...any) logr.LogSink {
+	return &sink{
+		buffer: s.buffer,
+		names:  s.names,
+		values: append(append([]any{}, s.values...), keysAndValues...),
+	}
+}
+
+func (s *sink) WithName(name string) logr.LogSink {
+	return &sink{
+		buffer: s.buffer,
+		names:  append(append([]string{}, s.names...), name),
+		values: s.values,
+	}
+}
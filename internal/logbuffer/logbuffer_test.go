@@ -0,0 +1,52 @@
+package logbuffer
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/funcr"
+	. "github.com/onsi/gomega"
+)
+
+func newTarget(lines *[]string) logr.Logger {
+	return funcr.New(func(prefix, args string) {
+		*lines = append(*lines, prefix+" "+args)
+	}, funcr.Options{Verbosity: 1})
+}
+
+func TestFlush(t *testing.T) {
+	g := NewWithT(t)
+
+	var lines []string
+	b := New(newTarget(&lines))
+	logger := b.Logger().WithName("helm").WithValues("release", "podinfo")
+
+	logger.Info("first")
+	logger.V(1).Info("debug", "key", "value")
+	logger.V(2).Info("dropped")
+	logger.Error(errors.New("boom"), "failed")
+
+	g.Expect(lines).To(BeEmpty())
+	g.Expect(b.Len()).To(Equal(3))
+
+	b.Flush()
+	g.Expect(b.Len()).To(Equal(0))
+	g.Expect(lines).To(Equal([]string{
+		`helm "level"=0 "msg"="first" "release"="podinfo"`,
+		`helm "level"=1 "msg"="debug" "release"="podinfo" "key"="value"`,
+		`helm "msg"="failed" "error"="boom" "release"="podinfo"`,
+	}))
+}
+
+func TestDiscard(t *testing.T) {
+	g := NewWithT(t)
+
+	var lines []string
+	b := New(newTarget(&lines))
+	b.Logger().Info("first")
+
+	b.Discard()
+	b.Flush()
+	g.Expect(lines).To(BeEmpty())
+}
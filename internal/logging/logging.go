@@ -0,0 +1,73 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/go-logr/logr"
+	"github.com/go-logr/zapr"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	// EncodingConsole writes human-readable lines.
+	EncodingConsole = "console"
+	// EncodingJSON writes one JSON object per line with the stable keys level, ts, logger, caller, msg and
+	// stacktrace. Durations are written as seconds.
+	EncodingJSON = "json"
+)
+
+// Options configure the logger returned by New.
+type Options struct {
+	// Level is the minimum level which is logged: debug, info, warn or error. Debug enables V(1) logs.
+	Level string
+	// Encoding is EncodingConsole or EncodingJSON.
+	Encoding string
+}
+
+// New returns a logger writing to w.
+func New(w io.Writer, opts Options) (logr.Logger, error) {
+	level := zap.NewAtomicLevel()
+	if err := level.UnmarshalText([]byte(opts.Level)); err != nil {
+		return logr.Discard(), err
+	}
+
+	var (
+		encoder    zapcore.Encoder
+		stacktrace zapcore.Level
+	)
+
+	switch opts.Encoding {
+	case EncodingConsole:
+		encoder = zapcore.NewConsoleEncoder(zap.NewDevelopmentEncoderConfig())
+		stacktrace = zapcore.WarnLevel
+	case EncodingJSON:
+		encoder = zapcore.NewJSONEncoder(jsonEncoderConfig())
+		stacktrace = zapcore.ErrorLevel
+	default:
+		return logr.Discard(), fmt.Errorf("unknown log encoding `%s`, supported encodings are %s, %s", opts.Encoding, EncodingConsole, EncodingJSON)
+	}
+
+	core := zapcore.NewCore(encoder, zapcore.Lock(zapcore.AddSync(w)), level)
+	return zapr.NewLogger(zap.New(core, zap.AddCaller(), zap.AddStacktrace(stacktrace))), nil
+}
+
+// jsonEncoderConfig returns the keys and encoders of EncodingJSON which must not change between releases, log
+// pipelines depend on them.
+func jsonEncoderConfig() zapcore.EncoderConfig {
+	return zapcore.EncoderConfig{
+		TimeKey:        "ts",
+		LevelKey:       "level",
+		NameKey:        "logger",
+		CallerKey:      "caller",
+		FunctionKey:    zapcore.OmitKey,
+		MessageKey:     "msg",
+		StacktraceKey:  "stacktrace",
+		LineEnding:     zapcore.DefaultLineEnding,
+		EncodeLevel:    zapcore.LowercaseLevelEncoder,
+		EncodeTime:     zapcore.RFC3339NanoTimeEncoder,
+		EncodeDuration: zapcore.SecondsDurationEncoder,
+		EncodeCaller:   zapcore.ShortCallerEncoder,
+	}
+}
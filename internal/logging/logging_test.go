@@ -0,0 +1,92 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        Options
+		expectErr   bool
+		expectLines int
+	}{
+		{
+			name:        "info",
+			opts:        Options{Level: "info", Encoding: EncodingJSON},
+			expectLines: 2,
+		},
+		{
+			name:        "debug",
+			opts:        Options{Level: "debug", Encoding: EncodingJSON},
+			expectLines: 3,
+		},
+		{
+			name:        "error",
+			opts:        Options{Level: "error", Encoding: EncodingJSON},
+			expectLines: 1,
+		},
+		{
+			name: "console",
+			opts: Options{Level: "info", Encoding: EncodingConsole},
+			// The stacktrace of the error spans several lines
+			expectLines: 6,
+		},
+		{
+			name:      "unknown level",
+			opts:      Options{Level: "verbose", Encoding: EncodingJSON},
+			expectErr: true,
+		},
+		{
+			name:      "unknown encoding",
+			opts:      Options{Level: "info", Encoding: "logfmt"},
+			expectErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			var buf bytes.Buffer
+			logger, err := New(&buf, tt.opts)
+			if tt.expectErr {
+				g.Expect(err).To(HaveOccurred())
+				return
+			}
+			g.Expect(err).NotTo(HaveOccurred())
+
+			logger.Info("info")
+			logger.V(1).Info("debug")
+			logger.Error(errors.New("boom"), "error")
+
+			g.Expect(strings.Split(strings.TrimSpace(buf.String()), "\n")).To(HaveLen(tt.expectLines))
+		})
+	}
+}
+
+func TestNewJSONKeys(t *testing.T) {
+	g := NewWithT(t)
+
+	var buf bytes.Buffer
+	logger, err := New(&buf, Options{Level: "info", Encoding: EncodingJSON})
+	g.Expect(err).NotTo(HaveOccurred())
+
+	logger.WithName("helm").Info("pulled chart", "chart", "podinfo", "duration", 1500*time.Millisecond)
+
+	var entry map[string]interface{}
+	g.Expect(json.Unmarshal(buf.Bytes(), &entry)).To(Succeed())
+	g.Expect(entry).To(HaveKeyWithValue("level", "info"))
+	g.Expect(entry).To(HaveKeyWithValue("logger", "helm"))
+	g.Expect(entry).To(HaveKeyWithValue("msg", "pulled chart"))
+	g.Expect(entry).To(HaveKeyWithValue("chart", "podinfo"))
+	g.Expect(entry).To(HaveKeyWithValue("duration", 1.5))
+	g.Expect(entry).To(HaveKey("ts"))
+	g.Expect(entry).To(HaveKeyWithValue("caller", ContainSubstring("logging_test.go")))
+}
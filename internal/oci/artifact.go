@@ -0,0 +1,197 @@
+package oci
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// ArtifactPrefix is the URL scheme of OCI artifacts.
+const ArtifactPrefix = "oci://"
+
+// MaxArtifactSize is the maximum size of the extracted content of an artifact.
+const MaxArtifactSize int64 = 100 << 20
+
+// PullArtifact pulls the first layer of the OCI artifact at url and extracts it into dir.
+// The url is either referenced by tag, digest or a semver range (for instance oci://ghcr.io/org/manifests:>=1.0.0).
+// The digest reference of the pulled artifact is returned.
+func PullArtifact(ctx context.Context, url, dir string, insecure bool, opts ...remote.Option) (name.Digest, error) {
+	opts = append([]remote.Option{remote.WithContext(ctx)}, opts...)
+	ref, err := ParseArtifactReference(url, insecure, opts...)
+	if err != nil {
+		return name.Digest{}, err
+	}
+
+	img, err := remote.Image(ref, opts...)
+	if err != nil {
+		return name.Digest{}, fmt.Errorf("failed to pull artifact %s: %w", ref, err)
+	}
+
+	digest, err := img.Digest()
+	if err != nil {
+		return name.Digest{}, err
+	}
+
+	layers, err := img.Layers()
+	if err != nil {
+		return name.Digest{}, fmt.Errorf("failed to list layers of artifact %s: %w", ref, err)
+	}
+
+	if len(layers) == 0 {
+		return name.Digest{}, fmt.Errorf("no layers found in artifact %s", ref)
+	}
+
+	blob, err := layers[0].Compressed()
+	if err != nil {
+		return name.Digest{}, err
+	}
+	defer blob.Close()
+
+	if err := Untar(blob, dir, MaxArtifactSize); err != nil {
+		return name.Digest{}, fmt.Errorf("failed to extract artifact %s: %w", ref, err)
+	}
+
+	return ref.Context().Digest(digest.String()), nil
+}
+
+// ParseArtifactReference parses an oci:// url into a reference.
+// A tag which is a semver range is resolved to the highest matching semver tag of the repository.
+// Semver ranges require an operator (for instance >=1.0.0 or ~1.2), a plain version is used as tag.
+func ParseArtifactReference(url string, insecure bool, opts ...remote.Option) (name.Reference, error) {
+	if !strings.HasPrefix(url, ArtifactPrefix) {
+		return nil, fmt.Errorf("invalid artifact url `%s`, expected %s prefix", url, ArtifactPrefix)
+	}
+
+	var nameOpts []name.Option
+	if insecure {
+		nameOpts = append(nameOpts, name.Insecure)
+	}
+
+	u := strings.TrimPrefix(url, ArtifactPrefix)
+	i := strings.LastIndex(u, ":")
+	if i == -1 || strings.Contains(u[i:], "/") || strings.Contains(u, "@") || !strings.ContainsAny(u[i+1:], " <>=~^*|,") {
+		return name.ParseReference(u, nameOpts...)
+	}
+
+	constraint, err := semver.NewConstraint(u[i+1:])
+	if err != nil {
+		return nil, fmt.Errorf("invalid artifact url `%s`: %w", url, err)
+	}
+
+	repo, err := name.NewRepository(u[:i], nameOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("invalid artifact url `%s`: %w", url, err)
+	}
+
+	tags, err := remote.List(repo, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tags of %s: %w", repo, err)
+	}
+
+	var latest *semver.Version
+	var latestTag string
+	for _, tag := range tags {
+		v, err := semver.NewVersion(tag)
+		if err != nil || !constraint.Check(v) {
+			continue
+		}
+
+		if latest == nil || v.GreaterThan(latest) {
+			latest, latestTag = v, tag
+		}
+	}
+
+	if latest == nil {
+		return nil, fmt.Errorf("no tag of %s matches semver range `%s`", repo, u[i+1:])
+	}
+
+	return repo.Tag(latestTag), nil
+}
+
+// Untar extracts the (optionally gzip compressed) tarball into dir.
+// Entries escaping dir, symlinks, hardlinks and special files are rejected or skipped and
+// the extraction fails if the content exceeds maxSize bytes.
+func Untar(r io.Reader, dir string, maxSize int64) error {
+	buf := bufio.NewReader(r)
+	if magic, err := buf.Peek(2); err == nil && bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(buf)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	} else {
+		r = buf
+	}
+
+	var size int64
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		entry := filepath.FromSlash(header.Name)
+		if !filepath.IsLocal(entry) {
+			if filepath.Clean(entry) == "." {
+				continue
+			}
+
+			return fmt.Errorf("tar entry `%s` is outside of the target directory", header.Name)
+		}
+
+		target := filepath.Join(dir, entry)
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0o750); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			size += header.Size
+			if size > maxSize {
+				return fmt.Errorf("tarball exceeds the maximum size of %d bytes", maxSize)
+			}
+
+			if err := os.MkdirAll(filepath.Dir(target), 0o750); err != nil {
+				return err
+			}
+
+			if err := writeFile(target, tr, header.Size); err != nil {
+				return err
+			}
+		default:
+			// symlinks, hardlinks and special files are never extracted
+			continue
+		}
+	}
+}
+
+func writeFile(path string, r io.Reader, size int64) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o640)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(f, io.LimitReader(r, size)); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
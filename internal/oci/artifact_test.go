@@ -0,0 +1,241 @@
+package oci
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"log"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	. "github.com/onsi/gomega"
+)
+
+type tarEntry struct {
+	name     string
+	typeflag byte
+	content  string
+	linkname string
+}
+
+func newTarball(t *testing.T, entries ...tarEntry) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+
+	for _, entry := range entries {
+		header := &tar.Header{
+			Name:     entry.name,
+			Typeflag: entry.typeflag,
+			Size:     int64(len(entry.content)),
+			Linkname: entry.linkname,
+			Mode:     0o644,
+		}
+		if entry.typeflag != tar.TypeReg {
+			header.Size = 0
+		}
+
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+
+		if entry.typeflag == tar.TypeReg {
+			if _, err := tw.Write([]byte(entry.content)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestUntar(t *testing.T) {
+	tests := []struct {
+		name        string
+		entries     []tarEntry
+		maxSize     int64
+		expectFiles map[string]string
+		expectErr   string
+	}{
+		{
+			name: "files and directories",
+			entries: []tarEntry{
+				{name: "./", typeflag: tar.TypeDir},
+				{name: "apps/", typeflag: tar.TypeDir},
+				{name: "apps/deploy.yaml", typeflag: tar.TypeReg, content: "kind: Deployment"},
+				{name: "nested/dir/cm.yaml", typeflag: tar.TypeReg, content: "kind: ConfigMap"},
+			},
+			maxSize: MaxArtifactSize,
+			expectFiles: map[string]string{
+				"apps/deploy.yaml":   "kind: Deployment",
+				"nested/dir/cm.yaml": "kind: ConfigMap",
+			},
+		},
+		{
+			name: "symlinks are skipped",
+			entries: []tarEntry{
+				{name: "passwd", typeflag: tar.TypeSymlink, linkname: "/etc/passwd"},
+				{name: "hosts", typeflag: tar.TypeLink, linkname: "/etc/hosts"},
+				{name: "a.yaml", typeflag: tar.TypeReg, content: "a"},
+			},
+			maxSize: MaxArtifactSize,
+			expectFiles: map[string]string{
+				"a.yaml": "a",
+			},
+		},
+		{
+			name: "path traversal",
+			entries: []tarEntry{
+				{name: "../escape.yaml", typeflag: tar.TypeReg, content: "a"},
+			},
+			maxSize:   MaxArtifactSize,
+			expectErr: "tar entry `../escape.yaml` is outside of the target directory",
+		},
+		{
+			name: "absolute path",
+			entries: []tarEntry{
+				{name: "/etc/escape.yaml", typeflag: tar.TypeReg, content: "a"},
+			},
+			maxSize:   MaxArtifactSize,
+			expectErr: "is outside of the target directory",
+		},
+		{
+			name: "exceeds max size",
+			entries: []tarEntry{
+				{name: "a.yaml", typeflag: tar.TypeReg, content: "aaaa"},
+				{name: "b.yaml", typeflag: tar.TypeReg, content: "bbbb"},
+			},
+			maxSize:   6,
+			expectErr: "tarball exceeds the maximum size of 6 bytes",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			dir := t.TempDir()
+
+			err := Untar(bytes.NewReader(newTarball(t, tt.entries...)), dir, tt.maxSize)
+			if tt.expectErr != "" {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tt.expectErr))
+				return
+			}
+
+			g.Expect(err).ToNot(HaveOccurred())
+
+			var files []string
+			g.Expect(filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+				if err != nil || info.IsDir() {
+					return err
+				}
+
+				files = append(files, path)
+				return nil
+			})).To(Succeed())
+			g.Expect(files).To(HaveLen(len(tt.expectFiles)))
+
+			for file, content := range tt.expectFiles {
+				b, err := os.ReadFile(filepath.Join(dir, file))
+				g.Expect(err).ToNot(HaveOccurred())
+				g.Expect(string(b)).To(Equal(content))
+			}
+		})
+	}
+}
+
+func TestPullArtifact(t *testing.T) {
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	t.Cleanup(server.Close)
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	push := func(t *testing.T, tag, content string) string {
+		g := NewWithT(t)
+		layer := static.NewLayer(newTarball(t, tarEntry{name: "manifest.yaml", typeflag: tar.TypeReg, content: content}), "application/vnd.cncf.flux.content.v1.tar+gzip")
+		img, err := mutate.AppendLayers(empty.Image, layer)
+		g.Expect(err).ToNot(HaveOccurred())
+
+		ref, err := name.ParseReference(host + "/manifests:" + tag)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(remote.Write(ref, img)).To(Succeed())
+
+		digest, err := img.Digest()
+		g.Expect(err).ToNot(HaveOccurred())
+		return digest.String()
+	}
+
+	push(t, "1.0.0", "v1.0.0")
+	latest := push(t, "1.2.0", "v1.2.0")
+	push(t, "2.0.0", "v2.0.0")
+	push(t, "stable", "stable")
+
+	tests := []struct {
+		name          string
+		url           string
+		expectContent string
+		expectErr     string
+	}{
+		{
+			name:          "tag",
+			url:           "oci://" + host + "/manifests:stable",
+			expectContent: "stable",
+		},
+		{
+			name:          "digest",
+			url:           "oci://" + host + "/manifests@" + latest,
+			expectContent: "v1.2.0",
+		},
+		{
+			name:          "semver range",
+			url:           "oci://" + host + "/manifests:>=1.0.0 <2.0.0",
+			expectContent: "v1.2.0",
+		},
+		{
+			name:      "no matching semver tag",
+			url:       "oci://" + host + "/manifests:>=3.0.0",
+			expectErr: "matches semver range `>=3.0.0`",
+		},
+		{
+			name:      "missing prefix",
+			url:       host + "/manifests:stable",
+			expectErr: "expected oci:// prefix",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			dir := t.TempDir()
+
+			digest, err := PullArtifact(context.Background(), tt.url, dir, true)
+			if tt.expectErr != "" {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tt.expectErr))
+				return
+			}
+
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(digest.String()).To(HavePrefix(host + "/manifests@sha256:"))
+
+			b, err := os.ReadFile(filepath.Join(dir, "manifest.yaml"))
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(string(b)).To(Equal(tt.expectContent))
+		})
+	}
+}
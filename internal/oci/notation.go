@@ -0,0 +1,483 @@
+package oci
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+const (
+	// NotationArtifactType is the artifact type of notation signatures.
+	NotationArtifactType = "application/vnd.cncf.notary.signature"
+	// NotationJWSMediaType is the media type of JWS signature envelopes.
+	NotationJWSMediaType = "application/jose+json"
+	// NotationCOSEMediaType is the media type of COSE signature envelopes.
+	NotationCOSEMediaType = "application/cose"
+)
+
+// Notation signature verification levels as defined by the trust policy.
+const (
+	NotationLevelStrict     = "strict"
+	NotationLevelPermissive = "permissive"
+	NotationLevelAudit      = "audit"
+	NotationLevelSkip       = "skip"
+)
+
+// TrustPolicyDocument is a notation trust policy (trustpolicy.json).
+type TrustPolicyDocument struct {
+	Version       string        `json:"version"`
+	TrustPolicies []TrustPolicy `json:"trustPolicies"`
+}
+
+// TrustPolicy is a single policy of a trust policy document.
+type TrustPolicy struct {
+	Name                  string   `json:"name"`
+	RegistryScopes        []string `json:"registryScopes"`
+	SignatureVerification struct {
+		Level string `json:"level"`
+	} `json:"signatureVerification"`
+	TrustStores       []string `json:"trustStores"`
+	TrustedIdentities []string `json:"trustedIdentities"`
+}
+
+// ParseTrustPolicy decodes and validates a notation trust policy document.
+func ParseTrustPolicy(b []byte) (*TrustPolicyDocument, error) {
+	doc := &TrustPolicyDocument{}
+	if err := json.Unmarshal(b, doc); err != nil {
+		return nil, fmt.Errorf("invalid trust policy: %w", err)
+	}
+
+	if len(doc.TrustPolicies) == 0 {
+		return nil, errors.New("invalid trust policy: no trust policies defined")
+	}
+
+	for _, policy := range doc.TrustPolicies {
+		switch policy.SignatureVerification.Level {
+		case NotationLevelStrict, NotationLevelPermissive, NotationLevelAudit, NotationLevelSkip:
+		default:
+			return nil, fmt.Errorf("invalid trust policy `%s`: unknown signature verification level `%s`", policy.Name, policy.SignatureVerification.Level)
+		}
+
+		if len(policy.RegistryScopes) == 0 {
+			return nil, fmt.Errorf("invalid trust policy `%s`: no registry scopes defined", policy.Name)
+		}
+	}
+
+	return doc, nil
+}
+
+// policyFor returns the trust policy which applies to the given repository.
+// A policy with an exact registry scope takes precedence over the wildcard policy.
+func (d *TrustPolicyDocument) policyFor(repository string) (*TrustPolicy, error) {
+	var wildcard *TrustPolicy
+	for i, policy := range d.TrustPolicies {
+		for _, scope := range policy.RegistryScopes {
+			if scope == repository {
+				return &d.TrustPolicies[i], nil
+			}
+
+			if scope == "*" {
+				wildcard = &d.TrustPolicies[i]
+			}
+		}
+	}
+
+	if wildcard == nil {
+		return nil, fmt.Errorf("no trust policy applies to `%s`", repository)
+	}
+
+	return wildcard, nil
+}
+
+// ParseCertificates decodes all PEM encoded certificates.
+func ParseCertificates(b []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			break
+		}
+
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		certs = append(certs, cert)
+	}
+
+	if len(certs) == 0 {
+		return nil, errors.New("no PEM encoded certificates found")
+	}
+
+	return certs, nil
+}
+
+// NotationVerifier verifies notation signatures of OCI artifacts.
+// Only JWS signature envelopes are supported.
+type NotationVerifier struct {
+	policy *TrustPolicyDocument
+	roots  *x509.CertPool
+	ropt   []remote.Option
+}
+
+// NotationOptions is a function that configures the NotationVerifier.
+type NotationOptions func(v *NotationVerifier)
+
+// WithTrustPolicy sets the trust policy document.
+func WithTrustPolicy(policy *TrustPolicyDocument) NotationOptions {
+	return func(v *NotationVerifier) {
+		v.policy = policy
+	}
+}
+
+// WithTrustStore sets the trusted root certificates.
+func WithTrustStore(certs []*x509.Certificate) NotationOptions {
+	return func(v *NotationVerifier) {
+		for _, cert := range certs {
+			v.roots.AddCert(cert)
+		}
+	}
+}
+
+// WithNotationRemoteOptions sets the remote options used to access the registry.
+func WithNotationRemoteOptions(opts ...remote.Option) NotationOptions {
+	return func(v *NotationVerifier) {
+		v.ropt = opts
+	}
+}
+
+// NewNotationVerifier initializes a new NotationVerifier.
+func NewNotationVerifier(opts ...NotationOptions) (*NotationVerifier, error) {
+	v := &NotationVerifier{
+		roots: x509.NewCertPool(),
+	}
+
+	for _, opt := range opts {
+		opt(v)
+	}
+
+	if v.policy == nil {
+		return nil, errors.New("a trust policy is required for notation verification")
+	}
+
+	return v, nil
+}
+
+// Verify verifies the notation signatures of the given ref OCI artifact.
+// It returns true if at least one signature satisfies the trust policy.
+func (v *NotationVerifier) Verify(ctx context.Context, ref name.Reference) (bool, error) {
+	policy, err := v.policy.policyFor(ref.Context().Name())
+	if err != nil {
+		return false, err
+	}
+
+	logger := logr.FromContextOrDiscard(ctx)
+	if policy.SignatureVerification.Level == NotationLevelSkip {
+		logger.V(1).Info("skipped notation verification", "ref", ref.String(), "policy", policy.Name)
+		return true, nil
+	}
+
+	ropt := append([]remote.Option{remote.WithContext(ctx)}, v.ropt...)
+	desc, err := remote.Head(ref, ropt...)
+	if err != nil {
+		return false, fmt.Errorf("failed to resolve %s: %w", ref, err)
+	}
+
+	digest := ref.Context().Digest(desc.Digest.String())
+	index, err := remote.Referrers(digest, ropt...)
+	if err != nil {
+		return false, fmt.Errorf("failed to list referrers of %s: %w", digest, err)
+	}
+
+	manifest, err := index.IndexManifest()
+	if err != nil {
+		return false, err
+	}
+
+	var errs []error
+	for _, referrer := range manifest.Manifests {
+		if referrer.ArtifactType != NotationArtifactType {
+			continue
+		}
+
+		subject, err := v.verifySignature(ref.Context(), referrer, desc.Digest, policy, ropt)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		logger.V(1).Info("verified notation signature", "ref", ref.String(), "policy", policy.Name, "subject", subject)
+		return true, nil
+	}
+
+	if policy.SignatureVerification.Level == NotationLevelAudit {
+		logger.Info("notation verification failed, ignored due to audit level", "ref", ref.String(), "policy", policy.Name, "error", errors.Join(errs...))
+		return true, nil
+	}
+
+	if len(errs) > 0 {
+		return false, errors.Join(errs...)
+	}
+
+	return false, nil
+}
+
+// jwsEnvelope is a notation signature envelope in the flattened JWS JSON serialization.
+type jwsEnvelope struct {
+	Payload   string `json:"payload"`
+	Protected string `json:"protected"`
+	Header    struct {
+		CertChain [][]byte `json:"x5c"`
+	} `json:"header"`
+	Signature string `json:"signature"`
+}
+
+type jwsProtectedHeader struct {
+	Algorithm   string     `json:"alg"`
+	SigningTime *time.Time `json:"io.cncf.notary.signingTime"`
+}
+
+type notationPayload struct {
+	TargetArtifact v1.Descriptor `json:"targetArtifact"`
+}
+
+// verifySignature verifies a single signature artifact and returns the subject of the signing certificate.
+func (v *NotationVerifier) verifySignature(repo name.Repository, signature v1.Descriptor, artifact v1.Hash, policy *TrustPolicy, ropt []remote.Option) (string, error) {
+	desc, err := remote.Get(repo.Digest(signature.Digest.String()), ropt...)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch signature %s: %w", signature.Digest, err)
+	}
+
+	manifest, err := v1.ParseManifest(bytes.NewReader(desc.Manifest))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse signature %s: %w", signature.Digest, err)
+	}
+
+	if len(manifest.Layers) != 1 {
+		return "", fmt.Errorf("signature %s: expected exactly one envelope, got %d", signature.Digest, len(manifest.Layers))
+	}
+
+	if manifest.Layers[0].MediaType != NotationJWSMediaType {
+		return "", fmt.Errorf("signature %s: unsupported envelope type `%s`", signature.Digest, manifest.Layers[0].MediaType)
+	}
+
+	layer, err := remote.Layer(repo.Digest(manifest.Layers[0].Digest.String()), ropt...)
+	if err != nil {
+		return "", err
+	}
+
+	rc, err := layer.Compressed()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	b, err := io.ReadAll(io.LimitReader(rc, 1<<20))
+	if err != nil {
+		return "", err
+	}
+
+	subject, err := v.verifyJWS(b, artifact, policy)
+	if err != nil {
+		return "", fmt.Errorf("signature %s: %w", signature.Digest, err)
+	}
+
+	return subject, nil
+}
+
+// verifyJWS verifies the integrity and authenticity of a JWS envelope against the trust policy.
+func (v *NotationVerifier) verifyJWS(b []byte, artifact v1.Hash, policy *TrustPolicy) (string, error) {
+	var envelope jwsEnvelope
+	if err := json.Unmarshal(b, &envelope); err != nil {
+		return "", fmt.Errorf("invalid envelope: %w", err)
+	}
+
+	if len(envelope.Header.CertChain) == 0 {
+		return "", errors.New("invalid envelope: no certificate chain")
+	}
+
+	protected, err := base64.RawURLEncoding.DecodeString(envelope.Protected)
+	if err != nil {
+		return "", fmt.Errorf("invalid protected header: %w", err)
+	}
+
+	var header jwsProtectedHeader
+	if err := json.Unmarshal(protected, &header); err != nil {
+		return "", fmt.Errorf("invalid protected header: %w", err)
+	}
+
+	certs := make([]*x509.Certificate, 0, len(envelope.Header.CertChain))
+	for _, raw := range envelope.Header.CertChain {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return "", fmt.Errorf("invalid certificate chain: %w", err)
+		}
+		certs = append(certs, cert)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(envelope.Signature)
+	if err != nil {
+		return "", fmt.Errorf("invalid signature: %w", err)
+	}
+
+	if err := verifyJWSSignature(header.Algorithm, certs[0].PublicKey, []byte(envelope.Protected+"."+envelope.Payload), sig); err != nil {
+		return "", err
+	}
+
+	payloadBytes, err := base64.RawURLEncoding.DecodeString(envelope.Payload)
+	if err != nil {
+		return "", fmt.Errorf("invalid payload: %w", err)
+	}
+
+	var payload notationPayload
+	if err := json.Unmarshal(payloadBytes, &payload); err != nil {
+		return "", fmt.Errorf("invalid payload: %w", err)
+	}
+
+	if payload.TargetArtifact.Digest != artifact {
+		return "", fmt.Errorf("signature was issued for %s, not %s", payload.TargetArtifact.Digest, artifact)
+	}
+
+	// Authenticity failures are only logged with the audit level
+	if policy.SignatureVerification.Level == NotationLevelAudit {
+		return certs[0].Subject.String(), nil
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	verifyOpts := x509.VerifyOptions{
+		Roots:         v.roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+
+	// The certificate must have been valid at signing time
+	if header.SigningTime != nil {
+		verifyOpts.CurrentTime = *header.SigningTime
+	}
+
+	if _, err := certs[0].Verify(verifyOpts); err != nil {
+		return "", fmt.Errorf("untrusted certificate chain: %w", err)
+	}
+
+	if !trustedIdentity(policy.TrustedIdentities, certs[0]) {
+		return "", fmt.Errorf("signing identity `%s` is not trusted by policy `%s`", certs[0].Subject.String(), policy.Name)
+	}
+
+	return certs[0].Subject.String(), nil
+}
+
+// verifyJWSSignature verifies a JWS signature using the notation supported algorithms.
+func verifyJWSSignature(alg string, key crypto.PublicKey, signingInput, sig []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case "PS256", "ES256":
+		hash = crypto.SHA256
+	case "PS384", "ES384":
+		hash = crypto.SHA384
+	case "PS512", "ES512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported signature algorithm `%s`", alg)
+	}
+
+	h := hash.New()
+	h.Write(signingInput)
+	digest := h.Sum(nil)
+
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(alg, "PS") {
+			return fmt.Errorf("signature algorithm `%s` does not match the rsa signing key", alg)
+		}
+
+		if err := rsa.VerifyPSS(pub, hash, digest, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}); err != nil {
+			return fmt.Errorf("invalid signature: %w", err)
+		}
+	case *ecdsa.PublicKey:
+		if !strings.HasPrefix(alg, "ES") || len(sig)%2 != 0 {
+			return fmt.Errorf("signature algorithm `%s` does not match the ecdsa signing key", alg)
+		}
+
+		r := new(big.Int).SetBytes(sig[:len(sig)/2])
+		s := new(big.Int).SetBytes(sig[len(sig)/2:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return errors.New("invalid signature")
+		}
+	default:
+		return fmt.Errorf("unsupported signing key type %T", key)
+	}
+
+	return nil
+}
+
+// trustedIdentity matches the signing certificate against the trusted identities of the policy.
+// An identity is either the wildcard or an `x509.subject: ` distinguished name whose attributes
+// all have to be present in the certificate subject.
+func trustedIdentity(identities []string, cert *x509.Certificate) bool {
+	subject := parseDN(cert.Subject.String())
+	for _, identity := range identities {
+		if identity == "*" {
+			return true
+		}
+
+		dn, ok := strings.CutPrefix(identity, "x509.subject:")
+		if !ok {
+			continue
+		}
+
+		matches := true
+		for k, v := range parseDN(dn) {
+			if subject[k] != v {
+				matches = false
+				break
+			}
+		}
+
+		if matches {
+			return true
+		}
+	}
+
+	return false
+}
+
+func parseDN(dn string) map[string]string {
+	attributes := make(map[string]string)
+	for _, attribute := range strings.Split(dn, ",") {
+		k, v, ok := strings.Cut(attribute, "=")
+		if !ok {
+			continue
+		}
+
+		attributes[strings.ToUpper(strings.TrimSpace(k))] = strings.TrimSpace(v)
+	}
+
+	return attributes
+}
@@ -0,0 +1,317 @@
+package oci
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log"
+	"math/big"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	. "github.com/onsi/gomega"
+)
+
+type rawManifest []byte
+
+func (m rawManifest) RawManifest() ([]byte, error) {
+	return m, nil
+}
+
+func newCertificate(t *testing.T, subject pkix.Name, key *ecdsa.PrivateKey, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) *x509.Certificate {
+	g := NewWithT(t)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               subject,
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  parent == nil,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	g.Expect(err).ToNot(HaveOccurred())
+	cert, err := x509.ParseCertificate(der)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	return cert
+}
+
+// pushNotationSignature signs the artifact with a JWS envelope and pushes it as referrer of the artifact.
+func pushNotationSignature(t *testing.T, ref name.Reference, artifact v1.Descriptor, key *ecdsa.PrivateKey, chain ...*x509.Certificate) {
+	g := NewWithT(t)
+
+	protected, err := json.Marshal(map[string]interface{}{
+		"alg":                          "ES256",
+		"cty":                          "application/vnd.cncf.notary.payload.v1+json",
+		"io.cncf.notary.signingScheme": "notary.x509",
+		"io.cncf.notary.signingTime":   time.Now().Format(time.RFC3339),
+	})
+	g.Expect(err).ToNot(HaveOccurred())
+	payload, err := json.Marshal(map[string]interface{}{
+		"targetArtifact": artifact,
+	})
+	g.Expect(err).ToNot(HaveOccurred())
+
+	signingInput := base64.RawURLEncoding.EncodeToString(protected) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	g.Expect(err).ToNot(HaveOccurred())
+	sig := append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+
+	var x5c [][]byte
+	for _, cert := range chain {
+		x5c = append(x5c, cert.Raw)
+	}
+
+	envelope, err := json.Marshal(map[string]interface{}{
+		"payload":   base64.RawURLEncoding.EncodeToString(payload),
+		"protected": base64.RawURLEncoding.EncodeToString(protected),
+		"header":    map[string]interface{}{"x5c": x5c},
+		"signature": base64.RawURLEncoding.EncodeToString(sig),
+	})
+	g.Expect(err).ToNot(HaveOccurred())
+
+	envelopeLayer := static.NewLayer(envelope, NotationJWSMediaType)
+	// The referrers API derives the artifact type from the config media type if artifactType is not set
+	configLayer := static.NewLayer([]byte("{}"), NotationArtifactType)
+	for _, layer := range []v1.Layer{envelopeLayer, configLayer} {
+		g.Expect(remote.WriteLayer(ref.Context(), layer)).To(Succeed())
+	}
+
+	descriptor := func(layer v1.Layer) v1.Descriptor {
+		d, err := layer.Digest()
+		g.Expect(err).ToNot(HaveOccurred())
+		size, err := layer.Size()
+		g.Expect(err).ToNot(HaveOccurred())
+		mt, err := layer.MediaType()
+		g.Expect(err).ToNot(HaveOccurred())
+		return v1.Descriptor{MediaType: mt, Digest: d, Size: size}
+	}
+
+	manifest, err := json.Marshal(v1.Manifest{
+		SchemaVersion: 2,
+		MediaType:     types.OCIManifestSchema1,
+		Config:        descriptor(configLayer),
+		Layers:        []v1.Descriptor{descriptor(envelopeLayer)},
+		Subject:       &artifact,
+		Annotations:   map[string]string{},
+	})
+	g.Expect(err).ToNot(HaveOccurred())
+
+	h, _, err := v1.SHA256(bytes.NewReader(manifest))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(remote.Put(ref.Context().Digest(h.String()), rawManifest(manifest))).To(Succeed())
+}
+
+func TestNotationVerifier(t *testing.T) {
+	server := httptest.NewServer(registry.New(registry.WithReferrersSupport(true), registry.Logger(log.New(io.Discard, "", 0))))
+	t.Cleanup(server.Close)
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := newCertificate(t, pkix.Name{CommonName: "ca"}, caKey, nil, nil)
+
+	otherCAKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherCA := newCertificate(t, pkix.Name{CommonName: "other"}, otherCAKey, nil, nil)
+
+	signerKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := newCertificate(t, pkix.Name{CommonName: "signer", Organization: []string{"acme"}}, signerKey, ca, caKey)
+
+	push := func(t *testing.T, repository string, sign bool) name.Reference {
+		g := NewWithT(t)
+		ref, err := name.ParseReference(host + "/" + repository + ":1.0.0")
+		g.Expect(err).ToNot(HaveOccurred())
+
+		img, err := random.Image(64, 1)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(remote.Write(ref, img)).To(Succeed())
+
+		if sign {
+			desc, err := remote.Head(ref)
+			g.Expect(err).ToNot(HaveOccurred())
+			pushNotationSignature(t, ref, *desc, signerKey, signer, ca)
+		}
+
+		return ref
+	}
+
+	policy := func(level string, scopes []string, identities ...string) *TrustPolicyDocument {
+		doc := &TrustPolicyDocument{
+			Version: "1.0",
+			TrustPolicies: []TrustPolicy{{
+				Name:              "charts",
+				RegistryScopes:    scopes,
+				TrustStores:       []string{"ca:charts"},
+				TrustedIdentities: identities,
+			}},
+		}
+		doc.TrustPolicies[0].SignatureVerification.Level = level
+		return doc
+	}
+
+	signed := push(t, "signed", true)
+	unsigned := push(t, "unsigned", false)
+
+	tests := []struct {
+		name         string
+		ref          name.Reference
+		policy       *TrustPolicyDocument
+		roots        []*x509.Certificate
+		expectResult bool
+		expectErr    string
+	}{
+		{
+			name:         "trusted signature",
+			ref:          signed,
+			policy:       policy(NotationLevelStrict, []string{"*"}, "*"),
+			roots:        []*x509.Certificate{ca},
+			expectResult: true,
+		},
+		{
+			name:         "trusted identity",
+			ref:          signed,
+			policy:       policy(NotationLevelStrict, []string{signed.Context().Name()}, "x509.subject: CN=signer, O=acme"),
+			roots:        []*x509.Certificate{ca},
+			expectResult: true,
+		},
+		{
+			name:      "untrusted identity",
+			ref:       signed,
+			policy:    policy(NotationLevelStrict, []string{"*"}, "x509.subject: CN=other"),
+			roots:     []*x509.Certificate{ca},
+			expectErr: "is not trusted by policy `charts`",
+		},
+		{
+			name:      "untrusted certificate chain",
+			ref:       signed,
+			policy:    policy(NotationLevelStrict, []string{"*"}, "*"),
+			roots:     []*x509.Certificate{otherCA},
+			expectErr: "untrusted certificate chain",
+		},
+		{
+			name:         "untrusted certificate chain with audit level",
+			ref:          signed,
+			policy:       policy(NotationLevelAudit, []string{"*"}, "*"),
+			roots:        []*x509.Certificate{otherCA},
+			expectResult: true,
+		},
+		{
+			name:         "no signature",
+			ref:          unsigned,
+			policy:       policy(NotationLevelStrict, []string{"*"}, "*"),
+			roots:        []*x509.Certificate{ca},
+			expectResult: false,
+		},
+		{
+			name:         "skip level",
+			ref:          unsigned,
+			policy:       policy(NotationLevelSkip, []string{"*"}),
+			expectResult: true,
+		},
+		{
+			name:      "no matching policy",
+			ref:       signed,
+			policy:    policy(NotationLevelStrict, []string{host + "/other"}, "*"),
+			roots:     []*x509.Certificate{ca},
+			expectErr: "no trust policy applies",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			verifier, err := NewNotationVerifier(WithTrustPolicy(tt.policy), WithTrustStore(tt.roots))
+			g.Expect(err).ToNot(HaveOccurred())
+
+			verified, err := verifier.Verify(context.Background(), tt.ref)
+			if tt.expectErr != "" {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tt.expectErr))
+				return
+			}
+
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(verified).To(Equal(tt.expectResult))
+		})
+	}
+}
+
+func TestParseTrustPolicy(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    string
+		expectErr string
+	}{
+		{
+			name:   "valid",
+			policy: `{"version":"1.0","trustPolicies":[{"name":"a","registryScopes":["*"],"signatureVerification":{"level":"strict"},"trustStores":["ca:a"],"trustedIdentities":["*"]}]}`,
+		},
+		{
+			name:      "no policies",
+			policy:    `{"version":"1.0","trustPolicies":[]}`,
+			expectErr: "no trust policies defined",
+		},
+		{
+			name:      "unknown level",
+			policy:    `{"version":"1.0","trustPolicies":[{"name":"a","registryScopes":["*"],"signatureVerification":{"level":"lax"}}]}`,
+			expectErr: "unknown signature verification level `lax`",
+		},
+		{
+			name:      "no scopes",
+			policy:    `{"version":"1.0","trustPolicies":[{"name":"a","signatureVerification":{"level":"strict"}}]}`,
+			expectErr: "no registry scopes defined",
+		},
+		{
+			name:      "invalid json",
+			policy:    `{`,
+			expectErr: "invalid trust policy",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			_, err := ParseTrustPolicy([]byte(tt.policy))
+			if tt.expectErr != "" {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tt.expectErr))
+				return
+			}
+
+			g.Expect(err).ToNot(HaveOccurred())
+		})
+	}
+}
@@ -19,8 +19,11 @@ package oci
 import (
 	"context"
 	"crypto"
+	"crypto/x509"
 	"fmt"
 
+	"github.com/go-logr/logr"
+
 	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/sigstore/cosign/v2/cmd/cosign/cli/fulcio"
 	"github.com/sigstore/cosign/v2/cmd/cosign/cli/rekor"
@@ -41,8 +44,10 @@ type Verifier interface {
 
 // options is a struct that holds options for verifier.
 type options struct {
-	PublicKey []byte
-	ROpt      []remote.Option
+	PublicKey  []byte
+	ROpt       []remote.Option
+	Identities []cosign.Identity
+	RekorURL   string
 }
 
 // Options is a function that configures the options applied to a Verifier.
@@ -63,6 +68,22 @@ func WithRemoteOptions(opts ...remote.Option) Options {
 	}
 }
 
+// WithIdentities specifies the identity matchers that have to be met
+// for the signature to be deemed valid in keyless verification.
+func WithIdentities(identities []cosign.Identity) Options {
+	return func(opts *options) {
+		opts.Identities = identities
+	}
+}
+
+// WithRekorURL overrides the Rekor transparency log used in keyless verification.
+// The public Rekor instance is used if not set.
+func WithRekorURL(url string) Options {
+	return func(opts *options) {
+		opts.RekorURL = url
+	}
+}
+
 // CosignVerifier is a struct which is responsible for executing verification logic.
 type CosignVerifier struct {
 	opts *cosign.CheckOpts
@@ -115,11 +136,28 @@ func NewCosignVerifier(ctx context.Context, opts ...Options) (*CosignVerifier, e
 		}
 		checkOpts.IntermediateCerts = icerts
 
-		rc, err := rekor.NewClient(coptions.DefaultRekorURL)
+		rekorURL := o.RekorURL
+		if rekorURL == "" {
+			rekorURL = coptions.DefaultRekorURL
+		}
+
+		rc, err := rekor.NewClient(rekorURL)
 		if err != nil {
 			return nil, fmt.Errorf("unable to create Rekor client: %w", err)
 		}
 		checkOpts.RekorClient = rc
+
+		checkOpts.RekorPubKeys, err = cosign.GetRekorPubs(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get Rekor public keys: %w", err)
+		}
+
+		checkOpts.CTLogPubKeys, err = cosign.GetCTLogPubs(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get CT log public keys: %w", err)
+		}
+
+		checkOpts.Identities = o.Identities
 	}
 
 	return &CosignVerifier{
@@ -145,5 +183,29 @@ func (v *CosignVerifier) Verify(ctx context.Context, ref name.Reference) (bool,
 		return false, nil
 	}
 
+	logger := logr.FromContextOrDiscard(ctx)
+	for _, sig := range signatures {
+		cert, err := sig.Cert()
+		if err != nil || cert == nil {
+			logger.V(1).Info("verified signature", "ref", ref.String())
+			continue
+		}
+
+		ce := cosign.CertExtensions{Cert: cert}
+		logger.V(1).Info("verified keyless signature", "ref", ref.String(), "issuer", ce.GetIssuer(), "subject", signatureSubject(cert))
+	}
+
 	return true, nil
 }
+
+// signatureSubject returns the identity the Fulcio certificate was issued for.
+func signatureSubject(cert *x509.Certificate) string {
+	switch {
+	case len(cert.EmailAddresses) > 0:
+		return cert.EmailAddresses[0]
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String()
+	}
+
+	return cert.Subject.String()
+}
@@ -23,6 +23,7 @@ import (
 
 	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/sigstore/cosign/v2/pkg/cosign"
 )
 
 func TestOptions(t *testing.T) {
@@ -75,6 +76,16 @@ func TestOptions(t *testing.T) {
 				remote.WithTransport(http.DefaultTransport),
 			},
 		},
+	}, {
+		name: "keyless identities and rekor option",
+		opts: []Options{
+			WithIdentities([]cosign.Identity{{IssuerRegExp: "^https://token.actions.githubusercontent.com$", SubjectRegExp: "^https://github.com/org/.*$"}}),
+			WithRekorURL("https://rekor.example.com"),
+		},
+		want: &options{
+			Identities: []cosign.Identity{{IssuerRegExp: "^https://token.actions.githubusercontent.com$", SubjectRegExp: "^https://github.com/org/.*$"}},
+			RekorURL:   "https://rekor.example.com",
+		},
 	},
 	}
 
@@ -88,6 +99,14 @@ func TestOptions(t *testing.T) {
 				t.Errorf("got %#v, want %#v", &o.PublicKey, test.want.PublicKey)
 			}
 
+			if !reflect.DeepEqual(o.Identities, test.want.Identities) {
+				t.Errorf("got %#v, want %#v", o.Identities, test.want.Identities)
+			}
+
+			if o.RekorURL != test.want.RekorURL {
+				t.Errorf("got %s, want %s", o.RekorURL, test.want.RekorURL)
+			}
+
 			if test.want.ROpt != nil {
 				if len(o.ROpt) != len(test.want.ROpt) {
 					t.Errorf("got %d remote options, want %d", len(o.ROpt), len(test.want.ROpt))
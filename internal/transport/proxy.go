@@ -0,0 +1,38 @@
+package transport
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// ProxyFunc is the proxy selection function of a http.Transport.
+type ProxyFunc func(*http.Request) (*url.URL, error)
+
+// NewProxyFunc returns a ProxyFunc which sends HTTP and HTTPS requests through the given proxy.
+// Hosts excluded by the NO_PROXY environment variable (for instance in-cluster registries like .svc.cluster.local)
+// as well as loopback addresses are accessed directly.
+// If proxy is nil the proxy is configured from the environment (HTTP_PROXY, HTTPS_PROXY and NO_PROXY).
+func NewProxyFunc(proxy *url.URL) ProxyFunc {
+	if proxy == nil {
+		return http.ProxyFromEnvironment
+	}
+
+	noProxy := os.Getenv("NO_PROXY")
+	if noProxy == "" {
+		noProxy = os.Getenv("no_proxy")
+	}
+
+	cfg := &httpproxy.Config{
+		HTTPProxy:  proxy.String(),
+		HTTPSProxy: proxy.String(),
+		NoProxy:    noProxy,
+	}
+
+	fn := cfg.ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return fn(req.URL)
+	}
+}
@@ -0,0 +1,64 @@
+package transport
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestNewProxyFunc(t *testing.T) {
+	proxy := &url.URL{Scheme: "http", Host: "proxy.example.com:3128"}
+
+	tests := []struct {
+		name        string
+		noProxy     string
+		url         string
+		expectProxy *url.URL
+	}{
+		{
+			name:        "https is proxied",
+			url:         "https://charts.example.com/index.yaml",
+			expectProxy: proxy,
+		},
+		{
+			name:        "http is proxied",
+			url:         "http://charts.example.com/index.yaml",
+			expectProxy: proxy,
+		},
+		{
+			name:    "in-cluster registry excluded by NO_PROXY",
+			noProxy: ".svc.cluster.local,10.0.0.0/8",
+			url:     "https://registry.registry.svc.cluster.local/v2/",
+		},
+		{
+			name:    "ip range excluded by NO_PROXY",
+			noProxy: ".svc.cluster.local,10.0.0.0/8",
+			url:     "https://10.1.2.3:5000/v2/",
+		},
+		{
+			name:        "host not excluded by NO_PROXY",
+			noProxy:     ".svc.cluster.local,10.0.0.0/8",
+			url:         "https://ghcr.io/v2/",
+			expectProxy: proxy,
+		},
+		{
+			name: "loopback is never proxied",
+			url:  "http://127.0.0.1:8080/index.yaml",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			t.Setenv("NO_PROXY", tt.noProxy)
+
+			req, err := http.NewRequest(http.MethodGet, tt.url, nil)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			got, err := NewProxyFunc(proxy)(req)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(got).To(Equal(tt.expectProxy))
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package transport
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultMaxIdleConnsPerHost is the number of idle connections kept per host if SharedOpts.MaxIdleConnsPerHost is nil.
+// Hosts serving many repositories (for instance virtual repositories of Artifactory) are accessed concurrently.
+var DefaultMaxIdleConnsPerHost = 16
+
+// SharedOpts tunes the transports of Shared.
+type SharedOpts struct {
+	// Proxy is the proxy of all transports, it is configured from the environment if nil.
+	Proxy ProxyFunc
+	// MaxIdleConnsPerHost is the number of idle connections kept per host, DefaultMaxIdleConnsPerHost is used if nil.
+	MaxIdleConnsPerHost *int
+	// DisableKeepAlives uses every connection for a single request only.
+	DisableKeepAlives bool
+}
+
+// Shared hands out one transport per TLS configuration so connections and TLS sessions are reused across all
+// repositories and registries of a host, unlike the transports of the TransportPool which are reused at random.
+// TLS configurations are told apart by a fingerprint of the fields which affect a connection, CAs and client
+// certificates included, so repositories with differing CA or mTLS settings never share a connection.
+type Shared struct {
+	opts       SharedOpts
+	mu         sync.Mutex
+	transports map[string][]*http.Transport
+}
+
+// NewShared returns an empty set of shared transports.
+func NewShared(opts SharedOpts) *Shared {
+	if opts.Proxy == nil {
+		opts.Proxy = http.ProxyFromEnvironment
+	}
+
+	if opts.MaxIdleConnsPerHost == nil {
+		opts.MaxIdleConnsPerHost = &DefaultMaxIdleConnsPerHost
+	}
+
+	return &Shared{
+		opts:       opts,
+		transports: make(map[string][]*http.Transport),
+	}
+}
+
+// Get returns the transport of the TLS configuration, tlsConfig may be nil. The transport must not be modified.
+// Configurations with callbacks can't be compared, they get a transport of their own.
+func (s *Shared) Get(tlsConfig *tls.Config) *http.Transport {
+	fingerprint, ok := tlsFingerprint(tlsConfig)
+	if !ok {
+		return s.newTransport(tlsConfig)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// The fingerprint does not cover the CAs, they are compared with the ones of the transports of the fingerprint
+	for _, t := range s.transports[fingerprint] {
+		if tlsConfig == nil || t.TLSClientConfig.RootCAs.Equal(tlsConfig.RootCAs) {
+			return t
+		}
+	}
+
+	t := s.newTransport(tlsConfig)
+	s.transports[fingerprint] = append(s.transports[fingerprint], t)
+	return t
+}
+
+// CloseIdleConnections closes the idle connections of all transports.
+func (s *Shared) CloseIdleConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, transports := range s.transports {
+		for _, t := range transports {
+			t.CloseIdleConnections()
+		}
+	}
+}
+
+func (s *Shared) newTransport(tlsConfig *tls.Config) *http.Transport {
+	return &http.Transport{
+		DisableCompression:  true,
+		DisableKeepAlives:   s.opts.DisableKeepAlives,
+		Proxy:               s.opts.Proxy,
+		TLSClientConfig:     tlsConfig,
+		ForceAttemptHTTP2:   true,
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: *s.opts.MaxIdleConnsPerHost,
+		IdleConnTimeout:     90 * time.Second,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
+
+// tlsFingerprint hashes the fields of the TLS configuration which affect a connection except for the CAs, it reports
+// false for configurations with callbacks.
+func tlsFingerprint(c *tls.Config) (string, bool) {
+	if c == nil {
+		return "", true
+	}
+
+	if c.GetClientCertificate != nil || c.VerifyPeerCertificate != nil || c.VerifyConnection != nil ||
+		c.GetCertificate != nil || c.GetConfigForClient != nil || c.Rand != nil || c.Time != nil {
+		return "", false
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%t\x00%d\x00%d\x00%v\x00%v\x00%q\x00%t\n", c.ServerName, c.InsecureSkipVerify,
+		c.MinVersion, c.MaxVersion, c.CipherSuites, c.CurvePreferences, c.NextProtos, c.RootCAs == nil)
+	for _, cert := range c.Certificates {
+		for _, der := range cert.Certificate {
+			h.Write(der)
+		}
+		h.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), true
+}
+
+// Acquire returns the transport of tlsConfig of s and a function which releases it after use. If s is nil a transport
+// of the TransportPool with tlsConfig and proxy is returned instead, the proxy of s takes precedence otherwise.
+func Acquire(s *Shared, tlsConfig *tls.Config, proxy ProxyFunc) (*http.Transport, func()) {
+	if s != nil {
+		return s.Get(tlsConfig), func() {}
+	}
+
+	t := NewOrIdle(tlsConfig, proxy)
+	return t, func() {
+		_ = Release(t)
+	}
+}
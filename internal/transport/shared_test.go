@@ -0,0 +1,145 @@
+package transport
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestSharedGet(t *testing.T) {
+	g := NewWithT(t)
+
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+
+	s := NewShared(SharedOpts{DisableKeepAlives: true})
+
+	g.Expect(s.Get(nil)).To(BeIdenticalTo(s.Get(nil)))
+	g.Expect(s.Get(nil).MaxIdleConnsPerHost).To(Equal(DefaultMaxIdleConnsPerHost))
+	g.Expect(s.Get(nil).DisableKeepAlives).To(BeTrue())
+
+	// Configurations are compared by value, the CAs of equal configurations are separate pools
+	ca := s.Get(trustingConfig(server))
+	g.Expect(ca).ToNot(BeIdenticalTo(s.Get(nil)))
+	g.Expect(s.Get(trustingConfig(server))).To(BeIdenticalTo(ca))
+	g.Expect(s.Get(&tls.Config{RootCAs: x509.NewCertPool()})).ToNot(BeIdenticalTo(ca))
+
+	serverName := trustingConfig(server)
+	serverName.ServerName = "charts.example.com"
+	g.Expect(s.Get(serverName)).ToNot(BeIdenticalTo(ca))
+
+	clientCert := trustingConfig(server)
+	clientCert.Certificates = server.TLS.Certificates
+	g.Expect(s.Get(clientCert)).ToNot(BeIdenticalTo(ca))
+	g.Expect(s.Get(clientCert)).To(BeIdenticalTo(s.Get(clientCert)))
+
+	callback := trustingConfig(server)
+	callback.VerifyConnection = func(tls.ConnectionState) error { return nil }
+	g.Expect(s.Get(callback)).ToNot(BeIdenticalTo(s.Get(callback)))
+}
+
+func TestSharedReusesConnections(t *testing.T) {
+	tests := []struct {
+		name              string
+		shared            bool
+		expectConnections int64
+	}{
+		{
+			name:              "transport per repository",
+			expectConnections: 5,
+		},
+		{
+			name:              "shared transports",
+			shared:            true,
+			expectConnections: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			server, connections := newCountingServer()
+			defer server.Close()
+
+			s := NewShared(SharedOpts{})
+			for i := 0; i < 5; i++ {
+				transport := s.newTransport(trustingConfig(server))
+				if tt.shared {
+					transport = s.Get(trustingConfig(server))
+				}
+
+				g.Expect(fetchIndex(transport, fmt.Sprintf("%s/repository-%d", server.URL, i))).To(Succeed())
+			}
+
+			g.Expect(connections.Load()).To(Equal(tt.expectConnections))
+		})
+	}
+}
+
+func BenchmarkSharedHandshakes(b *testing.B) {
+	for _, shared := range []bool{false, true} {
+		b.Run(fmt.Sprintf("shared=%t", shared), func(b *testing.B) {
+			server, connections := newCountingServer()
+			defer server.Close()
+
+			s := NewShared(SharedOpts{})
+			for n := 0; n < b.N; n++ {
+				for i := 0; i < 10; i++ {
+					transport := s.newTransport(trustingConfig(server))
+					if shared {
+						transport = s.Get(trustingConfig(server))
+					}
+
+					if err := fetchIndex(transport, fmt.Sprintf("%s/repository-%d", server.URL, i)); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+
+			b.ReportMetric(float64(connections.Load())/float64(b.N), "handshakes/op")
+		})
+	}
+}
+
+// newCountingServer returns a TLS server serving an empty index at every path and counts its connections, every
+// connection is a TLS handshake.
+func newCountingServer() (*httptest.Server, *atomic.Int64) {
+	var connections atomic.Int64
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("apiVersion: v1\nentries: {}\n"))
+	}))
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			connections.Add(1)
+		}
+	}
+	server.StartTLS()
+
+	return server, &connections
+}
+
+// trustingConfig returns a new TLS configuration trusting the certificate of the server.
+func trustingConfig(server *httptest.Server) *tls.Config {
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+	return &tls.Config{RootCAs: pool}
+}
+
+func fetchIndex(transport *http.Transport, url string) error {
+	res, err := (&http.Client{Transport: transport}).Get(url + "/index.yaml")
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	_, err = io.Copy(io.Discard, res.Body)
+	return err
+}
@@ -82,9 +82,14 @@ var pool = &sync.Pool{
 // If none is found, creates a new Transport instead.
 //
 // tlsConfig can optionally set the TLSClientConfig for the transport.
-func NewOrIdle(tlsConfig *tls.Config) *http.Transport {
+// proxy can optionally set the proxy for the transport, the proxy is configured
+// from the environment if nil.
+func NewOrIdle(tlsConfig *tls.Config, proxy ProxyFunc) *http.Transport {
 	t := pool.Get().(*http.Transport)
 	t.TLSClientConfig = tlsConfig
+	if proxy != nil {
+		t.Proxy = proxy
+	}
 
 	return t
 }
@@ -97,6 +102,7 @@ func Release(transport *http.Transport) error {
 	}
 
 	transport.TLSClientConfig = nil
+	transport.Proxy = http.ProxyFromEnvironment
 
 	pool.Put(transport)
 	return nil
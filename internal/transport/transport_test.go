@@ -18,12 +18,15 @@ package transport
 
 import (
 	"crypto/tls"
+	"net/http"
+	"net/url"
+	"reflect"
 	"testing"
 )
 
 func Test_TransportReuse(t *testing.T) {
-	t1 := NewOrIdle(nil)
-	t2 := NewOrIdle(nil)
+	t1 := NewOrIdle(nil, nil)
+	t2 := NewOrIdle(nil, nil)
 
 	if t1 == t2 {
 		t.Errorf("same transported returned twice")
@@ -36,7 +39,7 @@ func Test_TransportReuse(t *testing.T) {
 
 	t3 := NewOrIdle(&tls.Config{
 		ServerName: "testing",
-	})
+	}, NewProxyFunc(&url.URL{Scheme: "http", Host: "proxy:3128"}))
 	if t3.TLSClientConfig == nil || t3.TLSClientConfig.ServerName != "testing" {
 		t.Errorf("TLSClientConfig not properly configured")
 	}
@@ -48,6 +51,9 @@ func Test_TransportReuse(t *testing.T) {
 	if t3.TLSClientConfig != nil {
 		t.Errorf("TLSClientConfig not cleared after release")
 	}
+	if reflect.ValueOf(t3.Proxy).Pointer() != reflect.ValueOf(http.ProxyFromEnvironment).Pointer() {
+		t.Errorf("Proxy not reset after release")
+	}
 
 	err = Release(nil)
 	if err == nil {
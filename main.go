@@ -3,20 +3,29 @@ package main
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log"
+	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/doodlescheduling/flux-build/internal/action"
+	"github.com/doodlescheduling/flux-build/internal/build"
 	"github.com/doodlescheduling/flux-build/internal/cachemgr"
+	"github.com/doodlescheduling/flux-build/internal/helm/postrenderer"
+	"github.com/doodlescheduling/flux-build/internal/logging"
+	"github.com/doodlescheduling/flux-build/internal/transport"
 	"github.com/go-logr/logr"
-	"github.com/go-logr/zapr"
 	"github.com/sethvargo/go-envconfig"
 	flag "github.com/spf13/pflag"
-	"go.uber.org/zap"
 	"helm.sh/helm/v3/pkg/chartutil"
+	"k8s.io/apimachinery/pkg/labels"
 )
 
 type Config struct {
@@ -24,16 +33,86 @@ type Config struct {
 		Level    string `env:"LOG_LEVEL, default=info"`
 		Encoding string `env:"LOG_ENCODING, default=json"`
 	}
-	Output           string   `env:"OUTPUT, default=/dev/stdout"`
-	FailFast         bool     `env:"FAIL_FAST"`
-	IncludeHelmHooks bool     `env:"INCLUDE_HELM_HOOKS"`
-	AllowFailure     bool     `env:"ALLOW_FAILURE"`
-	Workers          int      `env:"WORKERS"`
-	APIVersions      []string `env:"API_VERSIONS"`
-	KubeVersion      string   `env:"KUBE_VERSION"`
-	CacheEnabled     bool     `env:"CACHE_ENABLED"`
-	CacheDir         string   `env:"CACHE_DIR"`
-	Cache            string   `env:"CACHE"`
+	Output             string            `env:"OUTPUT, default=/dev/stdout"`
+	FailFast           bool              `env:"FAIL_FAST"`
+	IncludeHelmHooks   bool              `env:"INCLUDE_HELM_HOOKS"`
+	HelmHookTypes      []string          `env:"HELM_HOOK_TYPES"`
+	HelmAction         string            `env:"HELM_ACTION"`
+	ChartVersions      map[string]string `env:"CHART_VERSIONS, separator=="`
+	TargetPathTypes    bool              `env:"TARGET_PATH_TYPES"`
+	Extensions         bool              `env:"EXTENSIONS"`
+	AllowFailure       bool              `env:"ALLOW_FAILURE"`
+	Workers            int               `env:"WORKERS"`
+	APIVersions        []string          `env:"API_VERSIONS"`
+	KubeVersion        string            `env:"KUBE_VERSION"`
+	CacheEnabled       bool              `env:"CACHE_ENABLED"`
+	CacheDir           string            `env:"CACHE_DIR"`
+	Cache              string            `env:"CACHE"`
+	InsecureRegistries []string          `env:"INSECURE_REGISTRIES"`
+	SkipTLSVerify      []string          `env:"SKIP_TLS_VERIFY"`
+	ClusterScopedKinds []string          `env:"CLUSTER_SCOPED_KINDS"`
+	APIResources       string            `env:"API_RESOURCES"`
+	ControllerCompat   string            `env:"CONTROLLER_COMPAT"`
+	KeepLists          bool              `env:"KEEP_LISTS"`
+	NoDefaultKeychain  bool              `env:"NO_DEFAULT_KEYCHAIN"`
+	CommonLabels       map[string]string `env:"COMMON_LABELS"`
+	CommonAnnotations  map[string]string `env:"COMMON_ANNOTATIONS"`
+	LogsOnFailureOnly  bool              `env:"LOGS_ON_FAILURE_ONLY"`
+	RekorURL           string            `env:"REKOR_URL"`
+	FixNameReferences  bool              `env:"FIX_NAME_REFERENCES"`
+	MaxDocumentSize    int               `env:"MAX_DOCUMENT_SIZE"`
+	MaxDocumentDepth   int               `env:"MAX_DOCUMENT_DEPTH"`
+	MaxEnvsubstGrowth  int               `env:"MAX_ENVSUBST_GROWTH"`
+	ProxyURL           string            `env:"PROXY_URL"`
+	IdleConnsPerHost   int               `env:"IDLE_CONNS_PER_HOST"`
+	DisableKeepAlives  bool              `env:"DISABLE_KEEP_ALIVES"`
+	RepositoryTimeout  time.Duration     `env:"REPOSITORY_TIMEOUT"`
+	RepositoryTimeouts map[string]string `env:"REPOSITORY_TIMEOUTS, separator=="`
+	RepositoryMirrors  []string          `env:"REPOSITORY_MIRRORS, delimiter=;"`
+	RetryMax           int               `env:"RETRY_MAX"`
+	RetryBackoff       time.Duration     `env:"RETRY_BACKOFF"`
+	Clusters           string            `env:"CLUSTERS"`
+	OutputDir          string            `env:"OUTPUT_DIR"`
+	RepositoryRoot     string            `env:"REPOSITORY_ROOT"`
+	SSAConflicts       bool              `env:"SSA_CONFLICTS"`
+	SSAConflictRules   string            `env:"SSA_CONFLICT_RULES"`
+	StrictObjectSize   bool              `env:"STRICT_OBJECT_SIZE"`
+	Report             string            `env:"REPORT"`
+	AuditSubstitutions bool              `env:"AUDIT_SUBSTITUTIONS"`
+	SkipSuspended      bool              `env:"SKIP_SUSPENDED"`
+	ReleaseSelector    string            `env:"RELEASE_SELECTOR"`
+	ExecPostRenderers  string            `env:"EXEC_POST_RENDERERS"`
+	KeepTempDirs       bool              `env:"KEEP_TEMP_DIRS"`
+	SchemaWarnings     bool              `env:"SCHEMA_WARNINGS"`
+	UnusedValuesAllow  []string          `env:"UNUSED_VALUES_ALLOW"`
+	DumpValuesDir      string            `env:"DUMP_VALUES_DIR"`
+	NotesDir           string            `env:"NOTES_DIR"`
+	ShowSecrets        bool              `env:"SHOW_SECRETS"`
+	ValueConflicts     bool              `env:"REPORT_VALUE_CONFLICTS"`
+	CheckDeterminism   bool              `env:"CHECK_DETERMINISM"`
+	FailOnEmpty        bool              `env:"FAIL_ON_EMPTY"`
+	WarningsAsErrors   bool              `env:"WARNINGS_AS_ERRORS"`
+	Timings            bool              `env:"TIMINGS"`
+	DrainTimeout       time.Duration     `env:"DRAIN_TIMEOUT"`
+	StrictEnv          bool              `env:"STRICT_ENV"`
+	NoEnvsubst         bool              `env:"NO_ENVSUBST"`
+	EnvDefaults        string            `env:"ENV_DEFAULTS"`
+	EnvAllowlist       []string          `env:"ENV_ALLOWLIST"`
+	SourceKinds        []string          `env:"REQUIRE_SOURCEREF_KINDS"`
+	SourceURLs         []string          `env:"REQUIRE_SOURCE_URLS"`
+	SourceExemptNS     []string          `env:"SOURCE_POLICY_EXEMPT_NAMESPACES"`
+	SourceExemptLabels string            `env:"SOURCE_POLICY_EXEMPT_SELECTOR"`
+	SourcePolicyText   string            `env:"SOURCE_POLICY_MESSAGE"`
+	OnDuplicate        string            `env:"ON_DUPLICATE"`
+	UnsupportedVerify  string            `env:"UNSUPPORTED_VERIFY"`
+	CreateNamespaces   bool              `env:"CREATE_NAMESPACES"`
+	PartOfLabel        string            `env:"PART_OF_LABEL"`
+	ValuesOverlays     []string          `env:"VALUES_OVERLAYS, delimiter=;"`
+	SetValues          []string          `env:"SET_VALUES, delimiter=;"`
+	SetStringValues    []string          `env:"SET_STRING_VALUES, delimiter=;"`
+	TLSMinVersion      string            `env:"TLS_MIN_VERSION"`
+	TLSCipherSuites    []string          `env:"TLS_CIPHER_SUITES"`
+	TLSRelaxedHosts    []string          `env:"TLS_RELAXED_HOSTS"`
 }
 
 var (
@@ -53,14 +132,84 @@ func init() {
 	flag.StringVarP(&config.Log.Level, "log-level", "l", "", "Define the log level (default is warning) [debug,info,warn,error]")
 	flag.StringVarP(&config.Log.Encoding, "log-encoding", "e", "", "Define the log format (default is json) [json,console]")
 	flag.StringVarP(&config.Output, "output", "o", "", "Path to output")
+	flag.StringVar(&config.Clusters, "clusters", "", "Glob pattern of cluster directories (for instance clusters/*), each cluster is built with the Flux Kustomizations reachable from it into <output-dir>/<cluster>.yaml")
+	flag.StringVar(&config.OutputDir, "output-dir", "", "Directory of the cluster outputs (required in combination with clusters)")
+	flag.StringVar(&config.RepositoryRoot, "repository-root", ".", "Directory of the built repository, the spec.path of Flux Kustomizations (in combination with clusters) and packaged charts (.tgz) of the flux-system GitRepository are relative to")
 	flag.BoolVar(&config.AllowFailure, "allow-failure", false, "Do not exit > 0 if an error occurred")
 	flag.BoolVar(&config.IncludeHelmHooks, "include-helm-hooks", false, "Include helm hooks in the output")
+	flag.StringSliceVarP(&config.HelmHookTypes, "helm-hook-types", "", nil, "Include only helm hooks with any of these events in the output, for instance pre-install,post-install (Comma separated)")
+	flag.StringVar(&config.HelmAction, "helm-action", "", "Render helm releases as a dry-run install or as a dry-run upgrade of an installed release using spec.upgrade (default is install) [install,upgrade]")
+	flag.StringToStringVar(&config.ChartVersions, "chart-versions", nil, "Chart versions overriding the version of helm releases keyed by namespace/name of the HelmRelease or by chart name (key=version comma separated)")
+	flag.BoolVar(&config.TargetPathTypes, "target-path-types", false, "Type the values set at valuesFrom targetPaths instead of setting them as strings and enable type markers (!str, !int, !bool) at the end of targetPaths, the markers are rejected by the HelmRelease CRD and only meant for builds")
+	flag.BoolVar(&config.Extensions, "extensions", false, "Enable extensions of flux-build to the Flux APIs which Flux itself does not support, for instance HelmRepositories of GitHub or GitLab release assets declared by the annotation flux-build.doodlescheduling.com/repository-type")
+	flag.BoolVar(&config.SkipSuspended, "skip-suspended", false, "Skip suspended HelmReleases and build charts of suspended HelmRepositories from the cache only")
+	flag.StringVar(&config.ReleaseSelector, "release-selector", "", "Label selector of the HelmReleases to build, all other HelmReleases are skipped")
+	flag.StringVar(&config.ExecPostRenderers, "exec-post-renderers", "", "Path to a YAML file with local commands the manifests of every helm release are piped through after its post renderers")
+	flag.StringArrayVar(&config.ValuesOverlays, "values-overlay", nil, "Values file merged on top of the values of the HelmReleases matching the selector, either namespace/name or a label selector (<selector>=<file>, repeatable, merged in order)")
+	flag.StringArrayVar(&config.SetValues, "set", nil, "Set values of a HelmRelease like helm --set with the highest precedence (<namespace>/<name>:<key>=<value>, repeatable)")
+	flag.StringArrayVar(&config.SetStringValues, "set-string", nil, "Set string values of a HelmRelease like helm --set-string with the highest precedence (<namespace>/<name>:<key>=<value>, repeatable)")
+	flag.BoolVar(&config.CreateNamespaces, "create-namespaces", false, "Add a Namespace to the output for HelmReleases with spec.install.createNamespace unless the namespace is declared by any resource")
+	flag.StringVar(&config.PartOfLabel, "part-of-label", "", "Label set to the name of the owning Flux Kustomization on all resources of the output which don't define it (for instance app.kubernetes.io/part-of)")
+	flag.BoolVar(&config.KeepTempDirs, "keep-temp-dirs", false, "Render helm releases into temporary directories on disk instead of memory, keep them and log their paths at log level debug, for instance to debug the kustomize step")
+	flag.BoolVar(&config.SchemaWarnings, "schema-warnings", false, "Log values of helm releases which violate the values.schema.json of the chart instead of failing the release")
+	flag.StringSliceVarP(&config.UnusedValuesAllow, "unused-values-allow", "", nil, "Paths of helm release values which are never reported as not consumed by the chart, for instance extraEnv or *.podLabels (Comma separated)")
+	flag.StringVar(&config.DumpValuesDir, "dump-values-dir", "", "Write the merged values of every helm release to <namespace>_<name>.yaml within this directory, annotated with the sources of the top-level keys. The output is not affected")
+	flag.StringVar(&config.NotesDir, "notes-dir", "", "Write the rendered NOTES.txt of every helm release to <namespace>_<name>.txt within this directory. The output is not affected")
+	flag.BoolVar(&config.ShowSecrets, "show-secrets", false, "Write values from secrets to --dump-values-dir instead of redacting them")
+	flag.BoolVar(&config.ValueConflicts, "report-value-conflicts", false, "List the values keys of every helm release which a later valuesFrom entry, spec.values, values overlay or --set overwrites in the report (--report). The merged values are not affected")
+	flag.DurationVar(&config.DrainTimeout, "drain-timeout", 10*time.Second, "How long in-flight helm releases may finish after SIGTERM or SIGINT before they are canceled, the completed releases are written and the build exits with code 3. A second signal cancels them immediately")
+	flag.BoolVar(&config.StrictEnv, "strict-env", false, "Fail helm releases referencing environment variables which are unset and have no default instead of substituting an empty string")
+	flag.BoolVar(&config.NoEnvsubst, "no-envsubst", false, "Do not substitute environment variables in helm releases, single resources are excluded by the annotation flux-build.doodlescheduling.com/envsubst: disabled")
+	flag.StringVar(&config.EnvDefaults, "env-defaults", "", "Env file (KEY=VALUE lines) with defaults for environment variables of helm releases which are not set, they take precedence over inline defaults like ${VAR:=x}")
+	flag.StringSliceVarP(&config.EnvAllowlist, "env-allowlist", "", nil, "Only substitute these environment variables in helm releases, a trailing * matches a prefix like FLUX_*. Other variables are kept as they are or fail helm releases with --strict-env (Comma separated)")
+	flag.StringSliceVarP(&config.SourceKinds, "require-sourceref-kinds", "", nil, "Fail helm releases whose chart source is not of any of these kinds, for instance OCIRepository,HelmRepository (Comma separated) [HelmRepository,GitRepository,Bucket,OCIRepository]")
+	flag.StringSliceVarP(&config.SourceURLs, "require-source-urls", "", nil, "Fail helm releases whose chart source URL does not match any of these anchored regular expressions, for instance oci://ghcr\\.io/org/.* (Comma separated)")
+	flag.StringSliceVarP(&config.SourceExemptNS, "source-policy-exempt-namespaces", "", nil, "Namespaces whose helm releases are exempt from the source policy (Comma separated)")
+	flag.StringVar(&config.SourceExemptLabels, "source-policy-exempt-selector", "", "Label selector of helm releases which are exempt from the source policy")
+	flag.StringVar(&config.SourcePolicyText, "source-policy-message", "", "Policy text violating helm releases fail with (default lists the allowed kinds and URLs)")
+	flag.StringVar(&config.UnsupportedVerify, "unsupported-verify", "", "How charts with a spec.verify flux-build can't check (unknown providers, charts of a GitRepository or Bucket) are handled, warn builds them unverified (default is error) [error,warn]")
+	flag.StringVar(&config.OnDuplicate, "on-duplicate", "", "How resources declared with different content by more than one path are handled, the last path wins unless it is error (default is warn) [error,warn,last-wins]")
+	flag.BoolVar(&config.FailOnEmpty, "fail-on-empty", false, "Fail helm releases whose chart renders no resources (hooks don't count), for instance as their values disable all templates")
+	flag.BoolVar(&config.WarningsAsErrors, "warnings-as-errors", false, "Fail helm releases for which helm warns while rendering, for instance as their values overwrite a table of the chart values with a scalar")
+	flag.BoolVar(&config.Timings, "timings", false, "Write the durations of resolving the chart, composing the values, rendering and kustomizing every helm release as a table sorted by the slowest release to stderr once the build finished")
+	flag.BoolVar(&config.CheckDeterminism, "check-determinism", false, "Render every helm release twice and fail releases whose renders differ, reporting a diff excerpt and the template functions likely responsible")
+	flag.BoolVar(&config.KeepLists, "keep-lists", false, "Do not flatten List objects rendered by helm charts into their items")
+	flag.BoolVar(&config.LogsOnFailureOnly, "logs-on-failure-only", false, "Only output the logs of a helm release if it failed to build")
+	flag.BoolVar(&config.FixNameReferences, "fix-name-references", false, "Rewrite references to hash-suffixed ConfigMaps and Secrets generated by kustomize across all paths and helm releases")
+	flag.BoolVar(&config.SSAConflicts, "ssa-conflicts", false, "Log resources which set fields commonly managed by other controllers and are prone to server-side apply conflicts")
+	flag.StringVar(&config.SSAConflictRules, "ssa-conflict-rules", "", "Path to a YAML file with additional server-side apply conflict rules (only used in combination with ssa-conflicts)")
+	flag.BoolVar(&config.StrictObjectSize, "strict-object-size", false, "Fail if any resource exceeds the kubernetes object size limits (1MiB object, 256KiB annotations) instead of logging a warning")
+	flag.StringVar(&config.Report, "report", "", "Path to write a JSON build report to")
+	flag.BoolVar(&config.AuditSubstitutions, "audit-substitutions", false, "Record every substituted variable with its source and the resource it was substituted in into the report (secrets are redacted)")
+	flag.IntVar(&config.MaxDocumentSize, "max-document-size", build.DefaultDocumentLimits.MaxSize, "Maximum size in bytes of manifests, stdin, HelmRelease values and rendered charts (0 disables the limit)")
+	flag.IntVar(&config.MaxDocumentDepth, "max-document-depth", build.DefaultDocumentLimits.MaxDepth, "Maximum nesting depth of manifests, stdin, HelmRelease values and rendered charts (0 disables the limit)")
+	flag.IntVar(&config.MaxEnvsubstGrowth, "max-envsubst-growth", build.DefaultDocumentLimits.MaxSubstitutionGrowth, "Maximum number of bytes the environment substitution may add to a HelmRelease (0 disables the limit)")
 	flag.BoolVar(&config.FailFast, "fail-fast", false, "Exit early if an error occurred")
 	flag.IntVar(&config.Workers, "workers", runtime.NumCPU(), "Workers used to parse manifests")
 	flag.StringVarP(&config.KubeVersion, "kube-version", "", "", "Kubernetes version (Some helm charts validate manifests against a specific kubernetes version)")
 	flag.StringSliceVarP(&config.APIVersions, "api-versions", "", nil, "Kubernetes api versions used for Capabilities.APIVersions (Comma separated)")
 	flag.StringVar(&config.Cache, "cache", "inmemory", "Which Helm cache to use, one of none, inmemory, fs")
 	flag.StringVar(&config.CacheDir, "cache-dir", getDefaultCacheDir(), "Path to helm chart cache (only used in combination with cache=fs)")
+	flag.BoolVar(&config.NoDefaultKeychain, "no-default-keychain", false, "Do not fall back to the docker config credentials for OCI repositories without secretRef and provider")
+	flag.StringVar(&config.RekorURL, "rekor-url", "", "Rekor transparency log used for keyless cosign verification of charts (default is the public Rekor instance)")
+	flag.DurationVar(&config.RepositoryTimeout, "repository-timeout", build.DefaultRepositoryTimeout, "Timeout for logging in, fetching the index and pulling a chart from a helm repository (0 disables the timeout)")
+	flag.StringToStringVar(&config.RepositoryTimeouts, "repository-timeouts", nil, "Timeouts of single helm repositories keyed by URL or namespace/name of the HelmRepository (url=duration comma separated)")
+	flag.StringArrayVar(&config.RepositoryMirrors, "repository-mirror", nil, "Mirror of a helm repository whose charts are pulled from it if pulling from the repository fails, mirrors are tried in order (<url>=<mirror url>, repeatable)")
+	flag.IntVar(&config.RetryMax, "retry-max", build.DefaultRetryMax, "Retries of chart pulls and registry logins which failed with a transient error like a network error, 5xx or 429 response (0 disables retries)")
+	flag.DurationVar(&config.RetryBackoff, "retry-backoff", build.DefaultRetryBackoff, "Initial backoff between retries, it doubles with every retry and is jittered")
+	flag.StringVar(&config.ProxyURL, "proxy-url", "", "Proxy used to pull charts and OCI artifacts, hosts in NO_PROXY are accessed directly (default is HTTPS_PROXY/HTTP_PROXY from the environment)")
+	flag.IntVar(&config.IdleConnsPerHost, "idle-conns-per-host", transport.DefaultMaxIdleConnsPerHost, "Idle connections kept per host for chart repositories and OCI registries, connections are shared by all repositories of a host with the same TLS settings")
+	flag.BoolVar(&config.DisableKeepAlives, "disable-keep-alives", false, "Use every connection to chart repositories and OCI registries for a single request only")
+	flag.StringVar(&config.TLSMinVersion, "tls-min-version", "", "Minimum TLS version of the connections to helm repositories, OCI registries and OCI artifacts (default is the Go default) [1.0,1.1,1.2,1.3]")
+	flag.StringSliceVarP(&config.TLSCipherSuites, "tls-cipher-suites", "", nil, "TLS cipher suites allowed for TLS 1.2 and below, for instance TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 (Comma separated)")
+	flag.StringSliceVarP(&config.TLSRelaxedHosts, "tls-relaxed-hosts", "", nil, "Repository hosts (host:port) whose HelmRepositories and OCIRepositories may lower the minimum TLS version by annotation (Comma separated)")
+	flag.StringSliceVarP(&config.InsecureRegistries, "insecure-registries", "", nil, "OCI registry hosts (host:port) which are accessed via plain HTTP (Comma separated)")
+	flag.StringSliceVarP(&config.SkipTLSVerify, "skip-tls-verify", "", nil, "OCI registry hosts (host:port) whose TLS certificate is not verified, for development and tests only (Comma separated)")
+	flag.StringVar(&config.ControllerCompat, "controller-compat", "", "Match the behaviour of a specific helm-controller minor version (for instance 0.37 or 1.0), defaults to the latest supported version")
+	flag.StringToStringVar(&config.CommonLabels, "common-labels", nil, "Labels added to all resources rendered from helm releases unless already set (key=value, comma separated), {namespace} and {name} in values are replaced by the helm release")
+	flag.StringToStringVar(&config.CommonAnnotations, "common-annotations", nil, "Annotations added to all resources rendered from helm releases unless already set (key=value, comma separated), {namespace} and {name} in values are replaced by the helm release")
+	flag.StringSliceVarP(&config.ClusterScopedKinds, "cluster-scoped-kinds", "", nil, "Additional cluster-scoped kinds which never get a namespace assigned by the HelmRelease post renderer (Comma separated)")
+	flag.StringVar(&config.APIResources, "api-resources", "", "Path to the output of kubectl api-resources or a YAML list of apiVersion, kind and namespaced which declares the scope of kinds whose CRDs are not part of the build")
 }
 
 func must(err error) {
@@ -70,7 +219,14 @@ func must(err error) {
 }
 
 func main() {
-	ctx := context.Background()
+	if len(os.Args) > 1 && os.Args[1] == "doctor" {
+		must(doctor(os.Args[2:]))
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
 	if err := envconfig.Process(ctx, config); err != nil {
 		log.Fatal(err)
 	}
@@ -94,11 +250,15 @@ func main() {
 	if len(paths) == 0 {
 		if os.Getenv("PATHS") != "" {
 			paths = strings.Split(os.Getenv("PATHS"), ",")
-		} else {
-			must(errors.New("path to kustomize overlay required"))
+		} else if config.Clusters == "" {
+			must(errors.New("path to kustomize overlay required, use - to read from stdin"))
 		}
 	}
 
+	if config.Clusters != "" && config.OutputDir == "" {
+		must(errors.New("output-dir is required in combination with clusters"))
+	}
+
 	if config.KubeVersion != "" {
 		v, err := chartutil.ParseKubeVersion(config.KubeVersion)
 		if err != nil {
@@ -108,6 +268,30 @@ func main() {
 		kubeVersion = v
 	}
 
+	controllerCompat, err := build.ControllerCompatFor(config.ControllerCompat)
+	must(err)
+
+	helmHookTypes, err := build.ParseHookEvents(config.HelmHookTypes)
+	must(err)
+
+	helmAction, err := build.ParseReleaseAction(config.HelmAction)
+	must(err)
+
+	onDuplicate, err := build.ParseDuplicatePolicy(config.OnDuplicate)
+	must(err)
+
+	unsupportedVerify, err := build.ParseUnsupportedVerifyPolicy(config.UnsupportedVerify)
+	must(err)
+
+	var envDefaults map[string]string
+	if config.EnvDefaults != "" {
+		envDefaults, err = build.LoadEnvDefaults(config.EnvDefaults)
+		must(err)
+	}
+
+	envAllowlist, err := build.ParseEnvAllowlist(config.EnvAllowlist)
+	must(err)
+
 	cache, err := cachemgr.New(config.Cache, config.CacheDir)
 	if err != nil {
 		must(err)
@@ -116,35 +300,207 @@ func main() {
 	out, err := os.OpenFile(config.Output, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0775)
 	must(err)
 
+	var proxyURL *url.URL
+	if config.ProxyURL != "" {
+		proxyURL, err = url.Parse(config.ProxyURL)
+		must(err)
+	}
+
+	repositoryTimeouts := make(map[string]time.Duration, len(config.RepositoryTimeouts))
+	for repository, timeout := range config.RepositoryTimeouts {
+		d, err := time.ParseDuration(timeout)
+		if err != nil {
+			must(fmt.Errorf("invalid timeout for repository %s: %w", repository, err))
+		}
+
+		repositoryTimeouts[repository] = d
+	}
+
+	repositoryMirrors := make(map[string][]string)
+	for _, mirror := range config.RepositoryMirrors {
+		repository, mirrorURL, ok := strings.Cut(mirror, "=")
+		if !ok || repository == "" || mirrorURL == "" {
+			must(fmt.Errorf("invalid repository mirror `%s`, expected <url>=<mirror url>", mirror))
+		}
+
+		repositoryMirrors[repository] = append(repositoryMirrors[repository], mirrorURL)
+	}
+
+	var conflictRules []build.ConflictRule
+	if config.SSAConflicts {
+		conflictRules = build.DefaultConflictRules
+		if config.SSAConflictRules != "" {
+			rules, err := build.LoadConflictRules(config.SSAConflictRules)
+			must(err)
+			conflictRules = append(slices.Clone(conflictRules), rules...)
+		}
+	}
+
+	var execPostRenderers []postrenderer.Exec
+	if config.ExecPostRenderers != "" {
+		execPostRenderers, err = build.LoadExecPostRenderers(config.ExecPostRenderers)
+		must(err)
+	}
+
+	var apiResources []postrenderer.APIResource
+	if config.APIResources != "" {
+		apiResources, err = build.LoadAPIResources(config.APIResources)
+		must(err)
+	}
+
+	var valuesOverlays []build.ValuesOverlay
+	for _, overlay := range config.ValuesOverlays {
+		o, err := build.LoadValuesOverlay(overlay)
+		must(err)
+		valuesOverlays = append(valuesOverlays, o)
+	}
+
+	var valuesOverrides []build.ValuesOverride
+	for _, set := range []struct {
+		overrides []string
+		asString  bool
+	}{{config.SetValues, false}, {config.SetStringValues, true}} {
+		for _, override := range set.overrides {
+			o, err := build.ParseValuesOverride(override, set.asString)
+			must(err)
+			valuesOverrides = append(valuesOverrides, o)
+		}
+	}
+
+	var tlsPolicy *build.TLSPolicy
+	if config.TLSMinVersion != "" || len(config.TLSCipherSuites) > 0 {
+		tlsPolicy = &build.TLSPolicy{
+			RelaxedHosts: config.TLSRelaxedHosts,
+		}
+
+		if config.TLSMinVersion != "" {
+			tlsPolicy.MinVersion, err = build.ParseTLSVersion(config.TLSMinVersion)
+			must(err)
+		}
+
+		tlsPolicy.CipherSuites, err = build.ParseCipherSuites(config.TLSCipherSuites)
+		must(err)
+	}
+
+	var sourcePolicy *build.SourcePolicy
+	if len(config.SourceKinds) > 0 || len(config.SourceURLs) > 0 {
+		sourcePolicy, err = build.NewSourcePolicy(config.SourceKinds, config.SourceURLs, config.SourceExemptNS, config.SourceExemptLabels, config.SourcePolicyText)
+		must(err)
+	}
+
+	var releaseSelector labels.Selector
+	if config.ReleaseSelector != "" {
+		releaseSelector, err = labels.Parse(config.ReleaseSelector)
+		if err != nil {
+			must(fmt.Errorf("invalid release selector: %w", err))
+		}
+	}
+
 	a := action.Action{
-		AllowFailure:     config.AllowFailure,
-		FailFast:         config.FailFast,
-		Workers:          config.Workers,
-		APIVersions:      config.APIVersions,
-		Paths:            paths,
-		KubeVersion:      kubeVersion,
-		Output:           out,
-		IncludeHelmHooks: config.IncludeHelmHooks,
-		Logger:           logger,
-		Cache:            cache,
+		AllowFailure:       config.AllowFailure,
+		FailFast:           config.FailFast,
+		Workers:            config.Workers,
+		APIVersions:        config.APIVersions,
+		Paths:              paths,
+		KubeVersion:        kubeVersion,
+		Output:             action.WriterSink(out),
+		IncludeHelmHooks:   config.IncludeHelmHooks,
+		HelmHookTypes:      helmHookTypes,
+		HelmAction:         helmAction,
+		ChartVersions:      config.ChartVersions,
+		TargetPathTypes:    config.TargetPathTypes,
+		Extensions:         config.Extensions,
+		Logger:             logger,
+		Cache:              cache,
+		InsecureRegistries: config.InsecureRegistries,
+		SkipTLSVerify:      config.SkipTLSVerify,
+		ClusterScopedKinds: config.ClusterScopedKinds,
+		APIResources:       apiResources,
+		ControllerCompat:   &controllerCompat,
+		KeepLists:          config.KeepLists,
+		NoDefaultKeychain:  config.NoDefaultKeychain,
+		CommonLabels:       config.CommonLabels,
+		CommonAnnotations:  config.CommonAnnotations,
+		LogsOnFailureOnly:  config.LogsOnFailureOnly,
+		RekorURL:           config.RekorURL,
+		FixNameReferences:  config.FixNameReferences,
+		Proxy:              proxyURL,
+		IdleConnsPerHost:   config.IdleConnsPerHost,
+		DisableKeepAlives:  config.DisableKeepAlives,
+		RepositoryTimeout:  config.RepositoryTimeout,
+		RepositoryTimeouts: repositoryTimeouts,
+		RepositoryMirrors:  repositoryMirrors,
+		RetryMax:           config.RetryMax,
+		RetryBackoff:       config.RetryBackoff,
+		ConflictRules:      conflictRules,
+		Clusters:           config.Clusters,
+		OutputDir:          config.OutputDir,
+		RepositoryRoot:     config.RepositoryRoot,
+		ObjectSizeLimits:   build.DefaultObjectSizeLimits,
+		StrictObjectSize:   config.StrictObjectSize,
+		Report:             config.Report,
+		AuditSubstitutions: config.AuditSubstitutions,
+		SkipSuspended:      config.SkipSuspended,
+		ReleaseSelector:    releaseSelector,
+		ExecPostRenderers:  execPostRenderers,
+		KeepTempDirs:       config.KeepTempDirs,
+		SchemaWarnings:     config.SchemaWarnings,
+		UnusedValuesAllow:  config.UnusedValuesAllow,
+		DumpValuesDir:      config.DumpValuesDir,
+		NotesDir:           config.NotesDir,
+		ShowSecrets:        config.ShowSecrets,
+		ValueConflicts:     config.ValueConflicts,
+		CheckDeterminism:   config.CheckDeterminism,
+		FailOnEmpty:        config.FailOnEmpty,
+		WarningsAsErrors:   config.WarningsAsErrors,
+		Timings:            config.Timings,
+		StrictEnv:          config.StrictEnv,
+		NoEnvsubst:         config.NoEnvsubst,
+		EnvDefaults:        envDefaults,
+		EnvAllowlist:       envAllowlist,
+		SourcePolicy:       sourcePolicy,
+		OnDuplicate:        onDuplicate,
+		UnsupportedVerify:  unsupportedVerify,
+		CreateNamespaces:   config.CreateNamespaces,
+		PartOfLabel:        config.PartOfLabel,
+		ValuesOverlays:     valuesOverlays,
+		ValuesOverrides:    valuesOverrides,
+		TLSPolicy:          tlsPolicy,
+		Interrupt:          interruptOnSignal(logger, cancel),
+		DrainTimeout:       config.DrainTimeout,
+		DocumentLimits: build.DocumentLimits{
+			MaxSize:               config.MaxDocumentSize,
+			MaxDepth:              config.MaxDocumentDepth,
+			MaxSubstitutionGrowth: config.MaxEnvsubstGrowth,
+		},
 	}
 
 	must(a.Run(ctx))
 }
 
-func buildLogger() (logr.Logger, error) {
-	logOpts := zap.NewDevelopmentConfig()
-	logOpts.Encoding = config.Log.Encoding
+// interruptOnSignal closes the returned channel on the first SIGTERM or SIGINT to drain the build,
+// a second signal cancels the build immediately.
+func interruptOnSignal(logger logr.Logger, cancel context.CancelFunc) <-chan struct{} {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
 
-	err := logOpts.Level.UnmarshalText([]byte(config.Log.Level))
-	if err != nil {
-		return logr.Discard(), err
-	}
+	interrupt := make(chan struct{})
+	go func() {
+		sig := <-signals
+		logger.Info("received signal, stop scheduling helm releases", "signal", sig.String())
+		close(interrupt)
 
-	zapLog, err := logOpts.Build()
-	if err != nil {
-		return logr.Discard(), err
-	}
+		sig = <-signals
+		logger.Info("received second signal, cancel the build", "signal", sig.String())
+		cancel()
+	}()
+
+	return interrupt
+}
 
-	return zapr.NewLogger(zapLog), nil
+func buildLogger() (logr.Logger, error) {
+	return logging.New(os.Stderr, logging.Options{
+		Level:    config.Log.Level,
+		Encoding: config.Log.Encoding,
+	})
 }